@@ -39,11 +39,20 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	ZooKeeper_Create_FullMethodName      = "/zk.ZooKeeper/Create"
-	ZooKeeper_Get_FullMethodName         = "/zk.ZooKeeper/Get"
-	ZooKeeper_Set_FullMethodName         = "/zk.ZooKeeper/Set"
-	ZooKeeper_Delete_FullMethodName      = "/zk.ZooKeeper/Delete"
-	ZooKeeper_GetChildren_FullMethodName = "/zk.ZooKeeper/GetChildren"
+	ZooKeeper_Create_FullMethodName        = "/zk.ZooKeeper/Create"
+	ZooKeeper_Get_FullMethodName           = "/zk.ZooKeeper/Get"
+	ZooKeeper_Set_FullMethodName           = "/zk.ZooKeeper/Set"
+	ZooKeeper_Delete_FullMethodName        = "/zk.ZooKeeper/Delete"
+	ZooKeeper_GetChildren_FullMethodName   = "/zk.ZooKeeper/GetChildren"
+	ZooKeeper_Multi_FullMethodName         = "/zk.ZooKeeper/Multi"
+	ZooKeeper_Sync_FullMethodName          = "/zk.ZooKeeper/Sync"
+	ZooKeeper_CreateSession_FullMethodName = "/zk.ZooKeeper/CreateSession"
+	ZooKeeper_KeepAlive_FullMethodName     = "/zk.ZooKeeper/KeepAlive"
+	ZooKeeper_CloseSession_FullMethodName  = "/zk.ZooKeeper/CloseSession"
+	ZooKeeper_Watch_FullMethodName         = "/zk.ZooKeeper/Watch"
+	ZooKeeper_GetACL_FullMethodName        = "/zk.ZooKeeper/GetACL"
+	ZooKeeper_SetACL_FullMethodName        = "/zk.ZooKeeper/SetACL"
+	ZooKeeper_Authenticate_FullMethodName  = "/zk.ZooKeeper/Authenticate"
 )
 
 // ZooKeeperClient is the client API for ZooKeeper service.
@@ -55,6 +64,30 @@ type ZooKeeperClient interface {
 	Set(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*SetResponse, error)
 	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
 	GetChildren(ctx context.Context, in *GetChildrenRequest, opts ...grpc.CallOption) (*GetChildrenResponse, error)
+	// Multi applies several ops atomically: all of them, or none.
+	Multi(ctx context.Context, in *MultiRequest, opts ...grpc.CallOption) (*MultiResponse, error)
+	// Sync brings this server up to date: when it returns, the server has
+	// applied every write the leader had committed when Sync was called.
+	// LOCAL reads after it see at least that much.
+	Sync(ctx context.Context, in *SyncRequest, opts ...grpc.CallOption) (*SyncResponse, error)
+	// Sessions. A client opens one, keeps it alive with KeepAlive well
+	// within its timeout, and closes it when done. Ephemeral znodes are
+	// deleted when their session is closed or expires.
+	CreateSession(ctx context.Context, in *CreateSessionRequest, opts ...grpc.CallOption) (*CreateSessionResponse, error)
+	KeepAlive(ctx context.Context, in *KeepAliveRequest, opts ...grpc.CallOption) (*KeepAliveResponse, error)
+	CloseSession(ctx context.Context, in *CloseSessionRequest, opts ...grpc.CallOption) (*CloseSessionResponse, error)
+	// Watch streams changes to a path as they're applied. The first
+	// message is REGISTERED; every change after its zxid follows. A
+	// one-shot watch ends the stream after its first event.
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (ZooKeeper_WatchClient, error)
+	// ACLs. Reading one needs READ or ADMIN on the node, setting one
+	// needs ADMIN. See docs/05-grpc-server.md for who may do what.
+	GetACL(ctx context.Context, in *GetACLRequest, opts ...grpc.CallOption) (*GetACLResponse, error)
+	SetACL(ctx context.Context, in *SetACLRequest, opts ...grpc.CallOption) (*SetACLResponse, error)
+	// Authenticate checks credentials and returns every identity the
+	// server sees for the caller with them. It doesn't log in: digest
+	// credentials count only on calls that carry them (see AuthenticateRequest).
+	Authenticate(ctx context.Context, in *AuthenticateRequest, opts ...grpc.CallOption) (*AuthenticateResponse, error)
 }
 
 type zooKeeperClient struct {
@@ -110,6 +143,110 @@ func (c *zooKeeperClient) GetChildren(ctx context.Context, in *GetChildrenReques
 	return out, nil
 }
 
+func (c *zooKeeperClient) Multi(ctx context.Context, in *MultiRequest, opts ...grpc.CallOption) (*MultiResponse, error) {
+	out := new(MultiResponse)
+	err := c.cc.Invoke(ctx, ZooKeeper_Multi_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *zooKeeperClient) Sync(ctx context.Context, in *SyncRequest, opts ...grpc.CallOption) (*SyncResponse, error) {
+	out := new(SyncResponse)
+	err := c.cc.Invoke(ctx, ZooKeeper_Sync_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *zooKeeperClient) CreateSession(ctx context.Context, in *CreateSessionRequest, opts ...grpc.CallOption) (*CreateSessionResponse, error) {
+	out := new(CreateSessionResponse)
+	err := c.cc.Invoke(ctx, ZooKeeper_CreateSession_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *zooKeeperClient) KeepAlive(ctx context.Context, in *KeepAliveRequest, opts ...grpc.CallOption) (*KeepAliveResponse, error) {
+	out := new(KeepAliveResponse)
+	err := c.cc.Invoke(ctx, ZooKeeper_KeepAlive_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *zooKeeperClient) CloseSession(ctx context.Context, in *CloseSessionRequest, opts ...grpc.CallOption) (*CloseSessionResponse, error) {
+	out := new(CloseSessionResponse)
+	err := c.cc.Invoke(ctx, ZooKeeper_CloseSession_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *zooKeeperClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (ZooKeeper_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ZooKeeper_ServiceDesc.Streams[0], ZooKeeper_Watch_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &zooKeeperWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ZooKeeper_WatchClient interface {
+	Recv() (*WatchEvent, error)
+	grpc.ClientStream
+}
+
+type zooKeeperWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *zooKeeperWatchClient) Recv() (*WatchEvent, error) {
+	m := new(WatchEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *zooKeeperClient) GetACL(ctx context.Context, in *GetACLRequest, opts ...grpc.CallOption) (*GetACLResponse, error) {
+	out := new(GetACLResponse)
+	err := c.cc.Invoke(ctx, ZooKeeper_GetACL_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *zooKeeperClient) SetACL(ctx context.Context, in *SetACLRequest, opts ...grpc.CallOption) (*SetACLResponse, error) {
+	out := new(SetACLResponse)
+	err := c.cc.Invoke(ctx, ZooKeeper_SetACL_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *zooKeeperClient) Authenticate(ctx context.Context, in *AuthenticateRequest, opts ...grpc.CallOption) (*AuthenticateResponse, error) {
+	out := new(AuthenticateResponse)
+	err := c.cc.Invoke(ctx, ZooKeeper_Authenticate_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ZooKeeperServer is the server API for ZooKeeper service.
 // All implementations must embed UnimplementedZooKeeperServer
 // for forward compatibility
@@ -119,6 +256,30 @@ type ZooKeeperServer interface {
 	Set(context.Context, *SetRequest) (*SetResponse, error)
 	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
 	GetChildren(context.Context, *GetChildrenRequest) (*GetChildrenResponse, error)
+	// Multi applies several ops atomically: all of them, or none.
+	Multi(context.Context, *MultiRequest) (*MultiResponse, error)
+	// Sync brings this server up to date: when it returns, the server has
+	// applied every write the leader had committed when Sync was called.
+	// LOCAL reads after it see at least that much.
+	Sync(context.Context, *SyncRequest) (*SyncResponse, error)
+	// Sessions. A client opens one, keeps it alive with KeepAlive well
+	// within its timeout, and closes it when done. Ephemeral znodes are
+	// deleted when their session is closed or expires.
+	CreateSession(context.Context, *CreateSessionRequest) (*CreateSessionResponse, error)
+	KeepAlive(context.Context, *KeepAliveRequest) (*KeepAliveResponse, error)
+	CloseSession(context.Context, *CloseSessionRequest) (*CloseSessionResponse, error)
+	// Watch streams changes to a path as they're applied. The first
+	// message is REGISTERED; every change after its zxid follows. A
+	// one-shot watch ends the stream after its first event.
+	Watch(*WatchRequest, ZooKeeper_WatchServer) error
+	// ACLs. Reading one needs READ or ADMIN on the node, setting one
+	// needs ADMIN. See docs/05-grpc-server.md for who may do what.
+	GetACL(context.Context, *GetACLRequest) (*GetACLResponse, error)
+	SetACL(context.Context, *SetACLRequest) (*SetACLResponse, error)
+	// Authenticate checks credentials and returns every identity the
+	// server sees for the caller with them. It doesn't log in: digest
+	// credentials count only on calls that carry them (see AuthenticateRequest).
+	Authenticate(context.Context, *AuthenticateRequest) (*AuthenticateResponse, error)
 	mustEmbedUnimplementedZooKeeperServer()
 }
 
@@ -141,6 +302,33 @@ func (UnimplementedZooKeeperServer) Delete(context.Context, *DeleteRequest) (*De
 func (UnimplementedZooKeeperServer) GetChildren(context.Context, *GetChildrenRequest) (*GetChildrenResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetChildren not implemented")
 }
+func (UnimplementedZooKeeperServer) Multi(context.Context, *MultiRequest) (*MultiResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Multi not implemented")
+}
+func (UnimplementedZooKeeperServer) Sync(context.Context, *SyncRequest) (*SyncResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Sync not implemented")
+}
+func (UnimplementedZooKeeperServer) CreateSession(context.Context, *CreateSessionRequest) (*CreateSessionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateSession not implemented")
+}
+func (UnimplementedZooKeeperServer) KeepAlive(context.Context, *KeepAliveRequest) (*KeepAliveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KeepAlive not implemented")
+}
+func (UnimplementedZooKeeperServer) CloseSession(context.Context, *CloseSessionRequest) (*CloseSessionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CloseSession not implemented")
+}
+func (UnimplementedZooKeeperServer) Watch(*WatchRequest, ZooKeeper_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedZooKeeperServer) GetACL(context.Context, *GetACLRequest) (*GetACLResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetACL not implemented")
+}
+func (UnimplementedZooKeeperServer) SetACL(context.Context, *SetACLRequest) (*SetACLResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetACL not implemented")
+}
+func (UnimplementedZooKeeperServer) Authenticate(context.Context, *AuthenticateRequest) (*AuthenticateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Authenticate not implemented")
+}
 func (UnimplementedZooKeeperServer) mustEmbedUnimplementedZooKeeperServer() {}
 
 // UnsafeZooKeeperServer may be embedded to opt out of forward compatibility for this service.
@@ -244,6 +432,171 @@ func _ZooKeeper_GetChildren_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ZooKeeper_Multi_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MultiRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZooKeeperServer).Multi(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ZooKeeper_Multi_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZooKeeperServer).Multi(ctx, req.(*MultiRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ZooKeeper_Sync_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SyncRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZooKeeperServer).Sync(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ZooKeeper_Sync_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZooKeeperServer).Sync(ctx, req.(*SyncRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ZooKeeper_CreateSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZooKeeperServer).CreateSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ZooKeeper_CreateSession_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZooKeeperServer).CreateSession(ctx, req.(*CreateSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ZooKeeper_KeepAlive_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeepAliveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZooKeeperServer).KeepAlive(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ZooKeeper_KeepAlive_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZooKeeperServer).KeepAlive(ctx, req.(*KeepAliveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ZooKeeper_CloseSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CloseSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZooKeeperServer).CloseSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ZooKeeper_CloseSession_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZooKeeperServer).CloseSession(ctx, req.(*CloseSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ZooKeeper_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ZooKeeperServer).Watch(m, &zooKeeperWatchServer{stream})
+}
+
+type ZooKeeper_WatchServer interface {
+	Send(*WatchEvent) error
+	grpc.ServerStream
+}
+
+type zooKeeperWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *zooKeeperWatchServer) Send(m *WatchEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _ZooKeeper_GetACL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetACLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZooKeeperServer).GetACL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ZooKeeper_GetACL_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZooKeeperServer).GetACL(ctx, req.(*GetACLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ZooKeeper_SetACL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetACLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZooKeeperServer).SetACL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ZooKeeper_SetACL_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZooKeeperServer).SetACL(ctx, req.(*SetACLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ZooKeeper_Authenticate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AuthenticateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZooKeeperServer).Authenticate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ZooKeeper_Authenticate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZooKeeperServer).Authenticate(ctx, req.(*AuthenticateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // ZooKeeper_ServiceDesc is the grpc.ServiceDesc for ZooKeeper service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -271,6 +624,378 @@ var ZooKeeper_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetChildren",
 			Handler:    _ZooKeeper_GetChildren_Handler,
 		},
+		{
+			MethodName: "Multi",
+			Handler:    _ZooKeeper_Multi_Handler,
+		},
+		{
+			MethodName: "Sync",
+			Handler:    _ZooKeeper_Sync_Handler,
+		},
+		{
+			MethodName: "CreateSession",
+			Handler:    _ZooKeeper_CreateSession_Handler,
+		},
+		{
+			MethodName: "KeepAlive",
+			Handler:    _ZooKeeper_KeepAlive_Handler,
+		},
+		{
+			MethodName: "CloseSession",
+			Handler:    _ZooKeeper_CloseSession_Handler,
+		},
+		{
+			MethodName: "GetACL",
+			Handler:    _ZooKeeper_GetACL_Handler,
+		},
+		{
+			MethodName: "SetACL",
+			Handler:    _ZooKeeper_SetACL_Handler,
+		},
+		{
+			MethodName: "Authenticate",
+			Handler:    _ZooKeeper_Authenticate_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _ZooKeeper_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "zk.proto",
+}
+
+const (
+	Admin_AddPeer_FullMethodName            = "/zk.Admin/AddPeer"
+	Admin_RemovePeer_FullMethodName         = "/zk.Admin/RemovePeer"
+	Admin_ListPeers_FullMethodName          = "/zk.Admin/ListPeers"
+	Admin_TransferLeadership_FullMethodName = "/zk.Admin/TransferLeadership"
+	Admin_Health_FullMethodName             = "/zk.Admin/Health"
+	Admin_Status_FullMethodName             = "/zk.Admin/Status"
+	Admin_Metrics_FullMethodName            = "/zk.Admin/Metrics"
+)
+
+// AdminClient is the client API for Admin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AdminClient interface {
+	// AddPeer adds a node: as a learner first, then, once it has caught
+	// up, as a voter. The node must already be running.
+	AddPeer(ctx context.Context, in *AddPeerRequest, opts ...grpc.CallOption) (*PeersResponse, error)
+	// RemovePeer removes a node, voter or learner.
+	RemovePeer(ctx context.Context, in *RemovePeerRequest, opts ...grpc.CallOption) (*PeersResponse, error)
+	// ListPeers returns this server's view of the membership.
+	ListPeers(ctx context.Context, in *ListPeersRequest, opts ...grpc.CallOption) (*PeersResponse, error)
+	// TransferLeadership hands leadership to another voter, e.g. before
+	// taking the leader down for maintenance.
+	TransferLeadership(ctx context.Context, in *TransferLeadershipRequest, opts ...grpc.CallOption) (*PeersResponse, error)
+	// Health says whether this server can serve requests. Any answer at
+	// all means it's alive (ZooKeeper's "ruok").
+	Health(ctx context.Context, in *HealthRequest, opts ...grpc.CallOption) (*HealthResponse, error)
+	// Status returns this server's role, term, log progress, each peer's
+	// lag (on the leader) and the store's numbers ("stat", "mntr").
+	Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResponse, error)
+	// Metrics returns this server's metrics in the Prometheus text format.
+	Metrics(ctx context.Context, in *MetricsRequest, opts ...grpc.CallOption) (*MetricsResponse, error)
+}
+
+type adminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminClient(cc grpc.ClientConnInterface) AdminClient {
+	return &adminClient{cc}
+}
+
+func (c *adminClient) AddPeer(ctx context.Context, in *AddPeerRequest, opts ...grpc.CallOption) (*PeersResponse, error) {
+	out := new(PeersResponse)
+	err := c.cc.Invoke(ctx, Admin_AddPeer_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) RemovePeer(ctx context.Context, in *RemovePeerRequest, opts ...grpc.CallOption) (*PeersResponse, error) {
+	out := new(PeersResponse)
+	err := c.cc.Invoke(ctx, Admin_RemovePeer_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ListPeers(ctx context.Context, in *ListPeersRequest, opts ...grpc.CallOption) (*PeersResponse, error) {
+	out := new(PeersResponse)
+	err := c.cc.Invoke(ctx, Admin_ListPeers_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) TransferLeadership(ctx context.Context, in *TransferLeadershipRequest, opts ...grpc.CallOption) (*PeersResponse, error) {
+	out := new(PeersResponse)
+	err := c.cc.Invoke(ctx, Admin_TransferLeadership_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) Health(ctx context.Context, in *HealthRequest, opts ...grpc.CallOption) (*HealthResponse, error) {
+	out := new(HealthResponse)
+	err := c.cc.Invoke(ctx, Admin_Health_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResponse, error) {
+	out := new(StatusResponse)
+	err := c.cc.Invoke(ctx, Admin_Status_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) Metrics(ctx context.Context, in *MetricsRequest, opts ...grpc.CallOption) (*MetricsResponse, error) {
+	out := new(MetricsResponse)
+	err := c.cc.Invoke(ctx, Admin_Metrics_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminServer is the server API for Admin service.
+// All implementations must embed UnimplementedAdminServer
+// for forward compatibility
+type AdminServer interface {
+	// AddPeer adds a node: as a learner first, then, once it has caught
+	// up, as a voter. The node must already be running.
+	AddPeer(context.Context, *AddPeerRequest) (*PeersResponse, error)
+	// RemovePeer removes a node, voter or learner.
+	RemovePeer(context.Context, *RemovePeerRequest) (*PeersResponse, error)
+	// ListPeers returns this server's view of the membership.
+	ListPeers(context.Context, *ListPeersRequest) (*PeersResponse, error)
+	// TransferLeadership hands leadership to another voter, e.g. before
+	// taking the leader down for maintenance.
+	TransferLeadership(context.Context, *TransferLeadershipRequest) (*PeersResponse, error)
+	// Health says whether this server can serve requests. Any answer at
+	// all means it's alive (ZooKeeper's "ruok").
+	Health(context.Context, *HealthRequest) (*HealthResponse, error)
+	// Status returns this server's role, term, log progress, each peer's
+	// lag (on the leader) and the store's numbers ("stat", "mntr").
+	Status(context.Context, *StatusRequest) (*StatusResponse, error)
+	// Metrics returns this server's metrics in the Prometheus text format.
+	Metrics(context.Context, *MetricsRequest) (*MetricsResponse, error)
+	mustEmbedUnimplementedAdminServer()
+}
+
+// UnimplementedAdminServer must be embedded to have forward compatible implementations.
+type UnimplementedAdminServer struct {
+}
+
+func (UnimplementedAdminServer) AddPeer(context.Context, *AddPeerRequest) (*PeersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddPeer not implemented")
+}
+func (UnimplementedAdminServer) RemovePeer(context.Context, *RemovePeerRequest) (*PeersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemovePeer not implemented")
+}
+func (UnimplementedAdminServer) ListPeers(context.Context, *ListPeersRequest) (*PeersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPeers not implemented")
+}
+func (UnimplementedAdminServer) TransferLeadership(context.Context, *TransferLeadershipRequest) (*PeersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TransferLeadership not implemented")
+}
+func (UnimplementedAdminServer) Health(context.Context, *HealthRequest) (*HealthResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Health not implemented")
+}
+func (UnimplementedAdminServer) Status(context.Context, *StatusRequest) (*StatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Status not implemented")
+}
+func (UnimplementedAdminServer) Metrics(context.Context, *MetricsRequest) (*MetricsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Metrics not implemented")
+}
+func (UnimplementedAdminServer) mustEmbedUnimplementedAdminServer() {}
+
+// UnsafeAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AdminServer will
+// result in compilation errors.
+type UnsafeAdminServer interface {
+	mustEmbedUnimplementedAdminServer()
+}
+
+func RegisterAdminServer(s grpc.ServiceRegistrar, srv AdminServer) {
+	s.RegisterService(&Admin_ServiceDesc, srv)
+}
+
+func _Admin_AddPeer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddPeerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).AddPeer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_AddPeer_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).AddPeer(ctx, req.(*AddPeerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_RemovePeer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemovePeerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).RemovePeer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_RemovePeer_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).RemovePeer(ctx, req.(*RemovePeerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ListPeers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPeersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListPeers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ListPeers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListPeers(ctx, req.(*ListPeersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_TransferLeadership_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TransferLeadershipRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).TransferLeadership(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_TransferLeadership_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).TransferLeadership(ctx, req.(*TransferLeadershipRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_Health_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HealthRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).Health(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_Health_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).Health(ctx, req.(*HealthRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_Status_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).Status(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_Status_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).Status(ctx, req.(*StatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_Metrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MetricsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).Metrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_Metrics_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).Metrics(ctx, req.(*MetricsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Admin_ServiceDesc is the grpc.ServiceDesc for Admin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Admin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "zk.Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "AddPeer",
+			Handler:    _Admin_AddPeer_Handler,
+		},
+		{
+			MethodName: "RemovePeer",
+			Handler:    _Admin_RemovePeer_Handler,
+		},
+		{
+			MethodName: "ListPeers",
+			Handler:    _Admin_ListPeers_Handler,
+		},
+		{
+			MethodName: "TransferLeadership",
+			Handler:    _Admin_TransferLeadership_Handler,
+		},
+		{
+			MethodName: "Health",
+			Handler:    _Admin_Health_Handler,
+		},
+		{
+			MethodName: "Status",
+			Handler:    _Admin_Status_Handler,
+		},
+		{
+			MethodName: "Metrics",
+			Handler:    _Admin_Metrics_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "zk.proto",
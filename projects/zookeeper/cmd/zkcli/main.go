@@ -8,64 +8,204 @@ package main
 //   go run ./cmd/zkcli --server localhost:2181 set /app "world"
 //   go run ./cmd/zkcli --server localhost:2181 delete /app
 //   go run ./cmd/zkcli --server localhost:2181 ls /
+//   go run ./cmd/zkcli --server localhost:2181 stat /app
+//
+// set and delete take -v <version> to only write if the node is still
+// at that version (compare-and-set). Get the version with stat:
+//   go run ./cmd/zkcli --server localhost:2181 set -v 3 /app "world"
+//
+// create -e makes an ephemeral node: zkcli opens a session, creates the
+// node and keeps the session alive until you press Ctrl-C. Then the
+// session closes and the node is gone. (Kill zkcli hard instead and the
+// node goes away once the session times out.)
+//   go run ./cmd/zkcli --server localhost:2181 create -e /workers/me "up"
+//
+// create -s appends a sequence number to the name and prints the path
+// actually created. -e and -s combine:
+//   go run ./cmd/zkcli --server localhost:2181 create -s /queue/job- "payload"
+//   → created /queue/job-0000000003
+//
+// Reads (get, ls, stat) answer from whatever the node has applied. A
+// follower may be a little behind; -c read_index (or lease) makes the
+// read see every write committed before it. sync catches the node up:
+//   go run ./cmd/zkcli --server localhost:2182 get -c read_index /app
+//   go run ./cmd/zkcli --server localhost:2182 sync
+//
+// watch prints every change to a node and its child list as it happens,
+// until Ctrl-C:
+//   go run ./cmd/zkcli --server localhost:2181 watch /workers
+//
+// peers lists the cluster's members; peers add and peers remove change
+// them (see docs/06). A new node is given in the same format as --peers,
+// and must already be running with --join:
+//   go run ./cmd/zkcli --server localhost:2181 peers
+//   go run ./cmd/zkcli --server localhost:2181 peers add node-4=localhost:3004:2184
+//   go run ./cmd/zkcli --server localhost:2181 peers remove node-1
+//
+// peers transfer moves leadership to another voter (any, without an id),
+// e.g. before restarting the leader:
+//   go run ./cmd/zkcli --server localhost:2181 peers transfer node-2
+//
+// admin asks each --server about itself, not the cluster: no retries,
+// no redirect to the leader (see docs/05). ruok says whether it's up and
+// ready, stat shows its role, log progress, followers' lag (on the
+// leader) and WAL size; state is the same as JSON, metrics the
+// Prometheus text:
+//   go run ./cmd/zkcli --server localhost:2181,localhost:2182,localhost:2183 admin ruok
+//   → localhost:2181: imok
+//   go run ./cmd/zkcli --server localhost:2181 admin stat
+//   go run ./cmd/zkcli --server localhost:2181 admin state | jq .peers
+//   go run ./cmd/zkcli --server localhost:2181 admin metrics | grep fsync
+//
+// With no command (or shell), zkcli is an interactive shell for
+// browsing a tree: cd, ls -R, tree, rmr, cp, export and import of a
+// branch to a JSON or YAML file, Tab completion of znode paths and
+// history (see shell.go):
+//   go run ./cmd/zkcli --server localhost:2181
+//   zk:/> cd app
+//   zk:/app> export . app.yaml
+//
+// ACLs (see docs/05). create --acl sets a new node's ACL; getAcl and
+// setAcl read and replace it. digest prints the id for a user and
+// password, without a server:
+//   go run ./cmd/zkcli digest alice:secret
+//   → digest:alice:aYXlLOpEooaV1cRAvUL1fp9Qt7E=
+//   go run ./cmd/zkcli --server localhost:2181 create --acl digest:alice:aYXlLOpEooaV1cRAvUL1fp9Qt7E=:cdrwa /app "hello"
+//   go run ./cmd/zkcli --server localhost:2181 --auth alice:secret getAcl /app
+//   go run ./cmd/zkcli --server localhost:2181 --auth alice:secret setAcl -v 0 /app world:anyone:r,digest:alice:aYXlLOpEooaV1cRAvUL1fp9Qt7E=:cdrwa
+//
+// --auth user:password sends digest credentials with every call (repeat
+// it for several). --tls-ca connects over TLS; --tls-cert and --tls-key
+// add a client certificate, an x509 id. whoami prints the ids the
+// server sees:
+//   go run ./cmd/zkcli --server localhost:2181 --tls-ca ca.pem --tls-cert billing.pem --tls-key billing-key.pem whoami
+//
+// Against a cluster, list every node. zkcli finds the leader by itself:
+//   go run ./cmd/zkcli --server localhost:2181,localhost:2182,localhost:2183 create /app "hello"
+//
+// If the node it talks to is down or is not the leader, zkcli moves on:
+//   - "not leader, the leader is at X" → retry on X right away
+//   - node unreachable / no quorum     → wait a little, try the next node
+// So a script keeps working through a leader failover without any changes.
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 
 	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+	"github.com/syamsularifin/zookeeper/internal/acl"
+	"github.com/syamsularifin/zookeeper/internal/cluster"
+	"github.com/syamsularifin/zookeeper/internal/server"
 )
 
+const (
+	// attemptTimeout bounds a single RPC to a single node.
+	attemptTimeout = 5 * time.Second
+
+	// totalTimeout bounds the whole command, retries included.
+	// Long enough to ride out an election (a few hundred ms) with margin.
+	totalTimeout = 15 * time.Second
+
+	// sessionTimeout is what create -e asks for.
+	sessionTimeout = 10 * time.Second
+)
+
+// authFlag collects repeated --auth values.
+type authFlag []string
+
+func (a *authFlag) String() string { return strings.Join(*a, ",") }
+
+func (a *authFlag) Set(v string) error {
+	if _, err := acl.DigestCredentials(v); err != nil {
+		return err
+	}
+	*a = append(*a, v)
+	return nil
+}
+
 func main() {
-	if len(os.Args) < 4 {
-		printUsage()
-		os.Exit(1)
+	fs := flag.NewFlagSet("zkcli", flag.ExitOnError)
+	fs.Usage = printUsage
+	serverList := fs.String("server", "", "comma-separated client addresses")
+	var auth authFlag
+	fs.Var(&auth, "auth", "digest credentials user:password (repeatable)")
+	tlsCA := fs.String("tls-ca", "", "CA that signs the servers' certificates (enables TLS)")
+	tlsCert := fs.String("tls-cert", "", "client certificate, an x509 id")
+	tlsKey := fs.String("tls-key", "", "client certificate key")
+	fs.Parse(os.Args[1:])
+
+	// No command: the interactive shell.
+	command, args := "shell", []string(nil)
+	if fs.NArg() > 0 {
+		command, args = fs.Arg(0), fs.Args()[1:]
 	}
 
-	if os.Args[1] != "--server" {
+	// digest needs no server.
+	if command == "digest" {
+		cmdDigest(args)
+		return
+	}
+	if *serverList == "" {
 		printUsage()
 		os.Exit(1)
 	}
 
-	serverAddr := os.Args[2]
-	command := os.Args[3]
-	args := os.Args[4:]
-
-	// Connect to the server.
-	// insecure.NewCredentials() means no TLS — fine for local development.
-	// In production, you'd use TLS certificates.
-	conn, err := grpc.NewClient(serverAddr,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-	)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to connect: %v\n", err)
-		os.Exit(1)
+	c := newClient(strings.Split(*serverList, ","))
+	defer c.close()
+	c.auth = auth
+	if *tlsCA != "" || *tlsCert != "" {
+		cfg, err := server.LoadClientTLS(*tlsCA, *tlsCert, *tlsKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		c.tls = cfg
 	}
-	defer conn.Close()
-
-	// Create the gRPC client from the generated code.
-	client := zkpb.NewZooKeeperClient(conn)
-
-	// 5 second timeout for all operations.
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
 
 	switch command {
 	case "create":
-		cmdCreate(ctx, client, args)
+		cmdCreate(c, args)
 	case "get":
-		cmdGet(ctx, client, args)
+		cmdGet(c, args)
 	case "set":
-		cmdSet(ctx, client, args)
+		cmdSet(c, args)
 	case "delete":
-		cmdDelete(ctx, client, args)
+		cmdDelete(c, args)
 	case "ls":
-		cmdLs(ctx, client, args)
+		cmdLs(c, args)
+	case "stat":
+		cmdStat(c, args)
+	case "sync":
+		cmdSync(c, args)
+	case "watch":
+		cmdWatch(c, args)
+	case "peers":
+		cmdPeers(c, args)
+	case "getAcl":
+		cmdGetACL(c, args)
+	case "setAcl":
+		cmdSetACL(c, args)
+	case "whoami":
+		cmdWhoami(c, args)
+	case "admin":
+		cmdAdmin(c, args)
+	case "shell":
+		cmdShell(c, args)
 	default:
 		fmt.Fprintf(os.Stderr, "unknown command: %s\n", command)
 		printUsage()
@@ -73,18 +213,179 @@ func main() {
 	}
 }
 
-func cmdCreate(ctx context.Context, c zkpb.ZooKeeperClient, args []string) {
+// client is zkcli's view of the cluster: the list of servers it may talk
+// to, which one it's using right now, and one connection per server.
+type client struct {
+	servers []string
+	current int
+	conns   map[string]*grpc.ClientConn
+
+	// tls, if set, is the config to connect with. nil: plaintext.
+	tls *tls.Config
+
+	// auth is the digest credentials sent with every call.
+	auth []string
+}
+
+func newClient(servers []string) *client {
+	return &client{
+		servers: servers,
+		conns:   make(map[string]*grpc.ClientConn),
+	}
+}
+
+// zk returns a gRPC client for addr, connecting on first use.
+//
+// Without --tls-ca the connection is plaintext — fine for local
+// development. In production, use TLS (see zknode's --tls-cert).
+func (c *client) zk(addr string) (zkpb.ZooKeeperClient, error) {
+	conn, err := c.conn(addr)
+	if err != nil {
+		return nil, err
+	}
+	return zkpb.NewZooKeeperClient(conn), nil
+}
+
+// conn returns the connection to addr, connecting on first use.
+func (c *client) conn(addr string) (*grpc.ClientConn, error) {
+	conn, ok := c.conns[addr]
+	if !ok {
+		var err error
+		creds := insecure.NewCredentials()
+		if c.tls != nil {
+			creds = credentials.NewTLS(c.tls)
+		}
+		conn, err = grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, err
+		}
+		c.conns[addr] = conn
+	}
+	return conn, nil
+}
+
+func (c *client) close() {
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+}
+
+// do runs one RPC against the cluster, retrying until it succeeds,
+// fails for a reason retrying can't fix, or totalTimeout runs out.
+//
+// The retry rules:
+//
+//	not leader, leader known   → switch to the leader, retry now
+//	not leader, leader unknown → election in progress, back off, next server
+//	Unavailable                → node down or no quorum, back off, next server
+//	DeadlineExceeded           → node hung, back off, next server
+//	anything else              → real answer (e.g. NotFound), stop
+func (c *client) do(call func(ctx context.Context, zk zkpb.ZooKeeperClient) error) error {
+	deadline := time.Now().Add(totalTimeout)
+	backoff := 100 * time.Millisecond
+
+	for {
+		addr := c.servers[c.current]
+
+		err := c.attempt(addr, call)
+		if err == nil {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return err
+		}
+
+		if _, leaderAddr, ok := server.LeaderFromError(err); ok && leaderAddr != "" && leaderAddr != addr {
+			c.useServer(leaderAddr)
+			continue
+		} else if !ok && !retryable(err) {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "%s: %v, retrying\n", addr, status.Convert(err).Message())
+		time.Sleep(backoff)
+		if backoff < time.Second {
+			backoff *= 2
+		}
+		c.current = (c.current + 1) % len(c.servers)
+	}
+}
+
+// attempt makes one RPC to one server with its own timeout.
+func (c *client) attempt(addr string, call func(ctx context.Context, zk zkpb.ZooKeeperClient) error) error {
+	zk, err := c.zk(addr)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "%v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(c.context(context.Background()), attemptTimeout)
+	defer cancel()
+
+	return call(ctx, zk)
+}
+
+// context adds the --auth credentials to ctx's calls.
+func (c *client) context(ctx context.Context) context.Context {
+	for _, creds := range c.auth {
+		ctx = server.WithDigest(ctx, creds)
+	}
+	return ctx
+}
+
+// useServer makes addr the current server, adding it to the list if
+// it isn't there yet (a leader we learned about from a redirect).
+func (c *client) useServer(addr string) {
+	for i, s := range c.servers {
+		if s == addr {
+			c.current = i
+			return
+		}
+	}
+	c.servers = append(c.servers, addr)
+	c.current = len(c.servers) - 1
+}
+
+// retryable reports whether trying again (maybe elsewhere) can help.
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+func cmdCreate(c *client, args []string) {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	ephemeral := fs.Bool("e", false, "ephemeral: lives until zkcli exits")
+	sequential := fs.Bool("s", false, "sequential: append the parent's next sequence number")
+	aclText := fs.String("acl", "", "the node's ACL, scheme:id:perms,... (default world:anyone:cdrwa)")
+	fs.Parse(args)
+	args = fs.Args()
+
 	if len(args) < 1 {
-		fmt.Fprintln(os.Stderr, "usage: create <path> [data]")
+		fmt.Fprintln(os.Stderr, "usage: create [-e] [-s] [--acl acl] <path> [data]")
 		os.Exit(1)
 	}
 
-	req := &zkpb.CreateRequest{Path: args[0]}
+	req := &zkpb.CreateRequest{Path: args[0], Sequential: *sequential}
+	if *aclText != "" {
+		req.Acl = parseACL(*aclText)
+	}
 	if len(args) >= 2 {
 		req.Data = []byte(args[1])
 	}
+	if *ephemeral {
+		createEphemeral(c, req)
+		return
+	}
 
-	resp, err := c.Create(ctx, req)
+	var resp *zkpb.CreateResponse
+	err := c.do(func(ctx context.Context, zk zkpb.ZooKeeperClient) (err error) {
+		resp, err = zk.Create(ctx, req)
+		return err
+	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
@@ -92,13 +393,81 @@ func cmdCreate(ctx context.Context, c zkpb.ZooKeeperClient, args []string) {
 	fmt.Printf("created %s\n", resp.Path)
 }
 
-func cmdGet(ctx context.Context, c zkpb.ZooKeeperClient, args []string) {
+// createEphemeral opens a session, creates req's node in it, and keeps
+// the session alive until Ctrl-C.
+func createEphemeral(c *client, req *zkpb.CreateRequest) {
+	var sess *zkpb.CreateSessionResponse
+	err := c.do(func(ctx context.Context, zk zkpb.ZooKeeperClient) (err error) {
+		sess, err = zk.CreateSession(ctx, &zkpb.CreateSessionRequest{TimeoutMs: sessionTimeout.Milliseconds()})
+		return err
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	req.Ephemeral = true
+	req.SessionId = sess.SessionId
+	req.SessionPassword = sess.Password
+	var resp *zkpb.CreateResponse
+	err = c.do(func(ctx context.Context, zk zkpb.ZooKeeperClient) (err error) {
+		resp, err = zk.Create(ctx, req)
+		return err
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		c.closeSession(sess)
+		os.Exit(1)
+	}
+	fmt.Printf("created %s (session %d, Ctrl-C to end it)\n", resp.Path, sess.SessionId)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	// Three keepalives per timeout: one can be lost, or land on a node
+	// that's mid-failover, without the session expiring.
+	ticker := time.NewTicker(time.Duration(sess.TimeoutMs) * time.Millisecond / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-interrupt:
+			c.closeSession(sess)
+			fmt.Println("session closed")
+			return
+		case <-ticker.C:
+			err := c.do(func(ctx context.Context, zk zkpb.ZooKeeperClient) error {
+				_, err := zk.KeepAlive(ctx, &zkpb.KeepAliveRequest{SessionId: sess.SessionId, Password: sess.Password})
+				return err
+			})
+			if server.IsSessionExpired(err) {
+				fmt.Fprintln(os.Stderr, "session expired, the node is gone")
+				os.Exit(1)
+			}
+		}
+	}
+}
+
+// closeSession ends a session, ignoring errors: at worst it expires.
+func (c *client) closeSession(sess *zkpb.CreateSessionResponse) {
+	c.do(func(ctx context.Context, zk zkpb.ZooKeeperClient) error {
+		_, err := zk.CloseSession(ctx, &zkpb.CloseSessionRequest{SessionId: sess.SessionId, Password: sess.Password})
+		return err
+	})
+}
+
+func cmdGet(c *client, args []string) {
+	consistency, args := parseConsistency("get", args)
 	if len(args) < 1 {
-		fmt.Fprintln(os.Stderr, "usage: get <path>")
+		fmt.Fprintln(os.Stderr, "usage: get [-c consistency] <path>")
 		os.Exit(1)
 	}
 
-	resp, err := c.Get(ctx, &zkpb.GetRequest{Path: args[0]})
+	var resp *zkpb.GetResponse
+	err := c.do(func(ctx context.Context, zk zkpb.ZooKeeperClient) (err error) {
+		resp, err = zk.Get(ctx, &zkpb.GetRequest{Path: args[0], Consistency: consistency})
+		return err
+	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
@@ -106,13 +475,18 @@ func cmdGet(ctx context.Context, c zkpb.ZooKeeperClient, args []string) {
 	fmt.Println(string(resp.Data))
 }
 
-func cmdSet(ctx context.Context, c zkpb.ZooKeeperClient, args []string) {
+func cmdSet(c *client, args []string) {
+	version, args := parseVersion("set", args)
 	if len(args) < 2 {
-		fmt.Fprintln(os.Stderr, "usage: set <path> <data>")
+		fmt.Fprintln(os.Stderr, "usage: set [-v version] <path> <data>")
 		os.Exit(1)
 	}
 
-	_, err := c.Set(ctx, &zkpb.SetRequest{Path: args[0], Data: []byte(args[1])})
+	req := &zkpb.SetRequest{Path: args[0], Data: []byte(args[1]), Version: version}
+	err := c.do(func(ctx context.Context, zk zkpb.ZooKeeperClient) error {
+		_, err := zk.Set(ctx, req)
+		return err
+	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
@@ -120,13 +494,18 @@ func cmdSet(ctx context.Context, c zkpb.ZooKeeperClient, args []string) {
 	fmt.Println("updated")
 }
 
-func cmdDelete(ctx context.Context, c zkpb.ZooKeeperClient, args []string) {
+func cmdDelete(c *client, args []string) {
+	version, args := parseVersion("delete", args)
 	if len(args) < 1 {
-		fmt.Fprintln(os.Stderr, "usage: delete <path>")
+		fmt.Fprintln(os.Stderr, "usage: delete [-v version] <path>")
 		os.Exit(1)
 	}
 
-	_, err := c.Delete(ctx, &zkpb.DeleteRequest{Path: args[0]})
+	req := &zkpb.DeleteRequest{Path: args[0], Version: version}
+	err := c.do(func(ctx context.Context, zk zkpb.ZooKeeperClient) error {
+		_, err := zk.Delete(ctx, req)
+		return err
+	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
@@ -134,13 +513,18 @@ func cmdDelete(ctx context.Context, c zkpb.ZooKeeperClient, args []string) {
 	fmt.Println("deleted")
 }
 
-func cmdLs(ctx context.Context, c zkpb.ZooKeeperClient, args []string) {
+func cmdLs(c *client, args []string) {
+	consistency, args := parseConsistency("ls", args)
 	if len(args) < 1 {
-		fmt.Fprintln(os.Stderr, "usage: ls <path>")
+		fmt.Fprintln(os.Stderr, "usage: ls [-c consistency] <path>")
 		os.Exit(1)
 	}
 
-	resp, err := c.GetChildren(ctx, &zkpb.GetChildrenRequest{Path: args[0]})
+	var resp *zkpb.GetChildrenResponse
+	err := c.do(func(ctx context.Context, zk zkpb.ZooKeeperClient) (err error) {
+		resp, err = zk.GetChildren(ctx, &zkpb.GetChildrenRequest{Path: args[0], Consistency: consistency})
+		return err
+	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
@@ -155,13 +539,479 @@ func cmdLs(ctx context.Context, c zkpb.ZooKeeperClient, args []string) {
 	}
 }
 
+func cmdStat(c *client, args []string) {
+	consistency, args := parseConsistency("stat", args)
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: stat [-c consistency] <path>")
+		os.Exit(1)
+	}
+
+	var resp *zkpb.GetResponse
+	err := c.do(func(ctx context.Context, zk zkpb.ZooKeeperClient) (err error) {
+		resp, err = zk.Get(ctx, &zkpb.GetRequest{Path: args[0], Consistency: consistency})
+		return err
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	printStat(os.Stdout, resp.Stat)
+}
+
+// printStat prints a Stat the way ZooKeeper's stat does.
+func printStat(w io.Writer, st *zkpb.Stat) {
+	fmt.Fprintf(w, "czxid          = %d\n", st.GetCzxid())
+	fmt.Fprintf(w, "mzxid          = %d\n", st.GetMzxid())
+	fmt.Fprintf(w, "ctime          = %s\n", formatMillis(st.GetCtime()))
+	fmt.Fprintf(w, "mtime          = %s\n", formatMillis(st.GetMtime()))
+	fmt.Fprintf(w, "version        = %d\n", st.GetVersion())
+	fmt.Fprintf(w, "cversion       = %d\n", st.GetCversion())
+	fmt.Fprintf(w, "dataLength     = %d\n", st.GetDataLength())
+	fmt.Fprintf(w, "numChildren    = %d\n", st.GetNumChildren())
+	fmt.Fprintf(w, "aversion       = %d\n", st.GetAversion())
+	fmt.Fprintf(w, "ephemeralOwner = %d\n", st.GetEphemeralOwner())
+}
+
+// cmdGetACL prints a node's ACL, one entry per line, and its aversion.
+func cmdGetACL(c *client, args []string) {
+	consistency, args := parseConsistency("getAcl", args)
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: getAcl [-c consistency] <path>")
+		os.Exit(1)
+	}
+
+	var resp *zkpb.GetACLResponse
+	err := c.do(func(ctx context.Context, zk zkpb.ZooKeeperClient) (err error) {
+		resp, err = zk.GetACL(ctx, &zkpb.GetACLRequest{Path: args[0], Consistency: consistency})
+		return err
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	for _, a := range resp.Acl {
+		fmt.Println(acl.ACL{Perms: acl.Perm(a.Perms), ID: acl.ID{Scheme: a.Scheme, ID: a.Id}})
+	}
+	fmt.Printf("aversion = %d\n", resp.Stat.GetAversion())
+}
+
+// cmdSetACL replaces a node's ACL.
+func cmdSetACL(c *client, args []string) {
+	version, args := parseVersion("setAcl", args)
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: setAcl [-v aversion] <path> <scheme:id:perms,...>")
+		os.Exit(1)
+	}
+
+	req := &zkpb.SetACLRequest{Path: args[0], Acl: parseACL(args[1]), Version: version}
+	err := c.do(func(ctx context.Context, zk zkpb.ZooKeeperClient) error {
+		_, err := zk.SetACL(ctx, req)
+		return err
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("acl updated")
+}
+
+// cmdWhoami prints the identities the server gives zkcli's calls.
+func cmdWhoami(c *client, args []string) {
+	var resp *zkpb.AuthenticateResponse
+	err := c.do(func(ctx context.Context, zk zkpb.ZooKeeperClient) (err error) {
+		// The --auth credentials are on every call already.
+		resp, err = zk.Authenticate(ctx, &zkpb.AuthenticateRequest{})
+		return err
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	for _, id := range resp.Identities {
+		fmt.Println(id)
+	}
+}
+
+// cmdDigest prints the digest id for user:password, to put in an ACL
+// or in zknode's --super-digest.
+func cmdDigest(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: digest <user>:<password>")
+		os.Exit(1)
+	}
+	id, err := acl.DigestCredentials(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(id)
+}
+
+// parseACL reads an ACL in its text form, or exits.
+func parseACL(s string) []*zkpb.ACL {
+	acls, err := acl.ParseList(s)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	out := make([]*zkpb.ACL, 0, len(acls))
+	for _, a := range acls {
+		out = append(out, &zkpb.ACL{Scheme: a.Scheme, Id: a.ID.ID, Perms: int32(a.Perms)})
+	}
+	return out
+}
+
+// cmdSync catches the node zkcli talks to up with the leader.
+func cmdSync(c *client, args []string) {
+	var resp *zkpb.SyncResponse
+	err := c.do(func(ctx context.Context, zk zkpb.ZooKeeperClient) (err error) {
+		resp, err = zk.Sync(ctx, &zkpb.SyncRequest{})
+		return err
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("synced to zxid %d\n", resp.Zxid)
+}
+
+// cmdPeers lists the cluster's members, adds or removes one, or moves
+// leadership.
+func cmdPeers(c *client, args []string) {
+	var resp *zkpb.PeersResponse
+	admin := func(call func(ctx context.Context, admin zkpb.AdminClient) (*zkpb.PeersResponse, error)) error {
+		// The Admin service shares the port (and the retries) of the
+		// server c.do is trying right now. A peers add that times out
+		// is retried, and picks up where it left off.
+		return c.do(func(ctx context.Context, _ zkpb.ZooKeeperClient) (err error) {
+			conn, err := c.conn(c.servers[c.current])
+			if err != nil {
+				return err
+			}
+			resp, err = call(ctx, zkpb.NewAdminClient(conn))
+			return err
+		})
+	}
+
+	var err error
+	switch {
+	case len(args) == 0:
+		err = admin(func(ctx context.Context, admin zkpb.AdminClient) (*zkpb.PeersResponse, error) {
+			return admin.ListPeers(ctx, &zkpb.ListPeersRequest{})
+		})
+	case args[0] == "add" && len(args) == 2:
+		peers, perr := cluster.ParsePeers(args[1])
+		if perr != nil || len(peers) != 1 {
+			fmt.Fprintf(os.Stderr, "usage: peers add <id>=<host>:<raftPort>[:<clientPort>] (%v)\n", perr)
+			os.Exit(1)
+		}
+		p := peers[0]
+		err = admin(func(ctx context.Context, admin zkpb.AdminClient) (*zkpb.PeersResponse, error) {
+			return admin.AddPeer(ctx, &zkpb.AddPeerRequest{Peer: &zkpb.Peer{
+				Id: string(p.ID), RaftAddr: p.Addr, ClientAddr: p.ClientAddr,
+			}})
+		})
+	case args[0] == "remove" && len(args) == 2:
+		err = admin(func(ctx context.Context, admin zkpb.AdminClient) (*zkpb.PeersResponse, error) {
+			return admin.RemovePeer(ctx, &zkpb.RemovePeerRequest{Id: args[1]})
+		})
+	case args[0] == "transfer" && len(args) <= 2:
+		var id string
+		if len(args) == 2 {
+			id = args[1]
+		}
+		err = admin(func(ctx context.Context, admin zkpb.AdminClient) (*zkpb.PeersResponse, error) {
+			return admin.TransferLeadership(ctx, &zkpb.TransferLeadershipRequest{Id: id})
+		})
+	default:
+		fmt.Fprintln(os.Stderr, "usage: peers [add <id>=<host>:<raftPort>[:<clientPort>] | remove <id> | transfer [<id>]]")
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, p := range resp.Peers {
+		role := "voter"
+		if p.Learner {
+			role = "learner"
+		}
+		if p.Id == resp.LeaderId {
+			role = "leader"
+		}
+		fmt.Printf("%-10s %-8s raft=%s client=%s\n", p.Id, role, p.RaftAddr, p.ClientAddr)
+	}
+}
+
+// cmdAdmin asks every server for its health, status or metrics. Each
+// answers for itself, so there's no c.do: a server that's down is
+// reported, not skipped.
+func cmdAdmin(c *client, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: admin ruok|stat|state|metrics")
+		os.Exit(1)
+	}
+
+	ask := func(addr string, call func(ctx context.Context, admin zkpb.AdminClient) error) error {
+		conn, err := c.conn(addr)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(c.context(context.Background()), attemptTimeout)
+		defer cancel()
+		return call(ctx, zkpb.NewAdminClient(conn))
+	}
+
+	failed := false
+	states := make(map[string]json.RawMessage)
+	for _, addr := range c.servers {
+		var err error
+		switch args[0] {
+		case "ruok":
+			var resp *zkpb.HealthResponse
+			err = ask(addr, func(ctx context.Context, admin zkpb.AdminClient) (err error) {
+				resp, err = admin.Health(ctx, &zkpb.HealthRequest{})
+				return err
+			})
+			if err == nil && !resp.Ready {
+				fmt.Printf("%s: imok, not ready: %s\n", addr, resp.Reason)
+				failed = true
+			} else if err == nil {
+				fmt.Printf("%s: imok\n", addr)
+			}
+		case "stat":
+			var resp *zkpb.StatusResponse
+			err = ask(addr, func(ctx context.Context, admin zkpb.AdminClient) (err error) {
+				resp, err = admin.Status(ctx, &zkpb.StatusRequest{})
+				return err
+			})
+			if err == nil {
+				printStatus(addr, resp)
+			}
+		case "state":
+			var resp *zkpb.StatusResponse
+			err = ask(addr, func(ctx context.Context, admin zkpb.AdminClient) (err error) {
+				resp, err = admin.Status(ctx, &zkpb.StatusRequest{})
+				return err
+			})
+			if err == nil {
+				states[addr], err = server.StatusJSON(resp)
+			}
+		case "metrics":
+			var resp *zkpb.MetricsResponse
+			err = ask(addr, func(ctx context.Context, admin zkpb.AdminClient) (err error) {
+				resp, err = admin.Metrics(ctx, &zkpb.MetricsRequest{})
+				return err
+			})
+			if err == nil {
+				if len(c.servers) > 1 {
+					fmt.Printf("# server %s\n", addr)
+				}
+				fmt.Print(resp.Text)
+			}
+		default:
+			fmt.Fprintln(os.Stderr, "usage: admin ruok|stat|state|metrics")
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", addr, status.Convert(err).Message())
+			failed = true
+		}
+	}
+
+	// state: one server's JSON as is, several keyed by address.
+	if len(c.servers) == 1 {
+		os.Stdout.Write(states[c.servers[0]])
+	} else if len(states) > 0 {
+		out, _ := json.MarshalIndent(states, "", "  ")
+		fmt.Println(string(out))
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// printStatus prints a StatusResponse the way ZooKeeper's stat does.
+func printStatus(addr string, st *zkpb.StatusResponse) {
+	fmt.Println(addr)
+	if st.Role == "standalone" {
+		fmt.Println("  mode:      standalone")
+	} else {
+		fmt.Printf("  node:      %s (%s, term %d)\n", st.Id, st.Role, st.Term)
+		leader := st.LeaderId
+		switch {
+		case leader == "":
+			leader = "none known"
+		case st.Isolated:
+			leader += " (isolated: not heard from lately)"
+		}
+		fmt.Printf("  leader:    %s\n", leader)
+		fmt.Printf("  elections: %d started, %d won\n", st.Elections, st.ElectionsWon)
+	}
+	fmt.Printf("  log:       commit %d, applied %d, last %d\n", st.CommitIndex, st.LastApplied, st.LastLogTxid)
+	if s := st.Store; s != nil {
+		fmt.Printf("  znodes:    %d\n", s.Znodes)
+		fmt.Printf("  wal:       %s in %d segment(s), entries %d-%d, snapshot at %d\n",
+			formatBytes(s.WalBytes), s.WalSegments, s.FirstTxid, s.LastTxid, s.SnapshotTxid)
+		fmt.Printf("  sessions:  %d, watches: %d\n", s.Sessions, s.Watches)
+	}
+	if st.Role != "leader" {
+		return
+	}
+	fmt.Println("  followers:")
+	for _, p := range st.Peers {
+		role := "voter"
+		if p.Learner {
+			role = "learner"
+		}
+		line := fmt.Sprintf("    %-10s %-8s match %-8d lag %d", p.Id, role, p.MatchIndex, p.Lag)
+		if p.SendingSnapshot {
+			line += " (sending snapshot)"
+		}
+		fmt.Println(line)
+	}
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// cmdWatch streams a persistent watch on a node and its children until
+// Ctrl-C. If the server goes away, it watches through the next one —
+// changes made while it was switching over are not printed.
+func cmdWatch(c *client, args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: watch <path>")
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	req := &zkpb.WatchRequest{Path: args[0], Type: zkpb.WatchType_ALL, Persistent: true}
+	backoff := 100 * time.Millisecond
+	for {
+		addr := c.servers[c.current]
+		err := watchOn(ctx, c, addr, req)
+		if ctx.Err() != nil {
+			return
+		}
+		if !retryable(err) {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Fprintf(os.Stderr, "%s: %v, watching elsewhere\n", addr, status.Convert(err).Message())
+		time.Sleep(backoff)
+		if backoff < time.Second {
+			backoff *= 2
+		}
+		c.current = (c.current + 1) % len(c.servers)
+	}
+}
+
+// watchOn prints req's events from one server until the stream breaks.
+func watchOn(ctx context.Context, c *client, addr string, req *zkpb.WatchRequest) error {
+	zk, err := c.zk(addr)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "%v", err)
+	}
+	stream, err := zk.Watch(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	for {
+		ev, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if ev.Type == zkpb.EventType_REGISTERED {
+			fmt.Printf("watching %s on %s (zxid %d, Ctrl-C to stop)\n", ev.Path, addr, ev.Zxid)
+			continue
+		}
+		fmt.Printf("%-22s %s (zxid %d)\n", ev.Type, ev.Path, ev.Zxid)
+	}
+}
+
+// parseVersion reads an optional -v <version> in front of a command's
+// arguments. nil means no -v: the write happens at any version.
+func parseVersion(command string, args []string) (*int32, []string) {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	v := fs.Int("v", -1, "only write if the node is at this version")
+	fs.Parse(args)
+
+	var version *int32
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "v" {
+			n := int32(*v)
+			version = &n
+		}
+	})
+	return version, fs.Args()
+}
+
+// parseConsistency reads the -c flag of a read command: local (the
+// default), read_index or lease.
+func parseConsistency(command string, args []string) (zkpb.ReadConsistency, []string) {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	c := fs.String("c", "local", "read consistency: local, read_index or lease")
+	fs.Parse(args)
+
+	v, ok := zkpb.ReadConsistency_value[strings.ToUpper(*c)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown consistency %q (local, read_index or lease)\n", *c)
+		os.Exit(1)
+	}
+	return zkpb.ReadConsistency(v), fs.Args()
+}
+
+// formatMillis prints a Unix ms timestamp. 0 means "never stamped"
+// (the root, or nodes from before Stat existed).
+func formatMillis(ms int64) string {
+	if ms == 0 {
+		return "-"
+	}
+	return time.UnixMilli(ms).Format(time.RFC3339Nano)
+}
+
 func printUsage() {
-	fmt.Println("usage: zkcli --server <addr> <command> [args]")
+	fmt.Println("usage: zkcli --server <addr>[,<addr>...] [--auth user:pw] [--tls-ca F [--tls-cert F --tls-key F]] [<command> [args]]")
 	fmt.Println()
 	fmt.Println("commands:")
-	fmt.Println("  create <path> [data]    create a znode")
-	fmt.Println("  get    <path>           read a znode's data")
-	fmt.Println("  set    <path> <data>    update a znode's data")
-	fmt.Println("  delete <path>           delete a znode")
-	fmt.Println("  ls     <path>           list children")
+	fmt.Println("  create [-e] [-s] [--acl A] <path> [data]")
+	fmt.Println("                               create a znode (-e: ephemeral, until Ctrl-C;")
+	fmt.Println("                               -s: sequential, prints the path created;")
+	fmt.Println("                               --acl: its ACL, scheme:id:perms,...)")
+	fmt.Println("  get    [-c C] <path>         read a znode's data")
+	fmt.Println("  set    [-v N] <path> <data>  update a znode's data (only at version N)")
+	fmt.Println("  delete [-v N] <path>         delete a znode (only at version N)")
+	fmt.Println("  ls     [-c C] <path>         list children")
+	fmt.Println("  stat   [-c C] <path>         show a znode's Stat")
+	fmt.Println("  sync                         catch the node up with the leader")
+	fmt.Println("  watch  <path>                print changes to a znode and its children")
+	fmt.Println("  peers  [add <id>=<host>:<raftPort>[:<clientPort>] | remove <id> | transfer [<id>]]")
+	fmt.Println("                               list, add or remove cluster members,")
+	fmt.Println("                               or move leadership")
+	fmt.Println("  getAcl [-c C] <path>         show a znode's ACL")
+	fmt.Println("  setAcl [-v N] <path> <acl>   replace a znode's ACL (only at aversion N)")
+	fmt.Println("  whoami                       show the identities the server sees")
+	fmt.Println("  admin  ruok|stat|state|metrics")
+	fmt.Println("                               ask each server about itself: health,")
+	fmt.Println("                               status, status as JSON, Prometheus metrics")
+	fmt.Println("  digest <user>:<password>     print the digest id, no server needed")
+	fmt.Println("  shell                        interactive shell (also with no command):")
+	fmt.Println("                               cd, ls -R, tree, rmr, cp, export, import; help")
+	fmt.Println()
+	fmt.Println("reads take -c local (default), read_index or lease; see docs/05")
 }
@@ -0,0 +1,738 @@
+package main
+
+// The interactive shell: zkcli --server ... shell, or no command at all.
+//
+//	zk:/> cd app
+//	zk:/app> tree
+//	/app
+//	├── config
+//	│   └── db
+//	└── workers
+//	zk:/app> cp config /backup/config
+//	copied 2 node(s) to /backup/config
+//	zk:/app> export . /tmp/app.yaml
+//	exported 4 node(s) to /tmp/app.yaml
+//
+// Paths are relative to the current node (cd, pwd); "..", "." and
+// absolute paths work as in a file system. Tab completes command names
+// and znode paths — each Tab on a path is one GetChildren. Up and down
+// walk the history, which is kept in ~/.zkcli_history across sessions.
+//
+// Every command is one of the usual zkcli calls, with the same retries
+// and redirects (client.do). An error is printed and the shell goes on.
+//
+// With stdin not a terminal, the shell reads commands from it with no
+// prompt or editing, and stops at the first error — a script whose cd
+// failed must not go on to rmr a relative path:
+//
+//	zkcli --server localhost:2181 shell < setup.zk
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+	"google.golang.org/grpc/status"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+)
+
+const (
+	// historyFile, in the home directory, keeps the shell's history.
+	historyFile = ".zkcli_history"
+
+	// historyKeep is how many lines of it are kept.
+	historyKeep = 1000
+)
+
+// shellCommand is one command of the shell.
+type shellCommand struct {
+	name  string
+	usage string
+	help  string
+	run   func(sh *shell, args []string) error
+
+	// local is the argument (from 1) that's a local file, not a znode
+	// path; Tab doesn't complete it. 0: none.
+	local int
+}
+
+var shellCommands = []shellCommand{
+	{name: "pwd", usage: "pwd", help: "print the current node", run: (*shell).pwd},
+	{name: "cd", usage: "cd [path|-]", help: "change the current node (none: /, -: the previous one)", run: (*shell).cd},
+	{name: "ls", usage: "ls [-R] [path]", help: "list children (-R: the whole branch)", run: (*shell).ls},
+	{name: "tree", usage: "tree [path]", help: "draw the branch", run: (*shell).tree},
+	{name: "stat", usage: "stat [path]", help: "show a znode's Stat", run: (*shell).stat},
+	{name: "get", usage: "get [path]", help: "read a znode's data", run: (*shell).get},
+	{name: "set", usage: "set [-v N] <path> <data>", help: "update a znode's data (only at version N)", run: (*shell).set},
+	{name: "create", usage: "create [-s] <path> [data]", help: "create a znode (-s: sequential)", run: (*shell).create},
+	{name: "delete", usage: "delete [-v N] <path>", help: "delete a childless znode (only at version N)", run: (*shell).delete},
+	{name: "rmr", usage: "rmr <path>", help: "delete a znode and everything under it", run: (*shell).rmr},
+	{name: "cp", usage: "cp <from> <to>", help: "copy a branch to a new path", run: (*shell).cp},
+	{name: "export", usage: "export <path> <file>", help: "write a branch to a .json or .yaml file", run: (*shell).export, local: 2},
+	{name: "import", usage: "import <file> [path]", help: "create a branch from a file (at the path it came from)", run: (*shell).importFile, local: 1},
+}
+
+// errUsage is what a command returns when its arguments are wrong.
+var errUsage = errors.New("usage")
+
+// shell is the state of one interactive session.
+type shell struct {
+	c   *client
+	out io.Writer
+
+	// cwd is the current node; prev is the one before the last cd.
+	cwd  string
+	prev string
+
+	// listing is where Tab shows the choices: the terminal.
+	listing io.Writer
+}
+
+func newShell(c *client, out io.Writer) *shell {
+	return &shell{c: c, out: out, cwd: "/", prev: "/", listing: io.Discard}
+}
+
+// cmdShell runs the shell on stdin.
+func cmdShell(c *client, args []string) {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "usage: shell")
+		os.Exit(1)
+	}
+
+	sh := newShell(c, os.Stdout)
+	var err error
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		err = sh.interactive(os.Stdin)
+	} else {
+		err = sh.run(os.Stdin)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", status.Convert(err).Message())
+		os.Exit(1)
+	}
+}
+
+// interactive reads commands from a terminal, with line editing,
+// history and completion, until exit or Ctrl-D.
+//
+// The terminal is raw only while a line is read: a command's output,
+// and Ctrl-C while it runs, work as for any other zkcli command.
+func (sh *shell) interactive(in *os.File) error {
+	fd := int(in.Fd())
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{in, sh.out}, "")
+	t.AutoCompleteCallback = sh.complete
+	sh.listing = t
+
+	history := openHistory(t.History)
+	if history != nil {
+		defer history.Close()
+	}
+
+	for {
+		t.SetPrompt("zk:" + sh.cwd + "> ")
+		old, err := term.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		// 0 (unknown) would wrap after every character.
+		if width, height, err := term.GetSize(fd); err == nil && width > 0 {
+			t.SetSize(width, height)
+		}
+		line, err := t.ReadLine()
+		term.Restore(fd, old)
+		if err == io.EOF {
+			fmt.Fprintln(sh.out)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if history != nil && strings.TrimSpace(line) != "" {
+			fmt.Fprintln(history, line)
+		}
+		exit, err := sh.exec(line)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", status.Convert(err).Message())
+		}
+		if exit {
+			return nil
+		}
+	}
+}
+
+// run reads commands from r, one per line, until exit, the end of r or
+// the first error.
+func (sh *shell) run(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		exit, err := sh.exec(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s: %s", strings.TrimSpace(scanner.Text()), status.Convert(err).Message())
+		}
+		if exit {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// exec runs one line. exit is set for exit and quit.
+func (sh *shell) exec(line string) (exit bool, err error) {
+	args, err := splitArgs(line)
+	if err != nil || len(args) == 0 || strings.HasPrefix(args[0], "#") {
+		return false, err
+	}
+
+	switch args[0] {
+	case "exit", "quit":
+		return true, nil
+	case "help":
+		sh.help()
+		return false, nil
+	}
+	cmd, ok := lookupShellCommand(args[0])
+	if !ok {
+		return false, fmt.Errorf("unknown command %q (try help)", args[0])
+	}
+	if err := cmd.run(sh, args[1:]); err != errUsage {
+		return false, err
+	}
+	return false, fmt.Errorf("usage: %s", cmd.usage)
+}
+
+func lookupShellCommand(name string) (shellCommand, bool) {
+	for _, cmd := range shellCommands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return shellCommand{}, false
+}
+
+func (sh *shell) help() {
+	for _, cmd := range shellCommands {
+		fmt.Fprintf(sh.out, "  %-26s %s\n", cmd.usage, cmd.help)
+	}
+	fmt.Fprintf(sh.out, "  %-26s %s\n", "help", "this list")
+	fmt.Fprintf(sh.out, "  %-26s %s\n", "exit", "leave the shell (or Ctrl-D)")
+	fmt.Fprintf(sh.out, "\nrmr, cp and import write the whole branch at once, all or nothing:\nat most %d nodes and %s. Do a bigger branch a piece at a time.\n",
+		maxMultiOps, formatBytes(maxMultiBytes))
+}
+
+// resolve turns a path typed in the shell into an absolute znode path.
+func (sh *shell) resolve(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = sh.cwd + "/" + p
+	}
+	return path.Clean(p)
+}
+
+// pathArg resolves the optional path argument of a read: the current
+// node without one.
+func (sh *shell) pathArg(args []string) (string, error) {
+	switch len(args) {
+	case 0:
+		return sh.cwd, nil
+	case 1:
+		return sh.resolve(args[0]), nil
+	}
+	return "", errUsage
+}
+
+func (sh *shell) pwd(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	fmt.Fprintln(sh.out, sh.cwd)
+	return nil
+}
+
+func (sh *shell) cd(args []string) error {
+	target := "/"
+	switch {
+	case len(args) > 1:
+		return errUsage
+	case len(args) == 1 && args[0] == "-":
+		target = sh.prev
+	case len(args) == 1:
+		target = sh.resolve(args[0])
+	}
+	// Only a node that exists.
+	if _, err := getChildren(sh.c, target); err != nil {
+		return err
+	}
+	sh.prev, sh.cwd = sh.cwd, target
+	return nil
+}
+
+func (sh *shell) ls(args []string) error {
+	fs := flag.NewFlagSet("ls", flag.ContinueOnError)
+	recursive := fs.Bool("R", false, "the whole branch")
+	args, err := parseShellFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	p, err := sh.pathArg(args)
+	if err != nil {
+		return err
+	}
+
+	if *recursive {
+		n, err := readTree(sh.c, p, false)
+		if err != nil {
+			return err
+		}
+		printPaths(sh.out, n, p)
+		return nil
+	}
+
+	children, err := getChildren(sh.c, p)
+	if err != nil {
+		return err
+	}
+	if len(children) == 0 {
+		fmt.Fprintln(sh.out, "(no children)")
+	}
+	for _, child := range children {
+		fmt.Fprintln(sh.out, child)
+	}
+	return nil
+}
+
+// printPaths prints every path in the branch at p, p first.
+func printPaths(w io.Writer, n *treeNode, p string) {
+	fmt.Fprintln(w, p)
+	for _, name := range n.names() {
+		printPaths(w, n.Children[name], path.Join(p, name))
+	}
+}
+
+func (sh *shell) tree(args []string) error {
+	p, err := sh.pathArg(args)
+	if err != nil {
+		return err
+	}
+	n, err := readTree(sh.c, p, false)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(sh.out, p)
+	printTree(sh.out, n, "")
+	return nil
+}
+
+// printTree draws n's children, each line starting with indent.
+func printTree(w io.Writer, n *treeNode, indent string) {
+	names := n.names()
+	for i, name := range names {
+		branch, next := "├── ", "│   "
+		if i == len(names)-1 {
+			branch, next = "└── ", "    "
+		}
+		fmt.Fprintf(w, "%s%s%s\n", indent, branch, name)
+		printTree(w, n.Children[name], indent+next)
+	}
+}
+
+func (sh *shell) stat(args []string) error {
+	resp, err := sh.getNode(args)
+	if err != nil {
+		return err
+	}
+	printStat(sh.out, resp.Stat)
+	return nil
+}
+
+func (sh *shell) get(args []string) error {
+	resp, err := sh.getNode(args)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(sh.out, string(resp.Data))
+	return nil
+}
+
+// getNode gets the node at the optional path argument.
+func (sh *shell) getNode(args []string) (*zkpb.GetResponse, error) {
+	p, err := sh.pathArg(args)
+	if err != nil {
+		return nil, err
+	}
+	var resp *zkpb.GetResponse
+	err = sh.c.do(func(ctx context.Context, zk zkpb.ZooKeeperClient) (err error) {
+		resp, err = zk.Get(ctx, &zkpb.GetRequest{Path: p})
+		return err
+	})
+	return resp, err
+}
+
+func (sh *shell) set(args []string) error {
+	version, args, err := parseShellVersion("set", args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return errUsage
+	}
+	req := &zkpb.SetRequest{Path: sh.resolve(args[0]), Data: []byte(args[1]), Version: version}
+	err = sh.c.do(func(ctx context.Context, zk zkpb.ZooKeeperClient) error {
+		_, err := zk.Set(ctx, req)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(sh.out, "updated")
+	return nil
+}
+
+func (sh *shell) create(args []string) error {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	sequential := fs.Bool("s", false, "sequential")
+	args, err := parseShellFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 1 || len(args) > 2 {
+		return errUsage
+	}
+
+	req := &zkpb.CreateRequest{Path: sh.resolve(args[0]), Sequential: *sequential}
+	if len(args) == 2 {
+		req.Data = []byte(args[1])
+	}
+	var resp *zkpb.CreateResponse
+	err = sh.c.do(func(ctx context.Context, zk zkpb.ZooKeeperClient) (err error) {
+		resp, err = zk.Create(ctx, req)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.out, "created %s\n", resp.Path)
+	return nil
+}
+
+func (sh *shell) delete(args []string) error {
+	version, args, err := parseShellVersion("delete", args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errUsage
+	}
+	req := &zkpb.DeleteRequest{Path: sh.resolve(args[0]), Version: version}
+	err = sh.c.do(func(ctx context.Context, zk zkpb.ZooKeeperClient) error {
+		_, err := zk.Delete(ctx, req)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(sh.out, "deleted")
+	return nil
+}
+
+func (sh *shell) rmr(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	p := sh.resolve(args[0])
+	if p == "/" {
+		return errors.New("the root can't be deleted")
+	}
+	n, err := readTree(sh.c, p, false)
+	if err != nil {
+		return err
+	}
+	if err := multi(sh.c, n.deleteOps(p)); err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.out, "deleted %d node(s)\n", n.size())
+	return nil
+}
+
+func (sh *shell) cp(args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	from, to := sh.resolve(args[0]), sh.resolve(args[1])
+	n, err := readTree(sh.c, from, true)
+	if err != nil {
+		return fmt.Errorf("%s: %w", from, err)
+	}
+	ops, err := n.createOps(to)
+	if err != nil {
+		return err
+	}
+	if err := multi(sh.c, ops); err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.out, "copied %d node(s) to %s\n", len(ops), to)
+	return nil
+}
+
+func (sh *shell) export(args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	p := sh.resolve(args[0])
+	n, err := readTree(sh.c, p, true)
+	if err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+	n.Path = p
+	if err := writeTreeFile(args[1], n); err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.out, "exported %d node(s) to %s\n", n.size(), args[1])
+	return nil
+}
+
+func (sh *shell) importFile(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errUsage
+	}
+	n, err := readTreeFile(args[0])
+	if err != nil {
+		return err
+	}
+	p := n.Path
+	if len(args) == 2 {
+		p = sh.resolve(args[1])
+	}
+	if p == "" {
+		return fmt.Errorf("%s has no path: give one", args[0])
+	}
+	ops, err := n.createOps(p)
+	if err != nil {
+		return err
+	}
+	if err := multi(sh.c, ops); err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.out, "imported %d node(s) to %s\n", len(ops), p)
+	return nil
+}
+
+// complete is the terminal's AutoCompleteCallback: on Tab, it completes
+// the word before the cursor — a command name first, a znode path after.
+// One choice is filled in; several are filled in as far as they agree,
+// and listed if that's no further.
+func (sh *shell) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	head := line[:pos]
+	start := strings.LastIndexAny(head, " \t") + 1
+	word := head[start:]
+	before := strings.Fields(head[:start])
+
+	var choices []string
+	if len(before) == 0 {
+		for _, cmd := range shellCommands {
+			if strings.HasPrefix(cmd.name, word) {
+				choices = append(choices, cmd.name+" ")
+			}
+		}
+	} else {
+		cmd, ok := lookupShellCommand(before[0])
+		if !ok || strings.HasPrefix(word, "-") || cmd.local == argIndex(before) {
+			return "", 0, false
+		}
+		choices = sh.completePath(word)
+	}
+	if len(choices) == 0 {
+		return line, pos, true
+	}
+
+	fill := commonPrefix(choices)
+	if len(choices) > 1 && fill == word {
+		names := make([]string, len(choices))
+		for i, choice := range choices {
+			names[i] = path.Base(strings.TrimSpace(choice))
+		}
+		fmt.Fprintln(sh.listing, strings.Join(names, "  "))
+	}
+	return head[:start] + fill + line[pos:], start + len(fill), true
+}
+
+// argIndex is which argument (from 1) the word after before is,
+// flags not counted.
+func argIndex(before []string) int {
+	i := 1
+	for _, arg := range before[1:] {
+		if !strings.HasPrefix(arg, "-") {
+			i++
+		}
+	}
+	return i
+}
+
+// completePath returns the znode paths that word could be: its
+// directory's children whose names start with the rest. A node with
+// children gets a "/", ready for the next Tab; one without, a space.
+// Completion makes one attempt on the current server: no retries, a
+// Tab mustn't hang the prompt for a whole failover.
+func (sh *shell) completePath(word string) []string {
+	dir, prefix := "", word
+	if i := strings.LastIndex(word, "/"); i >= 0 {
+		dir, prefix = word[:i+1], word[i+1:]
+	}
+
+	list := func(p string) []string {
+		var resp *zkpb.GetChildrenResponse
+		err := sh.c.attempt(sh.c.servers[sh.c.current], func(ctx context.Context, zk zkpb.ZooKeeperClient) (err error) {
+			resp, err = zk.GetChildren(ctx, &zkpb.GetChildrenRequest{Path: p})
+			return err
+		})
+		if err != nil {
+			return nil
+		}
+		return resp.Children
+	}
+
+	base := sh.resolve(dir)
+	var choices []string
+	for _, name := range list(base) {
+		if strings.HasPrefix(name, prefix) {
+			choices = append(choices, name)
+		}
+	}
+	sort.Strings(choices)
+	if len(choices) == 1 {
+		if len(list(path.Join(base, choices[0]))) > 0 {
+			return []string{dir + choices[0] + "/"}
+		}
+		return []string{dir + choices[0] + " "}
+	}
+	for i, name := range choices {
+		choices[i] = dir + name
+	}
+	return choices
+}
+
+// commonPrefix returns the longest prefix all of words share.
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
+		}
+	}
+	return prefix
+}
+
+// splitArgs splits a line into words on spaces. Single or double
+// quotes keep spaces in a word; a backslash escapes the next character,
+// and \n in double quotes is a newline (for multi-line data).
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	var word strings.Builder
+	inWord, quote, escaped := false, rune(0), false
+	for _, r := range line {
+		switch {
+		case escaped && quote == '"' && r == 'n':
+			word.WriteRune('\n')
+			escaped = false
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or escape")
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
+
+// parseShellFlags parses a shell command's flags. Unlike the command
+// line's, a bad flag is an error for that command, not an exit.
+func parseShellFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return nil, errUsage
+	}
+	return fs.Args(), nil
+}
+
+// parseShellVersion is parseVersion for the shell.
+func parseShellVersion(command string, args []string) (*int32, []string, error) {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	v := fs.Int("v", -1, "only write if the node is at this version")
+	args, err := parseShellFlags(fs, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	var version *int32
+	fs.Visit(func(f *flag.Flag) {
+		n := int32(*v)
+		version = &n
+	})
+	return version, args, nil
+}
+
+// openHistory loads ~/.zkcli_history into h and opens it to append the
+// lines to come. Without a home directory or the file, there's no
+// history across sessions: it returns nil.
+func openHistory(h term.History) *os.File {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	name := filepath.Join(home, historyFile)
+
+	data, _ := os.ReadFile(name)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) > historyKeep {
+		lines = lines[len(lines)-historyKeep:]
+		os.WriteFile(name, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+	}
+	for _, line := range lines {
+		if line != "" {
+			h.Add(line)
+		}
+	}
+
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil
+	}
+	return f
+}
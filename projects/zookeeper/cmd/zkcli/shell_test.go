@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+	"github.com/syamsularifin/zookeeper/internal/server"
+	"github.com/syamsularifin/zookeeper/internal/store"
+)
+
+// newTestShell starts a standalone server and returns a shell on it,
+// and what the shell prints.
+func newTestShell(t *testing.T) (*shell, *bytes.Buffer) {
+	t.Helper()
+	dir := t.TempDir()
+	s, err := store.New(filepath.Join(dir, "wal.log"), filepath.Join(dir, "snapshot.json"))
+	if err != nil {
+		t.Fatalf("store.New failed: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	srv := server.New(s, 0)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	c := newClient([]string{lis.Addr().String()})
+	t.Cleanup(c.close)
+	out := &bytes.Buffer{}
+	return newShell(c, out), out
+}
+
+// script runs lines in sh and returns what they printed.
+func script(t *testing.T, sh *shell, out *bytes.Buffer, lines ...string) string {
+	t.Helper()
+	out.Reset()
+	if err := sh.run(strings.NewReader(strings.Join(lines, "\n"))); err != nil {
+		t.Fatalf("script failed: %v", err)
+	}
+	return out.String()
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"", nil},
+		{"  ls   -R  /app ", []string{"ls", "-R", "/app"}},
+		{`set /app "hello world"`, []string{"set", "/app", "hello world"}},
+		{`set /app '{"a": "b c"}'`, []string{"set", "/app", `{"a": "b c"}`}},
+		{`set /app ""`, []string{"set", "/app", ""}},
+		{`create /my\ node`, []string{"create", "/my node"}},
+		{`set /app "a\nb" 'c\nd'`, []string{"set", "/app", "a\nb", `c\nd`}},
+	}
+	for _, tt := range tests {
+		got, err := splitArgs(tt.line)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitArgs(%q) = %q, %v; want %q", tt.line, got, err, tt.want)
+		}
+	}
+	if _, err := splitArgs(`set /app "oops`); err == nil {
+		t.Error("an unterminated quote should be an error")
+	}
+}
+
+func TestShell_Browse(t *testing.T) {
+	sh, out := newTestShell(t)
+	script(t, sh, out,
+		"create /app hello",
+		"create /app/config",
+		"create /app/config/db 'pg-1:5432'",
+		"create /app/workers",
+	)
+
+	got := script(t, sh, out, "cd app", "pwd", "ls", "get config/db", "cd config/../..", "pwd", "cd -", "pwd")
+	if want := "/app\nconfig\nworkers\npg-1:5432\n/\n/app\n"; got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	got = script(t, sh, out, "ls -R")
+	if want := "/app\n/app/config\n/app/config/db\n/app/workers\n"; got != want {
+		t.Fatalf("ls -R got:\n%s\nwant:\n%s", got, want)
+	}
+
+	got = script(t, sh, out, "tree /app")
+	want := "/app\n" +
+		"├── config\n" +
+		"│   └── db\n" +
+		"└── workers\n"
+	if got != want {
+		t.Fatalf("tree got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if got := script(t, sh, out, "stat config"); !strings.Contains(got, "numChildren    = 1") {
+		t.Fatalf("stat got:\n%s", got)
+	}
+	if err := sh.run(strings.NewReader("cd /nope")); err == nil || sh.cwd != "/app" {
+		t.Fatalf("cd to a missing node should fail and stay in /app: %v, %s", err, sh.cwd)
+	}
+}
+
+func TestShell_CopyAndRmr(t *testing.T) {
+	sh, out := newTestShell(t)
+	script(t, sh, out, "create /app v1", "create /app/config", "create /app/config/db pg-1", "create /backup")
+
+	// An ephemeral node belongs to its session: not copied.
+	err := sh.c.do(func(ctx context.Context, zk zkpb.ZooKeeperClient) error {
+		sess, err := zk.CreateSession(ctx, &zkpb.CreateSessionRequest{})
+		if err != nil {
+			return err
+		}
+		_, err = zk.Create(ctx, &zkpb.CreateRequest{Path: "/app/lock", Ephemeral: true, SessionId: sess.SessionId, SessionPassword: sess.Password})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("ephemeral create failed: %v", err)
+	}
+
+	if got := script(t, sh, out, "cp /app /backup/app"); got != "copied 3 node(s) to /backup/app\n" {
+		t.Fatalf("cp got %q", got)
+	}
+	if got := script(t, sh, out, "ls -R /backup", "get /backup/app/config/db"); got != "/backup\n/backup/app\n/backup/app/config\n/backup/app/config/db\npg-1\n" {
+		t.Fatalf("after cp got:\n%s", got)
+	}
+
+	// Copying onto an existing node changes nothing.
+	err = sh.run(strings.NewReader("cp /app/config /backup/app"))
+	if err == nil || !strings.Contains(err.Error(), "create /backup/app") || !strings.Contains(err.Error(), "nothing changed") {
+		t.Fatalf("cp onto an existing node: %v", err)
+	}
+
+	// rmr takes the ephemeral node with it.
+	if got := script(t, sh, out, "rmr /app", "ls /"); got != "deleted 4 node(s)\nbackup\n" {
+		t.Fatalf("rmr got %q", got)
+	}
+	if err := sh.run(strings.NewReader("rmr /")); err == nil {
+		t.Fatal("rmr / should be refused")
+	}
+}
+
+func TestShell_ExportImport(t *testing.T) {
+	for _, ext := range []string{".json", ".yaml"} {
+		t.Run(ext, func(t *testing.T) {
+			sh, out := newTestShell(t)
+			script(t, sh, out, "create /app", "create /app/empty")
+			binary := []byte{0xff, 0x00, 0xfe}
+			err := sh.c.do(func(ctx context.Context, zk zkpb.ZooKeeperClient) error {
+				if _, err := zk.Create(ctx, &zkpb.CreateRequest{Path: "/app/config", Data: []byte("line 1\nline 2")}); err != nil {
+					return err
+				}
+				_, err := zk.Create(ctx, &zkpb.CreateRequest{Path: "/app/bin", Data: binary})
+				return err
+			})
+			if err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+
+			file := filepath.Join(t.TempDir(), "app"+ext)
+			if got := script(t, sh, out, "cd /app", "export . "+file); got != "exported 4 node(s) to "+file+"\n" {
+				t.Fatalf("export got %q", got)
+			}
+			data, _ := os.ReadFile(file)
+			if !strings.Contains(string(data), "base64") {
+				t.Fatalf("binary data should be base64:\n%s", data)
+			}
+
+			// Back where it came from, and somewhere else.
+			script(t, sh, out, "cd /", "rmr /app", "import "+file, "import "+file+" /copy")
+			for _, p := range []string{"/app", "/copy"} {
+				n, err := readTree(sh.c, p, true)
+				if err != nil {
+					t.Fatalf("readTree(%s) failed: %v", p, err)
+				}
+				got, _ := n.Children["bin"].data()
+				if n.size() != 4 || n.Children["config"].Data != "line 1\nline 2" || !bytes.Equal(got, binary) {
+					t.Fatalf("%s after import: %+v, bin %x", p, n, got)
+				}
+			}
+		})
+	}
+}
+
+// TestShell_BranchTooBig proves a branch that doesn't fit in one Multi
+// is refused up front, with nothing written: by node count and by size.
+func TestShell_BranchTooBig(t *testing.T) {
+	sh, out := newTestShell(t)
+	dir := t.TempDir()
+
+	many := &treeNode{Path: "/many", Children: map[string]*treeNode{}}
+	for i := 0; i < maxMultiOps; i++ {
+		many.Children[fmt.Sprintf("n%d", i)] = &treeNode{}
+	}
+	big := &treeNode{Path: "/big", Children: map[string]*treeNode{
+		"a": {Data: strings.Repeat("x", maxMultiBytes/2)},
+		"b": {Data: strings.Repeat("x", maxMultiBytes/2)},
+	}}
+
+	for _, n := range []*treeNode{many, big} {
+		file := filepath.Join(dir, n.Path[1:]+".json")
+		if err := writeTreeFile(file, n); err != nil {
+			t.Fatalf("writeTreeFile failed: %v", err)
+		}
+		err := sh.run(strings.NewReader("import " + file))
+		if err == nil || !strings.Contains(err.Error(), "too big to write at once") {
+			t.Fatalf("import %s: expected a too big error, got %v", n.Path, err)
+		}
+		if got := script(t, sh, out, "ls /"); got != "(no children)\n" {
+			t.Fatalf("import %s must write nothing, / has %q", n.Path, got)
+		}
+	}
+
+	// One node less fits.
+	delete(many.Children, "n0")
+	file := filepath.Join(dir, "fits.json")
+	writeTreeFile(file, many)
+	if got := script(t, sh, out, "import "+file); got != fmt.Sprintf("imported %d node(s) to /many\n", maxMultiOps) {
+		t.Fatalf("import got %q", got)
+	}
+}
+
+func TestShell_ImportRejectsUnknownFields(t *testing.T) {
+	sh, _ := newTestShell(t)
+	file := filepath.Join(t.TempDir(), "app.json")
+	os.WriteFile(file, []byte(`{"path": "/app", "childs": {"a": {}}}`), 0644)
+	if err := sh.run(strings.NewReader("import " + file)); err == nil || !strings.Contains(err.Error(), "childs") {
+		t.Fatalf("expected an unknown field error, got %v", err)
+	}
+}
+
+func TestShell_Complete(t *testing.T) {
+	sh, out := newTestShell(t)
+	script(t, sh, out, "create /app", "create /app/config", "create /app/config/db", "create /app/cache", "create /apple")
+	listing := &bytes.Buffer{}
+	sh.listing = listing
+
+	tests := []struct {
+		line, want string
+	}{
+		{"tr", "tree "},
+		{"ls /app/con", "ls /app/config/"}, // has children: ready for the next Tab
+		{"ls /app/config/d", "ls /app/config/db "},
+		{"ls /ap", "ls /app"},              // /app and /apple
+		{"cd app/ca", "cd app/cache "},     // relative to /
+		{"export /app/c", "export /app/c"}, // config and cache: listed
+	}
+	for _, tt := range tests {
+		got, pos, ok := sh.complete(tt.line, len(tt.line), '\t')
+		if !ok || got != tt.want || pos != len(tt.want) {
+			t.Errorf("complete(%q) = %q, %d, %v; want %q", tt.line, got, pos, ok, tt.want)
+		}
+	}
+	if !strings.Contains(listing.String(), "cache  config") {
+		t.Errorf("expected cache and config listed, got %q", listing.String())
+	}
+
+	// Only Tab completes, and only znode paths.
+	if _, _, ok := sh.complete("ls /ap", 6, 'x'); ok {
+		t.Error("a key other than Tab should be left to the terminal")
+	}
+	if _, _, ok := sh.complete("import /app/con", 15, '\t'); ok {
+		t.Error("import's file is a local path, not a znode")
+	}
+
+	// The rest of the line stays.
+	if got, pos, _ := sh.complete("get /app/con extra", 12, '\t'); got != "get /app/config/ extra" || pos != 16 {
+		t.Errorf("completing mid-line got %q, %d", got, pos)
+	}
+}
+
+func TestShell_ScriptStopsAtFirstError(t *testing.T) {
+	sh, out := newTestShell(t)
+	script(t, sh, out, "create /keep", "create /keep/me")
+
+	err := sh.run(strings.NewReader("cd /typo\nrmr keep\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "cd /typo:") {
+		t.Fatalf("expected the cd to fail, got %v", err)
+	}
+	if got := script(t, sh, out, "ls /keep"); got != "me\n" {
+		t.Fatalf("the rmr after a failed cd must not run, /keep has %q", got)
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+// Subtrees: reading a whole branch of the tree, and writing one back.
+// The shell's ls -R, tree, rmr, cp, export and import are built on them.
+//
+// READING walks the branch depth-first, one GetChildren per node — and
+// one Get too when the data is wanted (cp, export):
+//
+//	readTree("/app", true)
+//	  Get /app, GetChildren /app → [config, workers]
+//	    Get /app/config,  GetChildren /app/config  → []
+//	    Get /app/workers, GetChildren /app/workers → [w1]
+//	      ...
+//
+// It isn't a snapshot: a write that lands mid-walk may or may not be
+// in it, and a node deleted mid-walk is just left out. With data,
+// ephemeral nodes are left out too: they belong to a session, and a
+// copy would be a persistent node nobody cleans up.
+//
+// WRITING is one Multi: import and cp create every node or none, rmr
+// deletes every node or none. If the branch changed since it was read
+// (a child appeared under a node rmr deletes), the Multi fails and
+// nothing changes — run it again.
+//
+// One Multi is one gRPC message and one WAL entry, so a branch has to
+// fit in one: at most maxMultiOps nodes and maxMultiBytes of request.
+// A bigger one is refused before anything is sent, and nothing changes.
+// It isn't split into several Multis: a copy or delete that stops
+// halfway, with no way to undo it, is worse than one that says no. Do
+// a big branch a piece at a time — its subtrees first.
+//
+// THE FILE FORMAT (export, import) is the branch as nested objects,
+// JSON or YAML by the file's extension:
+//
+//	path: /app
+//	data: hello
+//	children:
+//	  config:
+//	    data: '{"db": "pg-1"}'
+//	  workers: {}
+//
+// Data that isn't UTF-8 is base64, with encoding: base64. ACLs and
+// Stats aren't kept: an imported node gets the default ACL and a new
+// Stat, like any node you create.
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+	"github.com/syamsularifin/zookeeper/internal/server"
+)
+
+const (
+	// maxMultiOps is the most nodes rmr, cp and import write at once.
+	maxMultiOps = 1000
+
+	// maxMultiBytes is the biggest Multi they send: well under gRPC's
+	// 4 MiB default message limit, with room for the Raft entry around it.
+	maxMultiBytes = 1 << 20
+)
+
+// treeNode is a znode and everything under it.
+type treeNode struct {
+	// Path is only set on the top node of an export: where it came from.
+	Path     string               `json:"path,omitempty" yaml:"path,omitempty"`
+	Data     string               `json:"data,omitempty" yaml:"data,omitempty"`
+	Encoding string               `json:"encoding,omitempty" yaml:"encoding,omitempty"`
+	Children map[string]*treeNode `json:"children,omitempty" yaml:"children,omitempty"`
+}
+
+// errEphemeral is what readTree returns for an ephemeral node, with data.
+var errEphemeral = errors.New("ephemeral node")
+
+// readTree reads the branch at p. With data, it also gets each node's
+// data and leaves ephemeral nodes out; without, it only lists children.
+func readTree(c *client, p string, data bool) (*treeNode, error) {
+	n := &treeNode{}
+	if data {
+		var resp *zkpb.GetResponse
+		err := c.do(func(ctx context.Context, zk zkpb.ZooKeeperClient) (err error) {
+			resp, err = zk.Get(ctx, &zkpb.GetRequest{Path: p})
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if resp.Stat.GetEphemeralOwner() != 0 {
+			return nil, errEphemeral
+		}
+		n.setData(resp.Data)
+	}
+
+	children, err := getChildren(c, p)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range children {
+		child, err := readTree(c, path.Join(p, name), data)
+		switch {
+		case errors.Is(err, errEphemeral), status.Code(err) == codes.NotFound:
+			continue // ephemeral, or deleted since we listed it
+		case err != nil:
+			return nil, err
+		}
+		if n.Children == nil {
+			n.Children = make(map[string]*treeNode)
+		}
+		n.Children[name] = child
+	}
+	return n, nil
+}
+
+// getChildren lists p's children, sorted.
+func getChildren(c *client, p string) ([]string, error) {
+	var resp *zkpb.GetChildrenResponse
+	err := c.do(func(ctx context.Context, zk zkpb.ZooKeeperClient) (err error) {
+		resp, err = zk.GetChildren(ctx, &zkpb.GetChildrenRequest{Path: p})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(resp.Children)
+	return resp.Children, nil
+}
+
+// names returns n's children's names, sorted.
+func (n *treeNode) names() []string {
+	names := make([]string, 0, len(n.Children))
+	for name := range n.Children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// size counts the nodes in the branch, n included.
+func (n *treeNode) size() int {
+	size := 1
+	for _, child := range n.Children {
+		if child != nil {
+			size += child.size()
+		}
+	}
+	return size
+}
+
+func (n *treeNode) setData(data []byte) {
+	if utf8.Valid(data) {
+		n.Data, n.Encoding = string(data), ""
+		return
+	}
+	n.Data, n.Encoding = base64.StdEncoding.EncodeToString(data), "base64"
+}
+
+func (n *treeNode) data() ([]byte, error) {
+	switch n.Encoding {
+	case "":
+		return []byte(n.Data), nil
+	case "base64":
+		return base64.StdEncoding.DecodeString(n.Data)
+	}
+	return nil, fmt.Errorf("unknown encoding %q", n.Encoding)
+}
+
+// createOps returns the creates that make the branch at p: parents
+// before children, so the Multi can apply them in order.
+func (n *treeNode) createOps(p string) ([]*zkpb.Op, error) {
+	data, err := n.data()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+	ops := []*zkpb.Op{{Op: &zkpb.Op_Create{Create: &zkpb.CreateRequest{Path: p, Data: data}}}}
+	for _, name := range n.names() {
+		if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("%s: bad child name %q", p, name)
+		}
+		child := n.Children[name]
+		if child == nil {
+			child = &treeNode{} // "name: null" in the file
+		}
+		more, err := child.createOps(path.Join(p, name))
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, more...)
+	}
+	return ops, nil
+}
+
+// deleteOps returns the deletes that remove the branch at p: children
+// before parents.
+func (n *treeNode) deleteOps(p string) []*zkpb.Op {
+	var ops []*zkpb.Op
+	for _, name := range n.names() {
+		ops = append(ops, n.Children[name].deleteOps(path.Join(p, name))...)
+	}
+	return append(ops, &zkpb.Op{Op: &zkpb.Op_Delete{Delete: &zkpb.DeleteRequest{Path: p}}})
+}
+
+// multi applies ops as one write. If one fails, the error says which.
+// Too many ops, or too many bytes, and it sends nothing.
+func multi(c *client, ops []*zkpb.Op) error {
+	req := &zkpb.MultiRequest{Ops: ops}
+	if size := proto.Size(req); len(ops) > maxMultiOps || size > maxMultiBytes {
+		return fmt.Errorf("%d node(s), %s: too big to write at once (at most %d nodes, %s); nothing changed — do it a piece at a time",
+			len(ops), formatBytes(int64(size)), maxMultiOps, formatBytes(maxMultiBytes))
+	}
+	err := c.do(func(ctx context.Context, zk zkpb.ZooKeeperClient) error {
+		_, err := zk.Multi(ctx, req)
+		return err
+	})
+	if i, ok := server.FailedOp(err); ok && i < len(ops) {
+		op := ops[i]
+		what := "create " + op.GetCreate().GetPath()
+		if d := op.GetDelete(); d != nil {
+			what = "delete " + d.Path
+		}
+		return fmt.Errorf("%s: %s (nothing changed)", what, status.Convert(err).Message())
+	}
+	return err
+}
+
+// isYAML reports whether a file is YAML by its extension; anything
+// else is JSON.
+func isYAML(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+func writeTreeFile(name string, n *treeNode) error {
+	var data []byte
+	var err error
+	if isYAML(name) {
+		var b bytes.Buffer
+		enc := yaml.NewEncoder(&b)
+		enc.SetIndent(2)
+		err = enc.Encode(n)
+		data = b.Bytes()
+	} else {
+		data, err = json.MarshalIndent(n, "", "  ")
+		data = append(data, '\n')
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, 0644)
+}
+
+// readTreeFile reads an export. Unknown fields are an error: a typo
+// like "childs" would otherwise import a branch without its children.
+func readTreeFile(name string) (*treeNode, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	n := &treeNode{}
+	if isYAML(name) {
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		err = dec.Decode(n)
+	} else {
+		dec := json.NewDecoder(f)
+		dec.DisallowUnknownFields()
+		err = dec.Decode(n)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return n, nil
+}
@@ -11,15 +11,70 @@ package main
 //   3. Start the gRPC server on the given port
 //   4. Wait for Ctrl+C
 //   5. On shutdown: take final snapshot + close WAL
+//      (in cluster mode, a leader first hands leadership to a follower)
+//
+// Cluster mode (3 nodes on one machine, one terminal each):
+//
+//   PEERS=node-1=localhost:3001:2181,node-2=localhost:3002:2182,node-3=localhost:3003:2183
+//   go run ./cmd/zknode --node-id node-1 --peers $PEERS --port 2181 --data-dir ./data1
+//   go run ./cmd/zknode --node-id node-2 --peers $PEERS --port 2182 --data-dir ./data2
+//   go run ./cmd/zknode --node-id node-3 --peers $PEERS --port 2183 --data-dir ./data3
+//
+// Each peer entry is <id>=<host>:<raftPort>:<clientPort>. The node listens
+// for Raft traffic on its own raftPort and for clients on --port.
+// In cluster mode, every write goes through Raft before it's acknowledged.
+//
+// Adding a fourth node to the running cluster: start it with --join and
+// the existing peers plus itself, then ask the cluster to take it in:
+//
+//   go run ./cmd/zknode --node-id node-4 --join --peers $PEERS,node-4=localhost:3004:2184 --port 2184 --data-dir ./data4
+//   go run ./cmd/zkcli --server localhost:2181 peers add node-4=localhost:3004:2184
+//
+// With --join the node starts as a learner: it never campaigns, it just
+// waits for the leader to replicate to it. Once it's a member, the
+// committed membership is kept in its data dir — restart it without
+// --join, and with whatever --peers; the data dir wins.
+//
+// Securing the client port (see docs/05-grpc-server.md):
+//
+//   go run ./cmd/zknode --tls-cert node-1.crt --tls-key node-1.key --tls-ca ca.crt \
+//     --super-digest super:0bX78nEegDzJM3ju0iLBEJdWzjg= ...   # zkcli digest super:pw
+//
+// Clients then connect over TLS; a client certificate signed by --tls-ca
+// makes its common name the caller's x509 identity.
+//
+// In a cluster the same certificate secures the Raft port too, in both
+// directions, and is the node's client certificate when it forwards a
+// call. So it needs CN=<node id>, the URI SAN zk-node:<node id> that
+// marks it as a node's, and both server and client key usage. The Raft
+// port then only talks to members' node certificates.
+//
+// Monitoring over HTTP (the same is on the Admin gRPC service, see
+// zkcli admin):
+//
+//   go run ./cmd/zknode --admin-addr localhost:8081 ...
+//   curl localhost:8081/healthz    # imok
+//   curl localhost:8081/readyz     # 503 while there's no leader
+//   curl localhost:8081/state      # role, term, commit index, lag, WAL size
+//   curl localhost:8081/metrics    # Prometheus
+//
+// The admin address has no TLS and no authentication: keep it on
+// localhost or a private network.
 
 import (
 	"flag"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"syscall"
 
+	"google.golang.org/grpc"
+
+	"github.com/syamsularifin/zookeeper/api/proto/raftpb"
+	"github.com/syamsularifin/zookeeper/internal/cluster"
 	"github.com/syamsularifin/zookeeper/internal/server"
 	"github.com/syamsularifin/zookeeper/internal/store"
 )
@@ -27,8 +82,25 @@ import (
 func main() {
 	port := flag.Int("port", 2181, "gRPC listen port")
 	dataDir := flag.String("data-dir", "./data", "directory for WAL and snapshot files")
+	nodeID := flag.String("node-id", "", "this node's ID (enables cluster mode, requires --peers)")
+	peers := flag.String("peers", "", "all cluster nodes: id=host:raftPort:clientPort,...")
+	join := flag.Bool("join", false, "start as a learner, waiting to be added to a running cluster")
+	defaults := store.DefaultOptions()
+	snapEvery := flag.Int("snapshot-every", defaults.SnapshotEveryWrites, "take a snapshot after this many writes (0 = off)")
+	snapBytes := flag.Int64("snapshot-bytes", defaults.SnapshotEveryBytes, "take a snapshot after this many bytes written (0 = off)")
+	retain := flag.Int64("retain-entries", defaults.RetainEntries, "WAL entries to keep behind a snapshot for slow followers")
+	tlsCert := flag.String("tls-cert", "", "serve clients over TLS with this certificate (PEM)")
+	tlsKey := flag.String("tls-key", "", "the --tls-cert private key (PEM)")
+	tlsCA := flag.String("tls-ca", "", "CA that signs client and node certificates (PEM)")
+	superDigest := flag.String("super-digest", "", "user:hash of a superuser that passes every ACL (see zkcli digest)")
+	adminAddr := flag.String("admin-addr", "", "serve /healthz, /readyz, /state and /metrics over HTTP on this address (empty = off)")
 	flag.Parse()
 
+	if (*nodeID == "") != (*peers == "") {
+		fmt.Fprintln(os.Stderr, "--node-id and --peers must be used together")
+		os.Exit(1)
+	}
+
 	// Ensure data directory exists
 	if err := os.MkdirAll(*dataDir, 0755); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to create data dir: %v\n", err)
@@ -39,29 +111,167 @@ func main() {
 	snapPath := filepath.Join(*dataDir, "snapshot.json")
 
 	// Create the Store — this recovers from existing snapshot + WAL
-	s, err := store.New(walPath, snapPath)
+	s, err := store.NewWithOptions(walPath, snapPath, store.Options{
+		SnapshotEveryWrites: *snapEvery,
+		SnapshotEveryBytes:  *snapBytes,
+		RetainEntries:       *retain,
+	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to create store: %v\n", err)
 		os.Exit(1)
 	}
 
+	sec, err := security(*tlsCert, *tlsKey, *tlsCA, *superDigest)
+	if err == nil && *nodeID != "" && sec.TLS != nil && *tlsCA == "" {
+		err = fmt.Errorf("in cluster mode --tls-cert needs --tls-ca: it signs the other nodes' certificates")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to set up TLS: %v\n", err)
+		os.Exit(1)
+	}
+
+	// In cluster mode, start Raft before accepting clients.
+	// stopRaft is a no-op in standalone mode.
+	srv := server.New(s, *port)
+	stopRaft := func() {}
+	if *nodeID != "" {
+		node, stop, err := startCluster(cluster.NodeID(*nodeID), *peers, *join, *dataDir, s, sec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to start cluster mode: %v\n", err)
+			os.Exit(1)
+		}
+		srv = server.NewCluster(s, node, *port)
+		stopRaft = stop
+	}
+	srv.SetSecurity(sec)
+
+	if *adminAddr != "" {
+		lis, err := net.Listen("tcp", *adminAddr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to listen on --admin-addr: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("admin endpoints on http://%s\n", lis.Addr())
+		go http.Serve(lis, srv.AdminHandler())
+	}
+
 	// Handle Ctrl+C (SIGINT) and container stop (SIGTERM).
-	// When the signal arrives, we close the store (takes final snapshot)
-	// and exit cleanly.
+	// When the signal arrives, we stop Raft, close the store
+	// (takes final snapshot) and exit cleanly.
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
 	go func() {
 		sig := <-sigCh
 		fmt.Printf("\nreceived %s, shutting down...\n", sig)
+		stopRaft()
 		s.Close()
 		os.Exit(0)
 	}()
 
 	// Start the gRPC server — this blocks forever
-	srv := server.New(s, *port)
 	if err := srv.Start(); err != nil {
 		fmt.Fprintf(os.Stderr, "server failed: %v\n", err)
 		os.Exit(1)
 	}
 }
+
+// security builds the server's Security from the TLS flags. Without
+// --tls-cert the client port is plaintext.
+func security(certFile, keyFile, caFile, superDigest string) (server.Security, error) {
+	sec := server.Security{SuperDigest: superDigest}
+	if certFile == "" {
+		if keyFile != "" || caFile != "" {
+			return sec, fmt.Errorf("--tls-key and --tls-ca need --tls-cert")
+		}
+		return sec, nil
+	}
+
+	var err error
+	if sec.TLS, err = server.LoadServerTLS(certFile, keyFile, caFile); err != nil {
+		return sec, err
+	}
+	// Forwarding to the leader: the same certificate, as a client.
+	if sec.PeerTLS, err = server.LoadClientTLS(caFile, certFile, keyFile); err != nil {
+		return sec, err
+	}
+	return sec, nil
+}
+
+// startCluster wires up the Raft side of a cluster node:
+//
+//  1. Parse --peers into a cluster.Config (with --join, we're a learner)
+//  2. Create a GRPCTransport (how we talk to peers)
+//  3. Create the RaftNode on top of the Store (reloads term + vote)
+//  4. Serve the Raft gRPC service on our own raft port
+//  5. Start the Raft loop (elections, heartbeats)
+//
+// With TLS in sec, the Raft port serves and dials with the client port's
+// certificates, and only talks to members' node certificates.
+//
+// It returns the node and a function that stops everything it started.
+func startCluster(self cluster.NodeID, peerSpec string, join bool, dataDir string, s *store.Store, sec server.Security) (*cluster.RaftNode, func(), error) {
+	peers, err := cluster.ParsePeers(peerSpec)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range peers {
+		if peers[i].ID == self {
+			peers[i].Learner = join
+		}
+	}
+	cfg := cluster.Config{Self: self, Peers: peers, DataDir: dataDir}
+
+	me, ok := cfg.Peer(self)
+	if !ok {
+		return nil, nil, fmt.Errorf("node %q is not in --peers", self)
+	}
+
+	// NewRaftNode reloads our term and vote from the data dir,
+	// so a restart can't make us vote twice in the same term.
+	transport := cluster.NewGRPCTransport(0)
+	if sec.PeerTLS != nil {
+		transport.SetTLS(sec.PeerTLS)
+	}
+	node, err := cluster.NewRaftNode(cfg, transport, s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Listen on all interfaces at our raft port, not just the host in
+	// --peers. The host there is how OTHERS reach us (e.g. a container name).
+	_, raftPort, err := net.SplitHostPort(me.Addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	lis, err := net.Listen("tcp", ":"+raftPort)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to listen for raft on :%s: %w", raftPort, err)
+	}
+
+	var opts []grpc.ServerOption
+	if sec.TLS != nil {
+		opts = cluster.RaftServerOptions(sec.TLS, node)
+	}
+	raftServer := grpc.NewServer(opts...)
+	raftpb.RegisterRaftServer(raftServer, cluster.NewRaftServer(node))
+	go raftServer.Serve(lis)
+
+	fmt.Printf("raft node %s listening on :%s (%d peers)\n", self, raftPort, len(node.Members()))
+
+	node.Run()
+
+	// If we lead, hand over first: the others would otherwise wait out
+	// an election timeout before anyone takes writes again.
+	stop := func() {
+		if node.CheckLeader() == nil {
+			if err := node.TransferLeadership(""); err != nil {
+				fmt.Fprintf(os.Stderr, "shutting down without handing over: %v\n", err)
+			}
+		}
+		node.Stop()
+		raftServer.Stop()
+		transport.Close()
+	}
+	return node, stop, nil
+}
@@ -0,0 +1,245 @@
+// Package acl is who may do what to a znode.
+//
+// THE PROBLEM:
+//
+// Any client can delete "/" today, or overwrite another service's
+// config. Every znode is open to everyone.
+//
+// THE FIX: ZOOKEEPER-STYLE ACLs
+//
+// Every znode carries a list of entries, each granting permissions to
+// one identity:
+//
+//	scheme : id                                 : perms
+//	world  : anyone                             : r      ← everyone may read
+//	digest : alice:aYXlLOpEooaV1cRAvUL1fp9Qt7E= : cdrwa  ← alice may do anything
+//	x509   : billing                            : rw     ← the client cert with CN=billing
+//
+// A caller has one or more identities: always world:anyone, plus one
+// per credential it proved (a verified client certificate, a digest
+// user and password). An operation is allowed if ANY entry grants the
+// permission it needs to ANY of the caller's identities.
+//
+//	r  READ    get the data, list the children, read the ACL
+//	w  WRITE   set the data
+//	c  CREATE  create a child
+//	d  DELETE  delete a child
+//	a  ADMIN   set the ACL
+//
+// Like ZooKeeper, ACLs aren't inherited: a child gets the ACL its
+// create asked for, whatever its parent's says. And like ZooKeeper, a
+// digest id holds a hash, not the password — an ACL can be read by
+// anyone allowed to, and says nothing about the password.
+//
+// This package only knows ACLs and identities. The tree keeps them (see
+// znode), the server works out who the caller is and checks (see
+// server/acl.go).
+package acl
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Perm is a set of permissions. The values are ZooKeeper's.
+type Perm int32
+
+const (
+	Read Perm = 1 << iota
+	Write
+	Create
+	Delete
+	Admin
+
+	// All is every permission.
+	All = Read | Write | Create | Delete | Admin
+)
+
+// permLetters is the short form of each permission, in the order
+// ZooKeeper prints them.
+var permLetters = []struct {
+	perm   Perm
+	letter byte
+}{
+	{Create, 'c'}, {Delete, 'd'}, {Read, 'r'}, {Write, 'w'}, {Admin, 'a'},
+}
+
+// String is the short form: "cdrwa" for All, "r" for Read.
+func (p Perm) String() string {
+	var b strings.Builder
+	for _, pl := range permLetters {
+		if p&pl.perm != 0 {
+			b.WriteByte(pl.letter)
+		}
+	}
+	return b.String()
+}
+
+// ParsePerms reads the short form, in any order: "rw", "cdrwa".
+func ParsePerms(s string) (Perm, error) {
+	var p Perm
+	for i := 0; i < len(s); i++ {
+		found := false
+		for _, pl := range permLetters {
+			if s[i] == pl.letter {
+				p |= pl.perm
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("%w: unknown permission %q in %q (use c, d, r, w, a)", ErrInvalid, s[i], s)
+		}
+	}
+	return p, nil
+}
+
+// The schemes an identity can come from.
+const (
+	// SchemeWorld has one id, "anyone": every caller.
+	SchemeWorld = "world"
+
+	// SchemeDigest ids are "user:hash" — see Digest.
+	SchemeDigest = "digest"
+
+	// SchemeX509 ids are the common name of a verified client
+	// certificate.
+	SchemeX509 = "x509"
+)
+
+// ID is one identity: "digest:alice:aYXl...", "world:anyone".
+type ID struct {
+	Scheme string
+	ID     string
+}
+
+// Anyone is world:anyone, the identity every caller has.
+var Anyone = ID{Scheme: SchemeWorld, ID: "anyone"}
+
+func (id ID) String() string { return id.Scheme + ":" + id.ID }
+
+// ACL is one entry of a znode's list: Perms granted to ID.
+type ACL struct {
+	Perms Perm
+	ID
+}
+
+// Open grants everything to everyone. Nodes created without an ACL get
+// it, and so does the root.
+var Open = []ACL{{Perms: All, ID: Anyone}}
+
+// String is the text form, "scheme:id:perms".
+func (a ACL) String() string { return a.ID.String() + ":" + a.Perms.String() }
+
+// ErrInvalid means an ACL, or a credential, isn't well-formed.
+var ErrInvalid = errors.New("invalid ACL")
+
+// Parse reads "scheme:id:perms". The id may hold colons itself (a
+// digest id does): the scheme ends at the first colon, the perms start
+// after the last.
+func Parse(s string) (ACL, error) {
+	first, last := strings.Index(s, ":"), strings.LastIndex(s, ":")
+	if first < 0 || first == last {
+		return ACL{}, fmt.Errorf("%w: %q is not scheme:id:perms", ErrInvalid, s)
+	}
+	perms, err := ParsePerms(s[last+1:])
+	if err != nil {
+		return ACL{}, err
+	}
+	a := ACL{Perms: perms, ID: ID{Scheme: s[:first], ID: s[first+1 : last]}}
+	return a, Validate([]ACL{a})
+}
+
+// ParseList reads a comma-separated list of ACLs:
+// "world:anyone:r,digest:alice:aYXl...:cdrwa".
+func ParseList(s string) ([]ACL, error) {
+	var acls []ACL
+	for _, part := range strings.Split(s, ",") {
+		a, err := Parse(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		acls = append(acls, a)
+	}
+	return acls, nil
+}
+
+// MarshalText is the text form, so an ACL is a plain string in JSON
+// (the WAL) as it is on the command line.
+func (a ACL) MarshalText() ([]byte, error) { return []byte(a.String()), nil }
+
+// UnmarshalText reads the text form.
+func (a *ACL) UnmarshalText(text []byte) error {
+	parsed, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// Validate checks every entry names a known scheme, a well-formed id
+// and at least one permission.
+func Validate(acls []ACL) error {
+	for _, a := range acls {
+		if a.Perms == 0 || a.Perms&^All != 0 {
+			return fmt.Errorf("%w: %s grants no valid permissions", ErrInvalid, a.ID)
+		}
+		switch a.Scheme {
+		case SchemeWorld:
+			if a.ID.ID != Anyone.ID {
+				return fmt.Errorf("%w: the world scheme's only id is %q", ErrInvalid, Anyone.ID)
+			}
+		case SchemeDigest:
+			user, hash, ok := strings.Cut(a.ID.ID, ":")
+			if !ok || user == "" || hash == "" {
+				return fmt.Errorf("%w: a digest id is user:hash, got %q", ErrInvalid, a.ID.ID)
+			}
+		case SchemeX509:
+			if a.ID.ID == "" {
+				return fmt.Errorf("%w: an x509 id is a certificate's common name", ErrInvalid)
+			}
+		default:
+			return fmt.Errorf("%w: unknown scheme %q (world, digest or x509)", ErrInvalid, a.Scheme)
+		}
+	}
+	return nil
+}
+
+// Digest is the digest identity of a user and password, the same as
+// ZooKeeper's: "user:" + base64(sha1("user:password")).
+//
+//	Digest("alice", "secret") → digest:alice:aYXlLOpEooaV1cRAvUL1fp9Qt7E=
+//
+// Put it in an ACL to grant alice access; she proves it by sending her
+// password, which the server hashes the same way.
+func Digest(user, password string) ID {
+	sum := sha1.Sum([]byte(user + ":" + password))
+	return ID{Scheme: SchemeDigest, ID: user + ":" + base64.StdEncoding.EncodeToString(sum[:])}
+}
+
+// DigestCredentials is Digest for credentials given as "user:password".
+func DigestCredentials(credentials string) (ID, error) {
+	user, password, ok := strings.Cut(credentials, ":")
+	if !ok || user == "" {
+		return ID{}, fmt.Errorf("%w: digest credentials are user:password", ErrInvalid)
+	}
+	return Digest(user, password), nil
+}
+
+// Allowed reports whether acls grant perm to any of ids.
+func Allowed(acls []ACL, ids []ID, perm Perm) bool {
+	for _, a := range acls {
+		if a.Perms&perm == 0 {
+			continue
+		}
+		for _, id := range ids {
+			if a.ID == id {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package acl
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestPermsRoundTrip(t *testing.T) {
+	if All.String() != "cdrwa" {
+		t.Fatalf("expected cdrwa, got %q", All.String())
+	}
+	p, err := ParsePerms("wr")
+	if err != nil || p != Read|Write {
+		t.Fatalf("expected Read|Write, got %v, %v", p, err)
+	}
+	if _, err := ParsePerms("rx"); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected ErrInvalid for 'x', got %v", err)
+	}
+}
+
+// TestDigestMatchesZooKeeper pins the hash: an ACL written for
+// ZooKeeper's digest scheme must mean the same user here.
+func TestDigestMatchesZooKeeper(t *testing.T) {
+	id := Digest("alice", "secret")
+	if id.String() != "digest:alice:aYXlLOpEooaV1cRAvUL1fp9Qt7E=" {
+		t.Fatalf("unexpected digest %s", id)
+	}
+	fromCreds, err := DigestCredentials("alice:secret")
+	if err != nil || fromCreds != id {
+		t.Fatalf("DigestCredentials gave %v, %v", fromCreds, err)
+	}
+	if _, err := DigestCredentials("alice"); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected ErrInvalid without a password, got %v", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	acls, err := ParseList("world:anyone:r, digest:alice:aYXlLOpEooaV1cRAvUL1fp9Qt7E=:cdrwa,x509:billing:rw")
+	if err != nil {
+		t.Fatalf("ParseList failed: %v", err)
+	}
+	want := []ACL{
+		{Perms: Read, ID: Anyone},
+		{Perms: All, ID: Digest("alice", "secret")},
+		{Perms: Read | Write, ID: ID{Scheme: SchemeX509, ID: "billing"}},
+	}
+	if len(acls) != len(want) {
+		t.Fatalf("expected %d entries, got %v", len(want), acls)
+	}
+	for i := range want {
+		if acls[i] != want[i] {
+			t.Fatalf("entry %d: expected %s, got %s", i, want[i], acls[i])
+		}
+	}
+
+	for _, bad := range []string{
+		"world:anyone",    // no perms
+		"world:someone:r", // world has one id
+		"digest:alice:r",  // no hash
+		"ip:10.0.0.1:r",   // unknown scheme
+		"x509::r",         // no common name
+		"world:anyone:rq", // unknown permission
+	} {
+		if _, err := Parse(bad); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("Parse(%q): expected ErrInvalid, got %v", bad, err)
+		}
+	}
+}
+
+func TestJSONIsTextForm(t *testing.T) {
+	in := []ACL{{Perms: Read, ID: Anyone}, {Perms: All, ID: Digest("alice", "secret")}}
+	b, err := json.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(b) != `["world:anyone:r","digest:alice:aYXlLOpEooaV1cRAvUL1fp9Qt7E=:cdrwa"]` {
+		t.Fatalf("unexpected JSON %s", b)
+	}
+	var out []ACL
+	if err := json.Unmarshal(b, &out); err != nil || len(out) != 2 || out[1] != in[1] {
+		t.Fatalf("round trip gave %v, %v", out, err)
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	alice := Digest("alice", "secret")
+	acls := []ACL{{Perms: Read, ID: Anyone}, {Perms: All, ID: alice}}
+
+	if !Allowed(acls, []ID{Anyone}, Read) {
+		t.Fatal("anyone should be able to read")
+	}
+	if Allowed(acls, []ID{Anyone}, Write) {
+		t.Fatal("anyone should not be able to write")
+	}
+	if !Allowed(acls, []ID{Anyone, alice}, Delete) {
+		t.Fatal("alice should be able to delete")
+	}
+	if Allowed(acls, []ID{Anyone, Digest("alice", "wrong")}, Delete) {
+		t.Fatal("the wrong password is someone else")
+	}
+	if Allowed(nil, []ID{Anyone}, Read) {
+		t.Fatal("an empty ACL grants nothing")
+	}
+}
@@ -0,0 +1,53 @@
+package cluster
+
+// Where a RaftNode gets the time, and its randomness.
+//
+// THE PROBLEM:
+//
+// Everything in Raft that isn't a message is about time: a follower
+// campaigns when it hasn't heard from a leader for a random 300-500ms, a
+// voter refuses to depose a leader it heard from lately, a lease holds
+// for a while after a heartbeat round. With time.Now and crypto/rand
+// baked in, a test can only start nodes, sleep, and hope:
+//
+//   start 3 nodes ─ sleep 500ms ─ "exactly one leader?"
+//                     ↑ a slow CI machine, and the answer changes
+//
+// THE FIX:
+//
+// Config.Clock and Config.Rand. Left nil, a node uses the real clock
+// and crypto/rand, as before. A simulation (see sim_test.go) hands every
+// node the same virtual clock, which only moves when it says so, and a
+// seeded source of randomness — and calls tick itself instead of Run:
+//
+//   clock at 0ms    tick node-1, tick node-2, tick node-3
+//   clock at 50ms   tick node-1, deliver a delayed AppendEntries, ...
+//
+// Same seed, same election timeouts, same order of everything: a run
+// that failed once fails again, as often as it takes to debug it.
+//
+// Only what a node reads goes through the Clock. Run's ticker and the
+// waits in TransferLeadership and AddPeer still use real time: a
+// simulation doesn't call them.
+
+import "time"
+
+// Clock tells a RaftNode what time it is.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the real time.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// now is the current time on the node's clock.
+func (rn *RaftNode) now() time.Time {
+	return rn.clock.Now()
+}
+
+// since is the time elapsed since t on the node's clock.
+func (rn *RaftNode) since(t time.Time) time.Duration {
+	return rn.clock.Now().Sub(t)
+}
@@ -18,6 +18,16 @@ package cluster
 //   Node 3 starts with: "I am node-3, the others are node-1 and node-2"
 //
 // All three have the SAME list of peers. They only differ in which ID is "me".
+//
+// The list is only where a cluster STARTS. Nodes can be added and
+// removed while it runs, through the log — see membership.go.
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strings"
+)
 
 // NodeID is a unique identifier for a node in the cluster.
 // We use a string so it's human-readable in logs: "node-1", "node-2", etc.
@@ -36,6 +46,19 @@ type Peer struct {
 	// (heartbeats, vote requests, log replication) are different concerns.
 	// Keeping them on separate ports lets us manage them independently.
 	Addr string
+
+	// ClientAddr is the address clients use for the ZooKeeper service
+	// on this node (Create, Get, Set...). Example: "localhost:2181"
+	//
+	// Raft itself never uses it. It's here so a follower can tell a
+	// client "I'm not the leader, talk to node-2 at localhost:2182".
+	// Empty if unknown.
+	ClientAddr string
+
+	// Learner marks a node that receives the log but doesn't vote and
+	// doesn't count towards a quorum. New nodes start as learners until
+	// they've caught up (see membership.go).
+	Learner bool
 }
 
 // Config holds the full cluster configuration.
@@ -43,7 +66,9 @@ type Config struct {
 	// Self is this node's ID. Used to identify "which one am I?" in the peers list.
 	Self NodeID
 
-	// Peers is the complete list of ALL nodes in the cluster, including self.
+	// Peers is the complete list of ALL nodes in the cluster, including
+	// self and any learners. Once the membership has changed, the
+	// committed membership replaces it.
 	// Example for a 3-node cluster:
 	//   [
 	//     {ID: "node-1", Addr: "localhost:3001"},
@@ -51,6 +76,33 @@ type Config struct {
 	//     {ID: "node-3", Addr: "localhost:3003"},
 	//   ]
 	Peers []Peer
+
+	// DataDir is where this node keeps its durable Raft metadata
+	// (current term and vote, see meta.go). Usually the same directory
+	// as the WAL and snapshot.
+	//
+	// Empty means nothing is persisted — fine for unit tests, unsafe
+	// for a real cluster.
+	DataDir string
+
+	// Clock is where the node reads the time: election timeouts, the
+	// leader lease. nil means the real clock. See clock.go.
+	Clock Clock
+
+	// Rand picks election timeouts. nil means crypto/rand; a seeded one
+	// makes a simulation repeatable.
+	Rand *rand.Rand
+}
+
+// Peer looks up a node by ID.
+// Returns false if the ID is not part of the cluster.
+func (c *Config) Peer(id NodeID) (Peer, bool) {
+	for _, p := range c.Peers {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return Peer{}, false
 }
 
 // OtherPeers returns all peers except self.
@@ -65,7 +117,27 @@ func (c *Config) OtherPeers() []Peer {
 	return others
 }
 
-// QuorumSize returns the number of nodes needed for a majority.
+// Voters returns the peers that vote: everyone but the learners,
+// including self.
+func (c *Config) Voters() []Peer {
+	var voters []Peer
+	for _, p := range c.Peers {
+		if !p.Learner {
+			voters = append(voters, p)
+		}
+	}
+	return voters
+}
+
+// IsVoter reports whether id votes in this configuration. A learner,
+// or a node that isn't a member at all, doesn't.
+func (c *Config) IsVoter(id NodeID) bool {
+	p, ok := c.Peer(id)
+	return ok && !p.Learner
+}
+
+// QuorumSize returns the number of voters needed for a majority.
+// Learners don't count.
 //
 // In a 3-node cluster: quorum = 2 (majority of 3)
 // In a 5-node cluster: quorum = 3 (majority of 5)
@@ -88,5 +160,68 @@ func (c *Config) OtherPeers() []Peer {
 // With 4 nodes, quorum is 3 — same as with 5 nodes, but you have
 // one fewer node that can fail. 4 nodes is strictly worse than 5.
 func (c *Config) QuorumSize() int {
-	return len(c.Peers)/2 + 1
+	return len(c.Voters())/2 + 1
+}
+
+// ParsePeers parses the --peers flag into a list of peers.
+//
+// The format borrows from ZooKeeper's "server.1=zoo1:2888:3888":
+// one entry per node, comma-separated, each entry is
+//
+//	<id>=<host>:<raftPort>[:<clientPort>]
+//
+// Example:
+//
+//	"node-1=localhost:3001:2181,node-2=localhost:3002:2182"
+//	  → {ID: "node-1", Addr: "localhost:3001", ClientAddr: "localhost:2181"}
+//	    {ID: "node-2", Addr: "localhost:3002", ClientAddr: "localhost:2182"}
+//
+// The client port is optional. Without it, followers can still say
+// "not the leader" but can't tell the client where the leader is.
+func ParsePeers(spec string) ([]Peer, error) {
+	var peers []Peer
+	seen := make(map[NodeID]bool)
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		id, addr, ok := strings.Cut(item, "=")
+		if !ok || id == "" || addr == "" {
+			return nil, fmt.Errorf("invalid peer %q: expected <id>=<host>:<raftPort>[:<clientPort>]", item)
+		}
+		if seen[NodeID(id)] {
+			return nil, fmt.Errorf("duplicate peer id %q", id)
+		}
+		seen[NodeID(id)] = true
+
+		peer := Peer{ID: NodeID(id)}
+
+		// "localhost:3001:2181" → the part after the last colon is the
+		// client port, IF what's left is still a valid host:port.
+		// "localhost:3001" → what's left ("localhost") has no port,
+		// so the whole thing is the Raft address and there's no client port.
+		// (IPv6 hosts must be bracketed: "[::1]:3001:2181".)
+		i := strings.LastIndex(addr, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid address for peer %q: missing port in %q", id, addr)
+		}
+		if host, raftPort, err := net.SplitHostPort(addr[:i]); err == nil {
+			peer.Addr = net.JoinHostPort(host, raftPort)
+			peer.ClientAddr = net.JoinHostPort(host, addr[i+1:])
+		} else if _, _, err := net.SplitHostPort(addr); err == nil {
+			peer.Addr = addr
+		} else {
+			return nil, fmt.Errorf("invalid address for peer %q: %w", id, err)
+		}
+
+		peers = append(peers, peer)
+	}
+
+	if len(peers) == 0 {
+		return nil, fmt.Errorf("no peers given")
+	}
+	return peers, nil
 }
@@ -27,6 +27,19 @@ func TestQuorumSize(t *testing.T) {
 	}
 }
 
+func TestQuorumSize_IgnoresLearners(t *testing.T) {
+	cfg := Config{Self: "node-1", Peers: []Peer{
+		{ID: "node-1"}, {ID: "node-2"}, {ID: "node-3"},
+		{ID: "node-4", Learner: true}, {ID: "node-5", Learner: true},
+	}}
+	if got := cfg.QuorumSize(); got != 2 {
+		t.Fatalf("3 voters + 2 learners: expected quorum 2, got %d", got)
+	}
+	if cfg.IsVoter("node-4") || !cfg.IsVoter("node-1") || cfg.IsVoter("node-9") {
+		t.Fatal("IsVoter should be true for voters only")
+	}
+}
+
 func TestOtherPeers(t *testing.T) {
 	cfg := Config{
 		Self: "node-2",
@@ -50,3 +63,37 @@ func TestOtherPeers(t *testing.T) {
 		}
 	}
 }
+
+func TestParsePeers(t *testing.T) {
+	peers, err := ParsePeers("node-1=localhost:3001:2181, node-2=10.0.0.2:3002,node-3=[::1]:3003:2183")
+	if err != nil {
+		t.Fatalf("ParsePeers failed: %v", err)
+	}
+
+	expected := []Peer{
+		{ID: "node-1", Addr: "localhost:3001", ClientAddr: "localhost:2181"},
+		{ID: "node-2", Addr: "10.0.0.2:3002"}, // no client port given
+		{ID: "node-3", Addr: "[::1]:3003", ClientAddr: "[::1]:2183"},
+	}
+	if len(peers) != len(expected) {
+		t.Fatalf("expected %d peers, got %d", len(expected), len(peers))
+	}
+	for i := range expected {
+		if peers[i] != expected[i] {
+			t.Errorf("peer %d: expected %+v, got %+v", i, expected[i], peers[i])
+		}
+	}
+}
+
+func TestParsePeers_Invalid(t *testing.T) {
+	for _, spec := range []string{
+		"",                          // nothing
+		"node-1",                    // no address
+		"node-1=localhost",          // no port
+		"node-1=a:1:2,node-1=b:1:2", // duplicate ID
+	} {
+		if _, err := ParsePeers(spec); err == nil {
+			t.Errorf("expected error for %q", spec)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+)
+
+// NotLeaderError is returned by Propose when this node is not the leader.
+//
+// Only the leader can accept writes. A follower that receives a write
+// can't just say "no" — the client would have to guess where to go next.
+// So the error carries the answer: who the leader is and how to reach it.
+//
+//	err := node.Propose(...)
+//	var nle *NotLeaderError
+//	if errors.As(err, &nle) {
+//	    // retry on nle.LeaderAddr
+//	}
+//
+// LeaderID is empty during an election (nobody knows the leader yet).
+// LeaderAddr is empty if the leader is known but its client address isn't
+// in the cluster config.
+type NotLeaderError struct {
+	LeaderID   NodeID
+	LeaderAddr string
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.LeaderID == "" {
+		return "not the leader (leader unknown)"
+	}
+	if e.LeaderAddr == "" {
+		return fmt.Sprintf("not the leader (leader is %s)", e.LeaderID)
+	}
+	return fmt.Sprintf("not the leader (leader is %s at %s)", e.LeaderID, e.LeaderAddr)
+}
+
+// ErrNoQuorum means a write could not be replicated to a majority in
+// time. It isn't committed yet, but it may still be: the entry stays in
+// the log. A client that retries should check whether it went through.
+var ErrNoQuorum = errors.New("no quorum")
+
+// ErrLeaseExpired means the leader can't vouch for its commitIndex
+// without a heartbeat round: its lease ran out, or it hasn't committed
+// anything in its term yet. See read_index.go.
+var ErrLeaseExpired = errors.New("leader lease expired")
+
+// ErrPeerExists means AddPeer was asked to add a node that already
+// votes in the cluster.
+var ErrPeerExists = errors.New("peer already in the cluster")
+
+// ErrUnknownPeer means RemovePeer was asked to remove a node that isn't
+// a member.
+var ErrUnknownPeer = errors.New("no such peer")
+
+// ErrLearnerBehind means a new node didn't catch up with the leader's
+// log in time to be promoted. It stays a learner; AddPeer can be retried.
+// See membership.go.
+var ErrLearnerBehind = errors.New("learner did not catch up")
+
+// ErrTransferFailed means TransferLeadership didn't hand over: the
+// target didn't catch up, refused, or didn't win its election in time.
+// See transfer.go.
+var ErrTransferFailed = errors.New("leadership transfer failed")
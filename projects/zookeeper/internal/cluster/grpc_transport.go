@@ -0,0 +1,497 @@
+package cluster
+
+// GRPCTransport is the production Transport: it sends Raft messages to
+// other nodes over the network using the Raft gRPC service (raft.proto).
+//
+// It is the real-world twin of fakeTransport in the tests:
+//
+//   fakeTransport: node1.SendAppendEntries → node2.HandleAppendEntries  (method call)
+//   GRPCTransport: node1.SendAppendEntries → gRPC → RaftServer → node2.HandleAppendEntries
+//
+// RaftNode doesn't know or care which one it's using.
+//
+// Two details matter for a transport that runs every 50ms:
+//
+//   1. Connection reuse. Dialing a new TCP connection per heartbeat would
+//      be wasteful. We keep one *grpc.ClientConn per peer address and reuse
+//      it for every message. gRPC reconnects on its own if the peer restarts.
+//
+//   2. Deadlines. A dead peer must not stall the leader. Every call gets
+//      a short timeout — if the peer doesn't answer in time, we return an
+//      error and RaftNode treats it like "peer unreachable, try next tick".
+//
+// With SetTLS, every connection is mutual TLS and only talks to the
+// node it dialed: the server's certificate must be that peer's node
+// certificate (see tls.go).
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/syamsularifin/zookeeper/api/proto/raftpb"
+	"github.com/syamsularifin/zookeeper/internal/acl"
+	"github.com/syamsularifin/zookeeper/internal/wal"
+)
+
+// DefaultRPCTimeout is how long we wait for a peer to answer one Raft RPC.
+// It must be well below the election timeout (300-500ms), otherwise one
+// slow peer could delay heartbeats to everyone else long enough to
+// trigger an election.
+const DefaultRPCTimeout = 100 * time.Millisecond
+
+// snapshotTimeoutFactor stretches the deadline for InstallSnapshot.
+// Snapshot transfers run in the background, so a longer wait there
+// doesn't delay heartbeats.
+const snapshotTimeoutFactor = 20
+
+// GRPCTransport implements Transport over gRPC.
+type GRPCTransport struct {
+	mu sync.Mutex
+
+	// conns caches one connection per peer address.
+	// Keyed by address (not NodeID) so a peer that moves to a new
+	// address gets a fresh connection.
+	conns map[string]*grpc.ClientConn
+
+	// timeout is the deadline for each RPC.
+	timeout time.Duration
+
+	// tls is the client config for dialing peers; nil: plaintext.
+	tls *tls.Config
+}
+
+// NewGRPCTransport creates a transport with the given per-RPC timeout.
+// A zero timeout means DefaultRPCTimeout.
+func NewGRPCTransport(timeout time.Duration) *GRPCTransport {
+	if timeout <= 0 {
+		timeout = DefaultRPCTimeout
+	}
+	return &GRPCTransport{
+		conns:   make(map[string]*grpc.ClientConn),
+		timeout: timeout,
+	}
+}
+
+// SetTLS makes every connection mutual TLS with cfg: its certificate
+// is this node's node certificate, its RootCAs sign the peers'. Call
+// it before the first RPC.
+func (t *GRPCTransport) SetTLS(cfg *tls.Config) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tls = cfg
+}
+
+// client returns a Raft client for the peer, reusing the cached connection.
+//
+// grpc.NewClient does not actually connect — it connects lazily on the
+// first RPC. So creating it under the lock is cheap.
+func (t *GRPCTransport) client(peer Peer) (raftpb.RaftClient, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	conn, ok := t.conns[peer.Addr]
+	if !ok {
+		creds := insecure.NewCredentials()
+		if t.tls != nil {
+			cfg := t.tls.Clone()
+			cfg.VerifyConnection = verifyNode(peer.ID)
+			creds = credentials.NewTLS(cfg)
+		}
+		var err error
+		conn, err = grpc.NewClient(peer.Addr, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create client for %s: %w", peer.ID, err)
+		}
+		t.conns[peer.Addr] = conn
+	}
+
+	return raftpb.NewRaftClient(conn), nil
+}
+
+// SendRequestVote sends a vote request to a peer and waits for the response.
+func (t *GRPCTransport) SendRequestVote(peer Peer, req RequestVoteRequest) (RequestVoteResponse, error) {
+	c, err := t.client(peer)
+	if err != nil {
+		return RequestVoteResponse{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+
+	resp, err := c.RequestVote(ctx, requestVoteToProto(req))
+	if err != nil {
+		return RequestVoteResponse{}, fmt.Errorf("RequestVote to %s failed: %w", peer.ID, err)
+	}
+
+	return requestVoteResponseFromProto(resp), nil
+}
+
+// SendAppendEntries sends a heartbeat (or log entries) to a peer.
+func (t *GRPCTransport) SendAppendEntries(peer Peer, req AppendEntriesRequest) (AppendEntriesResponse, error) {
+	c, err := t.client(peer)
+	if err != nil {
+		return AppendEntriesResponse{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+
+	resp, err := c.AppendEntries(ctx, appendEntriesToProto(req))
+	if err != nil {
+		return AppendEntriesResponse{}, fmt.Errorf("AppendEntries to %s failed: %w", peer.ID, err)
+	}
+
+	return appendEntriesResponseFromProto(resp), nil
+}
+
+// SendInstallSnapshot sends one snapshot chunk to a peer.
+//
+// A chunk is much bigger than a heartbeat and the follower may write the
+// whole snapshot to disk before answering the last one, so it gets
+// snapshotTimeoutFactor times the normal deadline.
+func (t *GRPCTransport) SendInstallSnapshot(peer Peer, req InstallSnapshotRequest) (InstallSnapshotResponse, error) {
+	c, err := t.client(peer)
+	if err != nil {
+		return InstallSnapshotResponse{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout*snapshotTimeoutFactor)
+	defer cancel()
+
+	resp, err := c.InstallSnapshot(ctx, installSnapshotToProto(req))
+	if err != nil {
+		return InstallSnapshotResponse{}, fmt.Errorf("InstallSnapshot to %s failed: %w", peer.ID, err)
+	}
+
+	return installSnapshotResponseFromProto(resp), nil
+}
+
+// SendTimeoutNow tells a peer to start an election right away.
+func (t *GRPCTransport) SendTimeoutNow(peer Peer, req TimeoutNowRequest) (TimeoutNowResponse, error) {
+	c, err := t.client(peer)
+	if err != nil {
+		return TimeoutNowResponse{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+
+	resp, err := c.TimeoutNow(ctx, timeoutNowToProto(req))
+	if err != nil {
+		return TimeoutNowResponse{}, fmt.Errorf("TimeoutNow to %s failed: %w", peer.ID, err)
+	}
+
+	return timeoutNowResponseFromProto(resp), nil
+}
+
+// Close closes every cached connection.
+func (t *GRPCTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var firstErr error
+	for addr, conn := range t.conns {
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(t.conns, addr)
+	}
+	return firstErr
+}
+
+// RaftServer is the receiving side of GRPCTransport.
+// It implements the generated raftpb.RaftServer interface by
+// converting each protobuf message and handing it to the RaftNode.
+//
+// Like internal/server, it is thin on purpose — no Raft logic here.
+//
+// Register it on a gRPC server listening on the node's Raft address:
+//
+//	raftpb.RegisterRaftServer(grpcServer, cluster.NewRaftServer(node))
+type RaftServer struct {
+	raftpb.UnimplementedRaftServer
+
+	node *RaftNode
+}
+
+// NewRaftServer creates a gRPC handler backed by the given RaftNode.
+func NewRaftServer(node *RaftNode) *RaftServer {
+	return &RaftServer{node: node}
+}
+
+func (s *RaftServer) AppendEntries(ctx context.Context, req *raftpb.AppendEntriesRequest) (*raftpb.AppendEntriesResponse, error) {
+	resp := s.node.HandleAppendEntries(appendEntriesFromProto(req))
+	return appendEntriesResponseToProto(resp), nil
+}
+
+func (s *RaftServer) RequestVote(ctx context.Context, req *raftpb.RequestVoteRequest) (*raftpb.RequestVoteResponse, error) {
+	resp := s.node.HandleRequestVote(requestVoteFromProto(req))
+	return requestVoteResponseToProto(resp), nil
+}
+
+func (s *RaftServer) InstallSnapshot(ctx context.Context, req *raftpb.InstallSnapshotRequest) (*raftpb.InstallSnapshotResponse, error) {
+	resp := s.node.HandleInstallSnapshot(installSnapshotFromProto(req))
+	return installSnapshotResponseToProto(resp), nil
+}
+
+func (s *RaftServer) TimeoutNow(ctx context.Context, req *raftpb.TimeoutNowRequest) (*raftpb.TimeoutNowResponse, error) {
+	resp := s.node.HandleTimeoutNow(timeoutNowFromProto(req))
+	return timeoutNowResponseToProto(resp), nil
+}
+
+// --- Conversions between cluster messages and protobuf messages ---
+//
+// These are boring on purpose: field-by-field copies in both directions.
+// Keeping them in one place means a new field only has to be wired here.
+
+func entriesToProto(entries []wal.Entry) []*raftpb.LogEntry {
+	out := make([]*raftpb.LogEntry, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, &raftpb.LogEntry{
+			TxId:       e.TxID,
+			Term:       e.Term,
+			Op:         string(e.Op),
+			Path:       e.Path,
+			Data:       e.Data,
+			Version:    e.Version,
+			Time:       e.Time,
+			Session:    e.Session,
+			TimeoutMs:  e.Timeout,
+			Sequential: e.Sequential,
+			Ops:        opsToProto(e.Ops),
+			Acl:        aclToProto(e.ACL),
+		})
+	}
+	return out
+}
+
+func entriesFromProto(entries []*raftpb.LogEntry) []wal.Entry {
+	if len(entries) == 0 {
+		return nil
+	}
+	out := make([]wal.Entry, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, wal.Entry{
+			TxID:       e.TxId,
+			Term:       e.Term,
+			Op:         wal.OpType(e.Op),
+			Path:       e.Path,
+			Data:       e.Data,
+			Version:    e.Version,
+			Time:       e.Time,
+			Session:    e.Session,
+			Timeout:    e.TimeoutMs,
+			Sequential: e.Sequential,
+			Ops:        opsFromProto(e.Ops),
+			ACL:        aclFromProto(e.Acl),
+		})
+	}
+	return out
+}
+
+func opsToProto(ops []wal.Op) []*raftpb.MultiOp {
+	if len(ops) == 0 {
+		return nil
+	}
+	out := make([]*raftpb.MultiOp, 0, len(ops))
+	for _, op := range ops {
+		out = append(out, &raftpb.MultiOp{
+			Op:         string(op.Op),
+			Path:       op.Path,
+			Data:       op.Data,
+			Version:    op.Version,
+			Session:    op.Session,
+			Sequential: op.Sequential,
+			Acl:        aclToProto(op.ACL),
+		})
+	}
+	return out
+}
+
+func opsFromProto(ops []*raftpb.MultiOp) []wal.Op {
+	if len(ops) == 0 {
+		return nil
+	}
+	out := make([]wal.Op, 0, len(ops))
+	for _, op := range ops {
+		out = append(out, wal.Op{
+			Op:         wal.OpType(op.Op),
+			Path:       op.Path,
+			Data:       op.Data,
+			Version:    op.Version,
+			Session:    op.Session,
+			Sequential: op.Sequential,
+			ACL:        aclFromProto(op.Acl),
+		})
+	}
+	return out
+}
+
+func aclToProto(acls []acl.ACL) []*raftpb.ACLEntry {
+	if len(acls) == 0 {
+		return nil
+	}
+	out := make([]*raftpb.ACLEntry, 0, len(acls))
+	for _, a := range acls {
+		out = append(out, &raftpb.ACLEntry{Scheme: a.Scheme, Id: a.ID.ID, Perms: int32(a.Perms)})
+	}
+	return out
+}
+
+func aclFromProto(acls []*raftpb.ACLEntry) []acl.ACL {
+	if len(acls) == 0 {
+		return nil
+	}
+	out := make([]acl.ACL, 0, len(acls))
+	for _, a := range acls {
+		out = append(out, acl.ACL{Perms: acl.Perm(a.Perms), ID: acl.ID{Scheme: a.Scheme, ID: a.Id}})
+	}
+	return out
+}
+
+func appendEntriesToProto(req AppendEntriesRequest) *raftpb.AppendEntriesRequest {
+	return &raftpb.AppendEntriesRequest{
+		Term:              req.Term,
+		LeaderId:          string(req.LeaderID),
+		PrevLogTxId:       req.PrevLogTxID,
+		PrevLogTerm:       req.PrevLogTerm,
+		Entries:           entriesToProto(req.Entries),
+		LeaderCommitIndex: req.LeaderCommitIndex,
+	}
+}
+
+func appendEntriesFromProto(req *raftpb.AppendEntriesRequest) AppendEntriesRequest {
+	return AppendEntriesRequest{
+		Term:              req.Term,
+		LeaderID:          NodeID(req.LeaderId),
+		PrevLogTxID:       req.PrevLogTxId,
+		PrevLogTerm:       req.PrevLogTerm,
+		Entries:           entriesFromProto(req.Entries),
+		LeaderCommitIndex: req.LeaderCommitIndex,
+	}
+}
+
+func appendEntriesResponseToProto(resp AppendEntriesResponse) *raftpb.AppendEntriesResponse {
+	return &raftpb.AppendEntriesResponse{
+		Term:        resp.Term,
+		Success:     resp.Success,
+		LastLogTxId: resp.LastLogTxID,
+	}
+}
+
+func appendEntriesResponseFromProto(resp *raftpb.AppendEntriesResponse) AppendEntriesResponse {
+	return AppendEntriesResponse{
+		Term:        resp.Term,
+		Success:     resp.Success,
+		LastLogTxID: resp.LastLogTxId,
+	}
+}
+
+func requestVoteToProto(req RequestVoteRequest) *raftpb.RequestVoteRequest {
+	return &raftpb.RequestVoteRequest{
+		Term:        req.Term,
+		CandidateId: string(req.CandidateID),
+		LastLogTxId: req.LastLogTxID,
+		LastLogTerm: req.LastLogTerm,
+		PreVote:     req.PreVote,
+		Transfer:    req.Transfer,
+	}
+}
+
+func requestVoteFromProto(req *raftpb.RequestVoteRequest) RequestVoteRequest {
+	return RequestVoteRequest{
+		Term:        req.Term,
+		CandidateID: NodeID(req.CandidateId),
+		LastLogTxID: req.LastLogTxId,
+		LastLogTerm: req.LastLogTerm,
+		PreVote:     req.PreVote,
+		Transfer:    req.Transfer,
+	}
+}
+
+func requestVoteResponseToProto(resp RequestVoteResponse) *raftpb.RequestVoteResponse {
+	return &raftpb.RequestVoteResponse{
+		Term:        resp.Term,
+		VoteGranted: resp.VoteGranted,
+	}
+}
+
+func requestVoteResponseFromProto(resp *raftpb.RequestVoteResponse) RequestVoteResponse {
+	return RequestVoteResponse{
+		Term:        resp.Term,
+		VoteGranted: resp.VoteGranted,
+	}
+}
+
+func installSnapshotToProto(req InstallSnapshotRequest) *raftpb.InstallSnapshotRequest {
+	return &raftpb.InstallSnapshotRequest{
+		Term:             req.Term,
+		LeaderId:         string(req.LeaderID),
+		LastIncludedTxId: req.LastIncludedTxID,
+		LastIncludedTerm: req.LastIncludedTerm,
+		Offset:           req.Offset,
+		Data:             req.Data,
+		Done:             req.Done,
+	}
+}
+
+func installSnapshotFromProto(req *raftpb.InstallSnapshotRequest) InstallSnapshotRequest {
+	return InstallSnapshotRequest{
+		Term:             req.Term,
+		LeaderID:         NodeID(req.LeaderId),
+		LastIncludedTxID: req.LastIncludedTxId,
+		LastIncludedTerm: req.LastIncludedTerm,
+		Offset:           req.Offset,
+		Data:             req.Data,
+		Done:             req.Done,
+	}
+}
+
+func installSnapshotResponseToProto(resp InstallSnapshotResponse) *raftpb.InstallSnapshotResponse {
+	return &raftpb.InstallSnapshotResponse{
+		Term:    resp.Term,
+		Success: resp.Success,
+	}
+}
+
+func installSnapshotResponseFromProto(resp *raftpb.InstallSnapshotResponse) InstallSnapshotResponse {
+	return InstallSnapshotResponse{
+		Term:    resp.Term,
+		Success: resp.Success,
+	}
+}
+
+func timeoutNowToProto(req TimeoutNowRequest) *raftpb.TimeoutNowRequest {
+	return &raftpb.TimeoutNowRequest{
+		Term:     req.Term,
+		LeaderId: string(req.LeaderID),
+	}
+}
+
+func timeoutNowFromProto(req *raftpb.TimeoutNowRequest) TimeoutNowRequest {
+	return TimeoutNowRequest{
+		Term:     req.Term,
+		LeaderID: NodeID(req.LeaderId),
+	}
+}
+
+func timeoutNowResponseToProto(resp TimeoutNowResponse) *raftpb.TimeoutNowResponse {
+	return &raftpb.TimeoutNowResponse{
+		Term:    resp.Term,
+		Success: resp.Success,
+	}
+}
+
+func timeoutNowResponseFromProto(resp *raftpb.TimeoutNowResponse) TimeoutNowResponse {
+	return TimeoutNowResponse{
+		Term:    resp.Term,
+		Success: resp.Success,
+	}
+}
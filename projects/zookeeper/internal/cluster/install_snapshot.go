@@ -0,0 +1,230 @@
+package cluster
+
+// THE PROBLEM:
+//
+// After compaction, the leader no longer has the early part of its log.
+// A follower that was down long enough asks for entries that are gone:
+//
+//   leader:    snapshot@9000  log [4001 ........ 9500]
+//   follower:  log [1 .. 120]      nextIndex = 121 → wal.ErrCompacted
+//
+// AppendEntries can't help — there's nothing left to send.
+//
+// THE FIX: SEND THE SNAPSHOT
+//
+// The snapshot already contains everything up to 9000. The leader ships
+// the file itself, in chunks, and the follower swaps its state for it:
+//
+//   Leader                                   Follower
+//     │── InstallSnapshot(Offset=0, ...) ──→   start buffering
+//     │←──────────── Success ───────────────│
+//     │── InstallSnapshot(Offset=N, ...) ──→   append to buffer
+//     │←──────────── Success ───────────────│
+//     │── InstallSnapshot(..., Done) ──────→   store.RestoreSnapshot
+//     │←──────────── Success ───────────────│   commitIndex = 9000
+//     │
+//     nextIndex = 9001, matchIndex = 9000
+//     │── AppendEntries(prevLog=9000, [9001 ...]) ──→  normal replication
+//
+// WHY CHUNKS?
+//
+// A snapshot can be hundreds of megabytes. One giant message would blow
+// past gRPC's message size limit and hold everything up for the whole
+// transfer. Chunks keep each message small, and a follower that gets
+// them out of order (or a leader that restarts mid-way) just rejects
+// and the transfer starts over from Offset 0.
+//
+// WHY A GOROUTINE?
+//
+// A transfer takes many round trips. Doing it inside leaderTick would
+// stop heartbeats to every other peer until it's done — long enough for
+// them to start an election. So leaderTick only starts the transfer, and
+// marks the peer so the next tick doesn't start another one.
+
+// DefaultSnapshotChunkSize is how many snapshot bytes are sent per
+// InstallSnapshot message. Well under gRPC's 4 MiB default limit.
+const DefaultSnapshotChunkSize = 256 << 10 // 256 KiB
+
+// incomingSnapshot is a snapshot being received chunk by chunk.
+type incomingSnapshot struct {
+	txID int64
+	term int64
+	data []byte
+}
+
+// startSnapshotTransfer sends the latest snapshot to peer in the
+// background, unless a transfer to that peer is already running.
+//
+// Must be called with rn.mu held.
+func (rn *RaftNode) startSnapshotTransfer(peer Peer, term int64) {
+	if rn.sendingSnapshot[peer.ID] {
+		return
+	}
+	rn.sendingSnapshot[peer.ID] = true
+
+	go func() {
+		rn.sendSnapshot(peer, term)
+
+		rn.mu.Lock()
+		delete(rn.sendingSnapshot, peer.ID)
+		rn.mu.Unlock()
+	}()
+}
+
+// sendSnapshot sends the snapshot to peer, one chunk at a time.
+// On success, the peer's nextIndex moves past the snapshot, so the
+// next leaderTick continues with AppendEntries.
+func (rn *RaftNode) sendSnapshot(peer Peer, term int64) {
+	rn.mu.Lock()
+	data, txID, snapTerm, err := rn.store.ReadSnapshot()
+	leaderID := rn.config.Self
+	chunkSize := rn.snapshotChunkSize
+	rn.mu.Unlock()
+
+	if err != nil {
+		rn.logger.Error("failed to read snapshot for peer", "peer", peer.ID, "error", err)
+		return
+	}
+
+	rn.logger.Info("sending snapshot",
+		"peer", peer.ID,
+		"txid", txID,
+		"bytes", len(data),
+	)
+	start := rn.now()
+
+	for offset := 0; ; {
+		end := min(offset+chunkSize, len(data))
+		req := InstallSnapshotRequest{
+			Term:             term,
+			LeaderID:         leaderID,
+			LastIncludedTxID: txID,
+			LastIncludedTerm: snapTerm,
+			Offset:           int64(offset),
+			Data:             data[offset:end],
+			Done:             end == len(data),
+		}
+
+		resp, err := rn.transport.SendInstallSnapshot(peer, req)
+		if err != nil {
+			return // peer unreachable, leaderTick retries later
+		}
+
+		if resp.Term > term {
+			rn.mu.Lock()
+			if resp.Term > rn.state.CurrentTerm {
+				rn.becomeFollower(resp.Term, "")
+			}
+			rn.mu.Unlock()
+			return
+		}
+		if !resp.Success {
+			return
+		}
+		if req.Done {
+			break
+		}
+		offset = end
+	}
+
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	// We might have lost leadership while the transfer was running.
+	if rn.state.Role != Leader || rn.state.CurrentTerm != term {
+		return
+	}
+	if rn.nextIndex[peer.ID] <= txID {
+		rn.nextIndex[peer.ID] = txID + 1
+	}
+	if rn.matchIndex[peer.ID] < txID {
+		rn.matchIndex[peer.ID] = txID
+	}
+
+	rn.logger.Info("snapshot sent",
+		"peer", peer.ID,
+		"txid", txID,
+		"took", rn.since(start),
+	)
+}
+
+// HandleInstallSnapshot processes one chunk of a snapshot from the leader.
+//
+// Rules:
+//  1. Reject a stale term, same as AppendEntries.
+//  2. Offset 0 starts a new transfer; anything else must continue the
+//     current one exactly where it stopped.
+//  3. On the last chunk, restore the store from the snapshot — unless
+//     we've already applied past it, in which case it has nothing new.
+func (rn *RaftNode) HandleInstallSnapshot(req InstallSnapshotRequest) InstallSnapshotResponse {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	// Rule 1: reject if the sender's term is old.
+	if req.Term < rn.state.CurrentTerm {
+		rn.logger.Info("rejecting InstallSnapshot: stale term",
+			"from", req.LeaderID,
+			"their_term", req.Term,
+			"my_term", rn.state.CurrentTerm,
+		)
+		return InstallSnapshotResponse{Term: rn.state.CurrentTerm, Success: false}
+	}
+
+	rn.becomeFollower(req.Term, req.LeaderID)
+	rn.lastHeartbeat = rn.now()
+	rn.leaderContact = rn.lastHeartbeat
+
+	reject := InstallSnapshotResponse{Term: rn.state.CurrentTerm, Success: false}
+	ok := InstallSnapshotResponse{Term: rn.state.CurrentTerm, Success: true}
+
+	// Rule 2: chunks must arrive in order.
+	if req.Offset == 0 {
+		rn.incoming = &incomingSnapshot{
+			txID: req.LastIncludedTxID,
+			term: req.LastIncludedTerm,
+		}
+	}
+	in := rn.incoming
+	if in == nil || in.txID != req.LastIncludedTxID || in.term != req.LastIncludedTerm ||
+		req.Offset != int64(len(in.data)) {
+		rn.logger.Info("rejecting InstallSnapshot: unexpected chunk",
+			"from", req.LeaderID,
+			"txid", req.LastIncludedTxID,
+			"offset", req.Offset,
+		)
+		rn.incoming = nil
+		return reject
+	}
+	in.data = append(in.data, req.Data...)
+	if !req.Done {
+		return ok
+	}
+	rn.incoming = nil
+
+	// Rule 3: install.
+	if in.txID <= rn.lastApplied {
+		return ok
+	}
+	if err := rn.store.RestoreSnapshot(in.data, in.txID, in.term); err != nil {
+		rn.logger.Error("failed to install snapshot",
+			"txid", in.txID,
+			"error", err,
+		)
+		return reject
+	}
+	if data := rn.store.Config(); data != nil {
+		rn.applyMembers(data)
+	}
+	if rn.commitIndex < in.txID {
+		rn.commitIndex = in.txID
+	}
+	rn.lastApplied = in.txID
+	rn.noteApplied()
+
+	rn.logger.Info("installed snapshot",
+		"from", req.LeaderID,
+		"txid", in.txID,
+		"bytes", len(in.data),
+	)
+	return ok
+}
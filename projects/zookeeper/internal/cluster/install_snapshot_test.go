@@ -0,0 +1,233 @@
+package cluster
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syamsularifin/zookeeper/internal/store"
+	"github.com/syamsularifin/zookeeper/internal/wal"
+)
+
+// electNode1 makes node-1 the leader of the test cluster.
+func electNode1(t *testing.T, nodes map[NodeID]*RaftNode) *RaftNode {
+	t.Helper()
+	node1 := nodes["node-1"]
+	voteReq := node1.StartElection()
+	votes := 1
+	for _, peer := range node1.config.OtherPeers() {
+		node1.CollectVote(nodes[peer.ID].HandleRequestVote(voteReq), &votes)
+	}
+	if node1.GetState().Role != Leader {
+		t.Fatal("node-1 should be leader")
+	}
+	return node1
+}
+
+// waitForNextIndex polls until the leader's nextIndex for peer reaches
+// want. Snapshot transfers run in the background.
+func waitForNextIndex(t *testing.T, leader *RaftNode, peer NodeID, want int64) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		leader.mu.Lock()
+		next := leader.nextIndex[peer]
+		leader.mu.Unlock()
+		if next == want {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("nextIndex for %s never reached %d", peer, want)
+}
+
+// TestInstallSnapshot_CatchesUpFollower proves a follower behind the
+// compaction point gets the snapshot in chunks, then continues with
+// normal AppendEntries from right after it.
+func TestInstallSnapshot_CatchesUpFollower(t *testing.T) {
+	nodes, stores := newTestCluster()
+	node1 := electNode1(t, nodes)
+
+	for _, path := range []string{"/a", "/b", "/c"} {
+		node1.appendEntry("CREATE", path, nil)
+	}
+
+	// Entries 1-2 are only in the snapshot now.
+	leaderStore := stores["node-1"]
+	leaderStore.compactedTo = 2
+	leaderStore.snapshot = []byte("a snapshot of entries one and two")
+	leaderStore.snapshotTxID = 2
+	leaderStore.snapshotTerm = 1
+
+	node1.mu.Lock()
+	node1.snapshotChunkSize = 4 // many chunks
+	node1.nextIndex["node-3"] = 1
+	node1.mu.Unlock()
+
+	node1.leaderTick()
+	waitForNextIndex(t, node1, "node-3", 3)
+
+	follower := stores["node-3"]
+	nodes["node-3"].mu.Lock()
+	got := string(follower.snapshot)
+	applied := nodes["node-3"].lastApplied
+	nodes["node-3"].mu.Unlock()
+
+	if got != string(leaderStore.snapshot) {
+		t.Fatalf("follower got %q, want the whole snapshot", got)
+	}
+	if applied != 2 {
+		t.Fatalf("expected lastApplied 2 after install, got %d", applied)
+	}
+
+	// Next tick: plain AppendEntries with prevLog = the snapshot's last entry.
+	node1.leaderTick()
+
+	if follower.LastWALTxID() != 3 || follower.entries[2].Path != "/c" {
+		t.Fatalf("expected entry 3 (/c) after the snapshot, got %+v", follower.entries)
+	}
+	if node1.matchIndex["node-3"] != 3 {
+		t.Fatalf("expected matchIndex 3 for node-3, got %d", node1.matchIndex["node-3"])
+	}
+}
+
+func TestInstallSnapshot_RejectsStaleTerm(t *testing.T) {
+	node, ms := newTestNode("node-1")
+	node.state.CurrentTerm = 5
+
+	resp := node.HandleInstallSnapshot(InstallSnapshotRequest{
+		Term: 3, LeaderID: "node-2", LastIncludedTxID: 10, LastIncludedTerm: 3,
+		Data: []byte("snap"), Done: true,
+	})
+
+	if resp.Success || resp.Term != 5 {
+		t.Fatalf("expected rejection with term 5, got %+v", resp)
+	}
+	if ms.snapshot != nil {
+		t.Fatal("stale snapshot must not be installed")
+	}
+}
+
+func TestInstallSnapshot_RejectsChunkOutOfOrder(t *testing.T) {
+	node, ms := newTestNode("node-1")
+
+	first := InstallSnapshotRequest{
+		Term: 1, LeaderID: "node-2", LastIncludedTxID: 10, LastIncludedTerm: 1,
+		Offset: 0, Data: []byte("abcd"),
+	}
+	if resp := node.HandleInstallSnapshot(first); !resp.Success {
+		t.Fatal("first chunk should be accepted")
+	}
+
+	// Offset 8 skips the chunk at 4.
+	skipped := first
+	skipped.Offset = 8
+	skipped.Data = []byte("ijkl")
+	skipped.Done = true
+	if resp := node.HandleInstallSnapshot(skipped); resp.Success {
+		t.Fatal("chunk with a gap should be rejected")
+	}
+
+	// The transfer was abandoned: even the right next chunk is rejected
+	// until the leader starts over from 0.
+	next := first
+	next.Offset = 4
+	next.Data = []byte("efgh")
+	next.Done = true
+	if resp := node.HandleInstallSnapshot(next); resp.Success {
+		t.Fatal("chunk after an abandoned transfer should be rejected")
+	}
+	if ms.snapshot != nil {
+		t.Fatal("nothing should be installed")
+	}
+}
+
+func TestInstallSnapshot_SkipsOlderSnapshot(t *testing.T) {
+	node, ms := newTestNode("node-1")
+	for i := int64(1); i <= 5; i++ {
+		ms.entries = append(ms.entries, wal.Entry{TxID: i, Term: 1})
+	}
+	node.commitIndex = 5
+	node.lastApplied = 5
+
+	resp := node.HandleInstallSnapshot(InstallSnapshotRequest{
+		Term: 1, LeaderID: "node-2", LastIncludedTxID: 3, LastIncludedTerm: 1,
+		Data: []byte("old"), Done: true,
+	})
+
+	if !resp.Success {
+		t.Fatal("an older snapshot is harmless and should be acknowledged")
+	}
+	if ms.snapshot != nil || len(ms.entries) != 5 {
+		t.Fatal("an older snapshot must not replace newer state")
+	}
+}
+
+// TestInstallSnapshot_RealStore runs a transfer between two real Stores:
+// the follower rebuilds its tree from the leader's snapshot file and
+// keeps replicating after it.
+func TestInstallSnapshot_RealStore(t *testing.T) {
+	ft := &fakeTransport{nodes: make(map[NodeID]*RaftNode)}
+	stores := make(map[NodeID]*store.Store)
+	opts := store.Options{RetainEntries: 2}
+
+	for _, p := range testPeers {
+		dir := t.TempDir()
+		s, err := store.NewWithOptions(filepath.Join(dir, "wal"), filepath.Join(dir, "snapshot.json"), opts)
+		if err != nil {
+			t.Fatalf("store.NewWithOptions failed: %v", err)
+		}
+		defer s.Close()
+		stores[p.ID] = s
+		ft.nodes[p.ID] = newNode(Config{Self: p.ID, Peers: testPeers}, ft, s)
+	}
+
+	// node-3 is down while node-1 and node-2 commit 10 writes.
+	node3 := ft.nodes["node-3"]
+	delete(ft.nodes, "node-3")
+
+	node1 := electNode1(t, map[NodeID]*RaftNode{"node-1": ft.nodes["node-1"], "node-2": ft.nodes["node-2"], "node-3": node3})
+	node1.mu.Lock()
+	node1.snapshotChunkSize = 64
+	node1.mu.Unlock()
+
+	for i := 1; i <= 10; i++ {
+		if _, err := node1.Propose("CREATE", fmt.Sprintf("/n%d", i), []byte("v")); err != nil {
+			t.Fatalf("Propose %d failed: %v", i, err)
+		}
+	}
+
+	// Snapshot at 10, keep 2 → node-3's next entry (1) is gone.
+	node1.mu.Lock()
+	if err := stores["node-1"].TakeSnapshot(); err != nil {
+		node1.mu.Unlock()
+		t.Fatalf("TakeSnapshot failed: %v", err)
+	}
+	node1.mu.Unlock()
+
+	ft.nodes["node-3"] = node3
+	node1.leaderTick()
+	waitForNextIndex(t, node1, "node-3", 11)
+
+	node3.mu.Lock()
+	for i := 1; i <= 10; i++ {
+		if _, err := stores["node-3"].Get(fmt.Sprintf("/n%d", i)); err != nil {
+			node3.mu.Unlock()
+			t.Fatalf("/n%d missing on node-3 after install: %v", i, err)
+		}
+	}
+	node3.mu.Unlock()
+
+	// Back to normal replication.
+	if _, err := node1.Propose("CREATE", "/n11", []byte("v")); err != nil {
+		t.Fatalf("Propose after install failed: %v", err)
+	}
+	node1.leaderTick()
+
+	node3.mu.Lock()
+	defer node3.mu.Unlock()
+	if _, err := stores["node-3"].Get("/n11"); err != nil {
+		t.Fatalf("/n11 should replicate normally after install: %v", err)
+	}
+}
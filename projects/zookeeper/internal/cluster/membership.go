@@ -0,0 +1,333 @@
+package cluster
+
+// THE PROBLEM:
+//
+// The --peers list is fixed at startup. Replacing a dead node means
+// restarting every node with new flags — and if two nodes briefly
+// disagree about who's in the cluster, they can disagree about what a
+// majority is:
+//
+//   old config {A, B, C}      majority = 2 → A + B
+//   new config {A, B, C, D, E} majority = 3 → C + D + E
+//
+// Two leaders in the same term, each with "a majority". Split brain.
+//
+// THE FIX: CONFIG ENTRIES, ONE NODE AT A TIME (Raft thesis §4.1)
+//
+// The membership is replicated state, like the tree. Changing it is a
+// CONFIG entry in the log, carrying the whole new member list, and it
+// takes effect on every node when that entry is applied — on commit,
+// the same moment a write would become visible.
+//
+// Each change adds or removes exactly ONE voter. Any majority of
+// {A, B, C} and any majority of {A, B, C, D} overlap, so the old and
+// the new config can never elect two leaders, whichever one a node is
+// on. Bigger changes are a series of single ones.
+//
+// Two rules keep "old and new" from becoming "old, new and newer":
+//
+//   - one change at a time (changeMu), and
+//   - the leader must have committed an entry of its own term first
+//     (commitInTerm), so no change from an earlier leader is still
+//     waiting to commit.
+//
+// LEARNERS:
+//
+// A new node starts with an empty log. Make it a voter right away, and
+// the cluster may need its vote before it can give one:
+//
+//   {A, B, C} + empty D, C is down → need 3 of {A, B, D}
+//                                    D is still catching up → stall
+//
+// So AddPeer adds the node as a LEARNER first: it receives the log
+// (entries, or a snapshot), but doesn't vote and doesn't count towards
+// a quorum. Once it keeps up with the leader, a second CONFIG entry
+// promotes it:
+//
+//   AddPeer(D)
+//     1. CONFIG {A, B, C, D (learner)}   → leader starts replicating to D
+//     2. wait for D to catch up           (rounds, see waitCaughtUp)
+//     3. CONFIG {A, B, C, D}             → D votes, majority is 3
+//
+// REMOVAL:
+//
+// RemovePeer commits a CONFIG without the node. The leader stops
+// talking to it. A leader that removes itself sends one more heartbeat
+// round — so the others learn the entry committed — then steps down,
+// and they elect a new leader among themselves.
+//
+// A removed node may never learn it was removed: the leader stops
+// sending before it can say so. If it times out and campaigns, voters
+// that hear from their leader ignore it (HandleRequestVote, rule 1b).
+// Shut it down.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/syamsularifin/zookeeper/internal/wal"
+)
+
+// maxCatchUpRounds is how many replication rounds a learner gets to
+// catch up before AddPeer gives up on promoting it.
+const maxCatchUpRounds = 10
+
+// member is how a Peer is written into a CONFIG entry.
+type member struct {
+	ID         NodeID `json:"id"`
+	Addr       string `json:"addr"`
+	ClientAddr string `json:"client_addr,omitempty"`
+	Learner    bool   `json:"learner,omitempty"`
+}
+
+// encodeMembers turns a member list into a CONFIG entry's Data.
+func encodeMembers(peers []Peer) ([]byte, error) {
+	members := make([]member, len(peers))
+	for i, p := range peers {
+		members[i] = member{ID: p.ID, Addr: p.Addr, ClientAddr: p.ClientAddr, Learner: p.Learner}
+	}
+	return json.Marshal(members)
+}
+
+// decodeMembers is the reverse of encodeMembers.
+func decodeMembers(data []byte) ([]Peer, error) {
+	var members []member
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, fmt.Errorf("invalid membership: %w", err)
+	}
+	if len(members) == 0 {
+		return nil, fmt.Errorf("invalid membership: no members")
+	}
+	peers := make([]Peer, len(members))
+	for i, m := range members {
+		peers[i] = Peer{ID: m.ID, Addr: m.Addr, ClientAddr: m.ClientAddr, Learner: m.Learner}
+	}
+	return peers, nil
+}
+
+// Members returns every node in the current configuration, learners
+// included.
+func (rn *RaftNode) Members() []Peer {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	return append([]Peer(nil), rn.config.Peers...)
+}
+
+// AddPeer adds a node to the cluster: first as a learner, then, once it
+// has caught up, as a voter. It returns when the node votes, or with
+// an error if it doesn't — the node may be left a learner then, and
+// calling AddPeer again picks up from there.
+//
+// The node must already be running and reachable at peer.Addr.
+// Only the leader can add peers; anyone else returns a *NotLeaderError.
+func (rn *RaftNode) AddPeer(ctx context.Context, peer Peer) error {
+	if peer.ID == "" || peer.Addr == "" {
+		return fmt.Errorf("a peer needs an ID and a raft address")
+	}
+
+	rn.changeMu.Lock()
+	defer rn.changeMu.Unlock()
+
+	if err := rn.commitInTerm(); err != nil {
+		return err
+	}
+
+	// Step 1: join as a learner — unless a previous attempt got that far.
+	rn.mu.Lock()
+	existing, ok := rn.config.Peer(peer.ID)
+	peers := rn.config.Peers
+	rn.mu.Unlock()
+
+	if ok && !existing.Learner {
+		return fmt.Errorf("%w: %s", ErrPeerExists, peer.ID)
+	}
+	if !ok {
+		peer.Learner = true
+		if err := rn.proposeMembers(append(append([]Peer(nil), peers...), peer)); err != nil {
+			return err
+		}
+	}
+
+	// Step 2: catch up.
+	if err := rn.waitCaughtUp(ctx, peer.ID); err != nil {
+		return err
+	}
+
+	// Step 3: promote.
+	rn.mu.Lock()
+	promoted := append([]Peer(nil), rn.config.Peers...)
+	rn.mu.Unlock()
+	for i := range promoted {
+		if promoted[i].ID == peer.ID {
+			promoted[i].Learner = false
+		}
+	}
+	return rn.proposeMembers(promoted)
+}
+
+// RemovePeer removes a node — voter or learner — from the cluster.
+// It returns once the new configuration is committed.
+//
+// Only the leader can remove peers; anyone else returns a *NotLeaderError.
+func (rn *RaftNode) RemovePeer(id NodeID) error {
+	rn.changeMu.Lock()
+	defer rn.changeMu.Unlock()
+
+	if err := rn.commitInTerm(); err != nil {
+		return err
+	}
+
+	rn.mu.Lock()
+	removed, ok := rn.config.Peer(id)
+	peers := rn.config.Peers
+	voters := len(rn.config.Voters())
+	rn.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownPeer, id)
+	}
+	if !removed.Learner && voters == 1 {
+		return fmt.Errorf("can't remove %s: it's the last voter", id)
+	}
+
+	var rest []Peer
+	for _, p := range peers {
+		if p.ID != id {
+			rest = append(rest, p)
+		}
+	}
+	if err := rn.proposeMembers(rest); err != nil {
+		return err
+	}
+
+	// Removed ourselves: step down now rather than on the next tick.
+	if id == rn.config.Self {
+		rn.leaderTick()
+	}
+	return nil
+}
+
+// proposeMembers commits a CONFIG entry. By the time it returns, the
+// leader is on the new configuration.
+func (rn *RaftNode) proposeMembers(peers []Peer) error {
+	data, err := encodeMembers(peers)
+	if err != nil {
+		return err
+	}
+	_, err = rn.ProposeEntry(wal.Entry{Op: wal.OpConfig, Data: data})
+	return err
+}
+
+// waitCaughtUp replicates to a learner until it keeps up with the
+// leader. Each round, note where the leader's log ends and replicate
+// until the learner has that much. A round that takes less than an
+// election timeout means the learner is close enough that promoting
+// it won't stall the cluster for longer than a failover would.
+//
+// A learner that can't manage that in maxCatchUpRounds rounds gets
+// ErrLearnerBehind.
+func (rn *RaftNode) waitCaughtUp(ctx context.Context, id NodeID) error {
+	for round := 1; round <= maxCatchUpRounds; round++ {
+		rn.mu.Lock()
+		target := rn.store.LastWALTxID()
+		rn.mu.Unlock()
+		start := time.Now()
+
+		for {
+			done, err := rn.replicatedTo(id, target)
+			if err != nil {
+				return err
+			}
+			if done {
+				break
+			}
+
+			// Don't wait for the loop's next heartbeat — send now.
+			rn.leaderTick()
+			if done, _ := rn.replicatedTo(id, target); done {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(rn.heartbeatInterval):
+			}
+		}
+
+		if time.Since(start) < rn.electionTimeoutMin {
+			return nil
+		}
+		rn.logger.Info("learner still catching up",
+			"peer", id,
+			"round", round,
+			"target", target,
+		)
+	}
+	return fmt.Errorf("%w: %s after %d rounds", ErrLearnerBehind, id, maxCatchUpRounds)
+}
+
+// replicatedTo reports whether peer id has every entry up to index.
+func (rn *RaftNode) replicatedTo(id NodeID, index int64) (bool, error) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	if rn.state.Role != Leader {
+		return false, rn.notLeaderError()
+	}
+	return rn.matchIndex[id] >= index, nil
+}
+
+// otherVoters is who a candidate asks for votes.
+func (rn *RaftNode) otherVoters() []Peer {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	var voters []Peer
+	for _, p := range rn.config.OtherPeers() {
+		if !p.Learner {
+			voters = append(voters, p)
+		}
+	}
+	return voters
+}
+
+// applyMembers switches to the membership in a CONFIG entry's Data —
+// the entry just applied, or the one a snapshot carried.
+//
+// Must be called with rn.mu held.
+func (rn *RaftNode) applyMembers(data []byte) {
+	peers, err := decodeMembers(data)
+	if err != nil {
+		rn.logger.Error("ignoring membership change", "error", err)
+		return
+	}
+	rn.config.Peers = peers
+
+	if rn.state.Role == Leader {
+		// Start replicating to new members, forget removed ones.
+		last := rn.store.LastWALTxID()
+		for _, p := range rn.config.OtherPeers() {
+			if _, ok := rn.nextIndex[p.ID]; !ok {
+				rn.nextIndex[p.ID] = last + 1
+				rn.matchIndex[p.ID] = 0
+			}
+		}
+		for id := range rn.nextIndex {
+			if _, ok := rn.config.Peer(id); !ok {
+				delete(rn.nextIndex, id)
+				delete(rn.matchIndex, id)
+			}
+		}
+
+		// A leader that removed itself steps down at the end of its next
+		// heartbeat round (see leaderTick), not here: that round is how
+		// the others learn the change committed.
+	}
+
+	rn.logger.Info("membership changed",
+		"voters", len(rn.config.Voters()),
+		"members", len(rn.config.Peers),
+	)
+}
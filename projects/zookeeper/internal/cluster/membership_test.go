@@ -0,0 +1,176 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/syamsularifin/zookeeper/internal/wal"
+)
+
+// addTestNode starts a node that isn't a member yet, reachable through
+// the cluster's transport — like a new machine started with --join.
+func addTestNode(nodes map[NodeID]*RaftNode, id NodeID) (*RaftNode, *memoryStorage) {
+	ft := nodes["node-1"].transport.(*fakeTransport)
+	ms := newMemoryStorage()
+	peers := append(append([]Peer(nil), testPeers...), Peer{ID: id, Addr: "localhost:3004", Learner: true})
+	node := newNode(Config{Self: id, Peers: peers}, ft, ms)
+	nodes[id] = node
+	return node, ms
+}
+
+// TestAddPeer_LearnerCatchesUpThenVotes proves AddPeer goes through a
+// learner config before the voter one, and the new node has the whole
+// log by the time it votes.
+func TestAddPeer_LearnerCatchesUpThenVotes(t *testing.T) {
+	nodes, stores := newTestCluster()
+	leader := electNode1(t, nodes)
+	for _, path := range []string{"/a", "/b", "/c"} {
+		if _, err := leader.Propose("CREATE", path, nil); err != nil {
+			t.Fatalf("Propose failed: %v", err)
+		}
+	}
+	node4, ms4 := addTestNode(nodes, "node-4")
+
+	if err := leader.AddPeer(context.Background(), Peer{ID: "node-4", Addr: "localhost:3004"}); err != nil {
+		t.Fatalf("AddPeer failed: %v", err)
+	}
+
+	var configs []wal.Entry
+	for _, e := range stores["node-1"].entries {
+		if e.Op == wal.OpConfig {
+			configs = append(configs, e)
+		}
+	}
+	if len(configs) != 2 {
+		t.Fatalf("expected a learner and a voter CONFIG entry, got %d", len(configs))
+	}
+	if learners, _ := decodeMembers(configs[0].Data); !learners[3].Learner {
+		t.Fatalf("first CONFIG should add node-4 as a learner, got %+v", learners)
+	}
+
+	if !leader.config.IsVoter("node-4") || leader.config.QuorumSize() != 3 {
+		t.Fatalf("expected node-4 to vote and a quorum of 3, got %+v", leader.Members())
+	}
+	if ms4.LastWALTxID() != stores["node-1"].LastWALTxID() {
+		t.Fatalf("node-4 should have the whole log: %d vs %d",
+			ms4.LastWALTxID(), stores["node-1"].LastWALTxID())
+	}
+
+	// The next round tells node-4 its own promotion committed.
+	leader.leaderTick()
+	if !node4.config.IsVoter("node-4") {
+		t.Fatalf("node-4 should know it votes, got %+v", node4.Members())
+	}
+}
+
+// TestAddPeer_LearnerDoesNotCount proves a learner's acks don't make a
+// quorum, and a learner never starts an election.
+func TestAddPeer_LearnerDoesNotCount(t *testing.T) {
+	nodes, _ := newTestCluster()
+	leader := electNode1(t, nodes)
+	node4, _ := addTestNode(nodes, "node-4")
+	if err := leader.proposeMembers(node4.Members()); err != nil {
+		t.Fatalf("proposeMembers failed: %v", err)
+	}
+
+	// node-2 and node-3 go away. node-4 still answers, but it's a learner.
+	delete(nodes, "node-2")
+	delete(nodes, "node-3")
+	if _, err := leader.Propose("CREATE", "/app", nil); !errors.Is(err, ErrNoQuorum) {
+		t.Fatalf("expected ErrNoQuorum with only a learner left, got %v", err)
+	}
+
+	node4.mu.Lock()
+	node4.lastHeartbeat = time.Now().Add(-time.Minute)
+	node4.mu.Unlock()
+	term := node4.GetState().CurrentTerm
+	node4.followerTick()
+	if state := node4.GetState(); state.Role != Follower || state.CurrentTerm != term {
+		t.Fatalf("a learner must not campaign, got %+v", state)
+	}
+}
+
+// TestAddPeer_UnreachableStaysLearner proves a node that never catches
+// up isn't promoted, and can be removed again.
+func TestAddPeer_UnreachableStaysLearner(t *testing.T) {
+	nodes, _ := newTestCluster()
+	leader := electNode1(t, nodes)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err := leader.AddPeer(ctx, Peer{ID: "node-5", Addr: "localhost:3005"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	if p, ok := leader.config.Peer("node-5"); !ok || !p.Learner {
+		t.Fatalf("node-5 should be left a learner, got %+v", leader.Members())
+	}
+	if leader.config.QuorumSize() != 2 {
+		t.Fatalf("a learner must not change the quorum, got %d", leader.config.QuorumSize())
+	}
+
+	if err := leader.RemovePeer("node-5"); err != nil {
+		t.Fatalf("RemovePeer failed: %v", err)
+	}
+	if len(leader.Members()) != 3 {
+		t.Fatalf("expected 3 members after removal, got %+v", leader.Members())
+	}
+	if err := leader.RemovePeer("node-5"); !errors.Is(err, ErrUnknownPeer) {
+		t.Fatalf("expected ErrUnknownPeer, got %v", err)
+	}
+	if err := leader.AddPeer(context.Background(), Peer{ID: "node-2", Addr: "localhost:3002"}); !errors.Is(err, ErrPeerExists) {
+		t.Fatalf("expected ErrPeerExists, got %v", err)
+	}
+}
+
+// TestRemovePeer_LeaderStepsDown proves a leader that removes itself
+// tells the others before it goes, and they carry on as a 2-node
+// cluster without it.
+func TestRemovePeer_LeaderStepsDown(t *testing.T) {
+	nodes, _ := newTestCluster()
+	leader := electNode1(t, nodes)
+
+	if err := leader.RemovePeer("node-1"); err != nil {
+		t.Fatalf("RemovePeer failed: %v", err)
+	}
+	if leader.GetState().Role == Leader {
+		t.Fatal("node-1 should have stepped down")
+	}
+
+	node2 := nodes["node-2"]
+	if _, ok := node2.config.Peer("node-1"); ok || node2.config.QuorumSize() != 2 {
+		t.Fatalf("node-2 should be on the 2-node config, got %+v", node2.Members())
+	}
+
+	// node-2 wins with node-3's vote alone, and node-1 never hears of it.
+	node2.mu.Lock()
+	node2.leaderContact = time.Time{}
+	node2.mu.Unlock()
+	nodes["node-3"].mu.Lock()
+	nodes["node-3"].leaderContact = time.Time{}
+	nodes["node-3"].mu.Unlock()
+	node2.runElection()
+	if node2.GetState().Role != Leader {
+		t.Fatal("node-2 should have won the election")
+	}
+	if _, err := node2.Propose("CREATE", "/app", nil); err != nil {
+		t.Fatalf("Propose on the new leader failed: %v", err)
+	}
+}
+
+// TestMembership_SurvivesRestart proves a node restarted with its old
+// --peers picks up the membership the log committed instead.
+func TestMembership_SurvivesRestart(t *testing.T) {
+	nodes, stores := newTestCluster()
+	leader := electNode1(t, nodes)
+	if err := leader.RemovePeer("node-3"); err != nil {
+		t.Fatalf("RemovePeer failed: %v", err)
+	}
+
+	restarted := newNode(Config{Self: "node-1", Peers: testPeers}, &fakeTransport{}, stores["node-1"])
+	if len(restarted.Members()) != 2 || restarted.config.QuorumSize() != 2 {
+		t.Fatalf("expected the committed 2-node membership, got %+v", restarted.Members())
+	}
+}
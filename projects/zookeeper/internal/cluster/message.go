@@ -1,6 +1,6 @@
 package cluster
 
-// Raft uses only TWO types of messages for everything:
+// Raft uses only TWO types of messages for consensus:
 //
 // 1. AppendEntries — sent by leader to followers.
 //    - With entries: "here are new WAL entries, add them to your log"
@@ -11,6 +11,14 @@ package cluster
 //    "I want to be leader. Vote for me?"
 //
 // That's it. Two message types run the entire consensus algorithm.
+//
+// Two more exist for housekeeping:
+//
+// 3. InstallSnapshot — sent by leader to a follower that's so far behind
+//    the entries it needs were compacted. "Here's my whole state instead."
+//
+// 4. TimeoutNow — sent by leader to the follower it hands leadership to.
+//    "Start an election now, don't wait for me to time out."
 
 import "github.com/syamsularifin/zookeeper/internal/wal"
 
@@ -52,7 +60,8 @@ type AppendEntriesRequest struct {
 
 	// LeaderCommitIndex is the leader's commitIndex.
 	// The follower uses this to know which entries are safe to apply.
-	// The follower sets its own commitIndex = min(LeaderCommitIndex, its lastLogTxID).
+	// The follower sets its own commitIndex = min(LeaderCommitIndex, the
+	// last entry of this request): anything after it may not be the leader's.
 	LeaderCommitIndex int64
 }
 
@@ -88,11 +97,23 @@ type RequestVoteRequest struct {
 	// CandidateID is who is asking for votes.
 	CandidateID NodeID
 
-	// LastLogTxID is the TxID of the candidate's last WAL entry.
-	// Voters use this to check: "is this candidate at least as up-to-date as me?"
-	// A node won't vote for a candidate with a shorter log — that candidate
-	// might be missing committed entries, and electing it would lose data.
+	// LastLogTxID and LastLogTerm are the TxID and term of the
+	// candidate's last WAL entry. Voters use them to check: "is this
+	// candidate at least as up-to-date as me?" — later last term wins,
+	// and with the same last term, the longer log wins. A node won't vote
+	// for a candidate that's behind: it might be missing committed
+	// entries, and electing it would lose data.
 	LastLogTxID int64
+	LastLogTerm int64
+
+	// PreVote asks "would you vote for me in Term?" without starting the
+	// election: the voter keeps its term and its vote. See prevote.go.
+	PreVote bool
+
+	// Transfer is set when the leader asked this candidate to take over
+	// (TimeoutNow). Voters that just heard from that leader vote anyway:
+	// it gave up its lease before asking. See transfer.go.
+	Transfer bool
 }
 
 // RequestVoteResponse is the voter's reply to a candidate.
@@ -107,3 +128,71 @@ type RequestVoteResponse struct {
 	//   - the candidate's log is less up-to-date than the voter's
 	VoteGranted bool
 }
+
+// InstallSnapshotRequest carries one chunk of the leader's snapshot.
+//
+// A snapshot can be much bigger than a normal message, so it's sent in
+// pieces. Each chunk says where it goes (Offset); the follower glues
+// them together and installs the snapshot when Done is true:
+//
+//	chunk 1: Offset=0,      Data=[256 KiB], Done=false
+//	chunk 2: Offset=262144, Data=[256 KiB], Done=false
+//	chunk 3: Offset=524288, Data=[12 KiB],  Done=true   → install
+type InstallSnapshotRequest struct {
+	// Term is the leader's current term. Same rules as AppendEntries.
+	Term int64
+
+	// LeaderID tells the follower who the leader is.
+	LeaderID NodeID
+
+	// LastIncludedTxID is the TxID the snapshot ends at. It replaces
+	// every entry up to and including this one.
+	LastIncludedTxID int64
+
+	// LastIncludedTerm is the term of the entry at LastIncludedTxID.
+	// The next AppendEntries uses it as prevLog.
+	LastIncludedTerm int64
+
+	// Offset is where Data goes in the snapshot file.
+	Offset int64
+
+	// Data is the chunk itself.
+	Data []byte
+
+	// Done is true on the last chunk.
+	Done bool
+}
+
+// InstallSnapshotResponse is the follower's reply to one chunk.
+type InstallSnapshotResponse struct {
+	// Term is the follower's current term. A higher term makes the
+	// leader step down, just like with AppendEntries.
+	Term int64
+
+	// Success is false if the chunk was rejected: stale term, a chunk
+	// out of order, or a snapshot that couldn't be installed. The
+	// leader gives up on this transfer and starts over later.
+	Success bool
+}
+
+// TimeoutNowRequest tells a follower to start an election right away.
+// The leader sends it once the follower's log is as long as its own.
+type TimeoutNowRequest struct {
+	// Term is the leader's current term. A follower in a later term
+	// ignores it.
+	Term int64
+
+	// LeaderID is the leader handing over.
+	LeaderID NodeID
+}
+
+// TimeoutNowResponse is the follower's reply. Success means it's
+// starting an election, not that it won one.
+type TimeoutNowResponse struct {
+	// Term is the follower's current term.
+	Term int64
+
+	// Success is false if the follower won't campaign: the request's
+	// term is stale, or it doesn't vote.
+	Success bool
+}
@@ -0,0 +1,126 @@
+package cluster
+
+// THE PROBLEM:
+//
+// NodeState.CurrentTerm and VotedFor live in memory. Imagine:
+//
+//   Term 7: node-1 votes for node-2.
+//   node-1 crashes and restarts → term 0, VotedFor "".
+//   node-3 asks for a vote in term 7 → node-1 says yes!
+//
+// node-1 voted twice in term 7. With 3 nodes, node-2 and node-3 can now
+// BOTH collect 2 votes and BOTH become leader of term 7. Raft's election
+// safety ("at most one leader per term") is gone.
+//
+// THE FIX:
+//
+// Write term + vote to a small file in the data dir, and fsync it,
+// BEFORE telling anyone about them:
+//
+//   - before granting a vote (HandleRequestVote)
+//   - before asking for votes (StartElection votes for itself)
+//   - before moving to a higher term (becomeFollower)
+//
+// On startup, NewRaftNode reads the file back. A restarted node
+// remembers its vote and refuses to vote again in the same term.
+//
+// The file is tiny and only changes when the term or vote changes —
+// not on every heartbeat — so the fsync cost is negligible.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// metaFileName is the file inside Config.DataDir that holds the durable state.
+const metaFileName = "raft-meta.json"
+
+// raftMeta is the part of NodeState that must survive a restart.
+// Role and LeaderID are NOT here: after a restart every node is a
+// follower that doesn't know the leader yet.
+type raftMeta struct {
+	CurrentTerm int64  `json:"current_term"`
+	VotedFor    NodeID `json:"voted_for"`
+}
+
+// loadMeta reads the metadata file.
+// A missing file is not an error — it's a brand new node (term 0, no vote).
+func loadMeta(path string) (raftMeta, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return raftMeta{}, nil
+		}
+		return raftMeta{}, fmt.Errorf("failed to read raft metadata: %w", err)
+	}
+
+	var m raftMeta
+	if err := json.Unmarshal(data, &m); err != nil {
+		return raftMeta{}, fmt.Errorf("failed to parse raft metadata: %w", err)
+	}
+	return m, nil
+}
+
+// saveMeta writes the metadata file durably.
+//
+// Same safe pattern as snapshot.Save: write a temp file, fsync it,
+// rename it over the old one. A crash at any point leaves either the
+// old file or the new file, never a half-written one.
+//
+// One extra step: fsync the directory. The rename itself is a change
+// to the directory, and without this a power loss could undo it —
+// bringing back the OLD vote after we already told a candidate "yes".
+func saveMeta(path string, m raftMeta) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to marshal raft metadata: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to rename: %w", err)
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("failed to open data dir for sync: %w", err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("failed to sync data dir: %w", err)
+	}
+
+	return nil
+}
+
+// persistState writes term + vote to disk if this node has a DataDir.
+// Without a DataDir (tests with memoryStorage) it does nothing.
+//
+// Must be called with rn.mu held.
+func (rn *RaftNode) persistState(term int64, votedFor NodeID) error {
+	if rn.config.DataDir == "" {
+		return nil
+	}
+	return saveMeta(filepath.Join(rn.config.DataDir, metaFileName), raftMeta{
+		CurrentTerm: term,
+		VotedFor:    votedFor,
+	})
+}
@@ -0,0 +1,149 @@
+package cluster
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newDurableNode creates a node that persists its term and vote in dir.
+// Calling it again with the same dir simulates a restart.
+func newDurableNode(t *testing.T, id NodeID, dir string) *RaftNode {
+	t.Helper()
+	node, err := NewRaftNode(Config{Self: id, Peers: testPeers, DataDir: dir}, &failingTransport{}, newMemoryStorage())
+	if err != nil {
+		t.Fatalf("NewRaftNode failed: %v", err)
+	}
+	return node
+}
+
+func TestPersist_VoteSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	node := newDurableNode(t, "node-1", dir)
+	resp := node.HandleRequestVote(RequestVoteRequest{Term: 5, CandidateID: "node-2"})
+	if !resp.VoteGranted {
+		t.Fatal("node-1 should vote for node-2")
+	}
+
+	// Crash + restart: a fresh RaftNode from the same data dir.
+	node = newDurableNode(t, "node-1", dir)
+
+	state := node.GetState()
+	if state.CurrentTerm != 5 || state.VotedFor != "node-2" {
+		t.Fatalf("expected term 5 / voted node-2 after restart, got term %d / voted %q",
+			state.CurrentTerm, state.VotedFor)
+	}
+
+	// A second candidate in the same term must be refused.
+	resp = node.HandleRequestVote(RequestVoteRequest{Term: 5, CandidateID: "node-3"})
+	if resp.VoteGranted {
+		t.Fatal("restarted node voted twice in term 5")
+	}
+}
+
+func TestPersist_SelfVoteSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	node := newDurableNode(t, "node-1", dir)
+	node.StartElection() // term 1, votes for itself
+
+	node = newDurableNode(t, "node-1", dir)
+
+	resp := node.HandleRequestVote(RequestVoteRequest{Term: 1, CandidateID: "node-2"})
+	if resp.VoteGranted {
+		t.Fatal("node-1 already voted for itself in term 1, must not vote for node-2")
+	}
+}
+
+func TestPersist_HigherTermSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	node := newDurableNode(t, "node-1", dir)
+	node.HandleAppendEntries(AppendEntriesRequest{Term: 9, LeaderID: "node-2"})
+
+	node = newDurableNode(t, "node-1", dir)
+
+	state := node.GetState()
+	if state.CurrentTerm != 9 {
+		t.Fatalf("expected term 9 after restart, got %d", state.CurrentTerm)
+	}
+	if state.VotedFor != "" {
+		t.Fatalf("no vote was cast in term 9, got %q", state.VotedFor)
+	}
+	if state.Role != Follower || state.LeaderID != "" {
+		t.Fatalf("restarted node should be a follower with no known leader, got %s / %q",
+			state.Role, state.LeaderID)
+	}
+
+	// A stale candidate from term 8 is rejected as it would have been before the restart.
+	resp := node.HandleRequestVote(RequestVoteRequest{Term: 8, CandidateID: "node-3"})
+	if resp.VoteGranted {
+		t.Fatal("vote for a stale term should be rejected")
+	}
+}
+
+// TestPersist_ElectionSafetyAcrossRestart replays the exact scenario from
+// meta.go: without durable votes, two leaders could win the same term.
+func TestPersist_ElectionSafetyAcrossRestart(t *testing.T) {
+	dirs := map[NodeID]string{
+		"node-1": t.TempDir(),
+		"node-2": t.TempDir(),
+		"node-3": t.TempDir(),
+	}
+	node1 := newDurableNode(t, "node-1", dirs["node-1"])
+	node2 := newDurableNode(t, "node-2", dirs["node-2"])
+	node3 := newDurableNode(t, "node-3", dirs["node-3"])
+
+	// Term 1: node-2 wins with node-1's vote.
+	req2 := node2.StartElection()
+	votes2 := 1
+	if !node2.CollectVote(node1.HandleRequestVote(req2), &votes2) {
+		t.Fatal("node-2 should win term 1")
+	}
+
+	// node-1 crashes and restarts before hearing anything else.
+	node1 = newDurableNode(t, "node-1", dirs["node-1"])
+
+	// node-3 (partitioned from node-2) also runs for term 1.
+	req3 := node3.StartElection()
+	if req3.Term != 1 {
+		t.Fatalf("expected node-3 to run for term 1, got %d", req3.Term)
+	}
+	votes3 := 1
+	if node3.CollectVote(node1.HandleRequestVote(req3), &votes3) {
+		t.Fatal("node-3 must not become a second leader in term 1")
+	}
+	if node2.GetState().Role != Leader {
+		t.Fatal("node-2 should still be the only leader")
+	}
+}
+
+func TestPersist_NoVoteWhenDiskFails(t *testing.T) {
+	// A data dir that doesn't exist → every write fails.
+	dir := filepath.Join(t.TempDir(), "missing")
+	node := newDurableNode(t, "node-1", dir)
+
+	resp := node.HandleRequestVote(RequestVoteRequest{Term: 1, CandidateID: "node-2"})
+	if resp.VoteGranted {
+		t.Fatal("a vote that can't be persisted must not be granted")
+	}
+
+	// Same for our own election: no durable self-vote → no election.
+	if _, ok := node.startElection(); ok {
+		t.Fatal("election should not start without a durable self-vote")
+	}
+	if node.GetState().Role == Candidate {
+		t.Fatal("node should not be a candidate")
+	}
+}
+
+func TestPersist_CorruptMetaFailsStartup(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, metaFileName), []byte("{not json"), 0644)
+
+	_, err := NewRaftNode(Config{Self: "node-1", Peers: testPeers, DataDir: dir}, &failingTransport{}, newMemoryStorage())
+	if err == nil {
+		t.Fatal("expected error for corrupt metadata, starting at term 0 would be unsafe")
+	}
+}
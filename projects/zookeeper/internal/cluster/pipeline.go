@@ -0,0 +1,406 @@
+package cluster
+
+// THE PROBLEM:
+//
+// Propose used to do one write per round, start to finish:
+//
+//   client A: send to node-2, wait ─ send to node-3, wait ─ fsync ─ apply
+//   client B:                                                          send to node-2 ...
+//
+// Every write waits for every write before it, and pays for its own
+// fsync on every node. With a 1ms round trip and a few ms per fsync,
+// that's ~150 writes/s — with 1 client or with 64.
+//
+// THE FIX: GROUP COMMIT + PIPELINING
+//
+// 1. Log first. The leader writes a batch to its own WAL before it
+//    replicates, like any Raft. (It used to write only after a majority
+//    had the entry, so that an error meant "nothing happened". That
+//    promise didn't hold up anyway: a follower that got a failed entry
+//    keeps it, and can be elected with it.) An error now means the
+//    write didn't commit in time — it may still commit later. A slow
+//    follower alone doesn't cause one: while the leader stays leader,
+//    a proposal waits for leaderTick to catch it up (see replicate).
+//
+// 2. Batch. Proposals queue up while the previous batch is being
+//    written. Whoever gets proposeMu next takes the whole queue (up to
+//    maxBatch), picks TxIDs for all of it, and writes it with ONE fsync.
+//    Followers take each AppendEntries with one fsync too, and the commit
+//    file is saved once per tick, not once per entry applied.
+//
+//      queue: [A B C D]  ──→  one WAL write, TxIDs 8-11, one fsync
+//
+// 3. Send now. The batch goes to every peer as soon as it's on disk,
+//    not on the next tick.
+//
+// 4. Pipeline. A peer's nextIndex moves past the batch as soon as it's
+//    SENT, not when it's acked, so the next batch goes out right behind
+//    it without waiting:
+//
+//      node-2:  ──[8-11]──[12-30]──[31-33]──→
+//                 ←ack 11   ←ack 30   ←ack 33
+//
+//    If a request is lost, or arrives out of order, the follower rejects
+//    the next one (prevLog missing) and nextIndex goes back to where the
+//    follower really is — the same repair leaderTick always did.
+//
+// An entry commits once a majority of voters have it, and applying it
+// answers its proposal. Only entries of the leader's own term commit by
+// counting replicas (see advanceCommitIndex); earlier ones commit with
+// them.
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/syamsularifin/zookeeper/internal/wal"
+)
+
+// DefaultMaxBatch is how many proposals go into one WAL write and one
+// AppendEntries, at most.
+const DefaultMaxBatch = 256
+
+// maxSendAttempts is how many AppendEntries sendTo tries for one batch
+// before it leaves the peer to leaderTick.
+const maxSendAttempts = 3
+
+// proposal is one write waiting for its entry to be applied.
+type proposal struct {
+	entry wal.Entry // TxID, Term and Time are set once it's in a batch
+
+	// batched is set when the proposal leaves the queue: it's in a
+	// batch, or it failed.
+	batched bool
+
+	// applied and err are the result, valid once done is closed.
+	applied wal.Entry
+	err     error
+	done    chan struct{}
+}
+
+// batch is a group of proposals written to the WAL together.
+type batch struct {
+	term      int64
+	last      int64 // TxID of the last entry
+	proposals []*proposal
+}
+
+// ProposeEntry is Propose for a fully described write: Op, Path, Data and
+// the expected Version come from the caller. The leader fills in TxID,
+// Term and Time.
+//
+// Both return the entry as applied (see Storage.ApplyTree) — for a
+// sequential CREATE, that's how the caller learns the path created.
+func (rn *RaftNode) ProposeEntry(entry wal.Entry) (wal.Entry, error) {
+	p := &proposal{entry: entry, done: make(chan struct{})}
+
+	rn.mu.Lock()
+	if rn.state.Role != Leader {
+		err := rn.notLeaderError()
+		rn.mu.Unlock()
+		return wal.Entry{}, err
+	}
+	rn.queue = append(rn.queue, p)
+	rn.mu.Unlock()
+
+	// Whoever holds proposeMu is writing a batch. Once we get it, the
+	// queue holds everything that came in meanwhile — maybe ours was
+	// already taken, maybe we take it along with others.
+	for !rn.isBatched(p) {
+		rn.proposeMu.Lock()
+		b := rn.appendBatch()
+		rn.proposeMu.Unlock()
+
+		// proposeMu is free again: the next batch is written while
+		// this one is on the wire.
+		if b != nil {
+			rn.replicate(b)
+		}
+	}
+
+	<-p.done
+	return p.applied, p.err
+}
+
+// isBatched reports whether p has left the queue.
+func (rn *RaftNode) isBatched(p *proposal) bool {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	return p.batched
+}
+
+// appendBatch takes up to maxBatch proposals off the queue, gives them
+// TxIDs and writes them to the WAL in one go. nil if there was nothing
+// to write (or it failed, and the proposals got the error).
+//
+// Must be called with rn.proposeMu held.
+func (rn *RaftNode) appendBatch() *batch {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	n := min(len(rn.queue), rn.maxBatch)
+	if n == 0 {
+		return nil
+	}
+	proposals := append([]*proposal(nil), rn.queue[:n]...)
+	rn.queue = rn.queue[n:]
+	for _, p := range proposals {
+		p.batched = true
+	}
+
+	if rn.state.Role != Leader {
+		err := rn.notLeaderError()
+		for _, p := range proposals {
+			rn.finish(p, wal.Entry{}, err)
+		}
+		return nil
+	}
+
+	term := rn.state.CurrentTerm
+	first := rn.store.LastWALTxID() + 1
+	now := rn.now().UnixMilli()
+	entries := make([]wal.Entry, n)
+	for i, p := range proposals {
+		p.entry.TxID = first + int64(i)
+		p.entry.Term = term
+		p.entry.Time = now
+		entries[i] = p.entry
+	}
+
+	if err := rn.store.AppendWAL(entries...); err != nil {
+		err = fmt.Errorf("WAL write failed: %w", err)
+		for _, p := range proposals {
+			rn.finish(p, wal.Entry{}, err)
+		}
+		return nil
+	}
+	for _, p := range proposals {
+		rn.waiting[p.entry.TxID] = p
+	}
+
+	rn.logger.Debug("appended batch",
+		"from", first,
+		"to", entries[n-1].TxID,
+		"term", term,
+	)
+	return &batch{term: term, last: entries[n-1].TxID, proposals: proposals}
+}
+
+// replicate sends a batch to every peer at once and commits as soon as
+// a majority of voters have it. It returns once every peer has
+// answered, and every proposal in the batch has its result: applied,
+// or an error if it didn't commit.
+//
+// A peer that was slow to answer, or missed a request, isn't a reason
+// to fail the batch: leaderTick sends it the entries again. So while
+// we're still the leader of b.term, replicate waits for the batch to be
+// applied, for up to electionTimeoutMax — by then a healthy cluster has
+// caught up, and a sick one has had time to elect someone else. Only
+// then, or on losing leadership, do the proposals get ErrNoQuorum.
+func (rn *RaftNode) replicate(b *batch) {
+	deadline := time.NewTimer(rn.electionTimeoutMax)
+	defer deadline.Stop()
+
+	rn.mu.Lock()
+	peers := rn.config.OtherPeers()
+	rn.mu.Unlock()
+
+	// A single node is its own majority.
+	rn.advanceCommitIndex()
+
+	done := make(chan struct{}, len(peers))
+	for _, peer := range peers {
+		go func(peer Peer) {
+			if rn.sendTo(peer, b.term, b.last) {
+				rn.advanceCommitIndex()
+			}
+			done <- struct{}{}
+		}(peer)
+	}
+	for range peers {
+		<-done
+	}
+
+	// Leadership can go without lastApplied moving, so look again
+	// every tick too.
+	check := time.NewTicker(50 * time.Millisecond)
+	defer check.Stop()
+	expired := false
+	for {
+		rn.mu.Lock()
+		if rn.lastApplied >= b.last {
+			rn.mu.Unlock()
+			return
+		}
+		if rn.state.Role != Leader || rn.state.CurrentTerm != b.term || expired {
+			break
+		}
+		moved := rn.applied
+		rn.mu.Unlock()
+
+		select {
+		case <-moved:
+		case <-check.C:
+		case <-deadline.C:
+			expired = true
+		}
+	}
+
+	// Whatever is still waiting didn't commit — not yet, anyway.
+	defer rn.mu.Unlock()
+	err := fmt.Errorf("%w: only %d/%d voters have TxID %d",
+		ErrNoQuorum, rn.replicas(b.last), len(rn.config.Voters()), b.last)
+	if rn.state.Role != Leader || rn.state.CurrentTerm != b.term {
+		err = fmt.Errorf("%w: lost leadership before TxID %d committed", ErrNoQuorum, b.last)
+	}
+	for _, p := range b.proposals {
+		rn.finish(p, wal.Entry{}, err)
+	}
+}
+
+// sendTo replicates our log up to upTo to one peer and reports whether
+// the peer has it. A rejection sends again from where the peer says it
+// is; a peer that needs a snapshot, or can't be reached, is left to
+// leaderTick.
+func (rn *RaftNode) sendTo(peer Peer, term, upTo int64) bool {
+	for attempt := 0; attempt < maxSendAttempts; attempt++ {
+		rn.mu.Lock()
+		if rn.state.Role != Leader || rn.state.CurrentTerm != term {
+			rn.mu.Unlock()
+			return false
+		}
+		next, ok := rn.nextIndex[peer.ID]
+		if !ok || rn.sendingSnapshot[peer.ID] {
+			rn.mu.Unlock()
+			return false
+		}
+		if rn.matchIndex[peer.ID] >= upTo {
+			rn.mu.Unlock()
+			return true
+		}
+
+		// Normally we send from nextIndex. If nextIndex is already past
+		// upTo, an earlier request carries our entries; send them again
+		// rather than wait for its answer.
+		from := next
+		if from > upTo {
+			from = rn.matchIndex[peer.ID] + 1
+		}
+		entries, prevLogTxID, prevLogTerm, err := rn.entriesFor(from)
+		if err != nil {
+			rn.mu.Unlock()
+			return false
+		}
+		if n := upTo - from + 1; int64(len(entries)) > n {
+			entries = entries[:n]
+		}
+
+		// Pipelining: the next batch starts where this one ends,
+		// without waiting for the ack.
+		if from == next {
+			rn.nextIndex[peer.ID] = upTo + 1
+		}
+
+		// Our commitIndex can be past upTo. Don't tell the peer about
+		// anything after the last entry we send: its log may go on with
+		// stale entries there, and it would apply them.
+		req := AppendEntriesRequest{
+			Term:              term,
+			LeaderID:          rn.config.Self,
+			PrevLogTxID:       prevLogTxID,
+			PrevLogTerm:       prevLogTerm,
+			Entries:           entries,
+			LeaderCommitIndex: min(rn.commitIndex, prevLogTxID+int64(len(entries))),
+		}
+		rn.mu.Unlock()
+
+		resp, err := rn.transport.SendAppendEntries(peer, req)
+
+		rn.mu.Lock()
+		if _, ok := rn.nextIndex[peer.ID]; !ok || rn.state.CurrentTerm != term {
+			rn.mu.Unlock()
+			return false // removed, or no longer our term
+		}
+		if err != nil {
+			// Lost on the way: whatever we sent after it will be
+			// rejected. Start over from here.
+			if rn.nextIndex[peer.ID] > from {
+				rn.nextIndex[peer.ID] = from
+			}
+			rn.mu.Unlock()
+			return false
+		}
+		if resp.Term > term {
+			rn.becomeFollower(resp.Term, "")
+			rn.mu.Unlock()
+			return false
+		}
+		if resp.Success {
+			rn.noteReplicated(peer.ID, upTo)
+			rn.mu.Unlock()
+			return true
+		}
+		rn.nextIndex[peer.ID] = resp.LastLogTxID + 1
+		rn.mu.Unlock()
+	}
+	return false
+}
+
+// noteReplicated records that peer id has every entry up to txID.
+// Answers can come back out of order, so neither index ever moves back.
+//
+// Must be called with rn.mu held.
+func (rn *RaftNode) noteReplicated(id NodeID, txID int64) {
+	if txID > rn.matchIndex[id] {
+		rn.matchIndex[id] = txID
+	}
+	if txID+1 > rn.nextIndex[id] {
+		rn.nextIndex[id] = txID + 1
+	}
+}
+
+// replicas counts the voters that have txID, this node included.
+//
+// Must be called with rn.mu held.
+func (rn *RaftNode) replicas(txID int64) int {
+	n := 0
+	for _, p := range rn.config.Voters() {
+		if p.ID == rn.config.Self || rn.matchIndex[p.ID] >= txID {
+			n++
+		}
+	}
+	return n
+}
+
+// finish hands a proposal its result. Only the first result counts.
+//
+// Must be called with rn.mu held.
+func (rn *RaftNode) finish(p *proposal, applied wal.Entry, err error) {
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+	if rn.waiting[p.entry.TxID] == p {
+		delete(rn.waiting, p.entry.TxID)
+	}
+	p.applied, p.err = applied, err
+	close(p.done)
+}
+
+// answer finishes the proposal waiting for entry, if any, now that
+// entry is applied. A proposal whose TxID ended up holding another
+// leader's entry didn't commit.
+//
+// Must be called with rn.mu held.
+func (rn *RaftNode) answer(entry, applied wal.Entry, err error) {
+	p, ok := rn.waiting[entry.TxID]
+	if !ok {
+		return
+	}
+	if p.entry.Term != entry.Term {
+		rn.finish(p, wal.Entry{}, fmt.Errorf("%w: TxID %d went to another leader's entry", ErrNoQuorum, entry.TxID))
+		return
+	}
+	rn.finish(p, applied, err)
+}
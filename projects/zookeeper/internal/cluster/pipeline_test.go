@@ -0,0 +1,411 @@
+package cluster
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/syamsularifin/zookeeper/internal/store"
+	"github.com/syamsularifin/zookeeper/internal/wal"
+)
+
+// delayTransport is a fakeTransport whose AppendEntries take a round
+// trip's time, like a network would.
+type delayTransport struct {
+	*fakeTransport
+	rtt time.Duration
+}
+
+func (dt *delayTransport) SendAppendEntries(peer Peer, req AppendEntriesRequest) (AppendEntriesResponse, error) {
+	time.Sleep(dt.rtt)
+	return dt.fakeTransport.SendAppendEntries(peer, req)
+}
+
+// gateTransport holds every AppendEntries until release is closed, and
+// records them as they're sent.
+type gateTransport struct {
+	*fakeTransport
+	release chan struct{}
+
+	mu   sync.Mutex
+	sent map[NodeID][]AppendEntriesRequest
+}
+
+func (gt *gateTransport) SendAppendEntries(peer Peer, req AppendEntriesRequest) (AppendEntriesResponse, error) {
+	gt.mu.Lock()
+	gt.sent[peer.ID] = append(gt.sent[peer.ID], req)
+	gt.mu.Unlock()
+	<-gt.release
+	return gt.fakeTransport.SendAppendEntries(peer, req)
+}
+
+func (gt *gateTransport) requests(id NodeID) []AppendEntriesRequest {
+	gt.mu.Lock()
+	defer gt.mu.Unlock()
+	return append([]AppendEntriesRequest(nil), gt.sent[id]...)
+}
+
+// dropOnceTransport fails the first AppendEntries to one peer.
+type dropOnceTransport struct {
+	*fakeTransport
+	peer    NodeID
+	dropped bool
+}
+
+func (dt *dropOnceTransport) SendAppendEntries(peer Peer, req AppendEntriesRequest) (AppendEntriesResponse, error) {
+	if peer.ID == dt.peer && !dt.dropped {
+		dt.dropped = true
+		return AppendEntriesResponse{}, fmt.Errorf("request to %s lost", peer.ID)
+	}
+	return dt.fakeTransport.SendAppendEntries(peer, req)
+}
+
+// dropFirstTransport fails the first AppendEntries to every peer, like
+// requests that timed out.
+type dropFirstTransport struct {
+	*fakeTransport
+
+	mu      sync.Mutex
+	dropped map[NodeID]bool
+}
+
+func (dt *dropFirstTransport) SendAppendEntries(peer Peer, req AppendEntriesRequest) (AppendEntriesResponse, error) {
+	dt.mu.Lock()
+	drop := !dt.dropped[peer.ID]
+	dt.dropped[peer.ID] = true
+	dt.mu.Unlock()
+	if drop {
+		return AppendEntriesResponse{}, fmt.Errorf("request to %s timed out", peer.ID)
+	}
+	return dt.fakeTransport.SendAppendEntries(peer, req)
+}
+
+// proposeAll runs one Propose per path at the same time and waits for
+// all of them.
+func proposeAll(t *testing.T, leader *RaftNode, paths ...string) {
+	t.Helper()
+	var wg sync.WaitGroup
+	for _, path := range paths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := leader.Propose("CREATE", path, nil); err != nil {
+				t.Errorf("Propose %s failed: %v", path, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TestPropose_BatchesConcurrentWrites proves writes that queue up while
+// a batch is being written go into the next one together: one WAL write
+// on every node, one AppendEntries per follower.
+func TestPropose_BatchesConcurrentWrites(t *testing.T) {
+	nodes, stores := newTestCluster()
+	leader := electNode1(t, nodes)
+
+	// Hold proposeMu, as a batch being written would, until all five
+	// writes are queued.
+	leader.proposeMu.Lock()
+	done := make(chan struct{})
+	go func() {
+		proposeAll(t, leader, "/a", "/b", "/c", "/d", "/e")
+		close(done)
+	}()
+	for queued := 0; queued < 5; {
+		time.Sleep(time.Millisecond)
+		leader.mu.Lock()
+		queued = len(leader.queue)
+		leader.mu.Unlock()
+	}
+	leader.proposeMu.Unlock()
+	<-done
+
+	for id, ms := range stores {
+		if ms.walWrites != 1 || len(ms.entries) != 5 {
+			t.Fatalf("%s: expected 5 entries in 1 WAL write, got %d in %d", id, len(ms.entries), ms.walWrites)
+		}
+	}
+	if len(stores["node-1"].applied) != 5 || leader.GetCommitIndex() != 5 {
+		t.Fatalf("expected all 5 committed and applied, got %d applied, commitIndex %d",
+			len(stores["node-1"].applied), leader.GetCommitIndex())
+	}
+}
+
+// TestPropose_PipelinesWithoutWaitingForAcks proves the next batch goes
+// out while the previous one is still unacknowledged, carrying only its
+// own entries.
+func TestPropose_PipelinesWithoutWaitingForAcks(t *testing.T) {
+	nodes, stores := newTestCluster()
+	leader := electNode1(t, nodes)
+	gt := &gateTransport{
+		fakeTransport: leader.transport.(*fakeTransport),
+		release:       make(chan struct{}),
+		sent:          make(map[NodeID][]AppendEntriesRequest),
+	}
+	leader.transport = gt
+
+	done := make(chan struct{})
+	go func() {
+		proposeAll(t, leader, "/a")
+		close(done)
+	}()
+	for len(gt.requests("node-2")) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	go proposeAll(t, leader, "/b")
+	for len(gt.requests("node-2")) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	reqs := gt.requests("node-2")
+	if len(reqs[1].Entries) != 1 || reqs[1].Entries[0].Path != "/b" || reqs[1].PrevLogTxID != 1 {
+		t.Fatalf("second request should carry only /b after TxID 1, got prevLog %d, %d entries",
+			reqs[1].PrevLogTxID, len(reqs[1].Entries))
+	}
+
+	close(gt.release)
+	<-done
+	waitFor(t, func() bool { return leader.GetCommitIndex() == 2 })
+	if got := len(stores["node-2"].entries); got != 2 {
+		t.Fatalf("node-2 should have both entries, got %d", got)
+	}
+}
+
+// TestPropose_LostRequestIsResent proves a follower that missed a batch
+// gets it again with the next one: nextIndex goes back to where the
+// lost request started.
+func TestPropose_LostRequestIsResent(t *testing.T) {
+	nodes, stores := newTestCluster()
+	leader := electNode1(t, nodes)
+	leader.transport = &dropOnceTransport{fakeTransport: leader.transport.(*fakeTransport), peer: "node-3"}
+
+	// node-2 alone makes the majority.
+	if _, err := leader.Propose("CREATE", "/a", nil); err != nil {
+		t.Fatalf("Propose failed: %v", err)
+	}
+	if len(stores["node-3"].entries) != 0 {
+		t.Fatal("node-3's request was dropped; it should have nothing yet")
+	}
+
+	if _, err := leader.Propose("CREATE", "/b", nil); err != nil {
+		t.Fatalf("Propose failed: %v", err)
+	}
+	if got := len(stores["node-3"].entries); got != 2 {
+		t.Fatalf("node-3 should have /a and /b, got %d entries", got)
+	}
+}
+
+// TestPropose_WaitsForSlowPeers proves a write the peers didn't take on
+// the first try still succeeds: while the leader is the leader, it waits
+// for leaderTick to get the entry to a majority, rather than fail it.
+func TestPropose_WaitsForSlowPeers(t *testing.T) {
+	nodes, _ := newTestCluster()
+	leader := electNode1(t, nodes)
+	leader.transport = &dropFirstTransport{
+		fakeTransport: leader.transport.(*fakeTransport),
+		dropped:       make(map[NodeID]bool),
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := leader.Propose("CREATE", "/a", nil)
+		errs <- err
+	}()
+	select {
+	case err := <-errs:
+		t.Fatalf("Propose returned before any peer had the entry: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	leader.leaderTick()
+	if err := <-errs; err != nil {
+		t.Fatalf("Propose failed: %v", err)
+	}
+	if leader.GetCommitIndex() != 1 {
+		t.Fatalf("expected commit index 1, got %d", leader.GetCommitIndex())
+	}
+}
+
+// TestCommit_EarlierTermOnlyWithOwnEntry proves a new leader doesn't
+// commit an entry from an earlier term just because a majority has it.
+// It commits with the first entry of the leader's own term.
+func TestCommit_EarlierTermOnlyWithOwnEntry(t *testing.T) {
+	nodes, stores := newTestCluster()
+	leader := electNode1(t, nodes)
+	if _, err := leader.appendEntry("CREATE", "/old", nil); err != nil {
+		t.Fatalf("appendEntry failed: %v", err)
+	}
+
+	// Re-elected in term 2 before /old went anywhere.
+	electNode1(t, nodes)
+	leader.leaderTick() // followers reject: they don't have TxID 1
+	leader.leaderTick() // now they get it
+	if got := len(stores["node-2"].entries); got != 1 {
+		t.Fatalf("node-2 should have /old, got %d entries", got)
+	}
+	if leader.GetCommitIndex() != 0 {
+		t.Fatalf("a term-1 entry must not commit by counting replicas in term 2, got commitIndex %d",
+			leader.GetCommitIndex())
+	}
+
+	if _, err := leader.Propose("CREATE", "/new", nil); err != nil {
+		t.Fatalf("Propose failed: %v", err)
+	}
+	if leader.GetCommitIndex() != 2 || len(stores["node-1"].applied) != 2 {
+		t.Fatalf("/old should commit along with /new, got commitIndex %d", leader.GetCommitIndex())
+	}
+}
+
+// TestSendTo_CommitIndexStopsAtLastEntrySent proves a request trimmed to
+// upTo doesn't carry a leader commit past its last entry.
+func TestSendTo_CommitIndexStopsAtLastEntrySent(t *testing.T) {
+	nodes, _ := newTestCluster()
+	leader := electNode1(t, nodes)
+	proposeAll(t, leader, "/a")
+	proposeAll(t, leader, "/b")
+	proposeAll(t, leader, "/c")
+	if leader.GetCommitIndex() != 3 {
+		t.Fatalf("expected commitIndex 3, got %d", leader.GetCommitIndex())
+	}
+
+	gt := &gateTransport{
+		fakeTransport: leader.transport.(*fakeTransport),
+		release:       make(chan struct{}),
+		sent:          make(map[NodeID][]AppendEntriesRequest),
+	}
+	close(gt.release)
+	leader.transport = gt
+
+	// node-2 is behind at 1, and this batch only goes up to 2.
+	leader.mu.Lock()
+	leader.matchIndex["node-2"] = 1
+	leader.nextIndex["node-2"] = 2
+	leader.mu.Unlock()
+	if !leader.sendTo(Peer{ID: "node-2"}, leader.GetState().CurrentTerm, 2) {
+		t.Fatal("sendTo should succeed")
+	}
+
+	reqs := gt.requests("node-2")
+	if len(reqs) != 1 || len(reqs[0].Entries) != 1 {
+		t.Fatalf("expected one request with entry 2, got %d requests", len(reqs))
+	}
+	if got := reqs[0].LeaderCommitIndex; got != 2 {
+		t.Fatalf("LeaderCommitIndex should stop at the last entry sent (2), got %d", got)
+	}
+}
+
+// waitFor polls cond until it holds, or fails the test after 2s.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// BenchmarkPropose measures write throughput on a 3-node cluster with
+// real Stores and a 1ms round trip, for 1 to 64 clients writing at once.
+// batch=1 turns batching off; pipelining still works then.
+//
+// disk=local fsyncs to whatever disk the test runs on; disk=slow adds
+// 2ms to every WAL write, like a disk whose fsync takes that long.
+// docs/06-raft-consensus.md has the numbers, and what they were before
+// batching and pipelining.
+//
+//	go test ./internal/cluster -run XXX -bench Propose
+func BenchmarkPropose(b *testing.B) {
+	disks := []struct {
+		name  string
+		fsync time.Duration
+	}{
+		{"local", 0},
+		{"slow", 2 * time.Millisecond},
+	}
+	for _, disk := range disks {
+		for _, maxBatch := range []int{1, DefaultMaxBatch} {
+			for _, clients := range []int{1, 16, 64} {
+				name := fmt.Sprintf("disk=%s/batch=%d/clients=%d", disk.name, maxBatch, clients)
+				b.Run(name, func(b *testing.B) {
+					benchmarkPropose(b, disk.fsync, maxBatch, clients)
+				})
+			}
+		}
+	}
+}
+
+func benchmarkPropose(b *testing.B, fsync time.Duration, maxBatch, clients int) {
+	leader := newBenchCluster(b, time.Millisecond, fsync)
+	leader.maxBatch = maxBatch
+	if _, err := leader.Propose("CREATE", "/bench", nil); err != nil {
+		b.Fatalf("Propose failed: %v", err)
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	b.ResetTimer()
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for next.Add(1) <= int64(b.N) {
+				if _, err := leader.Propose("SET", "/bench", []byte("v")); err != nil {
+					b.Errorf("Propose failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "writes/s")
+}
+
+// slowDisk is a Store whose WAL writes take fsync longer.
+type slowDisk struct {
+	*store.Store
+	fsync time.Duration
+}
+
+func (sd slowDisk) AppendWAL(entries ...wal.Entry) error {
+	time.Sleep(sd.fsync)
+	return sd.Store.AppendWAL(entries...)
+}
+
+// newBenchCluster starts a 3-node cluster on real Stores, with node-1
+// elected, and returns node-1.
+func newBenchCluster(b *testing.B, rtt, fsync time.Duration) *RaftNode {
+	ft := &fakeTransport{nodes: make(map[NodeID]*RaftNode)}
+	dt := &delayTransport{fakeTransport: ft, rtt: rtt}
+	quiet := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	for _, p := range testPeers {
+		dir := b.TempDir()
+		s, err := store.New(filepath.Join(dir, "wal"), filepath.Join(dir, "snapshot.json"))
+		if err != nil {
+			b.Fatalf("store.New failed: %v", err)
+		}
+		b.Cleanup(func() { s.Close() })
+		node := newNode(Config{Self: p.ID, Peers: testPeers}, dt, slowDisk{s, fsync})
+		node.logger = quiet
+		ft.nodes[p.ID] = node
+	}
+
+	node1 := ft.nodes["node-1"]
+	voteReq := node1.StartElection()
+	votes := 1
+	for _, peer := range node1.config.OtherPeers() {
+		node1.CollectVote(ft.nodes[peer.ID].HandleRequestVote(voteReq), &votes)
+	}
+	if node1.GetState().Role != Leader {
+		b.Fatal("node-1 should be leader")
+	}
+	return node1
+}
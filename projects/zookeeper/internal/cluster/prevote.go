@@ -0,0 +1,121 @@
+package cluster
+
+// THE PROBLEM:
+//
+// A node cut off from the others hears no heartbeats, so it times out
+// and starts an election. Nobody answers. It times out again, and again
+// — each time one term higher:
+//
+//   node-3, partitioned:  term 1 → 2 → 3 → ... → 9
+//
+// When the partition heals, the leader (still in term 1) sends it a
+// heartbeat, gets back "my term is 9", and steps down. The cluster was
+// healthy the whole time, and now it has no leader until an election
+// finishes. Rule 1b in HandleRequestVote stops the others from VOTING
+// for node-3, but the leader still learns the higher term.
+//
+// THE FIX: PRE-VOTE (Raft thesis §9.6)
+//
+// Before it bumps its term, a node asks "WOULD you vote for me in the
+// next term?" A pre-vote changes nothing on either side: the voter
+// keeps its term and its vote, the asker stays a follower in its term.
+//
+//   node-3 times out
+//     pre-vote round: term 2?  ──→  no answer / "no, my leader is alive"
+//     no majority → stay in term 1, wait another timeout
+//
+//     pre-vote round: term 2?  ──→  majority says yes
+//     → real election: persist term 2 + self-vote, RequestVote
+//
+// A voter says yes only if it would say yes to the real thing: the term
+// isn't stale, it hasn't heard from a leader lately (rule 1b), and the
+// asker's log is at least as up-to-date as its own (rule 3). It doesn't
+// check rule 2 — it hasn't voted in the next term yet.
+//
+// A partitioned node never gets a majority, so it never bumps its term,
+// and rejoins without deposing anyone.
+
+// preVote runs a pre-vote round and reports whether a majority of
+// voters would vote for us in the next term.
+func (rn *RaftNode) preVote() bool {
+	rn.mu.Lock()
+	// Whatever the outcome, wait a full timeout before the next try.
+	rn.lastHeartbeat = rn.now()
+	term := rn.state.CurrentTerm
+	quorum := rn.config.QuorumSize()
+	lastTxID, lastTerm, err := rn.lastLog()
+	rn.mu.Unlock()
+	if err != nil {
+		rn.logger.Error("not starting election: can't read last log term", "error", err)
+		return false
+	}
+
+	req := RequestVoteRequest{
+		Term:        term + 1,
+		CandidateID: rn.config.Self,
+		LastLogTxID: lastTxID,
+		LastLogTerm: lastTerm,
+		PreVote:     true,
+	}
+	votes := 1 // our own
+	for _, peer := range rn.otherVoters() {
+		if votes >= quorum {
+			break
+		}
+		resp, err := rn.transport.SendRequestVote(peer, req)
+		if err != nil {
+			continue // peer unreachable, skip
+		}
+		if resp.VoteGranted {
+			votes++
+			continue
+		}
+
+		// A voter in a later term: there's a newer election or leader
+		// we didn't hear about. Catch up, and don't campaign.
+		rn.mu.Lock()
+		if resp.Term > rn.state.CurrentTerm {
+			rn.becomeFollower(resp.Term, "")
+			rn.mu.Unlock()
+			return false
+		}
+		rn.mu.Unlock()
+	}
+
+	if votes < quorum {
+		rn.logger.Info("pre-vote lost, not starting election",
+			"term", term+1,
+			"votes", votes,
+			"quorum", quorum,
+		)
+		return false
+	}
+	return true
+}
+
+// handlePreVote answers a pre-vote: would we vote for this candidate in
+// req.Term? Nothing changes — not our term, not our vote.
+//
+// Must be called with rn.mu held.
+func (rn *RaftNode) handlePreVote(req RequestVoteRequest) RequestVoteResponse {
+	no := RequestVoteResponse{Term: rn.state.CurrentTerm}
+
+	// Rule 1: a term we've already reached can't win. (The real vote
+	// takes an equal term; a pre-vote asks for the term after the
+	// candidate's, so equal means the candidate is behind us.)
+	if req.Term <= rn.state.CurrentTerm {
+		return no
+	}
+
+	// Rule 1b: we're the leader, or heard from one lately.
+	if rn.state.Role == Leader || rn.leaderAlive() {
+		return no
+	}
+
+	// Rule 3: the candidate's log is at least as up-to-date as ours.
+	if !rn.candidateUpToDate(req) {
+		return no
+	}
+
+	return RequestVoteResponse{Term: rn.state.CurrentTerm, VoteGranted: true}
+}
@@ -0,0 +1,102 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+)
+
+// isolate cuts node off from the rest of the test cluster, both ways.
+// The returned function heals the partition.
+func isolate(nodes map[NodeID]*RaftNode, id NodeID) (heal func()) {
+	node := nodes[id]
+	transport := node.transport
+	node.transport = &failingTransport{}
+	delete(nodes, id)
+	return func() {
+		node.transport = transport
+		nodes[id] = node
+	}
+}
+
+// TestPreVote_PartitionedNodeKeepsTerm proves a node that times out
+// again and again on its own never bumps its term, so it doesn't depose
+// the leader when it comes back.
+func TestPreVote_PartitionedNodeKeepsTerm(t *testing.T) {
+	nodes, _ := newTestCluster()
+	leader := electNode1(t, nodes)
+	leader.leaderTick()
+	node3 := nodes["node-3"]
+
+	heal := isolate(nodes, "node-3")
+	for i := 0; i < 5; i++ {
+		node3.runElection()
+	}
+	if state := node3.GetState(); state.CurrentTerm != 1 || state.Role != Follower {
+		t.Fatalf("partitioned node should stay a term-1 follower, got %+v", state)
+	}
+
+	heal()
+	leader.leaderTick()
+	if state := leader.GetState(); state.Role != Leader || state.CurrentTerm != 1 {
+		t.Fatalf("leader should keep leading in term 1, got %+v", state)
+	}
+}
+
+// TestPreVote_RejectedWhileLeaderAlive proves voters that hear from the
+// leader turn a pre-vote down, and nobody's term moves.
+func TestPreVote_RejectedWhileLeaderAlive(t *testing.T) {
+	nodes, _ := newTestCluster()
+	leader := electNode1(t, nodes)
+	leader.leaderTick()
+
+	nodes["node-3"].runElection()
+	for id, node := range nodes {
+		if term := node.GetState().CurrentTerm; term != 1 {
+			t.Fatalf("%s should still be in term 1, got %d", id, term)
+		}
+	}
+	if leader.GetState().Role != Leader {
+		t.Fatal("node-1 should still lead")
+	}
+}
+
+// TestPreVote_WinsOnceLeaderIsGone proves a pre-vote doesn't get in the
+// way of a real failover.
+func TestPreVote_WinsOnceLeaderIsGone(t *testing.T) {
+	nodes, _ := newTestCluster()
+	leader := electNode1(t, nodes)
+	leader.leaderTick()
+
+	delete(nodes, "node-1")
+	nodes["node-3"].mu.Lock()
+	nodes["node-3"].leaderContact = time.Now().Add(-time.Minute)
+	nodes["node-3"].mu.Unlock()
+	nodes["node-2"].mu.Lock()
+	nodes["node-2"].leaderContact = time.Now().Add(-time.Minute)
+	nodes["node-2"].mu.Unlock()
+
+	nodes["node-3"].runElection()
+	if state := nodes["node-3"].GetState(); state.Role != Leader || state.CurrentTerm != 2 {
+		t.Fatalf("node-3 should lead in term 2, got %+v", state)
+	}
+}
+
+// TestPreVote_ChangesNothing proves answering a pre-vote leaves the
+// voter's term and vote alone.
+func TestPreVote_ChangesNothing(t *testing.T) {
+	node, _ := newTestNode("node-1")
+
+	resp := node.HandleRequestVote(RequestVoteRequest{Term: 5, CandidateID: "node-2", PreVote: true})
+	if !resp.VoteGranted {
+		t.Fatal("should grant the pre-vote")
+	}
+	if state := node.GetState(); state.CurrentTerm != 0 || state.VotedFor != "" {
+		t.Fatalf("pre-vote should change nothing, got %+v", state)
+	}
+
+	// The real vote in the same term still goes to whoever asks first.
+	resp = node.HandleRequestVote(RequestVoteRequest{Term: 5, CandidateID: "node-3"})
+	if !resp.VoteGranted {
+		t.Fatal("should still be free to vote in term 5")
+	}
+}
@@ -3,14 +3,18 @@ package cluster
 import (
 	"crypto/rand"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"log/slog"
+	mrand "math/rand/v2"
 	"os"
+	"path/filepath"
 	"sort"
 	"sync"
 	"time"
 
 	"github.com/syamsularifin/zookeeper/internal/wal"
+	"github.com/syamsularifin/zookeeper/internal/znode"
 )
 
 // Storage is the interface RaftNode uses to access WAL and tree.
@@ -23,17 +27,26 @@ import (
 // In production, *store.Store implements this interface.
 // In tests, memoryStorage implements it.
 type Storage interface {
-	// AppendWAL writes an entry to the WAL (disk) and in-memory cache.
-	// The entry must already have a TxID assigned.
-	AppendWAL(entry wal.Entry) error
+	// AppendWAL writes entries to the WAL (disk) and in-memory cache,
+	// with one fsync for all of them. Each must already have a TxID.
+	AppendWAL(entries ...wal.Entry) error
 
 	// ApplyTree applies an entry to the in-memory tree.
 	// Called only after the entry is committed (majority confirmed).
-	ApplyTree(entry wal.Entry) error
+	// Returns the entry as applied: a sequential CREATE comes back with
+	// the path actually created.
+	ApplyTree(entry wal.Entry) (wal.Entry, error)
+
+	// SaveCommitIndex persists how far ApplyTree has got, so a restart
+	// knows where to stop replaying. Called every tick, not every apply:
+	// the saved index is a lower bound, and may lag by a tick. Called
+	// without rn.mu, at the same time as anything else here.
+	SaveCommitIndex() error
 
 	// GetWALEntriesFrom returns cached entries starting at fromTxID.
 	// Used by the leader to grab entries for replication.
-	GetWALEntriesFrom(fromTxID int64) []wal.Entry
+	// Returns wal.ErrCompacted if fromTxID was discarded after a snapshot.
+	GetWALEntriesFrom(fromTxID int64) ([]wal.Entry, error)
 
 	// LastWALTxID returns the TxID of the last WAL entry.
 	// Returns 0 if no entries exist.
@@ -42,7 +55,30 @@ type Storage interface {
 	// TruncateWALFrom removes all entries with TxID >= fromTxID.
 	// Used when a follower has conflicting entries that don't match
 	// the leader's log. The follower truncates and accepts the leader's version.
-	TruncateWALFrom(fromTxID int64)
+	TruncateWALFrom(fromTxID int64) error
+
+	// CommitIndex returns the highest TxID already applied to the tree.
+	// After a restart, Raft resumes applying from the entry after it.
+	CommitIndex() int64
+
+	// TermAt returns the term of the entry at txID, including the last
+	// entry covered by the snapshot. 0 for txID 0.
+	// Returns wal.ErrCompacted if the entry was discarded.
+	TermAt(txID int64) (int64, error)
+
+	// ReadSnapshot returns the latest snapshot as raw bytes, plus the
+	// TxID and term of the last entry it covers.
+	// Used by the leader to catch up followers behind compaction.
+	ReadSnapshot() (data []byte, txID, term int64, err error)
+
+	// RestoreSnapshot replaces the tree (and, if needed, the log) with a
+	// snapshot received from the leader.
+	RestoreSnapshot(data []byte, txID, term int64) error
+
+	// Config returns the Data of the last CONFIG entry applied (or
+	// carried by the last snapshot), nil if there was none.
+	// See membership.go.
+	Config() []byte
 }
 
 // RaftNode is the core Raft state machine.
@@ -64,6 +100,28 @@ type RaftNode struct {
 	state  *NodeState
 	logger *slog.Logger
 
+	// proposeMu serializes WAL writes on the leader.
+	//
+	// Whoever holds it takes the queue of proposals, picks their TxIDs
+	// (LastWALTxID + 1 onward) and writes them as one batch. One batch
+	// at a time keeps TxIDs unique and in order. See pipeline.go.
+	proposeMu sync.Mutex
+
+	// queue holds proposals waiting to go into a batch, oldest first.
+	queue []*proposal
+
+	// waiting holds proposals in the WAL, by TxID, until their entry is
+	// applied.
+	waiting map[int64]*proposal
+
+	// maxBatch is the most proposals one batch takes.
+	// Default: DefaultMaxBatch.
+	maxBatch int
+
+	// changeMu serializes membership changes: one at a time, start to
+	// finish. See membership.go.
+	changeMu sync.Mutex
+
 	// store is the durable storage: WAL (disk) + tree (memory).
 	// Leader writes to WAL during Propose.
 	// Follower writes to WAL during HandleAppendEntries.
@@ -85,6 +143,10 @@ type RaftNode struct {
 	// but not yet applied." applyCommitted() closes this gap.
 	lastApplied int64
 
+	// applied is closed (and replaced) every time lastApplied moves.
+	// WaitApplied waits on it. See read_index.go.
+	applied chan struct{}
+
 	// transport is how we send messages to other nodes.
 	transport Transport
 
@@ -102,9 +164,33 @@ type RaftNode struct {
 	// If now - lastHeartbeat > electionTimeout → start election.
 	lastHeartbeat time.Time
 
+	// clock is where we read the time, random where election timeouts
+	// come from (nil: crypto/rand). See clock.go.
+	clock  Clock
+	random *mrand.Rand
+
+	// leaderContact is when we last accepted a message from a leader.
+	// Unlike lastHeartbeat, nothing else moves it. While it's recent,
+	// we don't vote — that's what makes a leader lease safe.
+	// See read_index.go.
+	leaderContact time.Time
+
+	// transferTarget is the follower we're handing leadership to, while
+	// TransferLeadership runs. The lease is off meanwhile: the target's
+	// election doesn't wait for it. See transfer.go.
+	//
+	// Only used when this node is the leader. Empty otherwise.
+	transferTarget NodeID
+
+	// confirmedAt is when the latest heartbeat round that a majority
+	// answered in our term started. Until then, no other node could have
+	// been elected. Only used when this node is the leader.
+	confirmedAt time.Time
+
 	// nextIndex tracks, for each peer, the next log entry the leader
 	// will send to that peer. It's an optimistic guess — the leader
-	// assumes everyone is caught up when it first wins election.
+	// assumes everyone is caught up when it first wins election, and
+	// moves it past a batch as soon as it sends it (see pipeline.go).
 	// If a follower rejects, the leader jumps back using LastLogTxID.
 	//
 	// Only used when this node is the leader. nil otherwise.
@@ -117,24 +203,88 @@ type RaftNode struct {
 	// Only used when this node is the leader. nil otherwise.
 	matchIndex map[NodeID]int64
 
+	// sendingSnapshot marks peers that have a snapshot transfer in
+	// flight, so leaderTick doesn't start a second one every tick.
+	//
+	// Only used when this node is the leader.
+	sendingSnapshot map[NodeID]bool
+
+	// snapshotChunkSize is how many snapshot bytes go in one
+	// InstallSnapshot message. Default: DefaultSnapshotChunkSize.
+	snapshotChunkSize int
+
+	// incoming is the snapshot a follower is receiving, chunk by chunk.
+	// nil when no transfer is in progress.
+	incoming *incomingSnapshot
+
+	// elections and electionsWon count the elections this node started
+	// and won since it started; electedAt is when it last won. See
+	// status.go.
+	elections    int64
+	electionsWon int64
+	electedAt    time.Time
+
 	// stopCh signals the loop to stop. Used for clean shutdown.
 	stopCh chan struct{}
 }
 
-// NewRaftNode creates a node that starts as a follower in term 0.
-func NewRaftNode(config Config, transport Transport, store Storage) *RaftNode {
+// NewRaftNode creates a node that starts as a follower.
+//
+// A brand new node starts in term 0 with no vote. A restarted node
+// (one whose Config.DataDir already has a metadata file) picks up the
+// term and vote it had before the restart — see meta.go for why.
+//
+// If the cluster's membership has changed since config.Peers was
+// written, the store knows, and its membership wins.
+func NewRaftNode(config Config, transport Transport, store Storage) (*RaftNode, error) {
+	if data := store.Config(); data != nil {
+		peers, err := decodeMembers(data)
+		if err != nil {
+			return nil, err
+		}
+		config.Peers = peers
+	}
+
+	state := NewNodeState()
+	if config.DataDir != "" {
+		meta, err := loadMeta(filepath.Join(config.DataDir, metaFileName))
+		if err != nil {
+			return nil, err
+		}
+		state.CurrentTerm = meta.CurrentTerm
+		state.VotedFor = meta.VotedFor
+	}
+
+	// Entries up to the store's commit index were applied during
+	// recovery. Starting lastApplied at 0 would apply them a second time.
+	applied := store.CommitIndex()
+
+	clock := config.Clock
+	if clock == nil {
+		clock = systemClock{}
+	}
+
 	return &RaftNode{
 		config:             config,
-		state:              NewNodeState(),
+		state:              state,
+		commitIndex:        applied,
+		lastApplied:        applied,
+		applied:            make(chan struct{}),
+		waiting:            make(map[int64]*proposal),
+		maxBatch:           DefaultMaxBatch,
 		logger:             slog.New(slog.NewTextHandler(os.Stdout, nil)),
 		transport:          transport,
 		store:              store,
 		heartbeatInterval:  100 * time.Millisecond,
 		electionTimeoutMin: 300 * time.Millisecond,
 		electionTimeoutMax: 500 * time.Millisecond,
-		lastHeartbeat:      time.Now(),
+		clock:              clock,
+		random:             config.Rand,
+		lastHeartbeat:      clock.Now(),
+		sendingSnapshot:    make(map[NodeID]bool),
+		snapshotChunkSize:  DefaultSnapshotChunkSize,
 		stopCh:             make(chan struct{}),
-	}
+	}, nil
 }
 
 // randomElectionTimeout returns a random duration between min and max.
@@ -146,8 +296,14 @@ func NewRaftNode(config Config, transport Transport, store Storage) *RaftNode {
 //   - crypto/rand uses the OS entropy pool (/dev/urandom)
 //   - In a cluster, all nodes might start at the same time with similar
 //     state. math/rand could produce similar sequences. crypto/rand won't.
+//
+// Unless Config.Rand says otherwise: a simulation wants the same
+// sequence every time.
 func (rn *RaftNode) randomElectionTimeout() time.Duration {
 	spread := int64(rn.electionTimeoutMax - rn.electionTimeoutMin)
+	if rn.random != nil {
+		return rn.electionTimeoutMin + time.Duration(rn.random.Int64N(spread))
+	}
 
 	var b [8]byte
 	rand.Read(b[:])
@@ -199,11 +355,20 @@ func (rn *RaftNode) loop() {
 }
 
 // tick is called every 50ms. It checks the current role and acts.
+//
+// It also saves the commit index. Saving it on every apply would cost
+// an fsync per batch, on every node; once a tick is plenty for a lower
+// bound (see store/commit.go). It's saved without rn.mu: the fsyncs
+// mustn't hold up AppendEntries, votes or proposals.
 func (rn *RaftNode) tick() {
 	rn.mu.Lock()
 	role := rn.state.Role
 	rn.mu.Unlock()
 
+	if err := rn.store.SaveCommitIndex(); err != nil {
+		rn.logger.Warn("cannot save commit index", "error", err)
+	}
+
 	switch role {
 	case Leader:
 		rn.leaderTick()
@@ -222,29 +387,44 @@ func (rn *RaftNode) tick() {
 //  5. On failure → jump nextIndex to peer's LastLogTxID + 1
 //
 // If there are no new entries, this is just a heartbeat (empty Entries).
+// If the entries were compacted away, the peer gets the snapshot
+// instead (see install_snapshot.go).
 func (rn *RaftNode) leaderTick() {
 	rn.mu.Lock()
 	term := rn.state.CurrentTerm
 	leaderID := rn.config.Self
+	peers := rn.config.OtherPeers()
+	selfVotes := rn.config.IsVoter(leaderID)
 	rn.mu.Unlock()
 
-	for _, peer := range rn.config.OtherPeers() {
+	// Every voter that answers in our term still follows us.
+	// Counted for the leader lease (see read_index.go).
+	start := rn.now()
+	acks := 0
+	if selfVotes {
+		acks = 1
+	}
+
+	for _, peer := range peers {
 		rn.mu.Lock()
-		next := rn.nextIndex[peer.ID]
+		next, ok := rn.nextIndex[peer.ID]
+		if !ok {
+			rn.mu.Unlock()
+			continue // removed since we looked
+		}
 
 		// Grab entries from WAL starting at nextIndex.
 		// Returns nil if peer is caught up → heartbeat.
-		entries := rn.store.GetWALEntriesFrom(next)
-
-		// Compute prevLog: the entry right before the batch.
-		// The follower checks this to verify log consistency.
-		var prevLogTxID, prevLogTerm int64
-		if next > 1 {
-			prev := rn.store.GetWALEntriesFrom(next - 1)
-			if prev != nil {
-				prevLogTxID = prev[0].TxID
-				prevLogTerm = prev[0].Term
-			}
+		entries, prevLogTxID, prevLogTerm, err := rn.entriesFor(next)
+		if errors.Is(err, wal.ErrCompacted) {
+			rn.startSnapshotTransfer(peer, term)
+			rn.mu.Unlock()
+			continue
+		}
+		if err != nil {
+			rn.logger.Warn("cannot replicate to peer", "peer", peer.ID, "nextIndex", next, "error", err)
+			rn.mu.Unlock()
+			continue
 		}
 
 		req := AppendEntriesRequest{
@@ -269,27 +449,71 @@ func (rn *RaftNode) leaderTick() {
 			rn.mu.Unlock()
 			return
 		}
+		if rn.config.IsVoter(peer.ID) {
+			acks++
+		}
 
 		if resp.Success && len(entries) > 0 {
-			lastSent := entries[len(entries)-1].TxID
-			rn.nextIndex[peer.ID] = lastSent + 1
-			rn.matchIndex[peer.ID] = lastSent
+			rn.noteReplicated(peer.ID, entries[len(entries)-1].TxID)
 		} else if !resp.Success {
 			rn.nextIndex[peer.ID] = resp.LastLogTxID + 1
 		}
 		rn.mu.Unlock()
 	}
 
+	rn.mu.Lock()
+	if acks >= rn.config.QuorumSize() && rn.state.Role == Leader && rn.state.CurrentTerm == term &&
+		start.After(rn.confirmedAt) {
+		rn.confirmedAt = start
+	}
+
+	// A leader that removed itself has now told everyone the change
+	// committed. Time to go (see membership.go).
+	if rn.state.Role == Leader && !rn.config.IsVoter(leaderID) {
+		rn.logger.Info("stepping down: no longer a voter", "node", leaderID)
+		rn.becomeFollower(rn.state.CurrentTerm, "")
+		rn.mu.Unlock()
+		return
+	}
+	rn.mu.Unlock()
+
 	// After sending to all peers, check if we can advance commitIndex.
 	rn.advanceCommitIndex()
 }
 
+// entriesFor returns what to send a peer whose nextIndex is next: the
+// entries from next onward, plus the TxID and term of the entry right
+// before them (prevLog), which the follower checks for consistency.
+//
+// Returns wal.ErrCompacted if the peer is so far behind that we no
+// longer have those entries.
+//
+// Must be called with rn.mu held.
+func (rn *RaftNode) entriesFor(next int64) ([]wal.Entry, int64, int64, error) {
+	entries, err := rn.store.GetWALEntriesFrom(next)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	// TermAt also knows the term of the snapshot's last entry, so a
+	// peer right at the compaction boundary still gets a real prevLog.
+	var prevLogTxID, prevLogTerm int64
+	if next > 1 {
+		prevLogTerm, err = rn.store.TermAt(next - 1)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		prevLogTxID = next - 1
+	}
+	return entries, prevLogTxID, prevLogTerm, nil
+}
+
 // advanceCommitIndex checks if any new entries have been replicated
 // to a majority. If so, advance commitIndex.
 //
-// The approach: collect all matchIndex values (including leader's own),
-// sort descending, and pick the quorum-th value. That's the highest
-// TxID that a majority of nodes have.
+// The approach: collect the matchIndex of every voter (the leader's own
+// position included, learners left out), sort descending, and pick the
+// quorum-th value. That's the highest TxID that a majority of voters have.
 //
 // Example (3-node cluster, quorum=2):
 //
@@ -300,15 +524,28 @@ func (rn *RaftNode) leaderTick() {
 //	Quorum-th (2nd): 700 → commitIndex = 700
 //
 // O(peers log peers) regardless of how many entries exist.
+//
+// Only an entry of the leader's own term commits this way. An entry
+// from an earlier term can be on a majority and still be overwritten by
+// a later leader (Raft paper, Figure 8); it commits along with the
+// first entry of ours that does.
 func (rn *RaftNode) advanceCommitIndex() {
 	rn.mu.Lock()
 	defer rn.mu.Unlock()
 
-	// Collect: leader's own position + all peers.
-	matches := make([]int64, 0, len(rn.config.Peers))
-	matches = append(matches, rn.store.LastWALTxID()) // leader has everything
-	for _, peer := range rn.config.OtherPeers() {
-		matches = append(matches, rn.matchIndex[peer.ID])
+	if rn.state.Role != Leader {
+		return
+	}
+
+	// Collect: every voter's position, the leader's included.
+	voters := rn.config.Voters()
+	matches := make([]int64, 0, len(voters))
+	for _, peer := range voters {
+		if peer.ID == rn.config.Self {
+			matches = append(matches, rn.store.LastWALTxID()) // leader has everything
+		} else {
+			matches = append(matches, rn.matchIndex[peer.ID])
+		}
 	}
 
 	// Sort descending.
@@ -319,8 +556,9 @@ func (rn *RaftNode) advanceCommitIndex() {
 	// The quorum-th value (0-indexed: QuorumSize()-1) is the highest TxID
 	// that at least QuorumSize() nodes have.
 	committed := matches[rn.config.QuorumSize()-1]
+	term, err := rn.store.TermAt(committed)
 
-	if committed > rn.commitIndex {
+	if committed > rn.commitIndex && err == nil && term == rn.state.CurrentTerm {
 		rn.commitIndex = committed
 		rn.logger.Info("advanced commitIndex",
 			"commitIndex", rn.commitIndex,
@@ -336,7 +574,8 @@ func (rn *RaftNode) advanceCommitIndex() {
 // SET actually updates data, DELETE actually removes a node.
 //
 // Called by both leader (after advanceCommitIndex) and follower
-// (after learning commitIndex from the leader).
+// (after learning commitIndex from the leader). Applying an entry
+// answers the proposal waiting for it, if any (see pipeline.go).
 //
 // Must be called with rn.mu held.
 func (rn *RaftNode) applyCommitted() {
@@ -345,22 +584,50 @@ func (rn *RaftNode) applyCommitted() {
 	}
 
 	// Grab all entries from lastApplied+1 onward, apply up to commitIndex.
-	entries := rn.store.GetWALEntriesFrom(rn.lastApplied + 1)
+	// Compaction never goes past what's applied, so these are never compacted.
+	entries, err := rn.store.GetWALEntriesFrom(rn.lastApplied + 1)
+	if err != nil {
+		rn.logger.Error("cannot apply committed entries", "from", rn.lastApplied+1, "error", err)
+		return
+	}
 	for _, entry := range entries {
 		if entry.TxID > rn.commitIndex {
 			break
 		}
-		rn.store.ApplyTree(entry)
+		applied, err := rn.apply(entry)
 		rn.lastApplied = entry.TxID
+		rn.answer(entry, applied, err)
 	}
+	rn.noteApplied()
+}
+
+// apply applies one committed entry to the store. A CONFIG entry also
+// changes who this node replicates to and who counts towards a quorum
+// (see membership.go).
+//
+// Must be called with rn.mu held.
+func (rn *RaftNode) apply(entry wal.Entry) (wal.Entry, error) {
+	applied, err := rn.store.ApplyTree(entry)
+	if entry.Op == wal.OpConfig {
+		rn.applyMembers(entry.Data)
+	}
+	return applied, err
 }
 
 // followerTick checks if we've timed out waiting for the leader.
+//
+// Only voters start elections. A learner (or a node that was removed)
+// waits for the leader, however long it takes.
 func (rn *RaftNode) followerTick() {
 	rn.mu.Lock()
-	elapsed := time.Since(rn.lastHeartbeat)
+	elapsed := rn.since(rn.lastHeartbeat)
+	voter := rn.config.IsVoter(rn.config.Self)
 	rn.mu.Unlock()
 
+	if !voter {
+		return
+	}
+
 	timeout := rn.randomElectionTimeout()
 	if elapsed < timeout {
 		return // still within timeout, do nothing
@@ -370,13 +637,27 @@ func (rn *RaftNode) followerTick() {
 	rn.runElection()
 }
 
-// runElection runs a full election: start it, ask for votes, count them.
+// runElection runs a full election: check we could win it (pre-vote),
+// then start it, ask for votes, count them.
 func (rn *RaftNode) runElection() {
-	voteReq := rn.StartElection()
+	if !rn.preVote() {
+		return // we'd lose — don't disturb anyone, try again next timeout
+	}
+	rn.campaign(false)
+}
+
+// campaign starts an election and asks every other voter for its vote.
+// transfer marks an election the leader asked for (see transfer.go).
+func (rn *RaftNode) campaign(transfer bool) {
+	voteReq, ok := rn.startElection()
+	if !ok {
+		return // couldn't persist our self-vote, try again next timeout
+	}
+	voteReq.Transfer = transfer
 	votes := 1 // we already voted for ourselves in StartElection
 
-	// Ask every other node for their vote
-	for _, peer := range rn.config.OtherPeers() {
+	// Ask every other voter for their vote
+	for _, peer := range rn.otherVoters() {
 		resp, err := rn.transport.SendRequestVote(peer, voteReq)
 		if err != nil {
 			continue // peer unreachable, skip
@@ -400,135 +681,36 @@ func (rn *RaftNode) runElection() {
 func (rn *RaftNode) ResetElectionTimer() {
 	rn.mu.Lock()
 	defer rn.mu.Unlock()
-	rn.lastHeartbeat = time.Now()
+	rn.lastHeartbeat = rn.now()
 }
 
 // Propose accepts a new write from a client.
 //
 // Only the leader can accept writes. If this node isn't the leader,
-// it returns an error — the client should retry on the leader.
-//
-// The flow:
-//  1. Create entry in memory (NOT written to WAL yet)
-//  2. Send to followers (they write to their WALs)
-//  3. Count how many confirmed
-//  4. Majority confirmed?
-//     YES → write to leader's WAL + apply to tree → return success
-//     NO  → discard entry → return error (nothing was written on leader)
-//
-// Why not write to WAL first?
-// If we wrote to WAL and then consensus failed, the entry stays in
-// the WAL. The tick loop would eventually commit it — but the client
-// was told it failed. That's a lie. By writing AFTER consensus,
-// error truly means "nothing happened."
+// it returns a *NotLeaderError — the client should retry on the leader.
+//
+// The flow (see pipeline.go):
+//  1. Queue the write. Writes that arrive together go into one batch.
+//  2. Write the batch to the leader's WAL (one fsync)
+//  3. Send it to followers (they write to their WALs)
+//  4. Majority has it?
+//     YES → commit + apply to tree → return success
+//     NO  → return ErrNoQuorum
+//
+// ErrNoQuorum doesn't mean "nothing happened": the entry is in the
+// leader's log, and commits if a majority gets it later (this leader
+// catches followers up on its next ticks, or the next leader has it).
+// A *NotLeaderError from before step 2 does mean that.
+//
+// One exception: if the entry commits but applying it to the tree fails
+// (e.g. CREATE of a node that already exists), the entry stays committed
+// and Propose returns the tree's error. Every replica hits the same error
+// when it applies the entry, so the tree stays identical everywhere —
+// the client just learns that its operation had no effect.
+//
+// Propose writes unconditionally; see ProposeEntry for versioned writes.
 func (rn *RaftNode) Propose(op wal.OpType, path string, data []byte) (wal.Entry, error) {
-	rn.mu.Lock()
-	if rn.state.Role != Leader {
-		rn.mu.Unlock()
-		return wal.Entry{}, fmt.Errorf("not the leader")
-	}
-
-	// Step 1: create entry in memory only. No WAL write yet.
-	entry := wal.Entry{
-		TxID: rn.store.LastWALTxID() + 1,
-		Term: rn.state.CurrentTerm,
-		Op:   op,
-		Path: path,
-		Data: data,
-	}
-
-	term := rn.state.CurrentTerm
-	leaderID := rn.config.Self
-	rn.mu.Unlock()
-
-	rn.logger.Info("proposing entry",
-		"txid", entry.TxID,
-		"op", entry.Op,
-		"path", entry.Path,
-	)
-
-	// Step 2: send to followers. Count leader as 1 success
-	// (leader will write its WAL after consensus).
-	successCount := 1
-
-	for _, peer := range rn.config.OtherPeers() {
-		rn.mu.Lock()
-		next := rn.nextIndex[peer.ID]
-
-		// Build entries to send: any catch-up entries + our new entry.
-		var entries []wal.Entry
-		if catchUp := rn.store.GetWALEntriesFrom(next); catchUp != nil {
-			entries = append(entries, catchUp...)
-		}
-		entries = append(entries, entry)
-
-		// Compute prevLog for consistency check.
-		var prevLogTxID, prevLogTerm int64
-		if next > 1 {
-			prev := rn.store.GetWALEntriesFrom(next - 1)
-			if prev != nil {
-				prevLogTxID = prev[0].TxID
-				prevLogTerm = prev[0].Term
-			}
-		}
-
-		req := AppendEntriesRequest{
-			Term:              term,
-			LeaderID:          leaderID,
-			PrevLogTxID:       prevLogTxID,
-			PrevLogTerm:       prevLogTerm,
-			Entries:           entries,
-			LeaderCommitIndex: rn.commitIndex,
-		}
-		rn.mu.Unlock()
-
-		resp, err := rn.transport.SendAppendEntries(peer, req)
-		if err != nil {
-			continue // peer unreachable
-		}
-
-		rn.mu.Lock()
-		if resp.Term > term {
-			rn.becomeFollower(resp.Term, "")
-			rn.mu.Unlock()
-			return wal.Entry{}, fmt.Errorf("lost leadership during replication")
-		}
-
-		if resp.Success {
-			lastSent := entries[len(entries)-1].TxID
-			rn.nextIndex[peer.ID] = lastSent + 1
-			rn.matchIndex[peer.ID] = lastSent
-			successCount++
-		} else {
-			rn.nextIndex[peer.ID] = resp.LastLogTxID + 1
-		}
-		rn.mu.Unlock()
-	}
-
-	// Step 3: check consensus.
-	rn.mu.Lock()
-	defer rn.mu.Unlock()
-
-	if successCount < rn.config.QuorumSize() {
-		return wal.Entry{}, fmt.Errorf("no consensus: only %d/%d nodes confirmed",
-			successCount, len(rn.config.Peers))
-	}
-
-	// Step 4: consensus achieved! Write to leader's WAL + commit + apply.
-	if err := rn.store.AppendWAL(entry); err != nil {
-		return wal.Entry{}, fmt.Errorf("WAL write failed: %w", err)
-	}
-
-	rn.commitIndex = entry.TxID
-	rn.store.ApplyTree(entry)
-	rn.lastApplied = entry.TxID
-
-	rn.logger.Info("committed entry",
-		"txid", entry.TxID,
-		"commitIndex", rn.commitIndex,
-	)
-
-	return entry, nil
+	return rn.ProposeEntry(wal.Entry{Op: op, Path: path, Data: data, Version: znode.AnyVersion})
 }
 
 // appendEntry appends a new entry to the WAL without replicating.
@@ -538,15 +720,17 @@ func (rn *RaftNode) appendEntry(op wal.OpType, path string, data []byte) (wal.En
 	defer rn.mu.Unlock()
 
 	if rn.state.Role != Leader {
-		return wal.Entry{}, fmt.Errorf("not the leader")
+		return wal.Entry{}, rn.notLeaderError()
 	}
 
 	entry := wal.Entry{
-		TxID: rn.store.LastWALTxID() + 1,
-		Term: rn.state.CurrentTerm,
-		Op:   op,
-		Path: path,
-		Data: data,
+		TxID:    rn.store.LastWALTxID() + 1,
+		Term:    rn.state.CurrentTerm,
+		Op:      op,
+		Path:    path,
+		Data:    data,
+		Version: znode.AnyVersion,
+		Time:    rn.now().UnixMilli(),
 	}
 
 	if err := rn.store.AppendWAL(entry); err != nil {
@@ -562,6 +746,18 @@ func (rn *RaftNode) appendEntry(op wal.OpType, path string, data []byte) (wal.En
 	return entry, nil
 }
 
+// notLeaderError builds the error a follower returns for writes,
+// filled in with whatever it knows about the current leader.
+//
+// Must be called with rn.mu held.
+func (rn *RaftNode) notLeaderError() *NotLeaderError {
+	err := &NotLeaderError{LeaderID: rn.state.LeaderID}
+	if leader, ok := rn.config.Peer(rn.state.LeaderID); ok {
+		err.LeaderAddr = leader.ClientAddr
+	}
+	return err
+}
+
 // GetState returns a copy of the current state. Safe for reading from outside.
 func (rn *RaftNode) GetState() NodeState {
 	rn.mu.Lock()
@@ -569,6 +765,18 @@ func (rn *RaftNode) GetState() NodeState {
 	return *rn.state
 }
 
+// CheckLeader returns nil if this node is the leader, or a
+// *NotLeaderError pointing at the leader. For requests only the leader
+// can answer that don't go through the log, like session keepalives.
+func (rn *RaftNode) CheckLeader() error {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	if rn.state.Role != Leader {
+		return rn.notLeaderError()
+	}
+	return nil
+}
+
 // GetCommitIndex returns the current commitIndex.
 func (rn *RaftNode) GetCommitIndex() int64 {
 	rn.mu.Lock()
@@ -618,7 +826,8 @@ func (rn *RaftNode) HandleAppendEntries(req AppendEntriesRequest) AppendEntriesR
 	rn.becomeFollower(req.Term, req.LeaderID)
 
 	// Reset the election timer.
-	rn.lastHeartbeat = time.Now()
+	rn.lastHeartbeat = rn.now()
+	rn.leaderContact = rn.lastHeartbeat
 
 	// Rule 3: check log consistency using prevLog.
 	//
@@ -627,8 +836,11 @@ func (rn *RaftNode) HandleAppendEntries(req AppendEntriesRequest) AppendEntriesR
 	// If not, reject — the leader will back up and retry.
 	//
 	// This is O(1) — no scanning of all entries.
-	if req.PrevLogTxID > 0 {
-		prevEntries := rn.store.GetWALEntriesFrom(req.PrevLogTxID)
+	//
+	// If we compacted prevLog away, it was applied — and therefore
+	// committed, and committed entries match the leader's. Accept.
+	prevEntries, err := rn.store.GetWALEntriesFrom(req.PrevLogTxID)
+	if req.PrevLogTxID > 0 && !errors.Is(err, wal.ErrCompacted) {
 		if prevEntries == nil || prevEntries[0].TxID != req.PrevLogTxID {
 			// We don't have the previous entry. Our log is shorter.
 			rn.logger.Info("rejecting AppendEntries: missing prevLog",
@@ -649,7 +861,9 @@ func (rn *RaftNode) HandleAppendEntries(req AppendEntriesRequest) AppendEntriesR
 				"expected_term", req.PrevLogTerm,
 				"actual_term", prevEntries[0].Term,
 			)
-			rn.store.TruncateWALFrom(req.PrevLogTxID)
+			if err := rn.store.TruncateWALFrom(req.PrevLogTxID); err != nil {
+				rn.logger.Error("failed to truncate WAL", "from", req.PrevLogTxID, "error", err)
+			}
 			return AppendEntriesResponse{
 				Term:        rn.state.CurrentTerm,
 				Success:     false,
@@ -659,23 +873,37 @@ func (rn *RaftNode) HandleAppendEntries(req AppendEntriesRequest) AppendEntriesR
 	}
 
 	// Rule 4: prevLog matches (or PrevLogTxID=0, meaning "from the start").
-	// Truncate any stale entries after prevLog, then append the leader's entries.
-	if len(req.Entries) > 0 {
-		rn.store.TruncateWALFrom(req.Entries[0].TxID)
-		for _, entry := range req.Entries {
-			rn.store.AppendWAL(entry)
+	// Skip entries we already have, truncate at the first conflict, then
+	// append the rest of the leader's entries.
+	//
+	// Why not just truncate from Entries[0] every time? The truncation is
+	// on disk now, and requests can arrive late or twice. A delayed batch
+	// [5] arriving after [5, 6] would wipe out 6 — which the leader may
+	// already have counted towards a commit.
+	if err := rn.appendFromLeader(req.Entries); err != nil {
+		rn.logger.Error("failed to append entries", "error", err)
+		return AppendEntriesResponse{
+			Term:        rn.state.CurrentTerm,
+			Success:     false,
+			LastLogTxID: rn.store.LastWALTxID(),
 		}
 	}
 
 	// Rule 5: update commitIndex from the leader.
-	// Use min(LeaderCommitIndex, lastWALTxID) because we can't commit
-	// entries we don't have yet.
-	lastTxID := rn.store.LastWALTxID()
-	if req.LeaderCommitIndex > rn.commitIndex {
-		rn.commitIndex = req.LeaderCommitIndex
-		if rn.commitIndex > lastTxID {
-			rn.commitIndex = lastTxID
-		}
+	// Use min(LeaderCommitIndex, last new entry): only the entries up to
+	// the end of this request are known to match the leader's. Our log
+	// can go on past them — appendFromLeader keeps a tail it found no
+	// conflict in — and that tail may be stale entries from an old term:
+	//
+	//	ours:    [1/t1, 2/t1, 3/t1]
+	//	request: prev 1/t1, entries [2/t1], leaderCommit 3
+	//	→ commit 2, not 3: the leader's 3 may be another entry
+	//
+	// A late request can end before what we've already committed; the
+	// commit index never moves back.
+	lastNew := req.PrevLogTxID + int64(len(req.Entries))
+	if commit := min(req.LeaderCommitIndex, lastNew); commit > rn.commitIndex {
+		rn.commitIndex = commit
 	}
 
 	// Rule 6: apply any newly committed entries.
@@ -688,6 +916,30 @@ func (rn *RaftNode) HandleAppendEntries(req AppendEntriesRequest) AppendEntriesR
 	}
 }
 
+// appendFromLeader writes the leader's entries after the prevLog check
+// passed. An entry we already have with the same term is the same entry
+// (Raft's Log Matching property) and is left alone.
+//
+// Must be called with rn.mu held.
+func (rn *RaftNode) appendFromLeader(entries []wal.Entry) error {
+	for i, entry := range entries {
+		existing, err := rn.store.GetWALEntriesFrom(entry.TxID)
+		if errors.Is(err, wal.ErrCompacted) {
+			continue // already applied, so it's the same entry
+		}
+		if existing != nil {
+			if existing[0].Term == entry.Term {
+				continue
+			}
+			if err := rn.store.TruncateWALFrom(entry.TxID); err != nil {
+				return err
+			}
+		}
+		return rn.store.AppendWAL(entries[i:]...)
+	}
+	return nil
+}
+
 // HandleRequestVote processes a vote request from a candidate.
 //
 // A candidate sends this to all nodes when it starts an election.
@@ -696,6 +948,11 @@ func (rn *RaftNode) HandleAppendEntries(req AppendEntriesRequest) AppendEntriesR
 //  1. Is the candidate's term < my term?
 //     YES → reject. The candidate is behind.
 //
+//     Did I hear from a leader less than electionTimeoutMin ago?
+//     YES → reject, and keep my term. The leader is alive, and its
+//     lease depends on nobody replacing it that fast (read_index.go).
+//     Unless the leader itself asked for this election (Transfer).
+//
 //  2. Have I already voted for someone else this term?
 //     YES → reject. One vote per term.
 //
@@ -703,10 +960,17 @@ func (rn *RaftNode) HandleAppendEntries(req AppendEntriesRequest) AppendEntriesR
 //     YES → reject. Electing it could lose committed data.
 //
 //  4. Otherwise → grant the vote.
+//
+// A pre-vote (req.PreVote) only asks rules 1 and 3, and changes nothing.
+// See prevote.go.
 func (rn *RaftNode) HandleRequestVote(req RequestVoteRequest) RequestVoteResponse {
 	rn.mu.Lock()
 	defer rn.mu.Unlock()
 
+	if req.PreVote {
+		return rn.handlePreVote(req)
+	}
+
 	// Rule 1: reject if the candidate's term is old.
 	if req.Term < rn.state.CurrentTerm {
 		rn.logger.Info("rejecting vote: stale term",
@@ -720,6 +984,19 @@ func (rn *RaftNode) HandleRequestVote(req RequestVoteRequest) RequestVoteRespons
 		}
 	}
 
+	// Rule 1b: a leader we heard from just now hasn't failed. Don't even
+	// take the new term — that would depose it.
+	if !req.Transfer && rn.leaderAlive() {
+		rn.logger.Info("rejecting vote: leader is alive",
+			"from", req.CandidateID,
+			"leader", rn.state.LeaderID,
+		)
+		return RequestVoteResponse{
+			Term:        rn.state.CurrentTerm,
+			VoteGranted: false,
+		}
+	}
+
 	// If the candidate's term is higher than ours, update our term
 	// and clear our vote (new term = new election = can vote again).
 	if req.Term > rn.state.CurrentTerm {
@@ -740,20 +1017,26 @@ func (rn *RaftNode) HandleRequestVote(req RequestVoteRequest) RequestVoteRespons
 	}
 
 	// Rule 3: is the candidate's log at least as up-to-date as mine?
-	// A candidate with fewer entries might be missing committed data.
-	if req.LastLogTxID < rn.store.LastWALTxID() {
-		rn.logger.Info("rejecting vote: candidate log behind",
+	// A candidate that's behind might be missing committed data.
+	if !rn.candidateUpToDate(req) {
+		return RequestVoteResponse{
+			Term:        rn.state.CurrentTerm,
+			VoteGranted: false,
+		}
+	}
+
+	// Rule 4: grant the vote — but write it to disk first.
+	// If we can't remember the vote after a crash, we must not give it.
+	if err := rn.persistState(rn.state.CurrentTerm, req.CandidateID); err != nil {
+		rn.logger.Error("rejecting vote: failed to persist",
 			"from", req.CandidateID,
-			"their_last_tx", req.LastLogTxID,
-			"my_last_tx", rn.store.LastWALTxID(),
+			"error", err,
 		)
 		return RequestVoteResponse{
 			Term:        rn.state.CurrentTerm,
 			VoteGranted: false,
 		}
 	}
-
-	// Rule 4: grant the vote.
 	rn.state.VotedFor = req.CandidateID
 	rn.logger.Info("granting vote",
 		"to", req.CandidateID,
@@ -766,11 +1049,66 @@ func (rn *RaftNode) HandleRequestVote(req RequestVoteRequest) RequestVoteRespons
 	}
 }
 
+// leaderAlive reports whether we heard from a leader less than
+// electionTimeoutMin ago (HandleRequestVote, rule 1b).
+//
+// Must be called with rn.mu held.
+func (rn *RaftNode) leaderAlive() bool {
+	return rn.state.Role == Follower && !rn.leaderContact.IsZero() &&
+		rn.since(rn.leaderContact) < rn.electionTimeoutMin
+}
+
+// lastLog returns the TxID and term of our last WAL entry.
+//
+// Must be called with rn.mu held.
+func (rn *RaftNode) lastLog() (int64, int64, error) {
+	last := rn.store.LastWALTxID()
+	term, err := rn.store.TermAt(last)
+	return last, term, err
+}
+
+// candidateUpToDate is the up-to-date check (Raft paper §5.4.1): the
+// log whose last entry has the later term is more up-to-date; with the
+// same last term, the longer one is.
+//
+//	mine: ... [5 t2] [6 t2] [7 t2]     candidate: ... [5 t2] [6 t3]
+//	      longer, but t2 < t3 → the candidate is more up-to-date
+//
+// Entry 6 of term 3 can only exist if a term-3 leader wrote it, and
+// that leader had every entry committed before term 3. Our entry 7 of
+// term 2 never committed, or it would be in its log too.
+//
+// Must be called with rn.mu held.
+func (rn *RaftNode) candidateUpToDate(req RequestVoteRequest) bool {
+	myTxID, myTerm, err := rn.lastLog()
+	if err != nil {
+		rn.logger.Error("rejecting vote: can't read last log term",
+			"from", req.CandidateID,
+			"error", err,
+		)
+		return false
+	}
+	if req.LastLogTerm > myTerm {
+		return true
+	}
+	if req.LastLogTerm == myTerm && req.LastLogTxID >= myTxID {
+		return true
+	}
+	rn.logger.Info("rejecting vote: candidate log behind",
+		"from", req.CandidateID,
+		"their_last", fmt.Sprintf("%d@t%d", req.LastLogTxID, req.LastLogTerm),
+		"my_last", fmt.Sprintf("%d@t%d", myTxID, myTerm),
+		"pre_vote", req.PreVote,
+	)
+	return false
+}
+
 // StartElection is called when a follower hasn't heard from the leader
 // for too long. It transitions to candidate and prepares a vote request.
 //
 // What happens step by step:
 //
+//  0. Persist the new term + self-vote (see meta.go)
 //  1. Increment term (new election round)
 //  2. Become candidate
 //  3. Vote for myself
@@ -780,11 +1118,33 @@ func (rn *RaftNode) HandleRequestVote(req RequestVoteRequest) RequestVoteRespons
 // The caller (network layer) is responsible for actually sending it
 // to other nodes and collecting responses.
 func (rn *RaftNode) StartElection() RequestVoteRequest {
+	req, _ := rn.startElection()
+	return req
+}
+
+// startElection does the work of StartElection and also reports whether
+// the new term and self-vote made it to disk. If they didn't, the node
+// stays a follower in its old term and must not ask for votes:
+// after a crash it could forget it voted for itself and vote for
+// someone else in the same term.
+func (rn *RaftNode) startElection() (RequestVoteRequest, bool) {
 	rn.mu.Lock()
 	defer rn.mu.Unlock()
 
+	// Step 0: persist the new term + self-vote before anything else.
+	newTerm := rn.state.CurrentTerm + 1
+	if err := rn.persistState(newTerm, rn.config.Self); err != nil {
+		rn.logger.Error("not starting election: failed to persist",
+			"term", newTerm,
+			"error", err,
+		)
+		rn.lastHeartbeat = rn.now()
+		return RequestVoteRequest{}, false
+	}
+
 	// Step 1: new term
-	rn.state.CurrentTerm++
+	rn.state.CurrentTerm = newTerm
+	rn.elections++
 
 	// Step 2: become candidate
 	rn.state.Role = Candidate
@@ -796,7 +1156,7 @@ func (rn *RaftNode) StartElection() RequestVoteRequest {
 	// Step 3b: reset election timer so we don't immediately start
 	// another election on the next tick. We wait a full random timeout
 	// before trying again if this election fails.
-	rn.lastHeartbeat = time.Now()
+	rn.lastHeartbeat = rn.now()
 
 	rn.logger.Info("starting election",
 		"node", rn.config.Self,
@@ -804,11 +1164,18 @@ func (rn *RaftNode) StartElection() RequestVoteRequest {
 	)
 
 	// Step 4: build the request for others
+	lastTxID, lastTerm, err := rn.lastLog()
+	if err != nil {
+		// Can't happen: the last entry is always in the cache, or it's
+		// the snapshot's. Asking with term 0 only loses votes.
+		rn.logger.Error("failed to read last log term", "error", err)
+	}
 	return RequestVoteRequest{
 		Term:        rn.state.CurrentTerm,
 		CandidateID: rn.config.Self,
-		LastLogTxID: rn.store.LastWALTxID(),
-	}
+		LastLogTxID: lastTxID,
+		LastLogTerm: lastTerm,
+	}, true
 }
 
 // CollectVote processes one vote response and returns true if we've won.
@@ -863,6 +1230,10 @@ func (rn *RaftNode) CollectVote(resp RequestVoteResponse, votes *int) (won bool)
 func (rn *RaftNode) becomeLeader() {
 	rn.state.Role = Leader
 	rn.state.LeaderID = rn.config.Self
+	rn.electionsWon++
+	rn.electedAt = rn.now()
+	rn.confirmedAt = time.Time{}
+	rn.transferTarget = ""
 
 	lastTxID := rn.store.LastWALTxID()
 	rn.nextIndex = make(map[NodeID]int64)
@@ -892,6 +1263,17 @@ func (rn *RaftNode) becomeFollower(term int64, leaderID NodeID) {
 	// Must compare BEFORE updating CurrentTerm.
 	if term > oldTerm {
 		rn.state.VotedFor = ""
+
+		// Remember the new term across restarts. A failure here is logged
+		// but not fatal: losing a term bump (without a vote in it) can't
+		// cause a double vote — votes are always persisted together with
+		// their term in HandleRequestVote and StartElection.
+		if err := rn.persistState(term, ""); err != nil {
+			rn.logger.Error("failed to persist new term",
+				"term", term,
+				"error", err,
+			)
+		}
 	}
 
 	rn.state.CurrentTerm = term
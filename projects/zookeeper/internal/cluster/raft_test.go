@@ -1,6 +1,7 @@
 package cluster
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 	"time"
@@ -15,38 +16,73 @@ type memoryStorage struct {
 	entries []wal.Entry
 	applied []wal.Entry
 	tree    *znode.DataTree // optional, for integration tests
+
+	// compactedTo makes entries up to this TxID report wal.ErrCompacted.
+	// The slice keeps them so the index math stays simple.
+	compactedTo int64
+
+	// snapshot is what ReadSnapshot returns, and what RestoreSnapshot
+	// last installed. Opaque bytes — nothing decodes them.
+	snapshot     []byte
+	snapshotTxID int64
+	snapshotTerm int64
+
+	// config is the Data of the last CONFIG entry applied.
+	config []byte
+
+	// walWrites counts AppendWAL calls: one fsync each on a real Store.
+	walWrites int
 }
 
 func newMemoryStorage() *memoryStorage {
 	return &memoryStorage{}
 }
 
-func (ms *memoryStorage) AppendWAL(entry wal.Entry) error {
-	ms.entries = append(ms.entries, entry)
+func (ms *memoryStorage) AppendWAL(entries ...wal.Entry) error {
+	ms.walWrites++
+	ms.entries = append(ms.entries, entries...)
+	return nil
+}
+
+func (ms *memoryStorage) SaveCommitIndex() error {
 	return nil
 }
 
-func (ms *memoryStorage) ApplyTree(entry wal.Entry) error {
+func (ms *memoryStorage) ApplyTree(entry wal.Entry) (wal.Entry, error) {
 	ms.applied = append(ms.applied, entry)
+	if entry.Op == wal.OpConfig {
+		ms.config = entry.Data
+	}
 	if ms.tree != nil {
+		txn := znode.Txn{Zxid: entry.TxID, Time: entry.Time}
 		switch entry.Op {
 		case "CREATE":
-			return ms.tree.Create(entry.Path, entry.Data)
+			return entry, ms.tree.Create(entry.Path, entry.Data, txn)
 		case "SET":
-			return ms.tree.Set(entry.Path, entry.Data)
+			return entry, ms.tree.Set(entry.Path, entry.Data, entry.Version, txn)
 		case "DELETE":
-			return ms.tree.Delete(entry.Path)
+			return entry, ms.tree.Delete(entry.Path, entry.Version, txn)
 		}
 	}
-	return nil
+	return entry, nil
+}
+
+func (ms *memoryStorage) CommitIndex() int64 {
+	if len(ms.applied) == 0 {
+		return 0
+	}
+	return ms.applied[len(ms.applied)-1].TxID
 }
 
-func (ms *memoryStorage) GetWALEntriesFrom(fromTxID int64) []wal.Entry {
+func (ms *memoryStorage) GetWALEntriesFrom(fromTxID int64) ([]wal.Entry, error) {
+	if fromTxID <= ms.compactedTo {
+		return nil, wal.ErrCompacted
+	}
 	idx := int(fromTxID - 1)
 	if idx < 0 || idx >= len(ms.entries) {
-		return nil
+		return nil, nil
 	}
-	return ms.entries[idx:]
+	return ms.entries[idx:], nil
 }
 
 func (ms *memoryStorage) LastWALTxID() int64 {
@@ -56,7 +92,7 @@ func (ms *memoryStorage) LastWALTxID() int64 {
 	return ms.entries[len(ms.entries)-1].TxID
 }
 
-func (ms *memoryStorage) TruncateWALFrom(fromTxID int64) {
+func (ms *memoryStorage) TruncateWALFrom(fromTxID int64) error {
 	idx := int(fromTxID - 1)
 	if idx < 0 {
 		idx = 0
@@ -64,6 +100,51 @@ func (ms *memoryStorage) TruncateWALFrom(fromTxID int64) {
 	if idx < len(ms.entries) {
 		ms.entries = ms.entries[:idx]
 	}
+	return nil
+}
+
+func (ms *memoryStorage) TermAt(txID int64) (int64, error) {
+	if txID == 0 {
+		return 0, nil
+	}
+	if txID == ms.snapshotTxID {
+		return ms.snapshotTerm, nil
+	}
+	entries, err := ms.GetWALEntriesFrom(txID)
+	if err != nil {
+		return 0, err
+	}
+	if entries == nil {
+		return 0, fmt.Errorf("no entry at TxID %d", txID)
+	}
+	return entries[0].Term, nil
+}
+
+func (ms *memoryStorage) Config() []byte {
+	return ms.config
+}
+
+func (ms *memoryStorage) ReadSnapshot() ([]byte, int64, int64, error) {
+	if ms.snapshot == nil {
+		return nil, 0, 0, errors.New("no snapshot")
+	}
+	return ms.snapshot, ms.snapshotTxID, ms.snapshotTerm, nil
+}
+
+// RestoreSnapshot replaces the log with placeholders up to txID, all
+// compacted, so the next entry appended is txID + 1.
+func (ms *memoryStorage) RestoreSnapshot(data []byte, txID, term int64) error {
+	ms.snapshot = append([]byte(nil), data...)
+	ms.snapshotTxID = txID
+	ms.snapshotTerm = term
+
+	ms.entries = make([]wal.Entry, txID)
+	for i := range ms.entries {
+		ms.entries[i] = wal.Entry{TxID: int64(i) + 1, Term: term}
+	}
+	ms.compactedTo = txID
+	ms.applied = append(ms.applied, wal.Entry{TxID: txID, Term: term})
+	return nil
 }
 
 // fakeTransport connects nodes directly via method calls. No network needed.
@@ -88,6 +169,22 @@ func (ft *fakeTransport) SendAppendEntries(peer Peer, req AppendEntriesRequest)
 	return node.HandleAppendEntries(req), nil
 }
 
+func (ft *fakeTransport) SendInstallSnapshot(peer Peer, req InstallSnapshotRequest) (InstallSnapshotResponse, error) {
+	node, ok := ft.nodes[peer.ID]
+	if !ok {
+		return InstallSnapshotResponse{}, fmt.Errorf("node %s not found", peer.ID)
+	}
+	return node.HandleInstallSnapshot(req), nil
+}
+
+func (ft *fakeTransport) SendTimeoutNow(peer Peer, req TimeoutNowRequest) (TimeoutNowResponse, error) {
+	node, ok := ft.nodes[peer.ID]
+	if !ok {
+		return TimeoutNowResponse{}, fmt.Errorf("node %s not found", peer.ID)
+	}
+	return node.HandleTimeoutNow(req), nil
+}
+
 // failingTransport simulates all peers being unreachable.
 type failingTransport struct{}
 
@@ -99,15 +196,32 @@ func (ft *failingTransport) SendAppendEntries(peer Peer, req AppendEntriesReques
 	return AppendEntriesResponse{}, fmt.Errorf("peer %s unreachable", peer.ID)
 }
 
+func (ft *failingTransport) SendInstallSnapshot(peer Peer, req InstallSnapshotRequest) (InstallSnapshotResponse, error) {
+	return InstallSnapshotResponse{}, fmt.Errorf("peer %s unreachable", peer.ID)
+}
+
+func (ft *failingTransport) SendTimeoutNow(peer Peer, req TimeoutNowRequest) (TimeoutNowResponse, error) {
+	return TimeoutNowResponse{}, fmt.Errorf("peer %s unreachable", peer.ID)
+}
+
 var testPeers = []Peer{
 	{ID: "node-1", Addr: "localhost:3001"},
 	{ID: "node-2", Addr: "localhost:3002"},
 	{ID: "node-3", Addr: "localhost:3003"},
 }
 
+// newNode is NewRaftNode for tests without a DataDir, which can't fail.
+func newNode(config Config, transport Transport, store Storage) *RaftNode {
+	node, err := NewRaftNode(config, transport, store)
+	if err != nil {
+		panic(err)
+	}
+	return node
+}
+
 func newTestNode(id NodeID) (*RaftNode, *memoryStorage) {
 	ms := newMemoryStorage()
-	node := NewRaftNode(Config{Self: id, Peers: testPeers}, &fakeTransport{}, ms)
+	node := newNode(Config{Self: id, Peers: testPeers}, &fakeTransport{}, ms)
 	return node, ms
 }
 
@@ -119,7 +233,7 @@ func newTestCluster() (map[NodeID]*RaftNode, map[NodeID]*memoryStorage) {
 	for _, p := range testPeers {
 		ms := newMemoryStorage()
 		stores[p.ID] = ms
-		node := NewRaftNode(Config{Self: p.ID, Peers: testPeers}, ft, ms)
+		node := newNode(Config{Self: p.ID, Peers: testPeers}, ft, ms)
 		ft.nodes[p.ID] = node
 	}
 
@@ -172,6 +286,37 @@ func TestPropose_FollowerRejects(t *testing.T) {
 	}
 }
 
+// TestPropose_FollowerReturnsLeaderHint proves a follower's rejection
+// tells the client where the leader is.
+func TestPropose_FollowerReturnsLeaderHint(t *testing.T) {
+	peers := []Peer{
+		{ID: "node-1", Addr: "localhost:3001", ClientAddr: "localhost:2181"},
+		{ID: "node-2", Addr: "localhost:3002", ClientAddr: "localhost:2182"},
+	}
+	node := newNode(Config{Self: "node-1", Peers: peers}, &failingTransport{}, newMemoryStorage())
+
+	// No leader known yet (no heartbeat received).
+	_, err := node.Propose("CREATE", "/app", nil)
+	var nle *NotLeaderError
+	if !errors.As(err, &nle) {
+		t.Fatalf("expected NotLeaderError, got %v", err)
+	}
+	if nle.LeaderID != "" {
+		t.Fatalf("expected unknown leader, got %s", nle.LeaderID)
+	}
+
+	// A heartbeat from node-2 tells node-1 who the leader is.
+	node.HandleAppendEntries(AppendEntriesRequest{Term: 1, LeaderID: "node-2"})
+
+	_, err = node.Propose("CREATE", "/app", nil)
+	if !errors.As(err, &nle) {
+		t.Fatalf("expected NotLeaderError, got %v", err)
+	}
+	if nle.LeaderID != "node-2" || nle.LeaderAddr != "localhost:2182" {
+		t.Fatalf("expected leader node-2 at localhost:2182, got %s at %s", nle.LeaderID, nle.LeaderAddr)
+	}
+}
+
 // TestPropose_SynchronousCommit proves that Propose replicates immediately
 // and returns only after the entry is committed (majority confirmed).
 //
@@ -223,7 +368,7 @@ func TestPropose_FailsWithoutConsensus(t *testing.T) {
 	// Create a leader with a transport where all peers fail.
 	failTransport := &failingTransport{}
 	ms := newMemoryStorage()
-	node := NewRaftNode(Config{Self: "node-1", Peers: testPeers}, failTransport, ms)
+	node := newNode(Config{Self: "node-1", Peers: testPeers}, failTransport, ms)
 
 	// Force node to be leader.
 	node.mu.Lock()
@@ -375,6 +520,46 @@ func TestApply_FollowerAppliesAfterLearningCommitIndex(t *testing.T) {
 	}
 }
 
+// TestApply_RestartResumesFromStoreCommitIndex proves a restarted node
+// doesn't re-apply what recovery already applied, and still applies the
+// uncommitted tail once the leader says it's committed.
+func TestApply_RestartResumesFromStoreCommitIndex(t *testing.T) {
+	// What store.New leaves behind after a crash: 3 entries on disk,
+	// the first 2 committed and replayed into the tree.
+	store := newMemoryStorage()
+	for i := int64(1); i <= 3; i++ {
+		entry := wal.Entry{TxID: i, Term: 1, Op: "CREATE", Path: fmt.Sprintf("/n%d", i)}
+		store.AppendWAL(entry)
+		if i <= 2 {
+			store.ApplyTree(entry)
+		}
+	}
+
+	node := newNode(Config{Self: "node-1", Peers: testPeers}, &failingTransport{}, store)
+	if node.GetCommitIndex() != 2 {
+		t.Fatalf("expected commitIndex 2 after restart, got %d", node.GetCommitIndex())
+	}
+
+	// The leader's heartbeat says everything up to 3 is committed.
+	resp := node.HandleAppendEntries(AppendEntriesRequest{
+		Term:              1,
+		LeaderID:          "node-2",
+		PrevLogTxID:       3,
+		PrevLogTerm:       1,
+		LeaderCommitIndex: 3,
+	})
+	if !resp.Success {
+		t.Fatal("heartbeat should succeed")
+	}
+
+	if len(store.applied) != 3 {
+		t.Fatalf("expected entries 1-3 applied exactly once, got %d applies", len(store.applied))
+	}
+	if store.applied[2].Path != "/n3" {
+		t.Fatalf("expected /n3 applied last, got %+v", store.applied[2])
+	}
+}
+
 // --- Replication tests ---
 
 func TestReplication_LeaderSendsEntriesToFollowers(t *testing.T) {
@@ -490,7 +675,7 @@ func TestAppendEntries_RejectStaleTerm(t *testing.T) {
 //   5. Result: [1, 2, 3, 4, 5_new, 6]
 func TestAppendEntries_TruncatesConflictingLog(t *testing.T) {
 	ms := newMemoryStorage()
-	node := NewRaftNode(Config{Self: "node-1", Peers: testPeers}, &fakeTransport{}, ms)
+	node := newNode(Config{Self: "node-1", Peers: testPeers}, &fakeTransport{}, ms)
 
 	// Simulate follower having entries 1-5, where entry 5 is from old leader (term 1).
 	ms.entries = []wal.Entry{
@@ -537,9 +722,132 @@ func TestAppendEntries_TruncatesConflictingLog(t *testing.T) {
 // TestAppendEntries_RejectsPrevLogMismatch proves that when the follower's
 // entry at PrevLogTxID has a different term, it rejects and truncates.
 // The leader will back up and retry with an earlier prevLog.
+// TestAppendEntries_DelayedBatchKeepsNewerEntries proves a late,
+// duplicate batch doesn't truncate entries that arrived after it.
+func TestAppendEntries_DelayedBatchKeepsNewerEntries(t *testing.T) {
+	ms := newMemoryStorage()
+	node := newNode(Config{Self: "node-1", Peers: testPeers}, &fakeTransport{}, ms)
+
+	e1 := wal.Entry{TxID: 1, Term: 1, Op: "CREATE", Path: "/a"}
+	e2 := wal.Entry{TxID: 2, Term: 1, Op: "CREATE", Path: "/b"}
+
+	// The newer batch [1, 2] arrives first...
+	node.HandleAppendEntries(AppendEntriesRequest{Term: 1, LeaderID: "node-2", Entries: []wal.Entry{e1, e2}})
+
+	// ...then the older batch [1] shows up late.
+	resp := node.HandleAppendEntries(AppendEntriesRequest{Term: 1, LeaderID: "node-2", Entries: []wal.Entry{e1}})
+	if !resp.Success {
+		t.Fatal("duplicate batch should be accepted")
+	}
+
+	if len(ms.entries) != 2 {
+		t.Fatalf("entry 2 must survive the late batch, got %d entries", len(ms.entries))
+	}
+	if resp.LastLogTxID != 2 {
+		t.Fatalf("expected LastLogTxID 2, got %d", resp.LastLogTxID)
+	}
+}
+
+// TestAppendEntries_CommitStopsAtLastNewEntry proves a follower doesn't
+// commit the tail of its log a request didn't cover: entry 3 may be a
+// stale entry the leader doesn't have.
+func TestAppendEntries_CommitStopsAtLastNewEntry(t *testing.T) {
+	ms := newMemoryStorage()
+	node := newNode(Config{Self: "node-1", Peers: testPeers}, &fakeTransport{}, ms)
+
+	for i := int64(1); i <= 3; i++ {
+		ms.entries = append(ms.entries, wal.Entry{TxID: i, Term: 1, Op: "CREATE", Path: fmt.Sprintf("/n%d", i)})
+	}
+
+	resp := node.HandleAppendEntries(AppendEntriesRequest{
+		Term:              1,
+		LeaderID:          "node-2",
+		PrevLogTxID:       1,
+		PrevLogTerm:       1,
+		Entries:           []wal.Entry{ms.entries[1]},
+		LeaderCommitIndex: 3,
+	})
+	if !resp.Success {
+		t.Fatal("request should be accepted")
+	}
+	if got := node.GetCommitIndex(); got != 2 {
+		t.Fatalf("commitIndex should stop at the last new entry (2), got %d", got)
+	}
+	if len(ms.applied) != 2 {
+		t.Fatalf("only entries 1 and 2 should be applied, got %d", len(ms.applied))
+	}
+}
+
+// TestAppendEntries_AcceptsCompactedPrevLog proves a follower that
+// compacted the prevLog entry still accepts the batch: compacted means
+// applied, and applied entries always match the leader's.
+func TestAppendEntries_AcceptsCompactedPrevLog(t *testing.T) {
+	ms := newMemoryStorage()
+	node := newNode(Config{Self: "node-1", Peers: testPeers}, &fakeTransport{}, ms)
+
+	for i := int64(1); i <= 5; i++ {
+		ms.entries = append(ms.entries, wal.Entry{TxID: i, Term: 1, Op: "CREATE", Path: fmt.Sprintf("/n%d", i)})
+	}
+	ms.compactedTo = 3
+
+	resp := node.HandleAppendEntries(AppendEntriesRequest{
+		Term:        1,
+		LeaderID:    "node-2",
+		PrevLogTxID: 3,
+		PrevLogTerm: 1,
+		Entries: []wal.Entry{
+			{TxID: 4, Term: 1, Op: "CREATE", Path: "/n4"},
+			{TxID: 5, Term: 1, Op: "CREATE", Path: "/n5"},
+			{TxID: 6, Term: 1, Op: "CREATE", Path: "/n6"},
+		},
+	})
+	if !resp.Success {
+		t.Fatal("prevLog was compacted, the follower should accept")
+	}
+	if len(ms.entries) != 6 || ms.entries[5].Path != "/n6" {
+		t.Fatalf("expected entry 6 appended, got %d entries", len(ms.entries))
+	}
+}
+
+// TestReplication_SkipsPeerBehindCompaction proves the leader doesn't
+// send a bogus batch to a peer whose next entries were compacted.
+func TestReplication_SkipsPeerBehindCompaction(t *testing.T) {
+	nodes, stores := newTestCluster()
+	node1 := nodes["node-1"]
+
+	voteReq := node1.StartElection()
+	votes := 1
+	for _, peer := range node1.config.OtherPeers() {
+		node1.CollectVote(nodes[peer.ID].HandleRequestVote(voteReq), &votes)
+	}
+
+	node1.appendEntry("CREATE", "/a", nil)
+	node1.appendEntry("CREATE", "/b", nil)
+	stores["node-1"].compactedTo = 1
+
+	// node-2 has everything, node-3 still needs entry 1.
+	node1.mu.Lock()
+	node1.nextIndex["node-2"] = 3
+	node1.nextIndex["node-3"] = 1
+	node1.mu.Unlock()
+
+	node1.leaderTick()
+
+	if nodes["node-2"].GetState().LeaderID != "node-1" {
+		t.Fatal("node-2 should still get heartbeats")
+	}
+
+	if len(stores["node-3"].entries) != 0 {
+		t.Fatalf("node-3 needs compacted entries and should get nothing, got %d", len(stores["node-3"].entries))
+	}
+	if node1.nextIndex["node-3"] != 1 {
+		t.Fatalf("node-3's nextIndex should stay at 1, got %d", node1.nextIndex["node-3"])
+	}
+}
+
 func TestAppendEntries_RejectsPrevLogMismatch(t *testing.T) {
 	ms := newMemoryStorage()
-	node := NewRaftNode(Config{Self: "node-1", Peers: testPeers}, &fakeTransport{}, ms)
+	node := newNode(Config{Self: "node-1", Peers: testPeers}, &fakeTransport{}, ms)
 
 	// Follower has entries 1-3, where entry 3 is from term 1.
 	ms.entries = []wal.Entry{
@@ -663,7 +971,7 @@ func TestRequestVote_RejectCandidateWithShorterLog(t *testing.T) {
 	for i := int64(1); i <= 10; i++ {
 		ms.entries = append(ms.entries, wal.Entry{TxID: i})
 	}
-	node := NewRaftNode(Config{Self: "node-1", Peers: testPeers}, &fakeTransport{}, ms)
+	node := newNode(Config{Self: "node-1", Peers: testPeers}, &fakeTransport{}, ms)
 
 	// Candidate only has entries up to TxID 5
 	resp := node.HandleRequestVote(RequestVoteRequest{
@@ -677,6 +985,32 @@ func TestRequestVote_RejectCandidateWithShorterLog(t *testing.T) {
 	}
 }
 
+// TestRequestVote_LaterLastTermWins proves the up-to-date check looks
+// at the last entry's term before the log's length.
+func TestRequestVote_LaterLastTermWins(t *testing.T) {
+	ms := newMemoryStorage()
+	for i := int64(1); i <= 5; i++ {
+		ms.entries = append(ms.entries, wal.Entry{TxID: i, Term: 2})
+	}
+	node := newNode(Config{Self: "node-1", Peers: testPeers}, &fakeTransport{}, ms)
+
+	// Longer, but its last entry is from an older term: behind.
+	resp := node.HandleRequestVote(RequestVoteRequest{
+		Term: 3, CandidateID: "node-2", LastLogTxID: 8, LastLogTerm: 1,
+	})
+	if resp.VoteGranted {
+		t.Fatal("should reject a longer log with an older last term")
+	}
+
+	// Shorter, but its last entry is from a later term: ahead.
+	resp = node.HandleRequestVote(RequestVoteRequest{
+		Term: 3, CandidateID: "node-3", LastLogTxID: 4, LastLogTerm: 3,
+	})
+	if !resp.VoteGranted {
+		t.Fatal("should grant a shorter log with a later last term")
+	}
+}
+
 // --- Election tests ---
 
 // This test simulates a full election with 3 nodes.
@@ -809,55 +1143,33 @@ func TestElection_SplitVote(t *testing.T) {
 
 // --- Automatic election test ---
 
-// This test starts 3 connected nodes, starts the loop, and waits
-// for a leader to be elected automatically. No manual calls.
+// This test starts 3 connected nodes and lets time pass until a leader
+// is elected automatically. No manual calls. The nodes run in a
+// simulation (sim_test.go): virtual time, no sleeping, and the same
+// election every run.
 func TestElection_Automatic(t *testing.T) {
-	nodes, _ := newTestCluster()
+	s := newSim(t, 1, 3)
 
-	// Make timeouts very short so the test runs fast.
-	for _, node := range nodes {
-		node.heartbeatInterval = 20 * time.Millisecond
-		node.electionTimeoutMin = 50 * time.Millisecond
-		node.electionTimeoutMax = 150 * time.Millisecond
-	}
-
-	// Start all 3 nodes. Each runs its loop in a goroutine.
-	for _, node := range nodes {
-		node.Run()
-	}
-
-	// Wait for an election to happen (should take < 200ms)
-	time.Sleep(500 * time.Millisecond)
-
-	// Stop all nodes
-	for _, node := range nodes {
-		node.Stop()
-	}
+	// Election timeouts are 300-500ms: well within a second, one node
+	// times out, wins the pre-vote and the election.
+	s.runFor(time.Second)
 
 	// Check: exactly one node should be leader
-	leaderCount := 0
-	var leaderID NodeID
-	for id, node := range nodes {
-		state := node.GetState()
-		if state.Role == Leader {
-			leaderCount++
-			leaderID = id
-		}
-	}
-
-	if leaderCount != 1 {
-		t.Fatalf("expected exactly 1 leader, got %d", leaderCount)
+	leaders := s.leadersNow()
+	if len(leaders) != 1 {
+		t.Fatalf("expected exactly 1 leader, got %d\n%s", len(leaders), s.status())
 	}
+	leaderID := leaders[0].id
 
 	// Check: all followers should know who the leader is
-	for id, node := range nodes {
-		if id == leaderID {
+	for _, n := range s.nodes {
+		if n.id == leaderID {
 			continue
 		}
-		state := node.GetState()
+		state := n.raft.GetState()
 		if state.LeaderID != leaderID {
 			t.Fatalf("node %s thinks leader is %s, but actual leader is %s",
-				id, state.LeaderID, leaderID)
+				n.id, state.LeaderID, leaderID)
 		}
 	}
 
@@ -0,0 +1,176 @@
+package cluster
+
+// THE PROBLEM:
+//
+// Reads are answered from the local tree, without going through the log.
+// That's fast, but the local tree may be behind:
+//
+//   follower:       applied up to 41, the leader already committed 42
+//   deposed leader: cut off by a partition; the others elected a new
+//                   leader and committed 42 without it — it doesn't know
+//
+// A client that wrote 42 (or heard about it from someone who did) can
+// read from either one and not see it.
+//
+// THE FIX: READ INDEX (Raft thesis §6.4)
+//
+// Before answering, find out how far the tree must be to include every
+// write committed so far, and wait until it's there:
+//
+//   1. The leader notes its commitIndex: the read index.
+//   2. It sends a heartbeat round. If a majority still answers in its
+//      term, nobody else was leader when it noted the index — no write
+//      it doesn't know about can have committed before.
+//   3. Whoever serves the read waits until it has applied the read index.
+//
+// A follower asks the leader for steps 1-2 (the server's Sync RPC), then
+// does step 3 itself.
+//
+// Step 1 needs the leader's commitIndex to be current. A new leader's
+// may not be: entries of earlier terms only commit together with one of
+// its own. So if nothing from its term is committed yet, ReadIndex
+// commits a NOOP entry first.
+//
+// THE SHORTCUT: A LEASE
+//
+// Step 2 costs a round trip per read. But leaderTick sends a heartbeat
+// round every tick anyway. If a majority answered one that started at T,
+// then no other node can be elected before T + electionTimeoutMin: each
+// node of that majority refuses to vote for electionTimeoutMin after
+// hearing from a leader (HandleRequestVote, rule 1b).
+//
+//   T                                 T + electionTimeoutMin
+//   ├──────────── lease ────────────┤ │
+//   heartbeat round,                  earliest another leader
+//   a majority answers                can be elected
+//
+// Inside the lease the leader serves reads at its commitIndex with no
+// round trip. The lease stops 10% short, for clocks that don't tick at
+// quite the same rate.
+//
+// ReadIndex trusts only messages; the lease also trusts clocks, and the
+// voters' memory: a voter that restarts forgets when it last heard from
+// the leader, and may vote inside the window. Where that matters, use
+// ReadIndex.
+
+import (
+	"context"
+	"time"
+
+	"github.com/syamsularifin/zookeeper/internal/wal"
+)
+
+// ReadIndex returns an index that's safe to read at: once the tree has
+// applied it (see WaitApplied), it reflects every write committed before
+// ReadIndex was called. Costs one heartbeat round.
+//
+// Only the leader can answer; anyone else returns a *NotLeaderError.
+func (rn *RaftNode) ReadIndex() (int64, error) {
+	if err := rn.commitInTerm(); err != nil {
+		return 0, err
+	}
+
+	rn.mu.Lock()
+	if rn.state.Role != Leader {
+		err := rn.notLeaderError()
+		rn.mu.Unlock()
+		return 0, err
+	}
+	index := rn.commitIndex
+	term := rn.state.CurrentTerm
+	asked := rn.now()
+	rn.mu.Unlock()
+
+	// Any round that started after we noted the index confirms it —
+	// ours, or one the loop started in the meantime.
+	rn.leaderTick()
+
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	if rn.state.Role != Leader || rn.state.CurrentTerm != term {
+		return 0, rn.notLeaderError()
+	}
+	if rn.confirmedAt.Before(asked) {
+		return 0, ErrNoQuorum
+	}
+	return index, nil
+}
+
+// LeaseReadIndex is ReadIndex without the heartbeat round, for a leader
+// whose lease holds. It returns ErrLeaseExpired if the lease doesn't hold
+// — ReadIndex still works then. A leader handing over leadership has
+// given its lease up (see transfer.go).
+func (rn *RaftNode) LeaseReadIndex() (int64, error) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	if rn.state.Role != Leader {
+		return 0, rn.notLeaderError()
+	}
+	if rn.since(rn.confirmedAt) >= rn.leaseDuration() || !rn.committedInTerm() ||
+		rn.transferTarget != "" {
+		return 0, ErrLeaseExpired
+	}
+	return rn.commitIndex, nil
+}
+
+// WaitApplied blocks until the tree has applied index, or ctx is done.
+func (rn *RaftNode) WaitApplied(ctx context.Context, index int64) error {
+	for {
+		rn.mu.Lock()
+		applied, moved := rn.lastApplied, rn.applied
+		rn.mu.Unlock()
+
+		if applied >= index {
+			return nil
+		}
+		select {
+		case <-moved:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// commitInTerm makes sure an entry of the leader's own term is
+// committed, proposing a NOOP if there isn't one yet. Two reads racing
+// here may both propose one; the second is harmless.
+func (rn *RaftNode) commitInTerm() error {
+	rn.mu.Lock()
+	if rn.state.Role != Leader {
+		err := rn.notLeaderError()
+		rn.mu.Unlock()
+		return err
+	}
+	done := rn.committedInTerm()
+	rn.mu.Unlock()
+
+	if done {
+		return nil
+	}
+	_, err := rn.ProposeEntry(wal.Entry{Op: wal.OpNoop})
+	return err
+}
+
+// committedInTerm reports whether the entry at commitIndex is from the
+// current term. Once one is, commitIndex is as far as any leader's.
+//
+// Must be called with rn.mu held.
+func (rn *RaftNode) committedInTerm() bool {
+	term, err := rn.store.TermAt(rn.commitIndex)
+	return err == nil && term == rn.state.CurrentTerm
+}
+
+// leaseDuration is how long after a confirmed heartbeat round the leader
+// may serve reads without asking again.
+func (rn *RaftNode) leaseDuration() time.Duration {
+	return rn.electionTimeoutMin - rn.electionTimeoutMin/10
+}
+
+// noteApplied wakes up everyone in WaitApplied.
+//
+// Must be called with rn.mu held.
+func (rn *RaftNode) noteApplied() {
+	close(rn.applied)
+	rn.applied = make(chan struct{})
+}
@@ -0,0 +1,153 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/syamsularifin/zookeeper/internal/wal"
+)
+
+// TestReadIndex_NewLeaderCommitsNoop proves a new leader's first
+// ReadIndex commits a NOOP in its own term, and with it the entries the
+// previous leader replicated but never committed.
+func TestReadIndex_NewLeaderCommitsNoop(t *testing.T) {
+	nodes, stores := newTestCluster()
+
+	// What the old leader of term 1 left behind: entry 1 on every node,
+	// committed nowhere.
+	for id, ms := range stores {
+		ms.AppendWAL(wal.Entry{TxID: 1, Term: 1, Op: "CREATE", Path: "/app"})
+		nodes[id].state.CurrentTerm = 1
+	}
+	leader := electNode1(t, nodes)
+
+	index, err := leader.ReadIndex()
+	if err != nil {
+		t.Fatalf("ReadIndex failed: %v", err)
+	}
+	if index != 2 {
+		t.Fatalf("expected read index 2, got %d", index)
+	}
+	if noop := stores["node-1"].entries[1]; noop.Op != wal.OpNoop || noop.Term != 2 {
+		t.Fatalf("expected a NOOP in term 2 at TxID 2, got %+v", noop)
+	}
+	if len(stores["node-1"].applied) != 2 {
+		t.Fatalf("expected entries 1 and 2 applied, got %d", len(stores["node-1"].applied))
+	}
+}
+
+// TestReadIndex_DeposedLeaderRefuses proves a leader that lost its
+// majority to a newer term finds out before serving a read.
+func TestReadIndex_DeposedLeaderRefuses(t *testing.T) {
+	nodes, _ := newTestCluster()
+	leader := electNode1(t, nodes)
+	if _, err := leader.ReadIndex(); err != nil {
+		t.Fatalf("ReadIndex failed: %v", err)
+	}
+
+	// The others moved on without node-1, as if they elected a new
+	// leader across a partition.
+	for _, id := range []NodeID{"node-2", "node-3"} {
+		nodes[id].state.CurrentTerm = 5
+	}
+
+	_, err := leader.ReadIndex()
+	var nle *NotLeaderError
+	if !errors.As(err, &nle) {
+		t.Fatalf("expected NotLeaderError, got %v", err)
+	}
+	if leader.GetState().Role == Leader {
+		t.Fatal("node-1 should have stepped down")
+	}
+}
+
+func TestLeaseReadIndex_HoldsUntilLeaseRunsOut(t *testing.T) {
+	nodes, _ := newTestCluster()
+	leader := electNode1(t, nodes)
+
+	// No heartbeat round answered yet, so no lease.
+	if _, err := leader.LeaseReadIndex(); !errors.Is(err, ErrLeaseExpired) {
+		t.Fatalf("expected ErrLeaseExpired before any round, got %v", err)
+	}
+
+	if _, err := leader.ReadIndex(); err != nil {
+		t.Fatalf("ReadIndex failed: %v", err)
+	}
+	index, err := leader.LeaseReadIndex()
+	if err != nil || index != 1 {
+		t.Fatalf("expected lease read at 1, got %d (%v)", index, err)
+	}
+
+	time.Sleep(leader.leaseDuration())
+	if _, err := leader.LeaseReadIndex(); !errors.Is(err, ErrLeaseExpired) {
+		t.Fatalf("expected ErrLeaseExpired after the lease, got %v", err)
+	}
+
+	var nle *NotLeaderError
+	if _, err := nodes["node-2"].LeaseReadIndex(); !errors.As(err, &nle) {
+		t.Fatalf("expected NotLeaderError on a follower, got %v", err)
+	}
+}
+
+// TestRequestVote_RejectWhileLeaderAlive proves a node that just heard
+// from its leader neither votes nor takes the candidate's term. The
+// leader lease relies on it.
+func TestRequestVote_RejectWhileLeaderAlive(t *testing.T) {
+	node, _ := newTestNode("node-1")
+	node.HandleAppendEntries(AppendEntriesRequest{Term: 1, LeaderID: "node-3"})
+
+	resp := node.HandleRequestVote(RequestVoteRequest{Term: 2, CandidateID: "node-2"})
+	if resp.VoteGranted {
+		t.Fatal("should reject vote while the leader is alive")
+	}
+	if term := node.GetState().CurrentTerm; term != 1 {
+		t.Fatalf("expected to stay in term 1, got %d", term)
+	}
+
+	// An election timeout of silence later, the leader may be gone.
+	node.mu.Lock()
+	node.leaderContact = time.Now().Add(-node.electionTimeoutMin)
+	node.mu.Unlock()
+
+	resp = node.HandleRequestVote(RequestVoteRequest{Term: 2, CandidateID: "node-2"})
+	if !resp.VoteGranted {
+		t.Fatal("should grant vote once the leader has gone quiet")
+	}
+}
+
+func TestWaitApplied_ReturnsOnceApplied(t *testing.T) {
+	nodes, _ := newTestCluster()
+	leader := electNode1(t, nodes)
+	follower := nodes["node-2"]
+
+	leader.appendEntry("CREATE", "/app", nil)
+	done := make(chan error, 1)
+	go func() { done <- follower.WaitApplied(context.Background(), 1) }()
+
+	// The first tick replicates, the second tells the follower it's
+	// committed.
+	leader.leaderTick()
+	select {
+	case err := <-done:
+		t.Fatalf("returned before entry 1 was applied: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	leader.leaderTick()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("WaitApplied failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("WaitApplied never returned")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := follower.WaitApplied(ctx, 10); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,264 @@
+package cluster
+
+// Tests on the simulated cluster (sim_test.go): a few scripted
+// scenarios, then many seeded random ones.
+//
+// A failing random schedule prints its seed. To run it again, alone and
+// with every event logged:
+//
+//   go test ./internal/cluster -run TestSim_RandomSchedules -sim.seed=17 -sim.trace
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	simSeed  = flag.Uint64("sim.seed", 0, "run only this seed in TestSim_RandomSchedules")
+	simSeeds = flag.Int("sim.seeds", 20, "how many seeds TestSim_RandomSchedules runs")
+	simTrace = flag.Bool("sim.trace", false, "log every event of the simulations")
+)
+
+// logTrace logs s's whole trace when -sim.trace is set.
+func logTrace(t *testing.T, s *sim) {
+	t.Cleanup(func() {
+		if *simTrace {
+			t.Log("\n" + strings.Join(s.trace, "\n"))
+		}
+	})
+}
+
+// awaitLeader runs s until some node leads, and returns it.
+func awaitLeader(t *testing.T, s *sim) *simNode {
+	t.Helper()
+	if !s.runUntil(5*time.Second, func() bool { return s.leader() != nil }) {
+		s.fail("no leader after 5s")
+	}
+	return s.leader()
+}
+
+// awaitApplied runs s until every node has applied txID.
+func awaitApplied(t *testing.T, s *sim, txID int64) {
+	t.Helper()
+	if !s.runUntil(5*time.Second, func() bool { return s.appliedEverywhere(txID) }) {
+		s.fail("TxID %d not applied everywhere after 5s", txID)
+	}
+}
+
+// awaitWrite writes until one write is applied on every node, within d.
+func awaitWrite(t *testing.T, s *sim, d time.Duration) {
+	t.Helper()
+	for end := s.elapsed() + d; s.elapsed() < end; {
+		txID := s.write()
+		if txID != 0 && s.runUntil(time.Second, func() bool { return s.appliedEverywhere(txID) }) {
+			return
+		}
+		s.runFor(100 * time.Millisecond)
+	}
+	s.fail("no write applied everywhere within %v", d)
+}
+
+// TestSim_PartitionedLeader cuts a leader off. The majority elects a new
+// one and goes on; the old leader's write never commits, and is replaced
+// once the partition heals.
+func TestSim_PartitionedLeader(t *testing.T) {
+	s := newSim(t, 1, 5)
+	logTrace(t, s)
+
+	old := awaitLeader(t, s)
+	awaitApplied(t, s, s.write())
+	oldTerm := old.raft.GetState().CurrentTerm
+
+	s.isolate(old.id)
+	lost := s.write() // only the old leader has it
+	if lost == 0 {
+		t.Fatal("the old leader didn't take the write")
+	}
+	lostEntry := old.store.entries[lost-1]
+	s.runFor(2 * time.Second)
+	if old.raft.GetCommitIndex() >= lost {
+		s.fail("an isolated leader committed TxID %d", lost)
+	}
+
+	next := s.leader()
+	if next == nil || next == old || next.raft.GetState().CurrentTerm <= oldTerm {
+		s.fail("the majority didn't elect a new leader")
+	}
+	kept := s.write()
+	if !s.runUntil(2*time.Second, func() bool { return next.store.CommitIndex() >= kept }) {
+		s.fail("the majority can't commit TxID %d", kept)
+	}
+
+	s.heal()
+	awaitApplied(t, s, kept)
+	if role := old.raft.GetState().Role; role == Leader {
+		s.fail("the old leader is still %s", role)
+	}
+	if got := old.store.applied[lost-1]; sameEntry(got, lostEntry) {
+		s.fail("the old leader applied its uncommitted write")
+	}
+}
+
+// TestSim_CrashAndRestart crashes nodes, the leader among them. The
+// cluster goes on while a majority is up, stops when it isn't, and
+// catches up when they come back — with the terms and votes they had.
+func TestSim_CrashAndRestart(t *testing.T) {
+	s := newSim(t, 2, 3)
+	logTrace(t, s)
+
+	leader := awaitLeader(t, s)
+	var follower NodeID
+	for _, n := range s.nodes {
+		if n != leader {
+			follower = n.id
+			break
+		}
+	}
+
+	s.crash(follower)
+	for i := 0; i < 5; i++ {
+		txID := s.write()
+		if !s.runUntil(time.Second, func() bool { return leader.store.CommitIndex() >= txID }) {
+			s.fail("two of three nodes can't commit TxID %d", txID)
+		}
+	}
+
+	term := leader.raft.GetState().CurrentTerm
+	s.crash(leader.id)
+	s.runFor(3 * time.Second)
+	if l := s.leader(); l != nil {
+		s.fail("%s leads with one node of three up", l.id)
+	}
+
+	s.restart(leader.id)
+	if got := leader.raft.GetState().CurrentTerm; got < term {
+		s.fail("%s restarted in term %d, it was in %d", leader.id, got, term)
+	}
+	s.restart(follower)
+	awaitWrite(t, s, 5*time.Second)
+}
+
+// TestSim_LossyNetwork drops, delays, duplicates and reorders a third of
+// all messages. Safety holds throughout (checked after every event), and
+// writes still get through.
+func TestSim_LossyNetwork(t *testing.T) {
+	s := newSim(t, 3, 5)
+	logTrace(t, s)
+	s.faults = faults{drop: 0.2, delay: 0.3, duplicate: 0.2, maxDelay: 400 * time.Millisecond}
+
+	for s.elapsed() < 20*time.Second {
+		s.write()
+		s.runFor(100 * time.Millisecond)
+	}
+	if len(s.committed) < 20 {
+		s.fail("only %d writes committed in 20s", len(s.committed))
+	}
+
+	s.faults = faults{}
+	awaitWrite(t, s, 5*time.Second)
+}
+
+// TestSim_SameSeedSameRun runs a random schedule twice: same seed, same
+// events, in the same order. Else a failing seed couldn't be replayed.
+func TestSim_SameSeedSameRun(t *testing.T) {
+	first := randomSchedule(t, 7)
+	second := randomSchedule(t, 7)
+	if !slices.Equal(first.trace, second.trace) {
+		for i := range min(len(first.trace), len(second.trace)) {
+			if first.trace[i] != second.trace[i] {
+				t.Fatalf("runs differ at event %d:\n  %s\n  %s", i, first.trace[i], second.trace[i])
+			}
+		}
+		t.Fatalf("runs differ in length: %d vs %d events", len(first.trace), len(second.trace))
+	}
+	if other := randomSchedule(t, 8); slices.Equal(first.trace, other.trace) {
+		t.Fatal("seeds 7 and 8 ran the same events")
+	}
+}
+
+// TestSim_RandomSchedules runs a random schedule for each of -sim.seeds
+// seeds, or just -sim.seed.
+func TestSim_RandomSchedules(t *testing.T) {
+	seeds := *simSeeds
+	if testing.Short() {
+		seeds = min(seeds, 5)
+	}
+	var run []uint64
+	for seed := uint64(1); seed <= uint64(seeds); seed++ {
+		run = append(run, seed)
+	}
+	if *simSeed != 0 {
+		run = []uint64{*simSeed}
+	}
+
+	for _, seed := range run {
+		t.Run(fmt.Sprintf("seed=%d", seed), func(t *testing.T) {
+			s := randomSchedule(t, seed)
+			logTrace(t, s)
+		})
+	}
+}
+
+// randomSchedule runs 20s of writes on a 3- or 5-node cluster while a
+// nemesis partitions the network and crashes and restarts nodes, on a
+// network as lossy as the seed says. Then it heals everything: the
+// cluster must commit again, on every node.
+func randomSchedule(t *testing.T, seed uint64) *sim {
+	t.Helper()
+	s := newSim(t, seed, 3+2*int(seed%2))
+	s.faults = faults{
+		drop:      s.rand.Float64() * 0.1,
+		delay:     s.rand.Float64() * 0.2,
+		duplicate: s.rand.Float64() * 0.1,
+		maxDelay:  time.Duration(1+s.rand.IntN(500)) * time.Millisecond,
+	}
+	s.logf("faults %+v", s.faults)
+
+	for s.elapsed() < 20*time.Second {
+		s.runFor(time.Duration(10+s.rand.IntN(200)) * time.Millisecond)
+		s.write()
+		if s.rand.IntN(10) == 0 {
+			nemesis(s)
+		}
+	}
+
+	s.heal()
+	s.faults = faults{}
+	for _, n := range s.nodes {
+		s.restart(n.id)
+	}
+	awaitWrite(t, s, 10*time.Second)
+	return s
+}
+
+// nemesis does one bad thing to s.
+func nemesis(s *sim) {
+	n := s.nodes[s.rand.IntN(len(s.nodes))]
+	switch s.rand.IntN(5) {
+	case 0:
+		// Split the cluster in two, at random.
+		var a, b []NodeID
+		for _, n := range s.nodes {
+			if s.rand.IntN(2) == 0 {
+				a = append(a, n.id)
+			} else {
+				b = append(b, n.id)
+			}
+		}
+		s.partition(a, b)
+	case 1:
+		s.isolate(n.id)
+	case 2:
+		s.heal()
+	case 3:
+		if n.raft != nil {
+			s.crash(n.id)
+		}
+	case 4:
+		s.restart(n.id)
+	}
+}
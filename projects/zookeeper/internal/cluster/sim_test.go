@@ -0,0 +1,625 @@
+package cluster
+
+// A deterministic simulation of a whole cluster, for tests.
+//
+// THE PROBLEM:
+//
+// A cluster of RaftNodes on real goroutines, real timers and a real (or
+// fake, but instant) network runs whichever interleaving the scheduler
+// picks. The bugs worth finding need a particular one — a vote that
+// arrives after its voter restarted, an AppendEntries from a deposed
+// leader delivered late — and show up once in a thousand runs, never
+// while anyone is looking.
+//
+// THE FIX: ONE GOROUTINE, VIRTUAL TIME, A SCRIPTED NETWORK
+//
+// Nodes never Run. The simulation keeps a queue of events ordered by
+// virtual time, and runs them one at a time:
+//
+//   0.050s  tick node-1          → leaderTick → SendAppendEntries(node-2)
+//             node-1 → node-2      deliver now: node-2.HandleAppendEntries
+//             node-1 → node-3      delay 80ms:  queued, node-1 sees a timeout
+//   0.071s  tick node-2
+//   0.130s  late node-1 → node-3 node-3.HandleAppendEntries, reply dropped
+//
+// Every node ticks every 50ms, as loop does, and reads the time from the
+// simulation's clock (Config.Clock), which jumps from one event to the
+// next. Messages go through simTransport, a Transport that asks the
+// simulation what happens to each one:
+//
+//   partitioned / target down   → error, nothing delivered
+//   dropped                     → error, nothing delivered
+//   delayed                     → error now (the RPC timed out), the
+//                                 request is handled later — maybe after
+//                                 newer ones: that's reordering
+//   duplicated                  → handled now and again later
+//   reply dropped               → handled now, but the sender sees an error
+//
+// Nodes crash (their RaftNode is thrown away; the log in memoryStorage
+// and the term and vote in DataDir survive, as on disk) and restart.
+//
+// Everything random — election timeouts (Config.Rand), which messages
+// are dropped or delayed and for how long, what the schedule does next —
+// comes from one seed. The same seed runs the same events in the same
+// order, so a failing seed can be replayed as often as it takes.
+//
+// SAFETY:
+//
+// After every event, check tests Raft's guarantees (paper, Figure 3):
+//
+//   election safety        at most one leader per term
+//   log matching           same TxID and term → the same entries up to there
+//   leader completeness    a new leader has every entry applied anywhere
+//   state machine safety   every node applies the same entry at each TxID
+//
+// A violation fails the test with the seed and the last events.
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syamsularifin/zookeeper/internal/wal"
+	"github.com/syamsularifin/zookeeper/internal/znode"
+)
+
+// simTick is how often a node ticks, as in loop.
+const simTick = 50 * time.Millisecond
+
+var (
+	errSimUnreachable = errors.New("sim: unreachable")
+	errSimTimeout     = errors.New("sim: timed out")
+)
+
+// simClock is virtual time, shared by every node of a simulation.
+type simClock struct {
+	now time.Time
+}
+
+func (c *simClock) Now() time.Time { return c.now }
+
+// faults is how badly the network behaves. Probabilities go from 0 to 1.
+type faults struct {
+	drop      float64       // a request, or its reply, is lost
+	delay     float64       // a request arrives late
+	duplicate float64       // a request arrives twice, the copy late
+	maxDelay  time.Duration // how late, at most
+}
+
+// simEvent is something that happens at a point in virtual time.
+type simEvent struct {
+	at   time.Duration // since the start
+	seq  int           // ties go in the order events were scheduled
+	what string
+	run  func()
+}
+
+// eventQueue is a heap of events, earliest first.
+type eventQueue []*simEvent
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x any)   { *q = append(*q, x.(*simEvent)) }
+func (q *eventQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// simNode is one member of a simulation. Its store and dir are its disk,
+// and outlive crashes; raft is nil while it's down.
+type simNode struct {
+	id    NodeID
+	raft  *RaftNode
+	store *memoryStorage
+	dir   string
+
+	// checked is how many of store.applied check has seen.
+	checked int
+}
+
+// sim is a simulated cluster. Not safe for concurrent use — nothing in
+// it is concurrent.
+type sim struct {
+	t      testing.TB
+	seed   uint64
+	rand   *rand.Rand
+	clock  *simClock
+	start  time.Time
+	peers  []Peer
+	nodes  []*simNode
+	events eventQueue
+	seq    int
+
+	// faults applies to every message, on top of the partition.
+	faults faults
+
+	// group is each node's side of a partition: nodes in different
+	// groups can't talk. nil when there's no partition.
+	group map[NodeID]int
+
+	// trace is everything that happened, one line each.
+	trace []string
+
+	// leaders is every term's leader seen so far.
+	leaders map[int64]NodeID
+
+	// committed[i] is the entry applied at TxID i+1, by whichever node
+	// applied it first.
+	committed []wal.Entry
+
+	// writes numbers client writes.
+	writes int
+}
+
+// newSim starts a cluster of size nodes, all up, no faults.
+func newSim(t testing.TB, seed uint64, size int) *sim {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &sim{
+		t:       t,
+		seed:    seed,
+		rand:    rand.New(rand.NewPCG(seed, seed)),
+		clock:   &simClock{now: start},
+		start:   start,
+		leaders: make(map[int64]NodeID),
+	}
+	for i := 1; i <= size; i++ {
+		id := NodeID(fmt.Sprintf("node-%d", i))
+		s.peers = append(s.peers, Peer{ID: id, Addr: string(id)})
+	}
+	for _, p := range s.peers {
+		n := &simNode{id: p.ID, store: newMemoryStorage(), dir: t.TempDir()}
+		s.nodes = append(s.nodes, n)
+		s.boot(n)
+	}
+	return s
+}
+
+// boot starts n's RaftNode from what's on its disk, and its ticks.
+func (s *sim) boot(n *simNode) {
+	config := Config{
+		Self:    n.id,
+		Peers:   s.peers,
+		DataDir: n.dir,
+		Clock:   s.clock,
+		Rand:    rand.New(rand.NewPCG(s.rand.Uint64(), s.rand.Uint64())),
+	}
+	rn, err := NewRaftNode(config, &simTransport{sim: s, from: n.id}, n.store)
+	if err != nil {
+		s.t.Fatalf("NewRaftNode(%s) failed: %v", n.id, err)
+	}
+	rn.logger = slog.New(slog.DiscardHandler)
+	n.raft = rn
+
+	// Nodes don't tick in lockstep.
+	s.tick(n, rn, time.Duration(s.rand.Int64N(int64(simTick))))
+}
+
+// tick schedules rn's next tick, and the one after that, until rn is
+// no longer n's RaftNode (it crashed).
+func (s *sim) tick(n *simNode, rn *RaftNode, after time.Duration) {
+	s.after(after, "tick "+string(n.id), func() {
+		if n.raft != rn {
+			return
+		}
+		rn.tick()
+		s.tick(n, rn, simTick)
+	})
+}
+
+// after schedules run in d of virtual time.
+func (s *sim) after(d time.Duration, what string, run func()) {
+	s.seq++
+	heap.Push(&s.events, &simEvent{at: s.elapsed() + d, seq: s.seq, what: what, run: run})
+}
+
+// elapsed is the virtual time since the simulation started.
+func (s *sim) elapsed() time.Duration {
+	return s.clock.now.Sub(s.start)
+}
+
+// logf adds a line to the trace.
+func (s *sim) logf(format string, args ...any) {
+	s.trace = append(s.trace, fmt.Sprintf("%8.3fs  ", s.elapsed().Seconds())+fmt.Sprintf(format, args...))
+}
+
+// runFor runs every event due in the next d, checking after each one.
+func (s *sim) runFor(d time.Duration) {
+	s.t.Helper()
+	s.runUntil(d, func() bool { return false })
+}
+
+// runUntil runs events until done holds (checked after each one) or d
+// has passed. It reports whether done held.
+func (s *sim) runUntil(d time.Duration, done func() bool) bool {
+	s.t.Helper()
+	end := s.elapsed() + d
+	for len(s.events) > 0 && s.events[0].at <= end {
+		e := heap.Pop(&s.events).(*simEvent)
+		s.clock.now = s.start.Add(e.at)
+		s.logf("%s", e.what)
+		e.run()
+		s.check()
+		if done() {
+			return true
+		}
+	}
+	s.clock.now = s.start.Add(end)
+	return done()
+}
+
+// node returns the node with id.
+func (s *sim) node(id NodeID) *simNode {
+	for _, n := range s.nodes {
+		if n.id == id {
+			return n
+		}
+	}
+	s.t.Fatalf("no node %s", id)
+	return nil
+}
+
+// --- faults ---
+
+// partition splits the cluster: each group talks only among itself.
+// Nodes not in any group are cut off from everyone.
+func (s *sim) partition(groups ...[]NodeID) {
+	s.group = make(map[NodeID]int)
+	for _, n := range s.nodes {
+		s.group[n.id] = -1 - len(s.group) // alone
+	}
+	for i, g := range groups {
+		for _, id := range g {
+			s.group[id] = i
+		}
+	}
+	s.logf("partition %v", groups)
+}
+
+// isolate cuts id off from everyone else.
+func (s *sim) isolate(id NodeID) {
+	var rest []NodeID
+	for _, n := range s.nodes {
+		if n.id != id {
+			rest = append(rest, n.id)
+		}
+	}
+	s.partition([]NodeID{id}, rest)
+}
+
+// heal ends the partition.
+func (s *sim) heal() {
+	s.group = nil
+	s.logf("heal")
+}
+
+// crash stops id: its RaftNode is gone, its disk stays.
+func (s *sim) crash(id NodeID) {
+	s.node(id).raft = nil
+	s.logf("crash %s", id)
+}
+
+// restart brings a crashed node back, from its disk.
+func (s *sim) restart(id NodeID) {
+	n := s.node(id)
+	if n.raft != nil {
+		return
+	}
+	s.logf("restart %s", id)
+	s.boot(n)
+}
+
+// chance reports true with probability p.
+func (s *sim) chance(p float64) bool {
+	return p > 0 && s.rand.Float64() < p
+}
+
+// connected reports whether a message from a can reach b right now.
+func (s *sim) connected(a, b NodeID) bool {
+	return s.node(b).raft != nil && (s.group == nil || s.group[a] == s.group[b])
+}
+
+// send is how every message travels: deliver hands it to the target's
+// RaftNode, if and when it gets there.
+func (s *sim) send(from, to NodeID, what string, deliver func(*RaftNode)) error {
+	msg := fmt.Sprintf("%s → %s %s", from, to, what)
+	if !s.connected(from, to) {
+		s.logf("  %s: unreachable", msg)
+		return errSimUnreachable
+	}
+	if s.chance(s.faults.drop) {
+		s.logf("  %s: lost", msg)
+		return errSimTimeout
+	}
+	if s.chance(s.faults.delay) {
+		d := s.delay()
+		s.logf("  %s: delayed %v", msg, d)
+		s.after(d, "late "+msg, func() { s.deliver(from, to, deliver) })
+		return errSimTimeout
+	}
+	if s.chance(s.faults.duplicate) {
+		d := s.delay()
+		s.logf("  %s: duplicated, copy in %v", msg, d)
+		s.after(d, "copy "+msg, func() { s.deliver(from, to, deliver) })
+	}
+
+	deliver(s.node(to).raft)
+	if s.chance(s.faults.drop) {
+		s.logf("  %s: reply lost", msg)
+		return errSimTimeout
+	}
+	s.logf("  %s: delivered", msg)
+	return nil
+}
+
+// deliver hands a late message to its target, unless it went down or
+// got cut off meanwhile. Nobody waits for the reply anymore.
+func (s *sim) deliver(from, to NodeID, deliver func(*RaftNode)) {
+	if !s.connected(from, to) {
+		s.logf("  dropped: %s is unreachable", to)
+		return
+	}
+	deliver(s.node(to).raft)
+}
+
+// delay picks how late a message arrives.
+func (s *sim) delay() time.Duration {
+	return time.Millisecond + time.Duration(s.rand.Int64N(int64(max(s.faults.maxDelay, time.Millisecond))))
+}
+
+// simTransport is the Transport of one simulated node.
+type simTransport struct {
+	sim  *sim
+	from NodeID
+}
+
+func (tr *simTransport) SendRequestVote(peer Peer, req RequestVoteRequest) (RequestVoteResponse, error) {
+	kind := "RequestVote"
+	if req.PreVote {
+		kind = "PreVote"
+	}
+	var resp RequestVoteResponse
+	err := tr.sim.send(tr.from, peer.ID, fmt.Sprintf("%s t%d", kind, req.Term), func(rn *RaftNode) {
+		resp = rn.HandleRequestVote(req)
+	})
+	return resp, err
+}
+
+func (tr *simTransport) SendAppendEntries(peer Peer, req AppendEntriesRequest) (AppendEntriesResponse, error) {
+	// The entries alias the sender's log, which a late delivery may
+	// outlive: it can be truncated and written over meanwhile.
+	req.Entries = slices.Clone(req.Entries)
+
+	what := fmt.Sprintf("AppendEntries t%d prev=%d", req.Term, req.PrevLogTxID)
+	if len(req.Entries) > 0 {
+		what += fmt.Sprintf(" [%d-%d]", req.Entries[0].TxID, req.Entries[len(req.Entries)-1].TxID)
+	}
+	var resp AppendEntriesResponse
+	err := tr.sim.send(tr.from, peer.ID, what, func(rn *RaftNode) {
+		resp = rn.HandleAppendEntries(req)
+	})
+	return resp, err
+}
+
+func (tr *simTransport) SendInstallSnapshot(peer Peer, req InstallSnapshotRequest) (InstallSnapshotResponse, error) {
+	var resp InstallSnapshotResponse
+	err := tr.sim.send(tr.from, peer.ID, fmt.Sprintf("InstallSnapshot t%d", req.Term), func(rn *RaftNode) {
+		resp = rn.HandleInstallSnapshot(req)
+	})
+	return resp, err
+}
+
+func (tr *simTransport) SendTimeoutNow(peer Peer, req TimeoutNowRequest) (TimeoutNowResponse, error) {
+	var resp TimeoutNowResponse
+	err := tr.sim.send(tr.from, peer.ID, fmt.Sprintf("TimeoutNow t%d", req.Term), func(rn *RaftNode) {
+		resp = rn.HandleTimeoutNow(req)
+	})
+	return resp, err
+}
+
+// --- clients ---
+
+// leaders returns the nodes that are up and think they're the leader.
+// During a partition there can be more than one, in different terms.
+func (s *sim) leadersNow() []*simNode {
+	var out []*simNode
+	for _, n := range s.nodes {
+		if n.raft != nil && n.raft.GetState().Role == Leader {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// leader returns the leader with the highest term, nil if there's none.
+func (s *sim) leader() *simNode {
+	var best *simNode
+	for _, n := range s.leadersNow() {
+		if best == nil || n.raft.GetState().CurrentTerm > best.raft.GetState().CurrentTerm {
+			best = n
+		}
+	}
+	return best
+}
+
+// write has a node that thinks it's the leader take a client write. It
+// returns the write's TxID, or 0 if no node took it.
+//
+// ProposeEntry can't be used: it sends to every peer from its own
+// goroutine and blocks until they answer. write runs the same steps —
+// appendBatch, then sendTo every peer — one after another, and doesn't
+// wait for the entry to commit. It commits (or not) as the simulation
+// goes on.
+func (s *sim) write() int64 {
+	leaders := s.leadersNow()
+	if len(leaders) == 0 {
+		return 0
+	}
+	n := leaders[s.rand.IntN(len(leaders))]
+	rn := n.raft
+
+	s.writes++
+	data := fmt.Sprintf("w%d", s.writes)
+	p := &proposal{
+		entry: wal.Entry{Op: wal.OpSet, Path: "/sim", Data: []byte(data), Version: znode.AnyVersion},
+		done:  make(chan struct{}),
+	}
+	rn.mu.Lock()
+	rn.queue = append(rn.queue, p)
+	peers := rn.config.OtherPeers()
+	rn.mu.Unlock()
+
+	rn.proposeMu.Lock()
+	b := rn.appendBatch()
+	rn.proposeMu.Unlock()
+	if b == nil {
+		return 0
+	}
+	s.logf("client → %s: %s at TxID %d", n.id, data, b.last)
+
+	rn.advanceCommitIndex()
+	for _, peer := range peers {
+		if rn.sendTo(peer, b.term, b.last) {
+			rn.advanceCommitIndex()
+		}
+	}
+	s.check()
+	return b.last
+}
+
+// appliedEverywhere reports whether every node has applied txID.
+func (s *sim) appliedEverywhere(txID int64) bool {
+	for _, n := range s.nodes {
+		if n.store.CommitIndex() < txID {
+			return false
+		}
+	}
+	return true
+}
+
+// --- invariants ---
+
+// check fails the test if any of Raft's safety properties is broken.
+func (s *sim) check() {
+	s.t.Helper()
+
+	// Election safety, and leader completeness for every new leader.
+	for _, n := range s.nodes {
+		if n.raft == nil {
+			continue
+		}
+		state := n.raft.GetState()
+		if state.Role != Leader {
+			continue
+		}
+		if other, ok := s.leaders[state.CurrentTerm]; ok {
+			if other != n.id {
+				s.fail("election safety: %s and %s are both leader of term %d", other, n.id, state.CurrentTerm)
+			}
+			continue
+		}
+		s.leaders[state.CurrentTerm] = n.id
+		s.logf("%s leads term %d", n.id, state.CurrentTerm)
+		for i, e := range s.committed {
+			if i >= len(n.store.entries) || !sameEntry(n.store.entries[i], e) {
+				s.fail("leader completeness: %s leads term %d without committed TxID %d", n.id, state.CurrentTerm, e.TxID)
+			}
+		}
+	}
+
+	// Log matching: crashed nodes' logs count too, they're on disk.
+	for i, a := range s.nodes {
+		for _, b := range s.nodes[i+1:] {
+			s.checkLogsMatch(a, b)
+		}
+	}
+
+	// State machine safety: one entry per TxID, applied in order.
+	for _, n := range s.nodes {
+		for ; n.checked < len(n.store.applied); n.checked++ {
+			e := n.store.applied[n.checked]
+			if n.checked > 0 && e.TxID != n.store.applied[n.checked-1].TxID+1 {
+				s.fail("%s applied TxID %d right after %d", n.id, e.TxID, n.store.applied[n.checked-1].TxID)
+			}
+			switch i := int(e.TxID - 1); {
+			case i == len(s.committed):
+				s.committed = append(s.committed, e)
+			case i < len(s.committed):
+				if !sameEntry(e, s.committed[i]) {
+					s.fail("state machine safety: %s applied %s at TxID %d, another node %s",
+						n.id, describe(e), e.TxID, describe(s.committed[i]))
+				}
+			default:
+				s.fail("%s applied TxID %d, before anyone applied %d", n.id, e.TxID, len(s.committed)+1)
+			}
+		}
+	}
+}
+
+// checkLogsMatch checks the Log Matching property between two nodes: if
+// their logs have an entry with the same TxID and term, they're the
+// same up to there.
+func (s *sim) checkLogsMatch(a, b *simNode) {
+	s.t.Helper()
+	la, lb := a.store.entries, b.store.entries
+	last := -1
+	for i := min(len(la), len(lb)) - 1; i >= 0; i-- {
+		if la[i].Term == lb[i].Term {
+			last = i
+			break
+		}
+	}
+	for i := 0; i <= last; i++ {
+		if !sameEntry(la[i], lb[i]) {
+			s.fail("log matching: %s and %s agree on TxID %d (term %d) but not on TxID %d: %s vs %s",
+				a.id, b.id, last+1, la[last].Term, i+1, describe(la[i]), describe(lb[i]))
+		}
+	}
+}
+
+// sameEntry reports whether two entries are the same write.
+func sameEntry(a, b wal.Entry) bool {
+	return a.TxID == b.TxID && a.Term == b.Term && a.Op == b.Op && a.Path == b.Path && string(a.Data) == string(b.Data)
+}
+
+// describe is an entry in a failure message.
+func describe(e wal.Entry) string {
+	return fmt.Sprintf("%s %q (term %d)", e.Op, e.Data, e.Term)
+}
+
+// status sums up every node, for failure messages.
+func (s *sim) status() string {
+	var b strings.Builder
+	for _, n := range s.nodes {
+		if n.raft == nil {
+			fmt.Fprintf(&b, "  %s: down, log to %d, applied %d\n", n.id, n.store.LastWALTxID(), n.store.CommitIndex())
+			continue
+		}
+		state := n.raft.GetState()
+		fmt.Fprintf(&b, "  %s: %s term %d, log to %d, commit %d, applied %d\n",
+			n.id, state.Role, state.CurrentTerm, n.store.LastWALTxID(), n.raft.GetCommitIndex(), n.store.CommitIndex())
+	}
+	return b.String()
+}
+
+// fail stops the test with what went wrong, where the nodes are, the
+// events that led there, and how to run them again.
+func (s *sim) fail(format string, args ...any) {
+	s.t.Helper()
+	tail := s.trace[max(0, len(s.trace)-60):]
+	s.t.Fatalf("seed %d, at %v: %s\n\nnodes:\n%s\nlast events:\n%s\n\nreplay: go test ./internal/cluster -run '%s' -sim.seed=%d -sim.trace",
+		s.seed, s.elapsed(), fmt.Sprintf(format, args...), s.status(), strings.Join(tail, "\n"), s.t.Name(), s.seed)
+}
@@ -41,6 +41,11 @@ func (r Role) String() string {
 	}
 }
 
+// MarshalText makes a Role read "leader" in JSON, not 2.
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
 // NodeState holds the Raft state for this node.
 //
 // In Raft, time is divided into "terms". A term is like an election cycle:
@@ -58,7 +63,7 @@ func (r Role) String() string {
 // it's no longer leader — even though it never received a "you're fired" message.
 type NodeState struct {
 	// Role is the current state: Follower, Candidate, or Leader.
-	Role Role
+	Role Role `json:"role"`
 
 	// CurrentTerm is the latest term this node has seen.
 	// Starts at 0. Increases by 1 each time an election starts.
@@ -67,7 +72,7 @@ type NodeState struct {
 	// a message with a higher term, it updates its own term and
 	// steps down to follower. This is how stale leaders discover
 	// they've been replaced.
-	CurrentTerm int64
+	CurrentTerm int64 `json:"current_term"`
 
 	// VotedFor records who this node voted for in the current term.
 	// Empty string means "haven't voted yet this term".
@@ -78,12 +83,12 @@ type NodeState struct {
 	// Example:
 	//   Term 5 starts. node-2 asks for votes. node-1 votes for node-2.
 	//   node-3 also asks for votes. node-1 says "no, I already voted for node-2."
-	VotedFor NodeID
+	VotedFor NodeID `json:"voted_for"`
 
 	// LeaderID is the ID of the current known leader.
 	// Empty if no leader is known (e.g. during an election).
 	// Followers use this to forward write requests to the leader.
-	LeaderID NodeID
+	LeaderID NodeID `json:"leader_id"`
 }
 
 // NewNodeState creates the initial state: follower, term 0, no votes.
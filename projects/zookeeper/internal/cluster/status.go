@@ -0,0 +1,107 @@
+package cluster
+
+// What an operator asks a node: who are you, who leads, how far along
+// is the log, and — on the leader — how far behind is each follower.
+//
+//   node-1 (leader, term 4)   commit 1042   applied 1042   last 1043
+//     node-2   match 1043   lag 0
+//     node-3   match  871   lag 172   ← slow disk? sending a snapshot?
+//
+// Lag is measured in entries: the leader's last entry minus the last
+// one the peer has confirmed. Only the leader knows it; a follower
+// doesn't hear from the other followers.
+
+// Status is a node's Raft state and progress, for the admin endpoints.
+type Status struct {
+	ID NodeID `json:"id"`
+	NodeState
+
+	// Isolated is set when the LeaderID this node reports is probably
+	// stale: a follower hasn't heard from its leader, or a leader from
+	// a majority, for a whole election timeout. The node is cut off, or
+	// the others are down. (A cut-off follower keeps its old LeaderID:
+	// its pre-votes fail, so it never starts an election that would
+	// clear it.)
+	Isolated bool `json:"isolated,omitempty"`
+
+	CommitIndex int64 `json:"commit_index"`
+	LastApplied int64 `json:"last_applied"`
+	LastLogTxID int64 `json:"last_log_txid"`
+	LastLogTerm int64 `json:"last_log_term"`
+
+	// Elections counts the elections this node started, ElectionsWon
+	// the ones it won, since it started.
+	Elections    int64 `json:"elections"`
+	ElectionsWon int64 `json:"elections_won"`
+
+	// Peers is every other member and how far along it is. Only the
+	// leader fills in the progress; elsewhere it's zero.
+	Peers []PeerStatus `json:"peers"`
+}
+
+// PeerStatus is how far along one peer is, as the leader sees it.
+type PeerStatus struct {
+	ID      NodeID `json:"id"`
+	Learner bool   `json:"learner,omitempty"`
+
+	MatchIndex int64 `json:"match_index"`
+	NextIndex  int64 `json:"next_index"`
+
+	// Lag is how many entries the peer is missing.
+	Lag int64 `json:"lag"`
+
+	// SendingSnapshot is set while the peer is too far behind for the
+	// log and gets a snapshot instead.
+	SendingSnapshot bool `json:"sending_snapshot,omitempty"`
+}
+
+// Status returns a copy of the node's state and progress.
+func (rn *RaftNode) Status() Status {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	lastTxID, lastTerm, err := rn.lastLog()
+	if err != nil {
+		rn.logger.Error("failed to read last log term", "error", err)
+	}
+	st := Status{
+		ID:           rn.config.Self,
+		NodeState:    *rn.state,
+		Isolated:     rn.isolated(),
+		CommitIndex:  rn.commitIndex,
+		LastApplied:  rn.lastApplied,
+		LastLogTxID:  lastTxID,
+		LastLogTerm:  lastTerm,
+		Elections:    rn.elections,
+		ElectionsWon: rn.electionsWon,
+	}
+	for _, peer := range rn.config.OtherPeers() {
+		ps := PeerStatus{ID: peer.ID, Learner: peer.Learner}
+		if rn.state.Role == Leader {
+			ps.MatchIndex = rn.matchIndex[peer.ID]
+			ps.NextIndex = rn.nextIndex[peer.ID]
+			ps.Lag = max(lastTxID-ps.MatchIndex, 0)
+			ps.SendingSnapshot = rn.sendingSnapshot[peer.ID]
+		}
+		st.Peers = append(st.Peers, ps)
+	}
+	return st
+}
+
+// isolated computes Status.Isolated.
+//
+// Must be called with rn.mu held.
+func (rn *RaftNode) isolated() bool {
+	switch {
+	case rn.state.Role == Leader:
+		// A majority answered the last heartbeat round, or elected us.
+		heard := rn.confirmedAt
+		if rn.electedAt.After(heard) {
+			heard = rn.electedAt
+		}
+		return rn.since(heard) > rn.electionTimeoutMax
+	case rn.state.LeaderID != "":
+		return rn.since(rn.leaderContact) > rn.electionTimeoutMax
+	}
+	return false
+}
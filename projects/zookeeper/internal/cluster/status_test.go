@@ -0,0 +1,123 @@
+package cluster
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatus_LeaderReportsLag(t *testing.T) {
+	nodes, _ := newTestCluster()
+	node2 := nodes["node-2"]
+
+	voteReq := node2.StartElection()
+	votes := 1
+	for _, peer := range node2.config.OtherPeers() {
+		node2.CollectVote(nodes[peer.ID].HandleRequestVote(voteReq), &votes)
+	}
+
+	// node-3 goes away; node-1 gets everything.
+	ft := node2.transport.(*fakeTransport)
+	node3 := ft.nodes["node-3"]
+	delete(ft.nodes, "node-3")
+	node2.appendEntry("CREATE", "/app", []byte("v1"))
+	node2.appendEntry("SET", "/app", []byte("v2"))
+	node2.leaderTick()
+
+	st := node2.Status()
+	if st.ID != "node-2" || st.Role != Leader || st.LeaderID != "node-2" || st.CurrentTerm != 1 {
+		t.Fatalf("unexpected state: %+v", st.NodeState)
+	}
+	if st.LastLogTxID != 2 || st.LastLogTerm != 1 || st.CommitIndex != 2 {
+		t.Fatalf("expected last 2 (term 1), commit 2, got %+v", st)
+	}
+	if st.Elections != 1 || st.ElectionsWon != 1 {
+		t.Fatalf("expected 1 election, 1 won, got %d, %d", st.Elections, st.ElectionsWon)
+	}
+
+	lag := map[NodeID]int64{}
+	for _, p := range st.Peers {
+		lag[p.ID] = p.Lag
+	}
+	if len(lag) != 2 || lag["node-1"] != 0 || lag["node-3"] != 2 {
+		t.Fatalf("expected node-1 lag 0 and node-3 lag 2, got %v", lag)
+	}
+
+	// node-3 is back and catches up.
+	ft.nodes["node-3"] = node3
+	node2.leaderTick()
+	for _, p := range node2.Status().Peers {
+		if p.Lag != 0 || p.MatchIndex != 2 {
+			t.Fatalf("%s should have caught up, got %+v", p.ID, p)
+		}
+	}
+}
+
+func TestStatus_FollowerKnowsNoProgress(t *testing.T) {
+	node, _ := newTestNode("node-1")
+	node.HandleAppendEntries(AppendEntriesRequest{Term: 3, LeaderID: "node-2"})
+
+	st := node.Status()
+	if st.Role != Follower || st.LeaderID != "node-2" || st.CurrentTerm != 3 {
+		t.Fatalf("unexpected state: %+v", st.NodeState)
+	}
+	if st.Isolated {
+		t.Fatal("node-2 was just heard from")
+	}
+	if st.Elections != 0 {
+		t.Fatalf("a follower that never timed out started %d elections", st.Elections)
+	}
+	for _, p := range st.Peers {
+		if p.MatchIndex != 0 || p.NextIndex != 0 || p.Lag != 0 {
+			t.Fatalf("only the leader knows %s's progress, got %+v", p.ID, p)
+		}
+	}
+}
+
+func TestStatus_JSON(t *testing.T) {
+	node, _ := newTestNode("node-1")
+	node.StartElection()
+
+	data, err := json.Marshal(node.Status())
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	for _, want := range []string{`"id":"node-1"`, `"role":"candidate"`, `"current_term":1`, `"voted_for":"node-1"`, `"elections":1`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("missing %s in %s", want, data)
+		}
+	}
+}
+
+func TestStatus_Isolated(t *testing.T) {
+	clock := &simClock{now: time.Unix(1000, 0)}
+	ft := &fakeTransport{nodes: make(map[NodeID]*RaftNode)}
+	for _, p := range testPeers {
+		ft.nodes[p.ID] = newNode(Config{Self: p.ID, Peers: testPeers, Clock: clock}, ft, newMemoryStorage())
+	}
+	leader, follower := ft.nodes["node-1"], ft.nodes["node-2"]
+
+	voteReq := leader.StartElection()
+	votes := 1
+	for _, peer := range leader.config.OtherPeers() {
+		leader.CollectVote(ft.nodes[peer.ID].HandleRequestVote(voteReq), &votes)
+	}
+	leader.leaderTick()
+	if leader.Status().Isolated || follower.Status().Isolated {
+		t.Fatal("nobody is cut off yet")
+	}
+
+	// The leader is cut off: heartbeats go nowhere.
+	delete(ft.nodes, "node-2")
+	delete(ft.nodes, "node-3")
+	clock.now = clock.now.Add(leader.electionTimeoutMax + time.Millisecond)
+	leader.leaderTick()
+
+	if st := leader.Status(); !st.Isolated || st.Role != Leader {
+		t.Fatalf("a leader no majority answers should be isolated: %+v", st)
+	}
+	if st := follower.Status(); !st.Isolated || st.LeaderID != "node-1" {
+		t.Fatalf("a follower that hears nothing should be isolated: %+v", st)
+	}
+}
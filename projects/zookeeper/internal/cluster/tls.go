@@ -0,0 +1,128 @@
+package cluster
+
+// Who is on the other end of the Raft port?
+//
+// THE PROBLEM:
+//
+// Whoever can send AppendEntries can write to the log: a caller that
+// reaches the Raft port and says it's the leader of a new enough term
+// changes the tree, with no ACL in the way. A plaintext port also shows
+// every write to anyone on the network.
+//
+// THE FIX: MUTUAL TLS, NODES ONLY
+//
+// With TLS set, both ends of every Raft call show a certificate, and
+// each one has to be a NODE certificate of a member:
+//
+//   node-1 ──────── AppendEntries ────────→ node-2
+//          CN=node-1                 CN=node-2
+//          URI:zk-node:node-1        URI:zk-node:node-2
+//
+//   node-2 (server): the caller's certificate names a member? → go on
+//   node-1 (client): the server's certificate names node-2?   → go on
+//
+// A node certificate is one whose CN is the node id AND that carries the
+// URI SAN zk-node:<node id>. The CN alone isn't enough: the CA that signs
+// node certificates usually signs client certificates too, and a client
+// certificate that happens to say CN=node-2 mustn't make its owner a
+// node. The URI is something only a node's certificate is given on
+// purpose. internal/server asks the same question before it believes an
+// identity a follower forwards.
+//
+// Membership changes, so the server checks it on every call, not once
+// per connection.
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"slices"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// nodeURIScheme is the scheme of the URI SAN that marks a node
+// certificate: zk-node:<node id>.
+const nodeURIScheme = "zk-node"
+
+// NodeURI is the URI SAN a node's certificate carries, next to
+// CN=<id>: zk-node:<id>.
+func NodeURI(id NodeID) string {
+	return nodeURIScheme + ":" + string(id)
+}
+
+// NodeName is the node a certificate was issued to: its CN, if it also
+// carries NodeURI(CN). "" for any other certificate, nil included.
+func NodeName(cert *x509.Certificate) NodeID {
+	if cert == nil || cert.Subject.CommonName == "" {
+		return ""
+	}
+	id := NodeID(cert.Subject.CommonName)
+	for _, u := range cert.URIs {
+		if u.Scheme == nodeURIScheme && u.Opaque == string(id) {
+			return id
+		}
+	}
+	return ""
+}
+
+// PeerCertificate is the verified certificate the other end of ctx's
+// call presented, nil if there's none.
+func PeerCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return info.State.VerifiedChains[0][0]
+}
+
+// RaftServerOptions are the gRPC server options for a Raft port served
+// over cfg: a client certificate is required, and every call must come
+// from a member's node certificate (see NodeName). cfg's ClientCAs sign
+// the node certificates.
+func RaftServerOptions(cfg *tls.Config, node *RaftNode) []grpc.ServerOption {
+	cfg = cfg.Clone()
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return []grpc.ServerOption{
+		grpc.Creds(credentials.NewTLS(cfg)),
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			id := NodeName(PeerCertificate(ctx))
+			if !node.isMember(id) {
+				return nil, status.Errorf(codes.PermissionDenied, "%s: caller is not a member's node certificate", info.FullMethod)
+			}
+			return handler(ctx, req)
+		}),
+	}
+}
+
+// isMember reports whether id is a node of the cluster, learners
+// included.
+func (rn *RaftNode) isMember(id NodeID) bool {
+	if id == "" {
+		return false
+	}
+	return slices.ContainsFunc(rn.Members(), func(p Peer) bool { return p.ID == id })
+}
+
+// verifyNode is a tls.Config VerifyConnection that accepts only id's
+// node certificate. It runs after the usual chain and host name checks.
+func verifyNode(id NodeID) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return fmt.Errorf("%s presented no certificate", id)
+		}
+		if got := NodeName(cs.PeerCertificates[0]); got != id {
+			return fmt.Errorf("expected %s's node certificate, got CN=%q", id, cs.PeerCertificates[0].Subject.CommonName)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,146 @@
+package cluster
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/syamsularifin/zookeeper/api/proto/raftpb"
+)
+
+// testCA signs test certificates in memory.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue signs a certificate for 127.0.0.1 with CN=cn, usable by servers
+// and clients. node adds the node URI: a node certificate.
+func (ca *testCA) issue(t *testing.T, cn string, node bool) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if node {
+		u, _ := url.Parse(NodeURI(NodeID(cn)))
+		tmpl.URIs = []*url.URL{u}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// TestNodeName proves only a certificate with CN=<id> and the matching
+// node URI names a node.
+func TestNodeName(t *testing.T) {
+	node1, _ := url.Parse(NodeURI("node-1"))
+	node2, _ := url.Parse(NodeURI("node-2"))
+	other, _ := url.Parse("spiffe://example/node-1")
+
+	tests := []struct {
+		name string
+		cert *x509.Certificate
+		want NodeID
+	}{
+		{"node certificate", &x509.Certificate{Subject: pkix.Name{CommonName: "node-1"}, URIs: []*url.URL{other, node1}}, "node-1"},
+		{"CN only", &x509.Certificate{Subject: pkix.Name{CommonName: "node-1"}}, ""},
+		{"another node's URI", &x509.Certificate{Subject: pkix.Name{CommonName: "node-1"}, URIs: []*url.URL{node2}}, ""},
+		{"URI only", &x509.Certificate{URIs: []*url.URL{node1}}, ""},
+		{"no certificate", nil, ""},
+	}
+	for _, tt := range tests {
+		if got := NodeName(tt.cert); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+// TestGRPCTransport_TLSOnlyTalksToMembers serves the Raft port over
+// mutual TLS and proves it answers only a member's node certificate,
+// and that the dialing side only talks to the node it meant to reach.
+func TestGRPCTransport_TLSOnlyTalksToMembers(t *testing.T) {
+	ca := newTestCA(t)
+	addr := freeAddr(t)
+	peers := []Peer{{ID: "node-1", Addr: "unused"}, {ID: "node-2", Addr: addr}}
+
+	receiver := newNode(Config{Self: "node-2", Peers: peers}, &failingTransport{}, newMemoryStorage())
+	serverTLS := &tls.Config{Certificates: []tls.Certificate{ca.issue(t, "node-2", true)}, ClientCAs: ca.pool}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to listen on %s: %v", addr, err)
+	}
+	g := grpc.NewServer(RaftServerOptions(serverTLS, receiver)...)
+	raftpb.RegisterRaftServer(g, NewRaftServer(receiver))
+	go g.Serve(lis)
+	defer g.Stop()
+
+	// vote asks node-2 (as peer) for a vote, with cert as ours.
+	vote := func(cert tls.Certificate, peer Peer) error {
+		tr := NewGRPCTransport(time.Second)
+		defer tr.Close()
+		tr.SetTLS(&tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: ca.pool})
+		_, err := tr.SendRequestVote(peer, RequestVoteRequest{Term: 1, CandidateID: "node-1"})
+		return err
+	}
+
+	if err := vote(ca.issue(t, "node-1", true), peers[1]); err != nil {
+		t.Fatalf("a member's node certificate should get through: %v", err)
+	}
+	if err := vote(ca.issue(t, "node-1", false), peers[1]); err == nil {
+		t.Fatal("a client certificate saying CN=node-1 must be turned away")
+	}
+	if err := vote(ca.issue(t, "node-9", true), peers[1]); err == nil {
+		t.Fatal("a node that isn't a member must be turned away")
+	}
+	if err := vote(ca.issue(t, "node-1", true), Peer{ID: "node-3", Addr: addr}); err == nil {
+		t.Fatal("dialing node-3 must not talk to node-2's certificate")
+	}
+}
@@ -0,0 +1,182 @@
+package cluster
+
+// THE PROBLEM:
+//
+// To restart the leader for maintenance, just stopping it costs a
+// failover: the followers notice only after an election timeout, and
+// writes stall until a new leader is elected.
+//
+//   leader stops ──── 300-500ms, nobody leads ──── node-2 elected
+//
+// Starting the election early doesn't work either. Voters that heard
+// from the leader lately refuse to vote (HandleRequestVote, rule 1b),
+// because the leader's lease depends on it.
+//
+// THE FIX: LEADERSHIP TRANSFER (Raft thesis §3.10)
+//
+// The leader picks its successor and tells it when to start:
+//
+//   1. Stop taking writes. They wait (on proposeMu) until we're done.
+//   2. Catch the target up: replicate until its log is as long as ours,
+//      so it can win.
+//   3. Give up the lease — no more lease reads from here on — and send
+//      the target TimeoutNow.
+//   4. The target starts an election right away, with no pre-vote. Its
+//      RequestVote says Transfer, so voters skip rule 1b: the lease it
+//      protects is gone.
+//   5. We see the target's higher term and step down. The writes that
+//      waited in step 1 get a NotLeaderError, and the server forwards
+//      them to the new leader.
+//
+// If the target doesn't win within an election timeout, the transfer is
+// off. If we haven't seen a higher term by then, we're still leader, and
+// carry on.
+
+import (
+	"fmt"
+	"time"
+)
+
+// TransferLeadership hands leadership to target, a voter. An empty
+// target picks the voter that's furthest along. It returns once this
+// node has stepped down, or ErrTransferFailed if the target didn't take
+// over in time.
+//
+// Only the leader can transfer; anyone else returns a *NotLeaderError.
+func (rn *RaftNode) TransferLeadership(target NodeID) error {
+	// matchIndex only moves when a follower acks entries, so a new
+	// leader's says nothing until an entry of its term commits.
+	if err := rn.commitInTerm(); err != nil {
+		return err
+	}
+
+	// Step 1: no new writes.
+	rn.proposeMu.Lock()
+	defer rn.proposeMu.Unlock()
+
+	rn.mu.Lock()
+	if rn.state.Role != Leader {
+		err := rn.notLeaderError()
+		rn.mu.Unlock()
+		return err
+	}
+	if target == "" {
+		target = rn.transferCandidate()
+	}
+	peer, ok := rn.config.Peer(target)
+	rn.mu.Unlock()
+
+	switch {
+	case target == "":
+		return fmt.Errorf("%w: no other voter to hand over to", ErrTransferFailed)
+	case target == rn.config.Self:
+		return nil
+	case !ok:
+		return fmt.Errorf("%w: %s", ErrUnknownPeer, target)
+	case peer.Learner:
+		return fmt.Errorf("can't transfer leadership to %s: it's a learner", target)
+	}
+
+	// Step 2: catch the target up. No writes come in, so the end of our
+	// log stays put.
+	deadline := time.Now().Add(rn.electionTimeoutMax)
+	rn.mu.Lock()
+	last := rn.store.LastWALTxID()
+	rn.mu.Unlock()
+	for {
+		done, err := rn.replicatedTo(target, last)
+		if err != nil {
+			return err
+		}
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: %s didn't catch up", ErrTransferFailed, target)
+		}
+		rn.leaderTick()
+		if done, _ := rn.replicatedTo(target, last); !done {
+			time.Sleep(rn.heartbeatInterval)
+		}
+	}
+
+	// Step 3: no more lease reads, then tell the target to go.
+	rn.mu.Lock()
+	if rn.state.Role != Leader {
+		err := rn.notLeaderError()
+		rn.mu.Unlock()
+		return err
+	}
+	rn.transferTarget = target
+	term := rn.state.CurrentTerm
+	rn.mu.Unlock()
+	defer func() {
+		rn.mu.Lock()
+		rn.transferTarget = ""
+		rn.mu.Unlock()
+	}()
+
+	rn.logger.Info("transferring leadership",
+		"to", target,
+		"term", term,
+	)
+	resp, err := rn.transport.SendTimeoutNow(peer, TimeoutNowRequest{Term: term, LeaderID: rn.config.Self})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrTransferFailed, err)
+	}
+	if !resp.Success {
+		return fmt.Errorf("%w: %s refused (term %d)", ErrTransferFailed, target, resp.Term)
+	}
+
+	// Step 4-5: wait until we've stepped down and know who leads.
+	deadline = time.Now().Add(rn.electionTimeoutMax)
+	for {
+		rn.mu.Lock()
+		role, leader := rn.state.Role, rn.state.LeaderID
+		rn.mu.Unlock()
+
+		if role != Leader && (leader != "" || time.Now().After(deadline)) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: %s didn't take over", ErrTransferFailed, target)
+		}
+		time.Sleep(rn.heartbeatInterval)
+	}
+}
+
+// HandleTimeoutNow processes a TimeoutNow from the leader: start an
+// election right away, without a pre-vote. The election runs in the
+// background; Success only says it started.
+func (rn *RaftNode) HandleTimeoutNow(req TimeoutNowRequest) TimeoutNowResponse {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	if req.Term < rn.state.CurrentTerm || !rn.config.IsVoter(rn.config.Self) {
+		return TimeoutNowResponse{Term: rn.state.CurrentTerm}
+	}
+
+	rn.logger.Info("leader handing over, starting election",
+		"from", req.LeaderID,
+		"term", rn.state.CurrentTerm+1,
+	)
+	go rn.campaign(true)
+	return TimeoutNowResponse{Term: rn.state.CurrentTerm, Success: true}
+}
+
+// transferCandidate picks the voter whose log is furthest along: it
+// catches up soonest. Empty if there's no other voter.
+//
+// Must be called with rn.mu held.
+func (rn *RaftNode) transferCandidate() NodeID {
+	var best NodeID
+	for _, p := range rn.config.OtherPeers() {
+		if p.Learner {
+			continue
+		}
+		if best == "" || rn.matchIndex[p.ID] > rn.matchIndex[best] {
+			best = p.ID
+		}
+	}
+	return best
+}
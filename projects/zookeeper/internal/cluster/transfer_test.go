@@ -0,0 +1,111 @@
+package cluster
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestTransferLeadership_CatchesUpTarget proves the leader brings a
+// lagging target up to date, hands over, and steps down.
+func TestTransferLeadership_CatchesUpTarget(t *testing.T) {
+	nodes, stores := newTestCluster()
+	leader := electNode1(t, nodes)
+
+	heal := isolate(nodes, "node-3")
+	for _, path := range []string{"/a", "/b"} {
+		if _, err := leader.Propose("CREATE", path, nil); err != nil {
+			t.Fatalf("Propose failed: %v", err)
+		}
+	}
+	heal()
+
+	if err := leader.TransferLeadership("node-3"); err != nil {
+		t.Fatalf("TransferLeadership failed: %v", err)
+	}
+
+	if state := leader.GetState(); state.Role != Follower || state.LeaderID != "node-3" {
+		t.Fatalf("node-1 should follow node-3, got %+v", state)
+	}
+	if state := nodes["node-3"].GetState(); state.Role != Leader || state.CurrentTerm != 2 {
+		t.Fatalf("node-3 should lead in term 2, got %+v", state)
+	}
+	if got := stores["node-3"].LastWALTxID(); got != 2 {
+		t.Fatalf("node-3 should have both writes, got up to %d", got)
+	}
+
+	// Writes go to the new leader now.
+	if _, err := leader.Propose("CREATE", "/c", nil); err == nil {
+		t.Fatal("the old leader should refuse writes")
+	}
+	if _, err := nodes["node-3"].Propose("CREATE", "/c", nil); err != nil {
+		t.Fatalf("Propose on the new leader failed: %v", err)
+	}
+}
+
+// TestTransferLeadership_PicksTarget proves an empty target hands over
+// to another voter.
+func TestTransferLeadership_PicksTarget(t *testing.T) {
+	nodes, _ := newTestCluster()
+	leader := electNode1(t, nodes)
+
+	if err := leader.TransferLeadership(""); err != nil {
+		t.Fatalf("TransferLeadership failed: %v", err)
+	}
+	newLeader := leader.GetState().LeaderID
+	if newLeader == "" || newLeader == "node-1" || nodes[newLeader].GetState().Role != Leader {
+		t.Fatalf("expected another node to lead, node-1 says %q", newLeader)
+	}
+}
+
+// TestTransferLeadership_Rejects proves the checks before a transfer:
+// leader only, members only, voters only, and a target that answers.
+func TestTransferLeadership_Rejects(t *testing.T) {
+	nodes, _ := newTestCluster()
+	leader := electNode1(t, nodes)
+
+	var nle *NotLeaderError
+	if err := nodes["node-2"].TransferLeadership("node-3"); !errors.As(err, &nle) {
+		t.Fatalf("expected NotLeaderError from a follower, got %v", err)
+	}
+	if err := leader.TransferLeadership("node-9"); !errors.Is(err, ErrUnknownPeer) {
+		t.Fatalf("expected ErrUnknownPeer, got %v", err)
+	}
+
+	node4, _ := addTestNode(nodes, "node-4")
+	if err := leader.proposeMembers(node4.Members()); err != nil {
+		t.Fatalf("proposeMembers failed: %v", err)
+	}
+	if err := leader.TransferLeadership("node-4"); err == nil {
+		t.Fatal("should refuse to hand over to a learner")
+	}
+
+	isolate(nodes, "node-3")
+	if err := leader.TransferLeadership("node-3"); !errors.Is(err, ErrTransferFailed) {
+		t.Fatalf("expected ErrTransferFailed for an unreachable target, got %v", err)
+	}
+	if leader.GetState().Role != Leader {
+		t.Fatal("node-1 should still lead after a failed transfer")
+	}
+	leader.mu.Lock()
+	target := leader.transferTarget
+	leader.mu.Unlock()
+	if target != "" {
+		t.Fatalf("a failed transfer should give the lease back, still handing over to %s", target)
+	}
+}
+
+// TestRequestVote_TransferSkipsLeaderCheck proves a voter that just
+// heard from the leader still votes in an election the leader asked for.
+func TestRequestVote_TransferSkipsLeaderCheck(t *testing.T) {
+	node, _ := newTestNode("node-1")
+	node.HandleAppendEntries(AppendEntriesRequest{Term: 1, LeaderID: "node-3"})
+
+	resp := node.HandleRequestVote(RequestVoteRequest{Term: 2, CandidateID: "node-2"})
+	if resp.VoteGranted {
+		t.Fatal("should reject a plain vote while the leader is alive")
+	}
+	resp = node.HandleRequestVote(RequestVoteRequest{Term: 2, CandidateID: "node-2", Transfer: true})
+	if !resp.VoteGranted {
+		t.Fatal("should grant the vote when the leader handed over")
+	}
+}
@@ -19,4 +19,12 @@ type Transport interface {
 	// SendAppendEntries sends a heartbeat (or log entries) to a peer.
 	// Returns an error if the peer is unreachable.
 	SendAppendEntries(peer Peer, req AppendEntriesRequest) (AppendEntriesResponse, error)
+
+	// SendInstallSnapshot sends one chunk of a snapshot to a peer.
+	// Returns an error if the peer is unreachable.
+	SendInstallSnapshot(peer Peer, req InstallSnapshotRequest) (InstallSnapshotResponse, error)
+
+	// SendTimeoutNow tells a peer to start an election right away.
+	// Returns an error if the peer is unreachable.
+	SendTimeoutNow(peer Peer, req TimeoutNowRequest) (TimeoutNowResponse, error)
 }
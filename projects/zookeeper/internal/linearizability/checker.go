@@ -0,0 +1,423 @@
+// Package linearizability checks that a history of concurrent operations
+// could have come from a single copy of the data, one operation at a
+// time.
+//
+// THE PROBLEM:
+//
+// A cluster promises to behave like one znode tree: every operation
+// takes effect at one instant between its call and its return, and
+// every read sees every write that took effect before it. Under
+// partitions and leader changes that's the first thing to break — a
+// deposed leader answering a read from its own stale tree, a write
+// acknowledged and then lost. Checking a single client's results
+// doesn't find it; the bug is in how several clients' operations
+// overlap:
+//
+//	client 1  |--Set /k "a"--|
+//	client 2                    |--Get /k → "old"--|   ✗ Set returned first
+//	client 3        |--------Get /k → "a"--------|     ✓ overlaps the Set
+//
+// THE FIX: SEARCH FOR A LINEARIZATION
+//
+// A history is linearizable if there is an order of its operations that
+// (1) keeps every operation that returned before another one's call
+// ahead of it, and (2) makes sense to a sequential model: run in that
+// order on one tree, every operation gets the result it got. Check
+// looks for such an order, the way Porcupine and Knossos do (Wing &
+// Gong's search, with Lowe's cache):
+//
+//	events in time order:   call A, call B, return A, call C, return B, ...
+//
+//	take the first call whose result the model accepts in the current
+//	state, "linearize" it (drop its call and return from the list), start
+//	again from the front
+//	hit a return of an op not linearized yet → that op had to happen
+//	already: undo the last choice and try the next call instead
+//	nothing left to undo → no order works: NOT linearizable
+//
+// The search is exponential in the worst case. Two things keep it small:
+// a cache of (set of linearized ops, state) pairs already explored, and
+// partitioning — operations on different keys (znode paths) don't
+// constrain each other, so each key's history is checked on its own.
+//
+// An operation whose outcome is unknown (the request timed out, the
+// connection dropped) returns at Never: it may take effect any time
+// after its call, including never — it can always go last. So the
+// search is done once every operation that returned is linearized, and
+// the cache counts a pair as explored if it was, with a subset of the
+// same unknown ops: leaving more of them out can't close a way on.
+//
+// SHORT PREFIXES FIRST:
+//
+// Proving a history is NOT linearizable means trying every order, and
+// every unknown operation doubles the orders to try: it may have taken
+// effect at any point since its call. A few hundred of them, and the
+// search never ends. But a violation is a violation in any prefix that
+// contains it:
+//
+//	prefix = the first n ops by call time; whatever was still running
+//	         when op n+1 was called keeps its answer, but returns at
+//	         Never: it may take effect after the cut, or not at all
+//	prefix not linearizable → neither is the whole history
+//
+// So Check tries 32 ops, then a quarter more each time — 40, 50, 62,
+// ... — before the whole history. A violation early on is found in a
+// short prefix with few unknowns — quickly, and with a report small
+// enough to read. A linearizable history costs extra passes, each of
+// which finds its linearization on the first try, or nearly.
+//
+// And where nothing helps, CheckTimeout gives up on a key after a while
+// and says so (GaveUp), rather than running until the test times out.
+package linearizability
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"time"
+)
+
+// Never is the Return of an operation that didn't return. If it never
+// got an answer either, its Output must be one Step accepts in any
+// state (ResultUnknown, in the znode model).
+const Never = time.Duration(math.MaxInt64)
+
+// Operation is one request of a client: what it asked, what it got, and
+// when — times since the history started.
+type Operation[I, O any] struct {
+	Client int
+	Input  I
+	Output O
+	Call   time.Duration
+	Return time.Duration
+}
+
+// Model is the sequential specification a history is checked against.
+// S must be comparable: states are compared, and cached, with ==.
+type Model[S comparable, I, O any] interface {
+	// Init is the state before any operation.
+	Init() S
+
+	// Step reports whether an operation with input in can return out
+	// in state s, and the state after it.
+	Step(s S, in I, out O) (bool, S)
+
+	// Key partitions a history: operations with different keys are
+	// checked separately. Return "" if they can't be.
+	Key(in I) string
+
+	// Describe and DescribeState are for reports.
+	Describe(in I, out O) string
+	DescribeState(s S) string
+}
+
+// Result is the outcome of Check.
+type Result[S comparable, I, O any] struct {
+	OK bool
+
+	// GaveUp: not OK only because the search gave up on some keys —
+	// none of them is known not to be linearizable.
+	GaveUp bool
+
+	// Partitions are the histories of every key, in key order.
+	Partitions []Partition[S, I, O]
+}
+
+// Partition is the history of one key and what Check found in it.
+type Partition[S comparable, I, O any] struct {
+	Key string
+	Ops []Operation[I, O] // in call order
+	OK  bool
+
+	// Best is the longest order of Ops (indexes) the search found that
+	// the model accepts, States[i] the state after Best[i]. When OK,
+	// that's a linearization: every op that returned, and the unknown
+	// ones that had to take effect for it.
+	Best   []int
+	States []S
+
+	// Stuck is the op that returned before the search could place it,
+	// after Best: where the history stops making sense. -1 when OK.
+	Stuck int
+
+	// Cut is where the history was cut short, when the shortest
+	// prefix that isn't linearizable (Ops) ends before it does. 0 if
+	// Ops is all of it.
+	Cut time.Duration
+
+	// GaveUp: the search ran out of time before it could decide. Not
+	// OK, but not a violation either; Best is as far as it got, and
+	// Stuck is -1.
+	GaveUp bool
+}
+
+// Check reports whether ops is linearizable with respect to m.
+func Check[S comparable, I, O any](m Model[S, I, O], ops []Operation[I, O]) Result[S, I, O] {
+	return CheckTimeout(m, ops, 0)
+}
+
+// CheckTimeout is Check, giving up on a key after timeout (0: never).
+// The search is exponential in the number of operations that overlap;
+// a history with many unknown ones may not be decidable in any time
+// anyone would wait.
+func CheckTimeout[S comparable, I, O any](m Model[S, I, O], ops []Operation[I, O], timeout time.Duration) Result[S, I, O] {
+	byKey := make(map[string][]Operation[I, O])
+	for _, op := range ops {
+		k := m.Key(op.Input)
+		byKey[k] = append(byKey[k], op)
+	}
+	keys := make([]string, 0, len(byKey))
+	for k := range byKey {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	result := Result[S, I, O]{OK: true, GaveUp: true}
+	for _, k := range keys {
+		var deadline time.Time
+		if timeout > 0 {
+			deadline = time.Now().Add(timeout)
+		}
+		p := checkPartition(m, k, byKey[k], deadline)
+		result.OK = result.OK && p.OK
+		result.GaveUp = result.GaveUp && (p.OK || p.GaveUp)
+		result.Partitions = append(result.Partitions, p)
+	}
+	result.GaveUp = result.GaveUp && !result.OK
+	return result
+}
+
+// event is a call or a return in the doubly linked list the search
+// walks. A linearized op's two events are lifted out of the list, and
+// put back if the search backtracks.
+type event struct {
+	call       bool
+	op         int
+	at         time.Duration
+	match      *event // a call's return
+	prev, next *event
+}
+
+// lift takes a call and its return out of the list.
+func lift(e *event) {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	r := e.match
+	r.prev.next = r.next
+	if r.next != nil {
+		r.next.prev = r.prev
+	}
+}
+
+// unlift puts them back, undoing lift.
+func unlift(e *event) {
+	r := e.match
+	r.prev.next = r
+	if r.next != nil {
+		r.next.prev = r
+	}
+	e.prev.next = e
+	e.next.prev = e
+}
+
+// checkPartition checks one key's history, shortest prefixes first,
+// until deadline (zero: none).
+func checkPartition[S comparable, I, O any](m Model[S, I, O], key string, ops []Operation[I, O], deadline time.Time) Partition[S, I, O] {
+	sort.SliceStable(ops, func(i, j int) bool { return ops[i].Call < ops[j].Call })
+	for n := 32; n < len(ops); n += max(n/4, 1) {
+		cut := ops[n].Call
+		prefix := make([]Operation[I, O], n)
+		for i, op := range ops[:n] {
+			if op.Return > cut {
+				op.Return = Never
+			}
+			prefix[i] = op
+		}
+		if p := search(m, key, prefix, deadline); !p.OK {
+			p.Cut = cut
+			return p
+		}
+	}
+	return search(m, key, ops, deadline)
+}
+
+// search looks for a linearization of ops, sorted by call time.
+func search[S comparable, I, O any](m Model[S, I, O], key string, ops []Operation[I, O], deadline time.Time) Partition[S, I, O] {
+	p := Partition[S, I, O]{Key: key, Ops: ops, Stuck: -1}
+
+	// The event list: by time, calls before returns at the same time
+	// (touching operations count as concurrent).
+	events := make([]*event, 0, 2*len(ops))
+	for i, op := range ops {
+		c := &event{call: true, op: i, at: op.Call}
+		r := &event{op: i, at: op.Return}
+		c.match = r
+		events = append(events, c, r)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].at != events[j].at {
+			return events[i].at < events[j].at
+		}
+		return events[i].call && !events[j].call
+	})
+	head := &event{}
+	prev := head
+	for _, e := range events {
+		prev.next, e.prev = e, prev
+		prev = e
+	}
+
+	type choice struct {
+		call  *event
+		state S // before it
+	}
+	type explored struct {
+		answered string // which answered ops are linearized
+		state    S
+	}
+	var (
+		state   = m.Init()
+		done    = newBitset(len(ops)) // linearized ops that returned
+		maybe   = newBitset(len(ops)) // linearized ops that never did
+		cache   = make(map[explored][]bitset)
+		stack   []choice
+		pending int // ops that returned, not linearized yet
+	)
+	for _, op := range ops {
+		if op.Return != Never {
+			pending++
+		}
+	}
+	// linearized is the set an op belongs in when it's linearized.
+	linearized := func(i int) bitset {
+		if ops[i].Return == Never {
+			return maybe
+		}
+		return done
+	}
+	// visit reports whether the search is somewhere new, and records
+	// that it's been there. Unknown ops are optional — they can stay
+	// out for good — so if it was here before with the same answered
+	// ops and state and a subset of these unknown ones, it had every
+	// option it has now, and failed.
+	visit := func(s S) bool {
+		k := explored{done.key(), s}
+		for _, seen := range cache[k] {
+			if seen.subset(maybe) {
+				return false
+			}
+		}
+		cache[k] = append(cache[k], maybe.clone())
+		return true
+	}
+
+	// best remembers the deepest the search got, for the report.
+	best := func() {
+		if len(stack) <= len(p.Best) {
+			return
+		}
+		p.Best = p.Best[:0]
+		p.States = p.States[:0]
+		for i, c := range stack {
+			p.Best = append(p.Best, c.call.op)
+			if i+1 < len(stack) {
+				p.States = append(p.States, stack[i+1].state)
+			} else {
+				p.States = append(p.States, state)
+			}
+		}
+	}
+
+	e := head.next
+	for steps := 1; pending > 0; steps++ {
+		if steps%1024 == 0 && !deadline.IsZero() && time.Now().After(deadline) {
+			p.GaveUp = true
+			return p
+		}
+		if e.call {
+			op := ops[e.op]
+			if ok, next := m.Step(state, op.Input, op.Output); ok {
+				linearized(e.op).set(e.op)
+				if visit(next) {
+					stack = append(stack, choice{e, state})
+					state = next
+					lift(e)
+					if op.Return != Never {
+						pending--
+					}
+					best()
+					e = head.next
+					continue
+				}
+				linearized(e.op).clear(e.op)
+			}
+			e = e.next
+			continue
+		}
+
+		// A return: its op should have been linearized by now.
+		if len(stack) == 0 {
+			p.Stuck = stuck(ops, p.Best)
+			return p
+		}
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		state = c.state
+		linearized(c.call.op).clear(c.call.op)
+		unlift(c.call)
+		if ops[c.call.op].Return != Never {
+			pending++
+		}
+		e = c.call.next
+	}
+
+	p.OK = true
+	return p
+}
+
+// stuck picks the op a failed search couldn't get past: of those not in
+// best, the first to return.
+func stuck[I, O any](ops []Operation[I, O], best []int) int {
+	placed := make(map[int]bool, len(best))
+	for _, i := range best {
+		placed[i] = true
+	}
+	first := -1
+	for i, op := range ops {
+		if !placed[i] && (first == -1 || op.Return < ops[first].Return) {
+			first = i
+		}
+	}
+	return first
+}
+
+// bitset is a set of ops.
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (b bitset) set(i int)   { b[i/64] |= 1 << (i % 64) }
+func (b bitset) clear(i int) { b[i/64] &^= 1 << (i % 64) }
+
+func (b bitset) clone() bitset { return append(bitset(nil), b...) }
+
+// subset reports whether every element of b is in c.
+func (b bitset) subset(c bitset) bool {
+	for i, w := range b {
+		if w&^c[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// key is the set as a string, to be part of a map key.
+func (b bitset) key() string {
+	buf := make([]byte, 8*len(b))
+	for i, w := range b {
+		binary.LittleEndian.PutUint64(buf[8*i:], w)
+	}
+	return string(buf)
+}
@@ -0,0 +1,280 @@
+package linearizability
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"testing"
+	"time"
+)
+
+type znodeOp = Operation[ZnodeInput, ZnodeOutput]
+
+// op builds an operation from call to ret, in milliseconds. ret < 0
+// means it never returned.
+func op(client, call, ret int, in ZnodeInput, out ZnodeOutput) znodeOp {
+	r := time.Duration(ret) * time.Millisecond
+	if ret < 0 {
+		r = Never
+	}
+	return znodeOp{Client: client, Input: in, Output: out, Call: time.Duration(call) * time.Millisecond, Return: r}
+}
+
+func create(p, data string) ZnodeInput { return ZnodeInput{Op: ZnodeCreate, Path: p, Data: data} }
+func set(p, data string, v int32) ZnodeInput {
+	return ZnodeInput{Op: ZnodeSet, Path: p, Data: data, Version: v}
+}
+func get(p string) ZnodeInput { return ZnodeInput{Op: ZnodeGet, Path: p} }
+
+var (
+	ok      = ZnodeOutput{Result: ResultOK}
+	unknown = ZnodeOutput{Result: ResultUnknown}
+)
+
+func read(data string, v int32) ZnodeOutput {
+	return ZnodeOutput{Result: ResultOK, Data: data, Version: v}
+}
+
+func check(ops ...znodeOp) Result[ZnodeState, ZnodeInput, ZnodeOutput] {
+	return Check[ZnodeState, ZnodeInput, ZnodeOutput](ZnodeModel{}, ops)
+}
+
+func TestCheck_Sequential(t *testing.T) {
+	r := check(
+		op(0, 0, 10, create("/k", "a"), ok),
+		op(0, 20, 30, set("/k", "b", 0), ok),
+		op(0, 40, 50, set("/k", "c", 0), ZnodeOutput{Result: ResultBadVersion}),
+		op(0, 60, 70, get("/k"), read("b", 1)),
+		op(0, 80, 90, ZnodeInput{Op: ZnodeDelete, Path: "/k", Version: AnyVersion}, ok),
+		op(0, 100, 110, get("/k"), ZnodeOutput{Result: ResultNoNode}),
+	)
+	if !r.OK {
+		t.Fatalf("sequential history not linearizable:\n%s", Text(ZnodeModel{}, r))
+	}
+	if got := len(r.Partitions[0].Best); got != 6 {
+		t.Errorf("linearization has %d ops, expected 6", got)
+	}
+}
+
+// TestCheck_ConcurrentReadSeesEither: a read that overlaps a write may
+// see it or not.
+func TestCheck_ConcurrentReadSeesEither(t *testing.T) {
+	for _, seen := range []ZnodeOutput{read("a", 0), read("b", 1)} {
+		r := check(
+			op(0, 0, 10, create("/k", "a"), ok),
+			op(1, 20, 50, set("/k", "b", AnyVersion), ok),
+			op(2, 30, 40, get("/k"), seen),
+		)
+		if !r.OK {
+			t.Errorf("read of %q during the write should be fine:\n%s", seen.Data, Text(ZnodeModel{}, r))
+		}
+	}
+}
+
+// TestCheck_StaleRead: a read that starts after a write returned must
+// see it.
+func TestCheck_StaleRead(t *testing.T) {
+	r := check(
+		op(0, 0, 10, create("/k", "a"), ok),
+		op(1, 20, 30, set("/k", "b", AnyVersion), ok),
+		op(2, 40, 50, get("/k"), read("a", 0)),
+	)
+	if r.OK {
+		t.Fatal("stale read passed")
+	}
+	p := r.Partitions[0]
+	if p.Stuck != 2 || len(p.Best) != 2 {
+		t.Errorf("stuck on op %d after %v, expected op 2 after [0 1]", p.Stuck, p.Best)
+	}
+}
+
+// TestCheck_LostUpdate: two compare-and-sets on the same version can't
+// both win.
+func TestCheck_LostUpdate(t *testing.T) {
+	r := check(
+		op(0, 0, 10, create("/k", "a"), ok),
+		op(1, 20, 40, set("/k", "b", 0), ok),
+		op(2, 25, 45, set("/k", "c", 0), ok),
+	)
+	if r.OK {
+		t.Fatal("two winning compare-and-sets passed")
+	}
+}
+
+// TestCheck_Backtracks: the first order the search tries (earliest call
+// first) is wrong; it has to undo it.
+func TestCheck_Backtracks(t *testing.T) {
+	r := check(
+		op(0, 0, 10, create("/k", "a"), ok),
+		op(1, 20, 60, set("/k", "b", AnyVersion), ok),
+		op(2, 30, 70, set("/k", "c", AnyVersion), ok),
+		op(3, 80, 90, get("/k"), read("b", 2)),
+	)
+	if !r.OK {
+		t.Fatalf("set c, then set b is a linearization:\n%s", Text(ZnodeModel{}, r))
+	}
+	best := r.Partitions[0].Best
+	if best[1] != 2 || best[2] != 1 {
+		t.Errorf("linearization is %v, expected set c before set b", best)
+	}
+}
+
+// TestCheck_Unknown: a write with no answer may have happened, or not —
+// but not both.
+func TestCheck_Unknown(t *testing.T) {
+	base := []znodeOp{
+		op(0, 0, 10, create("/k", "a"), ok),
+		op(1, 20, -1, set("/k", "b", AnyVersion), unknown),
+	}
+	cases := []struct {
+		name  string
+		reads []znodeOp
+		ok    bool
+	}{
+		{"never seen", []znodeOp{op(2, 100, 110, get("/k"), read("a", 0))}, true},
+		{"seen", []znodeOp{op(2, 100, 110, get("/k"), read("b", 1))}, true},
+		{"seen, then gone", []znodeOp{
+			op(2, 100, 110, get("/k"), read("b", 1)),
+			op(2, 120, 130, get("/k"), read("a", 0)),
+		}, false},
+		{"seen before it was sent", []znodeOp{op(2, 12, 15, get("/k"), read("b", 1))}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if r := check(append(append([]znodeOp(nil), base...), c.reads...)...); r.OK != c.ok {
+				t.Errorf("linearizable: %v, expected %v\n%s", r.OK, c.ok, Text(ZnodeModel{}, r))
+			}
+		})
+	}
+}
+
+// TestCheck_PartitionsByPath: each path is checked on its own; only the
+// broken one fails.
+func TestCheck_PartitionsByPath(t *testing.T) {
+	r := check(
+		op(0, 0, 10, create("/a", "a"), ok),
+		op(1, 0, 10, create("/b", "b"), ok),
+		op(0, 20, 30, get("/a"), read("a", 0)),
+		op(1, 20, 30, get("/b"), ZnodeOutput{Result: ResultNoNode}),
+	)
+	if r.OK || len(r.Partitions) != 2 {
+		t.Fatalf("expected a failure in one of 2 partitions, got OK=%v with %d", r.OK, len(r.Partitions))
+	}
+	if !r.Partitions[0].OK || r.Partitions[1].OK {
+		t.Errorf("/a ok=%v, /b ok=%v: expected only /b to fail", r.Partitions[0].OK, r.Partitions[1].OK)
+	}
+}
+
+// randomHistory makes a linearizable history of n ops by running them
+// on the model in order, each at its own instant, and stretching each
+// into an interval around that instant.
+func randomHistory(rng *rand.Rand, n int) []znodeOp {
+	var ops []znodeOp
+	states := map[string]ZnodeState{}
+	paths := []string{"/a", "/b"}
+	for i := 0; i < n; i++ {
+		p := paths[rng.IntN(len(paths))]
+		var in ZnodeInput
+		switch rng.IntN(4) {
+		case 0:
+			in = create(p, fmt.Sprintf("v%d", i))
+		case 1:
+			in = set(p, fmt.Sprintf("v%d", i), int32(rng.IntN(3))-1)
+		case 2:
+			in = ZnodeInput{Op: ZnodeDelete, Path: p, Version: AnyVersion}
+		default:
+			in = get(p)
+		}
+		out, next := applyZnode(states[p], in)
+		states[p] = next
+
+		at := time.Duration(i) * time.Millisecond
+		o := znodeOp{
+			Client: rng.IntN(8),
+			Input:  in,
+			Output: out,
+			Call:   at - time.Duration(rng.IntN(5000))*time.Microsecond,
+			Return: at + time.Duration(rng.IntN(5000))*time.Microsecond,
+		}
+		if rng.IntN(20) == 0 && in.Op != ZnodeGet {
+			o.Output, o.Return = unknown, Never
+		}
+		ops = append(ops, o)
+	}
+	return ops
+}
+
+// TestCheck_RandomHistories checks histories that are linearizable by
+// construction, then breaks one read in each.
+func TestCheck_RandomHistories(t *testing.T) {
+	for seed := uint64(1); seed <= 20; seed++ {
+		rng := rand.New(rand.NewPCG(seed, 0))
+		ops := randomHistory(rng, 500)
+		if r := check(ops...); !r.OK {
+			t.Fatalf("seed %d: linearizable history failed:\n%s", seed, Text(ZnodeModel{}, r))
+		}
+
+		var reads []int
+		for i, o := range ops {
+			if o.Input.Op == ZnodeGet && o.Output.Result == ResultOK {
+				reads = append(reads, i)
+			}
+		}
+		if len(reads) == 0 {
+			continue
+		}
+		bad := reads[rng.IntN(len(reads))]
+		ops[bad].Output.Data = "never written"
+		if r := check(ops...); r.OK {
+			t.Fatalf("seed %d: a read of a value never written passed", seed)
+		}
+	}
+}
+
+// TestCheck_ShortPrefixFirst: a stale read early on, then hundreds of
+// writes that never returned. The whole history has more orders than
+// anyone could try; the shortest failing prefix has few.
+func TestCheck_ShortPrefixFirst(t *testing.T) {
+	ops := []znodeOp{
+		op(0, 0, 10, create("/k", "a"), ok),
+		op(0, 20, 30, set("/k", "b", AnyVersion), ok),
+		op(1, 40, 50, get("/k"), read("a", 0)),
+	}
+	for i := 0; i < 300; i++ {
+		ops = append(ops, op(2+i%8, 60+i, -1, set("/k", fmt.Sprintf("u%d", i), AnyVersion), unknown))
+	}
+
+	start := time.Now()
+	r := check(ops...)
+	if r.OK {
+		t.Fatal("stale read passed")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("check took %v", elapsed)
+	}
+	if p := r.Partitions[0]; p.Cut == 0 || len(p.Ops) >= len(ops) || p.Stuck != 2 {
+		t.Errorf("found in %d of %d ops (cut at %v), stuck on %d: expected a short prefix, stuck on 2", len(p.Ops), len(ops), p.Cut, p.Stuck)
+	}
+}
+
+// TestCheckTimeout_GivesUp: out of time, the search says it doesn't
+// know — not that the history is broken.
+func TestCheckTimeout_GivesUp(t *testing.T) {
+	ops := randomHistory(rand.New(rand.NewPCG(1, 0)), 2000)
+	r := CheckTimeout[ZnodeState](ZnodeModel{}, ops, time.Nanosecond)
+	if r.OK || !r.GaveUp {
+		t.Fatalf("OK=%v GaveUp=%v, expected to give up", r.OK, r.GaveUp)
+	}
+	for _, p := range r.Partitions {
+		if !p.OK && (!p.GaveUp || p.Stuck != -1) {
+			t.Errorf("%s: GaveUp=%v, stuck on %d: expected to give up, stuck on nothing", p.Key, p.GaveUp, p.Stuck)
+		}
+	}
+	if text := Text(ZnodeModel{}, r); !strings.Contains(text, "gave up, undecided") {
+		t.Errorf("report doesn't say it gave up:\n%s", text)
+	}
+
+	if r := CheckTimeout[ZnodeState](ZnodeModel{}, ops, time.Minute); !r.OK || r.GaveUp {
+		t.Errorf("with time enough: OK=%v GaveUp=%v", r.OK, r.GaveUp)
+	}
+}
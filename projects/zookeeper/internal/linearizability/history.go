@@ -0,0 +1,78 @@
+package linearizability
+
+import (
+	"sync"
+	"time"
+)
+
+// History records operations as concurrent clients make them. Safe for
+// concurrent use.
+//
+//	id := h.Call(client, input)     // just before sending the request
+//	out, err := send(input)
+//	h.Return(id, out)               // got an answer, success or failure
+//	h.Unknown(id, out)              // no idea whether it happened
+//	h.Discard(id)                   // a failed read: it tells nothing
+type History[I, O any] struct {
+	mu        sync.Mutex
+	start     time.Time
+	ops       []Operation[I, O]
+	discarded map[int]bool
+}
+
+// NewHistory starts an empty history. Times are measured from now.
+func NewHistory[I, O any]() *History[I, O] {
+	return &History[I, O]{start: time.Now(), discarded: make(map[int]bool)}
+}
+
+// Call records the start of an operation and returns its id. Until
+// Return is called its outcome is unknown.
+func (h *History[I, O]) Call(client int, in I) int {
+	now := time.Since(h.start)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ops = append(h.ops, Operation[I, O]{Client: client, Input: in, Call: now, Return: Never})
+	return len(h.ops) - 1
+}
+
+// Return records the answer to operation id.
+func (h *History[I, O]) Return(id int, out O) {
+	now := time.Since(h.start)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ops[id].Output = out
+	h.ops[id].Return = now
+}
+
+// Unknown records that operation id got no answer that says whether it
+// took effect — a timeout, a dropped connection. It may have, at any
+// time after its call, or never. out is what the model expects of an
+// operation like that.
+func (h *History[I, O]) Unknown(id int, out O) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ops[id].Output = out
+	h.ops[id].Return = Never
+}
+
+// Discard drops operation id from the history. Only for operations
+// that can't have changed anything, like a read that failed: leaving
+// them in as unknown would be correct, but makes the search slower.
+func (h *History[I, O]) Discard(id int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.discarded[id] = true
+}
+
+// Operations returns a copy of everything recorded so far.
+func (h *History[I, O]) Operations() []Operation[I, O] {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ops := make([]Operation[I, O], 0, len(h.ops)-len(h.discarded))
+	for i, op := range h.ops {
+		if !h.discarded[i] {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
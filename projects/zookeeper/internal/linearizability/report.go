@@ -0,0 +1,260 @@
+package linearizability
+
+// Reports: what Check found, for people.
+//
+// Text is for test logs: for every key that isn't linearizable, the
+// longest order the search could make sense of, and the operation it
+// got stuck on. A key the search gave up on gets one line: there's
+// nothing wrong to show.
+//
+// WriteHTML is a page to open in a browser, one timeline per key:
+//
+//   client 0  [1 create /k "a" → ok]        [4 get /k → "b" v1]
+//   client 1        [2 set /k "b" → ok]
+//   client 2              [✗ get /k → "a" v0]       ← stuck (red)
+//
+// A box spans its operation's call and return. Green boxes are the
+// longest linearizable order, numbered; the red one is where it stops;
+// grey ones come after. A box with a dashed border never returned. Hover
+// over a box to see the operation and the state after it.
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Text describes every key of r that isn't linearizable. Empty if r.OK.
+func Text[S comparable, I, O any](m Model[S, I, O], r Result[S, I, O]) string {
+	var b strings.Builder
+	for _, p := range r.Partitions {
+		if p.OK {
+			continue
+		}
+		if p.GaveUp {
+			fmt.Fprintf(&b, "%s: gave up, undecided (%d ops%s)\n", p.Key, len(p.Ops), cutNote(p.Cut))
+			continue
+		}
+		fmt.Fprintf(&b, "%s: not linearizable (%d ops%s). Longest linearizable order:\n", p.Key, len(p.Ops), cutNote(p.Cut))
+		for i, op := range p.Best {
+			fmt.Fprintf(&b, "  %3d. client %d: %s  ⇒ %s\n", i+1, p.Ops[op].Client, describe(m, p.Ops[op]), m.DescribeState(p.States[i]))
+		}
+		if p.Stuck >= 0 {
+			op := p.Ops[p.Stuck]
+			state := m.Init()
+			if len(p.States) > 0 {
+				state = p.States[len(p.States)-1]
+			}
+			fmt.Fprintf(&b, "  stuck: client %d: %s, returned at %v, but can't follow %s\n",
+				op.Client, describe(m, op), op.Return, m.DescribeState(state))
+		}
+	}
+	return b.String()
+}
+
+func cutNote(cut time.Duration) string {
+	if cut == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" called before %v; later ops left out, unfinished ones open-ended", cut)
+}
+
+// describe is an operation in a report, with when it happened.
+func describe[S comparable, I, O any](m Model[S, I, O], op Operation[I, O]) string {
+	return fmt.Sprintf("%s [%v, %s]", m.Describe(op.Input, op.Output), op.Call, returned(op.Return))
+}
+
+func returned(at time.Duration) string {
+	if at == Never {
+		return "never"
+	}
+	return at.String()
+}
+
+// WriteHTML writes r as a self-contained HTML page: keys that aren't
+// linearizable first, then the others, folded.
+func WriteHTML[S comparable, I, O any](w io.Writer, m Model[S, I, O], r Result[S, I, O]) error {
+	page := reportPage{OK: r.OK, GaveUp: r.GaveUp}
+	for _, p := range r.Partitions {
+		page.Keys = append(page.Keys, reportKey(m, p))
+	}
+	slices.SortStableFunc(page.Keys, func(a, b htmlKey) int {
+		switch {
+		case a.OK == b.OK:
+			return 0
+		case !a.OK:
+			return -1
+		}
+		return 1
+	})
+	return reportTemplate.Execute(w, page)
+}
+
+type reportPage struct {
+	OK     bool
+	GaveUp bool
+	Keys   []htmlKey
+}
+
+type htmlKey struct {
+	Key     string
+	OK      bool
+	GaveUp  bool
+	Ops     int
+	Clients []htmlClient
+	Order   []htmlStep
+	Stuck   *htmlStep
+	Cut     string
+	Start   string
+	End     string
+}
+
+type htmlClient struct {
+	ID  int
+	Ops []htmlOp
+}
+
+type htmlOp struct {
+	Label   string
+	Title   string
+	Class   string
+	Left    float64 // % of the timeline
+	Width   float64
+	Unknown bool
+}
+
+type htmlStep struct {
+	N      int
+	Client int
+	Op     string
+	State  string
+}
+
+// reportKey lays out one key's timeline.
+func reportKey[S comparable, I, O any](m Model[S, I, O], p Partition[S, I, O]) htmlKey {
+	k := htmlKey{Key: p.Key, OK: p.OK, GaveUp: p.GaveUp, Ops: len(p.Ops)}
+	if p.Cut > 0 {
+		k.Cut = p.Cut.String()
+	}
+
+	// The timeline runs from the first call to the last return, plus a
+	// bit for the ops that never returned.
+	start, end := time.Duration(0), time.Duration(0)
+	for i, op := range p.Ops {
+		if i == 0 || op.Call < start {
+			start = op.Call
+		}
+		end = max(end, op.Call)
+		if op.Return != Never {
+			end = max(end, op.Return)
+		}
+	}
+	span := max(end-start, 1) * 11 / 10
+	k.Start, k.End = start.String(), (start + span).String()
+	pos := func(t time.Duration) float64 {
+		if t == Never {
+			return 100
+		}
+		return 100 * float64(t-start) / float64(span)
+	}
+
+	order := make(map[int]int, len(p.Best))
+	after := make(map[int]S, len(p.Best))
+	for i, op := range p.Best {
+		order[op] = i + 1
+		after[op] = p.States[i]
+		k.Order = append(k.Order, htmlStep{N: i + 1, Client: p.Ops[op].Client, Op: describe(m, p.Ops[op]), State: m.DescribeState(p.States[i])})
+	}
+
+	clients := make(map[int]*htmlClient)
+	var ids []int
+	for i, op := range p.Ops {
+		c, ok := clients[op.Client]
+		if !ok {
+			c = &htmlClient{ID: op.Client}
+			clients[op.Client] = c
+			ids = append(ids, op.Client)
+		}
+		h := htmlOp{
+			Label:   m.Describe(op.Input, op.Output),
+			Title:   describe(m, op),
+			Class:   "later",
+			Left:    pos(op.Call),
+			Width:   max(pos(op.Return)-pos(op.Call), 0.4),
+			Unknown: op.Return == Never,
+		}
+		switch n, ok := order[i]; {
+		case ok:
+			h.Class = "linearized"
+			h.Label = fmt.Sprintf("%d  %s", n, h.Label)
+			h.Title += "\nstate after: " + m.DescribeState(after[i])
+		case i == p.Stuck:
+			h.Class = "stuck"
+			h.Label = "✗ " + h.Label
+			h.Title += "\ncan't be placed after the green ops"
+		}
+		c.Ops = append(c.Ops, h)
+	}
+	slices.Sort(ids)
+	for _, id := range ids {
+		k.Clients = append(k.Clients, *clients[id])
+	}
+
+	if p.Stuck >= 0 {
+		op := p.Ops[p.Stuck]
+		state := m.Init()
+		if len(p.States) > 0 {
+			state = p.States[len(p.States)-1]
+		}
+		k.Stuck = &htmlStep{Client: op.Client, Op: describe(m, op), State: m.DescribeState(state)}
+	}
+	return k
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Linearizability report</title>
+<style>
+body { font: 13px sans-serif; margin: 2em; }
+h1.ok { color: #2a7d2a; } h1.bad { color: #b22; }
+.timeline { position: relative; border-left: 1px solid #999; margin: 1em 0; }
+.client { position: relative; height: 26px; border-bottom: 1px dotted #ddd; }
+.client .name { position: absolute; left: -6em; width: 5.5em; text-align: right; top: 5px; color: #555; }
+.op { position: absolute; top: 3px; height: 18px; line-height: 18px; overflow: hidden; white-space: nowrap;
+      font: 11px monospace; padding: 0 3px; border: 1px solid #777; border-radius: 3px; box-sizing: border-box; }
+.op:hover { overflow: visible; z-index: 1; }
+.linearized { background: #c8ecc8; } .stuck { background: #f4b4b4; border-color: #b22; font-weight: bold; }
+.later { background: #e4e4e4; color: #666; } .unknown { border-style: dashed; }
+.wrap { margin-left: 6.5em; }
+table { border-collapse: collapse; } td, th { padding: 2px 8px; text-align: left; font-family: monospace; }
+tr.stuck td { background: #f4b4b4; }
+</style>
+</head>
+<body>
+{{if .OK}}<h1 class="ok">Linearizable</h1>{{else if .GaveUp}}<h1>Undecided</h1>{{else}}<h1 class="bad">Not linearizable</h1>{{end}}
+{{range .Keys}}
+<details{{if not .OK}} open{{end}}>
+<summary><b>{{.Key}}</b>: {{.Ops}} ops{{with .Cut}} called before {{.}} (a prefix: later ops left out, unfinished ones open-ended){{end}}, {{if .OK}}linearizable{{else if .GaveUp}}gave up, undecided{{else}}<span style="color:#b22">not linearizable</span>{{end}}</summary>
+<div class="wrap">
+<div class="timeline">
+{{range .Clients}}<div class="client"><span class="name">client {{.ID}}</span>
+{{range .Ops}}<div class="op {{.Class}}{{if .Unknown}} unknown{{end}}" style="left:{{printf "%.3f" .Left}}%;width:{{printf "%.3f" .Width}}%" title="{{.Title}}">{{.Label}}</div>
+{{end}}</div>
+{{end}}</div>
+<p>{{.Start}} … {{.End}}</p>
+<table>
+<tr><th>#</th><th>client</th><th>operation [call, return]</th><th>state after</th></tr>
+{{range .Order}}<tr><td>{{.N}}</td><td>{{.Client}}</td><td>{{.Op}}</td><td>{{.State}}</td></tr>
+{{end}}{{with .Stuck}}<tr class="stuck"><td>✗</td><td>{{.Client}}</td><td>{{.Op}}</td><td>can't follow {{.State}}</td></tr>{{end}}
+</table>
+</div>
+</details>
+{{end}}
+</body>
+</html>
+`))
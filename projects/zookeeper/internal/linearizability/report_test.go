@@ -0,0 +1,55 @@
+package linearizability
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// staleRead is the history of TestCheck_StaleRead.
+func staleRead() Result[ZnodeState, ZnodeInput, ZnodeOutput] {
+	return check(
+		op(0, 0, 10, create("/k", "a"), ok),
+		op(1, 20, 30, set("/k", "b", AnyVersion), ok),
+		op(2, 40, 50, get("/k"), read("a", 0)),
+	)
+}
+
+func TestText_ShowsOrderAndStuckOp(t *testing.T) {
+	text := Text(ZnodeModel{}, staleRead())
+	for _, want := range []string{
+		`/k: not linearizable (3 ops)`,
+		`1. client 0: create /k "a" → ok`,
+		`2. client 1: set /k "b" → ok`,
+		`⇒ "b" v1`,
+		`stuck: client 2: get /k → "a" v0`,
+		`can't follow "b" v1`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("report lacks %q:\n%s", want, text)
+		}
+	}
+
+	if text := Text(ZnodeModel{}, check(op(0, 0, 10, create("/k", "a"), ok))); text != "" {
+		t.Errorf("report of a linearizable history: %q, expected nothing", text)
+	}
+}
+
+func TestWriteHTML_MarksStuckOp(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteHTML(&buf, ZnodeModel{}, staleRead()); err != nil {
+		t.Fatalf("WriteHTML failed: %v", err)
+	}
+	html := buf.String()
+	for _, want := range []string{
+		"Not linearizable",
+		`class="op linearized"`,
+		`class="op stuck"`,
+		"✗ get /k → &#34;a&#34; v0", // escaped
+		"client 2",
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("page lacks %q", want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package linearizability
+
+// The znode model: what one znode tree, one operation at a time, answers
+// to Get, Create, Set and Delete.
+//
+// Each path is its own partition — a path's state is all an operation on
+// it depends on, as long as the history has no operations that span
+// paths (GetChildren, Multi, deleting a node with children).
+//
+//   state of /k       op                        answer
+//   (none)            create /k "a"             ok            → "a" v0
+//   "a" v0            set /k "b" version 0      ok            → "b" v1
+//   "b" v1            set /k "c" version 0      bad version
+//   "b" v1            get /k                    "b" v1
+//   "b" v1            delete /k any version     ok            → (none)
+
+import "fmt"
+
+// ZnodeOp is an operation of the znode model.
+type ZnodeOp string
+
+const (
+	ZnodeGet    ZnodeOp = "get"
+	ZnodeCreate ZnodeOp = "create"
+	ZnodeSet    ZnodeOp = "set"
+	ZnodeDelete ZnodeOp = "delete"
+)
+
+// AnyVersion is the Version of a Set or Delete that doesn't care which
+// version the node is at (znode.AnyVersion).
+const AnyVersion = -1
+
+// ZnodeInput is a request.
+type ZnodeInput struct {
+	Op      ZnodeOp
+	Path    string
+	Data    string // Create, Set
+	Version int32  // Set, Delete: the version expected, or AnyVersion
+}
+
+// ZnodeResult is how a request ended.
+type ZnodeResult string
+
+const (
+	ResultOK         ZnodeResult = "ok"
+	ResultNoNode     ZnodeResult = "no node"
+	ResultNodeExists ZnodeResult = "node exists"
+	ResultBadVersion ZnodeResult = "bad version"
+
+	// ResultUnknown: no answer, or one that doesn't say whether the
+	// request took effect (a timeout, no quorum). Anything goes.
+	ResultUnknown ZnodeResult = "unknown"
+)
+
+// ZnodeOutput is an answer.
+type ZnodeOutput struct {
+	Result  ZnodeResult
+	Data    string // Get
+	Version int32  // Get
+}
+
+// ZnodeState is one path: whether it exists, and its data and version.
+type ZnodeState struct {
+	Exists  bool
+	Data    string
+	Version int32
+}
+
+// ZnodeModel is the znode tree as a Model.
+type ZnodeModel struct{}
+
+func (ZnodeModel) Init() ZnodeState { return ZnodeState{} }
+
+func (ZnodeModel) Key(in ZnodeInput) string { return in.Path }
+
+func (ZnodeModel) Step(s ZnodeState, in ZnodeInput, out ZnodeOutput) (bool, ZnodeState) {
+	want, next := applyZnode(s, in)
+	switch {
+	case out.Result == ResultUnknown:
+		return true, next
+	case out.Result != want.Result:
+		return false, s
+	case in.Op == ZnodeGet && want.Result == ResultOK:
+		return out.Data == want.Data && out.Version == want.Version, next
+	}
+	return true, next
+}
+
+// applyZnode runs in on s: what it answers, and the state after.
+func applyZnode(s ZnodeState, in ZnodeInput) (ZnodeOutput, ZnodeState) {
+	if in.Op == ZnodeCreate {
+		if s.Exists {
+			return ZnodeOutput{Result: ResultNodeExists}, s
+		}
+		return ZnodeOutput{Result: ResultOK}, ZnodeState{Exists: true, Data: in.Data}
+	}
+
+	if !s.Exists {
+		return ZnodeOutput{Result: ResultNoNode}, s
+	}
+	switch in.Op {
+	case ZnodeGet:
+		return ZnodeOutput{Result: ResultOK, Data: s.Data, Version: s.Version}, s
+	case ZnodeSet:
+		if in.Version != AnyVersion && in.Version != s.Version {
+			return ZnodeOutput{Result: ResultBadVersion}, s
+		}
+		return ZnodeOutput{Result: ResultOK}, ZnodeState{Exists: true, Data: in.Data, Version: s.Version + 1}
+	case ZnodeDelete:
+		if in.Version != AnyVersion && in.Version != s.Version {
+			return ZnodeOutput{Result: ResultBadVersion}, s
+		}
+		return ZnodeOutput{Result: ResultOK}, ZnodeState{}
+	}
+	panic(fmt.Sprintf("unknown znode op %q", in.Op))
+}
+
+func (ZnodeModel) Describe(in ZnodeInput, out ZnodeOutput) string {
+	op := fmt.Sprintf("%s %s", in.Op, in.Path)
+	if in.Op == ZnodeCreate || in.Op == ZnodeSet {
+		op += fmt.Sprintf(" %q", in.Data)
+	}
+	if (in.Op == ZnodeSet || in.Op == ZnodeDelete) && in.Version != AnyVersion {
+		op += fmt.Sprintf(" v%d", in.Version)
+	}
+	if in.Op == ZnodeGet && out.Result == ResultOK {
+		return fmt.Sprintf("%s → %q v%d", op, out.Data, out.Version)
+	}
+	return fmt.Sprintf("%s → %s", op, out.Result)
+}
+
+func (ZnodeModel) DescribeState(s ZnodeState) string {
+	if !s.Exists {
+		return "(none)"
+	}
+	return fmt.Sprintf("%q v%d", s.Data, s.Version)
+}
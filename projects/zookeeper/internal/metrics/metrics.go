@@ -0,0 +1,404 @@
+// Package metrics keeps counters and latency histograms and writes them
+// in the Prometheus text format.
+//
+// THE PROBLEM:
+//
+// An operator looking at a slow cluster wants numbers: how long do
+// requests take, how long does an fsync take, how often are there
+// elections, how far behind is each follower. Prometheus scrapes them
+// over HTTP from every node, in a plain text format:
+//
+//	# HELP zk_wal_fsync_seconds Time to fsync the WAL.
+//	# TYPE zk_wal_fsync_seconds histogram
+//	zk_wal_fsync_seconds_bucket{le="0.001"} 812
+//	zk_wal_fsync_seconds_bucket{le="0.0025"} 990
+//	...
+//	zk_wal_fsync_seconds_bucket{le="+Inf"} 1000
+//	zk_wal_fsync_seconds_sum 0.974
+//	zk_wal_fsync_seconds_count 1000
+//
+// THE FIX:
+//
+// A Registry of metrics that can write that format. Two kinds:
+//
+//   - Kept: a Counter or Histogram, updated as things happen (an RPC
+//     returned, an fsync finished). Lock-free to update.
+//   - Collected: a function that reads the numbers when scraped (the
+//     term, the commit index, each peer's lag). Nothing to keep in sync
+//     with the state it reports: it IS the state, read at scrape time.
+//
+// Default is the registry for things there's one of per process, like
+// the WAL's fsyncs. Anything per node — a test runs several in one
+// process — gets a registry of its own, and the endpoint writes both.
+//
+// This is the small part of the Prometheus client library this project
+// needs, with no dependency: no gauges to set, no summaries, and label
+// values are whatever the caller says.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default is the registry for metrics there's one of per process.
+var Default = NewRegistry()
+
+// LatencyBuckets are histogram upper bounds, in seconds, for things
+// that take from a fraction of a millisecond (a local read) to seconds
+// (a write waiting out an election).
+var LatencyBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry is a set of metrics, written in the order they were added.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+// metric is anything a Registry can write.
+type metric interface {
+	name() string
+	write(w io.Writer) error
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) add(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[m.name()] {
+		panic(fmt.Sprintf("metrics: %s registered twice", m.name()))
+	}
+	r.names[m.name()] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes every metric of every registry in the text format.
+func Write(w io.Writer, regs ...*Registry) error {
+	for _, r := range regs {
+		r.mu.Lock()
+		ms := slices.Clone(r.metrics)
+		r.mu.Unlock()
+		for _, m := range ms {
+			if err := m.write(w); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Handler serves the registries to a Prometheus scrape.
+func Handler(regs ...*Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w, regs...)
+	})
+}
+
+// header writes a metric's HELP and TYPE lines.
+func header(w io.Writer, name, help, kind string) error {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	return err
+}
+
+// Label is one name="value" pair of a sample.
+type Label struct {
+	Name, Value string
+}
+
+// labelString is labels in the text format: {a="1",b="2"}, or "" for
+// none. extra comes last (a histogram's le).
+func labelString(labels []Label, extra ...Label) string {
+	all := append(slices.Clip(labels), extra...)
+	if len(all) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, l := range all {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l.Name)
+		b.WriteString(`="`)
+		b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(l.Value))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// zip pairs label names with values. A mismatch is a programming error.
+func zip(name string, names, values []string) []Label {
+	if len(names) != len(values) {
+		panic(fmt.Sprintf("metrics: %s has labels %v, got values %v", name, names, values))
+	}
+	labels := make([]Label, len(names))
+	for i := range names {
+		labels[i] = Label{names[i], values[i]}
+	}
+	return labels
+}
+
+// --- Counters ---
+
+// Counter is a number that only goes up: requests served, elections
+// started.
+type Counter struct {
+	n atomic.Uint64
+}
+
+func (c *Counter) Inc()          { c.n.Add(1) }
+func (c *Counter) Add(n uint64)  { c.n.Add(n) }
+func (c *Counter) Value() uint64 { return c.n.Load() }
+
+// CounterVec is a counter per combination of label values.
+type CounterVec struct {
+	metricName, help string
+	labels           []string
+
+	mu       sync.Mutex
+	counters map[string]*counterChild
+}
+
+type counterChild struct {
+	labels []Label
+	Counter
+}
+
+// NewCounter adds a counter without labels.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+// NewCounterVec adds a counter with the given label names.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{metricName: name, help: help, labels: labels, counters: make(map[string]*counterChild)}
+	r.add(v)
+	return v
+}
+
+// With returns the counter for these label values, one per name.
+func (v *CounterVec) With(values ...string) *Counter {
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, ok := v.counters[key]
+	if !ok {
+		c = &counterChild{labels: zip(v.metricName, v.labels, values)}
+		v.counters[key] = c
+	}
+	return &c.Counter
+}
+
+func (v *CounterVec) name() string { return v.metricName }
+
+func (v *CounterVec) write(w io.Writer) error {
+	if err := header(w, v.metricName, v.help, "counter"); err != nil {
+		return err
+	}
+	for _, c := range sortedChildren(&v.mu, v.counters) {
+		if _, err := fmt.Fprintf(w, "%s%s %d\n", v.metricName, labelString(c.labels), c.Value()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedChildren is a vec's children, by label values, so every scrape
+// lists them in the same order.
+func sortedChildren[C any](mu *sync.Mutex, m map[string]*C) []*C {
+	mu.Lock()
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]*C, len(keys))
+	for i, k := range keys {
+		out[i] = m[k]
+	}
+	mu.Unlock()
+	return out
+}
+
+// --- Histograms ---
+
+// Histogram counts observations (durations, in seconds) into buckets.
+type Histogram struct {
+	buckets []float64       // upper bounds, ascending
+	counts  []atomic.Uint64 // per bucket, not cumulative; the last is +Inf
+	sum     atomic.Uint64   // float64 bits
+	count   atomic.Uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{buckets: buckets, counts: make([]atomic.Uint64, len(buckets)+1)}
+}
+
+// Observe records one value.
+func (h *Histogram) Observe(v float64) {
+	h.counts[sort.SearchFloat64s(h.buckets, v)].Add(1)
+	for {
+		old := h.sum.Load()
+		if h.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			break
+		}
+	}
+	h.count.Add(1)
+}
+
+// ObserveSince records the time since start, in seconds.
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// Count is the number of observations.
+func (h *Histogram) Count() uint64 { return h.count.Load() }
+
+// HistogramVec is a histogram per combination of label values.
+type HistogramVec struct {
+	metricName, help string
+	labels           []string
+	buckets          []float64
+
+	mu         sync.Mutex
+	histograms map[string]*histogramChild
+}
+
+type histogramChild struct {
+	labels []Label
+	*Histogram
+}
+
+// NewHistogram adds a histogram without labels.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	return r.NewHistogramVec(name, help, buckets).With()
+}
+
+// NewHistogramVec adds a histogram with the given label names.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("metrics: %s buckets not sorted", name))
+	}
+	v := &HistogramVec{metricName: name, help: help, labels: labels, buckets: buckets, histograms: make(map[string]*histogramChild)}
+	r.add(v)
+	return v
+}
+
+// With returns the histogram for these label values, one per name.
+func (v *HistogramVec) With(values ...string) *Histogram {
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	h, ok := v.histograms[key]
+	if !ok {
+		h = &histogramChild{labels: zip(v.metricName, v.labels, values), Histogram: newHistogram(v.buckets)}
+		v.histograms[key] = h
+	}
+	return h.Histogram
+}
+
+func (v *HistogramVec) name() string { return v.metricName }
+
+func (v *HistogramVec) write(w io.Writer) error {
+	if err := header(w, v.metricName, v.help, "histogram"); err != nil {
+		return err
+	}
+	for _, h := range sortedChildren(&v.mu, v.histograms) {
+		// Read the count first: an Observe racing with the scrape may
+		// then show up in a bucket but not in _count, never the other
+		// way round.
+		count := h.count.Load()
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += h.counts[i].Load()
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", v.metricName, labelString(h.labels, Label{"le", formatFloat(le)}), cumulative); err != nil {
+				return err
+			}
+		}
+		cumulative += h.counts[len(h.buckets)].Load()
+		_, err := fmt.Fprintf(w, "%s_bucket%s %d\n%s_sum%s %s\n%s_count%s %d\n",
+			v.metricName, labelString(h.labels, Label{"le", "+Inf"}), max(cumulative, count),
+			v.metricName, labelString(h.labels), formatFloat(math.Float64frombits(h.sum.Load())),
+			v.metricName, labelString(h.labels), max(cumulative, count))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- Collected at scrape time ---
+
+// Sample is one value of a collected metric.
+type Sample struct {
+	Labels []Label
+	Value  float64
+}
+
+// collected is a metric whose samples a function reads when scraped.
+type collected struct {
+	metricName, help, kind string
+	collect                func() []Sample
+}
+
+// NewGaugeFunc adds a gauge — a number that goes up and down, like the
+// commit index or a peer's lag — read by collect on every scrape.
+func (r *Registry) NewGaugeFunc(name, help string, collect func() []Sample) {
+	r.add(&collected{name, help, "gauge", collect})
+}
+
+// NewCounterFunc adds a counter kept somewhere else, like the elections
+// a RaftNode has started, read by collect on every scrape.
+func (r *Registry) NewCounterFunc(name, help string, collect func() []Sample) {
+	r.add(&collected{name, help, "counter", collect})
+}
+
+// Value is a Sample without labels, for a collect function.
+func Value(v float64) []Sample {
+	return []Sample{{Value: v}}
+}
+
+func (c *collected) name() string { return c.metricName }
+
+func (c *collected) write(w io.Writer) error {
+	samples := c.collect()
+	if len(samples) == 0 {
+		return nil
+	}
+	if err := header(w, c.metricName, c.help, c.kind); err != nil {
+		return err
+	}
+	for _, s := range samples {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", c.metricName, labelString(s.Labels), formatFloat(s.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func write(t *testing.T, regs ...*Registry) string {
+	t.Helper()
+	var b strings.Builder
+	if err := Write(&b, regs...); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	return b.String()
+}
+
+func TestCounterVec(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("zk_things_total", "Things.")
+	v := r.NewCounterVec("zk_requests_total", "Requests served.", "method", "code")
+
+	c.Add(3)
+	v.With("Get", "OK").Inc()
+	v.With("Get", "OK").Inc()
+	v.With("Create", "AlreadyExists").Inc()
+
+	want := `# HELP zk_things_total Things.
+# TYPE zk_things_total counter
+zk_things_total 3
+# HELP zk_requests_total Requests served.
+# TYPE zk_requests_total counter
+zk_requests_total{method="Create",code="AlreadyExists"} 1
+zk_requests_total{method="Get",code="OK"} 2
+`
+	if got := write(t, r); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogram("zk_fsync_seconds", "Fsync time.", []float64{0.01, 0.1})
+
+	h.Observe(0.005)
+	h.Observe(0.01) // a bound is inclusive
+	h.Observe(0.05)
+	h.Observe(3)
+
+	want := `# HELP zk_fsync_seconds Fsync time.
+# TYPE zk_fsync_seconds histogram
+zk_fsync_seconds_bucket{le="0.01"} 2
+zk_fsync_seconds_bucket{le="0.1"} 3
+zk_fsync_seconds_bucket{le="+Inf"} 4
+zk_fsync_seconds_sum 3.065
+zk_fsync_seconds_count 4
+`
+	if got := write(t, r); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if h.Count() != 4 {
+		t.Errorf("Count = %d, want 4", h.Count())
+	}
+}
+
+func TestHistogram_Concurrent(t *testing.T) {
+	h := NewRegistry().NewHistogram("h", "h", LatencyBuckets)
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 1000 {
+				h.Observe(0.001)
+			}
+		}()
+	}
+	wg.Wait()
+	if h.Count() != 8000 {
+		t.Errorf("Count = %d, want 8000", h.Count())
+	}
+}
+
+func TestGaugeFunc(t *testing.T) {
+	r := NewRegistry()
+	lag := map[string]float64{"node-2": 0, "node-3": 12}
+	r.NewGaugeFunc("zk_lag", "Lag.", func() []Sample {
+		return []Sample{
+			{Labels: []Label{{"peer", "node-2"}}, Value: lag["node-2"]},
+			{Labels: []Label{{"peer", "node-3"}}, Value: lag["node-3"]},
+		}
+	})
+	// No samples: not written at all, HELP and TYPE included.
+	r.NewGaugeFunc("zk_empty", "Empty.", func() []Sample { return nil })
+	r.NewCounterFunc("zk_elections_total", "Elections.", func() []Sample { return Value(2) })
+
+	want := `# HELP zk_lag Lag.
+# TYPE zk_lag gauge
+zk_lag{peer="node-2"} 0
+zk_lag{peer="node-3"} 12
+# HELP zk_elections_total Elections.
+# TYPE zk_elections_total counter
+zk_elections_total 2
+`
+	if got := write(t, r); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Read at scrape time, not at registration.
+	lag["node-3"] = 0
+	if got := write(t, r); !strings.Contains(got, `zk_lag{peer="node-3"} 0`) {
+		t.Errorf("lag not re-read:\n%s", got)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("c", "Line one\nline two.", "path").With(`/a"b\c`).Inc()
+	got := write(t, r)
+	for _, want := range []string{
+		`# HELP c Line one\nline two.`,
+		`c{path="/a\"b\\c"} 1`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("c", "c")
+	defer func() {
+		if recover() == nil {
+			t.Error("registering c twice didn't panic")
+		}
+	}()
+	r.NewCounter("c", "c")
+}
+
+func TestHandler(t *testing.T) {
+	a, b := NewRegistry(), NewRegistry()
+	a.NewCounter("a_total", "A.").Inc()
+	b.NewCounter("b_total", "B.").Inc()
+
+	rec := httptest.NewRecorder()
+	Handler(a, b).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Content-Type = %q", rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(string(body), "a_total 1") || !strings.Contains(string(body), "b_total 1") {
+		t.Errorf("body missing a registry:\n%s", body)
+	}
+}
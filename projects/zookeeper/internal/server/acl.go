@@ -0,0 +1,198 @@
+package server
+
+// ACL checks: may this caller do this?
+//
+// Every call is checked against a znode's ACL (see package acl) before
+// anything happens, with the identities auth.go works out:
+//
+//   Create        CREATE on the parent     Get, GetChildren   READ
+//   Delete        DELETE on the parent     Watch              READ
+//   Set           WRITE                    GetACL             READ or ADMIN
+//   Multi         each op as if alone      SetACL             ADMIN
+//                 (Check needs READ)
+//   AddPeer, RemovePeer, TransferLeadership   ADMIN on "/"
+//
+//   deny → PERMISSION_DENIED, nothing logged
+//
+// A node that doesn't exist isn't checked: the operation fails on its
+// own (NotFound), the same as without ACLs.
+//
+// WHERE THE CHECK RUNS:
+//
+// Against the ACL this server has applied. A write is checked before
+// it's proposed, and a follower forwarding it makes the leader check it
+// again: every write passes the leader's check. A read is checked where
+// it's answered. So a follower that's behind can deny what the leader
+// would allow (a grant it hasn't applied yet), and a LOCAL read can be
+// allowed by an ACL the leader already changed — as stale as the data.
+//
+// Like ZooKeeper, the check and the write aren't one step: an ACL
+// change committed in between doesn't stop a write already checked.
+
+import (
+	"context"
+	"path"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+	"github.com/syamsularifin/zookeeper/internal/acl"
+	"github.com/syamsularifin/zookeeper/internal/wal"
+)
+
+// checkACL returns PermissionDenied unless the caller has perm on the
+// node at p. A node that doesn't exist passes.
+func (s *Server) checkACL(ctx context.Context, p string, perm acl.Perm) error {
+	c, err := s.caller(ctx)
+	if err != nil {
+		return err
+	}
+	acls, _, err := s.store.GetACL(p)
+	if err != nil {
+		return nil
+	}
+	if !c.can(acls, perm) {
+		return denied(p, perm)
+	}
+	return nil
+}
+
+// checkMulti checks each op of a Multi as if it were sent alone. Nodes
+// created earlier in the same Multi are checked with the ACL they're
+// created with.
+func (s *Server) checkMulti(ctx context.Context, ops []wal.Op) error {
+	c, err := s.caller(ctx)
+	if err != nil {
+		return err
+	}
+
+	created := make(map[string][]acl.ACL)
+	aclOf := func(p string) ([]acl.ACL, bool) {
+		if acls, ok := created[p]; ok {
+			return acls, true
+		}
+		acls, _, err := s.store.GetACL(p)
+		return acls, err == nil
+	}
+
+	for _, op := range ops {
+		target, perm := op.Path, acl.Read
+		switch op.Op {
+		case wal.OpCreate:
+			target, perm = path.Dir(op.Path), acl.Create
+		case wal.OpSet:
+			perm = acl.Write
+		case wal.OpDelete:
+			target, perm = path.Dir(op.Path), acl.Delete
+		}
+		if acls, ok := aclOf(target); ok && !c.can(acls, perm) {
+			return denied(target, perm)
+		}
+		if op.Op == wal.OpCreate && !op.Sequential {
+			created[op.Path] = op.ACL
+			if len(op.ACL) == 0 {
+				created[op.Path] = acl.Open
+			}
+		}
+	}
+	return nil
+}
+
+// checkAdmin is the check for operating the cluster: ADMIN on "/".
+func (s *Server) checkAdmin(ctx context.Context) error {
+	return s.checkACL(ctx, "/", acl.Admin)
+}
+
+// denied is the error for a failed check.
+func denied(p string, perm acl.Perm) error {
+	return status.Errorf(codes.PermissionDenied, "permission denied: no %s permission on %q", permNames[perm], p)
+}
+
+// permNames names each permission in errors.
+var permNames = map[acl.Perm]string{
+	acl.Read:   "read",
+	acl.Write:  "write",
+	acl.Create: "create",
+	acl.Delete: "delete",
+	acl.Admin:  "admin",
+}
+
+// GetACL returns a node's ACL and Stat.
+func (s *Server) GetACL(ctx context.Context, req *zkpb.GetACLRequest) (*zkpb.GetACLResponse, error) {
+	leader, fctx, err := s.prepareRead(ctx, req.Consistency)
+	if err != nil {
+		return nil, err
+	}
+	if leader != nil {
+		return leader.GetACL(fctx, req)
+	}
+
+	acls, stat, err := s.store.GetACL(req.Path)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "%v", err)
+	}
+	c, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !c.can(acls, acl.Read) && !c.can(acls, acl.Admin) {
+		return nil, denied(req.Path, acl.Read)
+	}
+	return &zkpb.GetACLResponse{Acl: aclToProto(acls), Stat: statToProto(stat)}, nil
+}
+
+// SetACL replaces a node's ACL, if it's at the expected aversion.
+func (s *Server) SetACL(ctx context.Context, req *zkpb.SetACLRequest) (*zkpb.SetACLResponse, error) {
+	acls, err := aclFromProto(req.Acl)
+	if err != nil {
+		return nil, err
+	}
+	if len(acls) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "an ACL needs at least one entry")
+	}
+	if err := s.checkACL(ctx, req.Path, acl.Admin); err != nil {
+		return nil, err
+	}
+
+	if s.raft != nil {
+		entry := wal.Entry{Op: wal.OpSetACL, Path: req.Path, ACL: acls, Version: versionOf(req.Version)}
+		if err := s.proposeEntry(entry); err != nil {
+			if leader, fctx, ok := s.leaderFor(ctx, err); ok {
+				return leader.SetACL(fctx, req)
+			}
+			return nil, clusterError(err, codes.NotFound)
+		}
+		return &zkpb.SetACLResponse{}, nil
+	}
+
+	if err := s.store.SetACL(req.Path, acls, versionOf(req.Version)); err != nil {
+		return nil, opError(err, codes.NotFound)
+	}
+	return &zkpb.SetACLResponse{}, nil
+}
+
+// aclFromProto converts a request's ACL, checking it's well-formed.
+// Empty stays nil.
+func aclFromProto(in []*zkpb.ACL) ([]acl.ACL, error) {
+	if len(in) == 0 {
+		return nil, nil
+	}
+	out := make([]acl.ACL, 0, len(in))
+	for _, a := range in {
+		out = append(out, acl.ACL{Perms: acl.Perm(a.Perms), ID: acl.ID{Scheme: a.Scheme, ID: a.Id}})
+	}
+	if err := acl.Validate(out); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	return out, nil
+}
+
+// aclToProto converts an ACL into its wire form.
+func aclToProto(in []acl.ACL) []*zkpb.ACL {
+	out := make([]*zkpb.ACL, 0, len(in))
+	for _, a := range in {
+		out = append(out, &zkpb.ACL{Scheme: a.Scheme, Id: a.ID.ID, Perms: int32(a.Perms)})
+	}
+	return out
+}
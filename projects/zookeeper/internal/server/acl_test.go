@@ -0,0 +1,396 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+	"github.com/syamsularifin/zookeeper/internal/acl"
+	"github.com/syamsularifin/zookeeper/internal/cluster"
+)
+
+// asDigest is ctx as the server sees a call carrying digest credentials.
+func asDigest(credentials ...string) context.Context {
+	md := metadata.MD{}
+	for _, c := range credentials {
+		md.Append(DigestKey, c)
+	}
+	return metadata.NewIncomingContext(context.Background(), md)
+}
+
+// aclOf builds a wire ACL from its text form.
+func aclOf(t *testing.T, s string) []*zkpb.ACL {
+	t.Helper()
+	acls, err := acl.ParseList(s)
+	if err != nil {
+		t.Fatalf("bad ACL %q: %v", s, err)
+	}
+	return aclToProto(acls)
+}
+
+var aliceACL = "world:anyone:r," + acl.Digest("alice", "secret").String() + ":cdrwa"
+
+// TestACL_DigestProtectsRoot locks "/" down to alice and proves only
+// she (or the superuser) can change anything under it.
+func TestACL_DigestProtectsRoot(t *testing.T) {
+	srv := newStandalone(t)
+	srv.SetSecurity(Security{SuperDigest: acl.Digest("super", "pw").ID})
+	anon, alice := context.Background(), asDigest("alice:secret")
+
+	if _, err := srv.SetACL(anon, &zkpb.SetACLRequest{Path: "/", Acl: aclOf(t, aliceACL)}); err != nil {
+		t.Fatalf("SetACL on the open root failed: %v", err)
+	}
+
+	_, err := srv.Create(anon, &zkpb.CreateRequest{Path: "/app"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for anyone, got %v", err)
+	}
+	_, err = srv.Create(asDigest("alice:wrong"), &zkpb.CreateRequest{Path: "/app"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for the wrong password, got %v", err)
+	}
+	_, err = srv.Create(asDigest("alice"), &zkpb.CreateRequest{Path: "/app"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated for malformed credentials, got %v", err)
+	}
+	if _, err := srv.Create(alice, &zkpb.CreateRequest{Path: "/app"}); err != nil {
+		t.Fatalf("Create as alice failed: %v", err)
+	}
+	if _, err := srv.Create(asDigest("super:pw"), &zkpb.CreateRequest{Path: "/other"}); err != nil {
+		t.Fatalf("Create as the superuser failed: %v", err)
+	}
+
+	// world:anyone:r still lets anyone read the ACL, not change it.
+	resp, err := srv.GetACL(anon, &zkpb.GetACLRequest{Path: "/"})
+	if err != nil || len(resp.Acl) != 2 || resp.Stat.Aversion != 1 {
+		t.Fatalf("expected the 2-entry ACL at aversion 1, got %v (%v)", resp, err)
+	}
+	_, err = srv.SetACL(anon, &zkpb.SetACLRequest{Path: "/", Acl: aclOf(t, "world:anyone:cdrwa")})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for SetACL, got %v", err)
+	}
+
+	stale := int32(0)
+	_, err = srv.SetACL(alice, &zkpb.SetACLRequest{Path: "/", Acl: aclOf(t, "world:anyone:cdrwa"), Version: &stale})
+	if status.Code(err) != codes.Aborted {
+		t.Fatalf("expected Aborted for a stale aversion, got %v", err)
+	}
+	_, err = srv.SetACL(alice, &zkpb.SetACLRequest{Path: "/", Acl: []*zkpb.ACL{{Scheme: "ip", Id: "10.0.0.1", Perms: 1}}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for an unknown scheme, got %v", err)
+	}
+
+	who, err := srv.Authenticate(anon, &zkpb.AuthenticateRequest{Scheme: "digest", Credentials: "alice:secret"})
+	if err != nil || len(who.Identities) != 2 || who.Identities[1] != acl.Digest("alice", "secret").String() {
+		t.Fatalf("expected world:anyone and alice's digest, got %v (%v)", who, err)
+	}
+}
+
+// TestACL_NodeACLs proves a node's own ACL is what's checked: reading
+// and writing it, deleting under it, watching it.
+func TestACL_NodeACLs(t *testing.T) {
+	srv := newStandalone(t)
+	anon, alice := context.Background(), asDigest("alice:secret")
+	private := acl.Digest("alice", "secret").String() + ":cdrwa"
+
+	if _, err := srv.Create(anon, &zkpb.CreateRequest{Path: "/secret", Acl: aclOf(t, private)}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	srv.Create(alice, &zkpb.CreateRequest{Path: "/secret/key"})
+
+	checks := []struct {
+		name string
+		err  error
+	}{
+		{"Get", second(srv.Get(anon, &zkpb.GetRequest{Path: "/secret"}))},
+		{"GetChildren", second(srv.GetChildren(anon, &zkpb.GetChildrenRequest{Path: "/secret"}))},
+		{"Set", second(srv.Set(anon, &zkpb.SetRequest{Path: "/secret", Data: []byte("x")}))},
+		{"Delete child", second(srv.Delete(anon, &zkpb.DeleteRequest{Path: "/secret/key"}))},
+		{"GetACL", second(srv.GetACL(anon, &zkpb.GetACLRequest{Path: "/secret"}))},
+	}
+	for _, c := range checks {
+		if status.Code(c.err) != codes.PermissionDenied {
+			t.Fatalf("%s: expected PermissionDenied, got %v", c.name, c.err)
+		}
+	}
+	if _, err := srv.Get(alice, &zkpb.GetRequest{Path: "/secret"}); err != nil {
+		t.Fatalf("Get as alice failed: %v", err)
+	}
+
+	// A missing node isn't checked: it's just missing.
+	_, err := srv.Get(anon, &zkpb.GetRequest{Path: "/secret/missing"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+
+	zk := dial(t, serve(t, srv))
+	stream, err := zk.Watch(context.Background(), &zkpb.WatchRequest{Path: "/secret"})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for Watch, got %v", err)
+	}
+}
+
+// TestACL_MultiChecksEachOp proves every op of a Multi is checked,
+// including against nodes created earlier in the same Multi.
+func TestACL_MultiChecksEachOp(t *testing.T) {
+	srv := newStandalone(t)
+	anon := context.Background()
+	readOnly := aclOf(t, "world:anyone:r")
+
+	_, err := srv.Multi(anon, &zkpb.MultiRequest{Ops: []*zkpb.Op{
+		{Op: &zkpb.Op_Create{Create: &zkpb.CreateRequest{Path: "/ro", Acl: readOnly}}},
+		{Op: &zkpb.Op_Create{Create: &zkpb.CreateRequest{Path: "/ro/child"}}},
+	}})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied creating under a read-only node, got %v", err)
+	}
+	if _, err := srv.store.Get("/ro"); err == nil {
+		t.Fatal("/ro should not exist: the multi was denied")
+	}
+
+	_, err = srv.Multi(anon, &zkpb.MultiRequest{Ops: []*zkpb.Op{
+		{Op: &zkpb.Op_Create{Create: &zkpb.CreateRequest{Path: "/ro", Acl: readOnly}}},
+		{Op: &zkpb.Op_Check{Check: &zkpb.CheckRequest{Path: "/ro"}}},
+	}})
+	if err != nil {
+		t.Fatalf("Multi failed: %v", err)
+	}
+	got, _, _ := srv.store.GetACL("/ro")
+	if len(got) != 1 || got[0].Perms != acl.Read {
+		t.Fatalf("expected /ro to be read-only, got %v", got)
+	}
+}
+
+// TestACL_X509ClientCertificate serves over mTLS and proves a client
+// certificate's common name is the caller's x509 identity.
+func TestACL_X509ClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	ca.issue(t, "server", true)
+	ca.issue(t, "billing", false)
+
+	serverTLS, err := LoadServerTLS(ca.file("server.crt"), ca.file("server.key"), ca.file("ca.crt"))
+	if err != nil {
+		t.Fatalf("LoadServerTLS failed: %v", err)
+	}
+	srv := newStandalone(t)
+	srv.SetSecurity(Security{TLS: serverTLS})
+	addr := serve(t, srv)
+
+	billing := dialTLS(t, addr, ca, "billing")
+	anon := dialTLS(t, addr, ca, "")
+	ctx := context.Background()
+
+	_, err = billing.Create(ctx, &zkpb.CreateRequest{Path: "/billing", Acl: aclOf(t, "world:anyone:r,x509:billing:cdrwa")})
+	if err != nil {
+		t.Fatalf("Create over mTLS failed: %v", err)
+	}
+	if _, err := billing.Set(ctx, &zkpb.SetRequest{Path: "/billing", Data: []byte("x")}); err != nil {
+		t.Fatalf("Set with the billing certificate failed: %v", err)
+	}
+	_, err = anon.Set(ctx, &zkpb.SetRequest{Path: "/billing", Data: []byte("y")})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied without the certificate, got %v", err)
+	}
+}
+
+// TestCluster_FollowerForwardsIdentity proves a write forwarded to the
+// leader is checked as the client that sent it.
+func TestCluster_FollowerForwardsIdentity(t *testing.T) {
+	nodes := newTestCluster(t)
+	leader := waitForLeader(t, nodes)
+	follower := followers(nodes, leader)[0]
+
+	_, err := leader.server.SetACL(context.Background(), &zkpb.SetACLRequest{Path: "/", Acl: aclOf(t, aliceACL)})
+	if err != nil {
+		t.Fatalf("SetACL failed: %v", err)
+	}
+	waitFor(t, func() bool {
+		_, st, _ := follower.store.GetACL("/")
+		return st.Aversion == 1
+	})
+
+	// Checked on the follower, then on the leader: both must allow it.
+	if _, err := follower.server.Create(asDigest("alice:secret"), &zkpb.CreateRequest{Path: "/app"}); err != nil {
+		t.Fatalf("Create as alice through the follower failed: %v", err)
+	}
+
+	// The leader doesn't trust the follower's check: a forwarded call
+	// without alice's credentials is denied there.
+	fwd := metadata.NewIncomingContext(context.Background(), metadata.Pairs(forwardedKey, string(follower.id)))
+	_, err = leader.server.Create(fwd, &zkpb.CreateRequest{Path: "/other"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied on the leader, got %v", err)
+	}
+}
+
+// TestCluster_ForwardedX509NeedsNodeCertificate proves the leader
+// believes a forwarded x509 identity only from a member's node
+// certificate: CN=<node id> alone, as any client certificate could say,
+// isn't enough.
+func TestCluster_ForwardedX509NeedsNodeCertificate(t *testing.T) {
+	nodes := newTestCluster(t)
+	leader := waitForLeader(t, nodes)
+	follower := followers(nodes, leader)[0]
+
+	// forwarded is a call forwarded for billing, by a caller with cert.
+	forwarded := func(cert *x509.Certificate) context.Context {
+		ctx := metadata.NewIncomingContext(context.Background(),
+			metadata.Pairs(forwardedKey, string(follower.id), x509Key, "billing"))
+		info := credentials.TLSInfo{State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}}
+		return peer.NewContext(ctx, &peer.Peer{AuthInfo: info})
+	}
+	billing := acl.ID{Scheme: acl.SchemeX509, ID: "billing"}
+
+	clientCert := &x509.Certificate{Subject: pkix.Name{CommonName: string(follower.id)}}
+	c, err := leader.server.caller(forwarded(clientCert))
+	if err != nil {
+		t.Fatalf("caller failed: %v", err)
+	}
+	if slices.Contains(c.ids, billing) {
+		t.Fatalf("a CN=%s client certificate must not forward identities, got %v", follower.id, c.ids)
+	}
+
+	nodeURI, _ := url.Parse(cluster.NodeURI(follower.id))
+	nodeCert := &x509.Certificate{Subject: pkix.Name{CommonName: string(follower.id)}, URIs: []*url.URL{nodeURI}}
+	c, err = leader.server.caller(forwarded(nodeCert))
+	if err != nil {
+		t.Fatalf("caller failed: %v", err)
+	}
+	if !slices.Contains(c.ids, billing) {
+		t.Fatalf("expected x509:billing from %s's node certificate, got %v", follower.id, c.ids)
+	}
+}
+
+// second is the error of a (response, error) pair.
+func second[T any](_ T, err error) error { return err }
+
+// waitFor polls cond until it holds, or fails the test after 5s.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// testCA signs test certificates and writes them, PEM, into dir.
+type testCA struct {
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, dir string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	ca := &testCA{dir: dir, cert: cert, key: key}
+	ca.write(t, "ca.crt", "CERTIFICATE", der)
+	return ca
+}
+
+// issue writes cn.crt and cn.key: a server certificate for 127.0.0.1,
+// or a client certificate.
+func (ca *testCA) issue(t *testing.T, cn string, server bool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey failed: %v", err)
+	}
+	ca.write(t, cn+".crt", "CERTIFICATE", der)
+	ca.write(t, cn+".key", "EC PRIVATE KEY", keyDER)
+}
+
+func (ca *testCA) write(t *testing.T, name, kind string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der})
+	if err := os.WriteFile(ca.file(name), data, 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+}
+
+func (ca *testCA) file(name string) string { return filepath.Join(ca.dir, name) }
+
+// dialTLS connects over TLS, with the client certificate named cn ("":
+// none).
+func dialTLS(t *testing.T, addr string, ca *testCA, cn string) zkpb.ZooKeeperClient {
+	t.Helper()
+	var certFile, keyFile string
+	if cn != "" {
+		certFile, keyFile = ca.file(cn+".crt"), ca.file(cn+".key")
+	}
+	cfg, err := LoadClientTLS(ca.file("ca.crt"), certFile, keyFile)
+	if err != nil {
+		t.Fatalf("LoadClientTLS failed: %v", err)
+	}
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(credentials.NewTLS(cfg)))
+	if err != nil {
+		t.Fatalf("failed to dial %s: %v", addr, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return zkpb.NewZooKeeperClient(conn)
+}
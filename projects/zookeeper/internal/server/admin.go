@@ -0,0 +1,137 @@
+package server
+
+// Cluster administration: adding and removing nodes.
+//
+// AddPeer and RemovePeer change the membership through the log (see
+// cluster/membership.go), so only the leader can run them. Like a
+// write, a follower forwards them:
+//
+//   zkcli peers add node-4 ... ──→ any node ──→ leader
+//                                                 1. CONFIG: node-4 learner
+//                                                 2. catch node-4 up
+//                                                 3. CONFIG: node-4 votes
+//   ←────────────── new membership ───────────────
+//
+// TransferLeadership is forwarded the same way. It returns once the
+// leader has handed over; the response names the new leader.
+//
+// ListPeers answers locally: it's this node's view, which a lagging
+// follower may not have caught up on yet.
+//
+// Changing the cluster needs ADMIN on "/" (see acl.go); ListPeers needs
+// nothing.
+//
+// Standalone, there's no cluster to change: FailedPrecondition.
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+	"github.com/syamsularifin/zookeeper/internal/cluster"
+)
+
+// AddPeer adds a node to the cluster and returns once it votes.
+func (s *Server) AddPeer(ctx context.Context, req *zkpb.AddPeerRequest) (*zkpb.PeersResponse, error) {
+	if s.raft == nil {
+		return nil, errStandalone
+	}
+	if err := s.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	p := req.GetPeer()
+	if p.GetId() == "" || p.GetRaftAddr() == "" {
+		return nil, status.Error(codes.InvalidArgument, "a peer needs an id and a raft address")
+	}
+
+	peer := cluster.Peer{ID: cluster.NodeID(p.Id), Addr: p.RaftAddr, ClientAddr: p.ClientAddr}
+	if err := s.raft.AddPeer(ctx, peer); err != nil {
+		if conn, fctx, ok := s.leaderConn(ctx, err); ok {
+			return zkpb.NewAdminClient(conn).AddPeer(fctx, req)
+		}
+		return nil, adminError(err)
+	}
+	return s.members(), nil
+}
+
+// RemovePeer removes a node from the cluster.
+func (s *Server) RemovePeer(ctx context.Context, req *zkpb.RemovePeerRequest) (*zkpb.PeersResponse, error) {
+	if s.raft == nil {
+		return nil, errStandalone
+	}
+	if err := s.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := s.raft.RemovePeer(cluster.NodeID(req.Id)); err != nil {
+		if conn, fctx, ok := s.leaderConn(ctx, err); ok {
+			return zkpb.NewAdminClient(conn).RemovePeer(fctx, req)
+		}
+		return nil, adminError(err)
+	}
+	return s.members(), nil
+}
+
+// ListPeers returns this node's view of the membership.
+func (s *Server) ListPeers(ctx context.Context, req *zkpb.ListPeersRequest) (*zkpb.PeersResponse, error) {
+	if s.raft == nil {
+		return nil, errStandalone
+	}
+	return s.members(), nil
+}
+
+// TransferLeadership hands leadership to another voter.
+func (s *Server) TransferLeadership(ctx context.Context, req *zkpb.TransferLeadershipRequest) (*zkpb.PeersResponse, error) {
+	if s.raft == nil {
+		return nil, errStandalone
+	}
+	if err := s.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := s.raft.TransferLeadership(cluster.NodeID(req.Id)); err != nil {
+		if conn, fctx, ok := s.leaderConn(ctx, err); ok {
+			return zkpb.NewAdminClient(conn).TransferLeadership(fctx, req)
+		}
+		return nil, adminError(err)
+	}
+	return s.members(), nil
+}
+
+// errStandalone is what the Admin service answers without a cluster.
+var errStandalone = status.Error(codes.FailedPrecondition, "not running in cluster mode")
+
+// members converts the RaftNode's membership into its wire form.
+func (s *Server) members() *zkpb.PeersResponse {
+	resp := &zkpb.PeersResponse{LeaderId: string(s.raft.GetState().LeaderID)}
+	for _, p := range s.raft.Members() {
+		resp.Peers = append(resp.Peers, &zkpb.Peer{
+			Id:         string(p.ID),
+			RaftAddr:   p.Addr,
+			ClientAddr: p.ClientAddr,
+			Learner:    p.Learner,
+		})
+	}
+	return resp
+}
+
+// adminError turns a failed membership change or transfer into a gRPC
+// status. A learner that didn't catch up, or a transfer that didn't
+// complete, is Unavailable: retrying may work.
+func adminError(err error) error {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return waitError(err)
+	case errors.Is(err, cluster.ErrPeerExists):
+		return clusterError(err, codes.AlreadyExists)
+	case errors.Is(err, cluster.ErrUnknownPeer):
+		return clusterError(err, codes.NotFound)
+	case errors.Is(err, cluster.ErrLearnerBehind), errors.Is(err, cluster.ErrTransferFailed):
+		return clusterError(err, codes.Unavailable)
+	default:
+		return clusterError(err, codes.FailedPrecondition)
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+	"github.com/syamsularifin/zookeeper/internal/cluster"
+)
+
+// TestAdmin_AddAndRemovePeer grows a running cluster to four nodes
+// through a follower's Admin service, then shrinks it back.
+func TestAdmin_AddAndRemovePeer(t *testing.T) {
+	nodes := newTestCluster(t)
+	leader := waitForLeader(t, nodes)
+	follower := followers(nodes, leader)[0]
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := leader.server.Create(ctx, &zkpb.CreateRequest{Path: "/before", Data: []byte("v1")}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	// Start node-4 as a learner that knows the existing members.
+	joiner := cluster.Peer{ID: "node-4", Addr: freeAddr(t), ClientAddr: freeAddr(t)}
+	peers := append(leader.raft.Members(), cluster.Peer{
+		ID: joiner.ID, Addr: joiner.Addr, ClientAddr: joiner.ClientAddr, Learner: true,
+	})
+	node4 := startTestNode(t, peers[len(peers)-1], peers)
+
+	resp, err := follower.server.AddPeer(ctx, &zkpb.AddPeerRequest{Peer: &zkpb.Peer{
+		Id: string(joiner.ID), RaftAddr: joiner.Addr, ClientAddr: joiner.ClientAddr,
+	}})
+	if err != nil {
+		t.Fatalf("AddPeer failed: %v", err)
+	}
+	if len(resp.Peers) != 4 || resp.Peers[3].Id != "node-4" || resp.Peers[3].Learner {
+		t.Fatalf("expected node-4 as the fourth voter, got %v", resp.Peers)
+	}
+
+	// node-4 has what was written before it joined, and what's written after.
+	if _, err := leader.server.Create(ctx, &zkpb.CreateRequest{Path: "/after"}); err != nil {
+		t.Fatalf("Create with four voters failed: %v", err)
+	}
+	if _, err := node4.server.Sync(ctx, &zkpb.SyncRequest{}); err != nil {
+		t.Fatalf("Sync on node-4 failed: %v", err)
+	}
+	for _, path := range []string{"/before", "/after"} {
+		if _, err := node4.store.Get(path); err != nil {
+			t.Fatalf("node-4 should have %s: %v", path, err)
+		}
+	}
+
+	if _, err := follower.server.AddPeer(ctx, &zkpb.AddPeerRequest{Peer: &zkpb.Peer{
+		Id: "node-4", RaftAddr: joiner.Addr,
+	}}); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected AlreadyExists adding node-4 twice, got %v", err)
+	}
+
+	resp, err = follower.server.RemovePeer(ctx, &zkpb.RemovePeerRequest{Id: "node-4"})
+	if err != nil {
+		t.Fatalf("RemovePeer failed: %v", err)
+	}
+	if len(resp.Peers) != 3 {
+		t.Fatalf("expected 3 peers after removal, got %v", resp.Peers)
+	}
+	if _, err := follower.server.RemovePeer(ctx, &zkpb.RemovePeerRequest{Id: "node-4"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound removing node-4 twice, got %v", err)
+	}
+}
+
+// TestAdmin_TransferLeadership asks a follower to move leadership to
+// the other follower, then writes through the old leader.
+func TestAdmin_TransferLeadership(t *testing.T) {
+	nodes := newTestCluster(t)
+	leader := waitForLeader(t, nodes)
+	fs := followers(nodes, leader)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	target := fs[1].raft.GetState()
+	resp, err := fs[0].server.TransferLeadership(ctx, &zkpb.TransferLeadershipRequest{Id: string(fs[1].id)})
+	if err != nil {
+		t.Fatalf("TransferLeadership failed: %v", err)
+	}
+	if resp.LeaderId != string(fs[1].id) {
+		t.Fatalf("expected %s to lead, got %q", fs[1].id, resp.LeaderId)
+	}
+	if state := fs[1].raft.GetState(); state.Role != cluster.Leader || state.CurrentTerm != target.CurrentTerm+1 {
+		t.Fatalf("expected %s to lead in the next term, got %+v", fs[1].id, state)
+	}
+
+	if _, err := leader.server.Create(ctx, &zkpb.CreateRequest{Path: "/after"}); err != nil {
+		t.Fatalf("Create through the old leader failed: %v", err)
+	}
+}
+
+func TestAdmin_Standalone(t *testing.T) {
+	srv := newStandalone(t)
+	if _, err := srv.ListPeers(context.Background(), &zkpb.ListPeersRequest{}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition, got %v", err)
+	}
+}
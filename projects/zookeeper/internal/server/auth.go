@@ -0,0 +1,277 @@
+package server
+
+// Who is calling?
+//
+// THE PROBLEM:
+//
+// ACLs (see acl.go) grant permissions to identities. The server has to
+// know which identities a caller has — without trusting the caller's
+// word for it.
+//
+// THE FIX: TWO WAYS TO PROVE WHO YOU ARE
+//
+//   x509    The client connects with a certificate the server's CA
+//           signed (mTLS). The TLS handshake proves it; the common
+//           name is the id: CN=billing → x509:billing.
+//   digest  The client sends "user:password" in the x-zk-digest
+//           metadata of each call. The server hashes it into the id:
+//           alice:secret → digest:alice:aYXl...
+//
+// Every caller is also world:anyone. A call can carry several digest
+// credentials; a bad one fails the call (Unauthenticated) rather than
+// silently counting for nothing.
+//
+// There's no login: the server keeps no per-connection state, so
+// digest credentials go with every call (zkclient's AddAuth does that).
+// The Authenticate RPC only checks credentials and echoes the
+// identities back, so a client finds out at once that it got them wrong.
+//
+// FORWARDED CALLS:
+//
+// A follower forwarding a call to the leader (forward.go) passes the
+// caller's identities on: digest credentials as they came, and the
+// client certificate's name in x-zk-x509. The leader only believes
+// x-zk-x509 from a cluster member — a caller whose own certificate is a
+// member's NODE certificate: CN=<node id> and the URI SAN
+// zk-node:<node id> (see cluster.NodeName):
+//
+//   Client ──CN=billing──→ node-2 ──CN=node-2, x-zk-x509: billing──→ leader
+//                                   URI:zk-node:node-2
+//                                   leader: node-2 is a member → x509:billing
+//
+// A CN is not enough: the CA signs client certificates too, and a
+// client that got one saying CN=node-2 could otherwise claim to be
+// anybody. Without TLS between the nodes, nobody is a member by
+// certificate and forwarded calls only keep their digest identities.
+//
+// THE SUPERUSER:
+//
+// Security.SuperDigest names one digest id that passes every check, the
+// way ZooKeeper's superDigest does — the way back in after an ACL that
+// locks everyone out.
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"slices"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+	"github.com/syamsularifin/zookeeper/internal/acl"
+	"github.com/syamsularifin/zookeeper/internal/cluster"
+)
+
+// DigestKey is the metadata key that carries digest credentials,
+// "user:password". A call may carry several.
+const DigestKey = "x-zk-digest"
+
+// x509Key carries the client certificate's common name on a call a
+// follower forwards. Only believed from a cluster member.
+const x509Key = "x-zk-x509"
+
+// Security is how a server authenticates callers. The zero value is no
+// TLS and no superuser: every caller is world:anyone plus whatever
+// digest credentials it sends.
+type Security struct {
+	// TLS is the client port's server config. With ClientCAs set, a
+	// client certificate the CAs signed gives the caller an x509 id.
+	TLS *tls.Config
+
+	// PeerTLS is the config for calling other nodes' client ports, to
+	// forward, and their Raft ports. Its certificate should be this
+	// node's node certificate (see cluster.NodeName), so the leader
+	// believes the x509 ids it forwards.
+	PeerTLS *tls.Config
+
+	// SuperDigest is a digest id, "user:hash" (see acl.Digest), that
+	// passes every ACL check. Empty: no superuser.
+	SuperDigest string
+}
+
+// SetSecurity sets how callers are authenticated. Call it before Serve.
+func (s *Server) SetSecurity(sec Security) {
+	s.sec = sec
+	if s.fwd != nil && sec.PeerTLS != nil {
+		s.fwd.creds = credentials.NewTLS(sec.PeerTLS)
+	}
+}
+
+// WithDigest returns ctx with digest credentials, "user:password",
+// added to its outgoing calls.
+func WithDigest(ctx context.Context, credentials string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, DigestKey, credentials)
+}
+
+// caller is who a call comes from.
+type caller struct {
+	ids   []acl.ID
+	super bool
+}
+
+// can reports whether acls grant perm to the caller.
+func (c caller) can(acls []acl.ACL, perm acl.Perm) bool {
+	return c.super || acl.Allowed(acls, c.ids, perm)
+}
+
+// caller works out who ctx's call comes from.
+func (s *Server) caller(ctx context.Context) (caller, error) {
+	c := caller{ids: []acl.ID{acl.Anyone}}
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if cert := cluster.PeerCertificate(ctx); cert != nil {
+		if isForwarded(ctx) && s.isMember(cluster.NodeName(cert)) {
+			// A member forwarding its client's call: the call is the
+			// client's, not the member's.
+			for _, name := range md.Get(x509Key) {
+				c.ids = appendID(c.ids, acl.ID{Scheme: acl.SchemeX509, ID: name})
+			}
+		} else if cn := cert.Subject.CommonName; cn != "" {
+			c.ids = appendID(c.ids, acl.ID{Scheme: acl.SchemeX509, ID: cn})
+		}
+	}
+
+	for _, creds := range md.Get(DigestKey) {
+		id, err := acl.DigestCredentials(creds)
+		if err != nil {
+			return caller{}, status.Errorf(codes.Unauthenticated, "%v", err)
+		}
+		c.ids = appendID(c.ids, id)
+	}
+
+	if s.sec.SuperDigest != "" {
+		c.super = slices.Contains(c.ids, acl.ID{Scheme: acl.SchemeDigest, ID: s.sec.SuperDigest})
+	}
+	return c, nil
+}
+
+// appendID adds id to ids unless it's there already.
+func appendID(ids []acl.ID, id acl.ID) []acl.ID {
+	if slices.Contains(ids, id) {
+		return ids
+	}
+	return append(ids, id)
+}
+
+// certName is the common name of the caller's verified client
+// certificate, "" if it didn't present one.
+func certName(ctx context.Context) string {
+	if cert := cluster.PeerCertificate(ctx); cert != nil {
+		return cert.Subject.CommonName
+	}
+	return ""
+}
+
+// isMember reports whether id is a node of this cluster.
+func (s *Server) isMember(id cluster.NodeID) bool {
+	if s.raft == nil || id == "" {
+		return false
+	}
+	for _, p := range s.raft.Members() {
+		if p.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardIdentity adds the caller's identities to out, the outgoing
+// context of a call being forwarded to the leader.
+func forwardIdentity(ctx, out context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, creds := range md.Get(DigestKey) {
+		out = metadata.AppendToOutgoingContext(out, DigestKey, creds)
+	}
+	if cn := certName(ctx); cn != "" {
+		out = metadata.AppendToOutgoingContext(out, x509Key, cn)
+	}
+	return out
+}
+
+// Authenticate checks credentials and returns every identity the caller
+// has with them. Empty credentials only ask: the caller's identities as
+// they are.
+func (s *Server) Authenticate(ctx context.Context, req *zkpb.AuthenticateRequest) (*zkpb.AuthenticateResponse, error) {
+	c, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := c.ids
+	if req.Credentials != "" {
+		if req.Scheme != acl.SchemeDigest {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"can't authenticate with scheme %q: digest only (x509 comes from the TLS handshake)", req.Scheme)
+		}
+		id, err := acl.DigestCredentials(req.Credentials)
+		if err != nil {
+			return nil, status.Errorf(codes.Unauthenticated, "%v", err)
+		}
+		ids = appendID(ids, id)
+	}
+
+	resp := &zkpb.AuthenticateResponse{}
+	for _, id := range ids {
+		resp.Identities = append(resp.Identities, id.String())
+	}
+	return resp, nil
+}
+
+// LoadServerTLS reads the client port's TLS config: this node's
+// certificate and key, and the CA that signs client certificates (may
+// be empty: no client certificates). A client certificate is optional;
+// one that's presented must verify.
+func LoadServerTLS(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		if cfg.ClientCAs, err = loadCA(caFile); err != nil {
+			return nil, err
+		}
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
+
+// LoadClientTLS reads a client's TLS config: the CA that signs server
+// certificates, and the client's own certificate and key (both empty:
+// no client certificate).
+func LoadClientTLS(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		var err error
+		if cfg.RootCAs, err = loadCA(caFile); err != nil {
+			return nil, err
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// loadCA reads a PEM file of CA certificates.
+func loadCA(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+	return pool, nil
+}
@@ -0,0 +1,487 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/syamsularifin/zookeeper/api/proto/raftpb"
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+	"github.com/syamsularifin/zookeeper/internal/cluster"
+	"github.com/syamsularifin/zookeeper/internal/store"
+	"github.com/syamsularifin/zookeeper/internal/znode"
+)
+
+// testNode is one member of an in-process cluster: a real Store on disk,
+// a real RaftNode talking gRPC over loopback, and the Server under test.
+type testNode struct {
+	id         cluster.NodeID
+	clientAddr string
+	store      *store.Store
+	raft       *cluster.RaftNode
+	server     *Server
+
+	// stop shuts the node down, as if it crashed. Safe to call twice.
+	stop func()
+}
+
+// freeAddr asks the OS for an unused loopback port.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find free port: %v", err)
+	}
+	defer lis.Close()
+	return lis.Addr().String()
+}
+
+// newTestCluster starts three nodes and waits until one of them is leader.
+func newTestCluster(t *testing.T) map[cluster.NodeID]*testNode {
+	t.Helper()
+
+	var peers []cluster.Peer
+	for i := 1; i <= 3; i++ {
+		peers = append(peers, cluster.Peer{
+			ID:         cluster.NodeID(fmt.Sprintf("node-%d", i)),
+			Addr:       freeAddr(t),
+			ClientAddr: freeAddr(t),
+		})
+	}
+
+	nodes := make(map[cluster.NodeID]*testNode)
+	for _, p := range peers {
+		nodes[p.ID] = startTestNode(t, p, peers)
+	}
+
+	waitForLeader(t, nodes)
+	return nodes
+}
+
+// startTestNode starts node p of a cluster made of peers: its Store, its
+// RaftNode serving Raft on p.Addr, and a Server on p.ClientAddr.
+func startTestNode(t *testing.T, p cluster.Peer, peers []cluster.Peer) *testNode {
+	t.Helper()
+	return startTestNodeWith(t, p, peers, nil)
+}
+
+// startTestNodeWith is startTestNode with the node's outgoing Raft
+// messages going through wrap's Transport (nil: straight to gRPC).
+func startTestNodeWith(t *testing.T, p cluster.Peer, peers []cluster.Peer, wrap func(cluster.Transport) cluster.Transport) *testNode {
+	t.Helper()
+
+	dir := t.TempDir()
+	s, err := store.New(filepath.Join(dir, "wal.log"), filepath.Join(dir, "snapshot.json"))
+	if err != nil {
+		t.Fatalf("store.New failed: %v", err)
+	}
+
+	grpcTransport := cluster.NewGRPCTransport(0)
+	var transport cluster.Transport = grpcTransport
+	if wrap != nil {
+		transport = wrap(grpcTransport)
+	}
+	node, err := cluster.NewRaftNode(cluster.Config{Self: p.ID, Peers: peers, DataDir: dir}, transport, s)
+	if err != nil {
+		t.Fatalf("NewRaftNode failed: %v", err)
+	}
+
+	lis, err := net.Listen("tcp", p.Addr)
+	if err != nil {
+		t.Fatalf("failed to listen on %s: %v", p.Addr, err)
+	}
+	g := grpc.NewServer()
+	raftpb.RegisterRaftServer(g, cluster.NewRaftServer(node))
+	go g.Serve(lis)
+
+	node.Run()
+
+	// Serve the client API too, so followers can forward to the leader.
+	srv := NewCluster(s, node, 0)
+	clientLis, err := net.Listen("tcp", p.ClientAddr)
+	if err != nil {
+		t.Fatalf("failed to listen on %s: %v", p.ClientAddr, err)
+	}
+	go srv.Serve(clientLis)
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			srv.Stop()
+			node.Stop()
+			g.Stop()
+			grpcTransport.Close()
+			s.Close()
+		})
+	}
+	t.Cleanup(stop)
+
+	return &testNode{
+		id:         p.ID,
+		clientAddr: p.ClientAddr,
+		store:      s,
+		raft:       node,
+		server:     srv,
+		stop:       stop,
+	}
+}
+
+// waitForLeader polls until exactly one node is leader and every node agrees.
+func waitForLeader(t *testing.T, nodes map[cluster.NodeID]*testNode) *testNode {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		var leader *testNode
+		agreed := true
+		for _, n := range nodes {
+			st := n.raft.GetState()
+			if st.Role == cluster.Leader {
+				leader = n
+			}
+			if leader != nil && st.LeaderID != leader.id {
+				agreed = false
+			}
+		}
+		if leader != nil && agreed {
+			for _, n := range nodes {
+				if n.raft.GetState().LeaderID != leader.id {
+					agreed = false
+				}
+			}
+			if agreed {
+				return leader
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("no leader elected")
+	return nil
+}
+
+// followers returns every node except the leader.
+func followers(nodes map[cluster.NodeID]*testNode, leader *testNode) []*testNode {
+	var out []*testNode
+	for _, n := range nodes {
+		if n != leader {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// waitForData polls a node's local store until path has the expected data.
+// Followers apply entries one heartbeat after the leader commits them.
+func waitForData(t *testing.T, n *testNode, path, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if data, err := n.store.Get(path); err == nil && string(data) == want {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("%s: %s never became %q", n.id, path, want)
+}
+
+// TestCluster_WritesReplicate proves a write through the leader's server
+// goes through Raft and ends up in every node's store.
+func TestCluster_WritesReplicate(t *testing.T) {
+	nodes := newTestCluster(t)
+	leader := waitForLeader(t, nodes)
+	ctx := context.Background()
+
+	if _, err := leader.server.Create(ctx, &zkpb.CreateRequest{Path: "/app", Data: []byte("hello")}); err != nil {
+		t.Fatalf("Create on leader failed: %v", err)
+	}
+	if _, err := leader.server.Set(ctx, &zkpb.SetRequest{Path: "/app", Data: []byte("world")}); err != nil {
+		t.Fatalf("Set on leader failed: %v", err)
+	}
+
+	// Create returned after commit → the leader has it applied already.
+	data, err := leader.store.Get("/app")
+	if err != nil || string(data) != "world" {
+		t.Fatalf("leader should have /app=world, got %q (%v)", data, err)
+	}
+
+	for _, n := range nodes {
+		waitForData(t, n, "/app", "world")
+	}
+}
+
+// TestCluster_OperationErrors proves a committed-but-failed operation
+// still reports the same error code as standalone mode.
+func TestCluster_OperationErrors(t *testing.T) {
+	nodes := newTestCluster(t)
+	leader := waitForLeader(t, nodes)
+	ctx := context.Background()
+
+	leader.server.Create(ctx, &zkpb.CreateRequest{Path: "/app"})
+
+	_, err := leader.server.Create(ctx, &zkpb.CreateRequest{Path: "/app"})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected AlreadyExists, got %v", err)
+	}
+
+	_, err = leader.server.Set(ctx, &zkpb.SetRequest{Path: "/missing"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+}
+
+// TestCluster_FollowerForwardsWrites proves a client can write through
+// any node: followers pass writes on to the leader transparently.
+func TestCluster_FollowerForwardsWrites(t *testing.T) {
+	nodes := newTestCluster(t)
+	leader := waitForLeader(t, nodes)
+	follower := followers(nodes, leader)[0]
+	ctx := context.Background()
+
+	resp, err := follower.server.Create(ctx, &zkpb.CreateRequest{Path: "/app", Data: []byte("v1")})
+	if err != nil {
+		t.Fatalf("Create through follower failed: %v", err)
+	}
+	if resp.Path != "/app" {
+		t.Fatalf("expected path /app, got %s", resp.Path)
+	}
+	if _, err := follower.server.Set(ctx, &zkpb.SetRequest{Path: "/app", Data: []byte("v2")}); err != nil {
+		t.Fatalf("Set through follower failed: %v", err)
+	}
+
+	// The leader committed it before answering the forwarded call.
+	data, err := leader.store.Get("/app")
+	if err != nil || string(data) != "v2" {
+		t.Fatalf("leader should have /app=v2, got %q (%v)", data, err)
+	}
+	for _, n := range nodes {
+		waitForData(t, n, "/app", "v2")
+	}
+
+	if _, err := follower.server.Delete(ctx, &zkpb.DeleteRequest{Path: "/app"}); err != nil {
+		t.Fatalf("Delete through follower failed: %v", err)
+	}
+
+	// Errors from the leader come back unchanged.
+	_, err = follower.server.Set(ctx, &zkpb.SetRequest{Path: "/app"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound from the leader, got %v", err)
+	}
+}
+
+// TestCluster_ForwardedWriteIsNotForwardedAgain proves the loop protection:
+// a request that was already forwarded once is answered with a typed
+// "not leader" error carrying the leader's address instead.
+func TestCluster_ForwardedWriteIsNotForwardedAgain(t *testing.T) {
+	nodes := newTestCluster(t)
+	leader := waitForLeader(t, nodes)
+	follower := followers(nodes, leader)[0]
+
+	// Pretend this request was forwarded to us by another node.
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(forwardedKey, "node-x"))
+
+	_, err := follower.server.Create(ctx, &zkpb.CreateRequest{Path: "/app"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition, got %v", err)
+	}
+
+	id, addr, ok := LeaderFromError(err)
+	if !ok {
+		t.Fatalf("expected a not-leader error, got %v", err)
+	}
+	if id != string(leader.id) || addr != leader.clientAddr {
+		t.Fatalf("expected leader %s at %s, got %s at %s", leader.id, leader.clientAddr, id, addr)
+	}
+
+	// Nothing was written anywhere.
+	if _, err := leader.store.Get("/app"); err == nil {
+		t.Fatal("rejected write should not reach the leader")
+	}
+}
+
+// TestCluster_ConditionalWrites proves the expected version travels
+// through Raft and a mismatch comes back as Aborted.
+func TestCluster_ConditionalWrites(t *testing.T) {
+	nodes := newTestCluster(t)
+	leader := waitForLeader(t, nodes)
+	ctx := context.Background()
+
+	leader.server.Create(ctx, &zkpb.CreateRequest{Path: "/app", Data: []byte("v0")})
+
+	v0 := int32(0)
+	if _, err := leader.server.Set(ctx, &zkpb.SetRequest{Path: "/app", Data: []byte("v1"), Version: &v0}); err != nil {
+		t.Fatalf("Set at version 0 failed: %v", err)
+	}
+	_, err := leader.server.Set(ctx, &zkpb.SetRequest{Path: "/app", Data: []byte("lost"), Version: &v0})
+	if status.Code(err) != codes.Aborted {
+		t.Fatalf("expected Aborted, got %v", err)
+	}
+	_, err = leader.server.Delete(ctx, &zkpb.DeleteRequest{Path: "/app", Version: &v0})
+	if status.Code(err) != codes.Aborted {
+		t.Fatalf("expected Aborted, got %v", err)
+	}
+
+	resp, err := leader.server.Get(ctx, &zkpb.GetRequest{Path: "/app"})
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if string(resp.Data) != "v1" || resp.Stat.GetVersion() != 1 {
+		t.Fatalf("expected v1 at version 1, got %q at %d", resp.Data, resp.Stat.GetVersion())
+	}
+
+	// Every replica applied the same entries, so every replica has the
+	// same Stat — times included, since they come from the leader.
+	for _, n := range nodes {
+		waitForData(t, n, "/app", "v1")
+		st, _ := n.store.Stat("/app")
+		if st != statOf(t, leader, "/app") {
+			t.Fatalf("%s: Stat %+v differs from the leader's", n.id, st)
+		}
+	}
+}
+
+func statOf(t *testing.T, n *testNode, path string) znode.Stat {
+	t.Helper()
+	st, err := n.store.Stat(path)
+	if err != nil {
+		t.Fatalf("%s: Stat %s failed: %v", n.id, path, err)
+	}
+	return st
+}
+
+// TestCluster_SessionExpiresAfterFailover proves session expiry doesn't
+// depend on the leader that created the session: the new leader expires
+// it from the replicated session table.
+func TestCluster_SessionExpiresAfterFailover(t *testing.T) {
+	nodes := newTestCluster(t)
+	leader := waitForLeader(t, nodes)
+	follower := followers(nodes, leader)[0]
+	ctx := context.Background()
+
+	// Through a follower: sessions and ephemeral creates are forwarded.
+	sess, err := follower.server.CreateSession(ctx, &zkpb.CreateSessionRequest{TimeoutMs: 1000})
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	_, err = follower.server.Create(ctx, &zkpb.CreateRequest{Path: "/worker", Ephemeral: true, SessionId: sess.SessionId, SessionPassword: sess.Password})
+	if err != nil {
+		t.Fatalf("ephemeral Create failed: %v", err)
+	}
+	if _, err := follower.server.KeepAlive(ctx, &zkpb.KeepAliveRequest{SessionId: sess.SessionId, Password: sess.Password}); err != nil {
+		t.Fatalf("KeepAlive through follower failed: %v", err)
+	}
+	for _, n := range nodes {
+		waitForData(t, n, "/worker", "")
+	}
+
+	// The leader dies, and the client with it.
+	leader.stop()
+	delete(nodes, leader.id)
+	waitForLeader(t, nodes)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for _, n := range nodes {
+		for {
+			if _, ok := n.store.Session(sess.SessionId); !ok {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: session %d never expired after failover", n.id, sess.SessionId)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if _, err := n.store.Get("/worker"); err == nil {
+			t.Fatalf("%s: /worker should be gone with its session", n.id)
+		}
+	}
+}
+
+// TestCluster_WatchOnFollower proves a client watching a follower hears
+// about writes committed through the leader.
+func TestCluster_WatchOnFollower(t *testing.T) {
+	nodes := newTestCluster(t)
+	leader := waitForLeader(t, nodes)
+	follower := followers(nodes, leader)[0]
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream := openWatch(t, ctx, dial(t, follower.clientAddr), &zkpb.WatchRequest{
+		Path: "/app", Type: zkpb.WatchType_ALL, Persistent: true,
+	})
+
+	leader.server.Create(ctx, &zkpb.CreateRequest{Path: "/app", Data: []byte("v1")})
+	leader.server.Set(ctx, &zkpb.SetRequest{Path: "/app", Data: []byte("v2")})
+	leader.server.Delete(ctx, &zkpb.DeleteRequest{Path: "/app"})
+
+	expectEvent(t, stream, zkpb.EventType_NODE_CREATED, "/app")
+	expectEvent(t, stream, zkpb.EventType_NODE_DATA_CHANGED, "/app")
+	expectEvent(t, stream, zkpb.EventType_NODE_DELETED, "/app")
+}
+
+// TestCluster_SequentialCreate proves a sequential create through a
+// follower returns the path the leader created, and every replica
+// creates the same one.
+func TestCluster_SequentialCreate(t *testing.T) {
+	nodes := newTestCluster(t)
+	leader := waitForLeader(t, nodes)
+	follower := followers(nodes, leader)[0]
+	ctx := context.Background()
+
+	leader.server.Create(ctx, &zkpb.CreateRequest{Path: "/q"})
+	var paths []string
+	for i := 0; i < 3; i++ {
+		resp, err := follower.server.Create(ctx, &zkpb.CreateRequest{Path: "/q/job-", Data: []byte("x"), Sequential: true})
+		if err != nil {
+			t.Fatalf("sequential Create failed: %v", err)
+		}
+		paths = append(paths, resp.Path)
+	}
+
+	want := []string{"/q/job-0000000000", "/q/job-0000000001", "/q/job-0000000002"}
+	if fmt.Sprint(paths) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, paths)
+	}
+	for _, n := range nodes {
+		waitForData(t, n, want[2], "x")
+	}
+}
+
+// TestCluster_MultiReplicates proves a Multi through a follower is
+// applied on every replica, and a failed one on none.
+func TestCluster_MultiReplicates(t *testing.T) {
+	nodes := newTestCluster(t)
+	leader := waitForLeader(t, nodes)
+	follower := followers(nodes, leader)[0]
+	ctx := context.Background()
+
+	_, err := follower.server.Multi(ctx, &zkpb.MultiRequest{Ops: []*zkpb.Op{
+		{Op: &zkpb.Op_Create{Create: &zkpb.CreateRequest{Path: "/app", Data: []byte("a")}}},
+		{Op: &zkpb.Op_Create{Create: &zkpb.CreateRequest{Path: "/app/config", Data: []byte("c")}}},
+	}})
+	if err != nil {
+		t.Fatalf("Multi through follower failed: %v", err)
+	}
+
+	_, err = follower.server.Multi(ctx, &zkpb.MultiRequest{Ops: []*zkpb.Op{
+		{Op: &zkpb.Op_Set{Set: &zkpb.SetRequest{Path: "/app", Data: []byte("b")}}},
+		{Op: &zkpb.Op_Create{Create: &zkpb.CreateRequest{Path: "/app/config"}}},
+	}})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected AlreadyExists, got %v", err)
+	}
+	if index, ok := FailedOp(err); !ok || index != 1 {
+		t.Fatalf("expected op 1 to be reported, got %d (%v)", index, ok)
+	}
+
+	for _, n := range nodes {
+		waitForData(t, n, "/app/config", "c")
+		waitForData(t, n, "/app", "a")
+	}
+}
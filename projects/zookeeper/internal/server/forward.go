@@ -0,0 +1,136 @@
+package server
+
+// Follower write forwarding.
+//
+// In cluster mode only the leader can accept writes. Without forwarding,
+// every client has to know who the leader is — and find out again after
+// every election. That's annoying for clients and leaks cluster details.
+//
+// With forwarding, a follower that receives a write simply passes it on:
+//
+//   Client ──Create──→ Follower ──Create──→ Leader ──Propose──→ majority
+//   Client ←─────────── Follower ←────────── Leader (after commit)
+//
+// The client talks to whichever node it likes and never sees the redirect.
+//
+// Reads that need the leader go the same way: a follower asks the
+// leader's Sync for a read index, and forwards LEASE reads (see read.go).
+//
+// LOOP PROTECTION:
+//
+// During an election two nodes can briefly disagree about the leader.
+// Node A thinks B is leader, B thinks A is leader → A forwards to B,
+// B forwards back to A, forever. To prevent that, a forwarded request
+// carries a marker in its gRPC metadata. A node never forwards a request
+// that was already forwarded — it answers "not leader" instead, and the
+// client retries.
+//
+// IDENTITY:
+//
+// The leader checks the ACLs again, as the original caller: the
+// forwarded call carries the caller's identities (see auth.go).
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+	"github.com/syamsularifin/zookeeper/internal/cluster"
+)
+
+// forwardedKey is the metadata key that marks a forwarded request.
+const forwardedKey = "x-zk-forwarded"
+
+// forwarder keeps one client connection per leader address.
+// Like GRPCTransport, connections are reused across requests.
+type forwarder struct {
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+
+	// creds secures the connections: TLS if the client port uses it.
+	creds credentials.TransportCredentials
+}
+
+func newForwarder() *forwarder {
+	return &forwarder{
+		conns: make(map[string]*grpc.ClientConn),
+		creds: insecure.NewCredentials(),
+	}
+}
+
+// conn returns the cached connection to addr, dialing it the first time.
+func (f *forwarder) conn(addr string) (*grpc.ClientConn, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	conn, ok := f.conns[addr]
+	if !ok {
+		var err error
+		conn, err = grpc.NewClient(addr,
+			grpc.WithTransportCredentials(f.creds),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create client for %s: %w", addr, err)
+		}
+		f.conns[addr] = conn
+	}
+
+	return conn, nil
+}
+
+// close closes every cached connection.
+func (f *forwarder) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for addr, conn := range f.conns {
+		conn.Close()
+		delete(f.conns, addr)
+	}
+}
+
+// isForwarded reports whether the incoming request came from another node.
+func isForwarded(ctx context.Context) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	return ok && len(md.Get(forwardedKey)) > 0
+}
+
+// leaderFor decides whether a failed proposal should be forwarded.
+// It returns the leader's client when:
+//   - the error is "not leader",
+//   - we know the leader's client address, and
+//   - the request wasn't already forwarded once.
+//
+// The returned context marks the outgoing call as forwarded and keeps
+// the client's deadline, so the leader doesn't work longer than the
+// client is willing to wait.
+func (s *Server) leaderFor(ctx context.Context, err error) (zkpb.ZooKeeperClient, context.Context, bool) {
+	conn, out, ok := s.leaderConn(ctx, err)
+	if !ok {
+		return nil, nil, false
+	}
+	return zkpb.NewZooKeeperClient(conn), out, true
+}
+
+// leaderConn is leaderFor for any service on the leader's client port:
+// it returns the connection rather than a ZooKeeper client.
+func (s *Server) leaderConn(ctx context.Context, err error) (*grpc.ClientConn, context.Context, bool) {
+	var nle *cluster.NotLeaderError
+	if s.fwd == nil || !errors.As(err, &nle) || nle.LeaderAddr == "" || isForwarded(ctx) {
+		return nil, nil, false
+	}
+
+	conn, cerr := s.fwd.conn(nle.LeaderAddr)
+	if cerr != nil {
+		return nil, nil, false
+	}
+
+	out := metadata.AppendToOutgoingContext(ctx, forwardedKey, string(nle.LeaderID))
+	return conn, forwardIdentity(ctx, out), true
+}
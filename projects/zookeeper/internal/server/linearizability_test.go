@@ -0,0 +1,389 @@
+package server
+
+// A linearizability test: concurrent clients against a three-node
+// cluster while the network between the nodes splits and heals, then a
+// check that everything they saw could have come from one znode tree
+// (see internal/linearizability).
+//
+//   clients ──Get/Create/Set/Delete──→ any node   (recorded: call, return, answer)
+//   nemesis ──every ~0.6s──→ isolate the leader, or another node, or heal
+//   then    ──→ linearizability.Check(history)
+//
+// Clients behave like zkcli with one server: one request at a time, one
+// attempt each, a short back-off after a failure. A write that times
+// out or finds no quorum is recorded as unknown — it may still commit
+// later. Retrying it would make a duplicate write look like a bug in
+// the cluster. A read that fails is left out: it changed nothing.
+//
+// On a violation the test fails with a text report, and writes an HTML
+// timeline of the history and logs where. A key the check can't decide
+// within -lin.timeout is logged, not failed: many unknown writes at
+// once make the search exponential. -lin.report writes the HTML always:
+//
+//   go test ./internal/server -run TestLinearizability -v -lin.report=/tmp/lin.html
+//
+// -lin.reads=LOCAL reads from whichever node a client asks, without
+// checking it's up to date. Followers cut off from the leader answer
+// from what they have — which is not linearizable, and the checker
+// should say so.
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+	"github.com/syamsularifin/zookeeper/internal/cluster"
+	"github.com/syamsularifin/zookeeper/internal/linearizability"
+)
+
+var (
+	linReport   = flag.String("lin.report", "", "write TestLinearizability's HTML report to this file, even if the history is linearizable")
+	linReads    = flag.String("lin.reads", "READ_INDEX", "read consistency of TestLinearizability's clients")
+	linDuration = flag.Duration("lin.duration", 6*time.Second, "how long TestLinearizability runs its clients")
+	linTimeout  = flag.Duration("lin.timeout", 5*time.Second, "how long TestLinearizability's check may spend on a key before it gives up")
+)
+
+const (
+	linClients = 8
+	linKeys    = 8
+)
+
+type (
+	linInput  = linearizability.ZnodeInput
+	linOutput = linearizability.ZnodeOutput
+	linModel  = linearizability.ZnodeModel
+)
+
+// errPartitioned is what a Raft message across a partition gets.
+var errPartitioned = errors.New("partitioned")
+
+// netSplit is the network between the nodes of a test cluster, which
+// can be split: nodes in different groups can't send each other Raft
+// messages. Clients still reach every node, and followers still forward
+// to the leader — only Raft is cut.
+type netSplit struct {
+	mu    sync.Mutex
+	group map[cluster.NodeID]int // nil: no partition
+}
+
+// split cuts the cluster into groups. A node in none is on its own.
+func (n *netSplit) split(groups ...[]cluster.NodeID) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.group = make(map[cluster.NodeID]int)
+	for i, g := range groups {
+		for _, id := range g {
+			n.group[id] = i + 1
+		}
+	}
+}
+
+func (n *netSplit) heal() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.group = nil
+}
+
+// cut reports whether a can't reach b. Nodes in no group (0) are alone.
+func (n *netSplit) cut(a, b cluster.NodeID) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.group == nil {
+		return false
+	}
+	return n.group[a] == 0 || n.group[a] != n.group[b]
+}
+
+// splitTransport is a node's Transport behind a netSplit.
+type splitTransport struct {
+	cluster.Transport
+	net  *netSplit
+	from cluster.NodeID
+}
+
+func (t *splitTransport) SendRequestVote(peer cluster.Peer, req cluster.RequestVoteRequest) (cluster.RequestVoteResponse, error) {
+	if t.net.cut(t.from, peer.ID) {
+		return cluster.RequestVoteResponse{}, errPartitioned
+	}
+	return t.Transport.SendRequestVote(peer, req)
+}
+
+func (t *splitTransport) SendAppendEntries(peer cluster.Peer, req cluster.AppendEntriesRequest) (cluster.AppendEntriesResponse, error) {
+	if t.net.cut(t.from, peer.ID) {
+		return cluster.AppendEntriesResponse{}, errPartitioned
+	}
+	return t.Transport.SendAppendEntries(peer, req)
+}
+
+func (t *splitTransport) SendInstallSnapshot(peer cluster.Peer, req cluster.InstallSnapshotRequest) (cluster.InstallSnapshotResponse, error) {
+	if t.net.cut(t.from, peer.ID) {
+		return cluster.InstallSnapshotResponse{}, errPartitioned
+	}
+	return t.Transport.SendInstallSnapshot(peer, req)
+}
+
+func (t *splitTransport) SendTimeoutNow(peer cluster.Peer, req cluster.TimeoutNowRequest) (cluster.TimeoutNowResponse, error) {
+	if t.net.cut(t.from, peer.ID) {
+		return cluster.TimeoutNowResponse{}, errPartitioned
+	}
+	return t.Transport.SendTimeoutNow(peer, req)
+}
+
+// newSplitCluster is newTestCluster on a network that can be split.
+func newSplitCluster(t *testing.T) (map[cluster.NodeID]*testNode, *netSplit) {
+	t.Helper()
+
+	var peers []cluster.Peer
+	for i := 1; i <= 3; i++ {
+		peers = append(peers, cluster.Peer{
+			ID:         cluster.NodeID(fmt.Sprintf("node-%d", i)),
+			Addr:       freeAddr(t),
+			ClientAddr: freeAddr(t),
+		})
+	}
+
+	network := &netSplit{}
+	nodes := make(map[cluster.NodeID]*testNode)
+	for _, p := range peers {
+		nodes[p.ID] = startTestNodeWith(t, p, peers, func(inner cluster.Transport) cluster.Transport {
+			return &splitTransport{Transport: inner, net: network, from: p.ID}
+		})
+	}
+
+	waitForLeader(t, nodes)
+	return nodes, network
+}
+
+// nemesis splits and heals the network every so often until stop is
+// closed, and logs what it did.
+func nemesis(t *testing.T, nodes map[cluster.NodeID]*testNode, network *netSplit, stop <-chan struct{}) {
+	rng := rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), 0))
+	var ids []cluster.NodeID
+	for id := range nodes {
+		ids = append(ids, id)
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(time.Duration(300+rng.IntN(700)) * time.Millisecond):
+		}
+
+		switch rng.IntN(4) {
+		case 0:
+			// The leader, alone: it keeps taking writes it can't commit
+			// until it notices, and the other two elect a new one.
+			for id, n := range nodes {
+				if n.raft.GetState().Role == cluster.Leader {
+					network.split([]cluster.NodeID{id})
+					t.Logf("nemesis: isolate leader %s", id)
+					break
+				}
+			}
+		case 1:
+			id := ids[rng.IntN(len(ids))]
+			network.split([]cluster.NodeID{id})
+			t.Logf("nemesis: isolate %s", id)
+		default:
+			network.heal()
+			t.Logf("nemesis: heal")
+		}
+	}
+}
+
+// linClient makes random requests on a few keys until stop is closed,
+// each to a random node, and records them in h.
+func linClient(id int, zks []zkpb.ZooKeeperClient, h *linearizability.History[linInput, linOutput], consistency zkpb.ReadConsistency, stop <-chan struct{}) {
+	rng := rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), uint64(id)))
+	for n := 0; ; n++ {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		in := linInput{
+			Path:    fmt.Sprintf("/k%d", rng.IntN(linKeys)),
+			Data:    fmt.Sprintf("c%d-%d", id, n),
+			Version: linearizability.AnyVersion,
+		}
+		switch r := rng.IntN(10); {
+		case r < 4:
+			in.Op = linearizability.ZnodeGet
+		case r < 7:
+			in.Op = linearizability.ZnodeSet
+			if rng.IntN(2) == 0 {
+				in.Version = int32(rng.IntN(3)) // compare-and-set
+			}
+		case r < 9:
+			in.Op = linearizability.ZnodeCreate
+		default:
+			in.Op = linearizability.ZnodeDelete
+		}
+
+		op := h.Call(id, in)
+		out := linDo(zks[rng.IntN(len(zks))], in, consistency)
+		switch {
+		case out.Result != linearizability.ResultUnknown:
+			h.Return(op, out)
+			continue
+		case in.Op == linearizability.ZnodeGet:
+			h.Discard(op)
+		default:
+			h.Unknown(op, out)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// linDo sends one request and says how it ended.
+func linDo(zk zkpb.ZooKeeperClient, in linInput, consistency zkpb.ReadConsistency) linOutput {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var version *int32
+	if in.Version != linearizability.AnyVersion {
+		version = proto.Int32(in.Version)
+	}
+
+	var err error
+	switch in.Op {
+	case linearizability.ZnodeGet:
+		var resp *zkpb.GetResponse
+		resp, err = zk.Get(ctx, &zkpb.GetRequest{Path: in.Path, Consistency: consistency})
+		if err == nil {
+			return linOutput{Result: linearizability.ResultOK, Data: string(resp.Data), Version: resp.Stat.Version}
+		}
+	case linearizability.ZnodeCreate:
+		_, err = zk.Create(ctx, &zkpb.CreateRequest{Path: in.Path, Data: []byte(in.Data)})
+	case linearizability.ZnodeSet:
+		_, err = zk.Set(ctx, &zkpb.SetRequest{Path: in.Path, Data: []byte(in.Data), Version: version})
+	case linearizability.ZnodeDelete:
+		_, err = zk.Delete(ctx, &zkpb.DeleteRequest{Path: in.Path, Version: version})
+	}
+	return linOutput{Result: linResult(in.Op, err)}
+}
+
+// linResult maps a request's error to how it ended. Anything that
+// doesn't say for sure is unknown: a "not leader" answer too, as it may
+// come from a follower that forwarded the request to a leader that
+// took it, and then lost leadership.
+func linResult(op linearizability.ZnodeOp, err error) linearizability.ZnodeResult {
+	if err == nil {
+		return linearizability.ResultOK
+	}
+	if _, _, ok := LeaderFromError(err); ok {
+		return linearizability.ResultUnknown
+	}
+	switch status.Code(err) {
+	case codes.NotFound:
+		return linearizability.ResultNoNode
+	case codes.AlreadyExists:
+		return linearizability.ResultNodeExists
+	case codes.Aborted:
+		return linearizability.ResultBadVersion
+	case codes.FailedPrecondition:
+		if op == linearizability.ZnodeDelete {
+			return linearizability.ResultNoNode // the keys have no children
+		}
+	}
+	return linearizability.ResultUnknown
+}
+
+// TestLinearizability runs linClients clients against a cluster under
+// a nemesis for -lin.duration, then checks their history.
+func TestLinearizability(t *testing.T) {
+	if testing.Short() {
+		t.Skip("slow: runs a whole cluster under partitions")
+	}
+	consistency, ok := zkpb.ReadConsistency_value[strings.ToUpper(*linReads)]
+	if !ok {
+		t.Fatalf("-lin.reads=%s: no such read consistency", *linReads)
+	}
+
+	nodes, network := newSplitCluster(t)
+	var zks []zkpb.ZooKeeperClient
+	for _, n := range nodes {
+		zks = append(zks, dial(t, n.clientAddr))
+	}
+
+	h := linearizability.NewHistory[linInput, linOutput]()
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < linClients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			linClient(i, zks, h, zkpb.ReadConsistency(consistency), stop)
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		nemesis(t, nodes, network, stop)
+	}()
+
+	time.Sleep(*linDuration)
+	close(stop)
+	wg.Wait()
+	network.heal()
+
+	ops := h.Operations()
+	answered := 0
+	for _, op := range ops {
+		if op.Return != linearizability.Never {
+			answered++
+		}
+	}
+	t.Logf("%d operations, %d answered, %d unknown", len(ops), answered, len(ops)-answered)
+	if answered < 50 {
+		t.Fatalf("only %d operations got an answer: the cluster was down most of the time", answered)
+	}
+
+	result := linearizability.CheckTimeout[linearizability.ZnodeState](linModel{}, ops, *linTimeout)
+	if !result.OK || *linReport != "" {
+		writeLinReport(t, result)
+	}
+	switch {
+	case result.GaveUp:
+		t.Logf("no violation found, but the check gave up:\n%s", linearizability.Text(linModel{}, result))
+	case !result.OK:
+		t.Fatalf("history is not linearizable:\n%s", linearizability.Text(linModel{}, result))
+	}
+}
+
+// writeLinReport writes the HTML report to -lin.report, or a temporary
+// file that outlives the test.
+func writeLinReport(t *testing.T, result linearizability.Result[linearizability.ZnodeState, linInput, linOutput]) {
+	t.Helper()
+	var f *os.File
+	var err error
+	if *linReport != "" {
+		f, err = os.Create(*linReport)
+	} else {
+		f, err = os.CreateTemp("", "linearizability-*.html")
+	}
+	if err != nil {
+		t.Errorf("can't write report: %v", err)
+		return
+	}
+	defer f.Close()
+	if err := linearizability.WriteHTML(f, linModel{}, result); err != nil {
+		t.Errorf("can't write report: %v", err)
+		return
+	}
+	t.Logf("report: %s", f.Name())
+}
@@ -0,0 +1,252 @@
+package server
+
+// Monitoring: what ZooKeeper's four-letter words (ruok, stat, mntr) tell
+// an operator, over the Admin service and over plain HTTP.
+//
+//   Admin.Health    GET /healthz   alive? (any answer: "imok")
+//                   GET /readyz    ready to serve? 503 and why, if not
+//   Admin.Status    GET /state     role, term, log progress, each peer's
+//                                  lag, znodes, WAL size — as JSON
+//   Admin.Metrics   GET /metrics   all of it and more, for Prometheus
+//
+// Every one answers about THIS server: nothing is forwarded to the
+// leader, and a follower that lost its leader still answers (that's
+// when an operator needs it most). None of them needs an ACL, like
+// ListPeers: they say how the server is doing, not what's in the tree.
+// The HTTP endpoint has no authentication at all — listen on a private
+// address (zknode --admin-addr).
+//
+// READY:
+//
+// Standalone, a server that answers is ready. In a cluster it must know
+// a leader, and not be cut off from it (cluster.Status.Isolated): without
+// one, writes fail and consistent reads wait.
+//
+// METRICS:
+//
+// The per-server registry holds the request latency of every unary
+// RPC, by method and status code, recorded by an interceptor, and
+// gauges read at scrape time from the RaftNode and the Store. The WAL's
+// fsync latency is process-wide (metrics.Default); the endpoint writes
+// both. A Watch stream isn't a request with a latency, so streams
+// aren't timed.
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+	"github.com/syamsularifin/zookeeper/internal/cluster"
+	"github.com/syamsularifin/zookeeper/internal/metrics"
+)
+
+// Health says whether this server can serve requests.
+func (s *Server) Health(ctx context.Context, req *zkpb.HealthRequest) (*zkpb.HealthResponse, error) {
+	reason := s.notReady()
+	return &zkpb.HealthResponse{Ready: reason == "", Reason: reason}, nil
+}
+
+// Status returns this server's Raft state and the store's numbers.
+func (s *Server) Status(ctx context.Context, req *zkpb.StatusRequest) (*zkpb.StatusResponse, error) {
+	return s.status(), nil
+}
+
+// Metrics returns this server's metrics in the Prometheus text format.
+func (s *Server) Metrics(ctx context.Context, req *zkpb.MetricsRequest) (*zkpb.MetricsResponse, error) {
+	var b strings.Builder
+	if err := metrics.Write(&b, s.metrics, metrics.Default); err != nil {
+		return nil, err
+	}
+	return &zkpb.MetricsResponse{Text: b.String()}, nil
+}
+
+// AdminHandler serves the monitoring endpoints over HTTP.
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("imok\n"))
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, _ *http.Request) {
+		if reason := s.notReady(); reason != "" {
+			http.Error(w, reason, http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ready\n"))
+	})
+	mux.HandleFunc("GET /state", func(w http.ResponseWriter, _ *http.Request) {
+		data, err := StatusJSON(s.status())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+	mux.Handle("GET /metrics", metrics.Handler(s.metrics, metrics.Default))
+	return mux
+}
+
+// StatusJSON is how /state and `zkcli admin state` print a status.
+func StatusJSON(st *zkpb.StatusResponse) ([]byte, error) {
+	data, err := protojson.MarshalOptions{Multiline: true, UseProtoNames: true, EmitUnpopulated: true}.Marshal(st)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// notReady is why this server can't serve requests, or "" if it can.
+func (s *Server) notReady() string {
+	if s.raft == nil {
+		return ""
+	}
+	st := s.raft.Status()
+	switch {
+	case st.LeaderID == "":
+		return fmt.Sprintf("no leader known in term %d", st.CurrentTerm)
+	case st.Isolated && st.Role == cluster.Leader:
+		return "no majority has answered for an election timeout"
+	case st.Isolated:
+		return fmt.Sprintf("no leader: %s not heard from for an election timeout", st.LeaderID)
+	}
+	return ""
+}
+
+// status gathers the StatusResponse.
+func (s *Server) status() *zkpb.StatusResponse {
+	stats := s.store.Stats()
+	resp := &zkpb.StatusResponse{
+		Role:        "standalone",
+		CommitIndex: stats.CommitIndex,
+		LastApplied: stats.CommitIndex,
+		LastLogTxid: stats.LastTxID,
+		Store: &zkpb.StoreStats{
+			Znodes:       int64(stats.Znodes),
+			WalBytes:     stats.WALBytes,
+			WalSegments:  int64(stats.WALSegments),
+			FirstTxid:    stats.FirstTxID,
+			LastTxid:     stats.LastTxID,
+			SnapshotTxid: stats.SnapshotTxID,
+			Sessions:     int64(stats.Sessions),
+			Watches:      int64(stats.Watches),
+		},
+	}
+	if s.raft == nil {
+		return resp
+	}
+
+	st := s.raft.Status()
+	resp.Id = string(st.ID)
+	resp.Role = st.Role.String()
+	resp.Term = st.CurrentTerm
+	resp.VotedFor = string(st.VotedFor)
+	resp.LeaderId = string(st.LeaderID)
+	resp.Isolated = st.Isolated
+	resp.CommitIndex = st.CommitIndex
+	resp.LastApplied = st.LastApplied
+	resp.LastLogTxid = st.LastLogTxID
+	resp.LastLogTerm = st.LastLogTerm
+	resp.Elections = st.Elections
+	resp.ElectionsWon = st.ElectionsWon
+	for _, p := range st.Peers {
+		resp.Peers = append(resp.Peers, &zkpb.PeerStatus{
+			Id:              string(p.ID),
+			Learner:         p.Learner,
+			MatchIndex:      p.MatchIndex,
+			NextIndex:       p.NextIndex,
+			Lag:             p.Lag,
+			SendingSnapshot: p.SendingSnapshot,
+		})
+	}
+	return resp
+}
+
+// observe is the interceptor that times every unary RPC.
+func (s *Server) observe(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+
+	// "/zk.ZooKeeper/Create" → service "zk.ZooKeeper", method "Create"
+	service, method := path.Split(info.FullMethod)
+	s.requests.With(strings.Trim(service, "/"), method, status.Code(err).String()).ObserveSince(start)
+	return resp, err
+}
+
+// registerMetrics sets up the server's registry: request latency, and
+// gauges that read the RaftNode and the Store when scraped.
+func (s *Server) registerMetrics() {
+	r := metrics.NewRegistry()
+	s.metrics = r
+	s.requests = r.NewHistogramVec("zk_grpc_request_duration_seconds",
+		"Time to answer a unary RPC, by method and status code.",
+		metrics.LatencyBuckets, "service", "method", "code")
+
+	r.NewGaugeFunc("zk_znode_count", "Nodes in the tree, the root included.", func() []metrics.Sample {
+		return metrics.Value(float64(s.store.Stats().Znodes))
+	})
+	r.NewGaugeFunc("zk_wal_bytes", "Bytes of WAL on disk.", func() []metrics.Sample {
+		return metrics.Value(float64(s.store.Stats().WALBytes))
+	})
+	r.NewGaugeFunc("zk_wal_segments", "WAL segment files on disk.", func() []metrics.Sample {
+		return metrics.Value(float64(s.store.Stats().WALSegments))
+	})
+	r.NewGaugeFunc("zk_sessions", "Open client sessions.", func() []metrics.Sample {
+		return metrics.Value(float64(s.store.Stats().Sessions))
+	})
+	r.NewGaugeFunc("zk_watches", "Registered watches.", func() []metrics.Sample {
+		return metrics.Value(float64(s.store.Stats().Watches))
+	})
+
+	// Raft: only in a cluster. A collect function with no samples
+	// writes nothing.
+	raft := func(f func(cluster.Status) []metrics.Sample) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			if s.raft == nil {
+				return nil
+			}
+			return f(s.raft.Status())
+		}
+	}
+	r.NewGaugeFunc("zk_raft_leader", "1 if this node is the leader, 0 if not.", raft(func(st cluster.Status) []metrics.Sample {
+		if st.Role == cluster.Leader {
+			return metrics.Value(1)
+		}
+		return metrics.Value(0)
+	}))
+	r.NewGaugeFunc("zk_raft_term", "The latest term this node has seen.", raft(func(st cluster.Status) []metrics.Sample {
+		return metrics.Value(float64(st.CurrentTerm))
+	}))
+	r.NewGaugeFunc("zk_raft_commit_index", "The highest committed TxID.", raft(func(st cluster.Status) []metrics.Sample {
+		return metrics.Value(float64(st.CommitIndex))
+	}))
+	r.NewGaugeFunc("zk_raft_last_applied", "The highest TxID applied to the tree.", raft(func(st cluster.Status) []metrics.Sample {
+		return metrics.Value(float64(st.LastApplied))
+	}))
+	r.NewGaugeFunc("zk_raft_last_log_txid", "The last TxID in the log, committed or not.", raft(func(st cluster.Status) []metrics.Sample {
+		return metrics.Value(float64(st.LastLogTxID))
+	}))
+	r.NewGaugeFunc("zk_raft_replication_lag", "Entries each peer is missing. Only on the leader.", raft(func(st cluster.Status) []metrics.Sample {
+		if st.Role != cluster.Leader {
+			return nil
+		}
+		samples := make([]metrics.Sample, len(st.Peers))
+		for i, p := range st.Peers {
+			samples[i] = metrics.Sample{Labels: []metrics.Label{{Name: "peer", Value: string(p.ID)}}, Value: float64(p.Lag)}
+		}
+		return samples
+	}))
+	r.NewCounterFunc("zk_raft_elections_total", "Elections this node started.", raft(func(st cluster.Status) []metrics.Sample {
+		return metrics.Value(float64(st.Elections))
+	}))
+	r.NewCounterFunc("zk_raft_elections_won_total", "Elections this node won.", raft(func(st cluster.Status) []metrics.Sample {
+		return metrics.Value(float64(st.ElectionsWon))
+	}))
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+)
+
+// get fetches path from an AdminHandler and returns the status code and body.
+func get(t *testing.T, h http.Handler, path string) (int, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	body, _ := io.ReadAll(rec.Body)
+	return rec.Code, string(body)
+}
+
+// TestMonitor_Standalone goes through a real listener, so the
+// interceptor sees the calls.
+func TestMonitor_Standalone(t *testing.T) {
+	srv := newStandalone(t)
+	addr := serve(t, srv)
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	zk, admin := zkpb.NewZooKeeperClient(conn), zkpb.NewAdminClient(conn)
+	ctx := context.Background()
+
+	zk.Create(ctx, &zkpb.CreateRequest{Path: "/app"})
+	zk.Create(ctx, &zkpb.CreateRequest{Path: "/app"}) // AlreadyExists
+
+	health, err := admin.Health(ctx, &zkpb.HealthRequest{})
+	if err != nil || !health.Ready {
+		t.Fatalf("a standalone server should be ready: %v, %v", health, err)
+	}
+
+	st, err := admin.Status(ctx, &zkpb.StatusRequest{})
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if st.Role != "standalone" || st.CommitIndex == 0 || st.CommitIndex != st.LastLogTxid || st.Store.Znodes != 2 || st.Store.WalBytes == 0 {
+		t.Fatalf("unexpected status: %v", st)
+	}
+
+	m, err := admin.Metrics(ctx, &zkpb.MetricsRequest{})
+	if err != nil {
+		t.Fatalf("Metrics failed: %v", err)
+	}
+	for _, want := range []string{
+		`zk_grpc_request_duration_seconds_count{service="zk.ZooKeeper",method="Create",code="OK"} 1`,
+		`zk_grpc_request_duration_seconds_count{service="zk.ZooKeeper",method="Create",code="AlreadyExists"} 1`,
+		"zk_znode_count 2",
+		"# TYPE zk_wal_fsync_seconds histogram",
+	} {
+		if !strings.Contains(m.Text, want) {
+			t.Errorf("metrics missing %q:\n%s", want, m.Text)
+		}
+	}
+	if strings.Contains(m.Text, "zk_raft_") {
+		t.Errorf("a standalone server has no Raft metrics:\n%s", m.Text)
+	}
+
+	// The same over HTTP.
+	h := srv.AdminHandler()
+	if code, body := get(t, h, "/healthz"); code != 200 || body != "imok\n" {
+		t.Fatalf("/healthz: %d %q", code, body)
+	}
+	if code, _ := get(t, h, "/readyz"); code != 200 {
+		t.Fatalf("/readyz: %d", code)
+	}
+	code, body := get(t, h, "/state")
+	var state map[string]any
+	if err := json.Unmarshal([]byte(body), &state); code != 200 || err != nil {
+		t.Fatalf("/state: %d %v\n%s", code, err, body)
+	}
+	if state["role"] != "standalone" || state["commit_index"] != fmt.Sprint(st.CommitIndex) {
+		t.Fatalf("/state: %s", body)
+	}
+	if code, body := get(t, h, "/metrics"); code != 200 || !strings.Contains(body, "zk_znode_count 2") {
+		t.Fatalf("/metrics: %d\n%s", code, body)
+	}
+}
+
+func TestMonitor_Cluster(t *testing.T) {
+	nodes := newTestCluster(t)
+	leader := waitForLeader(t, nodes)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := leader.server.Create(ctx, &zkpb.CreateRequest{Path: "/app"}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	// Every node knows the leader; only the leader knows the lag.
+	for _, n := range nodes {
+		health, _ := n.server.Health(ctx, &zkpb.HealthRequest{})
+		if !health.Ready {
+			t.Fatalf("%s not ready: %s", n.id, health.Reason)
+		}
+		st, _ := n.server.Status(ctx, &zkpb.StatusRequest{})
+		if st.Id != string(n.id) || st.LeaderId != string(leader.id) || len(st.Peers) != 2 {
+			t.Fatalf("%s: unexpected status %v", n.id, st)
+		}
+	}
+	st, _ := leader.server.Status(ctx, &zkpb.StatusRequest{})
+	if st.Role != "leader" || st.ElectionsWon != 1 {
+		t.Fatalf("unexpected leader status: %v", st)
+	}
+	m, _ := leader.server.Metrics(ctx, &zkpb.MetricsRequest{})
+	for _, want := range []string{"zk_raft_leader 1", "zk_raft_elections_won_total 1", fmt.Sprintf("zk_raft_term %d", st.Term)} {
+		if !strings.Contains(m.Text, want) {
+			t.Errorf("metrics missing %q:\n%s", want, m.Text)
+		}
+	}
+	for _, p := range st.Peers {
+		if !strings.Contains(m.Text, fmt.Sprintf(`zk_raft_replication_lag{peer=%q}`, p.Id)) {
+			t.Errorf("no lag for %s:\n%s", p.Id, m.Text)
+		}
+	}
+
+	// With the leader gone, a follower is alive but not ready.
+	others := followers(nodes, leader)
+	follower := others[0]
+	leader.stop()
+	others[1].stop()
+	waitFor(t, func() bool {
+		health, _ := follower.server.Health(ctx, &zkpb.HealthRequest{})
+		return !health.Ready
+	})
+	h := follower.server.AdminHandler()
+	if code, _ := get(t, h, "/healthz"); code != 200 {
+		t.Fatalf("/healthz without a leader: %d", code)
+	}
+	if code, body := get(t, h, "/readyz"); code != 503 || !strings.Contains(body, "no leader") {
+		t.Fatalf("/readyz without a leader: %d %q", code, body)
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+// The Multi RPC: several writes as one.
+//
+//   MultiRequest{create /app/config, set /app/version v6}
+//       ↓ one wal.Entry{Op: MULTI, Ops: [...]}
+//   Standalone: store.Multi          Cluster: raft.ProposeEntry
+//       ↓                                ↓
+//   all applied → MultiResponse{results}, one per op
+//   one failed  → nothing applied, error for that op
+//
+// A failed op gets the same code it would have alone (a create of an
+// existing node is AlreadyExists, a bad version is Aborted, ...), plus
+// an ErrorInfo saying which op it was.
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+	"github.com/syamsularifin/zookeeper/internal/wal"
+	"github.com/syamsularifin/zookeeper/internal/znode"
+)
+
+// MultiOpFailedReason is the ErrorInfo reason attached to a failed
+// Multi. metadata["index"] is the position of the op that failed.
+const MultiOpFailedReason = "MULTI_OP_FAILED"
+
+func (s *Server) Multi(ctx context.Context, req *zkpb.MultiRequest) (*zkpb.MultiResponse, error) {
+	ops, err := multiOps(req.Ops)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkMulti(ctx, ops); err != nil {
+		return nil, err
+	}
+	var creates []*zkpb.CreateRequest
+	for _, op := range req.Ops {
+		if c := op.GetCreate(); c != nil {
+			creates = append(creates, c)
+		}
+	}
+	leader, fctx, err := s.ownSessions(ctx, creates...)
+	if err != nil {
+		return nil, err
+	}
+	if leader != nil {
+		return leader.Multi(fctx, req)
+	}
+
+	var results []wal.OpResult
+	if s.raft != nil {
+		applied, err := s.raft.ProposeEntry(wal.Entry{Op: wal.OpMulti, Ops: ops})
+		if err != nil {
+			if leader, fctx, ok := s.leaderFor(ctx, err); ok {
+				return leader.Multi(fctx, req)
+			}
+			var me *znode.MultiError
+			if !errors.As(err, &me) {
+				return nil, clusterError(err, codes.Internal)
+			}
+			return nil, multiError(me, req.Ops)
+		}
+		results = applied.Results
+	} else {
+		results, err = s.store.Multi(ops)
+		if err != nil {
+			var me *znode.MultiError
+			if !errors.As(err, &me) {
+				return nil, status.Errorf(codes.Internal, "%v", err)
+			}
+			return nil, multiError(me, req.Ops)
+		}
+	}
+
+	resp := &zkpb.MultiResponse{Results: make([]*zkpb.OpResult, len(results))}
+	for i, r := range results {
+		resp.Results[i] = &zkpb.OpResult{Path: r.Path, Version: r.Version}
+	}
+	return resp, nil
+}
+
+// multiOps converts a request's ops into WAL ops, checking each is
+// well-formed before anything is logged.
+func multiOps(ops []*zkpb.Op) ([]wal.Op, error) {
+	if len(ops) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "multi needs at least one op")
+	}
+
+	out := make([]wal.Op, len(ops))
+	for i, op := range ops {
+		switch o := op.GetOp().(type) {
+		case *zkpb.Op_Create:
+			c := o.Create
+			var session int64
+			if c.Ephemeral {
+				if c.SessionId == 0 {
+					return nil, status.Errorf(codes.InvalidArgument, "op %d: ephemeral node %q needs a session", i, c.Path)
+				}
+				session = c.SessionId
+			}
+			acls, err := aclFromProto(c.Acl)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "op %d: %s", i, status.Convert(err).Message())
+			}
+			out[i] = wal.Op{Op: wal.OpCreate, Path: c.Path, Data: c.Data, Version: znode.AnyVersion, Session: session, Sequential: c.Sequential, ACL: acls}
+		case *zkpb.Op_Set:
+			out[i] = wal.Op{Op: wal.OpSet, Path: o.Set.Path, Data: o.Set.Data, Version: versionOf(o.Set.Version)}
+		case *zkpb.Op_Delete:
+			out[i] = wal.Op{Op: wal.OpDelete, Path: o.Delete.Path, Version: versionOf(o.Delete.Version)}
+		case *zkpb.Op_Check:
+			out[i] = wal.Op{Op: wal.OpCheck, Path: o.Check.Path, Version: versionOf(o.Check.Version)}
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "op %d is empty", i)
+		}
+	}
+	return out, nil
+}
+
+// multiError turns a failed Multi into a gRPC status: the failing op's
+// own code, and its index in an ErrorInfo.
+func multiError(me *znode.MultiError, ops []*zkpb.Op) error {
+	opCode := codes.Internal
+	switch ops[me.Index].GetOp().(type) {
+	case *zkpb.Op_Create:
+		opCode = codes.AlreadyExists
+	case *zkpb.Op_Set, *zkpb.Op_Check:
+		opCode = codes.NotFound
+	case *zkpb.Op_Delete:
+		opCode = codes.FailedPrecondition
+	}
+
+	st := status.Convert(opError(me, opCode))
+	withInfo, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason:   MultiOpFailedReason,
+		Domain:   "zookeeper",
+		Metadata: map[string]string{"index": strconv.Itoa(me.Index)},
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return withInfo.Err()
+}
+
+// FailedOp returns the position of the op that failed a Multi. ok is
+// false if err isn't a failed Multi.
+func FailedOp(err error) (index int, ok bool) {
+	st, isStatus := status.FromError(err)
+	if !isStatus {
+		return 0, false
+	}
+	for _, d := range st.Details() {
+		if info, isInfo := d.(*errdetails.ErrorInfo); isInfo && info.Reason == MultiOpFailedReason {
+			index, err := strconv.Atoi(info.Metadata["index"])
+			return index, err == nil
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+)
+
+func TestMulti_AppliesAllOps(t *testing.T) {
+	srv := newStandalone(t)
+	ctx := context.Background()
+	srv.Create(ctx, &zkpb.CreateRequest{Path: "/version", Data: []byte("1")})
+
+	v0 := int32(0)
+	resp, err := srv.Multi(ctx, &zkpb.MultiRequest{Ops: []*zkpb.Op{
+		{Op: &zkpb.Op_Create{Create: &zkpb.CreateRequest{Path: "/config", Data: []byte("port=1")}}},
+		{Op: &zkpb.Op_Set{Set: &zkpb.SetRequest{Path: "/version", Data: []byte("2"), Version: &v0}}},
+	}})
+	if err != nil {
+		t.Fatalf("Multi failed: %v", err)
+	}
+	if len(resp.Results) != 2 || resp.Results[0].Path != "/config" || resp.Results[1].Version != 1 {
+		t.Fatalf("unexpected results %v", resp.Results)
+	}
+}
+
+func TestMulti_FailedOpIsReported(t *testing.T) {
+	srv := newStandalone(t)
+	ctx := context.Background()
+	srv.Create(ctx, &zkpb.CreateRequest{Path: "/version"})
+
+	v5 := int32(5)
+	_, err := srv.Multi(ctx, &zkpb.MultiRequest{Ops: []*zkpb.Op{
+		{Op: &zkpb.Op_Create{Create: &zkpb.CreateRequest{Path: "/config"}}},
+		{Op: &zkpb.Op_Check{Check: &zkpb.CheckRequest{Path: "/version", Version: &v5}}},
+	}})
+	if status.Code(err) != codes.Aborted {
+		t.Fatalf("expected Aborted for the bad version, got %v", err)
+	}
+	if index, ok := FailedOp(err); !ok || index != 1 {
+		t.Fatalf("expected op 1 to be reported, got %d (%v)", index, ok)
+	}
+	if _, err := srv.store.Get("/config"); err == nil {
+		t.Fatal("/config should not exist: the multi failed")
+	}
+
+	_, err = srv.Multi(ctx, &zkpb.MultiRequest{Ops: []*zkpb.Op{{}}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for an empty op, got %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+// Read consistency.
+//
+// Get and GetChildren answer from the local Store. What the Store must
+// have applied first depends on the request's consistency:
+//
+//   LOCAL      → nothing. Fast, but a follower or a deposed leader may
+//                be behind — even behind the client's own last write.
+//   READ_INDEX → everything committed before the read. The leader gets
+//                a read index from Raft (one heartbeat round); a follower
+//                gets it from the leader's Sync. Then wait until the
+//                local Store has applied that far, and read.
+//   LEASE      → same, but while the leader's lease holds it skips the
+//                heartbeat round. Only the leader has a lease, so a
+//                follower forwards the whole read to it. A leader whose
+//                lease ran out falls back to READ_INDEX.
+//
+//   Client ──Get(READ_INDEX)──→ Follower ──Sync──→ Leader
+//                                  │       ←─ 42 ──  (heartbeat round)
+//                                  wait until applied 42
+//   Client ←──── data ──────────── Follower
+//
+// Sync on its own is a READ_INDEX without the read: LOCAL reads after
+// it see everything committed before it.
+//
+// Standalone, the Store is the only copy — every read is up to date.
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+	"github.com/syamsularifin/zookeeper/internal/cluster"
+)
+
+// Sync waits until this server has applied every write the leader had
+// committed when Sync was called.
+func (s *Server) Sync(ctx context.Context, req *zkpb.SyncRequest) (*zkpb.SyncResponse, error) {
+	if s.raft == nil {
+		return &zkpb.SyncResponse{Zxid: s.store.LastWALTxID()}, nil
+	}
+
+	index, err := s.catchUp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &zkpb.SyncResponse{Zxid: index}, nil
+}
+
+// prepareRead gets this server ready to answer a read at consistency c.
+// If the leader has to answer instead, it returns the leader's client
+// and the context to call it with.
+func (s *Server) prepareRead(ctx context.Context, c zkpb.ReadConsistency) (zkpb.ZooKeeperClient, context.Context, error) {
+	if s.raft == nil {
+		return nil, nil, nil
+	}
+
+	switch c {
+	case zkpb.ReadConsistency_LOCAL:
+		return nil, nil, nil
+	case zkpb.ReadConsistency_READ_INDEX:
+		_, err := s.catchUp(ctx)
+		return nil, nil, err
+	case zkpb.ReadConsistency_LEASE:
+		index, err := s.raft.LeaseReadIndex()
+		if err == nil {
+			return nil, nil, waitError(s.raft.WaitApplied(ctx, index))
+		}
+		if leader, fctx, ok := s.leaderFor(ctx, err); ok {
+			return leader, fctx, nil
+		}
+		if !errors.Is(err, cluster.ErrLeaseExpired) {
+			return nil, nil, clusterError(err, codes.Unavailable)
+		}
+		_, err = s.catchUp(ctx)
+		return nil, nil, err
+	default:
+		return nil, nil, status.Errorf(codes.InvalidArgument, "unknown read consistency %v", c)
+	}
+}
+
+// catchUp waits until this server has applied everything committed
+// before the call, and returns how far that is.
+func (s *Server) catchUp(ctx context.Context) (int64, error) {
+	index, err := s.readIndex(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.raft.WaitApplied(ctx, index); err != nil {
+		return 0, waitError(err)
+	}
+	return index, nil
+}
+
+// readIndex returns an index that's safe to read at once applied. The
+// leader asks Raft; a follower asks the leader's Sync.
+func (s *Server) readIndex(ctx context.Context) (int64, error) {
+	index, err := s.raft.ReadIndex()
+	if err == nil {
+		return index, nil
+	}
+
+	leader, fctx, ok := s.leaderFor(ctx, err)
+	if !ok {
+		return 0, clusterError(err, codes.Unavailable)
+	}
+	resp, err := leader.Sync(fctx, &zkpb.SyncRequest{})
+	if err != nil {
+		return 0, err
+	}
+	return resp.Zxid, nil
+}
+
+// waitError turns a failed WaitApplied (the client gave up or went
+// away) into a gRPC status.
+func waitError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return status.FromContextError(err).Err()
+}
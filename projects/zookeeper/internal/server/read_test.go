@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+)
+
+// TestRead_FollowerSeesLatestWrite proves READ_INDEX and LEASE reads on
+// a follower see a write the moment the leader has committed it — no
+// polling, unlike LOCAL reads.
+func TestRead_FollowerSeesLatestWrite(t *testing.T) {
+	nodes := newTestCluster(t)
+	leader := waitForLeader(t, nodes)
+	follower := followers(nodes, leader)[0]
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	leader.server.Create(ctx, &zkpb.CreateRequest{Path: "/app"})
+	for i, c := range []zkpb.ReadConsistency{
+		zkpb.ReadConsistency_READ_INDEX, zkpb.ReadConsistency_LEASE,
+		zkpb.ReadConsistency_READ_INDEX, zkpb.ReadConsistency_LEASE,
+	} {
+		want := fmt.Sprintf("v%d", i)
+		if _, err := leader.server.Set(ctx, &zkpb.SetRequest{Path: "/app", Data: []byte(want)}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		if _, err := leader.server.Create(ctx, &zkpb.CreateRequest{Path: "/app/" + want}); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+
+		resp, err := follower.server.Get(ctx, &zkpb.GetRequest{Path: "/app", Consistency: c})
+		if err != nil || string(resp.Data) != want {
+			t.Fatalf("%v read: expected %q, got %v (%v)", c, want, resp, err)
+		}
+		children, err := follower.server.GetChildren(ctx, &zkpb.GetChildrenRequest{Path: "/app", Consistency: c})
+		if err != nil || len(children.Children) != i+1 {
+			t.Fatalf("%v read: expected %d children, got %v (%v)", c, i+1, children, err)
+		}
+	}
+}
+
+// TestRead_SyncCatchesFollowerUp proves that after Sync returns, the
+// follower's own store has every write committed before it.
+func TestRead_SyncCatchesFollowerUp(t *testing.T) {
+	nodes := newTestCluster(t)
+	leader := waitForLeader(t, nodes)
+	follower := followers(nodes, leader)[0]
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	leader.server.Create(ctx, &zkpb.CreateRequest{Path: "/app", Data: []byte("v1")})
+	written := leader.store.LastWALTxID()
+
+	resp, err := follower.server.Sync(ctx, &zkpb.SyncRequest{})
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if resp.Zxid < written {
+		t.Fatalf("expected Sync to reach at least %d, got %d", written, resp.Zxid)
+	}
+	if data, err := follower.store.Get("/app"); err != nil || string(data) != "v1" {
+		t.Fatalf("expected /app = v1 on the follower after Sync, got %q (%v)", data, err)
+	}
+}
+
+func TestRead_Standalone(t *testing.T) {
+	srv := newStandalone(t)
+	ctx := context.Background()
+
+	srv.Create(ctx, &zkpb.CreateRequest{Path: "/app", Data: []byte("v1")})
+	resp, err := srv.Get(ctx, &zkpb.GetRequest{Path: "/app", Consistency: zkpb.ReadConsistency_READ_INDEX})
+	if err != nil || string(resp.Data) != "v1" {
+		t.Fatalf("expected v1, got %v (%v)", resp, err)
+	}
+	if sync, err := srv.Sync(ctx, &zkpb.SyncRequest{}); err != nil || sync.Zxid != 1 {
+		t.Fatalf("expected Sync at 1, got %v (%v)", sync, err)
+	}
+}
+
+func TestRead_UnknownConsistency(t *testing.T) {
+	nodes := newTestCluster(t)
+	leader := waitForLeader(t, nodes)
+
+	_, err := leader.server.Get(context.Background(), &zkpb.GetRequest{Path: "/", Consistency: 7})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+}
@@ -10,21 +10,57 @@ package server
 //   3. Return the result as a gRPC response (e.g. CreateResponse)
 //
 // This keeps the Store testable without needing a network.
+//
+// CLUSTER MODE:
+//
+// In a cluster, writes can't go straight to the local Store — the other
+// nodes would never hear about them. So when the Server has a RaftNode,
+// every write becomes a Raft proposal instead:
+//
+//   Standalone: Create → store.Create                    (local only)
+//   Cluster:    Create → raft.Propose → majority → apply (replicated)
+//
+// Reads still go to the local Store in both modes. In a cluster, the
+// read's consistency says how up to date the Store must be first (see
+// read.go).
+//
+// A follower that receives a write forwards it to the leader
+// (see forward.go), so clients can talk to any node.
+//
+// ACCESS:
+//
+// Before any of that, the caller must be allowed to: every call is
+// checked against the ACL of the node it touches (see acl.go), as the
+// identities the caller proved (see auth.go).
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"path"
+	"sync"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/status"
 
 	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+	"github.com/syamsularifin/zookeeper/internal/acl"
+	"github.com/syamsularifin/zookeeper/internal/cluster"
+	"github.com/syamsularifin/zookeeper/internal/metrics"
 	"github.com/syamsularifin/zookeeper/internal/store"
+	"github.com/syamsularifin/zookeeper/internal/wal"
+	"github.com/syamsularifin/zookeeper/internal/znode"
 )
 
-// Server implements the ZooKeeperServer gRPC interface.
+// NotLeaderReason is the ErrorInfo reason attached to writes rejected
+// by a follower. Clients look for it to find the leader.
+const NotLeaderReason = "NOT_LEADER"
+
+// Server implements the ZooKeeperServer and AdminServer gRPC interfaces.
 type Server struct {
 	// This embeds the "unimplemented" server generated by protoc.
 	// It provides default "not implemented" responses for any RPCs
@@ -32,16 +68,67 @@ type Server struct {
 	// if we add new RPCs to the proto but haven't implemented them.
 	zkpb.UnimplementedZooKeeperServer
 
+	// The same, for the Admin service (see admin.go).
+	zkpb.UnimplementedAdminServer
+
 	store *store.Store
 	port  int
+
+	// raft is set in cluster mode. nil means standalone:
+	// writes go directly to the store.
+	raft *cluster.RaftNode
+
+	// fwd forwards writes from a follower to the leader.
+	// Only set in cluster mode.
+	fwd *forwarder
+
+	// sessions holds session deadlines while this server is the leader.
+	// See session.go.
+	sessions *sessionTracker
+
+	// sec is how callers are authenticated. See auth.go.
+	sec Security
+
+	// metrics are this server's own, next to metrics.Default's.
+	// See monitor.go.
+	metrics  *metrics.Registry
+	requests *metrics.HistogramVec
+
+	// grpcServer is set once Serve is called. Used by Stop.
+	mu         sync.Mutex
+	grpcServer *grpc.Server
+
+	// stopCh stops the session expiry loop.
+	stopCh   chan struct{}
+	stopOnce sync.Once
 }
 
 // New creates a new gRPC server backed by the given Store.
 func New(s *store.Store, port int) *Server {
-	return &Server{
-		store: s,
-		port:  port,
+	srv := &Server{
+		store:    s,
+		port:     port,
+		sessions: newSessionTracker(),
+		stopCh:   make(chan struct{}),
+	}
+	srv.registerMetrics()
+	return srv
+}
+
+// NewCluster creates a gRPC server for a cluster node.
+// Writes are proposed through the RaftNode and only return after commit.
+// The RaftNode must use the same Store as its Storage.
+func NewCluster(s *store.Store, node *cluster.RaftNode, port int) *Server {
+	srv := &Server{
+		store:    s,
+		port:     port,
+		raft:     node,
+		fwd:      newForwarder(),
+		sessions: newSessionTracker(),
+		stopCh:   make(chan struct{}),
 	}
+	srv.registerMetrics()
+	return srv
 }
 
 // Start begins listening for gRPC connections.
@@ -61,61 +148,184 @@ func (s *Server) Start() error {
 		return fmt.Errorf("failed to listen on %s: %w", addr, err)
 	}
 
+	fmt.Printf("zookeeper node listening on %s\n", addr)
+
+	return s.Serve(lis)
+}
+
+// Serve handles RPCs on an existing listener. It blocks until Stop.
+//
+// Start uses this after opening its own port. Tests use it directly
+// with a listener on a random port.
+func (s *Server) Serve(lis net.Listener) error {
 	// Create the gRPC server and register our implementation.
-	grpcServer := grpc.NewServer()
+	opts := []grpc.ServerOption{grpc.ChainUnaryInterceptor(s.observe)}
+	if s.sec.TLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(s.sec.TLS)))
+	}
+	grpcServer := grpc.NewServer(opts...)
 	zkpb.RegisterZooKeeperServer(grpcServer, s)
+	zkpb.RegisterAdminServer(grpcServer, s)
 
-	fmt.Printf("zookeeper node listening on %s\n", addr)
+	s.mu.Lock()
+	s.grpcServer = grpcServer
+	s.mu.Unlock()
+
+	go s.expireSessions()
 
 	// Serve blocks forever, handling incoming RPCs.
 	return grpcServer.Serve(lis)
 }
 
+// Stop closes the listener, cancels in-flight RPCs, stops expiring
+// sessions and closes the connections used for forwarding.
+func (s *Server) Stop() {
+	s.mu.Lock()
+	g := s.grpcServer
+	s.mu.Unlock()
+
+	s.stopOnce.Do(func() { close(s.stopCh) })
+
+	if g != nil {
+		g.Stop()
+	}
+	if s.fwd != nil {
+		s.fwd.close()
+	}
+}
+
 // --- RPC implementations ---
 // Each method receives a protobuf request, calls the Store, and returns a protobuf response.
 // The `context.Context` parameter carries deadlines and cancellation — we don't use it yet
 // but it's required by the gRPC interface.
 
 func (s *Server) Create(ctx context.Context, req *zkpb.CreateRequest) (*zkpb.CreateResponse, error) {
-	err := s.store.Create(req.Path, req.Data)
+	acls, err := aclFromProto(req.Acl)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkACL(ctx, path.Dir(req.Path), acl.Create); err != nil {
+		return nil, err
+	}
+
+	var session int64
+	if req.Ephemeral {
+		if req.SessionId == 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "ephemeral node %q needs a session", req.Path)
+		}
+		session = req.SessionId
+	}
+	leader, fctx, err := s.ownSessions(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if leader != nil {
+		return leader.Create(fctx, req)
+	}
+
+	// The path comes back from the apply: a sequential node's name is
+	// only known once the entry is applied.
+	entry := wal.Entry{Op: wal.OpCreate, Path: req.Path, Data: req.Data, Session: session, Sequential: req.Sequential, ACL: acls}
+	if s.raft != nil {
+		applied, err := s.raft.ProposeEntry(entry)
+		if err != nil {
+			if leader, fctx, ok := s.leaderFor(ctx, err); ok {
+				return leader.Create(fctx, req)
+			}
+			return nil, clusterError(err, codes.AlreadyExists)
+		}
+		return &zkpb.CreateResponse{Path: applied.Path}, nil
+	}
+
+	created, err := s.store.CreateNode(entry)
 	if err != nil {
 		// Return a gRPC error with a status code.
 		// codes.AlreadyExists tells the client "this node already exists"
 		// which is more useful than a generic "internal error".
-		return nil, status.Errorf(codes.AlreadyExists, "%v", err)
+		return nil, opError(err, codes.AlreadyExists)
 	}
 
-	return &zkpb.CreateResponse{Path: req.Path}, nil
+	return &zkpb.CreateResponse{Path: created}, nil
 }
 
 func (s *Server) Get(ctx context.Context, req *zkpb.GetRequest) (*zkpb.GetResponse, error) {
-	data, err := s.store.Get(req.Path)
+	leader, fctx, err := s.prepareRead(ctx, req.Consistency)
+	if err != nil {
+		return nil, err
+	}
+	if leader != nil {
+		return leader.Get(fctx, req)
+	}
+	if err := s.checkACL(ctx, req.Path, acl.Read); err != nil {
+		return nil, err
+	}
+
+	data, stat, err := s.store.GetWithStat(req.Path)
 	if err != nil {
 		return nil, status.Errorf(codes.NotFound, "%v", err)
 	}
 
-	return &zkpb.GetResponse{Data: data}, nil
+	return &zkpb.GetResponse{Data: data, Stat: statToProto(stat)}, nil
 }
 
 func (s *Server) Set(ctx context.Context, req *zkpb.SetRequest) (*zkpb.SetResponse, error) {
-	err := s.store.Set(req.Path, req.Data)
+	if err := s.checkACL(ctx, req.Path, acl.Write); err != nil {
+		return nil, err
+	}
+	if s.raft != nil {
+		entry := wal.Entry{Op: wal.OpSet, Path: req.Path, Data: req.Data, Version: versionOf(req.Version)}
+		if err := s.proposeEntry(entry); err != nil {
+			if leader, fctx, ok := s.leaderFor(ctx, err); ok {
+				return leader.Set(fctx, req)
+			}
+			return nil, clusterError(err, codes.NotFound)
+		}
+		return &zkpb.SetResponse{}, nil
+	}
+
+	err := s.store.Set(req.Path, req.Data, versionOf(req.Version))
 	if err != nil {
-		return nil, status.Errorf(codes.NotFound, "%v", err)
+		return nil, opError(err, codes.NotFound)
 	}
 
 	return &zkpb.SetResponse{}, nil
 }
 
 func (s *Server) Delete(ctx context.Context, req *zkpb.DeleteRequest) (*zkpb.DeleteResponse, error) {
-	err := s.store.Delete(req.Path)
+	if err := s.checkACL(ctx, path.Dir(req.Path), acl.Delete); err != nil {
+		return nil, err
+	}
+	if s.raft != nil {
+		entry := wal.Entry{Op: wal.OpDelete, Path: req.Path, Version: versionOf(req.Version)}
+		if err := s.proposeEntry(entry); err != nil {
+			if leader, fctx, ok := s.leaderFor(ctx, err); ok {
+				return leader.Delete(fctx, req)
+			}
+			return nil, clusterError(err, codes.FailedPrecondition)
+		}
+		return &zkpb.DeleteResponse{}, nil
+	}
+
+	err := s.store.Delete(req.Path, versionOf(req.Version))
 	if err != nil {
-		return nil, status.Errorf(codes.FailedPrecondition, "%v", err)
+		return nil, opError(err, codes.FailedPrecondition)
 	}
 
 	return &zkpb.DeleteResponse{}, nil
 }
 
 func (s *Server) GetChildren(ctx context.Context, req *zkpb.GetChildrenRequest) (*zkpb.GetChildrenResponse, error) {
+	leader, fctx, err := s.prepareRead(ctx, req.Consistency)
+	if err != nil {
+		return nil, err
+	}
+	if leader != nil {
+		return leader.GetChildren(fctx, req)
+	}
+	if err := s.checkACL(ctx, req.Path, acl.Read); err != nil {
+		return nil, err
+	}
+
 	children, err := s.store.GetChildren(req.Path)
 	if err != nil {
 		return nil, status.Errorf(codes.NotFound, "%v", err)
@@ -123,3 +333,120 @@ func (s *Server) GetChildren(ctx context.Context, req *zkpb.GetChildrenRequest)
 
 	return &zkpb.GetChildrenResponse{Children: children}, nil
 }
+
+// --- Cluster mode helpers ---
+
+// proposeEntry sends a write through Raft. It returns only after the
+// entry is committed and applied on this node (or failed).
+func (s *Server) proposeEntry(entry wal.Entry) error {
+	_, err := s.raft.ProposeEntry(entry)
+	return err
+}
+
+// clusterError turns a Propose error into a gRPC status.
+//
+// Three kinds of failure, three different answers for the client:
+//
+//	NotLeaderError → FailedPrecondition + leader address in the details
+//	                 ("go ask node-2 at localhost:2182")
+//	ErrNoQuorum    → Unavailable ("cluster can't commit right now, retry")
+//	anything else  → the operation itself failed after commit
+//	                 (e.g. node already exists) → same as standalone
+func clusterError(err error, opCode codes.Code) error {
+	var nle *cluster.NotLeaderError
+	if errors.As(err, &nle) {
+		return notLeaderStatus(nle)
+	}
+	if errors.Is(err, cluster.ErrNoQuorum) {
+		return status.Errorf(codes.Unavailable, "%v", err)
+	}
+	return opError(err, opCode)
+}
+
+// opError turns a failed operation into a gRPC status. A version
+// mismatch is Aborted for every operation — the client's view of the
+// node is out of date, and re-reading then retrying may succeed.
+// A session that's gone gets the typed "session expired" error, and a
+// malformed ACL is InvalidArgument. Anything else gets the operation's
+// own code.
+func opError(err error, opCode codes.Code) error {
+	if errors.Is(err, znode.ErrBadVersion) {
+		return status.Errorf(codes.Aborted, "%v", err)
+	}
+	if errors.Is(err, acl.ErrInvalid) {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if errors.Is(err, store.ErrSessionExpired) {
+		return sessionExpiredStatus(err)
+	}
+	return status.Errorf(opCode, "%v", err)
+}
+
+// versionOf converts the optional version of a request. Unset means
+// the write doesn't care which version the node is at.
+func versionOf(v *int32) int32 {
+	if v == nil {
+		return znode.AnyVersion
+	}
+	return *v
+}
+
+// statToProto converts a znode.Stat into its wire form.
+func statToProto(st znode.Stat) *zkpb.Stat {
+	return &zkpb.Stat{
+		Czxid:       st.Czxid,
+		Mzxid:       st.Mzxid,
+		Ctime:       st.Ctime,
+		Mtime:       st.Mtime,
+		Version:     st.Version,
+		Cversion:    st.Cversion,
+		Aversion:    st.Aversion,
+		DataLength:  st.DataLength,
+		NumChildren: st.NumChildren,
+
+		EphemeralOwner: st.EphemeralOwner,
+	}
+}
+
+// notLeaderStatus builds the typed "not leader" error.
+//
+// The leader's identity travels in a standard google.rpc.ErrorInfo detail,
+// so any gRPC client (not just ours) can read it without parsing text:
+//
+//	ErrorInfo{
+//	  Reason:   "NOT_LEADER",
+//	  Metadata: {"leader_id": "node-2", "leader_addr": "localhost:2182"},
+//	}
+func notLeaderStatus(nle *cluster.NotLeaderError) error {
+	st := status.New(codes.FailedPrecondition, nle.Error())
+	withInfo, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: NotLeaderReason,
+		Domain: "zookeeper",
+		Metadata: map[string]string{
+			"leader_id":   string(nle.LeaderID),
+			"leader_addr": nle.LeaderAddr,
+		},
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return withInfo.Err()
+}
+
+// LeaderFromError extracts the leader hint from a "not leader" error
+// returned by a follower. ok is false if err is not a "not leader" error.
+// addr may be empty if the follower doesn't know where the leader is.
+//
+// Used by clients (zkcli) to redirect a write to the leader.
+func LeaderFromError(err error) (id string, addr string, ok bool) {
+	st, isStatus := status.FromError(err)
+	if !isStatus || st.Code() != codes.FailedPrecondition {
+		return "", "", false
+	}
+	for _, d := range st.Details() {
+		if info, isInfo := d.(*errdetails.ErrorInfo); isInfo && info.Reason == NotLeaderReason {
+			return info.Metadata["leader_id"], info.Metadata["leader_addr"], true
+		}
+	}
+	return "", "", false
+}
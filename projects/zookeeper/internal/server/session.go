@@ -0,0 +1,383 @@
+package server
+
+// Session liveness: who's alive, and who timed out.
+//
+// The Store knows which sessions exist (see internal/store/session.go).
+// This file decides when one has ended because its client went quiet:
+//
+//   Client                Leader
+//     │── CreateSession ──→ propose CREATE_SESSION, deadline = now + timeout
+//     │── KeepAlive ──────→ deadline = now + timeout
+//     │── KeepAlive ──────→ deadline = now + timeout
+//     ✗ (crash)
+//                           ... deadline passes ...
+//                           propose CLOSE_SESSION → ephemerals deleted
+//
+// ONLY THE LEADER TRACKS DEADLINES:
+//
+// Keepalives arrive every few seconds from every client. Putting each
+// one through Raft would swamp the log with writes that change nothing.
+// So deadlines live in the leader's memory only, and followers forward
+// keepalives to the leader like writes.
+//
+// FAILOVER:
+//
+// A new leader has no deadlines — they were in the old leader's memory.
+// It does have the replicated session table, so on taking over it gives
+// every session a fresh, full timeout. Clients that are alive keep
+// sending keepalives (to the new leader now) and never notice; sessions
+// whose clients are gone expire one timeout after the failover.
+//
+// PASSWORDS:
+//
+// A session id is a TxID: anyone can guess one, and a guessed id used to
+// be enough to keep someone else's session alive, close it, or hang
+// ephemeral nodes on it. Like ZooKeeper, CreateSession now hands out a
+// password with the id, and KeepAlive, CloseSession and ephemeral
+// creates must send it:
+//
+//   CreateSession            → id 42, password "9f3a…"
+//   KeepAlive 42 "9f3a…"     → ok
+//   KeepAlive 42 ""          → PermissionDenied
+//
+// The password is replicated with the session (internal/store/session.go)
+// and checked on the leader: only its table is sure to have a session
+// it just opened. Followers forward these calls, like keepalives.
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+	"github.com/syamsularifin/zookeeper/internal/cluster"
+	"github.com/syamsularifin/zookeeper/internal/store"
+	"github.com/syamsularifin/zookeeper/internal/wal"
+)
+
+const (
+	// DefaultSessionTimeout is used when a client doesn't ask for one.
+	DefaultSessionTimeout = 10 * time.Second
+
+	// MinSessionTimeout and MaxSessionTimeout bound what a client can
+	// ask for. Requests outside are clamped.
+	MinSessionTimeout = 200 * time.Millisecond
+	MaxSessionTimeout = 60 * time.Second
+
+	// SessionExpiredReason is the ErrorInfo reason attached to requests
+	// for a session that no longer exists.
+	SessionExpiredReason = "SESSION_EXPIRED"
+
+	// sessionCheckInterval is how often the leader looks for expired
+	// sessions. A session can outlive its timeout by up to this much.
+	sessionCheckInterval = 50 * time.Millisecond
+)
+
+// sessionTracker holds session deadlines. Only meaningful on the leader.
+type sessionTracker struct {
+	mu sync.Mutex
+
+	// term is the leadership term the deadlines were built in.
+	// -1 means "not leader, not tracking".
+	term int64
+
+	deadlines map[int64]time.Time
+	timeouts  map[int64]time.Duration
+}
+
+func newSessionTracker() *sessionTracker {
+	return &sessionTracker{
+		term:      -1,
+		deadlines: make(map[int64]time.Time),
+		timeouts:  make(map[int64]time.Duration),
+	}
+}
+
+// lead brings the deadlines in line with the open sessions.
+//
+// In a new term, every deadline starts over from now — we can't know
+// what the previous leader heard. Sessions that opened since the last
+// call (applied from the log) are added; closed ones are dropped.
+func (t *sessionTracker) lead(term int64, open map[int64]time.Duration, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if term != t.term {
+		t.term = term
+		clear(t.deadlines)
+		clear(t.timeouts)
+	}
+	for id, timeout := range open {
+		if _, ok := t.deadlines[id]; !ok {
+			t.deadlines[id] = now.Add(timeout)
+			t.timeouts[id] = timeout
+		}
+	}
+	for id := range t.deadlines {
+		if _, ok := open[id]; !ok {
+			delete(t.deadlines, id)
+			delete(t.timeouts, id)
+		}
+	}
+}
+
+// stepDown forgets every deadline. Whoever leads next rebuilds them.
+func (t *sessionTracker) stepDown() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.term = -1
+	clear(t.deadlines)
+	clear(t.timeouts)
+}
+
+// touch pushes a session's deadline to now + timeout.
+func (t *sessionTracker) touch(id int64, timeout time.Duration, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.deadlines[id] = now.Add(timeout)
+	t.timeouts[id] = timeout
+}
+
+// remove stops tracking a session.
+func (t *sessionTracker) remove(id int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.deadlines, id)
+	delete(t.timeouts, id)
+}
+
+// expired returns the sessions whose deadline has passed.
+func (t *sessionTracker) expired(now time.Time) []int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var ids []int64
+	for id, deadline := range t.deadlines {
+		if now.After(deadline) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// expireSessions runs until Stop, closing sessions that timed out.
+func (s *Server) expireSessions() {
+	ticker := time.NewTicker(sessionCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case now := <-ticker.C:
+			s.checkSessions(now)
+		}
+	}
+}
+
+// checkSessions is one round of expiry. Followers only drop their
+// deadlines; the leader closes every session past its deadline.
+func (s *Server) checkSessions(now time.Time) {
+	term, leader := s.leaderTerm()
+	if !leader {
+		s.sessions.stepDown()
+		return
+	}
+
+	s.sessions.lead(term, s.store.Sessions(), now)
+	for _, id := range s.sessions.expired(now) {
+		err := s.closeSession(id)
+		if err != nil && !errors.Is(err, store.ErrSessionExpired) {
+			continue // lost leadership or no quorum: try again next round
+		}
+		s.sessions.remove(id)
+	}
+}
+
+// leaderTerm reports whether this server is the one that tracks
+// sessions, and in which term. Standalone, it always is.
+func (s *Server) leaderTerm() (int64, bool) {
+	if s.raft == nil {
+		return 0, true
+	}
+	st := s.raft.GetState()
+	return st.CurrentTerm, st.Role == cluster.Leader
+}
+
+// closeSession ends a session through the log (or the store, standalone).
+func (s *Server) closeSession(id int64) error {
+	if s.raft != nil {
+		return s.proposeEntry(wal.Entry{Op: wal.OpCloseSession, Session: id})
+	}
+	return s.store.CloseSession(id)
+}
+
+// sessionTimeout turns a requested timeout into the one granted.
+func sessionTimeout(ms int64) time.Duration {
+	if ms <= 0 {
+		return DefaultSessionTimeout
+	}
+	return min(max(time.Duration(ms)*time.Millisecond, MinSessionTimeout), MaxSessionTimeout)
+}
+
+// --- RPC implementations ---
+
+// sessionPassword makes up a new session's password: 16 random bytes,
+// in hex.
+func sessionPassword() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// sessionLeader is where a session's password is checked. On the
+// leader (or standalone) it returns nothing; on a follower, the leader
+// to forward the call to, or an error if there's none.
+func (s *Server) sessionLeader(ctx context.Context) (zkpb.ZooKeeperClient, context.Context, error) {
+	if s.raft == nil {
+		return nil, nil, nil
+	}
+	if err := s.raft.CheckLeader(); err != nil {
+		if leader, fctx, ok := s.leaderFor(ctx, err); ok {
+			return leader, fctx, nil
+		}
+		return nil, nil, clusterError(err, codes.Internal)
+	}
+	return nil, nil, nil
+}
+
+// ownSessions checks the caller owns the session of every ephemeral
+// create in creates: it sent the session's password. Like
+// sessionLeader, it returns the leader to forward to on a follower.
+func (s *Server) ownSessions(ctx context.Context, creates ...*zkpb.CreateRequest) (zkpb.ZooKeeperClient, context.Context, error) {
+	if !slices.ContainsFunc(creates, (*zkpb.CreateRequest).GetEphemeral) {
+		return nil, nil, nil
+	}
+	if leader, fctx, err := s.sessionLeader(ctx); leader != nil || err != nil {
+		return leader, fctx, err
+	}
+	for _, c := range creates {
+		if !c.Ephemeral {
+			continue
+		}
+		if _, err := s.store.CheckSession(c.SessionId, c.SessionPassword); err != nil {
+			return nil, nil, sessionError(c.SessionId, err)
+		}
+	}
+	return nil, nil, nil
+}
+
+func (s *Server) CreateSession(ctx context.Context, req *zkpb.CreateSessionRequest) (*zkpb.CreateSessionResponse, error) {
+	timeout := sessionTimeout(req.TimeoutMs)
+	password := sessionPassword()
+
+	var id int64
+	if s.raft != nil {
+		entry, err := s.raft.ProposeEntry(wal.Entry{Op: wal.OpCreateSession, Timeout: timeout.Milliseconds(), Data: []byte(password)})
+		if err != nil {
+			if leader, fctx, ok := s.leaderFor(ctx, err); ok {
+				return leader.CreateSession(fctx, req)
+			}
+			return nil, clusterError(err, codes.Internal)
+		}
+		id = entry.TxID
+	} else {
+		var err error
+		if id, err = s.store.CreateSession(timeout, password); err != nil {
+			return nil, status.Errorf(codes.Internal, "%v", err)
+		}
+	}
+
+	s.sessions.touch(id, timeout, time.Now())
+	return &zkpb.CreateSessionResponse{SessionId: id, TimeoutMs: timeout.Milliseconds(), Password: password}, nil
+}
+
+func (s *Server) KeepAlive(ctx context.Context, req *zkpb.KeepAliveRequest) (*zkpb.KeepAliveResponse, error) {
+	leader, fctx, err := s.sessionLeader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if leader != nil {
+		return leader.KeepAlive(fctx, req)
+	}
+
+	// The table, not the tracker, decides: a session closed a moment
+	// ago may still have a deadline until the next check.
+	timeout, err := s.store.CheckSession(req.SessionId, req.Password)
+	if err != nil {
+		return nil, sessionError(req.SessionId, err)
+	}
+	s.sessions.touch(req.SessionId, timeout, time.Now())
+	return &zkpb.KeepAliveResponse{}, nil
+}
+
+func (s *Server) CloseSession(ctx context.Context, req *zkpb.CloseSessionRequest) (*zkpb.CloseSessionResponse, error) {
+	leader, fctx, err := s.sessionLeader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if leader != nil {
+		return leader.CloseSession(fctx, req)
+	}
+	if _, err := s.store.CheckSession(req.SessionId, req.Password); err != nil {
+		return nil, sessionError(req.SessionId, err)
+	}
+
+	if err := s.closeSession(req.SessionId); err != nil {
+		if leader, fctx, ok := s.leaderFor(ctx, err); ok {
+			return leader.CloseSession(fctx, req)
+		}
+		return nil, clusterError(err, codes.Internal)
+	}
+
+	s.sessions.remove(req.SessionId)
+	return &zkpb.CloseSessionResponse{}, nil
+}
+
+// sessionError turns a CheckSession error into a gRPC status: session
+// expired, or PermissionDenied for a wrong password.
+func sessionError(id int64, err error) error {
+	if errors.Is(err, store.ErrSessionPassword) {
+		return status.Errorf(codes.PermissionDenied, "session %d: %v", id, err)
+	}
+	return sessionExpiredStatus(fmt.Errorf("session %d: %w", id, err))
+}
+
+// sessionExpiredStatus builds the typed "session expired" error: NotFound
+// with an ErrorInfo, so clients can tell it from a missing znode.
+func sessionExpiredStatus(err error) error {
+	st := status.New(codes.NotFound, err.Error())
+	withInfo, derr := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: SessionExpiredReason,
+		Domain: "zookeeper",
+	})
+	if derr != nil {
+		return st.Err()
+	}
+	return withInfo.Err()
+}
+
+// IsSessionExpired reports whether err says the session is gone. A
+// client that gets it must start a new session; its ephemeral nodes
+// are already deleted.
+func IsSessionExpired(err error) bool {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.NotFound {
+		return false
+	}
+	for _, d := range st.Details() {
+		if info, isInfo := d.(*errdetails.ErrorInfo); isInfo && info.Reason == SessionExpiredReason {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+	"github.com/syamsularifin/zookeeper/internal/store"
+)
+
+func newStandalone(t *testing.T) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	s, err := store.New(filepath.Join(dir, "wal.log"), filepath.Join(dir, "snapshot.json"))
+	if err != nil {
+		t.Fatalf("store.New failed: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return New(s, 0)
+}
+
+// TestSession_ExpiresWithoutKeepAlive drives expiry by hand with
+// checkSessions and a clock in the future.
+func TestSession_ExpiresWithoutKeepAlive(t *testing.T) {
+	srv := newStandalone(t)
+	ctx := context.Background()
+
+	sess, err := srv.CreateSession(ctx, &zkpb.CreateSessionRequest{TimeoutMs: 500})
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	_, err = srv.Create(ctx, &zkpb.CreateRequest{Path: "/worker", Ephemeral: true, SessionId: sess.SessionId, SessionPassword: sess.Password})
+	if err != nil {
+		t.Fatalf("ephemeral Create failed: %v", err)
+	}
+
+	// Within the timeout: nothing happens.
+	srv.checkSessions(time.Now().Add(250 * time.Millisecond))
+	if _, err := srv.KeepAlive(ctx, &zkpb.KeepAliveRequest{SessionId: sess.SessionId, Password: sess.Password}); err != nil {
+		t.Fatalf("KeepAlive failed: %v", err)
+	}
+	srv.checkSessions(time.Now().Add(400 * time.Millisecond)) // 250+400 > 500, but the keepalive reset it
+	if _, err := srv.store.Get("/worker"); err != nil {
+		t.Fatalf("/worker should still be there: %v", err)
+	}
+
+	// Past the timeout: the session and its node are gone.
+	srv.checkSessions(time.Now().Add(time.Second))
+	if _, err := srv.store.Get("/worker"); err == nil {
+		t.Fatal("/worker should be gone with its session")
+	}
+
+	_, err = srv.KeepAlive(ctx, &zkpb.KeepAliveRequest{SessionId: sess.SessionId, Password: sess.Password})
+	if !IsSessionExpired(err) {
+		t.Fatalf("expected session expired, got %v", err)
+	}
+	_, err = srv.Create(ctx, &zkpb.CreateRequest{Path: "/worker", Ephemeral: true, SessionId: sess.SessionId, SessionPassword: sess.Password})
+	if !IsSessionExpired(err) {
+		t.Fatalf("expected session expired, got %v", err)
+	}
+}
+
+func TestSession_CloseDeletesEphemerals(t *testing.T) {
+	srv := newStandalone(t)
+	ctx := context.Background()
+
+	sess, _ := srv.CreateSession(ctx, &zkpb.CreateSessionRequest{})
+	if sess.TimeoutMs != DefaultSessionTimeout.Milliseconds() {
+		t.Fatalf("expected the default timeout, got %dms", sess.TimeoutMs)
+	}
+	srv.Create(ctx, &zkpb.CreateRequest{Path: "/lock", Ephemeral: true, SessionId: sess.SessionId, SessionPassword: sess.Password})
+
+	resp, err := srv.Get(ctx, &zkpb.GetRequest{Path: "/lock"})
+	if err != nil || resp.Stat.GetEphemeralOwner() != sess.SessionId {
+		t.Fatalf("expected /lock owned by %d, got %v (%v)", sess.SessionId, resp.GetStat(), err)
+	}
+
+	if _, err := srv.CloseSession(ctx, &zkpb.CloseSessionRequest{SessionId: sess.SessionId, Password: sess.Password}); err != nil {
+		t.Fatalf("CloseSession failed: %v", err)
+	}
+	if _, err := srv.store.Get("/lock"); err == nil {
+		t.Fatal("/lock should be gone with its session")
+	}
+}
+
+// TestSession_NeedsPassword proves a session id alone, easy to guess,
+// doesn't let anyone keep the session alive, close it or create
+// ephemeral nodes in it.
+func TestSession_NeedsPassword(t *testing.T) {
+	srv := newStandalone(t)
+	ctx := context.Background()
+
+	sess, _ := srv.CreateSession(ctx, &zkpb.CreateSessionRequest{})
+	if len(sess.Password) != 32 {
+		t.Fatalf("expected a 16-byte hex password, got %q", sess.Password)
+	}
+	other, _ := srv.CreateSession(ctx, &zkpb.CreateSessionRequest{})
+	if other.Password == sess.Password {
+		t.Fatal("two sessions got the same password")
+	}
+
+	for _, guess := range []string{"", other.Password} {
+		calls := map[string]error{
+			"KeepAlive":    second(srv.KeepAlive(ctx, &zkpb.KeepAliveRequest{SessionId: sess.SessionId, Password: guess})),
+			"CloseSession": second(srv.CloseSession(ctx, &zkpb.CloseSessionRequest{SessionId: sess.SessionId, Password: guess})),
+			"Create": second(srv.Create(ctx, &zkpb.CreateRequest{
+				Path: "/lock", Ephemeral: true, SessionId: sess.SessionId, SessionPassword: guess,
+			})),
+			"Multi": second(srv.Multi(ctx, &zkpb.MultiRequest{Ops: []*zkpb.Op{
+				{Op: &zkpb.Op_Create{Create: &zkpb.CreateRequest{Path: "/lock", Ephemeral: true, SessionId: sess.SessionId, SessionPassword: guess}}},
+			}})),
+		}
+		for name, err := range calls {
+			if status.Code(err) != codes.PermissionDenied {
+				t.Fatalf("%s with password %q: expected PermissionDenied, got %v", name, guess, err)
+			}
+		}
+	}
+
+	if _, ok := srv.store.Session(sess.SessionId); !ok {
+		t.Fatal("the session should still be open")
+	}
+	if _, err := srv.store.Get("/lock"); err == nil {
+		t.Fatal("/lock should not have been created")
+	}
+}
+
+func TestSessionTimeoutIsClamped(t *testing.T) {
+	if got := sessionTimeout(1); got != MinSessionTimeout {
+		t.Fatalf("expected %v, got %v", MinSessionTimeout, got)
+	}
+	if got := sessionTimeout(int64(time.Hour / time.Millisecond)); got != MaxSessionTimeout {
+		t.Fatalf("expected %v, got %v", MaxSessionTimeout, got)
+	}
+}
@@ -0,0 +1,234 @@
+package server
+
+// Stress tests: many clients at once, each RPC on its own goroutine, as
+// grpc-go serves them. Run with -race — the point is as much what the
+// race detector sees as what the checks at the end find.
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+)
+
+const (
+	stressClients = 16
+	stressRounds  = 25
+)
+
+// hammer runs stressRounds of mixed operations from each of
+// stressClients goroutines against zks (client i uses zks[i%len(zks)]).
+// The reads that check a client's own writes use consistency. Every
+// round:
+//
+//   - increments /counter with a compare-and-set loop
+//   - creates a sequential /seq/n- node
+//   - creates, reads, updates and deletes its own /c<i>/<round>
+//   - creates /c<i>/m<round> and sets /c<i> in one Multi
+//   - lists "/" and reads /counter
+//
+// Failures go to errs: t.Fatal can't be called off the test goroutine.
+func hammer(t *testing.T, zks []zkpb.ZooKeeperClient, consistency zkpb.ReadConsistency) {
+	t.Helper()
+	ctx := context.Background()
+
+	for _, p := range []string{"/counter", "/seq"} {
+		if _, err := zks[0].Create(ctx, &zkpb.CreateRequest{Path: p, Data: []byte("0")}); err != nil {
+			t.Fatalf("Create %s failed: %v", p, err)
+		}
+	}
+
+	errs := make(chan error, stressClients)
+	var wg sync.WaitGroup
+	for i := 0; i < stressClients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := stressClient(ctx, zks[i%len(zks)], i, consistency); err != nil {
+				errs <- fmt.Errorf("client %d: %w", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// stressClient is one client of hammer.
+func stressClient(ctx context.Context, zk zkpb.ZooKeeperClient, i int, consistency zkpb.ReadConsistency) error {
+	home := fmt.Sprintf("/c%d", i)
+	if _, err := zk.Create(ctx, &zkpb.CreateRequest{Path: home}); err != nil {
+		return err
+	}
+
+	for round := 0; round < stressRounds; round++ {
+		if err := increment(ctx, zk, "/counter", consistency); err != nil {
+			return err
+		}
+
+		if _, err := zk.Create(ctx, &zkpb.CreateRequest{Path: "/seq/n-", Sequential: true}); err != nil {
+			return err
+		}
+
+		p := fmt.Sprintf("%s/%d", home, round)
+		if _, err := zk.Create(ctx, &zkpb.CreateRequest{Path: p, Data: []byte("a")}); err != nil {
+			return err
+		}
+		if _, err := zk.Set(ctx, &zkpb.SetRequest{Path: p, Data: []byte("b"), Version: proto.Int32(0)}); err != nil {
+			return err
+		}
+		got, err := zk.Get(ctx, &zkpb.GetRequest{Path: p, Consistency: consistency})
+		if err != nil {
+			return err
+		}
+		if string(got.Data) != "b" || got.Stat.Version != 1 {
+			return fmt.Errorf("%s is %q at version %d, expected \"b\" at 1", p, got.Data, got.Stat.Version)
+		}
+		if _, err := zk.Delete(ctx, &zkpb.DeleteRequest{Path: p, Version: proto.Int32(1)}); err != nil {
+			return err
+		}
+
+		_, err = zk.Multi(ctx, &zkpb.MultiRequest{Ops: []*zkpb.Op{
+			{Op: &zkpb.Op_Create{Create: &zkpb.CreateRequest{Path: fmt.Sprintf("%s/m%d", home, round)}}},
+			{Op: &zkpb.Op_Set{Set: &zkpb.SetRequest{Path: home, Data: []byte(strconv.Itoa(round)), Version: proto.Int32(int32(round))}}},
+		}})
+		if err != nil {
+			return err
+		}
+
+		if _, err := zk.GetChildren(ctx, &zkpb.GetChildrenRequest{Path: "/"}); err != nil {
+			return err
+		}
+		if _, err := zk.Get(ctx, &zkpb.GetRequest{Path: "/counter"}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// increment adds one to the number at p: read, then write if nobody
+// else wrote in between, else try again.
+func increment(ctx context.Context, zk zkpb.ZooKeeperClient, p string, consistency zkpb.ReadConsistency) error {
+	for {
+		got, err := zk.Get(ctx, &zkpb.GetRequest{Path: p, Consistency: consistency})
+		if err != nil {
+			return err
+		}
+		n, err := strconv.Atoi(string(got.Data))
+		if err != nil {
+			return err
+		}
+		_, err = zk.Set(ctx, &zkpb.SetRequest{
+			Path:    p,
+			Data:    []byte(strconv.Itoa(n + 1)),
+			Version: proto.Int32(got.Stat.Version),
+		})
+		if status.Code(err) == codes.Aborted {
+			continue // lost the race
+		}
+		return err
+	}
+}
+
+// checkHammered checks what hammer left behind, as zk sees it.
+func checkHammered(t *testing.T, zk zkpb.ZooKeeperClient) {
+	t.Helper()
+	ctx := context.Background()
+	total := stressClients * stressRounds
+
+	// No increment lost, none applied twice.
+	got, err := zk.Get(ctx, &zkpb.GetRequest{Path: "/counter"})
+	if err != nil {
+		t.Fatalf("Get /counter failed: %v", err)
+	}
+	if string(got.Data) != strconv.Itoa(total) || got.Stat.Version != int32(total) {
+		t.Errorf("/counter is %s at version %d, expected %d at %d", got.Data, got.Stat.Version, total, total)
+	}
+
+	// Every sequential create got its own number.
+	seq, err := zk.GetChildren(ctx, &zkpb.GetChildrenRequest{Path: "/seq"})
+	if err != nil {
+		t.Fatalf("GetChildren /seq failed: %v", err)
+	}
+	if len(seq.Children) != total {
+		t.Errorf("/seq has %d children, expected %d", len(seq.Children), total)
+	}
+	for n := 0; n < total; n++ {
+		if _, err := zk.Get(ctx, &zkpb.GetRequest{Path: fmt.Sprintf("/seq/n-%010d", n)}); err != nil {
+			t.Errorf("sequence number %d is missing: %v", n, err)
+		}
+	}
+
+	// Each client's nodes: the deleted ones gone, the Multi ones there.
+	for i := 0; i < stressClients; i++ {
+		home := fmt.Sprintf("/c%d", i)
+		children, err := zk.GetChildren(ctx, &zkpb.GetChildrenRequest{Path: home})
+		if err != nil {
+			t.Fatalf("GetChildren %s failed: %v", home, err)
+		}
+		if len(children.Children) != stressRounds {
+			t.Errorf("%s has %d children, expected %d", home, len(children.Children), stressRounds)
+		}
+	}
+}
+
+// TestStress_Standalone hammers one standalone server over gRPC.
+func TestStress_Standalone(t *testing.T) {
+	srv := newStandalone(t)
+	zk := dial(t, serve(t, srv))
+
+	// A persistent watch sees every change to /counter, once, in order.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := openWatch(t, ctx, zk, &zkpb.WatchRequest{Path: "/counter", Persistent: true})
+
+	hammer(t, []zkpb.ZooKeeperClient{zk}, zkpb.ReadConsistency_LOCAL)
+	checkHammered(t, zk)
+
+	expectEvent(t, stream, zkpb.EventType_NODE_CREATED, "/counter")
+	last := int64(0)
+	for n := 0; n < stressClients*stressRounds; n++ {
+		ev := expectEvent(t, stream, zkpb.EventType_NODE_DATA_CHANGED, "/counter")
+		if ev.Zxid <= last {
+			t.Fatalf("event %d at zxid %d, after one at %d", n, ev.Zxid, last)
+		}
+		last = ev.Zxid
+	}
+}
+
+// TestStress_Cluster hammers all three servers of a cluster at once:
+// followers forward, the leader proposes, every node applies while it
+// answers reads.
+func TestStress_Cluster(t *testing.T) {
+	if testing.Short() {
+		t.Skip("slow: runs a whole cluster")
+	}
+	nodes := newTestCluster(t)
+
+	var zks []zkpb.ZooKeeperClient
+	for _, n := range nodes {
+		zks = append(zks, dial(t, n.clientAddr))
+	}
+	// A follower answers LOCAL reads from what it has applied, which
+	// may not have a client's last write yet.
+	hammer(t, zks, zkpb.ReadConsistency_READ_INDEX)
+
+	// Every node applies the same writes: once it has the last one,
+	// each looks the same.
+	if _, err := zks[0].Create(context.Background(), &zkpb.CreateRequest{Path: "/done", Data: []byte("y")}); err != nil {
+		t.Fatalf("Create /done failed: %v", err)
+	}
+	for _, n := range nodes {
+		waitForData(t, n, "/done", "y")
+		checkHammered(t, dial(t, n.clientAddr))
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+// The Watch RPC: one server-streaming call per watch.
+//
+//   Client                              Server
+//     │── Watch(/app, DATA) ─────────→  store.Watch → registered at zxid 41
+//     │←── REGISTERED zxid 41 ────────
+//     │                                  ... SET /app applied (zxid 42) ...
+//     │←── NODE_DATA_CHANGED zxid 42 ──
+//     │                                  one-shot: stream ends here
+//
+// The stream lives exactly as long as the watch. The client cancels a
+// persistent watch by cancelling the call; the server ends a one-shot
+// watch after its event, and any watch that overflows (see
+// internal/watch) with RESOURCE_EXHAUSTED.
+//
+// Watches are served by whichever node the client is connected to —
+// no forwarding. Every node applies every committed write, so every
+// node fires the same events.
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+	"github.com/syamsularifin/zookeeper/internal/acl"
+	"github.com/syamsularifin/zookeeper/internal/watch"
+)
+
+func (s *Server) Watch(req *zkpb.WatchRequest, stream zkpb.ZooKeeper_WatchServer) error {
+	kind, ok := watchKinds[req.Type]
+	if !ok {
+		return status.Errorf(codes.InvalidArgument, "unknown watch type %v", req.Type)
+	}
+	if err := s.checkACL(stream.Context(), req.Path, acl.Read); err != nil {
+		return err
+	}
+
+	w := s.store.Watch(req.Path, kind, req.Persistent)
+	defer w.Cancel()
+
+	err := stream.Send(&zkpb.WatchEvent{Type: zkpb.EventType_REGISTERED, Path: req.Path, Zxid: w.Zxid})
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-s.stopCh:
+			return status.Error(codes.Unavailable, "server stopping")
+		case ev, ok := <-w.Events:
+			if !ok {
+				if err := w.Err(); err != nil {
+					return status.Errorf(codes.ResourceExhausted, "%v", err)
+				}
+				return nil // one-shot watch fired
+			}
+			if err := stream.Send(eventToProto(ev)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// watchKinds maps the wire watch type to what the store watches.
+var watchKinds = map[zkpb.WatchType]watch.Kind{
+	zkpb.WatchType_DATA:     watch.Data,
+	zkpb.WatchType_CHILDREN: watch.Children,
+	zkpb.WatchType_ALL:      watch.All,
+}
+
+// eventTypes maps a store event to its wire form.
+var eventTypes = map[watch.EventType]zkpb.EventType{
+	watch.NodeCreated:         zkpb.EventType_NODE_CREATED,
+	watch.NodeDeleted:         zkpb.EventType_NODE_DELETED,
+	watch.NodeDataChanged:     zkpb.EventType_NODE_DATA_CHANGED,
+	watch.NodeChildrenChanged: zkpb.EventType_NODE_CHILDREN_CHANGED,
+}
+
+func eventToProto(ev watch.Event) *zkpb.WatchEvent {
+	return &zkpb.WatchEvent{Type: eventTypes[ev.Type], Path: ev.Path, Zxid: ev.Zxid}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+	"github.com/syamsularifin/zookeeper/internal/znode"
+)
+
+// dial connects a client to addr. The connection closes with the test.
+func dial(t *testing.T, addr string) zkpb.ZooKeeperClient {
+	t.Helper()
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial %s: %v", addr, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return zkpb.NewZooKeeperClient(conn)
+}
+
+// serve runs srv on a loopback port until the test ends.
+func serve(t *testing.T, srv *Server) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+// openWatch opens a Watch stream and waits until it's registered.
+func openWatch(t *testing.T, ctx context.Context, zk zkpb.ZooKeeperClient, req *zkpb.WatchRequest) zkpb.ZooKeeper_WatchClient {
+	t.Helper()
+	stream, err := zk.Watch(ctx, req)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	ev, err := stream.Recv()
+	if err != nil || ev.Type != zkpb.EventType_REGISTERED {
+		t.Fatalf("expected REGISTERED first, got %v (%v)", ev, err)
+	}
+	return stream
+}
+
+// expectEvent receives the next event and checks its type and path.
+func expectEvent(t *testing.T, stream zkpb.ZooKeeper_WatchClient, typ zkpb.EventType, path string) *zkpb.WatchEvent {
+	t.Helper()
+	ev, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("expected %v %s, got %v", typ, path, err)
+	}
+	if ev.Type != typ || ev.Path != path {
+		t.Fatalf("expected %v %s, got %v %s", typ, path, ev.Type, ev.Path)
+	}
+	return ev
+}
+
+// TestWatch_OneShotEndsStream proves a one-shot watch delivers one
+// event and then the stream ends.
+func TestWatch_OneShotEndsStream(t *testing.T) {
+	srv := newStandalone(t)
+	zk := dial(t, serve(t, srv))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv.store.Create("/app", []byte("v1"))
+	stream := openWatch(t, ctx, zk, &zkpb.WatchRequest{Path: "/app", Type: zkpb.WatchType_DATA})
+
+	srv.store.Set("/app", []byte("v2"), znode.AnyVersion)
+	first, _ := srv.store.Stat("/app")
+	srv.store.Set("/app", []byte("v3"), znode.AnyVersion)
+
+	ev := expectEvent(t, stream, zkpb.EventType_NODE_DATA_CHANGED, "/app")
+	if ev.Zxid != first.Mzxid {
+		t.Fatalf("expected the event of the first Set (zxid %d), got zxid %d", first.Mzxid, ev.Zxid)
+	}
+	if ev, err := stream.Recv(); err == nil {
+		t.Fatalf("one-shot stream should have ended, got %v", ev)
+	}
+}
+
+// TestWatch_PersistentChildren proves a persistent children watch sees
+// every change to the child list, ephemeral nodes included.
+func TestWatch_PersistentChildren(t *testing.T) {
+	srv := newStandalone(t)
+	zk := dial(t, serve(t, srv))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv.store.Create("/workers", nil)
+	stream := openWatch(t, ctx, zk, &zkpb.WatchRequest{Path: "/workers", Type: zkpb.WatchType_CHILDREN, Persistent: true})
+
+	sess, _ := srv.CreateSession(ctx, &zkpb.CreateSessionRequest{})
+	srv.Create(ctx, &zkpb.CreateRequest{Path: "/workers/a", Ephemeral: true, SessionId: sess.SessionId, SessionPassword: sess.Password})
+	srv.store.Set("/workers", []byte("ignored"), znode.AnyVersion) // data, not children
+	srv.CloseSession(ctx, &zkpb.CloseSessionRequest{SessionId: sess.SessionId, Password: sess.Password})
+
+	expectEvent(t, stream, zkpb.EventType_NODE_CHILDREN_CHANGED, "/workers")
+	expectEvent(t, stream, zkpb.EventType_NODE_CHILDREN_CHANGED, "/workers")
+}
@@ -0,0 +1,527 @@
+package snapshot
+
+// THE PROBLEM WITH ONE JSON DOCUMENT:
+//
+//   1. Memory. To write it, the whole tree is first copied into a
+//      []NodeData, then marshaled into one big []byte. A 2 GB tree needs
+//      another 2 GB (and then some — base64 adds a third) just to save.
+//   2. Trust. A JSON file that's been cut short or had a bit flipped may
+//      still parse — into the wrong tree. Nothing tells us it's damaged.
+//
+// THE FIX: A STREAMED BINARY FORMAT WITH A CHECKSUM
+//
+//   ┌────────────────────────────────────────────────────────────┐
+//   │ header   "ZKSNAP" │ version u16 │ txID │ term │ timestamp  │
+//   ├────────────────────────────────────────────────────────────┤
+//   │ node     1 │ len(path) │ path │ len(data) │ data │ stat    │
+//   │            │ len(acl) │ len(entry) │ entry │ ...           │
+//   │ node     1 │ len(path) │ path │ len(data) │ data │ stat    │
+//   │            │ len(acl) │ len(entry) │ entry │ ...           │
+//   │ ...                                                        │
+//   │ session  2 │ id │ timeout ms │ len(password) │ password    │
+//   │ ...                                                        │
+//   │ config   3 │ len(members) │ members      (at most one)      │
+//   ├────────────────────────────────────────────────────────────┤
+//   │ end      0 │ record count u64                              │
+//   │ footer   crc32c u32 of everything above                    │
+//   └────────────────────────────────────────────────────────────┘
+//
+// Fixed-size numbers are little-endian; lengths are uvarints. stat is
+// czxid, mzxid, ctime, mtime, version, cversion, ephemeral owner,
+// aversion as signed varints; each ACL entry is its text form,
+// "scheme:id:perms". Session ids and timeouts are signed varints too.
+// members is the cluster membership, opaque here (see store/config.go).
+// Older versions still load: version 1 has no stat, version 2 has no
+// ephemeral owner and no sessions, version 3 has no config, version 4
+// has no aversion and no ACL, version 5 has no session passwords.
+//
+// A Writer emits nodes one at a time as the tree is walked, through a
+// buffered writer, straight into the temp file. Memory use is one node,
+// not the whole tree.
+//
+// Reading checks the footer before trusting a single byte. A file
+// that's short, padded, or damaged anywhere fails with ErrCorrupt.
+//
+// OLD SNAPSHOTS:
+//
+// Files written before this format are JSON. They start with '{', never
+// with "ZKSNAP", so Decode can tell them apart and still load them. The
+// next snapshot is written in the new format.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/syamsularifin/zookeeper/internal/acl"
+)
+
+const (
+	// magic starts every binary snapshot.
+	magic = "ZKSNAP"
+
+	// formatVersion is bumped whenever the layout changes.
+	//   1: path + data
+	//   2: path + data + stat
+	//   3: stat + ephemeral owner, session records
+	//   4: config record
+	//   5: aversion + ACL
+	//   6: session password
+	formatVersion = 6
+
+	// headerSize is magic + version + txID + term + timestamp.
+	headerSize = len(magic) + 2 + 8 + 8 + 8
+
+	// footerSize is the trailing checksum.
+	footerSize = 4
+
+	recordNode    = 1
+	recordSession = 2
+	recordConfig  = 3
+	recordEnd     = 0
+)
+
+// ErrCorrupt means a snapshot file is damaged: its checksum doesn't
+// match, or its contents don't follow the format.
+var ErrCorrupt = errors.New("snapshot: corrupt file")
+
+// crcTable is CRC-32C, the same checksum the WAL uses.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// encoder writes the binary format to any io.Writer and keeps a running
+// checksum of what it wrote.
+type encoder struct {
+	w     io.Writer // the destination
+	out   io.Writer // w + crc
+	crc   hash.Hash32
+	count uint64
+	err   error
+
+	// scratch holds one uvarint or fixed-size number at a time.
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func newEncoder(w io.Writer, txID, term int64, timestamp time.Time) *encoder {
+	crc := crc32.New(crcTable)
+	e := &encoder{w: w, out: io.MultiWriter(w, crc), crc: crc}
+
+	e.write([]byte(magic))
+	e.putUint16(formatVersion)
+	e.putUint64(uint64(txID))
+	e.putUint64(uint64(term))
+	e.putUint64(uint64(unixNano(timestamp)))
+	return e
+}
+
+// add writes one node record.
+func (e *encoder) add(node NodeData) error {
+	e.write([]byte{recordNode})
+	e.putUvarint(uint64(len(node.Path)))
+	e.write([]byte(node.Path))
+	e.putUvarint(uint64(len(node.Data)))
+	e.write(node.Data)
+	for _, v := range []int64{node.Czxid, node.Mzxid, node.Ctime, node.Mtime,
+		int64(node.Version), int64(node.Cversion), node.EphemeralOwner, int64(node.Aversion)} {
+		e.putVarint(v)
+	}
+	e.putUvarint(uint64(len(node.ACL)))
+	for _, a := range node.ACL {
+		text := a.String()
+		e.putUvarint(uint64(len(text)))
+		e.write([]byte(text))
+	}
+	e.count++
+	return e.err
+}
+
+// addSession writes one session record.
+func (e *encoder) addSession(sess SessionData) error {
+	e.write([]byte{recordSession})
+	e.putVarint(sess.ID)
+	e.putVarint(sess.TimeoutMs)
+	e.putUvarint(uint64(len(sess.Password)))
+	e.write([]byte(sess.Password))
+	e.count++
+	return e.err
+}
+
+// addConfig writes the config record.
+func (e *encoder) addConfig(config []byte) error {
+	e.write([]byte{recordConfig})
+	e.putUvarint(uint64(len(config)))
+	e.write(config)
+	e.count++
+	return e.err
+}
+
+// finish writes the end record and the checksum footer.
+func (e *encoder) finish() error {
+	e.write([]byte{recordEnd})
+	e.putUint64(e.count)
+
+	// The footer itself isn't part of the checksum.
+	if e.err == nil {
+		binary.LittleEndian.PutUint32(e.scratch[:4], e.crc.Sum32())
+		_, e.err = e.w.Write(e.scratch[:4])
+	}
+	return e.err
+}
+
+func (e *encoder) write(p []byte) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = e.out.Write(p)
+}
+
+func (e *encoder) putUvarint(v uint64) {
+	n := binary.PutUvarint(e.scratch[:], v)
+	e.write(e.scratch[:n])
+}
+
+func (e *encoder) putVarint(v int64) {
+	n := binary.PutVarint(e.scratch[:], v)
+	e.write(e.scratch[:n])
+}
+
+func (e *encoder) putUint16(v uint16) {
+	binary.LittleEndian.PutUint16(e.scratch[:2], v)
+	e.write(e.scratch[:2])
+}
+
+func (e *encoder) putUint64(v uint64) {
+	binary.LittleEndian.PutUint64(e.scratch[:8], v)
+	e.write(e.scratch[:8])
+}
+
+// Writer streams a snapshot to disk, one node at a time.
+//
+//	w, err := snapshot.Create(path, txID, term)
+//	tree.WalkSnapshot(w.Add)
+//	w.AddSession(...)  // for each open session
+//	w.SetConfig(...)   // if there is one
+//	w.Commit()         // or w.Abort() on error
+//
+// Nothing is visible at path until Commit: the data goes to path.tmp and
+// is renamed into place only after it's complete and synced — the same
+// write-to-temp-then-rename pattern as always.
+type Writer struct {
+	path    string
+	tmpPath string
+	file    *os.File
+	buf     *bufio.Writer
+	enc     *encoder
+}
+
+// Create starts a new snapshot that will replace the one at path.
+func Create(path string, txID, term int64) (*Writer, error) {
+	return create(path, txID, term, time.Now())
+}
+
+func create(path string, txID, term int64, timestamp time.Time) (*Writer, error) {
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+
+	buf := bufio.NewWriterSize(f, 256<<10)
+	return &Writer{
+		path:    path,
+		tmpPath: tmpPath,
+		file:    f,
+		buf:     buf,
+		enc:     newEncoder(buf, txID, term, timestamp),
+	}, nil
+}
+
+// Add appends one znode. Parents must come before their children.
+func (w *Writer) Add(node NodeData) error {
+	if err := w.enc.add(node); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// AddSession appends one session. Sessions come after all the nodes.
+func (w *Writer) AddSession(sess SessionData) error {
+	if err := w.enc.addSession(sess); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// SetConfig appends the cluster membership, after the sessions.
+// Call it at most once.
+func (w *Writer) SetConfig(config []byte) error {
+	if err := w.enc.addConfig(config); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// Commit finishes the file, syncs it, and atomically renames it over
+// the previous snapshot. On error, the previous snapshot is untouched.
+func (w *Writer) Commit() error {
+	if err := w.enc.finish(); err != nil {
+		w.Abort()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := w.buf.Flush(); err != nil {
+		w.Abort()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	// Sync: force the temp file to physical disk before renaming.
+	// Without this, the OS might rename the file but not yet flush
+	// the contents — crash at that moment = empty file with the right name.
+	if err := w.file.Sync(); err != nil {
+		w.Abort()
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err := w.file.Close(); err != nil {
+		os.Remove(w.tmpPath)
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	// Atomic rename: old snapshot is replaced in one instant.
+	if err := os.Rename(w.tmpPath, w.path); err != nil {
+		return fmt.Errorf("failed to rename: %w", err)
+	}
+	return syncDir(filepath.Dir(w.path))
+}
+
+// Abort throws the unfinished snapshot away.
+func (w *Writer) Abort() {
+	w.file.Close()
+	os.Remove(w.tmpPath)
+}
+
+// Encode turns a snapshot into the bytes Save writes to disk.
+// Used for tests and anywhere a snapshot is needed in memory.
+func Encode(snap *Snapshot) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := newEncoder(&buf, snap.TxID, snap.Term, snap.Timestamp)
+	for _, node := range snap.Nodes {
+		if err := enc.add(node); err != nil {
+			return nil, err
+		}
+	}
+	for _, sess := range snap.Sessions {
+		if err := enc.addSession(sess); err != nil {
+			return nil, err
+		}
+	}
+	if snap.Config != nil {
+		if err := enc.addConfig(snap.Config); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.finish(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode parses a snapshot file's contents: the binary format, or the
+// old JSON format.
+//
+// Also used to check a snapshot received from another node
+// (InstallSnapshot) before it's installed.
+func Decode(data []byte) (*Snapshot, error) {
+	if bytes.HasPrefix(data, []byte(magic)) {
+		return decodeBinary(data)
+	}
+	return decodeJSON(data)
+}
+
+// decodeBinary parses the binary format. The checksum is verified
+// before anything else.
+func decodeBinary(data []byte) (*Snapshot, error) {
+	if len(data) < headerSize+1+8+footerSize {
+		return nil, fmt.Errorf("%w: only %d bytes", ErrCorrupt, len(data))
+	}
+
+	body := data[:len(data)-footerSize]
+	want := binary.LittleEndian.Uint32(data[len(data)-footerSize:])
+	if crc32.Checksum(body, crcTable) != want {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+
+	version := binary.LittleEndian.Uint16(body[len(magic):])
+	if version < 1 || version > formatVersion {
+		return nil, fmt.Errorf("snapshot format version %d not supported", version)
+	}
+
+	p := len(magic) + 2
+	snap := &Snapshot{
+		TxID: int64(binary.LittleEndian.Uint64(body[p:])),
+		Term: int64(binary.LittleEndian.Uint64(body[p+8:])),
+	}
+	if ts := int64(binary.LittleEndian.Uint64(body[p+16:])); ts != 0 {
+		snap.Timestamp = time.Unix(0, ts)
+	}
+
+	r := bytes.NewReader(body[headerSize:])
+	for {
+		kind, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%w: missing end record", ErrCorrupt)
+		}
+		if kind == recordEnd {
+			break
+		}
+		if kind == recordSession && version >= 3 {
+			var sess SessionData
+			if sess.ID, err = binary.ReadVarint(r); err != nil {
+				return nil, fmt.Errorf("%w: bad session", ErrCorrupt)
+			}
+			if sess.TimeoutMs, err = binary.ReadVarint(r); err != nil {
+				return nil, fmt.Errorf("%w: bad session", ErrCorrupt)
+			}
+			if version >= 6 {
+				password, err := readBytes(r)
+				if err != nil {
+					return nil, err
+				}
+				sess.Password = string(password)
+			}
+			snap.Sessions = append(snap.Sessions, sess)
+			continue
+		}
+		if kind == recordConfig && version >= 4 && snap.Config == nil {
+			if snap.Config, err = readBytes(r); err != nil {
+				return nil, err
+			}
+			if snap.Config == nil {
+				return nil, fmt.Errorf("%w: empty config", ErrCorrupt)
+			}
+			continue
+		}
+		if kind != recordNode {
+			return nil, fmt.Errorf("%w: unknown record type %d", ErrCorrupt, kind)
+		}
+
+		path, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		nodeData, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		node := NodeData{Path: string(path), Data: nodeData}
+		if version >= 2 {
+			stat := make([]int64, 6, 8)
+			if version >= 3 {
+				stat = stat[:7] // + ephemeral owner
+			}
+			if version >= 5 {
+				stat = stat[:8] // + aversion
+			}
+			for i := range stat {
+				if stat[i], err = binary.ReadVarint(r); err != nil {
+					return nil, fmt.Errorf("%w: bad stat", ErrCorrupt)
+				}
+			}
+			node.Czxid, node.Mzxid, node.Ctime, node.Mtime = stat[0], stat[1], stat[2], stat[3]
+			node.Version, node.Cversion = int32(stat[4]), int32(stat[5])
+			if version >= 3 {
+				node.EphemeralOwner = stat[6]
+			}
+			if version >= 5 {
+				node.Aversion = int32(stat[7])
+				if node.ACL, err = readACL(r); err != nil {
+					return nil, err
+				}
+			}
+		}
+		snap.Nodes = append(snap.Nodes, node)
+	}
+
+	var count uint64
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, fmt.Errorf("%w: missing node count", ErrCorrupt)
+	}
+	read := uint64(len(snap.Nodes) + len(snap.Sessions))
+	if snap.Config != nil {
+		read++
+	}
+	if count != read || r.Len() != 0 {
+		return nil, fmt.Errorf("%w: expected %d records, read %d", ErrCorrupt, count, read)
+	}
+	return snap, nil
+}
+
+// readBytes reads a uvarint length and that many bytes.
+// A zero length gives nil, like an omitted JSON field.
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return nil, fmt.Errorf("%w: bad length", ErrCorrupt)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	b := make([]byte, n)
+	r.Read(b)
+	return b, nil
+}
+
+// readACL reads a node's ACL: a uvarint count, then each entry's text.
+func readACL(r *bytes.Reader) ([]acl.ACL, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return nil, fmt.Errorf("%w: bad ACL", ErrCorrupt)
+	}
+	var acls []acl.ACL
+	for i := uint64(0); i < n; i++ {
+		text, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		var a acl.ACL
+		if err := a.UnmarshalText(text); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		acls = append(acls, a)
+	}
+	return acls, nil
+}
+
+// unixNano is t.UnixNano, except the zero time is 0 (UnixNano of the
+// zero time doesn't fit in an int64).
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// decodeJSON parses a snapshot written before the binary format.
+func decodeJSON(data []byte) (*Snapshot, error) {
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot: %w", err)
+	}
+	return &snap, nil
+}
+
+// syncDir fsyncs a directory, so the rename survives a power loss.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
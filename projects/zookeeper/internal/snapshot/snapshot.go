@@ -26,10 +26,11 @@ package snapshot
 // That's it. A snapshot = the tree state dumped to a file.
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"time"
+
+	"github.com/syamsularifin/zookeeper/internal/acl"
 )
 
 // NodeData is one znode serialized to a flat format.
@@ -45,11 +46,47 @@ import (
 //                               {path: "/locks",      data: nil},
 //                             ]
 //
-// Why flatten? Because JSON can't easily represent pointer-based trees.
-// A flat list is simple to write, simple to read, simple to debug.
+// Why flatten? Because a file can't easily represent pointer-based trees.
+// A flat list is simple to write, simple to read, simple to debug — and
+// it can be written one node at a time (see format.go).
+//
+// The JSON tags are for snapshots written in the old JSON format.
+//
+// The Stat fields travel with the node, so a restored tree has the same
+// versions and zxids it had when the snapshot was taken. (DataLength and
+// NumChildren aren't stored — they follow from Data and the list.)
 type NodeData struct {
 	Path string `json:"path"`
 	Data []byte `json:"data,omitempty"`
+
+	Czxid    int64 `json:"czxid,omitempty"`
+	Mzxid    int64 `json:"mzxid,omitempty"`
+	Ctime    int64 `json:"ctime,omitempty"`
+	Mtime    int64 `json:"mtime,omitempty"`
+	Version  int32 `json:"version,omitempty"`
+	Cversion int32 `json:"cversion,omitempty"`
+	Aversion int32 `json:"aversion,omitempty"`
+
+	// EphemeralOwner is the session that owns an ephemeral node, 0 otherwise.
+	EphemeralOwner int64 `json:"ephemeral_owner,omitempty"`
+
+	// ACL is the node's ACL. Empty in snapshots from before ACLs
+	// existed; those nodes come back open to everyone.
+	ACL []acl.ACL `json:"acl,omitempty"`
+}
+
+// SessionData is one open client session.
+//
+// Sessions are part of the state a snapshot captures: the WAL entry
+// that opened a session may be compacted away, but the session (and its
+// ephemeral nodes) live on until it's closed or expires.
+type SessionData struct {
+	ID        int64 `json:"id"`
+	TimeoutMs int64 `json:"timeout_ms"`
+
+	// Password is the session's secret. Empty for sessions from
+	// snapshots that predate passwords.
+	Password string `json:"password,omitempty"`
 }
 
 // Snapshot is the full snapshot written to disk.
@@ -59,11 +96,25 @@ type Snapshot struct {
 	// They're already captured in the snapshot.
 	TxID int64 `json:"tx_id"`
 
+	// Term is the Raft term of the entry at TxID (0 in standalone mode).
+	// A leader sending this snapshot to a follower tells it "this replaces
+	// your log up to TxID, which was written in Term" — the follower needs
+	// the term to check that the entries it receives next line up.
+	Term int64 `json:"term,omitempty"`
+
 	// Timestamp is when the snapshot was created. For debugging only.
 	Timestamp time.Time `json:"timestamp"`
 
 	// Nodes is every znode in the tree, flattened into a list.
 	Nodes []NodeData `json:"nodes"`
+
+	// Sessions is every open client session.
+	Sessions []SessionData `json:"sessions,omitempty"`
+
+	// Config is the cluster membership as of TxID, nil if it never
+	// changed from the one the nodes were started with. Like sessions,
+	// it outlives the WAL entry that set it.
+	Config []byte `json:"config,omitempty"`
 }
 
 // Save writes a snapshot to disk.
@@ -85,42 +136,36 @@ type Snapshot struct {
 //     still has the PREVIOUS good snapshot. Node starts fine.
 //   - Step 3 (rename) is atomic on most filesystems: the file is either
 //     fully the old version or fully the new version, never half-and-half.
+//
+// Save is for a snapshot already in memory. To write a tree without
+// copying it first, use Create and add nodes as you walk it.
 func Save(path string, snap *Snapshot) error {
-	tmpPath := path + ".tmp"
-
-	// Marshal to pretty JSON so we can read it for debugging.
-	data, err := json.MarshalIndent(snap, "", "  ")
+	w, err := create(path, snap.TxID, snap.Term, snap.Timestamp)
 	if err != nil {
-		return fmt.Errorf("failed to marshal snapshot: %w", err)
-	}
-
-	// Write to temp file
-	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write temp file: %w", err)
+		return err
 	}
-
-	// Sync: force the temp file to physical disk before renaming.
-	// Without this, the OS might rename the file but not yet flush
-	// the contents — crash at that moment = empty file with the right name.
-	f, err := os.Open(tmpPath)
-	if err != nil {
-		return fmt.Errorf("failed to open temp file for sync: %w", err)
+	for _, node := range snap.Nodes {
+		if err := w.Add(node); err != nil {
+			w.Abort()
+			return err
+		}
 	}
-	if err := f.Sync(); err != nil {
-		f.Close()
-		return fmt.Errorf("failed to sync temp file: %w", err)
+	for _, sess := range snap.Sessions {
+		if err := w.AddSession(sess); err != nil {
+			w.Abort()
+			return err
+		}
 	}
-	f.Close()
-
-	// Atomic rename: old snapshot is replaced in one instant.
-	if err := os.Rename(tmpPath, path); err != nil {
-		return fmt.Errorf("failed to rename: %w", err)
+	if snap.Config != nil {
+		if err := w.SetConfig(snap.Config); err != nil {
+			w.Abort()
+			return err
+		}
 	}
-
-	return nil
+	return w.Commit()
 }
 
-// Load reads a snapshot from disk.
+// Load reads a snapshot from disk, in either format (see format.go).
 // Returns nil (not an error) if the file doesn't exist — that's normal
 // on first boot when no snapshot has been taken yet.
 func Load(path string) (*Snapshot, error) {
@@ -132,10 +177,5 @@ func Load(path string) (*Snapshot, error) {
 		return nil, fmt.Errorf("failed to read snapshot: %w", err)
 	}
 
-	var snap Snapshot
-	if err := json.Unmarshal(data, &snap); err != nil {
-		return nil, fmt.Errorf("failed to parse snapshot: %w", err)
-	}
-
-	return &snap, nil
+	return Decode(data)
 }
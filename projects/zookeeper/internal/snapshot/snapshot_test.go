@@ -1,9 +1,16 @@
 package snapshot
 
 import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
 	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/syamsularifin/zookeeper/internal/acl"
 )
 
 func TestSaveAndLoad(t *testing.T) {
@@ -56,3 +63,262 @@ func TestLoadNoFile(t *testing.T) {
 		t.Fatal("expected nil snapshot for missing file")
 	}
 }
+
+func TestEncodeDecodeKeepsTerm(t *testing.T) {
+	data, err := Encode(&Snapshot{TxID: 7, Term: 3, Nodes: []NodeData{{Path: "/"}}})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	snap, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if snap.TxID != 7 || snap.Term != 3 {
+		t.Fatalf("expected TxID 7 term 3, got TxID %d term %d", snap.TxID, snap.Term)
+	}
+
+	if _, err := Decode([]byte("not a snapshot")); err == nil {
+		t.Fatal("expected error for garbage")
+	}
+}
+
+func TestLoadLegacyJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	legacy := `{"tx_id": 9, "timestamp": "2026-05-23T12:00:00Z", "nodes": [{"path": "/"}, {"path": "/app", "data": "aGVsbG8="}]}`
+	if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load of a JSON snapshot failed: %v", err)
+	}
+	if snap.TxID != 9 || len(snap.Nodes) != 2 || string(snap.Nodes[1].Data) != "hello" {
+		t.Fatalf("JSON snapshot not read intact: %+v", snap)
+	}
+}
+
+func TestLoadDetectsCorruption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	snap := &Snapshot{TxID: 5, Nodes: []NodeData{{Path: "/"}, {Path: "/app", Data: []byte("hello")}}}
+	if err := Save(path, snap); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	good, _ := os.ReadFile(path)
+
+	flipped := append([]byte(nil), good...)
+	flipped[len(flipped)/2] ^= 0x01
+	cut := good[:len(good)-3]
+
+	for name, data := range map[string][]byte{"bit flip": flipped, "truncated": cut} {
+		os.WriteFile(path, data, 0644)
+		if _, err := Load(path); !errors.Is(err, ErrCorrupt) {
+			t.Fatalf("%s: expected ErrCorrupt, got %v", name, err)
+		}
+	}
+}
+
+func TestWriterAbortKeepsPreviousSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := Save(path, &Snapshot{TxID: 1, Nodes: []NodeData{{Path: "/"}}}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	w, err := Create(path, 2, 1)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	w.Add(NodeData{Path: "/"})
+	w.Abort()
+
+	snap, err := Load(path)
+	if err != nil || snap.TxID != 1 {
+		t.Fatalf("expected the previous snapshot at TxID 1, got %+v (%v)", snap, err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatal("Abort should remove the temp file")
+	}
+}
+
+func TestEncodeDecodeKeepsStat(t *testing.T) {
+	node := NodeData{
+		Path: "/app", Data: []byte("x"),
+		Czxid: 3, Mzxid: 9, Ctime: 1000, Mtime: 2000, Version: 4, Cversion: 2,
+	}
+	data, err := Encode(&Snapshot{TxID: 9, Nodes: []NodeData{{Path: "/"}, node}})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	snap, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	got := snap.Nodes[1]
+	if got.Czxid != 3 || got.Mzxid != 9 || got.Ctime != 1000 || got.Mtime != 2000 ||
+		got.Version != 4 || got.Cversion != 2 {
+		t.Fatalf("Stat fields lost: %+v", got)
+	}
+}
+
+// TestDecodeVersion1 proves files written before node records carried
+// a Stat still load, with a zero Stat.
+func TestDecodeVersion1(t *testing.T) {
+	var buf bytes.Buffer
+	crc := crc32.New(crcTable)
+	e := &encoder{w: &buf, out: io.MultiWriter(&buf, crc), crc: crc}
+	e.write([]byte(magic))
+	e.putUint16(1)
+	e.putUint64(5) // txID
+	e.putUint64(2) // term
+	e.putUint64(0) // timestamp
+	e.write([]byte{recordNode})
+	e.putUvarint(4)
+	e.write([]byte("/app"))
+	e.putUvarint(2)
+	e.write([]byte("hi"))
+	e.count++
+	if err := e.finish(); err != nil {
+		t.Fatalf("writing version 1 file failed: %v", err)
+	}
+
+	snap, err := Decode(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if snap.TxID != 5 || len(snap.Nodes) != 1 || string(snap.Nodes[0].Data) != "hi" {
+		t.Fatalf("unexpected snapshot: %+v", snap)
+	}
+	if snap.Nodes[0].Version != 0 || snap.Nodes[0].Czxid != 0 {
+		t.Fatalf("version 1 node should have a zero Stat, got %+v", snap.Nodes[0])
+	}
+}
+
+func TestEncodeDecodeKeepsSessions(t *testing.T) {
+	data, err := Encode(&Snapshot{
+		TxID:     9,
+		Nodes:    []NodeData{{Path: "/"}, {Path: "/lock", EphemeralOwner: 4}},
+		Sessions: []SessionData{{ID: 4, TimeoutMs: 5000, Password: "secret"}, {ID: 6, TimeoutMs: 1000}},
+	})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	snap, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if snap.Nodes[1].EphemeralOwner != 4 {
+		t.Fatalf("expected owner 4, got %d", snap.Nodes[1].EphemeralOwner)
+	}
+	if len(snap.Sessions) != 2 || snap.Sessions[0] != (SessionData{ID: 4, TimeoutMs: 5000, Password: "secret"}) ||
+		snap.Sessions[1] != (SessionData{ID: 6, TimeoutMs: 1000}) {
+		t.Fatalf("sessions lost: %+v", snap.Sessions)
+	}
+}
+
+func TestEncodeDecodeKeepsConfig(t *testing.T) {
+	data, err := Encode(&Snapshot{
+		TxID:   9,
+		Nodes:  []NodeData{{Path: "/"}},
+		Config: []byte(`[{"id":"node-1"}]`),
+	})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	snap, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if string(snap.Config) != `[{"id":"node-1"}]` {
+		t.Fatalf("config lost: %q", snap.Config)
+	}
+}
+
+func TestEncodeDecodeKeepsACL(t *testing.T) {
+	alice := acl.ACL{Perms: acl.All, ID: acl.Digest("alice", "secret")}
+	data, err := Encode(&Snapshot{
+		TxID: 9,
+		Nodes: []NodeData{
+			{Path: "/", ACL: acl.Open},
+			{Path: "/app", Aversion: 3, ACL: []acl.ACL{{Perms: acl.Read, ID: acl.Anyone}, alice}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	snap, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	got := snap.Nodes[1]
+	if got.Aversion != 3 || len(got.ACL) != 2 || got.ACL[1] != alice {
+		t.Fatalf("ACL lost: %+v", got)
+	}
+}
+
+// TestDecodeVersion4 proves files written before ACLs still load, with
+// no ACL (the tree makes those nodes open).
+func TestDecodeVersion4(t *testing.T) {
+	var buf bytes.Buffer
+	crc := crc32.New(crcTable)
+	e := &encoder{w: &buf, out: io.MultiWriter(&buf, crc), crc: crc}
+	e.write([]byte(magic))
+	e.putUint16(4)
+	e.putUint64(5) // txID
+	e.putUint64(2) // term
+	e.putUint64(0) // timestamp
+	e.write([]byte{recordNode})
+	e.putUvarint(4)
+	e.write([]byte("/app"))
+	e.putUvarint(0)
+	for _, v := range []int64{3, 4, 0, 0, 1, 0, 0} { // stat, no aversion
+		e.putVarint(v)
+	}
+	e.count++
+	if err := e.finish(); err != nil {
+		t.Fatalf("writing version 4 file failed: %v", err)
+	}
+
+	snap, err := Decode(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if len(snap.Nodes) != 1 || snap.Nodes[0].Mzxid != 4 || snap.Nodes[0].Version != 1 {
+		t.Fatalf("unexpected snapshot: %+v", snap)
+	}
+	if snap.Nodes[0].ACL != nil || snap.Nodes[0].Aversion != 0 {
+		t.Fatalf("version 4 node should have no ACL, got %+v", snap.Nodes[0])
+	}
+}
+
+// TestDecodeVersion5 proves sessions written before passwords still
+// load, with an empty password.
+func TestDecodeVersion5(t *testing.T) {
+	var buf bytes.Buffer
+	crc := crc32.New(crcTable)
+	e := &encoder{w: &buf, out: io.MultiWriter(&buf, crc), crc: crc}
+	e.write([]byte(magic))
+	e.putUint16(5)
+	e.putUint64(5) // txID
+	e.putUint64(2) // term
+	e.putUint64(0) // timestamp
+	e.write([]byte{recordSession})
+	e.putVarint(4)    // id
+	e.putVarint(5000) // timeout, no password
+	e.count++
+	if err := e.finish(); err != nil {
+		t.Fatalf("writing version 5 file failed: %v", err)
+	}
+
+	snap, err := Decode(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if len(snap.Sessions) != 1 || snap.Sessions[0] != (SessionData{ID: 4, TimeoutMs: 5000}) {
+		t.Fatalf("unexpected sessions: %+v", snap.Sessions)
+	}
+}
@@ -0,0 +1,144 @@
+package store
+
+// THE PROBLEM:
+//
+// The WAL is a log of everything a node has WRITTEN, not everything
+// that was COMMITTED. In cluster mode those are different:
+//
+//   node-1 (leader, term 3) appends TxID 8 to its WAL
+//   node-1 crashes before a majority has TxID 8
+//   node-2 becomes leader and commits a different TxID 8
+//
+// On restart, node-1's WAL still holds the old TxID 8. Replaying it
+// would put data in the tree that the cluster never agreed on — and
+// clients reading from node-1 would see it.
+//
+// THE FIX:
+//
+// Remember how far we've applied. Every tick (50ms), Raft has the Store
+// write the TxID of the last entry it applied to the tree to a small
+// side file next to the WAL (SaveCommitIndex):
+//
+//   wal.log          → [1] [2] [3] ... [7] [8]
+//   wal.log.commit   → {"commit_index": 7}
+//
+// On restart, replay applies entries up to 7 and stops. Entry 8 stays
+// in the WAL cache, unapplied. When the node rejoins, Raft either:
+//   - learns from the leader that 8 is committed → applies it, or
+//   - finds a conflict → truncates it and takes the leader's version.
+//
+// WHY A LOWER BOUND IS ENOUGH:
+//
+// The file only ever holds a TxID that really was committed. If it's
+// a little behind (a crash before the next save), restart applies
+// fewer entries and Raft applies the rest once it hears from the
+// leader. Applying too LITTLE is recoverable; applying too MUCH is not.
+//
+// STANDALONE MODE:
+//
+// A standalone node commits every write immediately, so there's nothing
+// to hold back. The file is only created the first time Raft appends an
+// entry (AppendWAL). Without it, replay applies everything — which is
+// also how data dirs from before this file existed keep working.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// commitSuffix is appended to the WAL path to get the commit file path.
+const commitSuffix = ".commit"
+
+// commitState is the content of the commit file.
+type commitState struct {
+	CommitIndex int64 `json:"commit_index"`
+}
+
+// loadCommitIndex reads the commit file.
+// ok = false means there is no file: nothing is held back during replay.
+func loadCommitIndex(path string) (index int64, ok bool, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("failed to read commit index: %w", err)
+	}
+
+	var c commitState
+	if err := json.Unmarshal(data, &c); err != nil {
+		return 0, false, fmt.Errorf("failed to parse commit index: %w", err)
+	}
+	return c.CommitIndex, true, nil
+}
+
+// saveCommitIndex writes the commit file with the same temp file +
+// fsync + rename + directory fsync pattern as snapshot.Save, so a crash
+// never leaves a half-written file behind, or loses the new one.
+func saveCommitIndex(path string, index int64) error {
+	data, err := json.Marshal(commitState{CommitIndex: index})
+	if err != nil {
+		return fmt.Errorf("failed to marshal commit index: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to rename: %w", err)
+	}
+
+	// The rename is only durable once the directory is: without this,
+	// a crash can bring back the old commit index.
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("failed to open data dir for sync: %w", err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("failed to sync data dir: %w", err)
+	}
+	return nil
+}
+
+// markCommitted records that every entry up to txID is applied.
+// Before the commit file exists (standalone mode) it only updates memory.
+func (s *Store) markCommitted(txID int64) error {
+	if txID > s.commitIndex {
+		s.commitIndex = txID
+	}
+	if !s.trackCommit {
+		return nil
+	}
+	return s.writeCommitIndex(s.commitIndex)
+}
+
+// writeCommitIndex writes index to the commit file, after any
+// SaveCommitIndex already writing it.
+//
+// Must be called with s.mu held.
+func (s *Store) writeCommitIndex(index int64) error {
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+	if err := saveCommitIndex(s.commitPath, index); err != nil {
+		return err
+	}
+	s.savedCommit = index
+	return nil
+}
@@ -0,0 +1,118 @@
+package store
+
+// THE PROBLEM:
+//
+// Every write adds an entry to the WAL and to the in-memory cache.
+// Nothing ever removes one. A node that has served a million writes
+// holds a million entries in memory and replays them on every restart
+// — even though the snapshot already contains all of them.
+//
+// THE FIX: COMPACTION
+//
+// Once a snapshot at TxID X is safely on disk, entries up to X are
+// redundant for recovery. TakeSnapshot drops them:
+//
+//   before:   snapshot@0     cache [1 ........................ 9000]
+//   snapshot: snapshot@9000  cache [1 ........................ 9000]
+//   compact:  snapshot@9000  cache            [4001 .......... 9000]
+//                                              └─ RetainEntries=5000 ─┘
+//
+// WHY KEEP A TAIL?
+//
+// Recovery doesn't need it, but replication does. A follower that was
+// down for a minute asks for entries from where it stopped. If they're
+// still in the tail, the leader just sends them. If not, the follower
+// needs the whole snapshot — much more expensive. The tail is a buffer
+// for slow followers.
+//
+// Entries the cache no longer has are reported as wal.ErrCompacted,
+// never as "nothing there" — an empty answer would look like the
+// follower is up to date.
+//
+// WHEN?
+//
+// Snapshots are taken automatically after SnapshotEveryWrites applied
+// writes or SnapshotEveryBytes of written data, whichever comes first,
+// and always on Close.
+
+import "github.com/syamsularifin/zookeeper/internal/wal"
+
+// Options tunes snapshots and compaction. The zero value never takes
+// automatic snapshots and keeps no tail.
+type Options struct {
+	// SnapshotEveryWrites takes a snapshot after this many writes.
+	// 0 disables the count trigger.
+	SnapshotEveryWrites int
+
+	// SnapshotEveryBytes takes a snapshot after this many bytes of
+	// written data (paths + values). 0 disables the size trigger.
+	SnapshotEveryBytes int64
+
+	// RetainEntries is how many entries before the snapshot point stay
+	// in the cache and WAL for followers that are behind.
+	RetainEntries int64
+}
+
+// DefaultOptions returns the options New uses.
+func DefaultOptions() Options {
+	return Options{
+		SnapshotEveryWrites: 10000,
+		SnapshotEveryBytes:  64 << 20, // 64 MiB
+		RetainEntries:       5000,
+	}
+}
+
+// noteWrite counts an applied write and takes a snapshot if a trigger
+// has been reached. Called with s.mu held.
+//
+// A failed snapshot doesn't fail the write — the write is already
+// durable in the WAL. The counters aren't reset, so the next write
+// tries again.
+func (s *Store) noteWrite(size int) {
+	s.writesSinceSnap++
+	s.bytesSinceSnap += int64(size)
+
+	due := (s.opts.SnapshotEveryWrites > 0 && s.writesSinceSnap >= s.opts.SnapshotEveryWrites) ||
+		(s.opts.SnapshotEveryBytes > 0 && s.bytesSinceSnap >= s.opts.SnapshotEveryBytes)
+	if due {
+		_ = s.takeSnapshot()
+	}
+}
+
+// compact discards entries up to snapTxID - RetainEntries, from the
+// cache and from the WAL. Only called after the snapshot at snapTxID
+// was saved — otherwise a crash could lose the entries for good.
+func (s *Store) compact(snapTxID int64) error {
+	upTo := snapTxID - s.opts.RetainEntries
+	if upTo < s.firstTxID() {
+		return nil
+	}
+
+	// Copy instead of reslicing so the old array can be freed. Callers
+	// still iterating an older slice from GetWALEntriesFrom are unaffected.
+	keep := s.entries[s.indexOf(upTo+1):]
+	s.entries = append([]wal.Entry(nil), keep...)
+	s.compactedTo = upTo
+
+	return s.wal.CompactTo(upTo)
+}
+
+// firstTxID is the TxID of the first cached entry. If the cache is
+// empty, it's the TxID the next entry will get.
+func (s *Store) firstTxID() int64 {
+	if len(s.entries) > 0 {
+		return s.entries[0].TxID
+	}
+	if last := s.wal.LastTxID(); last > s.compactedTo {
+		return last + 1
+	}
+	return s.compactedTo + 1
+}
+
+// indexOf converts a TxID into a position in the cache. The result
+// may be out of range; callers check.
+//
+//	entries = [4001, 4002, 4003]   → indexOf(4002) = 1
+func (s *Store) indexOf(txID int64) int {
+	return int(txID - s.firstTxID())
+}
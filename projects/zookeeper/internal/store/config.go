@@ -0,0 +1,23 @@
+package store
+
+// Cluster membership, as replicated state.
+//
+// Which nodes make up the cluster is decided through the log, like any
+// write (see cluster/membership.go). The Store only keeps the result:
+//
+//   CONFIG (TxID 57, members A, B, C, D)  → config = A, B, C, D
+//
+// It never looks inside — the bytes belong to package cluster. It keeps
+// them because the WAL entry that set them will be compacted away one
+// day, and a restarted node (or one installing a snapshot) must still
+// know who its peers are. So they go into every snapshot, next to the
+// sessions.
+
+// Config returns the membership set by the last CONFIG entry applied,
+// or carried by the snapshot the Store was restored from. nil if the
+// membership never changed.
+func (s *Store) Config() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.config
+}
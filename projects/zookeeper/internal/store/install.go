@@ -0,0 +1,115 @@
+package store
+
+// Snapshots that travel between nodes.
+//
+// After compaction, the leader can't replay old entries to a follower
+// that's far behind — they're gone. It sends its latest snapshot
+// instead (Raft's InstallSnapshot), and the follower swaps its whole
+// state for it:
+//
+//   Leader                                  Follower (stuck at TxID 120)
+//   ReadSnapshot() → bytes, TxID 9000  ──→  RestoreSnapshot(bytes, 9000, term)
+//                                             1. save as its own snapshot
+//                                             2. rebuild the tree from it
+//                                             3. fix up the log (see below)
+//                                             4. commit index = 9000
+//
+// WHAT HAPPENS TO THE FOLLOWER'S LOG?
+//
+// If the follower has the entry at 9000 with the same term, its log
+// agrees with the leader's up to there (Log Matching). Entries after
+// 9000 may still be useful, so only the part the snapshot covers is
+// compacted.
+//
+// Otherwise the log is either too short or from a different history.
+// Either way it's worthless: the WAL is reset so the next entry it
+// accepts is 9001.
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/syamsularifin/zookeeper/internal/snapshot"
+	"github.com/syamsularifin/zookeeper/internal/wal"
+)
+
+// ReadSnapshot returns the latest snapshot file as raw bytes, plus the
+// TxID and term it ends at. Used by the leader for InstallSnapshot.
+func (s *Store) ReadSnapshot() (data []byte, txID, term int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err = os.ReadFile(s.snapPath)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	snap, err := snapshot.Decode(data)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return data, snap.TxID, snap.Term, nil
+}
+
+// RestoreSnapshot replaces the Store's state with a snapshot received
+// from the leader. txID and term must match what's inside data.
+//
+// Order matters for crashes: the snapshot is saved to disk before the
+// log is touched. A crash in between restarts from the new snapshot
+// with the old log, which replay handles like any other snapshot.
+func (s *Store) RestoreSnapshot(data []byte, txID, term int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap, err := snapshot.Decode(data)
+	if err != nil {
+		return err
+	}
+	if snap.TxID != txID || snap.Term != term {
+		return fmt.Errorf("snapshot is at TxID %d term %d, expected TxID %d term %d",
+			snap.TxID, snap.Term, txID, term)
+	}
+
+	// Does our log agree with the leader's at txID? Check before the
+	// snapshot meta changes what termAt answers.
+	ourTerm, err := s.termAt(txID)
+	keepLog := err == nil && ourTerm == term && txID <= s.wal.LastTxID()
+
+	// Step 1: durable first.
+	if err := snapshot.Save(s.snapPath, snap); err != nil {
+		return err
+	}
+	s.snapTxID = snap.TxID
+	s.snapTerm = snap.Term
+
+	// Step 2: a brand new tree, session table and membership. Nothing
+	// from the old ones survives. The tree is rebuilt in place: readers
+	// hold on to s.tree without s.mu.
+	s.tree.RestoreFromSnapshot(snap.Nodes)
+	s.restoreSessions(snap.Sessions)
+	s.config = snap.Config
+
+	// Step 3: fix up the log.
+	if keepLog {
+		if idx := s.indexOf(txID + 1); idx > 0 {
+			s.entries = append([]wal.Entry(nil), s.entries[idx:]...)
+		}
+		if err := s.wal.CompactTo(txID); err != nil {
+			return err
+		}
+	} else {
+		if err := s.wal.Reset(txID + 1); err != nil {
+			return fmt.Errorf("failed to reset WAL: %w", err)
+		}
+		s.entries = nil
+	}
+	s.compactedTo = txID
+
+	// Step 4: everything up to txID is in the tree now. Watches stay,
+	// but nothing fires for the writes the snapshot skipped over.
+	s.commitIndex = txID
+	s.watches.Reset(txID)
+	s.trackCommit = true
+	s.writesSinceSnap = 0
+	s.bytesSinceSnap = 0
+	return s.writeCommitIndex(txID)
+}
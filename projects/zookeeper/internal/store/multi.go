@@ -0,0 +1,67 @@
+package store
+
+// Multi transactions: one WAL entry, many ops.
+//
+// A MULTI is a single entry, so it is logged, replicated and committed
+// as one — there's no point at which half of it is in the log. Applying
+// it is all-or-nothing too (znode.DataTree.Multi undoes the ops before
+// a failing one), so every replica either has all of its changes or,
+// like for any failed write, none.
+
+import (
+	"github.com/syamsularifin/zookeeper/internal/wal"
+	"github.com/syamsularifin/zookeeper/internal/znode"
+)
+
+// Multi applies ops atomically and returns what each one did. On
+// failure nothing changed, and the error is a *znode.MultiError naming
+// the op that failed. WAL first, then tree.
+func (s *Store) Multi(ops []wal.Op) ([]wal.OpResult, error) {
+	applied, err := s.write(wal.Entry{
+		Op:  wal.OpMulti,
+		Ops: ops,
+	})
+	return applied.Results, err
+}
+
+// multi applies a MULTI entry to the tree.
+func (s *Store) multi(entry wal.Entry, txn znode.Txn) ([]wal.OpResult, error) {
+	ops := make([]znode.Op, len(entry.Ops))
+	for i, op := range entry.Ops {
+		// Same rule as a single ephemeral CREATE: the owner must still
+		// be open when the entry is applied.
+		if op.Op == wal.OpCreate && op.Session != 0 {
+			if err := s.checkOwner(op.Session); err != nil {
+				return nil, &znode.MultiError{Index: i, Type: znode.OpCreate, Err: err}
+			}
+		}
+		ops[i] = znode.Op{
+			Type:       multiOpTypes[op.Op],
+			Path:       op.Path,
+			Data:       op.Data,
+			Version:    op.Version,
+			Owner:      op.Session,
+			Sequential: op.Sequential,
+			ACL:        op.ACL,
+		}
+	}
+
+	results, err := s.tree.Multi(ops, txn)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]wal.OpResult, len(results))
+	for i, r := range results {
+		out[i] = wal.OpResult{Path: r.Path, Version: r.Stat.Version}
+	}
+	return out, nil
+}
+
+// multiOpTypes maps the ops a MULTI may hold to the tree's. Anything
+// else maps to 0, which the tree rejects.
+var multiOpTypes = map[wal.OpType]znode.OpType{
+	wal.OpCreate: znode.OpCreate,
+	wal.OpSet:    znode.OpSet,
+	wal.OpDelete: znode.OpDelete,
+	wal.OpCheck:  znode.OpCheck,
+}
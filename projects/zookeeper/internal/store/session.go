@@ -0,0 +1,191 @@
+package store
+
+// Client sessions, as replicated state.
+//
+// A session is a client saying "I'm alive". Ephemeral znodes belong to
+// a session and disappear when it ends — that's how a lock holder or a
+// registered worker that crashes gets cleaned up.
+//
+// WHAT LIVES HERE, AND WHAT DOESN'T:
+//
+// The Store only knows which sessions EXIST, their timeouts and their
+// passwords:
+//
+//   CREATE_SESSION (TxID 42, timeout 10s)  → sessions[42] = 10s, "9f3a…"
+//   CREATE /lock, session 42               → /lock owned by 42
+//   CLOSE_SESSION 42                       → /lock deleted, 42 gone
+//
+// These are WAL entries like any other write, so every replica (and
+// every snapshot) has the same sessions and the same ephemeral nodes.
+//
+// A session id is a TxID, easy to guess. The password is what proves a
+// client owns the session (the server checks it, see CheckSession); it
+// rides in the CREATE_SESSION entry's Data, so whichever node leads
+// next can check it too.
+//
+// WHEN a session has timed out is not replicated. Heartbeats are far too
+// frequent to go through Raft. Only the leader tracks deadlines, in
+// memory (see internal/server/session.go), and when one passes it
+// proposes CLOSE_SESSION — through the log, like a client would.
+//
+// A new leader rebuilds the deadlines from this table, giving every
+// session a full timeout from the moment it took over. So a session
+// whose client died just before a failover still expires — a little
+// later than it would have, never not at all.
+
+import (
+	"crypto/subtle"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/syamsularifin/zookeeper/internal/snapshot"
+	"github.com/syamsularifin/zookeeper/internal/wal"
+	"github.com/syamsularifin/zookeeper/internal/znode"
+)
+
+// ErrSessionExpired means the session doesn't exist (anymore): it was
+// closed, it expired, or it never existed.
+var ErrSessionExpired = errors.New("session expired")
+
+// ErrSessionPassword means the session exists, but the password given
+// for it isn't its password.
+var ErrSessionPassword = errors.New("wrong session password")
+
+// session is one open session.
+type session struct {
+	timeout  time.Duration
+	password string // "" for sessions from before passwords
+}
+
+// CreateSession opens a session with the given timeout and password.
+// The session's ID is the TxID of the write that opened it. WAL first,
+// then tree.
+func (s *Store) CreateSession(timeout time.Duration, password string) (int64, error) {
+	applied, err := s.write(wal.Entry{
+		Op:      wal.OpCreateSession,
+		Timeout: timeout.Milliseconds(),
+		Data:    []byte(password),
+	})
+	return applied.TxID, err
+}
+
+// CloseSession ends a session and deletes its ephemeral nodes.
+func (s *Store) CloseSession(id int64) error {
+	_, err := s.write(wal.Entry{
+		Op:      wal.OpCloseSession,
+		Session: id,
+	})
+	return err
+}
+
+// CreateEphemeral is Create for a node owned by session.
+func (s *Store) CreateEphemeral(path string, data []byte, session int64) error {
+	_, err := s.write(wal.Entry{
+		Op:      wal.OpCreate,
+		Path:    path,
+		Data:    data,
+		Session: session,
+	})
+	return err
+}
+
+// Sessions returns every open session and its timeout.
+//
+// Safe to call while Raft applies entries: the leader's session tracker
+// polls it from its own goroutine.
+func (s *Store) Sessions() map[int64]time.Duration {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	out := make(map[int64]time.Duration, len(s.sessions))
+	for id, sess := range s.sessions {
+		out[id] = sess.timeout
+	}
+	return out
+}
+
+// Session returns one session's timeout. ok is false if it's not open.
+func (s *Store) Session(id int64) (timeout time.Duration, ok bool) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	sess, ok := s.sessions[id]
+	return sess.timeout, ok
+}
+
+// CheckSession is Session for a caller that claims to own the session:
+// password must be the one it was opened with. ErrSessionExpired if
+// it's not open, ErrSessionPassword if the password is wrong.
+func (s *Store) CheckSession(id int64, password string) (time.Duration, error) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	sess, ok := s.sessions[id]
+	if !ok {
+		return 0, ErrSessionExpired
+	}
+	if subtle.ConstantTimeCompare([]byte(sess.password), []byte(password)) != 1 {
+		return 0, ErrSessionPassword
+	}
+	return sess.timeout, nil
+}
+
+// applySession applies CREATE_SESSION and CLOSE_SESSION entries.
+// Closing returns the ephemeral nodes it deleted.
+func (s *Store) applySession(entry wal.Entry, txn znode.Txn) ([]string, error) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	switch entry.Op {
+	case wal.OpCreateSession:
+		s.sessions[entry.TxID] = session{
+			timeout:  time.Duration(entry.Timeout) * time.Millisecond,
+			password: string(entry.Data),
+		}
+		return nil, nil
+	default: // wal.OpCloseSession
+		if _, ok := s.sessions[entry.Session]; !ok {
+			return nil, ErrSessionExpired
+		}
+		delete(s.sessions, entry.Session)
+		return s.tree.DeleteEphemerals(entry.Session, txn), nil
+	}
+}
+
+// checkOwner is the check for a CREATE owned by a session. The session
+// must still be open when the entry is applied — not just when the
+// client sent it. A CLOSE_SESSION that commits first wins, and the node
+// is never created, on every replica.
+func (s *Store) checkOwner(session int64) error {
+	if _, ok := s.Session(session); !ok {
+		return ErrSessionExpired
+	}
+	return nil
+}
+
+// restoreSessions replaces the session table with a snapshot's.
+func (s *Store) restoreSessions(sessions []snapshot.SessionData) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	s.sessions = make(map[int64]session, len(sessions))
+	for _, sess := range sessions {
+		s.sessions[sess.ID] = session{
+			timeout:  time.Duration(sess.TimeoutMs) * time.Millisecond,
+			password: sess.Password,
+		}
+	}
+}
+
+// snapshotSessions lists the open sessions for a snapshot, by ID.
+func (s *Store) snapshotSessions() []snapshot.SessionData {
+	s.sessionsMu.Lock()
+	out := make([]snapshot.SessionData, 0, len(s.sessions))
+	for id, sess := range s.sessions {
+		out = append(out, snapshot.SessionData{ID: id, TimeoutMs: sess.timeout.Milliseconds(), Password: sess.password})
+	}
+	s.sessionsMu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
@@ -0,0 +1,41 @@
+package store
+
+// Numbers for the admin endpoints: how big is the tree, how much log is
+// on disk, how much of it a snapshot already covers. The equivalent of
+// the store half of ZooKeeper's `mntr`.
+
+// Stats describes what the Store holds right now.
+type Stats struct {
+	Znodes       int   // nodes in the tree, the root included
+	WALBytes     int64 // bytes on disk, every segment
+	WALSegments  int
+	FirstTxID    int64 // the oldest entry still in the log
+	LastTxID     int64 // the newest entry in the log, committed or not
+	CommitIndex  int64 // the newest entry applied to the tree
+	SnapshotTxID int64 // the entry the latest snapshot ends at
+	Sessions     int
+	Watches      int
+}
+
+// Stats returns the Store's numbers. It waits for a batch being written
+// to finish, like any other call that reads the log.
+func (s *Store) Stats() Stats {
+	s.mu.Lock()
+	walBytes, segments := s.wal.Size()
+	st := Stats{
+		WALBytes:     walBytes,
+		WALSegments:  segments,
+		FirstTxID:    s.firstTxID(),
+		LastTxID:     s.wal.LastTxID(),
+		CommitIndex:  s.commitIndex,
+		SnapshotTxID: s.snapTxID,
+	}
+	s.mu.Unlock()
+
+	st.Znodes = s.tree.Count()
+	s.sessionsMu.Lock()
+	st.Sessions = len(s.sessions)
+	s.sessionsMu.Unlock()
+	st.Watches = s.watches.Len()
+	return st
+}
@@ -32,21 +32,40 @@ package store
 
 import (
 	"fmt"
-	"time"
+	"math"
+	"sync"
 
+	"github.com/syamsularifin/zookeeper/internal/acl"
 	"github.com/syamsularifin/zookeeper/internal/snapshot"
 	"github.com/syamsularifin/zookeeper/internal/wal"
+	"github.com/syamsularifin/zookeeper/internal/watch"
 	"github.com/syamsularifin/zookeeper/internal/znode"
 )
 
 // Store is the durable data store. All mutations go through here.
+//
+// It's safe for concurrent use: writes take mu, one batch at a time,
+// and reads go to the tree, which has its own lock. See write.go.
 type Store struct {
+	// tree is never replaced, only restored in place, so reading the
+	// pointer needs no lock.
 	tree *znode.DataTree
-	wal  *wal.WAL
 
-	// entries is an in-memory cache of all WAL entries.
-	// It mirrors what's on disk — populated during recovery,
-	// appended to during AppendWAL.
+	// mu serializes everything that changes the Store, and guards every
+	// field below except the session table and the watches, which have
+	// their own locks.
+	mu sync.Mutex
+
+	// queue is the standalone writes waiting for the next batch. queueMu
+	// guards it, so joining doesn't wait for the batch on disk.
+	queueMu sync.Mutex
+	queue   []*pendingWrite
+
+	wal *wal.WAL
+
+	// entries is an in-memory cache of the WAL entries since the last
+	// compaction. It mirrors what's on disk — populated during recovery,
+	// appended to during AppendWAL, trimmed after a snapshot.
 	//
 	// Why keep this? The leader needs fast access to entries
 	// for replication (grab entries[nextIndex:] every tick).
@@ -58,6 +77,56 @@ type Store struct {
 
 	// snapPath is where we save/load the snapshot file.
 	snapPath string
+
+	// commitIndex is the highest TxID applied to the tree.
+	// Everything after it in entries is written but not (yet) committed.
+	// See commit.go.
+	commitIndex int64
+
+	// commitPath is the side file that persists commitIndex.
+	commitPath string
+
+	// commitMu serializes writes of the commit file, and guards
+	// savedCommit. SaveCommitIndex holds it, and not mu, across its
+	// fsyncs; everything else that writes the file takes it with mu
+	// held (see writeCommitIndex). So the order is always mu, then
+	// commitMu.
+	commitMu sync.Mutex
+
+	// savedCommit is the commit index last written to commitPath.
+	savedCommit int64
+
+	// trackCommit is true once the commit file exists. Until then
+	// (standalone mode) every WAL entry counts as committed.
+	trackCommit bool
+
+	// opts controls automatic snapshots and compaction.
+	opts Options
+
+	// compactedTo is the highest TxID discarded by compaction in this
+	// process. See compaction.go.
+	compactedTo int64
+
+	// snapTxID and snapTerm describe the latest snapshot on disk.
+	// TermAt needs them once the entry itself is compacted away.
+	snapTxID int64
+	snapTerm int64
+
+	// writesSinceSnap and bytesSinceSnap drive automatic snapshots.
+	writesSinceSnap int
+	bytesSinceSnap  int64
+
+	// sessions is every open client session: its timeout and password.
+	// See session.go. sessionsMu guards it: the leader's session tracker
+	// reads it from another goroutine.
+	sessionsMu sync.Mutex
+	sessions   map[int64]session
+
+	// config is the cluster membership, opaque. See config.go.
+	config []byte
+
+	// watches are fired as writes are applied. See watch.go.
+	watches *watch.Manager
 }
 
 // New creates a Store, recovers from snapshot + WAL, and is ready to serve.
@@ -66,13 +135,23 @@ type Store struct {
 //
 //   1. Load snapshot (if exists)  → tree has state at TxID X
 //   2. Open WAL                   → read all entries
-//   3. Replay WAL entries > X     → tree is fully up to date
+//   3. Load commit index          → entries <= C are committed
+//   4. Replay WAL entries X+1..C  → tree has every committed write
 //
 // On first boot (no snapshot, no WAL), we just start with an empty tree.
 func New(walPath string, snapPath string) (*Store, error) {
+	return NewWithOptions(walPath, snapPath, DefaultOptions())
+}
+
+// NewWithOptions is New with custom snapshot and compaction settings.
+func NewWithOptions(walPath string, snapPath string, opts Options) (*Store, error) {
 	s := &Store{
-		tree:     znode.NewDataTree(),
-		snapPath: snapPath,
+		tree:       znode.NewDataTree(),
+		snapPath:   snapPath,
+		commitPath: walPath + commitSuffix,
+		opts:       opts,
+		sessions:   make(map[int64]session),
+		watches:    watch.NewManager(),
 	}
 
 	// Step 1: Load snapshot if it exists.
@@ -87,8 +166,13 @@ func New(walPath string, snapPath string) (*Store, error) {
 	var snapshotTxID int64
 	if snap != nil {
 		s.tree.RestoreFromSnapshot(snap.Nodes)
+		s.restoreSessions(snap.Sessions)
+		s.config = snap.Config
 		snapshotTxID = snap.TxID
+		s.snapTxID = snap.TxID
+		s.snapTerm = snap.Term
 	}
+	s.commitIndex = snapshotTxID
 
 	// Step 2: Open the WAL file.
 	w, err := wal.Open(walPath)
@@ -97,13 +181,30 @@ func New(walPath string, snapPath string) (*Store, error) {
 	}
 	s.wal = w
 
-	// Step 3: Replay WAL entries that came AFTER the snapshot.
+	// Step 3: Load the commit index, if this node has ever been in a cluster.
+	//
+	// No file → standalone (or a data dir from before commit tracking):
+	// every entry in the WAL is committed.
+	commitIndex, tracked, err := loadCommitIndex(s.commitPath)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	s.trackCommit = tracked
+
+	// Step 4: Replay WAL entries that came AFTER the snapshot,
+	// up to the commit index.
 	//
 	// If snapshot was at TxID=100, we skip entries 1-100 (already in snapshot)
 	// and only replay 101, 102, 103...
 	//
-	// If there's no snapshot (snapshotTxID=0), we replay everything.
-	if err := s.replay(snapshotTxID); err != nil {
+	// If there's no snapshot (snapshotTxID=0), we replay everything
+	// that was committed.
+	limit := int64(math.MaxInt64)
+	if tracked {
+		limit = commitIndex
+	}
+	if err := s.replay(snapshotTxID, limit); err != nil {
 		w.Close()
 		return nil, fmt.Errorf("WAL replay failed: %w", err)
 	}
@@ -111,17 +212,21 @@ func New(walPath string, snapPath string) (*Store, error) {
 	return s, nil
 }
 
-// replay reads WAL entries and applies those that came after the snapshot.
+// replay reads WAL entries and applies those that came after the snapshot
+// and are known to be committed.
 //
 // afterTxID = 0 means "replay everything" (no snapshot).
 // afterTxID = 100 means "skip entries 1-100, replay 101+".
-func (s *Store) replay(afterTxID int64) error {
+// upToTxID = 120 means "stop after 120" — 121+ stay in the cache only,
+// waiting for Raft to either commit or truncate them.
+func (s *Store) replay(afterTxID, upToTxID int64) error {
 	entries, err := s.wal.ReadAll()
 	if err != nil {
 		return err
 	}
 
 	// Cache all entries in memory for fast access during replication.
+	// Uncommitted ones too — Raft still needs them to reconcile.
 	s.entries = entries
 
 	for _, entry := range entries {
@@ -130,41 +235,110 @@ func (s *Store) replay(afterTxID int64) error {
 			continue
 		}
 
+		// Stop at the first entry that may not be committed.
+		if entry.TxID > upToTxID {
+			break
+		}
+
 		// Ignore errors — see explanation in applyToTree.
-		_ = s.applyToTree(entry)
+		_, _ = s.applyToTree(entry)
+		s.commitIndex = entry.TxID
 	}
 
 	return nil
 }
 
 // applyToTree applies a single WAL entry to the in-memory tree.
-func (s *Store) applyToTree(entry wal.Entry) error {
+// The entry's TxID and Time become the zxid and time in the Stat.
+//
+// It returns the entry as applied. That's the entry itself, except for
+// a sequential CREATE: its Path becomes the node actually created. And
+// a MULTI comes back with its Results.
+//
+// Every entry that reaches here is committed, so this is also where
+// watches fire — only for writes that actually changed the tree.
+func (s *Store) applyToTree(entry wal.Entry) (wal.Entry, error) {
+	txn := znode.Txn{Zxid: entry.TxID, Time: entry.Time}
+	applied := entry
+
+	var deleted []string
+	var err error
 	switch entry.Op {
 	case wal.OpCreate:
-		return s.tree.Create(entry.Path, entry.Data)
+		applied.Path, err = s.create(entry, txn)
 	case wal.OpSet:
-		return s.tree.Set(entry.Path, entry.Data)
+		err = s.tree.Set(entry.Path, entry.Data, entry.Version, txn)
 	case wal.OpDelete:
-		return s.tree.Delete(entry.Path)
+		err = s.tree.Delete(entry.Path, entry.Version, txn)
+	case wal.OpCreateSession, wal.OpCloseSession:
+		deleted, err = s.applySession(entry, txn)
+	case wal.OpMulti:
+		applied.Results, err = s.multi(entry, txn)
+	case wal.OpSetACL:
+		err = s.tree.SetACL(entry.Path, entry.ACL, entry.Version)
+	case wal.OpConfig:
+		s.config = entry.Data
+	case wal.OpNoop:
 	default:
-		return fmt.Errorf("unknown operation: %s", entry.Op)
+		err = fmt.Errorf("unknown operation: %s", entry.Op)
+	}
+
+	if err != nil {
+		s.watches.Fire(entry.TxID, nil)
+		return entry, err
+	}
+	s.watches.Fire(entry.TxID, changes(applied, deleted))
+	return applied, nil
+}
+
+// create applies a CREATE — persistent or ephemeral, exact or
+// sequential, with its ACL — and returns the path created.
+func (s *Store) create(entry wal.Entry, txn znode.Txn) (string, error) {
+	if entry.Session != 0 {
+		if err := s.checkOwner(entry.Session); err != nil {
+			return "", err
+		}
 	}
+	return s.tree.CreateNode(znode.Op{
+		Path:       entry.Path,
+		Data:       entry.Data,
+		Owner:      entry.Session,
+		Sequential: entry.Sequential,
+		ACL:        entry.ACL,
+	}, txn)
 }
 
 // Create adds a new znode. WAL first, then tree.
 func (s *Store) Create(path string, data []byte) error {
-	// Step 1: WAL — record the intent to disk
-	_, err := s.wal.Append(wal.Entry{
+	_, err := s.write(wal.Entry{
 		Op:   wal.OpCreate,
 		Path: path,
 		Data: data,
 	})
-	if err != nil {
-		return fmt.Errorf("WAL write failed: %w", err)
-	}
+	return err
+}
+
+// CreateSequential creates a node named prefix + the parent's next
+// sequence number, and returns its path. A non-zero session makes it
+// ephemeral too. See znode.DataTree.CreateSequential.
+func (s *Store) CreateSequential(prefix string, data []byte, session int64) (string, error) {
+	applied, err := s.write(wal.Entry{
+		Op:         wal.OpCreate,
+		Path:       prefix,
+		Data:       data,
+		Session:    session,
+		Sequential: true,
+	})
+	return applied.Path, err
+}
 
-	// Step 2: Tree — apply in memory
-	return s.tree.Create(path, data)
+// CreateNode is the create that does it all, described by a CREATE
+// entry: Path and Data, the owning Session (0 for a persistent node),
+// Sequential and the ACL. Returns the path created.
+func (s *Store) CreateNode(entry wal.Entry) (string, error) {
+	entry.Op = wal.OpCreate
+	applied, err := s.write(entry)
+	return applied.Path, err
 }
 
 // Get reads a znode. No WAL needed — reads don't change anything.
@@ -172,31 +346,57 @@ func (s *Store) Get(path string) ([]byte, error) {
 	return s.tree.Get(path)
 }
 
-// Set updates a znode. WAL first, then tree.
-func (s *Store) Set(path string, data []byte) error {
-	_, err := s.wal.Append(wal.Entry{
-		Op:   wal.OpSet,
-		Path: path,
-		Data: data,
+// GetWithStat reads a znode's data and its Stat together.
+func (s *Store) GetWithStat(path string) ([]byte, znode.Stat, error) {
+	return s.tree.GetWithStat(path)
+}
+
+// Stat reads a znode's metadata.
+func (s *Store) Stat(path string) (znode.Stat, error) {
+	return s.tree.Stat(path)
+}
+
+// Set updates a znode if it's at the expected version (or any version,
+// with znode.AnyVersion). WAL first, then tree.
+//
+// A Set that fails the version check is still in the WAL — like any
+// failed write, it fails the same way on replay and changes nothing.
+func (s *Store) Set(path string, data []byte, version int32) error {
+	_, err := s.write(wal.Entry{
+		Op:      wal.OpSet,
+		Path:    path,
+		Data:    data,
+		Version: version,
 	})
-	if err != nil {
-		return fmt.Errorf("WAL write failed: %w", err)
-	}
+	return err
+}
 
-	return s.tree.Set(path, data)
+// GetACL reads a znode's ACL and its Stat.
+func (s *Store) GetACL(path string) ([]acl.ACL, znode.Stat, error) {
+	return s.tree.GetACL(path)
 }
 
-// Delete removes a znode. WAL first, then tree.
-func (s *Store) Delete(path string) error {
-	_, err := s.wal.Append(wal.Entry{
-		Op:   wal.OpDelete,
-		Path: path,
+// SetACL replaces a znode's ACL if it's at the expected ACL version
+// (or any, with znode.AnyVersion). WAL first, then tree.
+func (s *Store) SetACL(path string, acls []acl.ACL, version int32) error {
+	_, err := s.write(wal.Entry{
+		Op:      wal.OpSetACL,
+		Path:    path,
+		Version: version,
+		ACL:     acls,
 	})
-	if err != nil {
-		return fmt.Errorf("WAL write failed: %w", err)
-	}
+	return err
+}
 
-	return s.tree.Delete(path)
+// Delete removes a znode if it's at the expected version (or any
+// version, with znode.AnyVersion). WAL first, then tree.
+func (s *Store) Delete(path string, version int32) error {
+	_, err := s.write(wal.Entry{
+		Op:      wal.OpDelete,
+		Path:    path,
+		Version: version,
+	})
+	return err
 }
 
 // GetChildren lists children of a znode. No WAL needed — read only.
@@ -207,72 +407,232 @@ func (s *Store) GetChildren(path string) ([]string, error) {
 // TakeSnapshot saves the current tree state to disk.
 //
 // When to call this:
-//   - Periodically (e.g. every 1000 writes)
+//   - Periodically (automatic, see Options)
 //   - Before shutdown (to minimize replay on next startup)
 //
 // What it does:
 //   1. Ask the tree for a flat list of all znodes
-//   2. Ask the WAL for the current TxID
+//   2. Take the commit index as the snapshot's TxID
 //   3. Save both to the snapshot file
+//   4. Compact: drop entries the snapshot covers, keeping a tail
+//
+// Why commitIndex and not the WAL's last TxID? In cluster mode the WAL
+// can be ahead of the tree (written but not committed). The snapshot
+// holds exactly what's in the tree, so it must claim exactly that.
 //
 // After this, on next restart:
 //   - Load this snapshot → tree is at TxID X
 //   - Replay only WAL entries after X → much faster
 func (s *Store) TakeSnapshot() error {
-	snap := &snapshot.Snapshot{
-		TxID:      s.wal.LastTxID(),
-		Timestamp: time.Now(),
-		Nodes:     s.tree.ToSnapshot(),
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.takeSnapshot()
+}
+
+// takeSnapshot is TakeSnapshot with s.mu held.
+func (s *Store) takeSnapshot() error {
+	term, err := s.termAt(s.commitIndex)
+	if err != nil {
+		return fmt.Errorf("failed to find term of TxID %d: %w", s.commitIndex, err)
 	}
 
-	return snapshot.Save(s.snapPath, snap)
+	// Stream the tree straight into the file — no copy of it in memory.
+	w, err := snapshot.Create(s.snapPath, s.commitIndex, term)
+	if err != nil {
+		return err
+	}
+	if err := s.tree.WalkSnapshot(w.Add); err != nil {
+		w.Abort()
+		return err
+	}
+	for _, sess := range s.snapshotSessions() {
+		if err := w.AddSession(sess); err != nil {
+			w.Abort()
+			return err
+		}
+	}
+	if s.config != nil {
+		if err := w.SetConfig(s.config); err != nil {
+			w.Abort()
+			return err
+		}
+	}
+	if err := w.Commit(); err != nil {
+		return err
+	}
+	s.snapTxID = s.commitIndex
+	s.snapTerm = term
+	s.writesSinceSnap = 0
+	s.bytesSinceSnap = 0
+
+	return s.compact(s.snapTxID)
 }
 
-// AppendWAL writes an entry to the WAL (disk) and the in-memory cache.
-// The entry must already have a valid TxID — assigned by the leader.
-// It does NOT apply to the tree — that happens later, after commit.
+// AppendWAL writes entries to the WAL (disk) and the in-memory cache,
+// with one fsync for all of them. Each entry must already have a valid
+// TxID — assigned by the leader. It does NOT apply to the tree — that
+// happens later, after commit.
 //
 // Used by Raft:
-//   - Leader calls this during Propose (before replication)
+//   - Leader calls this during Propose, one batch of writes at a time
 //   - Follower calls this during HandleAppendEntries (when receiving entries)
-func (s *Store) AppendWAL(entry wal.Entry) error {
-	if err := s.wal.AppendEntry(entry); err != nil {
+//
+// The first call also creates the commit file: from now on the WAL may
+// hold entries that aren't committed, so replay has to know where to stop.
+func (s *Store) AppendWAL(entries ...wal.Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.trackCommit {
+		if err := s.writeCommitIndex(s.commitIndex); err != nil {
+			return err
+		}
+		s.trackCommit = true
+	}
+
+	if err := s.wal.AppendEntries(entries); err != nil {
 		return err
 	}
-	s.entries = append(s.entries, entry)
+	s.entries = append(s.entries, entries...)
 	return nil
 }
 
-// ApplyTree applies a single entry to the in-memory tree.
-// It does NOT write to the WAL — that already happened.
+// ApplyTree applies a single entry to the in-memory tree, and returns
+// it as applied (see applyToTree). It does NOT write to the WAL — that
+// already happened.
 //
 // Used by Raft after an entry is committed (majority confirmed).
-func (s *Store) ApplyTree(entry wal.Entry) error {
-	return s.applyToTree(entry)
+// The commit index moves forward even if the operation itself fails
+// (e.g. "already exists") — the entry is still committed.
+//
+// The commit index only moves in memory here. Raft saves it to the
+// commit file with SaveCommitIndex, once every tick.
+func (s *Store) ApplyTree(entry wal.Entry) (wal.Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	applied, err := s.applyToTree(entry)
+	if entry.TxID > s.commitIndex {
+		s.commitIndex = entry.TxID
+	}
+	s.noteWrite(entrySize(entry))
+	return applied, err
+}
+
+// SaveCommitIndex writes how far ApplyTree has got to the commit file,
+// if that moved since the last save. Before the commit file exists
+// (standalone mode) there's nothing to do.
+//
+// A failure is worth logging, not stopping for: the file is only a
+// lower bound, and Raft re-applies anything past it after a restart.
+//
+// The write takes two fsyncs, and mu isn't held for them: applies,
+// WAL appends and reads go on meanwhile. commitMu, taken before mu is
+// let go, keeps any other write of the file from landing in between,
+// so the index written is never older than one already there.
+func (s *Store) SaveCommitIndex() error {
+	s.mu.Lock()
+	if !s.trackCommit {
+		s.mu.Unlock()
+		return nil
+	}
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+	index := s.commitIndex
+	s.mu.Unlock()
+
+	if index == s.savedCommit {
+		return nil
+	}
+	if err := saveCommitIndex(s.commitPath, index); err != nil {
+		return err
+	}
+	s.savedCommit = index
+	return nil
+}
+
+// CommitIndex returns the highest TxID applied to the tree.
+// After a restart this is where Raft picks up applying again.
+func (s *Store) CommitIndex() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commitIndex
 }
 
 // GetWALEntriesFrom returns all cached entries starting at the given TxID.
-// Returns nil if fromTxID is beyond what we have.
+// Returns nil if fromTxID is beyond what we have, and wal.ErrCompacted
+// if it's before the first entry we still have.
 //
 // Used by the leader to grab entries for replication:
-//   entries := store.GetWALEntriesFrom(nextIndex[peer])
-func (s *Store) GetWALEntriesFrom(fromTxID int64) []wal.Entry {
-	// entries is 0-indexed, TxIDs are 1-indexed.
-	// TxID=1 is at entries[0], TxID=5 is at entries[4].
-	idx := int(fromTxID - 1)
-	if idx < 0 || idx >= len(s.entries) {
-		return nil
+//
+//	entries, err := store.GetWALEntriesFrom(nextIndex[peer])
+//
+// The slice is the cache itself, not a copy, and stays valid: entries
+// are never changed in place, and TruncateWALFrom makes sure later
+// appends don't reuse its array.
+func (s *Store) GetWALEntriesFrom(fromTxID int64) ([]wal.Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.walEntriesFrom(fromTxID)
+}
+
+// walEntriesFrom is GetWALEntriesFrom with s.mu held.
+func (s *Store) walEntriesFrom(fromTxID int64) ([]wal.Entry, error) {
+	if fromTxID < s.firstTxID() {
+		return nil, wal.ErrCompacted
+	}
+
+	// entries[0] is the first TxID we still have, not necessarily 1.
+	// After compaction to 4000: TxID=4001 is at entries[0].
+	idx := s.indexOf(fromTxID)
+	if idx >= len(s.entries) {
+		return nil, nil
+	}
+	return s.entries[idx:], nil
+}
+
+// TermAt returns the term of the entry at txID.
+//
+// Usually that's a cache lookup. The exception is the entry the latest
+// snapshot ends at: it may be compacted, but the snapshot remembers its
+// term. The leader needs exactly that entry's term as prevLog for the
+// first AppendEntries after a follower installs the snapshot.
+//
+// TxID 0 is "before the first entry" and has term 0.
+func (s *Store) TermAt(txID int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.termAt(txID)
+}
+
+// termAt is TermAt with s.mu held.
+func (s *Store) termAt(txID int64) (int64, error) {
+	if txID == 0 {
+		return 0, nil
+	}
+	if txID == s.snapTxID {
+		return s.snapTerm, nil
+	}
+	entries, err := s.walEntriesFrom(txID)
+	if err != nil {
+		return 0, err
+	}
+	if entries == nil {
+		return 0, fmt.Errorf("no entry at TxID %d", txID)
 	}
-	return s.entries[idx:]
+	return entries[0].Term, nil
 }
 
 // LastWALTxID returns the TxID of the last WAL entry.
 // Returns 0 if no entries exist.
 func (s *Store) LastWALTxID() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return s.wal.LastTxID()
 }
 
-// TruncateWALFrom removes all cached entries with TxID >= fromTxID.
+// TruncateWALFrom removes all entries with TxID >= fromTxID,
+// from the disk WAL and from the cache.
 //
 // Used when a follower discovers its log conflicts with the leader's.
 // The follower truncates its stale entries and accepts the leader's version.
@@ -283,23 +643,36 @@ func (s *Store) LastWALTxID() int64 {
 //	TruncateWALFrom(5)
 //	After:  entries = [1, 2, 3, 4]
 //
-// Note: this only truncates the in-memory cache. The disk WAL still has
-// the old entries, but they'll be overwritten by new appends with the
-// same TxIDs. On restart, the snapshot + WAL replay will produce the
-// correct state. Full disk WAL truncation is a later optimization.
-func (s *Store) TruncateWALFrom(fromTxID int64) {
-	idx := int(fromTxID - 1)
+// Disk first, then cache: if the disk truncation fails, the cache still
+// matches what a restart would see.
+//
+// The cache is cut to capacity too, so the next append gets a new
+// array: a slice GetWALEntriesFrom handed out earlier (an AppendEntries
+// still being sent) keeps the entries it had.
+func (s *Store) TruncateWALFrom(fromTxID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.wal.TruncateFrom(fromTxID); err != nil {
+		return fmt.Errorf("WAL truncate failed: %w", err)
+	}
+
+	idx := s.indexOf(fromTxID)
 	if idx < 0 {
 		idx = 0
 	}
 	if idx < len(s.entries) {
-		s.entries = s.entries[:idx]
+		s.entries = s.entries[:idx:idx]
 	}
+	return nil
 }
 
 // Close takes a final snapshot, then closes the WAL file.
 // The snapshot minimizes WAL replay on next startup.
 func (s *Store) Close() error {
-	s.TakeSnapshot()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.takeSnapshot()
 	return s.wal.Close()
 }
@@ -1,8 +1,19 @@
 package store
 
 import (
+	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/syamsularifin/zookeeper/internal/acl"
+	"github.com/syamsularifin/zookeeper/internal/snapshot"
+	"github.com/syamsularifin/zookeeper/internal/wal"
+	"github.com/syamsularifin/zookeeper/internal/watch"
+	"github.com/syamsularifin/zookeeper/internal/znode"
 )
 
 // helper to create WAL and snapshot paths in the same temp dir
@@ -39,7 +50,7 @@ func TestDataSurvivesRestart(t *testing.T) {
 	s1 := newTestStore(t, dir)
 	s1.Create("/app", []byte("hello"))
 	s1.Create("/app/config", []byte("port=5432"))
-	s1.Set("/app", []byte("updated"))
+	s1.Set("/app", []byte("updated"), znode.AnyVersion)
 	s1.Close() // takes snapshot + closes WAL
 
 	// Second run: opens same files — data should be back
@@ -69,7 +80,7 @@ func TestDeleteSurvivesRestart(t *testing.T) {
 	// First run: create then delete
 	s1 := newTestStore(t, dir)
 	s1.Create("/temp", []byte("gone soon"))
-	s1.Delete("/temp")
+	s1.Delete("/temp", znode.AnyVersion)
 	s1.Close()
 
 	// Second run: /temp should NOT exist
@@ -92,7 +103,7 @@ func TestSnapshotSpeedsUpRecovery(t *testing.T) {
 	s1.TakeSnapshot() // snapshot covers TxID 1 and 2
 
 	// More writes AFTER the snapshot
-	s1.Set("/app", []byte("v2")) // TxID 3
+	s1.Set("/app", []byte("v2"), znode.AnyVersion) // TxID 3
 	s1.Close()
 
 	// Second run: loads snapshot (TxID 1-2) + replays WAL (TxID 3)
@@ -111,3 +122,894 @@ func TestSnapshotSpeedsUpRecovery(t *testing.T) {
 		t.Fatalf("expected 'port=5432', got '%s'", string(data))
 	}
 }
+
+// crash simulates a process dying: the WAL file is closed but, unlike
+// Close, no final snapshot is taken.
+func crash(s *Store) {
+	s.wal.Close()
+}
+
+func TestRecoverySkipsUncommittedEntries(t *testing.T) {
+	dir := t.TempDir()
+
+	// Raft path: TxID 1 is committed and applied, TxID 2 is only appended.
+	s1 := newTestStore(t, dir)
+	s1.AppendWAL(wal.Entry{TxID: 1, Term: 1, Op: wal.OpCreate, Path: "/app", Data: []byte("v1")})
+	s1.ApplyTree(wal.Entry{TxID: 1, Term: 1, Op: wal.OpCreate, Path: "/app", Data: []byte("v1")})
+	s1.SaveCommitIndex()
+	s1.AppendWAL(wal.Entry{TxID: 2, Term: 1, Op: wal.OpCreate, Path: "/orphan"})
+	crash(s1)
+
+	s2 := newTestStore(t, dir)
+	defer s2.Close()
+
+	if data, err := s2.Get("/app"); err != nil || string(data) != "v1" {
+		t.Fatalf("committed /app should be applied, got %q (%v)", data, err)
+	}
+	if _, err := s2.Get("/orphan"); err == nil {
+		t.Fatal("uncommitted /orphan must not be applied on restart")
+	}
+	if s2.CommitIndex() != 1 {
+		t.Fatalf("expected commit index 1, got %d", s2.CommitIndex())
+	}
+
+	// The entry is still in the WAL cache for Raft to reconcile.
+	pending, _ := s2.GetWALEntriesFrom(2)
+	if len(pending) != 1 || pending[0].Path != "/orphan" {
+		t.Fatalf("expected /orphan to stay in the WAL cache, got %+v", pending)
+	}
+	if s2.LastWALTxID() != 2 {
+		t.Fatalf("expected last TxID 2, got %d", s2.LastWALTxID())
+	}
+
+	// Once Raft learns it's committed, it applies normally.
+	s2.ApplyTree(pending[0])
+	if _, err := s2.Get("/orphan"); err != nil {
+		t.Fatalf("/orphan should exist after commit: %v", err)
+	}
+}
+
+func TestRecoveryCrashBeforeAnyCommit(t *testing.T) {
+	dir := t.TempDir()
+
+	s1 := newTestStore(t, dir)
+	s1.AppendWAL(wal.Entry{TxID: 1, Term: 1, Op: wal.OpCreate, Path: "/app"})
+	crash(s1)
+
+	s2 := newTestStore(t, dir)
+	defer s2.Close()
+
+	if _, err := s2.Get("/app"); err == nil {
+		t.Fatal("/app was never committed and must not be applied")
+	}
+	if s2.CommitIndex() != 0 {
+		t.Fatalf("expected commit index 0, got %d", s2.CommitIndex())
+	}
+	if pending, _ := s2.GetWALEntriesFrom(1); len(pending) != 1 {
+		t.Fatal("the uncommitted entry should still be cached")
+	}
+}
+
+// TestCommitIndexOnlySavedOnRequest proves ApplyTree alone doesn't write
+// the commit file: a crash before SaveCommitIndex applies less on
+// restart, never more.
+func TestCommitIndexOnlySavedOnRequest(t *testing.T) {
+	dir := t.TempDir()
+
+	s1 := newTestStore(t, dir)
+	batch := []wal.Entry{
+		{TxID: 1, Term: 1, Op: wal.OpCreate, Path: "/a"},
+		{TxID: 2, Term: 1, Op: wal.OpCreate, Path: "/b"},
+		{TxID: 3, Term: 1, Op: wal.OpCreate, Path: "/c"},
+	}
+	if err := s1.AppendWAL(batch...); err != nil {
+		t.Fatalf("AppendWAL failed: %v", err)
+	}
+	s1.ApplyTree(batch[0])
+	s1.SaveCommitIndex()
+	s1.ApplyTree(batch[1])
+	s1.ApplyTree(batch[2])
+	crash(s1)
+
+	s2 := newTestStore(t, dir)
+	defer s2.Close()
+	if s2.CommitIndex() != 1 {
+		t.Fatalf("expected the saved commit index 1, got %d", s2.CommitIndex())
+	}
+	if _, err := s2.Get("/b"); err == nil {
+		t.Fatal("/b was applied but not saved as committed; replay must not apply it")
+	}
+	if s2.LastWALTxID() != 3 {
+		t.Fatalf("the whole batch should be in the WAL, got last TxID %d", s2.LastWALTxID())
+	}
+}
+
+// TestSaveCommitIndexAlongsideWrites saves the commit index while
+// entries are appended and applied (run it with -race): the save
+// doesn't hold up the writes, and whatever it last wrote is what a
+// restart finds.
+func TestSaveCommitIndexAlongsideWrites(t *testing.T) {
+	dir := t.TempDir()
+	s1 := newTestStore(t, dir)
+
+	const n = 50
+	stop := make(chan struct{})
+	saved := make(chan struct{})
+	go func() {
+		defer close(saved)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				s1.SaveCommitIndex()
+			}
+		}
+	}()
+	for i := int64(1); i <= n; i++ {
+		entry := wal.Entry{TxID: i, Term: 1, Op: wal.OpCreate, Path: fmt.Sprintf("/n%d", i)}
+		if err := s1.AppendWAL(entry); err != nil {
+			t.Fatalf("AppendWAL failed: %v", err)
+		}
+		s1.ApplyTree(entry)
+	}
+	close(stop)
+	<-saved
+	if err := s1.SaveCommitIndex(); err != nil {
+		t.Fatalf("SaveCommitIndex failed: %v", err)
+	}
+	crash(s1)
+
+	s2 := newTestStore(t, dir)
+	defer s2.Close()
+	if s2.CommitIndex() != n {
+		t.Fatalf("expected commit index %d, got %d", n, s2.CommitIndex())
+	}
+}
+
+func TestSnapshotStopsAtCommitIndex(t *testing.T) {
+	dir := t.TempDir()
+
+	s1 := newTestStore(t, dir)
+	s1.AppendWAL(wal.Entry{TxID: 1, Term: 1, Op: wal.OpCreate, Path: "/app"})
+	s1.ApplyTree(wal.Entry{TxID: 1, Term: 1, Op: wal.OpCreate, Path: "/app"})
+	s1.AppendWAL(wal.Entry{TxID: 2, Term: 1, Op: wal.OpCreate, Path: "/orphan"})
+	s1.Close() // snapshot must claim TxID 1, not 2
+
+	snap, err := snapshot.Load(filepath.Join(dir, "snapshot.json"))
+	if err != nil || snap == nil {
+		t.Fatalf("failed to load snapshot: %v", err)
+	}
+	if snap.TxID != 1 {
+		t.Fatalf("snapshot should stop at the commit index 1, got %d", snap.TxID)
+	}
+
+	s2 := newTestStore(t, dir)
+	defer s2.Close()
+	if _, err := s2.Get("/orphan"); err == nil {
+		t.Fatal("uncommitted /orphan must not come back after restart")
+	}
+}
+
+func TestStandaloneCrashAppliesEverything(t *testing.T) {
+	dir := t.TempDir()
+
+	// Standalone writes are committed immediately; no commit file needed.
+	s1 := newTestStore(t, dir)
+	s1.Create("/app", []byte("v1"))
+	s1.Set("/app", []byte("v2"), znode.AnyVersion)
+	crash(s1)
+
+	if _, err := os.Stat(filepath.Join(dir, "test.wal"+commitSuffix)); !os.IsNotExist(err) {
+		t.Fatalf("standalone mode should not create a commit file, got %v", err)
+	}
+
+	s2 := newTestStore(t, dir)
+	defer s2.Close()
+	if data, _ := s2.Get("/app"); string(data) != "v2" {
+		t.Fatalf("expected 'v2', got '%s'", string(data))
+	}
+	if s2.CommitIndex() != 2 {
+		t.Fatalf("expected commit index 2, got %d", s2.CommitIndex())
+	}
+}
+
+func TestTruncatedEntriesDoNotComeBack(t *testing.T) {
+	dir := t.TempDir()
+
+	// A follower has TxID 2 from a deposed leader, then takes the new
+	// leader's TxID 2 instead.
+	s1 := newTestStore(t, dir)
+	s1.AppendWAL(wal.Entry{TxID: 1, Term: 1, Op: wal.OpCreate, Path: "/app"})
+	s1.ApplyTree(wal.Entry{TxID: 1, Term: 1, Op: wal.OpCreate, Path: "/app"})
+	s1.AppendWAL(wal.Entry{TxID: 2, Term: 1, Op: wal.OpCreate, Path: "/stale"})
+	if err := s1.TruncateWALFrom(2); err != nil {
+		t.Fatalf("TruncateWALFrom failed: %v", err)
+	}
+	s1.AppendWAL(wal.Entry{TxID: 2, Term: 2, Op: wal.OpCreate, Path: "/leader"})
+	s1.ApplyTree(wal.Entry{TxID: 2, Term: 2, Op: wal.OpCreate, Path: "/leader"})
+	s1.SaveCommitIndex()
+	crash(s1)
+
+	s2 := newTestStore(t, dir)
+	defer s2.Close()
+
+	if _, err := s2.Get("/stale"); err == nil {
+		t.Fatal("truncated /stale came back after restart")
+	}
+	if _, err := s2.Get("/leader"); err != nil {
+		t.Fatalf("/leader should exist: %v", err)
+	}
+	entries, _ := s2.GetWALEntriesFrom(1)
+	if len(entries) != 2 || entries[1].Term != 2 {
+		t.Fatalf("expected [1, 2(term 2)] in the WAL, got %+v", entries)
+	}
+}
+
+// newStoreWithOptions is newTestStore with custom snapshot settings.
+func newStoreWithOptions(t *testing.T, dir string, opts Options) *Store {
+	s, err := NewWithOptions(
+		filepath.Join(dir, "test.wal"),
+		filepath.Join(dir, "snapshot.json"),
+		opts,
+	)
+	if err != nil {
+		t.Fatalf("NewWithOptions failed: %v", err)
+	}
+	return s
+}
+
+func TestSnapshotCompactsEntries(t *testing.T) {
+	dir := t.TempDir()
+
+	s1 := newStoreWithOptions(t, dir, Options{RetainEntries: 2})
+	for i := 1; i <= 10; i++ {
+		s1.Create(fmt.Sprintf("/n%d", i), []byte("v"))
+	}
+	if err := s1.TakeSnapshot(); err != nil {
+		t.Fatalf("TakeSnapshot failed: %v", err)
+	}
+
+	// Snapshot at 10, keep 2 → 9 and 10 are still cached.
+	if _, err := s1.GetWALEntriesFrom(8); !errors.Is(err, wal.ErrCompacted) {
+		t.Fatalf("expected ErrCompacted for TxID 8, got %v", err)
+	}
+	tail, err := s1.GetWALEntriesFrom(9)
+	if err != nil || len(tail) != 2 || tail[0].TxID != 9 {
+		t.Fatalf("expected [9, 10] in the tail, got %+v (%v)", tail, err)
+	}
+
+	// Past the end is still "nothing yet", not compacted.
+	if entries, err := s1.GetWALEntriesFrom(11); entries != nil || err != nil {
+		t.Fatalf("expected nil, nil past the end, got %+v, %v", entries, err)
+	}
+	crash(s1)
+
+	// Restart: snapshot + whatever segments survived compaction.
+	s2 := newStoreWithOptions(t, dir, Options{RetainEntries: 2})
+	defer s2.Close()
+	for i := 1; i <= 10; i++ {
+		if _, err := s2.Get(fmt.Sprintf("/n%d", i)); err != nil {
+			t.Fatalf("/n%d missing after restart: %v", i, err)
+		}
+	}
+	if err := s2.Create("/n11", nil); err != nil {
+		t.Fatalf("Create after restart failed: %v", err)
+	}
+	if s2.LastWALTxID() != 11 {
+		t.Fatalf("expected TxID 11, got %d", s2.LastWALTxID())
+	}
+}
+
+func TestStats(t *testing.T) {
+	s := newStoreWithOptions(t, t.TempDir(), Options{RetainEntries: 2})
+	defer s.Close()
+
+	for i := 1; i <= 10; i++ {
+		s.Create(fmt.Sprintf("/n%d", i), []byte("v"))
+	}
+	s.Delete("/n10", -1)
+	s.CreateSession(time.Second, "")
+	s.Watch("/n1", watch.Data, false)
+	if err := s.TakeSnapshot(); err != nil {
+		t.Fatalf("TakeSnapshot failed: %v", err)
+	}
+
+	st := s.Stats()
+	want := Stats{
+		Znodes:       10, // the root and /n1../n9
+		WALBytes:     st.WALBytes,
+		WALSegments:  st.WALSegments,
+		FirstTxID:    11, // snapshot at 12, keep 2
+		LastTxID:     12,
+		CommitIndex:  12,
+		SnapshotTxID: 12,
+		Sessions:     1,
+		Watches:      1,
+	}
+	if st != want {
+		t.Fatalf("Stats() = %+v, want %+v", st, want)
+	}
+	if st.WALBytes == 0 || st.WALSegments == 0 {
+		t.Fatalf("expected the WAL on disk, got %d bytes in %d segments", st.WALBytes, st.WALSegments)
+	}
+}
+
+func TestAutoSnapshotAfterWrites(t *testing.T) {
+	dir := t.TempDir()
+	s := newStoreWithOptions(t, dir, Options{SnapshotEveryWrites: 5})
+	defer s.Close()
+
+	for i := 1; i <= 5; i++ {
+		s.Create(fmt.Sprintf("/n%d", i), nil)
+	}
+
+	snap, err := snapshot.Load(filepath.Join(dir, "snapshot.json"))
+	if err != nil || snap == nil {
+		t.Fatalf("expected an automatic snapshot after 5 writes: %v", err)
+	}
+	if snap.TxID != 5 {
+		t.Fatalf("expected snapshot at TxID 5, got %d", snap.TxID)
+	}
+}
+
+func TestAutoSnapshotAfterBytes(t *testing.T) {
+	dir := t.TempDir()
+	s := newStoreWithOptions(t, dir, Options{SnapshotEveryBytes: 100})
+	defer s.Close()
+
+	s.Create("/small", []byte("x"))
+	if snap, _ := snapshot.Load(filepath.Join(dir, "snapshot.json")); snap != nil {
+		t.Fatal("no snapshot expected yet")
+	}
+
+	s.Create("/big", make([]byte, 200))
+	snap, _ := snapshot.Load(filepath.Join(dir, "snapshot.json"))
+	if snap == nil || snap.TxID != 2 {
+		t.Fatalf("expected a snapshot at TxID 2 after a large write, got %+v", snap)
+	}
+}
+
+// leaderWithSnapshot builds a Raft-mode store with entries 1..n in term 1,
+// all committed, and a snapshot at n. Returns the snapshot bytes.
+func leaderWithSnapshot(t *testing.T, n int64) []byte {
+	s := newTestStore(t, t.TempDir())
+	defer s.Close()
+	for i := int64(1); i <= n; i++ {
+		entry := wal.Entry{TxID: i, Term: 1, Op: wal.OpCreate, Path: fmt.Sprintf("/n%d", i)}
+		if err := s.AppendWAL(entry); err != nil {
+			t.Fatalf("AppendWAL failed: %v", err)
+		}
+		if _, err := s.ApplyTree(entry); err != nil {
+			t.Fatalf("ApplyTree failed: %v", err)
+		}
+	}
+	if err := s.TakeSnapshot(); err != nil {
+		t.Fatalf("TakeSnapshot failed: %v", err)
+	}
+
+	data, txID, term, err := s.ReadSnapshot()
+	if err != nil {
+		t.Fatalf("ReadSnapshot failed: %v", err)
+	}
+	if txID != n || term != 1 {
+		t.Fatalf("expected snapshot at TxID %d term 1, got TxID %d term %d", n, txID, term)
+	}
+	return data
+}
+
+func TestRestoreSnapshotResetsDivergentLog(t *testing.T) {
+	data := leaderWithSnapshot(t, 5)
+	dir := t.TempDir()
+
+	// The follower only has a stale entry from another leader.
+	f := newTestStore(t, dir)
+	f.AppendWAL(wal.Entry{TxID: 1, Term: 0, Op: wal.OpCreate, Path: "/stale"})
+
+	if err := f.RestoreSnapshot(data, 5, 1); err != nil {
+		t.Fatalf("RestoreSnapshot failed: %v", err)
+	}
+	if _, err := f.Get("/stale"); err == nil {
+		t.Fatal("/stale should be gone")
+	}
+	if _, err := f.Get("/n5"); err != nil {
+		t.Fatalf("/n5 should come from the snapshot: %v", err)
+	}
+	if f.LastWALTxID() != 5 || f.CommitIndex() != 5 {
+		t.Fatalf("expected log and commit index at 5, got %d and %d", f.LastWALTxID(), f.CommitIndex())
+	}
+	if term, err := f.TermAt(5); err != nil || term != 1 {
+		t.Fatalf("expected TermAt(5) = 1, got %d (%v)", term, err)
+	}
+
+	// Replication continues right after the snapshot.
+	if err := f.AppendWAL(wal.Entry{TxID: 6, Term: 1, Op: wal.OpCreate, Path: "/n6"}); err != nil {
+		t.Fatalf("AppendWAL after restore failed: %v", err)
+	}
+	crash(f)
+
+	f2 := newTestStore(t, dir)
+	defer f2.Close()
+	if _, err := f2.Get("/n5"); err != nil {
+		t.Fatalf("/n5 missing after restart: %v", err)
+	}
+	if _, err := f2.Get("/stale"); err == nil {
+		t.Fatal("/stale came back after restart")
+	}
+	if f2.LastWALTxID() != 6 {
+		t.Fatalf("expected entry 6 in the WAL after restart, got %d", f2.LastWALTxID())
+	}
+}
+
+func TestRestoreSnapshotKeepsMatchingTail(t *testing.T) {
+	data := leaderWithSnapshot(t, 5)
+
+	// The follower received 1..7 from the same leader but applied nothing.
+	f := newTestStore(t, t.TempDir())
+	defer f.Close()
+	for i := int64(1); i <= 7; i++ {
+		f.AppendWAL(wal.Entry{TxID: i, Term: 1, Op: wal.OpCreate, Path: fmt.Sprintf("/n%d", i)})
+	}
+
+	if err := f.RestoreSnapshot(data, 5, 1); err != nil {
+		t.Fatalf("RestoreSnapshot failed: %v", err)
+	}
+
+	if _, err := f.GetWALEntriesFrom(5); !errors.Is(err, wal.ErrCompacted) {
+		t.Fatalf("entries covered by the snapshot should be compacted, got %v", err)
+	}
+	tail, err := f.GetWALEntriesFrom(6)
+	if err != nil || len(tail) != 2 {
+		t.Fatalf("expected entries 6 and 7 to survive, got %+v (%v)", tail, err)
+	}
+	if _, err := f.Get("/n6"); err == nil {
+		t.Fatal("/n6 is not committed and must not be in the tree")
+	}
+}
+
+func TestRestoreSnapshotRejectsMismatchedMeta(t *testing.T) {
+	data := leaderWithSnapshot(t, 3)
+
+	f := newTestStore(t, t.TempDir())
+	defer f.Close()
+	if err := f.RestoreSnapshot(data, 4, 1); err == nil {
+		t.Fatal("expected error when TxID doesn't match the snapshot")
+	}
+}
+
+func TestJSONSnapshotFromOlderVersionStillLoads(t *testing.T) {
+	dir := t.TempDir()
+	old := newTestStore(t, dir)
+	old.Create("/app", []byte("hello"))
+	crash(old)
+
+	// What an older version would have left behind after a snapshot.
+	snapPath := filepath.Join(dir, "snapshot.json")
+	legacy := `{"tx_id": 1, "timestamp": "2026-05-23T12:00:00Z", "nodes": [{"path": "/"}, {"path": "/app", "data": "aGVsbG8="}]}`
+	if err := os.WriteFile(snapPath, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestStore(t, dir)
+	if data, err := s.Get("/app"); err != nil || string(data) != "hello" {
+		t.Fatalf("expected /app from the JSON snapshot, got %q (%v)", data, err)
+	}
+	s.Create("/next", nil)
+	s.Close() // takes a new snapshot
+
+	raw, _ := os.ReadFile(snapPath)
+	if len(raw) > 0 && raw[0] == '{' {
+		t.Fatal("the next snapshot should be written in the binary format")
+	}
+	snap, err := snapshot.Load(snapPath)
+	if err != nil || snap.TxID != 2 || len(snap.Nodes) != 3 {
+		t.Fatalf("expected a binary snapshot at TxID 2 with 3 nodes, got %+v (%v)", snap, err)
+	}
+}
+
+func TestConditionalSetAndDelete(t *testing.T) {
+	s := newTestStore(t, t.TempDir())
+	defer s.Close()
+
+	s.Create("/app", []byte("v0"))
+	if err := s.Set("/app", []byte("v1"), 0); err != nil {
+		t.Fatalf("Set at version 0 failed: %v", err)
+	}
+	if err := s.Set("/app", []byte("lost"), 0); !errors.Is(err, znode.ErrBadVersion) {
+		t.Fatalf("expected ErrBadVersion, got %v", err)
+	}
+	if err := s.Delete("/app", 0); !errors.Is(err, znode.ErrBadVersion) {
+		t.Fatalf("expected ErrBadVersion, got %v", err)
+	}
+
+	data, st, err := s.GetWithStat("/app")
+	if err != nil || string(data) != "v1" || st.Version != 1 {
+		t.Fatalf("expected v1 at version 1, got %q at %d (%v)", data, st.Version, err)
+	}
+	if st.Czxid != 1 || st.Mzxid != 2 || st.Mtime < st.Ctime || st.Ctime == 0 {
+		t.Fatalf("Stat not stamped from the WAL entries: %+v", st)
+	}
+}
+
+// TestStatSurvivesReplay proves replaying the WAL rebuilds the same Stat
+// — including failed conditional writes, which must fail again.
+func TestStatSurvivesReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	s1 := newTestStore(t, dir)
+	s1.Create("/app", []byte("v0"))
+	s1.Set("/app", []byte("v1"), 0)
+	s1.Set("/app", []byte("lost"), 0) // fails, but is in the WAL
+	want, _ := s1.Stat("/app")
+	crash(s1)
+
+	s2 := newTestStore(t, dir)
+	defer s2.Close()
+
+	data, got, err := s2.GetWithStat("/app")
+	if err != nil {
+		t.Fatalf("Get after replay failed: %v", err)
+	}
+	if string(data) != "v1" || got != want {
+		t.Fatalf("expected %q %+v after replay, got %q %+v", "v1", want, data, got)
+	}
+}
+
+// TestACLSurvivesReplay proves CREATE's ACL and SET_ACL come back from
+// the WAL, and from a snapshot — a failed SetACL fails again on replay.
+func TestACLSurvivesReplay(t *testing.T) {
+	dir := t.TempDir()
+	readOnly := []acl.ACL{{Perms: acl.Read, ID: acl.Anyone}}
+	alice := []acl.ACL{{Perms: acl.All, ID: acl.Digest("alice", "secret")}}
+
+	s1 := newTestStore(t, dir)
+	if _, err := s1.CreateNode(wal.Entry{Path: "/app", ACL: readOnly}); err != nil {
+		t.Fatalf("CreateNode failed: %v", err)
+	}
+	s1.Create("/other", nil)
+	s1.TakeSnapshot()
+	if err := s1.SetACL("/other", alice, 0); err != nil {
+		t.Fatalf("SetACL failed: %v", err)
+	}
+	if err := s1.SetACL("/other", acl.Open, 0); !errors.Is(err, znode.ErrBadVersion) {
+		t.Fatalf("expected ErrBadVersion, got %v", err) // in the WAL all the same
+	}
+	crash(s1)
+
+	s2 := newTestStore(t, dir)
+	defer s2.Close()
+
+	for path, want := range map[string][]acl.ACL{"/app": readOnly, "/other": alice} {
+		got, _, err := s2.GetACL(path)
+		if err != nil || len(got) != 1 || got[0] != want[0] {
+			t.Fatalf("%s: expected %v after replay, got %v (%v)", path, want, got, err)
+		}
+	}
+	if _, st, _ := s2.GetACL("/other"); st.Aversion != 1 {
+		t.Fatalf("expected aversion 1 after replay, got %d", st.Aversion)
+	}
+}
+
+func TestCloseSessionDeletesEphemerals(t *testing.T) {
+	s := newTestStore(t, t.TempDir())
+	defer s.Close()
+
+	id, err := s.CreateSession(5*time.Second, "pw")
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	s.Create("/workers", nil)
+	if err := s.CreateEphemeral("/workers/a", []byte("up"), id); err != nil {
+		t.Fatalf("CreateEphemeral failed: %v", err)
+	}
+
+	if _, err := s.CheckSession(id, "pw"); err != nil {
+		t.Fatalf("CheckSession with the password failed: %v", err)
+	}
+	if _, err := s.CheckSession(id, "guess"); !errors.Is(err, ErrSessionPassword) {
+		t.Fatalf("expected ErrSessionPassword, got %v", err)
+	}
+
+	if err := s.CloseSession(id); err != nil {
+		t.Fatalf("CloseSession failed: %v", err)
+	}
+	if _, err := s.Get("/workers/a"); err == nil {
+		t.Fatal("ephemeral node should be gone with its session")
+	}
+	if _, ok := s.Session(id); ok {
+		t.Fatal("session should be closed")
+	}
+
+	// Too late: the session is gone.
+	if err := s.CreateEphemeral("/workers/b", nil, id); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("expected ErrSessionExpired, got %v", err)
+	}
+	if err := s.CloseSession(id); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("expected ErrSessionExpired closing twice, got %v", err)
+	}
+	if _, err := s.CheckSession(id, "pw"); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("expected ErrSessionExpired checking a closed session, got %v", err)
+	}
+}
+
+// TestSessionsSurviveRestart proves sessions and their ephemeral nodes
+// come back from the snapshot and from WAL replay alike.
+func TestSessionsSurviveRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	s1 := newTestStore(t, dir)
+	inSnap, _ := s1.CreateSession(3*time.Second, "snap-pw")
+	s1.CreateEphemeral("/a", nil, inSnap)
+	s1.TakeSnapshot()
+	inWAL, _ := s1.CreateSession(4*time.Second, "wal-pw")
+	s1.CreateEphemeral("/b", nil, inWAL)
+	crash(s1)
+
+	s2 := newTestStore(t, dir)
+	defer s2.Close()
+
+	want := map[int64]time.Duration{inSnap: 3 * time.Second, inWAL: 4 * time.Second}
+	if got := s2.Sessions(); len(got) != 2 || got[inSnap] != want[inSnap] || got[inWAL] != want[inWAL] {
+		t.Fatalf("expected sessions %v, got %v", want, got)
+	}
+	for id, password := range map[int64]string{inSnap: "snap-pw", inWAL: "wal-pw"} {
+		if _, err := s2.CheckSession(id, password); err != nil {
+			t.Fatalf("session %d should keep its password: %v", id, err)
+		}
+	}
+
+	// Closing the session from the snapshot still finds its node.
+	s2.CloseSession(inSnap)
+	if _, err := s2.Get("/a"); err == nil {
+		t.Fatal("/a should be gone with its session")
+	}
+	if _, err := s2.Get("/b"); err != nil {
+		t.Fatalf("/b belongs to another session and should stay: %v", err)
+	}
+}
+
+// TestWatchesFireOnAppliedWrites proves every successful write fires its
+// events, a failed one fires none, and the zxid still moves past it.
+func TestWatchesFireOnAppliedWrites(t *testing.T) {
+	s := newTestStore(t, t.TempDir())
+	defer s.Close()
+
+	app := s.Watch("/app", watch.Data, true)
+	root := s.Watch("/", watch.Children, true)
+
+	id, _ := s.CreateSession(5*time.Second, "")
+	s.Create("/app", []byte("v1"))
+	s.Set("/app", []byte("v2"), znode.AnyVersion)
+	s.Set("/app", []byte("v3"), 7) // bad version: no event
+	s.CreateEphemeral("/lock", nil, id)
+	s.CloseSession(id)
+	s.Delete("/app", znode.AnyVersion)
+
+	app.Cancel()
+	root.Cancel()
+
+	check := func(name string, w *watch.Watch, want []watch.Event) {
+		t.Helper()
+		var got []watch.Event
+		for ev := range w.Events {
+			got = append(got, ev)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("%s: expected %v, got %v", name, want, got)
+		}
+	}
+	check("/app data", app, []watch.Event{
+		{Type: watch.NodeCreated, Path: "/app", Zxid: 2},
+		{Type: watch.NodeDataChanged, Path: "/app", Zxid: 3},
+		{Type: watch.NodeDeleted, Path: "/app", Zxid: 7},
+	})
+	check("/ children", root, []watch.Event{
+		{Type: watch.NodeChildrenChanged, Path: "/", Zxid: 2},
+		{Type: watch.NodeChildrenChanged, Path: "/", Zxid: 5},
+		{Type: watch.NodeChildrenChanged, Path: "/", Zxid: 6}, // /lock went with its session
+		{Type: watch.NodeChildrenChanged, Path: "/", Zxid: 7},
+	})
+
+	if w := s.Watch("/app", watch.Data, false); w.Zxid != 7 {
+		t.Fatalf("a new watch should start at zxid 7, got %d", w.Zxid)
+	}
+}
+
+// TestSequentialNamesAreReplayed proves a restart picks up the sequence
+// where it left off, whether the nodes come from the snapshot or the WAL,
+// and that watches see the path actually created.
+func TestSequentialNamesAreReplayed(t *testing.T) {
+	dir := t.TempDir()
+
+	s1 := newTestStore(t, dir)
+	s1.Create("/q", nil)
+	s1.CreateSequential("/q/job-", nil, 0)
+	s1.TakeSnapshot()
+	inWAL, err := s1.CreateSequential("/q/job-", []byte("x"), 0)
+	if err != nil || inWAL != "/q/job-0000000001" {
+		t.Fatalf("expected /q/job-0000000001, got %q (%v)", inWAL, err)
+	}
+	crash(s1)
+
+	s2 := newTestStore(t, dir)
+	defer s2.Close()
+	if data, err := s2.Get(inWAL); err != nil || string(data) != "x" {
+		t.Fatalf("expected %s=x after replay, got %q (%v)", inWAL, data, err)
+	}
+
+	w := s2.Watch("/q/job-0000000002", watch.Data, false)
+	next, _ := s2.CreateSequential("/q/job-", nil, 0)
+	if next != "/q/job-0000000002" {
+		t.Fatalf("expected the sequence to continue at 2, got %q", next)
+	}
+	if ev := <-w.Events; ev.Type != watch.NodeCreated || ev.Path != next {
+		t.Fatalf("expected NodeCreated %s, got %v", next, ev)
+	}
+}
+
+// TestMultiIsOneEntry proves a MULTI is logged as one entry, comes back
+// whole on replay, and a failed one leaves nothing behind.
+func TestMultiIsOneEntry(t *testing.T) {
+	dir := t.TempDir()
+
+	s1 := newTestStore(t, dir)
+	s1.Create("/app", nil)
+	results, err := s1.Multi([]wal.Op{
+		{Op: wal.OpCreate, Path: "/app/config", Data: []byte("v2"), Version: znode.AnyVersion},
+		{Op: wal.OpCreate, Path: "/app/v-", Version: znode.AnyVersion, Sequential: true},
+	})
+	if err != nil {
+		t.Fatalf("Multi failed: %v", err)
+	}
+	if results[1].Path != "/app/v-0000000001" {
+		t.Fatalf("expected /app/v-0000000001, got %q", results[1].Path)
+	}
+	if got := s1.LastWALTxID(); got != 2 {
+		t.Fatalf("expected 2 WAL entries, got %d", got)
+	}
+
+	_, err = s1.Multi([]wal.Op{
+		{Op: wal.OpSet, Path: "/app/config", Data: []byte("v3"), Version: znode.AnyVersion},
+		{Op: wal.OpCheck, Path: "/missing", Version: znode.AnyVersion},
+	})
+	var me *znode.MultiError
+	if !errors.As(err, &me) || me.Index != 1 {
+		t.Fatalf("expected op 1 to fail, got %v", err)
+	}
+	crash(s1)
+
+	s2 := newTestStore(t, dir)
+	defer s2.Close()
+	if data, err := s2.Get("/app/config"); err != nil || string(data) != "v2" {
+		t.Fatalf("expected /app/config=v2 after replay, got %q (%v)", data, err)
+	}
+	if _, err := s2.Get("/app/v-0000000001"); err != nil {
+		t.Fatalf("expected the sequential node after replay: %v", err)
+	}
+}
+
+// TestConfigSurvivesRestart proves the membership set by CONFIG entries
+// comes back from the snapshot, and from the WAL after it.
+func TestConfigSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	s1 := newTestStore(t, dir)
+	for i, members := range []string{"a,b,c", "a,b,c,d"} {
+		entry := wal.Entry{TxID: int64(i) + 1, Term: 1, Op: wal.OpConfig, Data: []byte(members)}
+		s1.AppendWAL(entry)
+		s1.ApplyTree(entry)
+		if i == 0 {
+			s1.TakeSnapshot()
+		}
+	}
+	s1.SaveCommitIndex()
+	crash(s1)
+
+	s2 := newTestStore(t, dir)
+	defer s2.Close()
+	if got := string(s2.Config()); got != "a,b,c,d" {
+		t.Fatalf("expected config a,b,c,d after replay, got %q", got)
+	}
+
+	// A snapshot installed from the leader brings its own config.
+	data, _ := snapshot.Encode(&snapshot.Snapshot{TxID: 9, Term: 2, Nodes: []snapshot.NodeData{{Path: "/"}}, Config: []byte("a,b")})
+	if err := s2.RestoreSnapshot(data, 9, 2); err != nil {
+		t.Fatalf("RestoreSnapshot failed: %v", err)
+	}
+	if got := string(s2.Config()); got != "a,b" {
+		t.Fatalf("expected config a,b from the snapshot, got %q", got)
+	}
+}
+
+// TestConcurrentWrites proves writes from many goroutines each get their
+// own TxID, all land in the tree, and all come back after a restart —
+// with automatic snapshots taken in between.
+func TestConcurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+	s1 := newStoreWithOptions(t, dir, Options{SnapshotEveryWrites: 50, RetainEntries: 10})
+
+	const writers, each = 8, 40
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				p := fmt.Sprintf("/w%d-%d", w, i)
+				if err := s1.Create(p, []byte("v")); err != nil {
+					t.Errorf("Create %s failed: %v", p, err)
+				}
+				if _, err := s1.Get(p); err != nil {
+					t.Errorf("%s missing right after Create: %v", p, err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if got := s1.LastWALTxID(); got != writers*each {
+		t.Fatalf("expected %d TxIDs, got %d", writers*each, got)
+	}
+	czxids := make(map[int64]string)
+	for w := 0; w < writers; w++ {
+		for i := 0; i < each; i++ {
+			p := fmt.Sprintf("/w%d-%d", w, i)
+			stat, err := s1.Stat(p)
+			if err != nil {
+				t.Fatalf("%s missing: %v", p, err)
+			}
+			if other, ok := czxids[stat.Czxid]; ok {
+				t.Fatalf("%s and %s were both created by TxID %d", p, other, stat.Czxid)
+			}
+			czxids[stat.Czxid] = p
+		}
+	}
+	crash(s1)
+
+	s2 := newStoreWithOptions(t, dir, Options{})
+	defer s2.Close()
+	children, err := s2.GetChildren("/")
+	if err != nil || len(children) != writers*each {
+		t.Fatalf("expected %d nodes after restart, got %d (%v)", writers*each, len(children), err)
+	}
+}
+
+// TestGroupCommit proves writes that queue up while a batch is being
+// written go to disk together, as the next batch.
+func TestGroupCommit(t *testing.T) {
+	s := newTestStore(t, t.TempDir())
+	defer s.Close()
+
+	// Hold the write lock, as a batch on its way to disk would, until
+	// every writer has queued up behind it.
+	const writers = 5
+	s.mu.Lock()
+	errs := make(chan error, writers)
+	for w := 0; w < writers; w++ {
+		go func(w int) { errs <- s.Create(fmt.Sprintf("/w%d", w), nil) }(w)
+	}
+	for queued := 0; queued < writers; {
+		time.Sleep(time.Millisecond)
+		s.queueMu.Lock()
+		queued = len(s.queue)
+		s.queueMu.Unlock()
+	}
+	s.mu.Unlock()
+
+	for w := 0; w < writers; w++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+
+	// One batch: consecutive TxIDs, one timestamp.
+	entries, err := s.GetWALEntriesFrom(1)
+	if err != nil || len(entries) != writers {
+		t.Fatalf("expected %d entries, got %d (%v)", writers, len(entries), err)
+	}
+	for i, e := range entries {
+		if e.TxID != int64(i)+1 || e.Time != entries[0].Time {
+			t.Fatalf("expected one batch of TxIDs 1..%d, got %+v", writers, entries)
+		}
+	}
+}
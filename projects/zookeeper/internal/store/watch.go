@@ -0,0 +1,66 @@
+package store
+
+// Watches, fired as committed writes are applied.
+//
+// applyToTree is the one place every committed write passes through:
+// standalone writes right after the WAL append, cluster writes when
+// Raft calls ApplyTree. So that's where events come from, and a watcher
+// never hears about a write that might still be rolled back.
+//
+// Each node fires its own watches as it applies. A client watching a
+// follower hears about a write when that follower applies it — usually
+// a heartbeat after the leader.
+
+import (
+	"path"
+
+	"github.com/syamsularifin/zookeeper/internal/wal"
+	"github.com/syamsularifin/zookeeper/internal/watch"
+)
+
+// Watch registers a watch on p. Read its Events; Cancel it when done.
+// Every write applied after w.Zxid that matches is delivered.
+func (s *Store) Watch(p string, kind watch.Kind, persistent bool) *watch.Watch {
+	return s.watches.Add(path.Clean(p), kind, persistent)
+}
+
+// changes lists the events of a write that succeeded, as applied.
+// deleted is the ephemeral nodes a CLOSE_SESSION took with it. A MULTI
+// fires the events of each of its ops, in order, all at its zxid.
+func changes(entry wal.Entry, deleted []string) []watch.Event {
+	zxid := entry.TxID
+	switch entry.Op {
+	case wal.OpCreate:
+		p := path.Clean(entry.Path)
+		return []watch.Event{
+			{Type: watch.NodeCreated, Path: p, Zxid: zxid},
+			{Type: watch.NodeChildrenChanged, Path: path.Dir(p), Zxid: zxid},
+		}
+	case wal.OpSet:
+		return []watch.Event{{Type: watch.NodeDataChanged, Path: path.Clean(entry.Path), Zxid: zxid}}
+	case wal.OpDelete:
+		return deletedEvents(zxid, path.Clean(entry.Path))
+	case wal.OpCloseSession:
+		return deletedEvents(zxid, deleted...)
+	case wal.OpMulti:
+		var events []watch.Event
+		for i, op := range entry.Ops {
+			events = append(events, changes(wal.Entry{TxID: zxid, Op: op.Op, Path: entry.Results[i].Path}, nil)...)
+		}
+		return events
+	default:
+		return nil
+	}
+}
+
+// deletedEvents lists the events of deleting paths.
+func deletedEvents(zxid int64, paths ...string) []watch.Event {
+	events := make([]watch.Event, 0, 2*len(paths))
+	for _, p := range paths {
+		events = append(events,
+			watch.Event{Type: watch.NodeDeleted, Path: p, Zxid: zxid},
+			watch.Event{Type: watch.NodeChildrenChanged, Path: path.Dir(p), Zxid: zxid},
+		)
+	}
+	return events
+}
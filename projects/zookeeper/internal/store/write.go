@@ -0,0 +1,138 @@
+package store
+
+// Standalone writes from many clients at once.
+//
+// THE PROBLEM:
+//
+// grpc-go serves every RPC on its own goroutine. Two Creates at once
+// used to go straight to the WAL: both read its nextTxID, both appended
+// TxID 8, and both added a child to the same Children map. And every
+// write paid for its own fsync, one after another:
+//
+//   client A: append, fsync ─ apply
+//   client B:                        append, fsync ─ apply
+//   client C:                                               append, ...
+//
+// THE FIX: ONE WRITER AT A TIME, MANY WRITES PER FSYNC
+//
+// Every write joins a queue, then waits for s.mu. Whoever gets s.mu
+// takes the WHOLE queue — its own write and whatever piled up while the
+// last batch was on disk — and commits it in one go:
+//
+//   queue: [A B C]  ──→  TxIDs 8-10, one WAL write, one fsync
+//                   ──→  apply 8, 9, 10 in order, answer A, B, C
+//
+// A writer that finds its write already done by someone else's batch
+// just returns the result. The more writers wait, the bigger the
+// batches, the fewer fsyncs per write — the same group commit Raft does
+// for cluster writes (cluster/pipeline.go).
+//
+// Writes are applied in TxID order, the order of the WAL: a replay
+// builds exactly the same tree.
+//
+// WHO TAKES s.mu:
+//
+//   standalone writes      write() / commitQueue()
+//   Raft                   AppendWAL, ApplyTree, TruncateWALFrom, TermAt, ...
+//   snapshots              TakeSnapshot, RestoreSnapshot, Close
+//
+// Reads (Get, GetChildren, Stat, GetACL) don't: they go straight to the
+// tree, which has its own RWMutex. A read never waits for an fsync.
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/syamsularifin/zookeeper/internal/wal"
+)
+
+// pendingWrite is one standalone write waiting for its batch.
+type pendingWrite struct {
+	entry wal.Entry
+
+	// applied, err and done are set by whoever commits the batch, with
+	// s.mu held.
+	applied wal.Entry
+	err     error
+	done    bool
+}
+
+// write logs entry and applies it, in a batch with whatever other
+// writes are waiting. It returns the entry as applied (see
+// applyToTree).
+func (s *Store) write(entry wal.Entry) (wal.Entry, error) {
+	w := &pendingWrite{entry: entry}
+
+	s.queueMu.Lock()
+	s.queue = append(s.queue, w)
+	s.queueMu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Whoever held s.mu before us may have taken our write along.
+	// If not, it's in the queue, and we take it — with everyone else's.
+	if !w.done {
+		s.commitQueue()
+	}
+	return w.applied, w.err
+}
+
+// commitQueue writes every queued write to the WAL with one fsync, then
+// applies them in order.
+//
+// Must be called with s.mu held.
+func (s *Store) commitQueue() {
+	s.queueMu.Lock()
+	batch := s.queue
+	s.queue = nil
+	s.queueMu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+
+	// With no cluster to wait for, a write is committed as soon as it's
+	// on disk.
+	first := s.wal.LastTxID() + 1
+	now := time.Now().UnixMilli()
+	entries := make([]wal.Entry, len(batch))
+	for i, w := range batch {
+		w.entry.TxID = first + int64(i)
+		w.entry.Time = now
+		entries[i] = w.entry
+	}
+	err := s.wal.AppendEntries(entries)
+	if err != nil {
+		err = fmt.Errorf("WAL write failed: %w", err)
+	} else {
+		s.entries = append(s.entries, entries...)
+		err = s.markCommitted(entries[len(entries)-1].TxID)
+	}
+	if err != nil {
+		for _, w := range batch {
+			w.err, w.done = err, true
+		}
+		return
+	}
+
+	for _, w := range batch {
+		w.applied, w.err = s.applyToTree(w.entry)
+		w.done = true
+	}
+
+	// Only now: a snapshot claims the commit index, so the whole batch
+	// must be in the tree.
+	for _, w := range batch {
+		s.noteWrite(entrySize(w.entry))
+	}
+}
+
+// entrySize is how much written data an entry counts for towards
+// SnapshotEveryBytes: its paths and values.
+func entrySize(entry wal.Entry) int {
+	size := len(entry.Path) + len(entry.Data)
+	for _, op := range entry.Ops {
+		size += len(op.Path) + len(op.Data)
+	}
+	return size
+}
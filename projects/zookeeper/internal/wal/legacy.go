@@ -0,0 +1,164 @@
+package wal
+
+// Migrating the old single-file WAL.
+//
+// Before segments, the WAL was one file of JSON lines at `path`. Now
+// `path` is a directory. Open converts the old format in place:
+//
+//   1. rename wal.log       → wal.log.legacy
+//   2. write  wal.log.tmp/  ← one segment with every entry
+//   3. rename wal.log.tmp/  → wal.log/
+//   4. remove wal.log.legacy
+//
+// A crash at any step is safe. The next Open sees wal.log.legacy and
+// starts over from step 2 — unless wal.log/ is already a directory,
+// which means step 3 happened and only the cleanup is left.
+//
+// The old file can contain "ghost" entries: TruncateWALFrom only trimmed
+// memory, so a follower that resolved a conflict has TxID 5 twice (the
+// stale one, then the leader's). Reading it the way the old truncation
+// meant it — a TxID we've already seen replaces that entry and
+// everything after it — gives exactly the log the node had in memory.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// legacySuffix is where the old file is moved while it's being converted.
+const legacySuffix = ".legacy"
+
+// migrateLegacy converts a single-file WAL at path into a segment directory.
+// It does nothing if path is already a directory or doesn't exist.
+func migrateLegacy(path string) error {
+	legacyPath := path + legacySuffix
+	parent := filepath.Dir(path)
+
+	info, err := os.Stat(path)
+	switch {
+	case err == nil && info.IsDir():
+		// Already migrated. A leftover .legacy file means we crashed
+		// between steps 3 and 4.
+		if err := os.Remove(legacyPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	case err == nil:
+		if err := os.Rename(path, legacyPath); err != nil {
+			return err
+		}
+		if err := syncDir(parent); err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	if _, err := os.Stat(legacyPath); os.IsNotExist(err) {
+		return nil // brand new WAL, nothing to migrate
+	}
+
+	entries, err := readLegacy(legacyPath)
+	if err != nil {
+		return err
+	}
+
+	tmpDir := path + ".tmp"
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return err
+	}
+	if err := os.Mkdir(tmpDir, 0755); err != nil {
+		return err
+	}
+
+	// Everything goes into one segment, however big. segmentSize is a
+	// soft limit: the next append after Open starts a new segment.
+	first := int64(1)
+	if len(entries) > 0 {
+		first = entries[0].TxID
+	}
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		rec, err := encodeRecord(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(rec)
+	}
+	if err := writeSynced(filepath.Join(tmpDir, segmentName(first)), buf.Bytes()); err != nil {
+		return err
+	}
+	if err := syncDir(tmpDir); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpDir, path); err != nil {
+		return err
+	}
+	if err := syncDir(parent); err != nil {
+		return err
+	}
+	return os.Remove(legacyPath)
+}
+
+// readLegacy parses an old JSON-lines WAL file.
+// A bad LAST line is a torn write and is dropped; a bad line anywhere
+// else is an error.
+func readLegacy(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+	for scanner.Scan() {
+		if line := scanner.Bytes(); len(line) > 0 {
+			lines = append(lines, append([]byte(nil), line...))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read legacy WAL: %w", err)
+	}
+
+	var entries []Entry
+	for i, line := range lines {
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			if i == len(lines)-1 {
+				break
+			}
+			return nil, fmt.Errorf("failed to parse legacy WAL line %d: %w", i+1, err)
+		}
+
+		// A repeated TxID replaces that entry and everything after it.
+		for len(entries) > 0 && entries[len(entries)-1].TxID >= entry.TxID {
+			entries = entries[:len(entries)-1]
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// writeSynced creates a file with data and fsyncs it.
+func writeSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -1,10 +1,15 @@
 package wal
 
 import (
-	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/syamsularifin/zookeeper/internal/acl"
+	"github.com/syamsularifin/zookeeper/internal/metrics"
 )
 
 // THE PROBLEM:
@@ -21,27 +26,66 @@ import (
 //
 // If we crash, we can replay the log file to rebuild the tree.
 //
-// Example WAL file contents (one line per operation):
+// Example WAL contents (one entry per operation):
 //
 //   {"tx_id":1, "op":"CREATE", "path":"/app", "data":"hello"}
 //   {"tx_id":2, "op":"SET",    "path":"/app", "data":"world"}
 //   {"tx_id":3, "op":"CREATE", "path":"/app/config", "data":"port=5432"}
 //   {"tx_id":4, "op":"DELETE", "path":"/app/config"}
 //
-// To recover: read line 1, apply it. Read line 2, apply it. And so on.
-// After replaying all 4 lines, the tree is back to its last state.
+// To recover: read entry 1, apply it. Read entry 2, apply it. And so on.
+// After replaying all 4 entries, the tree is back to its last state.
+//
+// On disk, entries are checksummed records spread over segment files.
+// See segment.go.
 
 // OpType is the kind of operation. There are only 3 things you can do
 // to a tree: create a node, update a node, or delete a node.
+//
+// Plus two for client sessions. Sessions are replicated state like the
+// tree: every node must know which sessions exist, so whichever node is
+// leader next can expire them.
 type OpType string
 
 const (
 	OpCreate OpType = "CREATE"
 	OpSet    OpType = "SET"
 	OpDelete OpType = "DELETE"
+
+	// OpCreateSession opens a session. Its ID is the entry's TxID.
+	OpCreateSession OpType = "CREATE_SESSION"
+
+	// OpCloseSession ends a session and deletes its ephemeral znodes —
+	// whether the client closed it or the leader expired it.
+	OpCloseSession OpType = "CLOSE_SESSION"
+
+	// OpMulti is a transaction: the entry's Ops, applied all together or
+	// not at all. One entry, so it commits (or doesn't) as one.
+	OpMulti OpType = "MULTI"
+
+	// OpCheck only appears inside a MULTI: it changes nothing, and fails
+	// the transaction unless Path exists at Version.
+	OpCheck OpType = "CHECK"
+
+	// OpNoop changes nothing. A new Raft leader appends one as soon as
+	// it's elected: committing it commits every entry before it.
+	OpNoop OpType = "NOOP"
+
+	// OpConfig changes the cluster's membership. Data is the new member
+	// list, encoded by package cluster — the Store keeps it as opaque
+	// bytes, like a znode's data.
+	OpConfig OpType = "CONFIG"
+
+	// OpSetACL replaces Path's ACL with the entry's ACL. Version is the
+	// ACL version (aversion) it expects, not the data version.
+	OpSetACL OpType = "SET_ACL"
 )
 
-// Entry is one line in the WAL file. It records a single operation.
+// ErrCompacted means the requested entries were discarded after a
+// snapshot. Whoever asked for them needs the snapshot instead.
+var ErrCompacted = errors.New("wal: entries compacted")
+
+// Entry is one record in the WAL. It records a single operation.
 //
 // An entry must contain EVERYTHING needed to replay the operation.
 // If someone hands you an Entry, you should be able to call
@@ -73,86 +117,241 @@ type Entry struct {
 	// omitempty means: if Data is nil, don't write "data":null to the JSON.
 	// Keeps the log file cleaner.
 	Data []byte `json:"data,omitempty"`
+
+	// Version is the version a SET or DELETE expects the node to be at,
+	// or -1 (znode.AnyVersion) for "any". Part of the entry because the
+	// check has to give the same answer on every replica and on replay.
+	Version int32 `json:"version"`
+
+	// Time is when the leader accepted the write, in Unix milliseconds.
+	// It becomes the node's ctime/mtime. Taken once, here, so replicas
+	// don't each stamp their own clock.
+	Time int64 `json:"time,omitempty"`
+
+	// Session is the session a CLOSE_SESSION ends, or for a CREATE, the
+	// session that owns the new node (0 = a normal, persistent node).
+	Session int64 `json:"session,omitempty"`
+
+	// Timeout is a CREATE_SESSION's session timeout in milliseconds.
+	Timeout int64 `json:"timeout_ms,omitempty"`
+
+	// Sequential makes a CREATE append a sequence number to Path's last
+	// name. The number comes from the parent when the entry is applied,
+	// so Path here is the prefix, not the node created.
+	Sequential bool `json:"sequential,omitempty"`
+
+	// ACL is a CREATE's ACL for the new node (none = open to everyone),
+	// or the ACL a SET_ACL sets. Each entry is logged in its text form,
+	// "world:anyone:r".
+	ACL []acl.ACL `json:"acl,omitempty"`
+
+	// Ops are a MULTI's operations, in order.
+	Ops []Op `json:"ops,omitempty"`
+
+	// Results is what each of a MULTI's Ops did. Filled in when the entry
+	// is applied — never logged, replay works them out again.
+	Results []OpResult `json:"-"`
+}
+
+// Op is one operation of a MULTI: a CREATE, SET, DELETE or CHECK, with
+// the same fields as an entry of that kind.
+type Op struct {
+	Op         OpType    `json:"op"`
+	Path       string    `json:"path"`
+	Data       []byte    `json:"data,omitempty"`
+	Version    int32     `json:"version"`
+	Session    int64     `json:"session,omitempty"`
+	Sequential bool      `json:"sequential,omitempty"`
+	ACL        []acl.ACL `json:"acl,omitempty"`
 }
 
-// WAL manages the log file. It can do two things:
-//   1. Append — write a new entry to the end of the file
-//   2. ReadAll — read all entries from the file (for recovery)
+// OpResult is what one op of an applied MULTI did.
+type OpResult struct {
+	// Path is the node the op touched — for a sequential CREATE, the
+	// path actually created.
+	Path string
+
+	// Version is the node's data version after the op (for a DELETE,
+	// the version it was deleted at).
+	Version int32
+}
+
+// anyVersion mirrors znode.AnyVersion (wal can't import znode).
+const anyVersion = -1
+
+// UnmarshalJSON decodes an entry. Entries written before Version existed
+// have no "version" field; they were unconditional, so they decode as
+// "any version" instead of the zero value (which means "version 0").
+func (e *Entry) UnmarshalJSON(data []byte) error {
+	type plain Entry // same fields, without this method
+	p := plain{Version: anyVersion}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*e = Entry(p)
+	return nil
+}
+
+// WAL manages the log. It can do three things:
+//   1. Append — write a new entry to the end of the log
+//   2. ReadAll — read all entries from the log (for recovery)
+//   3. TruncateFrom — throw away the end of the log (Raft conflicts)
+//
+// Plus CompactTo, which throws away the start of the log after a snapshot.
+//
+// A WAL is not safe for concurrent use: two Appends would pick the same
+// nextTxID. The Store makes every call with its write lock held.
 type WAL struct {
-	// file is the underlying file handle. We keep it open for the lifetime
-	// of the WAL so we can keep appending without reopening.
+	// dir is the WAL directory holding the segment files.
+	dir string
+
+	// segments are the segment files, oldest first.
+	// The last one is the active segment — the only one we append to.
+	segments []*segment
+
+	// file is the active segment's file handle. We keep it open for the
+	// lifetime of the WAL so we can keep appending without reopening.
 	file *os.File
 
+	// size is how many bytes the active segment holds.
+	size int64
+
+	// segmentSize is when we roll over to a new segment.
+	segmentSize int64
+
 	// nextTxID is the counter for the next transaction ID to assign.
 	// Starts at 1, goes up by 1 for each Append call.
 	nextTxID int64
 }
 
-// Open opens (or creates) a WAL file at the given path.
+// Open opens (or creates) the WAL directory at the given path.
 //
-// os.O_CREATE — create the file if it doesn't exist (first boot)
-// os.O_RDWR   — we need to both read (recovery) and write (append)
-// os.O_APPEND — every write goes to the END of the file, never the middle
+// The flow:
+//   1. Convert an old single-file WAL at path, if there is one (legacy.go)
+//   2. Read every segment, checking each record's checksum
+//   3. Cut a torn tail off the last segment (a crash mid-append)
+//   4. Open the last segment for appending
 //
-// Why O_APPEND? Two reasons:
+// Why O_APPEND for the active segment? Two reasons:
 //   1. We never want to overwrite old entries — they're our history
 //   2. The OS guarantees small appends are atomic — if we crash mid-write,
 //      old entries are safe, only the last partial entry is damaged
+//      (and step 3 removes it on the next Open)
 func Open(path string) (*WAL, error) {
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err := migrateLegacy(path); err != nil {
+		return nil, fmt.Errorf("failed to migrate legacy WAL: %w", err)
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create WAL dir: %w", err)
+	}
+
+	segs, err := listSegments(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open WAL file: %w", err)
+		return nil, fmt.Errorf("failed to list WAL segments: %w", err)
 	}
 
 	w := &WAL{
-		file:     file,
-		nextTxID: 1,
+		dir:         path,
+		segmentSize: DefaultSegmentSize,
+		nextTxID:    1,
 	}
 
-	// Scan the existing file to find the last TxID.
+	// Scan every segment to find the last TxID and check for damage.
 	// This way, Open is always safe — even if you call Append
 	// without calling ReadAll first, TxIDs won't collide.
-	lastTxID, err := w.findLastTxID()
-	if err != nil {
-		file.Close()
-		return nil, fmt.Errorf("failed to scan existing WAL: %w", err)
+	for i, seg := range segs {
+		recs, good, torn, err := readSegment(seg.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read WAL segment: %w", err)
+		}
+		last := i == len(segs)-1
+		if torn && !last {
+			return nil, fmt.Errorf("%w: %s at offset %d", ErrCorrupt, seg.path, good)
+		}
+		if torn {
+			if err := truncateFile(seg.path, good); err != nil {
+				return nil, fmt.Errorf("failed to drop torn WAL tail: %w", err)
+			}
+		}
+		if len(recs) > 0 {
+			seg.lastTxID = recs[len(recs)-1].entry.TxID
+		}
+		if last {
+			w.size = good
+		}
 	}
-	w.nextTxID = lastTxID + 1
+	w.segments = segs
+
+	if err := w.openActive(1); err != nil {
+		return nil, err
+	}
+	w.nextTxID = w.nextAfterLast()
 
 	return w, nil
 }
 
-// findLastTxID scans the file to find the highest TxID.
-// Returns 0 if the file is empty (so nextTxID becomes 1).
-func (w *WAL) findLastTxID() (int64, error) {
-	// Seek to the start to read the whole file
-	if _, err := w.file.Seek(0, 0); err != nil {
-		return 0, err
+// openActive opens the last segment for appending, or creates a first
+// segment starting at firstTxID if there are none.
+func (w *WAL) openActive(firstTxID int64) error {
+	if len(w.segments) == 0 {
+		return w.newSegment(firstTxID)
 	}
 
-	var lastTxID int64
-	scanner := bufio.NewScanner(w.file)
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		if len(line) == 0 {
-			continue
-		}
-		var entry Entry
-		if err := json.Unmarshal(line, &entry); err != nil {
-			return 0, err
-		}
-		lastTxID = entry.TxID
+	active := w.segments[len(w.segments)-1]
+	file, err := os.OpenFile(active.path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open WAL segment: %w", err)
 	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat WAL segment: %w", err)
+	}
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+// newSegment creates an empty segment and makes it the active one.
+func (w *WAL) newSegment(firstTxID int64) error {
+	path := filepath.Join(w.dir, segmentName(firstTxID))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create WAL segment: %w", err)
+	}
+	if err := syncDir(w.dir); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync WAL dir: %w", err)
+	}
+
+	w.segments = append(w.segments, &segment{firstTxID: firstTxID, path: path})
+	w.file = file
+	w.size = 0
+	return nil
+}
 
-	return lastTxID, scanner.Err()
+// nextAfterLast returns the TxID that follows the newest entry on disk.
+//
+// If no segment holds an entry, the oldest segment's name tells us where
+// the log starts — after compaction that's not necessarily 1.
+func (w *WAL) nextAfterLast() int64 {
+	for i := len(w.segments) - 1; i >= 0; i-- {
+		if w.segments[i].lastTxID > 0 {
+			return w.segments[i].lastTxID + 1
+		}
+	}
+	if len(w.segments) > 0 {
+		return w.segments[0].firstTxID
+	}
+	return 1
 }
 
-// Append writes one entry to the end of the log file.
+// Append writes one entry to the end of the log.
 //
 // The flow:
 //   1. Assign a TxID to this entry
-//   2. Serialize the entry to JSON
-//   3. Write the JSON + a newline to the file
+//   2. Frame the entry: length + checksum + JSON
+//   3. Write the record to the active segment
 //   4. Call file.Sync() to force it to disk
 //   5. Return the assigned TxID
 //
@@ -164,77 +363,136 @@ func (w *WAL) findLastTxID() (int64, error) {
 func (w *WAL) Append(entry Entry) (int64, error) {
 	// Assign the next TxID
 	entry.TxID = w.nextTxID
-	w.nextTxID++
 
-	// Turn the Entry struct into a JSON byte slice.
-	// Example: {"tx_id":1,"op":"CREATE","path":"/app","data":"aGVsbG8="}
-	data, err := json.Marshal(entry)
+	if err := w.write(entry); err != nil {
+		return 0, err
+	}
+	if err := w.sync(); err != nil {
+		return 0, err
+	}
+	return entry.TxID, nil
+}
+
+// AppendEntry writes an entry to the log WITHOUT assigning a TxID.
+// The entry must already have a valid TxID set by the caller.
+//
+// Used in cluster mode: the leader assigns TxIDs, and followers
+// write entries with the leader's TxIDs. We can't auto-assign
+// because all nodes must agree on which TxID maps to which operation.
+//
+// The TxID must be higher than every TxID already in the log. To
+// replace entries, TruncateFrom first — the log never holds two
+// entries with the same TxID.
+func (w *WAL) AppendEntry(entry Entry) error {
+	return w.AppendEntries([]Entry{entry})
+}
+
+// AppendEntries is AppendEntry for a batch, in TxID order: every record
+// is written, then ONE Sync makes them all durable.
+//
+// The Sync costs about the same for one record as for a hundred, so a
+// Raft batch pays for it once instead of once per entry. If it fails
+// part way, the entries before the failure may be on disk; none of
+// them are promised to be.
+func (w *WAL) AppendEntries(entries []Entry) error {
+	for _, entry := range entries {
+		if entry.TxID < w.nextTxID {
+			return fmt.Errorf("entry %d out of order: WAL already has up to %d", entry.TxID, w.LastTxID())
+		}
+		if err := w.write(entry); err != nil {
+			return err
+		}
+	}
+	return w.sync()
+}
+
+// write appends one record to the active segment, rolling over to a new
+// segment first if the active one is full. The record isn't durable
+// until the next sync.
+func (w *WAL) write(entry Entry) error {
+	rec, err := encodeRecord(entry)
 	if err != nil {
-		return 0, fmt.Errorf("failed to marshal entry: %w", err)
+		return err
 	}
 
-	// Write JSON + newline. The newline is important:
-	// it separates entries so ReadAll can read them line by line.
-	//
-	//   {"tx_id":1,...}\n
-	//   {"tx_id":2,...}\n
-	//   {"tx_id":3,...}\n
-	data = append(data, '\n')
+	if w.size > 0 && w.size+int64(len(rec)) > w.segmentSize {
+		// The full segment may hold records of this batch: sync them
+		// before we let go of the file.
+		if err := w.sync(); err != nil {
+			return err
+		}
+		if err := w.file.Close(); err != nil {
+			return fmt.Errorf("failed to close full segment: %w", err)
+		}
+		if err := w.newSegment(entry.TxID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := w.file.Write(rec); err != nil {
+		// Cut off whatever part of the record made it, so the next
+		// append doesn't land behind a broken record.
+		w.file.Truncate(w.size)
+		return fmt.Errorf("failed to write entry: %w", err)
+	}
 
-	if _, err := w.file.Write(data); err != nil {
-		return 0, fmt.Errorf("failed to write entry: %w", err)
+	w.size += int64(len(rec))
+	w.segments[len(w.segments)-1].lastTxID = entry.TxID
+
+	// Keep nextTxID in sync so LastTxID() returns the right value
+	// and so standalone Append() still works if mixed with AppendEntry().
+	if entry.TxID >= w.nextTxID {
+		w.nextTxID = entry.TxID + 1
 	}
+	return nil
+}
+
+// fsyncSeconds is how long each sync takes. Every write waits for one,
+// so a slow disk shows up here before it shows up anywhere else.
+var fsyncSeconds = metrics.Default.NewHistogram("zk_wal_fsync_seconds",
+	"Time to fsync the active WAL segment.", metrics.LatencyBuckets)
 
+// sync forces the active segment to disk.
+func (w *WAL) sync() error {
 	// Sync = "flush to physical disk NOW, don't buffer"
 	// This is slow (~1-5ms) but guarantees durability.
+	defer fsyncSeconds.ObserveSince(time.Now())
 	if err := w.file.Sync(); err != nil {
-		return 0, fmt.Errorf("failed to sync: %w", err)
+		return fmt.Errorf("failed to sync: %w", err)
 	}
-
-	return entry.TxID, nil
+	return nil
 }
 
-// ReadAll reads every entry from the WAL file and returns them in order.
+// ReadAll reads every entry from the WAL and returns them in order.
 //
 // This is called once at startup to replay the log and rebuild the tree.
 //
 // How it works:
-//   1. Seek to the beginning of the file
-//   2. Read line by line (each line = one JSON entry)
-//   3. Parse each line into an Entry struct
+//   1. Go through the segments, oldest first
+//   2. Read each record and check its checksum
+//   3. Parse each payload into an Entry struct
 //   4. Collect them all into a slice
 //
 // After ReadAll, we also update nextTxID so that new Append calls
 // continue from where we left off (not restart at 1).
 func (w *WAL) ReadAll() ([]Entry, error) {
-	// Seek to the beginning — we might have been appending at the end.
-	if _, err := w.file.Seek(0, 0); err != nil {
-		return nil, fmt.Errorf("failed to seek: %w", err)
-	}
-
 	var entries []Entry
-	scanner := bufio.NewScanner(w.file)
-
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		if len(line) == 0 {
-			continue // skip empty lines
+	for _, seg := range w.segments {
+		recs, good, torn, err := readSegment(seg.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read WAL: %w", err)
 		}
-
-		var entry Entry
-		if err := json.Unmarshal(line, &entry); err != nil {
-			return nil, fmt.Errorf("failed to parse entry: %w", err)
+		// Open already cut any torn tail, so damage here is new.
+		if torn {
+			return nil, fmt.Errorf("%w: %s at offset %d", ErrCorrupt, seg.path, good)
+		}
+		for _, r := range recs {
+			entries = append(entries, r.entry)
 		}
-
-		entries = append(entries, entry)
-	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("failed to read WAL: %w", err)
 	}
 
 	// Update the counter so new appends continue from where we left off.
-	// If the file had entries [1, 2, 3], next should be 4.
+	// If the log had entries [1, 2, 3], next should be 4.
 	if len(entries) > 0 {
 		w.nextTxID = entries[len(entries)-1].TxID + 1
 	}
@@ -244,44 +502,141 @@ func (w *WAL) ReadAll() ([]Entry, error) {
 
 // LastTxID returns the most recently assigned TxID.
 // Returns 0 if no entries have been written.
-//
-// Used by snapshot: "this snapshot covers everything up to TxID X"
 func (w *WAL) LastTxID() int64 {
 	return w.nextTxID - 1
 }
 
-// AppendEntry writes an entry to the log WITHOUT assigning a TxID.
-// The entry must already have a valid TxID set by the caller.
+// Size returns how many bytes the WAL takes on disk, and in how many
+// segments.
+func (w *WAL) Size() (bytes int64, segments int) {
+	for _, seg := range w.segments[:max(len(w.segments)-1, 0)] {
+		if info, err := os.Stat(seg.path); err == nil {
+			bytes += info.Size()
+		}
+	}
+	return bytes + w.size, len(w.segments)
+}
+
+// TruncateFrom removes every entry with TxID >= fromTxID from disk.
 //
-// Used in cluster mode: the leader assigns TxIDs, and followers
-// write entries with the leader's TxIDs. We can't auto-assign
-// because all nodes must agree on which TxID maps to which operation.
-func (w *WAL) AppendEntry(entry Entry) error {
-	data, err := json.Marshal(entry)
-	if err != nil {
-		return fmt.Errorf("failed to marshal entry: %w", err)
+// Used when a follower's log conflicts with the leader's:
+//
+//	Before: [1] [2] [3] [4] [5]     (4 and 5 came from a deposed leader)
+//	TruncateFrom(4)
+//	After:  [1] [2] [3]             ← the leader's 4 and 5 get appended next
+//
+// Whole segments past the cut are deleted; the segment containing the
+// cut is shortened to the start of that record. Everything is synced
+// before returning — a truncated entry must never come back on restart.
+func (w *WAL) TruncateFrom(fromTxID int64) error {
+	if fromTxID < 1 {
+		fromTxID = 1
+	}
+	if fromTxID > w.LastTxID() {
+		return nil // nothing at or after fromTxID
 	}
 
-	data = append(data, '\n')
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close active segment: %w", err)
+	}
 
-	if _, err := w.file.Write(data); err != nil {
-		return fmt.Errorf("failed to write entry: %w", err)
+	for len(w.segments) > 0 {
+		seg := w.segments[len(w.segments)-1]
+
+		// Every entry in this segment is >= fromTxID → delete the file.
+		if seg.firstTxID >= fromTxID {
+			if err := os.Remove(seg.path); err != nil {
+				return fmt.Errorf("failed to remove WAL segment: %w", err)
+			}
+			w.segments = w.segments[:len(w.segments)-1]
+			continue
+		}
+
+		// The cut is inside this segment (or right after it).
+		recs, good, _, err := readSegment(seg.path)
+		if err != nil {
+			return fmt.Errorf("failed to read WAL segment: %w", err)
+		}
+		cut := good
+		seg.lastTxID = 0
+		for _, r := range recs {
+			if r.entry.TxID >= fromTxID {
+				cut = r.offset
+				break
+			}
+			seg.lastTxID = r.entry.TxID
+		}
+		if err := truncateFile(seg.path, cut); err != nil {
+			return fmt.Errorf("failed to truncate WAL segment: %w", err)
+		}
+		break
 	}
 
-	if err := w.file.Sync(); err != nil {
-		return fmt.Errorf("failed to sync: %w", err)
+	if err := syncDir(w.dir); err != nil {
+		return fmt.Errorf("failed to sync WAL dir: %w", err)
+	}
+	if err := w.openActive(fromTxID); err != nil {
+		return err
 	}
+	w.nextTxID = w.nextAfterLast()
+	return nil
+}
 
-	// Keep nextTxID in sync so LastTxID() returns the right value
-	// and so standalone Append() still works if mixed with AppendEntry().
-	if entry.TxID >= w.nextTxID {
-		w.nextTxID = entry.TxID + 1
+// CompactTo deletes segments that only hold entries with TxID <= txID.
+//
+// Called after a snapshot: those entries are in the snapshot now, so
+// replay doesn't need them anymore.
+//
+//	Segments: [1..4096] [4097..8192] [8193..]   (active)
+//	CompactTo(5000)
+//	Segments:           [4097..8192] [8193..]
+//
+// 4097..5000 stay: compaction works on whole files, never rewrites one.
+// The active segment is never deleted.
+func (w *WAL) CompactTo(txID int64) error {
+	removed := false
+	for len(w.segments) > 1 && w.segments[1].firstTxID <= txID+1 {
+		if err := os.Remove(w.segments[0].path); err != nil {
+			return fmt.Errorf("failed to remove WAL segment: %w", err)
+		}
+		w.segments = w.segments[1:]
+		removed = true
 	}
 
+	if !removed {
+		return nil
+	}
+	if err := syncDir(w.dir); err != nil {
+		return fmt.Errorf("failed to sync WAL dir: %w", err)
+	}
+	return nil
+}
+
+// Reset throws away the whole log and makes nextTxID the next TxID.
+//
+// Used when a follower installs a snapshot from the leader that's
+// newer than anything in its log: the old entries are useless, and the
+// next entry it receives will be the one right after the snapshot.
+func (w *WAL) Reset(nextTxID int64) error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close active segment: %w", err)
+	}
+	for _, seg := range w.segments {
+		if err := os.Remove(seg.path); err != nil {
+			return fmt.Errorf("failed to remove WAL segment: %w", err)
+		}
+	}
+	w.segments = nil
+
+	// newSegment syncs the directory, which also makes the removals durable.
+	if err := w.newSegment(nextTxID); err != nil {
+		return err
+	}
+	w.nextTxID = nextTxID
 	return nil
 }
 
-// Close flushes and closes the file.
+// Close flushes and closes the active segment.
 func (w *WAL) Close() error {
 	return w.file.Close()
 }
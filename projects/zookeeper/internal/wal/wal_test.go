@@ -1,6 +1,10 @@
 package wal
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
 	"testing"
 )
@@ -110,3 +114,370 @@ func TestOpenExistingWALSafeWithoutReadAll(t *testing.T) {
 
 	w2.Close()
 }
+
+// openSmall opens a WAL that rolls over to a new segment every few entries.
+func openSmall(t *testing.T, path string) *WAL {
+	t.Helper()
+	w, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	w.segmentSize = 200
+	return w
+}
+
+// readAll reopens the WAL at path and returns everything in it.
+func readAll(t *testing.T, path string) []Entry {
+	t.Helper()
+	w, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer w.Close()
+	entries, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	return entries
+}
+
+func expectTxIDs(t *testing.T, entries []Entry, want ...int64) {
+	t.Helper()
+	if len(entries) != len(want) {
+		t.Fatalf("expected TxIDs %v, got %d entries", want, len(entries))
+	}
+	for i, e := range entries {
+		if e.TxID != want[i] {
+			t.Fatalf("entry %d: expected TxID=%d, got %d", i, want[i], e.TxID)
+		}
+	}
+}
+
+func TestRollsOverIntoSegments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+
+	w := openSmall(t, path)
+	for i := 1; i <= 10; i++ {
+		w.Append(Entry{Op: OpCreate, Path: fmt.Sprintf("/n%d", i)})
+	}
+	w.Close()
+
+	segs, _ := listSegments(path)
+	if len(segs) < 3 {
+		t.Fatalf("expected several segments, got %d", len(segs))
+	}
+	if segs[0].firstTxID != 1 {
+		t.Fatalf("first segment should start at TxID 1, got %d", segs[0].firstTxID)
+	}
+
+	expectTxIDs(t, readAll(t, path), 1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+}
+
+func TestSizeCountsEverySegment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+
+	w := openSmall(t, path)
+	defer w.Close()
+	before := fsyncSeconds.Count()
+	for i := 1; i <= 10; i++ {
+		w.Append(Entry{Op: OpCreate, Path: fmt.Sprintf("/n%d", i)})
+	}
+
+	var want int64
+	segs, _ := listSegments(path)
+	for _, seg := range segs {
+		info, err := os.Stat(seg.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want += info.Size()
+	}
+	bytes, n := w.Size()
+	if bytes != want || n != len(segs) {
+		t.Fatalf("Size() = %d bytes in %d segments, want %d in %d", bytes, n, want, len(segs))
+	}
+	if got := fsyncSeconds.Count() - before; got < 10 {
+		t.Fatalf("expected an fsync per Append, %d were timed", got)
+	}
+}
+
+func TestTruncateFromRemovesEntriesOnDisk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+
+	w := openSmall(t, path)
+	for i := int64(1); i <= 10; i++ {
+		w.AppendEntry(Entry{TxID: i, Term: 1, Op: OpCreate, Path: fmt.Sprintf("/n%d", i)})
+	}
+
+	// Cut in the middle of an older segment: later segments must go too.
+	if err := w.TruncateFrom(4); err != nil {
+		t.Fatalf("TruncateFrom failed: %v", err)
+	}
+	if w.LastTxID() != 3 {
+		t.Fatalf("expected LastTxID 3 after truncate, got %d", w.LastTxID())
+	}
+
+	// The leader's version of TxID 4.
+	if err := w.AppendEntry(Entry{TxID: 4, Term: 2, Op: OpCreate, Path: "/leader"}); err != nil {
+		t.Fatalf("AppendEntry after truncate failed: %v", err)
+	}
+	w.Close()
+
+	// After a restart there are no ghost entries: one TxID 4, from term 2.
+	entries := readAll(t, path)
+	expectTxIDs(t, entries, 1, 2, 3, 4)
+	if entries[3].Term != 2 || entries[3].Path != "/leader" {
+		t.Fatalf("expected the leader's entry at TxID 4, got %+v", entries[3])
+	}
+}
+
+func TestTruncateFromEverything(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+
+	w := openSmall(t, path)
+	w.Append(Entry{Op: OpCreate, Path: "/a"})
+	w.Append(Entry{Op: OpCreate, Path: "/b"})
+	if err := w.TruncateFrom(1); err != nil {
+		t.Fatalf("TruncateFrom failed: %v", err)
+	}
+
+	txID, err := w.Append(Entry{Op: OpCreate, Path: "/c"})
+	if err != nil || txID != 1 {
+		t.Fatalf("expected TxID 1 after truncating everything, got %d (%v)", txID, err)
+	}
+	w.Close()
+
+	expectTxIDs(t, readAll(t, path), 1)
+}
+
+func TestAppendEntryRejectsOutOfOrder(t *testing.T) {
+	w, _ := Open(filepath.Join(t.TempDir(), "test.wal"))
+	defer w.Close()
+
+	w.AppendEntry(Entry{TxID: 1, Op: OpCreate, Path: "/a"})
+	w.AppendEntry(Entry{TxID: 2, Op: OpCreate, Path: "/b"})
+
+	// Replacing TxID 2 without truncating first would create a duplicate.
+	if err := w.AppendEntry(Entry{TxID: 2, Op: OpCreate, Path: "/c"}); err == nil {
+		t.Fatal("expected an error for a duplicate TxID")
+	}
+}
+
+func TestAppendEntriesAcrossSegments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+
+	w := openSmall(t, path)
+	var batch []Entry
+	for i := int64(1); i <= 10; i++ {
+		batch = append(batch, Entry{TxID: i, Term: 1, Op: OpCreate, Path: fmt.Sprintf("/n%d", i)})
+	}
+	if err := w.AppendEntries(batch); err != nil {
+		t.Fatalf("AppendEntries failed: %v", err)
+	}
+	if w.LastTxID() != 10 {
+		t.Fatalf("expected LastTxID 10, got %d", w.LastTxID())
+	}
+
+	// A batch that isn't in order is refused at the first bad entry.
+	if err := w.AppendEntries([]Entry{{TxID: 11, Op: OpCreate}, {TxID: 11, Op: OpCreate}}); err == nil {
+		t.Fatal("expected an error for a duplicate TxID in the batch")
+	}
+	w.Close()
+
+	if segs, _ := listSegments(path); len(segs) < 3 {
+		t.Fatalf("a big batch should still roll over, got %d segments", len(segs))
+	}
+	expectTxIDs(t, readAll(t, path), 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11)
+}
+
+// lastSegmentPath returns the newest segment file in the WAL dir.
+func lastSegmentPath(t *testing.T, path string) string {
+	t.Helper()
+	segs, err := listSegments(path)
+	if err != nil || len(segs) == 0 {
+		t.Fatalf("no segments in %s: %v", path, err)
+	}
+	return segs[len(segs)-1].path
+}
+
+func TestOpenDropsTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+
+	w, _ := Open(path)
+	w.Append(Entry{Op: OpCreate, Path: "/a"})
+	w.Append(Entry{Op: OpCreate, Path: "/b"})
+	w.Close()
+
+	// Crash in the middle of the third append: only part of the record hit the disk.
+	rec, _ := encodeRecord(Entry{TxID: 3, Op: OpCreate, Path: "/c"})
+	f, _ := os.OpenFile(lastSegmentPath(t, path), os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write(rec[:len(rec)/2])
+	f.Close()
+
+	w2, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open should recover from a torn tail: %v", err)
+	}
+	txID, _ := w2.Append(Entry{Op: OpCreate, Path: "/d"})
+	if txID != 3 {
+		t.Fatalf("expected TxID 3 after dropping the torn entry, got %d", txID)
+	}
+	w2.Close()
+
+	// The new entry landed after the last good record, not after the garbage.
+	entries := readAll(t, path)
+	expectTxIDs(t, entries, 1, 2, 3)
+	if entries[2].Path != "/d" {
+		t.Fatalf("expected /d at TxID 3, got %s", entries[2].Path)
+	}
+}
+
+func TestOpenDropsCorruptTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+
+	w, _ := Open(path)
+	w.Append(Entry{Op: OpCreate, Path: "/a"})
+	w.Append(Entry{Op: OpCreate, Path: "/b"})
+	w.Close()
+
+	// Flip the last byte of the last record's payload.
+	seg := lastSegmentPath(t, path)
+	data, _ := os.ReadFile(seg)
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(seg, data, 0644)
+
+	expectTxIDs(t, readAll(t, path), 1)
+}
+
+func TestOpenRejectsCorruptionInOlderSegment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+
+	w := openSmall(t, path)
+	for i := 1; i <= 10; i++ {
+		w.Append(Entry{Op: OpCreate, Path: fmt.Sprintf("/n%d", i)})
+	}
+	w.Close()
+
+	// Damage the FIRST segment. Dropping it and everything after would
+	// silently lose entries — Open must refuse instead.
+	segs, _ := listSegments(path)
+	data, _ := os.ReadFile(segs[0].path)
+	data[recordHeaderSize] ^= 0xff
+	os.WriteFile(segs[0].path, data, 0644)
+
+	_, err := Open(path)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt, got %v", err)
+	}
+}
+
+func TestOpenMigratesLegacyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+
+	// The old format: JSON lines. TxID 3 appears twice (a follower
+	// resolved a conflict in memory only), and the last line is torn.
+	legacy := `{"tx_id":1,"term":1,"op":"CREATE","path":"/a"}
+{"tx_id":2,"term":1,"op":"CREATE","path":"/b"}
+{"tx_id":3,"term":1,"op":"CREATE","path":"/stale"}
+{"tx_id":3,"term":2,"op":"CREATE","path":"/leader"}
+{"tx_id":4,"term":2,"op":"CRE`
+	os.WriteFile(path, []byte(legacy), 0644)
+
+	entries := readAll(t, path)
+	expectTxIDs(t, entries, 1, 2, 3)
+	if entries[2].Path != "/leader" {
+		t.Fatalf("expected the later TxID 3 to win, got %s", entries[2].Path)
+	}
+
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		t.Fatalf("WAL path should now be a directory: %v", err)
+	}
+	if _, err := os.Stat(path + legacySuffix); !os.IsNotExist(err) {
+		t.Fatalf("legacy file should be removed after migration, got %v", err)
+	}
+}
+
+func TestCompactToRemovesCoveredSegments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+
+	w := openSmall(t, path)
+	for i := 1; i <= 10; i++ {
+		w.Append(Entry{Op: OpCreate, Path: fmt.Sprintf("/n%d", i)})
+	}
+	before, _ := listSegments(path)
+
+	if err := w.CompactTo(6); err != nil {
+		t.Fatalf("CompactTo failed: %v", err)
+	}
+	after, _ := listSegments(path)
+	if len(after) >= len(before) {
+		t.Fatalf("expected fewer segments after compaction, had %d, now %d", len(before), len(after))
+	}
+	w.Close()
+
+	// Only whole segments go: the log now starts somewhere in 2..7,
+	// and everything after 6 is still there.
+	entries := readAll(t, path)
+	first := entries[0].TxID
+	if first < 2 || first > 7 {
+		t.Fatalf("expected the log to start between 2 and 7, got %d", first)
+	}
+	if entries[len(entries)-1].TxID != 10 {
+		t.Fatalf("expected last TxID 10, got %d", entries[len(entries)-1].TxID)
+	}
+
+	// TxIDs continue after a restart.
+	w2, _ := Open(path)
+	txID, _ := w2.Append(Entry{Op: OpCreate, Path: "/next"})
+	if txID != 11 {
+		t.Fatalf("expected TxID 11, got %d", txID)
+	}
+	w2.Close()
+}
+
+func TestCompactToKeepsActiveSegment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+
+	w := openSmall(t, path)
+	for i := 1; i <= 10; i++ {
+		w.Append(Entry{Op: OpCreate, Path: fmt.Sprintf("/n%d", i)})
+	}
+
+	// Compact past everything: the active segment stays so we can append.
+	if err := w.CompactTo(10); err != nil {
+		t.Fatalf("CompactTo failed: %v", err)
+	}
+	segs, _ := listSegments(path)
+	if len(segs) != 1 {
+		t.Fatalf("expected only the active segment to remain, got %d", len(segs))
+	}
+
+	// Truncating everything that's left must not reset TxIDs to 1.
+	if err := w.TruncateFrom(segs[0].firstTxID); err != nil {
+		t.Fatalf("TruncateFrom failed: %v", err)
+	}
+	txID, _ := w.Append(Entry{Op: OpCreate, Path: "/next"})
+	if txID != segs[0].firstTxID {
+		t.Fatalf("expected TxID %d, got %d", segs[0].firstTxID, txID)
+	}
+	w.Close()
+}
+
+// TestEntryWithoutVersionIsUnconditional proves entries written before
+// Version existed replay as "any version", not as "expect version 0".
+func TestEntryWithoutVersionIsUnconditional(t *testing.T) {
+	var e Entry
+	if err := json.Unmarshal([]byte(`{"tx_id":1,"term":1,"op":"SET","path":"/app"}`), &e); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if e.Version != anyVersion {
+		t.Fatalf("expected version %d, got %d", anyVersion, e.Version)
+	}
+
+	if err := json.Unmarshal([]byte(`{"tx_id":2,"op":"SET","path":"/app","version":0}`), &e); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if e.Version != 0 {
+		t.Fatalf("an explicit version 0 must stay 0, got %d", e.Version)
+	}
+}
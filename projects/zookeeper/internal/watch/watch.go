@@ -0,0 +1,256 @@
+package watch
+
+// Watches: "tell me when this changes" instead of "has it changed yet?".
+//
+// THE PROBLEM:
+//
+// Without watches, a client that cares about /app/config has to poll:
+//
+//   loop: Get /app/config → same → sleep → Get /app/config → same → ...
+//
+// Most polls return nothing new, and a change is only seen at the next
+// poll. With a hundred clients polling every second that's a hundred
+// useless reads a second, and changes still arrive up to a second late.
+//
+// THE FIX:
+//
+// The client registers interest once. When a committed write changes
+// the path, the server pushes an event:
+//
+//   Client                       Server
+//     │── Watch /app/config ───→ registered at zxid 41
+//     │                          ... SET /app/config commits (zxid 42) ...
+//     │←── NodeDataChanged 42 ──
+//
+// WHICH EVENTS REACH WHICH WATCH:
+//
+//   write            events                         a Data watch   a Children watch
+//                                                   on the path    on the path
+//   CREATE /a/b      NodeCreated /a/b               /a/b           -
+//                    NodeChildrenChanged /a         -              /a
+//   SET /a/b         NodeDataChanged /a/b           /a/b           -
+//   DELETE /a/b      NodeDeleted /a/b               /a/b           /a/b
+//                    NodeChildrenChanged /a         -              /a
+//
+// ONE-SHOT AND PERSISTENT:
+//
+// A one-shot watch fires once and is gone — the client re-reads and
+// re-registers if it still cares. A persistent watch fires for every
+// matching event until it's cancelled.
+//
+// NOT MISSING ANYTHING:
+//
+// Every watch remembers the zxid it was registered at. Every event after
+// that zxid is delivered; none before it. So a client can register, then
+// read, and know that any change its read didn't see will still arrive.
+//
+// SLOW WATCHERS:
+//
+// Events are fired by whoever applies committed writes (Raft, on a
+// cluster) and must never wait for a client. Each watch has a buffer;
+// a watch whose buffer is full is cancelled with ErrOverflow instead of
+// holding up everyone else. Its client has to re-read and re-register.
+
+import (
+	"errors"
+	"sync"
+)
+
+// EventType says what happened to a path.
+type EventType int
+
+const (
+	NodeCreated EventType = iota + 1
+	NodeDeleted
+	NodeDataChanged
+	NodeChildrenChanged
+)
+
+func (t EventType) String() string {
+	switch t {
+	case NodeCreated:
+		return "NodeCreated"
+	case NodeDeleted:
+		return "NodeDeleted"
+	case NodeDataChanged:
+		return "NodeDataChanged"
+	case NodeChildrenChanged:
+		return "NodeChildrenChanged"
+	default:
+		return "Unknown"
+	}
+}
+
+// Event is one change to one path, made by the write at Zxid.
+type Event struct {
+	Type EventType
+	Path string
+	Zxid int64
+}
+
+// Kind is what a watch is interested in. Kinds combine: All is both.
+type Kind int
+
+const (
+	// Data: the node itself — created, deleted, data changed.
+	Data Kind = 1 << iota
+	// Children: the node's child list — changed, or the node deleted.
+	Children
+
+	All = Data | Children
+)
+
+// kindOf is the Kind of watch an event type is for.
+func kindOf(t EventType) Kind {
+	switch t {
+	case NodeCreated, NodeDataChanged:
+		return Data
+	case NodeChildrenChanged:
+		return Children
+	default: // NodeDeleted
+		return All
+	}
+}
+
+// bufferSize is how many undelivered events a watch holds before it's
+// cancelled with ErrOverflow.
+const bufferSize = 256
+
+// ErrOverflow means a watch fell too far behind and was cancelled.
+var ErrOverflow = errors.New("watch overflowed: events not read fast enough")
+
+// Watch is one registered watch. Read events from Events until it's
+// closed; then Err says why (nil: one-shot fired, or cancelled).
+type Watch struct {
+	// Events delivers the watch's events, in zxid order.
+	Events <-chan Event
+
+	// Zxid is the last write applied before the watch was registered.
+	// Every event after it is delivered.
+	Zxid int64
+
+	path       string
+	kind       Kind
+	persistent bool
+	ch         chan Event
+	m          *Manager
+
+	// err is set (under m.mu) when the watch ends.
+	err error
+}
+
+// Cancel removes the watch and closes Events. Safe to call more than
+// once, and after the watch has ended on its own.
+func (w *Watch) Cancel() {
+	w.m.mu.Lock()
+	defer w.m.mu.Unlock()
+	w.m.remove(w, nil)
+}
+
+// Err returns why the watch ended: ErrOverflow, or nil.
+func (w *Watch) Err() error {
+	w.m.mu.Lock()
+	defer w.m.mu.Unlock()
+	return w.err
+}
+
+// Manager holds every registered watch, by path. Safe for concurrent
+// use: watches are added from RPC goroutines while writes are applied.
+type Manager struct {
+	mu      sync.Mutex
+	zxid    int64
+	watches map[string]map[*Watch]struct{}
+}
+
+// NewManager creates a Manager with no watches.
+func NewManager() *Manager {
+	return &Manager{watches: make(map[string]map[*Watch]struct{})}
+}
+
+// Add registers a watch on path.
+func (m *Manager) Add(path string, kind Kind, persistent bool) *Watch {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ch := make(chan Event, bufferSize)
+	w := &Watch{
+		Events:     ch,
+		Zxid:       m.zxid,
+		path:       path,
+		kind:       kind,
+		persistent: persistent,
+		ch:         ch,
+		m:          m,
+	}
+	if m.watches[path] == nil {
+		m.watches[path] = make(map[*Watch]struct{})
+	}
+	m.watches[path][w] = struct{}{}
+	return w
+}
+
+// Fire records that the write at zxid has been applied and delivers its
+// events. Called for every applied write, including ones that failed
+// (with no events), so Zxid stays exact. Never blocks.
+func (m *Manager) Fire(zxid int64, events []Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if zxid > m.zxid {
+		m.zxid = zxid
+	}
+	for _, ev := range events {
+		for w := range m.watches[ev.Path] {
+			if w.kind&kindOf(ev.Type) == 0 {
+				continue
+			}
+			select {
+			case w.ch <- ev:
+			default:
+				m.remove(w, ErrOverflow)
+				continue
+			}
+			if !w.persistent {
+				m.remove(w, nil)
+			}
+		}
+	}
+}
+
+// Reset moves the zxid to where a newly installed snapshot ends. Events
+// for the writes in between are never fired: the snapshot replaced the
+// tree wholesale. Watches stay registered.
+func (m *Manager) Reset(zxid int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.zxid = zxid
+}
+
+// Len returns how many watches are registered.
+func (m *Manager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, ws := range m.watches {
+		n += len(ws)
+	}
+	return n
+}
+
+// remove unregisters w and closes its channel. m.mu must be held.
+func (m *Manager) remove(w *Watch, err error) {
+	ws, ok := m.watches[w.path]
+	if !ok {
+		return
+	}
+	if _, ok := ws[w]; !ok {
+		return
+	}
+	delete(ws, w)
+	if len(ws) == 0 {
+		delete(m.watches, w.path)
+	}
+	w.err = err
+	close(w.ch)
+}
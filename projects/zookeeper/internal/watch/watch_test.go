@@ -0,0 +1,97 @@
+package watch
+
+import (
+	"errors"
+	"testing"
+)
+
+// drain reads every event until the watch's channel closes.
+func drain(w *Watch) []Event {
+	var events []Event
+	for ev := range w.Events {
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestOneShotFiresOnce(t *testing.T) {
+	m := NewManager()
+	w := m.Add("/app", Data, false)
+
+	m.Fire(1, []Event{{Type: NodeDataChanged, Path: "/app", Zxid: 1}})
+	m.Fire(2, []Event{{Type: NodeDataChanged, Path: "/app", Zxid: 2}})
+
+	events := drain(w)
+	if len(events) != 1 || events[0].Zxid != 1 {
+		t.Fatalf("expected only the event at zxid 1, got %v", events)
+	}
+	if w.Err() != nil {
+		t.Fatalf("a fired one-shot watch has no error, got %v", w.Err())
+	}
+	if m.Len() != 0 {
+		t.Fatalf("expected the watch to be gone, %d left", m.Len())
+	}
+}
+
+func TestKindsFilterEvents(t *testing.T) {
+	m := NewManager()
+	data := m.Add("/app", Data, true)
+	children := m.Add("/app", Children, true)
+	other := m.Add("/other", All, true)
+
+	m.Fire(1, []Event{
+		{Type: NodeDataChanged, Path: "/app", Zxid: 1},
+		{Type: NodeChildrenChanged, Path: "/app", Zxid: 1},
+	})
+	m.Fire(2, []Event{{Type: NodeDeleted, Path: "/app", Zxid: 2}})
+	data.Cancel()
+	children.Cancel()
+	other.Cancel()
+
+	want := map[*Watch][]EventType{
+		data:     {NodeDataChanged, NodeDeleted},
+		children: {NodeChildrenChanged, NodeDeleted},
+		other:    nil,
+	}
+	for w, types := range want {
+		events := drain(w)
+		if len(events) != len(types) {
+			t.Fatalf("%s watch: expected %v, got %v", w.path, types, events)
+		}
+		for i, ev := range events {
+			if ev.Type != types[i] {
+				t.Fatalf("%s watch: expected %v, got %v", w.path, types, events)
+			}
+		}
+	}
+}
+
+func TestWatchStartsAtLastFiredZxid(t *testing.T) {
+	m := NewManager()
+	m.Fire(7, nil) // a failed write still moves the zxid
+	if w := m.Add("/app", Data, false); w.Zxid != 7 {
+		t.Fatalf("expected zxid 7, got %d", w.Zxid)
+	}
+
+	m.Reset(100)
+	if w := m.Add("/app", Data, false); w.Zxid != 100 {
+		t.Fatalf("expected zxid 100 after Reset, got %d", w.Zxid)
+	}
+}
+
+func TestSlowWatcherOverflows(t *testing.T) {
+	m := NewManager()
+	w := m.Add("/app", Data, true)
+
+	for i := int64(1); i <= bufferSize+1; i++ {
+		m.Fire(i, []Event{{Type: NodeDataChanged, Path: "/app", Zxid: i}})
+	}
+
+	if events := drain(w); len(events) != bufferSize {
+		t.Fatalf("expected the %d buffered events, got %d", bufferSize, len(events))
+	}
+	if !errors.Is(w.Err(), ErrOverflow) {
+		t.Fatalf("expected ErrOverflow, got %v", w.Err())
+	}
+	w.Cancel() // already gone: a no-op
+}
@@ -0,0 +1,190 @@
+package znode
+
+// Multi: several changes that happen together or not at all.
+//
+// THE PROBLEM:
+//
+// A client wants to write /app/config and bump /app/version, so readers
+// never see the new config with the old version number. As two writes,
+// anything can happen in between: another client's write, a crash, the
+// second write failing its version check.
+//
+// THE FIX:
+//
+// Multi applies a list of ops as one write. If any op fails, the ones
+// before it are undone and the tree is exactly as it was:
+//
+//   Multi(check /app v3, set /app/config, set /app/version)
+//
+//   op 0: check /app v3           ✓
+//   op 1: set /app/config         ✓  undo: old data + Stat
+//   op 2: set /app/version v7     ✗  bad version
+//         ↓
+//   undo op 1 → tree unchanged, MultiError{Index: 2}
+//
+// Later ops see the earlier ones: "create /a, then create /a/b" works,
+// and two sequential creates under one parent get consecutive numbers.
+//
+// Every op is undone by putting back exactly what it changed: a Set's
+// data and Stat, a Create's new child and its parent's Cversion, a
+// Delete's node (the same *ZNode, children and all) and its parent's
+// Cversion. No copy of the tree is made.
+
+import (
+	"fmt"
+
+	"github.com/syamsularifin/zookeeper/internal/acl"
+)
+
+// OpType is the kind of a Multi op.
+type OpType int
+
+const (
+	OpCreate OpType = iota + 1
+	OpSet
+	OpDelete
+
+	// OpCheck changes nothing. It fails the Multi unless Path exists at
+	// Version (any version, with AnyVersion).
+	OpCheck
+)
+
+func (t OpType) String() string {
+	switch t {
+	case OpCreate:
+		return "create"
+	case OpSet:
+		return "set"
+	case OpDelete:
+		return "delete"
+	case OpCheck:
+		return "check"
+	default:
+		return "unknown"
+	}
+}
+
+// Op is one operation of a Multi. The fields mean what they mean for the
+// single-op methods: Owner as in CreateEphemeral, Sequential as in
+// CreateSequential, ACL as in CreateNode, Version as in Set and Delete.
+type Op struct {
+	Type       OpType
+	Path       string
+	Data       []byte
+	Version    int32
+	Owner      int64
+	Sequential bool
+	ACL        []acl.ACL
+}
+
+// OpResult is what one op did: the node's path (as created, for a
+// sequential create) and its Stat right after the op.
+type OpResult struct {
+	Path string
+	Stat Stat
+}
+
+// MultiError says which op failed a Multi, and why. Unwrap gives the
+// op's own error, so errors.Is(err, ErrBadVersion) still works.
+type MultiError struct {
+	Index int
+	Type  OpType
+	Err   error
+}
+
+func (e *MultiError) Error() string {
+	return fmt.Sprintf("op %d (%s): %v", e.Index, e.Type, e.Err)
+}
+
+func (e *MultiError) Unwrap() error { return e.Err }
+
+// Multi applies ops in order, as the single write txn. Either all of
+// them succeed and their results come back, or the tree is left as it
+// was and the error is a *MultiError.
+func (dt *DataTree) Multi(ops []Op, txn Txn) ([]OpResult, error) {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	results := make([]OpResult, 0, len(ops))
+	undos := make([]func(), 0, len(ops))
+
+	for i, op := range ops {
+		result, undo, err := dt.applyOp(op, txn)
+		if err != nil {
+			for j := len(undos) - 1; j >= 0; j-- {
+				undos[j]()
+			}
+			return nil, &MultiError{Index: i, Type: op.Type, Err: err}
+		}
+		results = append(results, result)
+		undos = append(undos, undo)
+	}
+	return results, nil
+}
+
+// applyOp applies one op and returns how to take it back. Must be
+// called with dt.mu held for writing.
+func (dt *DataTree) applyOp(op Op, txn Txn) (OpResult, func(), error) {
+	switch op.Type {
+	case OpCreate:
+		path, err := dt.create(op.Path, op.Data, op.Owner, op.Sequential, op.ACL, txn)
+		if err != nil {
+			return OpResult{}, nil, err
+		}
+		parentPath, name := splitPath(path)
+		parent, _ := dt.findNode(parentPath)
+		node := parent.Children[name]
+		undo := func() {
+			delete(parent.Children, name)
+			parent.Stat.Cversion--
+			dt.count--
+			dt.removeEphemeral(op.Owner, path)
+		}
+		return OpResult{Path: path, Stat: node.stat()}, undo, nil
+
+	case OpSet:
+		node, err := dt.findNode(op.Path)
+		if err != nil {
+			return OpResult{}, nil, err
+		}
+		oldData, oldStat := node.Data, node.Stat
+		if err := dt.set(op.Path, op.Data, op.Version, txn); err != nil {
+			return OpResult{}, nil, err
+		}
+		undo := func() {
+			node.Data, node.Stat = oldData, oldStat
+		}
+		return OpResult{Path: op.Path, Stat: node.stat()}, undo, nil
+
+	case OpDelete:
+		node, err := dt.findNode(op.Path)
+		if err != nil {
+			return OpResult{}, nil, err
+		}
+		if err := dt.remove(op.Path, op.Version, txn); err != nil {
+			return OpResult{}, nil, err
+		}
+		parentPath, name := splitPath(op.Path) // not the root: Delete refuses it
+		parent, _ := dt.findNode(parentPath)
+		undo := func() {
+			parent.Children[name] = node
+			parent.Stat.Cversion--
+			dt.count++
+			dt.addEphemeral(node.Stat.EphemeralOwner, op.Path)
+		}
+		return OpResult{Path: op.Path, Stat: node.stat()}, undo, nil
+
+	case OpCheck:
+		node, err := dt.findNode(op.Path)
+		if err != nil {
+			return OpResult{}, nil, err
+		}
+		if err := checkVersion(op.Path, node, op.Version); err != nil {
+			return OpResult{}, nil, err
+		}
+		return OpResult{Path: op.Path, Stat: node.stat()}, func() {}, nil
+
+	default:
+		return OpResult{}, nil, fmt.Errorf("unknown op type %d", op.Type)
+	}
+}
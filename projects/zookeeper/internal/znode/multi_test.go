@@ -0,0 +1,88 @@
+package znode
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/syamsularifin/zookeeper/internal/snapshot"
+)
+
+// byPath indexes a snapshot by path: ToSnapshot visits siblings in map
+// order, so two snapshots of the same tree needn't list them alike.
+func byPath(nodes []snapshot.NodeData) map[string]snapshot.NodeData {
+	m := make(map[string]snapshot.NodeData, len(nodes))
+	for _, n := range nodes {
+		m[n.Path] = n
+	}
+	return m
+}
+
+func TestMultiAppliesAllOps(t *testing.T) {
+	tree := NewDataTree()
+	tree.Create("/app", nil, Txn{})
+	tree.Create("/app/version", []byte("5"), Txn{})
+
+	results, err := tree.Multi([]Op{
+		{Type: OpCheck, Path: "/app", Version: 0},
+		{Type: OpCreate, Path: "/app/config", Data: []byte("port=1")},
+		{Type: OpCreate, Path: "/app/config/db", Data: []byte("pg")}, // sees the op before it
+		{Type: OpSet, Path: "/app/version", Data: []byte("6"), Version: 0},
+		{Type: OpCreate, Path: "/app/job-", Sequential: true},
+		{Type: OpCreate, Path: "/app/job-", Sequential: true},
+	}, Txn{Zxid: 9})
+	if err != nil {
+		t.Fatalf("Multi failed: %v", err)
+	}
+
+	paths := make([]string, len(results))
+	for i, r := range results {
+		paths[i] = r.Path
+	}
+	want := []string{"/app", "/app/config", "/app/config/db", "/app/version", "/app/job-0000000002", "/app/job-0000000003"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("expected paths %v, got %v", want, paths)
+	}
+	if results[3].Stat.Version != 1 || results[3].Stat.Mzxid != 9 {
+		t.Fatalf("expected /app/version at version 1, zxid 9, got %+v", results[3].Stat)
+	}
+	if data, _ := tree.Get("/app/config/db"); string(data) != "pg" {
+		t.Fatalf("expected /app/config/db=pg, got %q", data)
+	}
+}
+
+// TestMultiFailureChangesNothing proves a failing op undoes every op
+// before it, down to the Stat and the ephemeral index.
+func TestMultiFailureChangesNothing(t *testing.T) {
+	tree := NewDataTree()
+	tree.Create("/app", []byte("a"), Txn{Zxid: 1})
+	tree.Create("/app/old", nil, Txn{Zxid: 2})
+	tree.CreateEphemeral("/app/lock", nil, 42, Txn{Zxid: 3})
+	before := byPath(tree.ToSnapshot())
+
+	_, err := tree.Multi([]Op{
+		{Type: OpSet, Path: "/app", Data: []byte("b"), Version: AnyVersion},
+		{Type: OpCreate, Path: "/app/new", Owner: 7},
+		{Type: OpDelete, Path: "/app/old", Version: AnyVersion},
+		{Type: OpDelete, Path: "/app/lock", Version: AnyVersion},
+		{Type: OpCreate, Path: "/app/seq-", Sequential: true},
+		{Type: OpCheck, Path: "/app", Version: 0}, // /app is at version 1 by now
+	}, Txn{Zxid: 4})
+
+	var me *MultiError
+	if !errors.As(err, &me) || me.Index != 5 || !errors.Is(err, ErrBadVersion) {
+		t.Fatalf("expected op 5 to fail with ErrBadVersion, got %v", err)
+	}
+	if after := byPath(tree.ToSnapshot()); !reflect.DeepEqual(after, before) {
+		t.Fatalf("tree changed:\nbefore %+v\nafter  %+v", before, after)
+	}
+	if got := tree.Ephemerals(42); len(got) != 1 || got[0] != "/app/lock" {
+		t.Fatalf("expected session 42 to still own /app/lock, got %v", got)
+	}
+	if got := tree.Ephemerals(7); len(got) != 0 {
+		t.Fatalf("expected session 7 to own nothing, got %v", got)
+	}
+	if got := tree.Count(); got != 4 {
+		t.Fatalf("expected 4 nodes after the undo, got %d", got)
+	}
+}
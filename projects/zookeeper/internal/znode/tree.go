@@ -1,9 +1,13 @@
 package znode
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
 
+	"github.com/syamsularifin/zookeeper/internal/acl"
 	"github.com/syamsularifin/zookeeper/internal/snapshot"
 )
 
@@ -17,12 +21,48 @@ import (
 //   path "/app/config"  →  root.Children["app"].Children["config"]
 //
 // That's DataTree's job: it walks the tree to find the right node.
+//
+// It's safe for concurrent use. grpc-go serves every RPC on its own
+// goroutine, and Raft applies writes on yet another, so a Get can run
+// while a Create is adding to the very Children map it's walking. mu
+// makes that safe the simple way:
+//
+//	reads  (Get, Stat, GetChildren, GetACL, WalkSnapshot)  RLock — any number at once
+//	writes (Create, Set, Delete, SetACL, Multi, Restore)   Lock  — one at a time, no readers
+//
+// Every method takes the lock once, for the whole operation: a read
+// never sees half a write, and a Multi is one write. Writes are already
+// one at a time upstream (the Store and Raft apply them in log order),
+// so the write lock only ever waits for readers.
+//
+// The price: a snapshot walks the tree under RLock, and writes wait
+// until it's on disk.
 type DataTree struct {
+	mu sync.RWMutex
+
 	// root is the "/" node. It always exists and can never be deleted.
 	// Every path starts from here.
 	root *ZNode
+
+	// ephemerals indexes ephemeral nodes by owning session, so ending a
+	// session doesn't have to search the whole tree:
+	//
+	//   ephemerals[42] = {"/workers/a", "/locks/x"}
+	ephemerals map[int64]map[string]struct{}
+
+	// count is the number of nodes, the root included.
+	count int
 }
 
+// ErrBadVersion means a Set or Delete expected a different version than
+// the node has: someone else changed it first.
+var ErrBadVersion = errors.New("bad version")
+
+// ErrEphemeralParent means a Create tried to put a child under an
+// ephemeral node. Ephemeral nodes can vanish at any moment, so they
+// can't have children.
+var ErrEphemeralParent = errors.New("ephemeral nodes cannot have children")
+
 // NewDataTree creates an empty tree with just the root node.
 func NewDataTree() *DataTree {
 	return &DataTree{
@@ -30,10 +70,20 @@ func NewDataTree() *DataTree {
 			// Root starts with an empty Children map, ready for children.
 			// make() is important here — a nil map would panic on assignment.
 			Children: make(map[string]*ZNode),
+			ACL:      acl.Open,
 		},
+		ephemerals: make(map[int64]map[string]struct{}),
+		count:      1,
 	}
 }
 
+// Count returns the number of znodes in the tree, the root included.
+func (dt *DataTree) Count() int {
+	dt.mu.RLock()
+	defer dt.mu.RUnlock()
+	return dt.count
+}
+
 // Create adds a new znode at the given path.
 //
 // Rules (same as a real filesystem):
@@ -41,12 +91,72 @@ func NewDataTree() *DataTree {
 //   - Parent must exist: you can't create "/a/b" if "/a" doesn't exist
 //   - Node must not already exist: no overwriting via Create
 //
+// txn is stamped into the new node's Stat (Czxid, Ctime) and counts as
+// a child change on the parent (Cversion).
+//
 // Example:
-//   tree.Create("/app", []byte("hello"))       // OK
-//   tree.Create("/app/config", []byte("..."))   // OK — /app exists
-//   tree.Create("/x/y/z", []byte("..."))        // ERROR — /x doesn't exist
-//   tree.Create("/app", []byte("again"))         // ERROR — /app already exists
-func (dt *DataTree) Create(path string, data []byte) error {
+//   tree.Create("/app", []byte("hello"), txn)       // OK
+//   tree.Create("/app/config", []byte("..."), txn)   // OK — /app exists
+//   tree.Create("/x/y/z", []byte("..."), txn)        // ERROR — /x doesn't exist
+//   tree.Create("/app", []byte("again"), txn)         // ERROR — /app already exists
+func (dt *DataTree) Create(path string, data []byte, txn Txn) error {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	_, err := dt.create(path, data, 0, false, nil, txn)
+	return err
+}
+
+// CreateEphemeral is Create for a node owned by a session. It's
+// deleted by DeleteEphemerals when the session ends.
+func (dt *DataTree) CreateEphemeral(path string, data []byte, owner int64, txn Txn) error {
+	if owner == 0 {
+		return fmt.Errorf("ephemeral node %q needs an owning session", path)
+	}
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	_, err := dt.create(path, data, owner, false, nil, txn)
+	return err
+}
+
+// CreateSequential is Create with a sequence number appended to the
+// name. It returns the path actually created. A non-zero owner makes
+// the node ephemeral too.
+//
+// The number is the parent's Cversion, printed as 10 digits:
+//
+//	/locks Cversion 6
+//	CreateSequential("/locks/lock-")  → "/locks/lock-0000000006"
+//	CreateSequential("/locks/lock-")  → "/locks/lock-0000000007"
+//	delete /locks/lock-0000000006     → Cversion 8
+//	CreateSequential("/locks/")       → "/locks/0000000008"
+//
+// Cversion only ever goes up, so numbers under one parent never repeat
+// and later creates get bigger ones. That's what lock and queue recipes
+// rely on: "lowest number goes first". And Cversion is tree state — in
+// every replica, in the WAL replay, in the snapshot — so every replica
+// picks the same number for the same entry.
+func (dt *DataTree) CreateSequential(prefix string, data []byte, owner int64, txn Txn) (string, error) {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	return dt.create(prefix, data, owner, true, nil, txn)
+}
+
+// CreateNode is the one create that does it all, described by an Op:
+// Owner as in CreateEphemeral, Sequential as in CreateSequential, and
+// the node's ACL (acl.Open if none). It returns the path created.
+func (dt *DataTree) CreateNode(op Op, txn Txn) (string, error) {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	return dt.create(op.Path, op.Data, op.Owner, op.Sequential, op.ACL, txn)
+}
+
+// sequenceFormat is how a sequence number is appended: 10 digits, so
+// names sort the same as numbers.
+const sequenceFormat = "%010d"
+
+// create does the work of every Create variant. Must be called with
+// dt.mu held for writing.
+func (dt *DataTree) create(path string, data []byte, owner int64, sequential bool, acls []acl.ACL, txn Txn) (string, error) {
 	// Step 1: Split the path into parent and child name.
 	//
 	// "/app/config" → parent="/app", name="config"
@@ -55,26 +165,56 @@ func (dt *DataTree) Create(path string, data []byte) error {
 	// We need both because:
 	//   - We must find the PARENT node (to add the child to it)
 	//   - We need the NAME to use as the map key in parent.Children
+	//
+	// A sequential name is only a prefix, and may be empty:
+	// "/locks/" → parent="/locks", name="" (+ the sequence number).
 	parentPath, name := splitPath(path)
+	if sequential {
+		parentPath, name = splitPrefix(path)
+	}
 
 	// Step 2: Walk the tree to find the parent node.
 	parent, err := dt.findNode(parentPath)
 	if err != nil {
-		return fmt.Errorf("parent does not exist: %w", err)
+		return "", fmt.Errorf("parent does not exist: %w", err)
+	}
+	if sequential {
+		name += fmt.Sprintf(sequenceFormat, parent.Stat.Cversion)
+		path = joinPath(parentPath, name)
 	}
 
 	// Step 3: Check if the node already exists.
 	if _, exists := parent.Children[name]; exists {
-		return fmt.Errorf("node %q already exists", path)
+		return "", fmt.Errorf("node %q already exists", path)
+	}
+	if parent.Stat.EphemeralOwner != 0 {
+		return "", fmt.Errorf("%w: %q is ephemeral", ErrEphemeralParent, parentPath)
+	}
+	if len(acls) == 0 {
+		acls = acl.Open
+	} else if err := acl.Validate(acls); err != nil {
+		return "", err
 	}
 
 	// Step 4: Create the new node and attach it to the parent.
 	parent.Children[name] = &ZNode{
 		Data:     data,
 		Children: make(map[string]*ZNode), // ready for its own children
+		Stat: Stat{
+			Czxid: txn.Zxid,
+			Mzxid: txn.Zxid,
+			Ctime: txn.Time,
+			Mtime: txn.Time,
+
+			EphemeralOwner: owner,
+		},
+		ACL: append([]acl.ACL(nil), acls...),
 	}
+	parent.Stat.Cversion++
+	dt.count++
+	dt.addEphemeral(owner, path)
 
-	return nil
+	return path, nil
 }
 
 // Get retrieves the data stored at the given path.
@@ -83,6 +223,9 @@ func (dt *DataTree) Create(path string, data []byte) error {
 // Why? If we returned the original slice, the caller could modify it
 // and corrupt our tree without going through proper channels.
 func (dt *DataTree) Get(path string) ([]byte, error) {
+	dt.mu.RLock()
+	defer dt.mu.RUnlock()
+
 	node, err := dt.findNode(path)
 	if err != nil {
 		return nil, err
@@ -95,18 +238,103 @@ func (dt *DataTree) Get(path string) ([]byte, error) {
 	return dataCopy, nil
 }
 
+// GetWithStat is Get plus the node's Stat, read together.
+func (dt *DataTree) GetWithStat(path string) ([]byte, Stat, error) {
+	dt.mu.RLock()
+	defer dt.mu.RUnlock()
+
+	node, err := dt.findNode(path)
+	if err != nil {
+		return nil, Stat{}, err
+	}
+
+	dataCopy := make([]byte, len(node.Data))
+	copy(dataCopy, node.Data)
+	return dataCopy, node.stat(), nil
+}
+
+// Stat returns the metadata of the node at path.
+func (dt *DataTree) Stat(path string) (Stat, error) {
+	dt.mu.RLock()
+	defer dt.mu.RUnlock()
+
+	node, err := dt.findNode(path)
+	if err != nil {
+		return Stat{}, err
+	}
+	return node.stat(), nil
+}
+
 // Set updates the data of an existing znode.
 //
 // Unlike Create, Set does NOT create a new node — the node must already exist.
 // This is intentional: Create and Set are separate operations so you can tell
 // the difference between "this is new" vs "this is an update".
-func (dt *DataTree) Set(path string, data []byte) error {
+//
+// version is the version the caller expects the node to be at, or
+// AnyVersion. On a mismatch nothing changes and ErrBadVersion is returned.
+func (dt *DataTree) Set(path string, data []byte, version int32, txn Txn) error {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	return dt.set(path, data, version, txn)
+}
+
+// set is Set with dt.mu already held for writing.
+func (dt *DataTree) set(path string, data []byte, version int32, txn Txn) error {
 	node, err := dt.findNode(path)
 	if err != nil {
 		return err
 	}
+	if err := checkVersion(path, node, version); err != nil {
+		return err
+	}
 
 	node.Data = data
+	node.Stat.Version++
+	node.Stat.Mzxid = txn.Zxid
+	node.Stat.Mtime = txn.Time
+	return nil
+}
+
+// GetACL returns the ACL of the node at path, and its Stat.
+func (dt *DataTree) GetACL(path string) ([]acl.ACL, Stat, error) {
+	dt.mu.RLock()
+	defer dt.mu.RUnlock()
+
+	node, err := dt.findNode(path)
+	if err != nil {
+		return nil, Stat{}, err
+	}
+	return append([]acl.ACL(nil), node.ACL...), node.stat(), nil
+}
+
+// SetACL replaces the ACL of the node at path.
+//
+// version is the Aversion the caller expects, or AnyVersion; on a
+// mismatch nothing changes and ErrBadVersion is returned. Like
+// ZooKeeper, an ACL change isn't a data change: Aversion goes up,
+// Version and Mzxid stay.
+func (dt *DataTree) SetACL(path string, acls []acl.ACL, version int32) error {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	node, err := dt.findNode(path)
+	if err != nil {
+		return err
+	}
+	if version != AnyVersion && version != node.Stat.Aversion {
+		return fmt.Errorf("%w: node %q is at ACL version %d, expected %d",
+			ErrBadVersion, path, node.Stat.Aversion, version)
+	}
+	if len(acls) == 0 {
+		return fmt.Errorf("%w: a node needs at least one entry", acl.ErrInvalid)
+	}
+	if err := acl.Validate(acls); err != nil {
+		return err
+	}
+
+	node.ACL = append([]acl.ACL(nil), acls...)
+	node.Stat.Aversion++
 	return nil
 }
 
@@ -118,9 +346,18 @@ func (dt *DataTree) Set(path string, data []byte) error {
 // children first, so you're always explicit about what you're removing.
 //
 // To delete a subtree, delete from the leaves up:
-//   tree.Delete("/app/config")  // leaf first
-//   tree.Delete("/app")         // now safe
-func (dt *DataTree) Delete(path string) error {
+//   tree.Delete("/app/config", AnyVersion, txn)  // leaf first
+//   tree.Delete("/app", AnyVersion, txn)         // now safe
+//
+// version works like in Set. txn counts as a child change on the parent.
+func (dt *DataTree) Delete(path string, version int32, txn Txn) error {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	return dt.remove(path, version, txn)
+}
+
+// remove is Delete with dt.mu already held for writing.
+func (dt *DataTree) remove(path string, version int32, txn Txn) error {
 	if path == "/" {
 		return fmt.Errorf("cannot delete root node")
 	}
@@ -140,6 +377,10 @@ func (dt *DataTree) Delete(path string) error {
 		return fmt.Errorf("node %q does not exist", path)
 	}
 
+	if err := checkVersion(path, child, version); err != nil {
+		return err
+	}
+
 	// Block deletion if the node has children (no orphans allowed)
 	if len(child.Children) > 0 {
 		return fmt.Errorf("node %q has children, delete them first", path)
@@ -148,9 +389,84 @@ func (dt *DataTree) Delete(path string) error {
 	// Remove from parent's map. After this, nothing references the node
 	// and Go's garbage collector will free it.
 	delete(parent.Children, name)
+	parent.Stat.Cversion++
+	dt.count--
+	dt.removeEphemeral(child.Stat.EphemeralOwner, path)
 	return nil
 }
 
+// Ephemerals returns the paths of the ephemeral nodes owned by session,
+// sorted.
+func (dt *DataTree) Ephemerals(session int64) []string {
+	dt.mu.RLock()
+	defer dt.mu.RUnlock()
+	return dt.ephemeralPaths(session)
+}
+
+// ephemeralPaths is Ephemerals with dt.mu already held.
+func (dt *DataTree) ephemeralPaths(session int64) []string {
+	paths := make([]string, 0, len(dt.ephemerals[session]))
+	for path := range dt.ephemerals[session] {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// DeleteEphemerals deletes every ephemeral node owned by session, as
+// part of the write txn that ends the session. Returns the deleted paths.
+//
+// Ephemeral nodes have no children, so they can go in any order.
+func (dt *DataTree) DeleteEphemerals(session int64, txn Txn) []string {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	paths := dt.ephemeralPaths(session)
+	for _, path := range paths {
+		_ = dt.remove(path, AnyVersion, txn)
+	}
+	return paths
+}
+
+// addEphemeral records path as owned by owner. No-op for owner 0.
+func (dt *DataTree) addEphemeral(owner int64, path string) {
+	if owner == 0 {
+		return
+	}
+	if dt.ephemerals[owner] == nil {
+		dt.ephemerals[owner] = make(map[string]struct{})
+	}
+	dt.ephemerals[owner][path] = struct{}{}
+}
+
+// removeEphemeral forgets path. No-op for owner 0.
+func (dt *DataTree) removeEphemeral(owner int64, path string) {
+	if owner == 0 {
+		return
+	}
+	delete(dt.ephemerals[owner], path)
+	if len(dt.ephemerals[owner]) == 0 {
+		delete(dt.ephemerals, owner)
+	}
+}
+
+// checkVersion returns ErrBadVersion if node isn't at the expected version.
+func checkVersion(path string, node *ZNode, version int32) error {
+	if version != AnyVersion && version != node.Stat.Version {
+		return fmt.Errorf("%w: node %q is at version %d, expected %d",
+			ErrBadVersion, path, node.Stat.Version, version)
+	}
+	return nil
+}
+
+// stat returns the node's Stat with the derived fields filled in.
+func (n *ZNode) stat() Stat {
+	st := n.Stat
+	st.DataLength = int32(len(n.Data))
+	st.NumChildren = int32(len(n.Children))
+	return st
+}
+
 // GetChildren returns the names of all direct children of the node at path.
 //
 // Example:
@@ -160,6 +476,9 @@ func (dt *DataTree) Delete(path string) error {
 // This is how service discovery works: create children under /services,
 // then GetChildren("/services") returns all registered services.
 func (dt *DataTree) GetChildren(path string) ([]string, error) {
+	dt.mu.RLock()
+	defer dt.mu.RUnlock()
+
 	node, err := dt.findNode(path)
 	if err != nil {
 		return nil, err
@@ -230,6 +549,27 @@ func splitPath(path string) (string, string) {
 	return path[:idx], path[idx+1:]
 }
 
+// splitPrefix is splitPath for a sequential node's prefix, which keeps
+// a trailing slash: "/locks/" → parent="/locks", name="".
+func splitPrefix(prefix string) (string, string) {
+	idx := strings.LastIndex(prefix, "/")
+	if idx == 0 {
+		return "/", prefix[1:]
+	}
+	if idx < 0 {
+		return "", prefix // no parent: findNode fails
+	}
+	return prefix[:idx], prefix[idx+1:]
+}
+
+// joinPath puts a child name under a parent path.
+func joinPath(parent, name string) string {
+	if parent == "/" {
+		return "/" + name
+	}
+	return parent + "/" + name
+}
+
 // ToSnapshot walks the tree and collects every znode into a flat list.
 //
 // We walk depth-first, which means parents always appear before children:
@@ -244,13 +584,33 @@ func splitPath(path string) (string, string) {
 // To create /app/config, /app must already exist. If we listed children
 // before parents, the restore would fail with "parent does not exist".
 func (dt *DataTree) ToSnapshot() []snapshot.NodeData {
+	dt.mu.RLock()
+	defer dt.mu.RUnlock()
+
 	var nodes []snapshot.NodeData
-	dt.walkNode("/", dt.root, &nodes)
+	dt.walkNode("/", dt.root, func(nd snapshot.NodeData) error {
+		nodes = append(nodes, nd)
+		return nil
+	})
 	return nodes
 }
 
-// walkNode is a recursive helper for ToSnapshot.
-// It adds the current node, then recurses into each child.
+// WalkSnapshot is ToSnapshot without the list: it hands each znode to
+// fn as it's visited, in the same parents-first order. Used to stream a
+// snapshot to disk without copying the tree:
+//
+//	tree.WalkSnapshot(writer.Add)
+//
+// Stops at the first error fn returns. Writes wait until the walk is
+// done: fn sees one consistent tree.
+func (dt *DataTree) WalkSnapshot(fn func(snapshot.NodeData) error) error {
+	dt.mu.RLock()
+	defer dt.mu.RUnlock()
+	return dt.walkNode("/", dt.root, fn)
+}
+
+// walkNode is a recursive helper for ToSnapshot and WalkSnapshot.
+// It visits the current node, then recurses into each child.
 //
 // For the tree:
 //   /
@@ -259,16 +619,28 @@ func (dt *DataTree) ToSnapshot() []snapshot.NodeData {
 //   └── locks
 //
 // The calls look like:
-//   walkNode("/",          root)       → adds "/"
-//     walkNode("/app",     app_node)   → adds "/app"
-//       walkNode("/app/config", config_node) → adds "/app/config"
-//     walkNode("/locks",   locks_node) → adds "/locks"
-func (dt *DataTree) walkNode(path string, node *ZNode, nodes *[]snapshot.NodeData) {
-	// Add this node to the list
-	*nodes = append(*nodes, snapshot.NodeData{
-		Path: path,
-		Data: node.Data,
-	})
+//   walkNode("/",          root)       → visits "/"
+//     walkNode("/app",     app_node)   → visits "/app"
+//       walkNode("/app/config", config_node) → visits "/app/config"
+//     walkNode("/locks",   locks_node) → visits "/locks"
+func (dt *DataTree) walkNode(path string, node *ZNode, fn func(snapshot.NodeData) error) error {
+	// Visit this node
+	if err := fn(snapshot.NodeData{
+		Path:     path,
+		Data:     node.Data,
+		Czxid:    node.Stat.Czxid,
+		Mzxid:    node.Stat.Mzxid,
+		Ctime:    node.Stat.Ctime,
+		Mtime:    node.Stat.Mtime,
+		Version:  node.Stat.Version,
+		Cversion: node.Stat.Cversion,
+		Aversion: node.Stat.Aversion,
+
+		EphemeralOwner: node.Stat.EphemeralOwner,
+		ACL:            node.ACL,
+	}); err != nil {
+		return err
+	}
 
 	// Recurse into children
 	for name, child := range node.Children {
@@ -277,37 +649,80 @@ func (dt *DataTree) walkNode(path string, node *ZNode, nodes *[]snapshot.NodeDat
 		if path == "/" {
 			childPath = "/" + name
 		}
-		dt.walkNode(childPath, child, nodes)
+		if err := dt.walkNode(childPath, child, fn); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 // RestoreFromSnapshot rebuilds the tree from a flat list of NodeData.
 //
-// It walks through the list in order, creating each node.
+// It walks through the list in order, attaching each node to its parent.
 // Because ToSnapshot outputs parents before children (depth-first),
-// we can simply call Create for each entry and the parent will always
-// exist by the time we reach the child.
+// the parent will always exist by the time we reach the child.
 //
 // Example:
 //   Input: [{"/", nil}, {"/app", "hello"}, {"/app/config", "5432"}]
 //
-//   Step 1: "/" → skip (root always exists)
-//   Step 2: "/app" → Create("/app", "hello")  ✓ (parent "/" exists)
-//   Step 3: "/app/config" → Create("/app/config", "5432")  ✓ (parent "/app" exists)
+//   Step 1: "/" → root always exists, restore its data
+//   Step 2: "/app" → attach to "/"  ✓ (parent "/" exists)
+//   Step 3: "/app/config" → attach to "/app"  ✓ (parent "/app" exists)
+//
+// Why not call Create? Create stamps a new Stat and bumps the parent's
+// Cversion. A restored node must get back exactly the Stat it had.
 func (dt *DataTree) RestoreFromSnapshot(nodes []snapshot.NodeData) {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
 	// Reset the tree to empty (just root)
 	dt.root = &ZNode{
 		Children: make(map[string]*ZNode),
+		ACL:      acl.Open,
 	}
+	dt.ephemerals = make(map[int64]map[string]struct{})
+	dt.count = 1
 
 	for _, nd := range nodes {
+		stat := Stat{
+			Czxid:    nd.Czxid,
+			Mzxid:    nd.Mzxid,
+			Ctime:    nd.Ctime,
+			Mtime:    nd.Mtime,
+			Version:  nd.Version,
+			Cversion: nd.Cversion,
+			Aversion: nd.Aversion,
+
+			EphemeralOwner: nd.EphemeralOwner,
+		}
+
+		// Snapshots from before ACLs have none: those nodes were
+		// open to everyone, and still are.
+		acls := nd.ACL
+		if len(acls) == 0 {
+			acls = acl.Open
+		}
+
 		if nd.Path == "/" {
 			// Root always exists — just restore its data
 			dt.root.Data = nd.Data
+			dt.root.Stat = stat
+			dt.root.ACL = acls
 			continue
 		}
 
-		// Create the node. We reuse our existing Create method.
-		dt.Create(nd.Path, nd.Data)
+		parentPath, name := splitPath(nd.Path)
+		parent, err := dt.findNode(parentPath)
+		if err != nil {
+			continue // can't happen: parents come first
+		}
+		parent.Children[name] = &ZNode{
+			Data:     nd.Data,
+			Children: make(map[string]*ZNode),
+			Stat:     stat,
+			ACL:      acls,
+		}
+		dt.count++
+		dt.addEphemeral(stat.EphemeralOwner, nd.Path)
 	}
 }
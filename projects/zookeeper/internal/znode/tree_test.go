@@ -1,12 +1,21 @@
 package znode
 
-import "testing"
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/syamsularifin/zookeeper/internal/acl"
+	"github.com/syamsularifin/zookeeper/internal/snapshot"
+)
 
 func TestCreateAndGet(t *testing.T) {
 	tree := NewDataTree()
 
 	// Create a node and read it back
-	err := tree.Create("/app", []byte("hello"))
+	err := tree.Create("/app", []byte("hello"), Txn{})
 	if err != nil {
 		t.Fatalf("Create failed: %v", err)
 	}
@@ -25,8 +34,8 @@ func TestCreateNested(t *testing.T) {
 	tree := NewDataTree()
 
 	// Create parent first, then child
-	tree.Create("/app", []byte("parent"))
-	err := tree.Create("/app/config", []byte("port=5432"))
+	tree.Create("/app", []byte("parent"), Txn{})
+	err := tree.Create("/app/config", []byte("port=5432"), Txn{})
 	if err != nil {
 		t.Fatalf("Create nested failed: %v", err)
 	}
@@ -41,7 +50,7 @@ func TestCreateFailsWithoutParent(t *testing.T) {
 	tree := NewDataTree()
 
 	// /a doesn't exist, so /a/b should fail
-	err := tree.Create("/a/b", []byte("data"))
+	err := tree.Create("/a/b", []byte("data"), Txn{})
 	if err == nil {
 		t.Fatal("expected error when parent doesn't exist")
 	}
@@ -50,8 +59,8 @@ func TestCreateFailsWithoutParent(t *testing.T) {
 func TestCreateFailsDuplicate(t *testing.T) {
 	tree := NewDataTree()
 
-	tree.Create("/app", nil)
-	err := tree.Create("/app", nil)
+	tree.Create("/app", nil, Txn{})
+	err := tree.Create("/app", nil, Txn{})
 	if err == nil {
 		t.Fatal("expected error on duplicate create")
 	}
@@ -68,7 +77,7 @@ func TestGetFailsNotFound(t *testing.T) {
 
 func TestGetReturnsCopy(t *testing.T) {
 	tree := NewDataTree()
-	tree.Create("/app", []byte("original"))
+	tree.Create("/app", []byte("original"), Txn{})
 
 	// Get returns a copy — modifying it should NOT affect the tree
 	data, _ := tree.Get("/app")
@@ -85,10 +94,10 @@ func TestGetReturnsCopy(t *testing.T) {
 
 func TestSet(t *testing.T) {
 	tree := NewDataTree()
-	tree.Create("/app", []byte("v1"))
+	tree.Create("/app", []byte("v1"), Txn{})
 
 	// Update the data
-	err := tree.Set("/app", []byte("v2"))
+	err := tree.Set("/app", []byte("v2"), AnyVersion, Txn{})
 	if err != nil {
 		t.Fatalf("Set failed: %v", err)
 	}
@@ -103,7 +112,7 @@ func TestSet(t *testing.T) {
 func TestSetFailsNotFound(t *testing.T) {
 	tree := NewDataTree()
 
-	err := tree.Set("/missing", []byte("data"))
+	err := tree.Set("/missing", []byte("data"), AnyVersion, Txn{})
 	if err == nil {
 		t.Fatal("expected error when setting non-existent node")
 	}
@@ -113,9 +122,9 @@ func TestSetFailsNotFound(t *testing.T) {
 
 func TestDelete(t *testing.T) {
 	tree := NewDataTree()
-	tree.Create("/app", nil)
+	tree.Create("/app", nil, Txn{})
 
-	err := tree.Delete("/app")
+	err := tree.Delete("/app", AnyVersion, Txn{})
 	if err != nil {
 		t.Fatalf("Delete failed: %v", err)
 	}
@@ -129,11 +138,11 @@ func TestDelete(t *testing.T) {
 
 func TestDeleteFailsWithChildren(t *testing.T) {
 	tree := NewDataTree()
-	tree.Create("/app", nil)
-	tree.Create("/app/config", nil)
+	tree.Create("/app", nil, Txn{})
+	tree.Create("/app/config", nil, Txn{})
 
 	// Can't delete /app because /app/config still exists
-	err := tree.Delete("/app")
+	err := tree.Delete("/app", AnyVersion, Txn{})
 	if err == nil {
 		t.Fatal("expected error when deleting node with children")
 	}
@@ -142,7 +151,7 @@ func TestDeleteFailsWithChildren(t *testing.T) {
 func TestDeleteCannotDeleteRoot(t *testing.T) {
 	tree := NewDataTree()
 
-	err := tree.Delete("/")
+	err := tree.Delete("/", AnyVersion, Txn{})
 	if err == nil {
 		t.Fatal("expected error when deleting root")
 	}
@@ -152,10 +161,10 @@ func TestDeleteCannotDeleteRoot(t *testing.T) {
 
 func TestGetChildren(t *testing.T) {
 	tree := NewDataTree()
-	tree.Create("/app", nil)
-	tree.Create("/app/config", nil)
-	tree.Create("/app/leader", nil)
-	tree.Create("/app/locks", nil)
+	tree.Create("/app", nil, Txn{})
+	tree.Create("/app/config", nil, Txn{})
+	tree.Create("/app/leader", nil, Txn{})
+	tree.Create("/app/locks", nil, Txn{})
 
 	children, err := tree.GetChildren("/app")
 	if err != nil {
@@ -169,7 +178,7 @@ func TestGetChildren(t *testing.T) {
 
 func TestGetChildrenEmpty(t *testing.T) {
 	tree := NewDataTree()
-	tree.Create("/app", nil)
+	tree.Create("/app", nil, Txn{})
 
 	children, err := tree.GetChildren("/app")
 	if err != nil {
@@ -181,14 +190,257 @@ func TestGetChildrenEmpty(t *testing.T) {
 	}
 }
 
+// --- Stat tests ---
+
+func TestStatTracksChanges(t *testing.T) {
+	tree := NewDataTree()
+	tree.Create("/app", []byte("v1"), Txn{Zxid: 1, Time: 1000})
+	tree.Set("/app", []byte("v22"), AnyVersion, Txn{Zxid: 2, Time: 2000})
+	tree.Create("/app/config", nil, Txn{Zxid: 3, Time: 3000})
+
+	st, err := tree.Stat("/app")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	want := Stat{
+		Czxid: 1, Mzxid: 2, Ctime: 1000, Mtime: 2000,
+		Version: 1, Cversion: 1, DataLength: 3, NumChildren: 1,
+	}
+	if st != want {
+		t.Fatalf("expected %+v, got %+v", want, st)
+	}
+
+	// Deleting the child is a child change too.
+	tree.Delete("/app/config", AnyVersion, Txn{Zxid: 4, Time: 4000})
+	st, _ = tree.Stat("/app")
+	if st.Cversion != 2 || st.NumChildren != 0 || st.Mzxid != 2 {
+		t.Fatalf("expected Cversion 2, no children, Mzxid 2, got %+v", st)
+	}
+}
+
+func TestSetWithVersion(t *testing.T) {
+	tree := NewDataTree()
+	tree.Create("/app", []byte("v0"), Txn{})
+
+	if err := tree.Set("/app", []byte("v1"), 0, Txn{}); err != nil {
+		t.Fatalf("Set at the right version failed: %v", err)
+	}
+
+	// Still expecting version 0 — someone (us) already moved it to 1.
+	err := tree.Set("/app", []byte("lost"), 0, Txn{})
+	if !errors.Is(err, ErrBadVersion) {
+		t.Fatalf("expected ErrBadVersion, got %v", err)
+	}
+
+	data, st, _ := tree.GetWithStat("/app")
+	if string(data) != "v1" || st.Version != 1 {
+		t.Fatalf("a failed Set must change nothing, got %q at version %d", data, st.Version)
+	}
+}
+
+func TestDeleteWithVersion(t *testing.T) {
+	tree := NewDataTree()
+	tree.Create("/app", nil, Txn{})
+	tree.Set("/app", []byte("x"), AnyVersion, Txn{})
+
+	if err := tree.Delete("/app", 0, Txn{}); !errors.Is(err, ErrBadVersion) {
+		t.Fatalf("expected ErrBadVersion, got %v", err)
+	}
+	if err := tree.Delete("/app", 1, Txn{}); err != nil {
+		t.Fatalf("Delete at the right version failed: %v", err)
+	}
+	if _, err := tree.Get("/app"); err == nil {
+		t.Fatal("/app should be gone")
+	}
+}
+
+// --- Ephemeral tests ---
+
+func TestEphemeralNodes(t *testing.T) {
+	tree := NewDataTree()
+	tree.Create("/workers", nil, Txn{})
+	tree.CreateEphemeral("/workers/a", nil, 7, Txn{})
+	tree.CreateEphemeral("/workers/b", nil, 7, Txn{})
+	tree.CreateEphemeral("/workers/c", nil, 8, Txn{})
+
+	st, _ := tree.Stat("/workers/a")
+	if st.EphemeralOwner != 7 {
+		t.Fatalf("expected owner 7, got %d", st.EphemeralOwner)
+	}
+
+	err := tree.Create("/workers/a/child", nil, Txn{})
+	if !errors.Is(err, ErrEphemeralParent) {
+		t.Fatalf("expected ErrEphemeralParent, got %v", err)
+	}
+
+	// Deleting one by hand takes it out of the session's list too.
+	tree.Delete("/workers/b", AnyVersion, Txn{})
+	if got := tree.Ephemerals(7); len(got) != 1 || got[0] != "/workers/a" {
+		t.Fatalf("expected [/workers/a] for session 7, got %v", got)
+	}
+
+	deleted := tree.DeleteEphemerals(7, Txn{Zxid: 9})
+	if len(deleted) != 1 || deleted[0] != "/workers/a" {
+		t.Fatalf("expected to delete [/workers/a], got %v", deleted)
+	}
+	children, _ := tree.GetChildren("/workers")
+	if len(children) != 1 || children[0] != "c" {
+		t.Fatalf("only session 8's node should be left, got %v", children)
+	}
+}
+
+func TestEphemeralsSurviveSnapshot(t *testing.T) {
+	original := NewDataTree()
+	original.Create("/locks", nil, Txn{})
+	original.CreateEphemeral("/locks/x", nil, 42, Txn{})
+
+	restored := NewDataTree()
+	restored.RestoreFromSnapshot(original.ToSnapshot())
+
+	if got := restored.Ephemerals(42); len(got) != 1 || got[0] != "/locks/x" {
+		t.Fatalf("expected session 42 to own /locks/x after restore, got %v", got)
+	}
+	restored.DeleteEphemerals(42, Txn{})
+	if _, err := restored.Get("/locks/x"); err == nil {
+		t.Fatal("/locks/x should be gone with its session")
+	}
+}
+
+func TestCreateSequential(t *testing.T) {
+	tree := NewDataTree()
+	tree.Create("/locks", nil, Txn{})
+	tree.Create("/locks/other", nil, Txn{}) // a normal child counts too
+
+	first, err := tree.CreateSequential("/locks/lock-", nil, 0, Txn{})
+	if err != nil || first != "/locks/lock-0000000001" {
+		t.Fatalf("expected /locks/lock-0000000001, got %q (%v)", first, err)
+	}
+	tree.Delete(first, AnyVersion, Txn{})
+
+	// Deleting doesn't give the number back: the counter only goes up.
+	second, _ := tree.CreateSequential("/locks/lock-", nil, 0, Txn{})
+	if second != "/locks/lock-0000000003" {
+		t.Fatalf("expected /locks/lock-0000000003, got %q", second)
+	}
+
+	// An empty prefix, and an ephemeral sequential node.
+	bare, _ := tree.CreateSequential("/locks/", nil, 42, Txn{})
+	if bare != "/locks/0000000004" {
+		t.Fatalf("expected /locks/0000000004, got %q", bare)
+	}
+	if got := tree.Ephemerals(42); len(got) != 1 || got[0] != bare {
+		t.Fatalf("expected session 42 to own %s, got %v", bare, got)
+	}
+
+	if _, err := tree.CreateSequential("/missing/x-", nil, 0, Txn{}); err == nil {
+		t.Fatal("expected an error for a missing parent")
+	}
+}
+
+func TestSequenceSurvivesSnapshot(t *testing.T) {
+	original := NewDataTree()
+	original.Create("/q", nil, Txn{})
+	original.CreateSequential("/q/job-", nil, 0, Txn{})
+	original.CreateSequential("/q/job-", nil, 0, Txn{})
+
+	restored := NewDataTree()
+	restored.RestoreFromSnapshot(original.ToSnapshot())
+
+	want, _ := original.CreateSequential("/q/job-", nil, 0, Txn{})
+	got, _ := restored.CreateSequential("/q/job-", nil, 0, Txn{})
+	if got != want || got != "/q/job-0000000002" {
+		t.Fatalf("expected both trees to pick /q/job-0000000002, got %q and %q", want, got)
+	}
+}
+
+func TestCreateNodeWithACL(t *testing.T) {
+	tree := NewDataTree()
+	readOnly := []acl.ACL{{Perms: acl.Read, ID: acl.Anyone}}
+
+	path, err := tree.CreateNode(Op{Path: "/app", ACL: readOnly}, Txn{})
+	if err != nil || path != "/app" {
+		t.Fatalf("CreateNode failed: %q, %v", path, err)
+	}
+	got, _, _ := tree.GetACL("/app")
+	if len(got) != 1 || got[0] != readOnly[0] {
+		t.Fatalf("expected %v, got %v", readOnly, got)
+	}
+
+	// No ACL given: open to everyone, like the root.
+	tree.Create("/open", nil, Txn{})
+	for _, path := range []string{"/", "/open"} {
+		if got, _, _ := tree.GetACL(path); len(got) != 1 || got[0] != acl.Open[0] {
+			t.Fatalf("%s: expected %v, got %v", path, acl.Open, got)
+		}
+	}
+
+	bad := []acl.ACL{{Perms: acl.Read, ID: acl.ID{Scheme: "ip", ID: "10.0.0.1"}}}
+	if _, err := tree.CreateNode(Op{Path: "/bad", ACL: bad}, Txn{}); !errors.Is(err, acl.ErrInvalid) {
+		t.Fatalf("expected acl.ErrInvalid, got %v", err)
+	}
+}
+
+func TestSetACL(t *testing.T) {
+	tree := NewDataTree()
+	tree.Create("/app", []byte("a"), Txn{Zxid: 1})
+	readOnly := []acl.ACL{{Perms: acl.Read, ID: acl.Anyone}}
+
+	if err := tree.SetACL("/app", readOnly, 0); err != nil {
+		t.Fatalf("SetACL failed: %v", err)
+	}
+	got, stat, _ := tree.GetACL("/app")
+	if len(got) != 1 || got[0] != readOnly[0] {
+		t.Fatalf("expected %v, got %v", readOnly, got)
+	}
+	// An ACL change isn't a data change.
+	if stat.Aversion != 1 || stat.Version != 0 || stat.Mzxid != 1 {
+		t.Fatalf("expected only Aversion to move, got %+v", stat)
+	}
+
+	if err := tree.SetACL("/app", acl.Open, 0); !errors.Is(err, ErrBadVersion) {
+		t.Fatalf("expected ErrBadVersion for a stale aversion, got %v", err)
+	}
+	if err := tree.SetACL("/app", nil, AnyVersion); !errors.Is(err, acl.ErrInvalid) {
+		t.Fatalf("expected acl.ErrInvalid for an empty ACL, got %v", err)
+	}
+	if err := tree.SetACL("/missing", acl.Open, AnyVersion); err == nil {
+		t.Fatal("expected an error for a missing node")
+	}
+}
+
+func TestACLSurvivesSnapshot(t *testing.T) {
+	original := NewDataTree()
+	readOnly := []acl.ACL{{Perms: acl.Read, ID: acl.Anyone}}
+	original.CreateNode(Op{Path: "/app", ACL: readOnly}, Txn{})
+	original.SetACL("/", []acl.ACL{{Perms: acl.All, ID: acl.Digest("alice", "secret")}}, AnyVersion)
+
+	restored := NewDataTree()
+	restored.RestoreFromSnapshot(original.ToSnapshot())
+
+	for _, path := range []string{"/", "/app"} {
+		want, wantStat, _ := original.GetACL(path)
+		got, gotStat, err := restored.GetACL(path)
+		if err != nil || len(got) != 1 || got[0] != want[0] || gotStat != wantStat {
+			t.Fatalf("%s: expected %v %+v after restore, got %v %+v (%v)", path, want, wantStat, got, gotStat, err)
+		}
+	}
+
+	// Snapshots from before ACLs: every node comes back open.
+	old := NewDataTree()
+	old.RestoreFromSnapshot([]snapshot.NodeData{{Path: "/"}, {Path: "/app"}})
+	if got, _, _ := old.GetACL("/app"); len(got) != 1 || got[0] != acl.Open[0] {
+		t.Fatalf("expected a node without an ACL to be open, got %v", got)
+	}
+}
+
 // --- Snapshot tests ---
 
 func TestToSnapshotAndRestore(t *testing.T) {
 	// Build a tree
 	original := NewDataTree()
-	original.Create("/app", []byte("hello"))
-	original.Create("/app/config", []byte("port=5432"))
-	original.Create("/locks", nil)
+	original.Create("/app", []byte("hello"), Txn{})
+	original.Create("/app/config", []byte("port=5432"), Txn{})
+	original.Create("/locks", nil, Txn{})
 
 	// Dump it to a snapshot
 	nodes := original.ToSnapshot()
@@ -219,3 +471,134 @@ func TestToSnapshotAndRestore(t *testing.T) {
 		t.Fatalf("Get /locks failed: %v", err)
 	}
 }
+
+func TestCount(t *testing.T) {
+	tree := NewDataTree()
+	if got := tree.Count(); got != 1 {
+		t.Fatalf("empty tree: expected 1 node (the root), got %d", got)
+	}
+
+	tree.Create("/app", nil, Txn{})
+	tree.Create("/app/config", nil, Txn{})
+	tree.Create("/app/config", nil, Txn{}) // exists: no change
+	tree.Create("/locks", nil, Txn{})
+	tree.Delete("/locks", AnyVersion, Txn{})
+	tree.Delete("/app", AnyVersion, Txn{}) // has children: no change
+	if got := tree.Count(); got != 3 {
+		t.Fatalf("expected 3 nodes, got %d", got)
+	}
+
+	restored := NewDataTree()
+	restored.Create("/gone", nil, Txn{})
+	restored.RestoreFromSnapshot(tree.ToSnapshot())
+	if got := restored.Count(); got != 3 {
+		t.Fatalf("restored: expected 3 nodes, got %d", got)
+	}
+}
+
+func TestSnapshotKeepsStat(t *testing.T) {
+	original := NewDataTree()
+	original.Create("/app", []byte("a"), Txn{Zxid: 1, Time: 1000})
+	original.Create("/app/x", nil, Txn{Zxid: 2, Time: 2000})
+	original.Set("/app", []byte("bb"), AnyVersion, Txn{Zxid: 3, Time: 3000})
+
+	restored := NewDataTree()
+	restored.RestoreFromSnapshot(original.ToSnapshot())
+
+	for _, path := range []string{"/", "/app", "/app/x"} {
+		want, _ := original.Stat(path)
+		got, err := restored.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat %s failed: %v", path, err)
+		}
+		if got != want {
+			t.Fatalf("%s: expected %+v after restore, got %+v", path, want, got)
+		}
+	}
+}
+
+func TestWalkSnapshotStopsOnError(t *testing.T) {
+	tree := NewDataTree()
+	tree.Create("/a", nil, Txn{})
+	tree.Create("/a/b", nil, Txn{})
+
+	stop := errors.New("stop")
+	visited := 0
+	err := tree.WalkSnapshot(func(nd snapshot.NodeData) error {
+		visited++
+		if nd.Path == "/a" {
+			return stop
+		}
+		return nil
+	})
+
+	if err != stop {
+		t.Fatalf("expected the callback's error back, got %v", err)
+	}
+	if visited != 2 {
+		t.Fatalf("expected to stop after 2 nodes (/ and /a), visited %d", visited)
+	}
+}
+
+// TestConcurrentReadersAndWriters runs writers and readers at once. Run
+// with -race. Every snapshot a reader takes is one consistent tree: no
+// node without its parent, and a parent's Cversion matching its
+// children (each writer only ever adds).
+func TestConcurrentReadersAndWriters(t *testing.T) {
+	tree := NewDataTree()
+	const writers, each = 4, 100
+	for w := 0; w < writers; w++ {
+		tree.Create(fmt.Sprintf("/w%d", w), nil, Txn{})
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				p := fmt.Sprintf("/w%d/n%d", w, i)
+				if err := tree.Create(p, []byte("a"), Txn{}); err != nil {
+					t.Errorf("Create %s failed: %v", p, err)
+				}
+				if err := tree.Set(p, []byte("b"), 0, Txn{}); err != nil {
+					t.Errorf("Set %s failed: %v", p, err)
+				}
+			}
+		}(w)
+	}
+
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+	for reading := true; reading; {
+		select {
+		case <-done:
+			reading = false
+		default:
+		}
+
+		seen := make(map[string]int32) // path → Cversion
+		for _, nd := range tree.ToSnapshot() {
+			if nd.Path != "/" {
+				if _, ok := seen[path.Dir(nd.Path)]; !ok {
+					t.Fatalf("%s is in the snapshot without its parent", nd.Path)
+				}
+			}
+			seen[nd.Path] = nd.Cversion
+		}
+		for w := 0; w < writers; w++ {
+			parent := fmt.Sprintf("/w%d", w)
+			children := 0
+			for i := 0; i < each; i++ {
+				if _, ok := seen[fmt.Sprintf("%s/n%d", parent, i)]; ok {
+					children++
+				}
+			}
+			if int32(children) != seen[parent] {
+				t.Fatalf("%s has %d children at Cversion %d", parent, children, seen[parent])
+			}
+		}
+		tree.GetChildren("/w0")
+		tree.Get("/w1/n0")
+	}
+}
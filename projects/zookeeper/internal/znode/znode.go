@@ -12,6 +12,10 @@ package znode
 //   /app/config              <- holds data "port=5432"
 //
 // That's it. A znode = a path + some data + children.
+// (Plus a little metadata — its Stat — so clients can tell versions apart.)
+// (And an ACL — who may read it, change it, add children under it.)
+
+import "github.com/syamsularifin/zookeeper/internal/acl"
 
 // ZNode is one node in the tree. This is the smallest building block.
 type ZNode struct {
@@ -30,4 +34,69 @@ type ZNode struct {
 	//   - We need fast existence check: _, exists := children["leader"]
 	//   - We need fast delete: delete(children, "leader")
 	Children map[string]*ZNode
+
+	// Stat is the node's metadata: who created it, versions, times.
+	// See Stat below.
+	Stat Stat
+
+	// ACL says who may do what to the node (see package acl). Never
+	// empty: a node created without one gets acl.Open.
+	ACL []acl.ACL
+}
+
+// Stat is the metadata ZooKeeper keeps for every znode, next to its data.
+//
+// The zxid fields are WAL TxIDs: "which write created this node" and
+// "which write last changed it". The versions count changes:
+//
+//	create /app "a"    → Version 0, Czxid = Mzxid = 7
+//	set    /app "b"    → Version 1, Mzxid = 8
+//	create /app/x      → /app's Cversion 1 (a child was added)
+//
+// Version is what makes compare-and-set possible: "set /app to c, but
+// only if it's still at version 1". If someone else wrote in between,
+// the version moved on and the write fails with ErrBadVersion.
+type Stat struct {
+	// Czxid is the TxID of the write that created the node.
+	Czxid int64
+
+	// Mzxid is the TxID of the write that last changed the node's data.
+	Mzxid int64
+
+	// Ctime and Mtime are when the node was created and last changed,
+	// in Unix milliseconds. They come from the WAL entry (the leader's
+	// clock), not from the node applying it, so every replica agrees.
+	Ctime int64
+	Mtime int64
+
+	// Version is the number of data changes since the node was created.
+	Version int32
+
+	// Cversion is the number of changes to the node's children.
+	Cversion int32
+
+	// Aversion is the number of changes to the node's ACL. SetACL can
+	// check it the way Set checks Version.
+	Aversion int32
+
+	// EphemeralOwner is the session that owns the node if it's ephemeral,
+	// 0 for a normal node. An ephemeral node is deleted when its session
+	// ends, and can't have children.
+	EphemeralOwner int64
+
+	// DataLength and NumChildren are derived from the node itself.
+	// They're filled in when the Stat is read, never stored.
+	DataLength  int32
+	NumChildren int32
+}
+
+// Txn is the write a change belongs to: its TxID (zxid) and the time
+// the leader accepted it. DataTree stamps it into the Stat of whatever
+// the write touches.
+type Txn struct {
+	Zxid int64
+	Time int64 // Unix milliseconds
 }
+
+// AnyVersion as an expected version means "don't check, just write".
+const AnyVersion int32 = -1
@@ -0,0 +1,117 @@
+package zkclient
+
+// DoubleBarrier: a group of processes start a phase together and finish
+// it together.
+//
+//	b := zkclient.NewDoubleBarrier(c, "/barriers/step-7", "worker-3", 5)
+//	b.Enter(ctx)   // blocks until all 5 have entered
+//	... compute ...
+//	b.Leave(ctx)   // blocks until all 5 have left
+//
+// Each process has an ephemeral node under the barrier's directory
+// while it's inside:
+//
+//	/barriers/step-7
+//	├── worker-1 ... worker-5   one per process inside
+//	└── ready                   created by whoever saw the 5th arrive
+//
+// Enter waits until there are size processes. It can't just count: a
+// fast process may enter, compute and leave before a slow one looks, and
+// the slow one would wait forever. So the first to see the count reach
+// size creates "ready", and anyone who sees "ready" is through.
+//
+// Leave deletes our node and waits until every process node is gone;
+// the last to see that deletes "ready". A directory is for one round:
+// use a new one for the next.
+
+import (
+	"context"
+	"path"
+	"slices"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+)
+
+// readyNode is created once everyone has entered.
+const readyNode = "ready"
+
+// DoubleBarrier is one process's view of a double barrier.
+type DoubleBarrier struct {
+	c    *Client
+	dir  string
+	name string
+	size int
+}
+
+// NewDoubleBarrier returns the barrier at dir for size processes. name
+// is this process's; it must be unique in the group.
+func NewDoubleBarrier(c *Client, dir, name string, size int) *DoubleBarrier {
+	return &DoubleBarrier{c: c, dir: dir, name: name, size: size}
+}
+
+// Enter blocks until size processes have entered.
+func (b *DoubleBarrier) Enter(ctx context.Context) error {
+	if err := b.c.ensurePath(ctx, b.dir); err != nil {
+		return err
+	}
+
+	// AlreadyExists: a retried create whose first attempt went through.
+	_, err := b.c.Create(ctx, path.Join(b.dir, b.name), nil, Ephemeral)
+	if err := ignore(err, codes.AlreadyExists); err != nil {
+		return err
+	}
+
+	return b.waitFor(ctx, func(children []string) (bool, error) {
+		if slices.Contains(children, readyNode) {
+			return true, nil
+		}
+		if len(children) < b.size {
+			return false, nil
+		}
+		_, err := b.c.Create(ctx, path.Join(b.dir, readyNode), nil, 0)
+		return true, ignore(err, codes.AlreadyExists)
+	})
+}
+
+// Leave blocks until every process has left.
+func (b *DoubleBarrier) Leave(ctx context.Context) error {
+	err := b.c.Delete(ctx, path.Join(b.dir, b.name), AnyVersion)
+	if err := ignore(err, codes.FailedPrecondition); err != nil {
+		return err
+	}
+
+	return b.waitFor(ctx, func(children []string) (bool, error) {
+		if slices.ContainsFunc(children, func(name string) bool { return name != readyNode }) {
+			return false, nil
+		}
+		// FailedPrecondition: someone else got there first.
+		err := b.c.Delete(ctx, path.Join(b.dir, readyNode), AnyVersion)
+		return true, ignore(err, codes.FailedPrecondition)
+	})
+}
+
+// waitFor blocks until done says yes to the barrier's children (or
+// fails). It's asked again every time they change.
+func (b *DoubleBarrier) waitFor(ctx context.Context, done func(children []string) (bool, error)) error {
+	for {
+		w, err := b.c.Watch(ctx, b.dir, zkpb.WatchType_CHILDREN, false)
+		if err != nil {
+			return err
+		}
+
+		children, err := b.c.Children(ctx, b.dir)
+		finished := false
+		if err == nil {
+			finished, err = done(children)
+		}
+		if err == nil && !finished {
+			err = b.c.wait(ctx, w)
+		}
+		w.Cancel()
+		if err != nil || finished {
+			return err
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package zkclient
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDoubleBarrier_EnterAndLeaveTogether(t *testing.T) {
+	nodes := newTestCluster(t)
+	ctx := testContext(t)
+	const size = 3
+
+	var entered, left atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < size; i++ {
+		c := connect(t, nodes)
+		b := NewDoubleBarrier(c, "/barrier", fmt.Sprintf("p-%d", i), size)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Stagger arrivals, so the first ones have to wait.
+			time.Sleep(time.Duration(i) * 100 * time.Millisecond)
+
+			entered.Add(1)
+			if err := b.Enter(ctx); err != nil {
+				t.Errorf("Enter failed: %v", err)
+				return
+			}
+			if n := entered.Load(); n != size {
+				t.Errorf("through Enter with %d of %d entered", n, size)
+			}
+
+			time.Sleep(time.Duration(size-i) * 100 * time.Millisecond)
+			left.Add(1)
+			if err := b.Leave(ctx); err != nil {
+				t.Errorf("Leave failed: %v", err)
+				return
+			}
+			if n := left.Load(); n != size {
+				t.Errorf("through Leave with %d of %d left", n, size)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,426 @@
+// Package zkclient is a Go client for zknode, with the classic
+// ZooKeeper recipes built on top.
+//
+// THE PROBLEM:
+//
+// The generated zkpb client talks to one server and knows nothing about
+// sessions. Every program that wants an ephemeral node has to open a
+// session, send keepalives, and notice when it expires; every program
+// talking to a cluster has to follow "not leader" redirects and move on
+// when a node dies. zkcli does all that, but only for itself.
+//
+// THE FIX:
+//
+// A Client is one session against a list of servers:
+//
+//	Connect(servers) → CreateSession → keepalive every timeout/3
+//	    │
+//	    ├── Create/Get/Set/Delete/Children/Multi/Watch
+//	    │     not leader, leader known → retry on the leader
+//	    │     unavailable / timed out  → back off, next server
+//	    │     anything else            → the answer
+//	    │
+//	    └── no keepalive got through for a whole timeout,
+//	        or a server says the session is gone
+//	          → Expired() closes; every call returns ErrSessionExpired
+//
+// Options.TLS connects over TLS (and, with a certificate, gives the
+// client an x509 identity); AddAuth adds digest credentials to every
+// call after it. See docs/05 for ACLs.
+//
+// A Client never starts a second session by itself: its ephemeral
+// nodes are gone with the first one, and whoever relied on them (a lock
+// holder, a leader) has to know. Connect again to start over.
+//
+// On top of that: Mutex, Election, DoubleBarrier and Queue (see the
+// files of the same name).
+package zkclient
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+	"github.com/syamsularifin/zookeeper/internal/server"
+)
+
+const (
+	// DefaultSessionTimeout is the session timeout asked for when
+	// Options.SessionTimeout is 0.
+	DefaultSessionTimeout = 10 * time.Second
+
+	// DefaultAttemptTimeout bounds one RPC to one server when
+	// Options.AttemptTimeout is 0.
+	DefaultAttemptTimeout = 5 * time.Second
+
+	// Backoff between attempts that failed because a server was down or
+	// the cluster had no leader.
+	minBackoff = 50 * time.Millisecond
+	maxBackoff = time.Second
+)
+
+var (
+	// ErrSessionExpired means the client's session is gone, and with it
+	// its ephemeral nodes. The Client can't be used any more.
+	ErrSessionExpired = errors.New("zkclient: session expired")
+
+	// ErrClosed means Close was called.
+	ErrClosed = errors.New("zkclient: client closed")
+)
+
+// Options tune a Client. The zero value is fine.
+type Options struct {
+	// SessionTimeout is how long the servers keep the session alive
+	// without a keepalive. The server may clamp it; Timeout() says what
+	// was granted.
+	SessionTimeout time.Duration
+
+	// AttemptTimeout bounds one RPC to one server. The whole call is
+	// bounded by its context.
+	AttemptTimeout time.Duration
+
+	// Consistency is how up to date Get, Exists and Children are. The
+	// zero value, LOCAL, is the fastest, but may lag behind writes;
+	// READ_INDEX and LEASE see every write committed before the read.
+	Consistency zkpb.ReadConsistency
+
+	// TLS, if set, is used to connect to the servers. A certificate in
+	// it is the client's x509 identity. nil: plaintext.
+	TLS *tls.Config
+}
+
+// Client is a session with a zknode cluster. It's safe for concurrent use.
+type Client struct {
+	attemptTimeout time.Duration
+	consistency    zkpb.ReadConsistency
+	creds          credentials.TransportCredentials
+
+	mu      sync.Mutex
+	servers []string
+	current int
+	conns   map[string]*grpc.ClientConn
+	closed  bool
+
+	// auth is the digest credentials AddAuth added, sent with every call.
+	auth []string
+
+	session  int64
+	password string // the session's, sent with every call that uses it
+	timeout  time.Duration
+
+	// expired is closed when the session is gone.
+	expired    chan struct{}
+	expireOnce sync.Once
+
+	// stop ends the keepalive loop.
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// Connect opens a session with the cluster at servers (client
+// addresses, any node will do) and keeps it alive until Close.
+func Connect(ctx context.Context, servers []string, opts Options) (*Client, error) {
+	if len(servers) == 0 {
+		return nil, errors.New("zkclient: no servers")
+	}
+	if opts.SessionTimeout == 0 {
+		opts.SessionTimeout = DefaultSessionTimeout
+	}
+	if opts.AttemptTimeout == 0 {
+		opts.AttemptTimeout = DefaultAttemptTimeout
+	}
+
+	c := &Client{
+		attemptTimeout: opts.AttemptTimeout,
+		consistency:    opts.Consistency,
+		creds:          insecure.NewCredentials(),
+		servers:        append([]string(nil), servers...),
+		conns:          make(map[string]*grpc.ClientConn),
+		expired:        make(chan struct{}),
+		stop:           make(chan struct{}),
+	}
+	if opts.TLS != nil {
+		c.creds = credentials.NewTLS(opts.TLS)
+	}
+
+	var resp *zkpb.CreateSessionResponse
+	err := c.do(ctx, func(ctx context.Context, zk zkpb.ZooKeeperClient) (err error) {
+		resp, err = zk.CreateSession(ctx, &zkpb.CreateSessionRequest{TimeoutMs: opts.SessionTimeout.Milliseconds()})
+		return err
+	})
+	if err != nil {
+		c.closeConns()
+		return nil, fmt.Errorf("zkclient: failed to create session: %w", err)
+	}
+	c.session = resp.SessionId
+	c.password = resp.Password
+	c.timeout = time.Duration(resp.TimeoutMs) * time.Millisecond
+
+	c.wg.Add(1)
+	go c.keepAlive()
+	return c, nil
+}
+
+// SessionID is the client's session, the owner of its ephemeral nodes.
+func (c *Client) SessionID() int64 { return c.session }
+
+// Timeout is the session timeout the server granted.
+func (c *Client) Timeout() time.Duration { return c.timeout }
+
+// Expired is closed when the session is gone. From then on the client's
+// ephemeral nodes may have been deleted, and every call fails.
+func (c *Client) Expired() <-chan struct{} { return c.expired }
+
+// AddAuth checks credentials ("user:password", scheme "digest") with
+// the server and, if they're good, sends them with every call from now
+// on. It returns every identity the client now has, e.g.
+// ["world:anyone", "digest:alice:aYXl..."].
+//
+// The server keeps nothing: a Client that reconnects elsewhere is still
+// authenticated, because the credentials go with each call.
+func (c *Client) AddAuth(ctx context.Context, scheme, creds string) ([]string, error) {
+	var resp *zkpb.AuthenticateResponse
+	err := c.do(ctx, func(ctx context.Context, zk zkpb.ZooKeeperClient) (err error) {
+		resp, err = zk.Authenticate(ctx, &zkpb.AuthenticateRequest{Scheme: scheme, Credentials: creds})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.auth = append(c.auth, creds)
+	c.mu.Unlock()
+	return resp.Identities, nil
+}
+
+// Close ends the session, deleting its ephemeral nodes, and closes the
+// connections. Safe to call twice.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		c.wg.Wait()
+
+		// Best effort: if this fails, the session expires on its own.
+		ctx, cancel := context.WithTimeout(context.Background(), c.attemptTimeout)
+		defer cancel()
+		c.do(ctx, func(ctx context.Context, zk zkpb.ZooKeeperClient) error {
+			_, err := zk.CloseSession(ctx, &zkpb.CloseSessionRequest{SessionId: c.session, Password: c.password})
+			return err
+		})
+
+		c.mu.Lock()
+		c.closed = true
+		c.mu.Unlock()
+		c.closeConns()
+	})
+	return nil
+}
+
+// keepAlive sends a keepalive every timeout/3, so one can be lost (or
+// land on a node that's mid-failover) without the session expiring.
+//
+// If none gets through for a whole timeout, the servers may well have
+// expired the session already. The client can't tell, so it assumes
+// they did: better a lock holder that stops early than two holders.
+func (c *Client) keepAlive() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.timeout / 3)
+	defer ticker.Stop()
+
+	lastOK := time.Now()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-c.expired:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithDeadline(context.Background(), lastOK.Add(c.timeout))
+		err := c.do(ctx, func(ctx context.Context, zk zkpb.ZooKeeperClient) error {
+			_, err := zk.KeepAlive(ctx, &zkpb.KeepAliveRequest{SessionId: c.session, Password: c.password})
+			return err
+		})
+		deadlinePassed := ctx.Err() != nil
+		cancel()
+
+		switch {
+		case err == nil:
+			lastOK = time.Now()
+		case deadlinePassed:
+			c.expire()
+		}
+	}
+}
+
+// expire marks the session gone.
+func (c *Client) expire() {
+	c.expireOnce.Do(func() { close(c.expired) })
+}
+
+// do runs one RPC against the cluster, retrying until it succeeds,
+// fails for a reason retrying can't fix, or ctx is done.
+//
+// The retry rules are zkcli's:
+//
+//	not leader, leader known   → switch to the leader, retry now
+//	not leader, leader unknown → election in progress, back off, next server
+//	Unavailable                → node down or no quorum, back off, next server
+//	DeadlineExceeded           → node hung, back off, next server
+//	session expired            → ErrSessionExpired, for this call and every later one
+//	anything else              → real answer (e.g. NotFound), stop
+//
+// A write whose answer was lost may be retried after it was applied.
+// For most writes the retry then fails (AlreadyExists, a bad version);
+// a sequential create makes a second node. The recipes deal with that.
+func (c *Client) do(ctx context.Context, call func(ctx context.Context, zk zkpb.ZooKeeperClient) error) error {
+	ctx = c.withAuth(ctx)
+	backoff := minBackoff
+	for {
+		select {
+		case <-c.expired:
+			return ErrSessionExpired
+		default:
+		}
+
+		addr, err := c.server()
+		if err != nil {
+			return err
+		}
+
+		err = c.attempt(ctx, addr, call)
+		if err == nil {
+			return nil
+		}
+		if server.IsSessionExpired(err) {
+			c.expire()
+			return ErrSessionExpired
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+
+		if _, leaderAddr, ok := server.LeaderFromError(err); ok && leaderAddr != "" && leaderAddr != addr {
+			c.useServer(leaderAddr)
+			continue
+		} else if !ok && !retryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+		c.moveOn(addr)
+	}
+}
+
+// withAuth adds the credentials AddAuth collected to ctx's calls.
+func (c *Client) withAuth(ctx context.Context) context.Context {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, creds := range c.auth {
+		ctx = server.WithDigest(ctx, creds)
+	}
+	return ctx
+}
+
+// attempt makes one RPC to one server with its own timeout.
+func (c *Client) attempt(ctx context.Context, addr string, call func(ctx context.Context, zk zkpb.ZooKeeperClient) error) error {
+	zk, err := c.zk(addr)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "%v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.attemptTimeout)
+	defer cancel()
+
+	return call(ctx, zk)
+}
+
+// server returns the server to talk to right now.
+func (c *Client) server() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return "", ErrClosed
+	}
+	return c.servers[c.current], nil
+}
+
+// zk returns a gRPC client for addr, connecting on first use.
+func (c *Client) zk(addr string) (zkpb.ZooKeeperClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conn, ok := c.conns[addr]
+	if !ok {
+		var err error
+		conn, err = grpc.NewClient(addr, grpc.WithTransportCredentials(c.creds))
+		if err != nil {
+			return nil, err
+		}
+		c.conns[addr] = conn
+	}
+	return zkpb.NewZooKeeperClient(conn), nil
+}
+
+// useServer makes addr the current server, adding it to the list if
+// it isn't there yet (a leader we learned about from a redirect).
+func (c *Client) useServer(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, s := range c.servers {
+		if s == addr {
+			c.current = i
+			return
+		}
+	}
+	c.servers = append(c.servers, addr)
+	c.current = len(c.servers) - 1
+}
+
+// moveOn switches to the next server after addr failed — unless another
+// call already switched away from it.
+func (c *Client) moveOn(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.servers[c.current] == addr {
+		c.current = (c.current + 1) % len(c.servers)
+	}
+}
+
+func (c *Client) closeConns() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for addr, conn := range c.conns {
+		conn.Close()
+		delete(c.conns, addr)
+	}
+}
+
+// retryable reports whether trying again (maybe elsewhere) can help.
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,375 @@
+package zkclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/syamsularifin/zookeeper/api/proto/raftpb"
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+	"github.com/syamsularifin/zookeeper/internal/cluster"
+	"github.com/syamsularifin/zookeeper/internal/server"
+	"github.com/syamsularifin/zookeeper/internal/store"
+)
+
+// testNode is one member of an in-process cluster: a real Store, a real
+// RaftNode over loopback gRPC, and the client API on clientAddr.
+type testNode struct {
+	clientAddr string
+	raft       *cluster.RaftNode
+
+	// stop shuts the node down, as if it crashed. Safe to call twice.
+	stop func()
+}
+
+// newTestCluster starts three nodes and waits until one of them leads.
+func newTestCluster(t *testing.T) []*testNode {
+	t.Helper()
+
+	var peers []cluster.Peer
+	for i := 1; i <= 3; i++ {
+		peers = append(peers, cluster.Peer{
+			ID:         cluster.NodeID(fmt.Sprintf("node-%d", i)),
+			Addr:       freeAddr(t),
+			ClientAddr: freeAddr(t),
+		})
+	}
+
+	var nodes []*testNode
+	for _, p := range peers {
+		dir := t.TempDir()
+		s, err := store.New(filepath.Join(dir, "wal.log"), filepath.Join(dir, "snapshot.json"))
+		if err != nil {
+			t.Fatalf("store.New failed: %v", err)
+		}
+
+		transport := cluster.NewGRPCTransport(0)
+		node, err := cluster.NewRaftNode(cluster.Config{Self: p.ID, Peers: peers, DataDir: dir}, transport, s)
+		if err != nil {
+			t.Fatalf("NewRaftNode failed: %v", err)
+		}
+
+		lis, err := net.Listen("tcp", p.Addr)
+		if err != nil {
+			t.Fatalf("failed to listen on %s: %v", p.Addr, err)
+		}
+		g := grpc.NewServer()
+		raftpb.RegisterRaftServer(g, cluster.NewRaftServer(node))
+		go g.Serve(lis)
+		node.Run()
+
+		srv := server.NewCluster(s, node, 0)
+		clientLis, err := net.Listen("tcp", p.ClientAddr)
+		if err != nil {
+			t.Fatalf("failed to listen on %s: %v", p.ClientAddr, err)
+		}
+		go srv.Serve(clientLis)
+
+		var once sync.Once
+		stop := func() {
+			once.Do(func() {
+				srv.Stop()
+				node.Stop()
+				g.Stop()
+				transport.Close()
+				s.Close()
+			})
+		}
+		t.Cleanup(stop)
+
+		nodes = append(nodes, &testNode{clientAddr: p.ClientAddr, raft: node, stop: stop})
+	}
+
+	waitForLeader(t, nodes)
+	return nodes
+}
+
+// freeAddr asks the OS for an unused loopback port.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find free port: %v", err)
+	}
+	defer lis.Close()
+	return lis.Addr().String()
+}
+
+// waitForLeader polls until one of nodes leads.
+func waitForLeader(t *testing.T, nodes []*testNode) *testNode {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, n := range nodes {
+			if n.raft.GetState().Role == cluster.Leader {
+				return n
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("no leader elected")
+	return nil
+}
+
+// addrs lists the nodes' client addresses.
+func addrs(nodes []*testNode) []string {
+	var out []string
+	for _, n := range nodes {
+		out = append(out, n.clientAddr)
+	}
+	return out
+}
+
+// connect opens a client to nodes with a short session, closed when the
+// test ends.
+func connect(t *testing.T, nodes []*testNode) *Client {
+	t.Helper()
+	return connectWith(t, nodes, time.Second)
+}
+
+func connectWith(t *testing.T, nodes []*testNode, sessionTimeout time.Duration) *Client {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := Connect(ctx, addrs(nodes), Options{SessionTimeout: sessionTimeout})
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// testContext bounds a test's calls, so a hung recipe fails the test
+// instead of hanging it.
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// eventually polls cond until it's true. Reads from a follower see a
+// write one heartbeat after the leader commits it.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("never happened: %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestClient_Operations(t *testing.T) {
+	c := connect(t, newTestCluster(t))
+	ctx := testContext(t)
+
+	if _, err := c.Create(ctx, "/app", []byte("v1"), 0); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := c.Set(ctx, "/app", []byte("v2"), 0); err != nil {
+		t.Fatalf("Set at version 0 failed: %v", err)
+	}
+	if err := c.Set(ctx, "/app", []byte("lost"), 0); status.Code(err) != codes.Aborted {
+		t.Fatalf("expected Aborted, got %v", err)
+	}
+
+	eventually(t, "/app is v2 at version 1", func() bool {
+		data, stat, err := c.Get(ctx, "/app")
+		return err == nil && string(data) == "v2" && stat.GetVersion() == 1
+	})
+
+	_, err := c.Multi(ctx,
+		&zkpb.Op{Op: &zkpb.Op_Create{Create: &zkpb.CreateRequest{Path: "/app/a"}}},
+		&zkpb.Op{Op: &zkpb.Op_Create{Create: &zkpb.CreateRequest{Path: "/app/b", Ephemeral: true}}},
+	)
+	if err != nil {
+		t.Fatalf("Multi failed: %v", err)
+	}
+	eventually(t, "/app has 2 children", func() bool {
+		children, err := c.Children(ctx, "/app")
+		return err == nil && len(children) == 2
+	})
+	if stat, _ := c.Exists(ctx, "/app/b"); stat.GetEphemeralOwner() != c.SessionID() {
+		t.Fatalf("expected /app/b owned by session %d, got %v", c.SessionID(), stat)
+	}
+
+	if stat, err := c.Exists(ctx, "/missing"); stat != nil || err != nil {
+		t.Fatalf("expected no /missing, got %v (%v)", stat, err)
+	}
+}
+
+// TestClient_ReadsSeeOwnWrites proves READ_INDEX reads, and LOCAL reads
+// after a Sync, see the client's own writes right away, whichever server
+// the client reads from.
+func TestClient_ReadsSeeOwnWrites(t *testing.T) {
+	nodes := newTestCluster(t)
+	ctx := testContext(t)
+
+	// Followers first: that's where reads can lag.
+	leader := waitForLeader(t, nodes)
+	var servers []string
+	for _, n := range nodes {
+		if n != leader {
+			servers = append(servers, n.clientAddr)
+		}
+	}
+	servers = append(servers, leader.clientAddr)
+
+	c, err := Connect(ctx, servers, Options{Consistency: zkpb.ReadConsistency_READ_INDEX})
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Close()
+	local, err := Connect(ctx, servers, Options{})
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer local.Close()
+
+	c.Create(ctx, "/app", nil, 0)
+	for i := 0; i < 5; i++ {
+		want := fmt.Sprintf("v%d", i)
+		if err := c.Set(ctx, "/app", []byte(want), AnyVersion); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		if data, _, err := c.Get(ctx, "/app"); err != nil || string(data) != want {
+			t.Fatalf("READ_INDEX read: expected %q, got %q (%v)", want, data, err)
+		}
+
+		if err := local.Sync(ctx); err != nil {
+			t.Fatalf("Sync failed: %v", err)
+		}
+		if data, _, err := local.Get(ctx, "/app"); err != nil || string(data) != want {
+			t.Fatalf("read after Sync: expected %q, got %q (%v)", want, data, err)
+		}
+	}
+}
+
+// TestClient_SurvivesLeaderFailover proves the session, and the client's
+// ephemeral nodes with it, live through the loss of the leader.
+func TestClient_SurvivesLeaderFailover(t *testing.T) {
+	nodes := newTestCluster(t)
+	// Long enough to ride out an election with a split vote or two.
+	c := connectWith(t, nodes, 3*time.Second)
+	ctx := testContext(t)
+
+	if _, err := c.Create(ctx, "/me", nil, Ephemeral); err != nil {
+		t.Fatalf("ephemeral Create failed: %v", err)
+	}
+
+	leader := waitForLeader(t, nodes)
+	leader.stop()
+	var rest []*testNode
+	for _, n := range nodes {
+		if n != leader {
+			rest = append(rest, n)
+		}
+	}
+	waitForLeader(t, rest)
+
+	// Longer than the session timeout: keepalives must have found the
+	// new leader.
+	time.Sleep(c.Timeout() + 500*time.Millisecond)
+
+	// The new leader commits the old leader's last entries with its
+	// first write (docs/07, issue 10), so write before reading.
+	if _, err := c.Create(ctx, "/after", nil, 0); err != nil {
+		t.Fatalf("Create after failover failed: %v", err)
+	}
+	eventually(t, "/me outlives the failover", func() bool {
+		stat, err := c.Exists(ctx, "/me")
+		return err == nil && stat != nil
+	})
+}
+
+// TestClient_CloseEndsSession proves Close deletes the client's
+// ephemeral nodes, and a closed client refuses calls.
+func TestClient_CloseEndsSession(t *testing.T) {
+	nodes := newTestCluster(t)
+	a, b := connect(t, nodes), connect(t, nodes)
+	ctx := testContext(t)
+
+	a.Create(ctx, "/me", nil, Ephemeral)
+	a.Close()
+
+	eventually(t, "/me is gone with its session", func() bool {
+		stat, err := b.Exists(ctx, "/me")
+		return err == nil && stat == nil
+	})
+	if _, err := a.Children(ctx, "/"); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestClient_Watch(t *testing.T) {
+	c := connect(t, newTestCluster(t))
+	ctx := testContext(t)
+
+	w, err := c.Watch(ctx, "/app", zkpb.WatchType_DATA, false)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	c.Create(ctx, "/app", nil, 0)
+
+	ev, ok := <-w.Events
+	if !ok || ev.Type != zkpb.EventType_NODE_CREATED {
+		t.Fatalf("expected NODE_CREATED, got %v", ev)
+	}
+	if _, ok := <-w.Events; ok || w.Err() != nil {
+		t.Fatalf("expected a one-shot watch to end cleanly, got %v", w.Err())
+	}
+}
+
+// TestClient_AddAuth proves digest credentials added once go with every
+// later call, whichever server answers it.
+func TestClient_AddAuth(t *testing.T) {
+	nodes := newTestCluster(t)
+	owner, other := connect(t, nodes), connect(t, nodes)
+	ctx := testContext(t)
+
+	alice, err := DigestACL("alice", "secret", "cdrwa")
+	if err != nil {
+		t.Fatalf("DigestACL failed: %v", err)
+	}
+	if _, err := owner.CreateWithACL(ctx, "/secret", []byte("s"), []*zkpb.ACL{alice}, 0); err != nil {
+		t.Fatalf("CreateWithACL failed: %v", err)
+	}
+
+	eventually(t, "other is denied /secret", func() bool {
+		_, _, err := other.Get(ctx, "/secret")
+		return status.Code(err) == codes.PermissionDenied
+	})
+
+	if _, err := other.AddAuth(ctx, "digest", "alice"); err == nil {
+		t.Fatal("expected AddAuth to fail without a password")
+	}
+	ids, err := other.AddAuth(ctx, "digest", "alice:secret")
+	if err != nil {
+		t.Fatalf("AddAuth failed: %v", err)
+	}
+	if len(ids) != 2 || ids[1] != "digest:"+alice.Id {
+		t.Fatalf("expected world:anyone and alice, got %v", ids)
+	}
+
+	if data, _, err := other.Get(ctx, "/secret"); err != nil || string(data) != "s" {
+		t.Fatalf("expected alice to read s, got %q (%v)", data, err)
+	}
+	open, _ := ParseACL("world:anyone:r")
+	if err := other.SetACL(ctx, "/secret", open, 0); err != nil {
+		t.Fatalf("SetACL failed: %v", err)
+	}
+	eventually(t, "/secret is world-readable at aversion 1", func() bool {
+		acls, stat, err := owner.GetACL(ctx, "/secret")
+		return err == nil && len(acls) == 1 && stat.GetAversion() == 1
+	})
+}
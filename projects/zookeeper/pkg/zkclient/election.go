@@ -0,0 +1,214 @@
+package zkclient
+
+// Election: pick one leader among many candidates, and a new one when
+// it goes away.
+//
+//	e := zkclient.NewElection(c, "/election/scheduler", "host-a", zkclient.ElectionCallbacks{
+//		Elected: func() { startScheduling() },
+//		Deposed: func() { stopScheduling() },
+//	})
+//	e.Campaign(ctx)   // blocks until host-a leads
+//
+// It's the Mutex recipe with a name on the node: the candidate with the
+// lowest number leads, the others wait in line. The node's data is the
+// candidate's id, so anyone can ask who leads (Leader) without taking
+// part.
+//
+// A leader is deposed when it resigns, when its session expires, or when
+// its node is deleted from under it (by an operator, say). Deposed is
+// called once, and from then on the candidate doesn't lead until it
+// campaigns again.
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+)
+
+// ElectionCallbacks are told about a candidate's leadership. Either
+// may be nil.
+type ElectionCallbacks struct {
+	// Elected is called when the candidate becomes leader, before
+	// Campaign returns.
+	Elected func()
+
+	// Deposed is called when the candidate stops being leader: from
+	// Resign, or from a background goroutine when the session expires
+	// or the node is deleted.
+	Deposed func()
+}
+
+// Election is one candidate in an election.
+type Election struct {
+	c   *Client
+	dir string
+	id  string
+	cb  ElectionCallbacks
+
+	mu   sync.Mutex
+	node string        // our node's name while we lead
+	term chan struct{} // closed to end the current term
+}
+
+// NewElection returns a candidate called id in the election at dir.
+func NewElection(c *Client, dir, id string, cb ElectionCallbacks) *Election {
+	return &Election{c: c, dir: dir, id: id, cb: cb}
+}
+
+// Campaign blocks until the candidate leads, ctx is done, or the
+// session expires.
+func (e *Election) Campaign(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.node != "" {
+		return errors.New("zkclient: already leading")
+	}
+
+	name, err := e.c.joinQueue(ctx, e.dir, "n-", []byte(e.id))
+	if err != nil {
+		return err
+	}
+	p := path.Join(e.dir, name)
+	if err := e.c.waitTurn(ctx, e.dir, name); err != nil {
+		e.c.leaveQueue(ctx, p)
+		return err
+	}
+
+	// Watch our own node from now on: it's deleted if we're expired.
+	w, err := e.c.Watch(context.Background(), p, zkpb.WatchType_DATA, true)
+	if err != nil {
+		e.c.leaveQueue(ctx, p)
+		return err
+	}
+
+	e.node = name
+	e.term = make(chan struct{})
+	if e.cb.Elected != nil {
+		e.cb.Elected()
+	}
+	go e.monitor(e.term, p, w)
+	return nil
+}
+
+// monitor deposes the leader if its node p goes away.
+func (e *Election) monitor(term chan struct{}, p string, w *Watcher) {
+	defer w.Cancel()
+	for {
+		select {
+		case <-term:
+			return // resigned
+		case <-e.c.expired:
+			e.depose(term)
+			return
+		case ev, ok := <-w.Events:
+			if ok && ev.Type != zkpb.EventType_NODE_DELETED {
+				continue
+			}
+			if !ok && w.Err() != nil {
+				// The server went away, not our node. Check, and watch
+				// again through another one.
+				if nw, err := e.rewatch(term, p); err == nil {
+					w.Cancel()
+					w = nw
+					continue
+				}
+			}
+			e.depose(term)
+			return
+		}
+	}
+}
+
+// rewatch watches our node p again, if it still exists.
+func (e *Election) rewatch(term chan struct{}, p string) (*Watcher, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-term:
+		case <-e.c.expired:
+		case <-ctx.Done():
+			return
+		}
+		cancel()
+	}()
+
+	w, err := e.c.Watch(ctx, p, zkpb.WatchType_DATA, true)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if stat, err := e.c.Exists(ctx, p); err != nil || stat == nil {
+		w.Cancel()
+		cancel()
+		return nil, errors.New("zkclient: leader node gone")
+	}
+	return w, nil
+}
+
+// depose ends term, if it's still the current one, and says so.
+func (e *Election) depose(term chan struct{}) {
+	e.mu.Lock()
+	if e.term != term || e.node == "" {
+		e.mu.Unlock()
+		return
+	}
+	e.node = ""
+	close(term)
+	e.mu.Unlock()
+
+	if e.cb.Deposed != nil {
+		e.cb.Deposed()
+	}
+}
+
+// Resign steps down, letting the next candidate lead.
+func (e *Election) Resign(ctx context.Context) error {
+	e.mu.Lock()
+	if e.node == "" {
+		e.mu.Unlock()
+		return errors.New("zkclient: not leading")
+	}
+	p, term := path.Join(e.dir, e.node), e.term
+	e.mu.Unlock()
+
+	e.depose(term)
+	return ignore(e.c.Delete(ctx, p, AnyVersion), codes.FailedPrecondition)
+}
+
+// Leader returns the id of the current leader, or "" if there's none.
+func (e *Election) Leader(ctx context.Context) (string, error) {
+	return Leader(ctx, e.c, e.dir)
+}
+
+// Leader returns the id of the leader of the election at dir, or "" if
+// there's none.
+func Leader(ctx context.Context, c *Client, dir string) (string, error) {
+	for {
+		children, err := c.Children(ctx, dir)
+		if status.Code(err) == codes.NotFound {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if len(children) == 0 {
+			return "", nil
+		}
+		sortBySequence(children)
+
+		data, _, err := c.Get(ctx, path.Join(dir, children[0]))
+		if status.Code(err) == codes.NotFound {
+			continue // it just stepped down: ask again
+		}
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+}
@@ -0,0 +1,84 @@
+package zkclient
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// candidate is one process in an election test: its Election, and the
+// callbacks it got.
+type candidate struct {
+	id      string
+	e       *Election
+	c       *Client
+	elected chan struct{}
+	deposed chan struct{}
+}
+
+func newCandidate(t *testing.T, nodes []*testNode, id string) *candidate {
+	c := connect(t, nodes)
+	cand := &candidate{id: id, c: c, elected: make(chan struct{}, 1), deposed: make(chan struct{}, 1)}
+	cand.e = NewElection(c, "/election", id, ElectionCallbacks{
+		Elected: func() { cand.elected <- struct{}{} },
+		Deposed: func() { cand.deposed <- struct{}{} },
+	})
+	return cand
+}
+
+// expect waits for a callback.
+func expect(t *testing.T, ch chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s never happened", what)
+	}
+}
+
+func TestElection_NextCandidateTakesOver(t *testing.T) {
+	nodes := newTestCluster(t)
+	ctx := testContext(t)
+
+	var cands []*candidate
+	for i := 0; i < 3; i++ {
+		cands = append(cands, newCandidate(t, nodes, fmt.Sprintf("host-%d", i)))
+	}
+
+	if err := cands[0].e.Campaign(ctx); err != nil {
+		t.Fatalf("Campaign failed: %v", err)
+	}
+	expect(t, cands[0].elected, "host-0 elected")
+	for _, cand := range cands[1:] {
+		go cand.e.Campaign(ctx)
+	}
+	eventually(t, "host-0 leads", func() bool {
+		leader, err := cands[2].e.Leader(ctx)
+		return err == nil && leader == "host-0"
+	})
+
+	// Resigning hands over to the next in line, whichever of the two
+	// queued up first.
+	if err := cands[0].e.Resign(ctx); err != nil {
+		t.Fatalf("Resign failed: %v", err)
+	}
+	expect(t, cands[0].deposed, "host-0 deposed")
+	next, last := cands[1], cands[2]
+	select {
+	case <-next.elected:
+	case <-last.elected:
+		next, last = last, next
+	case <-time.After(5 * time.Second):
+		t.Fatal("nobody took over from host-0")
+	}
+
+	// So does losing the session.
+	next.c.Close()
+	expect(t, next.deposed, "the second leader deposed")
+	expect(t, last.elected, "the third leader elected")
+
+	eventually(t, "the third candidate leads", func() bool {
+		leader, err := Leader(ctx, cands[0].c, "/election")
+		return err == nil && leader == last.id
+	})
+}
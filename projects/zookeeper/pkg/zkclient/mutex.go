@@ -0,0 +1,75 @@
+package zkclient
+
+// Mutex: a lock shared by every client of the cluster.
+//
+//	m := zkclient.NewMutex(c, "/locks/orders")
+//	if err := m.Lock(ctx); err != nil { ... }
+//	defer m.Unlock(ctx)
+//
+// Lock takes a number under the lock's directory and waits until it's
+// the lowest (see recipe.go). Waiters are served in the order they
+// asked. If the holder's session ends — Close, a crash, a partition —
+// its node is deleted and the next waiter gets the lock.
+//
+// The lock is only as good as the session: when c.Expired() closes, the
+// holder must assume someone else has the lock now.
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sync"
+)
+
+// Mutex is a distributed lock. Don't share one Mutex between goroutines
+// of the same process — give each its own (they then take turns like
+// any two clients would).
+type Mutex struct {
+	c   *Client
+	dir string
+
+	mu   sync.Mutex
+	node string // our node's name while we hold the lock
+}
+
+// NewMutex returns the lock at dir. Every client using the same dir
+// contends for the same lock.
+func NewMutex(c *Client, dir string) *Mutex {
+	return &Mutex{c: c, dir: dir}
+}
+
+// Lock blocks until the lock is held, ctx is done, or the session
+// expires.
+func (m *Mutex) Lock(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.node != "" {
+		return errors.New("zkclient: mutex already locked")
+	}
+
+	name, err := m.c.joinQueue(ctx, m.dir, "lock-", nil)
+	if err != nil {
+		return err
+	}
+	if err := m.c.waitTurn(ctx, m.dir, name); err != nil {
+		m.c.leaveQueue(ctx, path.Join(m.dir, name))
+		return err
+	}
+	m.node = name
+	return nil
+}
+
+// Unlock releases the lock.
+func (m *Mutex) Unlock(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.node == "" {
+		return errors.New("zkclient: mutex not locked")
+	}
+
+	if err := m.c.Delete(ctx, path.Join(m.dir, m.node), AnyVersion); err != nil {
+		return err
+	}
+	m.node = ""
+	return nil
+}
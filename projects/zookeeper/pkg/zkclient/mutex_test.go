@@ -0,0 +1,73 @@
+package zkclient
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestMutex_MutualExclusion proves clients on different servers never
+// hold the lock at the same time.
+func TestMutex_MutualExclusion(t *testing.T) {
+	nodes := newTestCluster(t)
+	ctx := testContext(t)
+
+	var holders, acquired atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		c := connect(t, nodes)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := NewMutex(c, "/locks/x")
+			for j := 0; j < 3; j++ {
+				if err := m.Lock(ctx); err != nil {
+					t.Errorf("Lock failed: %v", err)
+					return
+				}
+				if n := holders.Add(1); n != 1 {
+					t.Errorf("%d holders at once", n)
+				}
+				acquired.Add(1)
+				time.Sleep(10 * time.Millisecond)
+				holders.Add(-1)
+				if err := m.Unlock(ctx); err != nil {
+					t.Errorf("Unlock failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if acquired.Load() != 9 {
+		t.Fatalf("expected 9 acquisitions, got %d", acquired.Load())
+	}
+}
+
+// TestMutex_HolderGoneReleasesLock proves the lock passes on when the
+// holder's session ends without unlocking.
+func TestMutex_HolderGoneReleasesLock(t *testing.T) {
+	nodes := newTestCluster(t)
+	a, b := connect(t, nodes), connect(t, nodes)
+	ctx := testContext(t)
+
+	if err := NewMutex(a, "/locks/x").Lock(ctx); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+
+	locked := make(chan error, 1)
+	go func() { locked <- NewMutex(b, "/locks/x").Lock(ctx) }()
+
+	select {
+	case err := <-locked:
+		t.Fatalf("b got the lock while a held it (%v)", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	a.Close()
+	if err := <-locked; err != nil {
+		t.Fatalf("b's Lock failed: %v", err)
+	}
+}
@@ -0,0 +1,233 @@
+package zkclient
+
+// Znode operations. Errors are the server's gRPC statuses, unchanged
+// (status.Code(err) == codes.NotFound and so on, see docs/05), except
+// ErrSessionExpired and ErrClosed.
+//
+// Writes go to the leader (followers forward them). Reads are answered
+// by whichever server the client is using, from what it has applied: a
+// read right after a write may not see it yet on a follower — unless
+// Options.Consistency asks for more, or Sync came first.
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+	"github.com/syamsularifin/zookeeper/internal/acl"
+)
+
+// AnyVersion makes Set and Delete skip the version check.
+const AnyVersion int32 = -1
+
+// CreateFlag changes what Create makes.
+type CreateFlag int
+
+const (
+	// Ephemeral nodes belong to the client's session and are deleted
+	// when it ends.
+	Ephemeral CreateFlag = 1 << iota
+
+	// Sequential appends the parent's next sequence number to the name.
+	Sequential
+)
+
+// Create makes a node open to everyone and returns its path — with the
+// sequence number, for a Sequential node.
+func (c *Client) Create(ctx context.Context, p string, data []byte, flags CreateFlag) (string, error) {
+	return c.CreateWithACL(ctx, p, data, nil, flags)
+}
+
+// CreateWithACL is Create with the new node's ACL. nil is open to
+// everyone (world:anyone:cdrwa).
+func (c *Client) CreateWithACL(ctx context.Context, p string, data []byte, acls []*zkpb.ACL, flags CreateFlag) (string, error) {
+	req := &zkpb.CreateRequest{Path: p, Data: data, Acl: acls, Sequential: flags&Sequential != 0}
+	if flags&Ephemeral != 0 {
+		req.Ephemeral = true
+		req.SessionId = c.session
+		req.SessionPassword = c.password
+	}
+
+	var resp *zkpb.CreateResponse
+	err := c.do(ctx, func(ctx context.Context, zk zkpb.ZooKeeperClient) (err error) {
+		resp, err = zk.Create(ctx, req)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.Path, nil
+}
+
+// Get reads a node's data and Stat.
+func (c *Client) Get(ctx context.Context, p string) ([]byte, *zkpb.Stat, error) {
+	var resp *zkpb.GetResponse
+	err := c.do(ctx, func(ctx context.Context, zk zkpb.ZooKeeperClient) (err error) {
+		resp, err = zk.Get(ctx, &zkpb.GetRequest{Path: p, Consistency: c.consistency})
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Data, resp.Stat, nil
+}
+
+// Exists returns a node's Stat, or nil if there's no such node.
+func (c *Client) Exists(ctx context.Context, p string) (*zkpb.Stat, error) {
+	_, stat, err := c.Get(ctx, p)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	return stat, err
+}
+
+// Set replaces a node's data if it's at version (or at any version,
+// with AnyVersion).
+func (c *Client) Set(ctx context.Context, p string, data []byte, version int32) error {
+	req := &zkpb.SetRequest{Path: p, Data: data, Version: versionOf(version)}
+	return c.do(ctx, func(ctx context.Context, zk zkpb.ZooKeeperClient) error {
+		_, err := zk.Set(ctx, req)
+		return err
+	})
+}
+
+// Delete removes a node without children if it's at version (or at
+// any version, with AnyVersion).
+func (c *Client) Delete(ctx context.Context, p string, version int32) error {
+	req := &zkpb.DeleteRequest{Path: p, Version: versionOf(version)}
+	return c.do(ctx, func(ctx context.Context, zk zkpb.ZooKeeperClient) error {
+		_, err := zk.Delete(ctx, req)
+		return err
+	})
+}
+
+// Children lists the names of a node's children, in no particular order.
+func (c *Client) Children(ctx context.Context, p string) ([]string, error) {
+	var resp *zkpb.GetChildrenResponse
+	err := c.do(ctx, func(ctx context.Context, zk zkpb.ZooKeeperClient) (err error) {
+		resp, err = zk.GetChildren(ctx, &zkpb.GetChildrenRequest{Path: p, Consistency: c.consistency})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Children, nil
+}
+
+// Multi applies ops all together or not at all. Ephemeral creates
+// without a session get the client's, and its password.
+// server.FailedOp(err) says which op failed.
+func (c *Client) Multi(ctx context.Context, ops ...*zkpb.Op) ([]*zkpb.OpResult, error) {
+	for _, op := range ops {
+		if create := op.GetCreate(); create != nil && create.Ephemeral && create.SessionId == 0 {
+			create.SessionId = c.session
+			create.SessionPassword = c.password
+		}
+	}
+
+	var resp *zkpb.MultiResponse
+	err := c.do(ctx, func(ctx context.Context, zk zkpb.ZooKeeperClient) (err error) {
+		resp, err = zk.Multi(ctx, &zkpb.MultiRequest{Ops: ops})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Results, nil
+}
+
+// GetACL reads a node's ACL, and its Stat (Aversion is the ACL's
+// version). Needs READ or ADMIN on the node.
+func (c *Client) GetACL(ctx context.Context, p string) ([]*zkpb.ACL, *zkpb.Stat, error) {
+	var resp *zkpb.GetACLResponse
+	err := c.do(ctx, func(ctx context.Context, zk zkpb.ZooKeeperClient) (err error) {
+		resp, err = zk.GetACL(ctx, &zkpb.GetACLRequest{Path: p, Consistency: c.consistency})
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Acl, resp.Stat, nil
+}
+
+// SetACL replaces a node's ACL if it's at aversion (or at any, with
+// AnyVersion). Needs ADMIN on the node.
+func (c *Client) SetACL(ctx context.Context, p string, acls []*zkpb.ACL, aversion int32) error {
+	req := &zkpb.SetACLRequest{Path: p, Acl: acls, Version: versionOf(aversion)}
+	return c.do(ctx, func(ctx context.Context, zk zkpb.ZooKeeperClient) error {
+		_, err := zk.SetACL(ctx, req)
+		return err
+	})
+}
+
+// ParseACL reads an ACL in its text form, comma-separated entries of
+// scheme:id:perms — "world:anyone:r,digest:alice:aYXl...=:cdrwa".
+func ParseACL(s string) ([]*zkpb.ACL, error) {
+	acls, err := acl.ParseList(s)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*zkpb.ACL, 0, len(acls))
+	for _, a := range acls {
+		out = append(out, &zkpb.ACL{Scheme: a.Scheme, Id: a.ID.ID, Perms: int32(a.Perms)})
+	}
+	return out, nil
+}
+
+// DigestACL is the ACL id for user with password, as ZooKeeper's digest
+// scheme computes it — what to grant permissions to.
+func DigestACL(user, password, perms string) (*zkpb.ACL, error) {
+	p, err := acl.ParsePerms(perms)
+	if err != nil {
+		return nil, err
+	}
+	id := acl.Digest(user, password)
+	return &zkpb.ACL{Scheme: id.Scheme, Id: id.ID, Perms: int32(p)}, nil
+}
+
+// Sync catches the server the client is using up with the leader:
+// reads after it see every write committed before it. The client stays
+// on that server unless it fails.
+func (c *Client) Sync(ctx context.Context) error {
+	return c.do(ctx, func(ctx context.Context, zk zkpb.ZooKeeperClient) error {
+		_, err := zk.Sync(ctx, &zkpb.SyncRequest{})
+		return err
+	})
+}
+
+// ensurePath creates p and any missing parents, as empty nodes. If it
+// created any, it Syncs: the caller's next read may go to a follower
+// that hasn't applied them yet.
+func (c *Client) ensurePath(ctx context.Context, p string) error {
+	p = path.Clean(p)
+	if p == "/" {
+		return nil
+	}
+
+	// The common case: everything is there already.
+	if stat, err := c.Exists(ctx, p); err != nil || stat != nil {
+		return err
+	}
+
+	at := ""
+	for _, name := range strings.Split(strings.TrimPrefix(p, "/"), "/") {
+		at += "/" + name
+		_, err := c.Create(ctx, at, nil, 0)
+		if err != nil && status.Code(err) != codes.AlreadyExists {
+			return err
+		}
+	}
+	return c.Sync(ctx)
+}
+
+// versionOf converts a version to the optional field of a request.
+func versionOf(version int32) *int32 {
+	if version == AnyVersion {
+		return nil
+	}
+	return &version
+}
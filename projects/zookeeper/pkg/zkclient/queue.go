@@ -0,0 +1,110 @@
+package zkclient
+
+// Queue: a FIFO queue shared by producers and consumers on any client.
+//
+//	q := zkclient.NewQueue(c, "/queues/jobs")
+//	q.Put(ctx, []byte("job 1"))          // producer
+//	data, err := q.Take(ctx)             // consumer: blocks until there's one
+//
+// Each item is a persistent sequential node; the sequence number is its
+// place in the queue:
+//
+//	/queues/jobs
+//	├── item-0000000012   ← next to be taken
+//	└── item-0000000013
+//
+// Take reads the first item and deletes it. Two consumers may read the
+// same one; only one of the deletes succeeds, and the other consumer
+// moves on to the next item. So every item is taken by exactly one
+// consumer.
+//
+// Unless an answer is lost on its way back: the client retries, and the
+// retry can't tell its own earlier attempt from another client's. A
+// retried Put may queue its item twice; a retried delete in Take looks
+// like someone else took the item, and Take moves on without it.
+
+import (
+	"context"
+	"path"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+)
+
+// Queue is a distributed queue.
+type Queue struct {
+	c   *Client
+	dir string
+}
+
+// NewQueue returns the queue at dir.
+func NewQueue(c *Client, dir string) *Queue {
+	return &Queue{c: c, dir: dir}
+}
+
+// Put adds data to the end of the queue.
+func (q *Queue) Put(ctx context.Context, data []byte) error {
+	if err := q.c.ensurePath(ctx, q.dir); err != nil {
+		return err
+	}
+	_, err := q.c.Create(ctx, path.Join(q.dir, "item-"), data, Sequential)
+	return err
+}
+
+// Take removes the first item and returns its data. If the queue is
+// empty, it waits for a Put.
+func (q *Queue) Take(ctx context.Context) ([]byte, error) {
+	if err := q.c.ensurePath(ctx, q.dir); err != nil {
+		return nil, err
+	}
+
+	for {
+		// Watch, then list: a Put after the list wakes us up.
+		w, err := q.c.Watch(ctx, q.dir, zkpb.WatchType_CHILDREN, false)
+		if err != nil {
+			return nil, err
+		}
+
+		data, ok, err := q.takeFirst(ctx)
+		if err == nil && !ok {
+			err = q.c.wait(ctx, w)
+		}
+		w.Cancel()
+		if err != nil || ok {
+			return data, err
+		}
+	}
+}
+
+// takeFirst takes the first item another consumer doesn't take first.
+// ok is false if there was none left.
+func (q *Queue) takeFirst(ctx context.Context) (data []byte, ok bool, err error) {
+	children, err := q.c.Children(ctx, q.dir)
+	if err != nil {
+		return nil, false, err
+	}
+	sortBySequence(children)
+
+	for _, child := range children {
+		p := path.Join(q.dir, child)
+		data, _, err := q.c.Get(ctx, p)
+		if status.Code(err) == codes.NotFound {
+			continue // taken already
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		err = q.c.Delete(ctx, p, AnyVersion)
+		if status.Code(err) == codes.FailedPrecondition {
+			continue // someone else deleted it first
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		return data, true, nil
+	}
+	return nil, false, nil
+}
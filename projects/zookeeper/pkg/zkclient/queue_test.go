@@ -0,0 +1,72 @@
+package zkclient
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueue_FIFO(t *testing.T) {
+	c := connect(t, newTestCluster(t))
+	ctx := testContext(t)
+	q := NewQueue(c, "/queue")
+
+	for i := 0; i < 3; i++ {
+		if err := q.Put(ctx, []byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		data, err := q.Take(ctx)
+		if err != nil || string(data) != fmt.Sprint(i) {
+			t.Fatalf("expected item %d, got %q (%v)", i, data, err)
+		}
+	}
+}
+
+// TestQueue_EveryItemTakenOnce proves competing consumers on different
+// servers each get different items, and a Take on an empty queue waits
+// for a Put.
+func TestQueue_EveryItemTakenOnce(t *testing.T) {
+	nodes := newTestCluster(t)
+	ctx := testContext(t)
+	const items = 12
+
+	var mu sync.Mutex
+	taken := make(map[string]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		q := NewQueue(connect(t, nodes), "/queue")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < items/3; j++ {
+				data, err := q.Take(ctx)
+				if err != nil {
+					t.Errorf("Take failed: %v", err)
+					return
+				}
+				mu.Lock()
+				taken[string(data)]++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// The consumers are waiting by now.
+	time.Sleep(100 * time.Millisecond)
+	producer := NewQueue(connect(t, nodes), "/queue")
+	for i := 0; i < items; i++ {
+		if err := producer.Put(ctx, []byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	wg.Wait()
+
+	for i := 0; i < items; i++ {
+		if n := taken[fmt.Sprint(i)]; n != 1 {
+			t.Fatalf("item %d taken %d times", i, n)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package zkclient
+
+// What Mutex and Election have in common: take a number, wait your turn.
+//
+//	/lock
+//	├── 1f3a…-lock-0000000004   ← holder (lowest number)
+//	├── 9c2e…-lock-0000000005   watches …04
+//	└── 07bd…-lock-0000000007   watches …05
+//
+// Each waiter creates an ephemeral sequential node and watches only the
+// node right before its own. When the holder finishes (or its session
+// dies), exactly one waiter wakes up — not all of them, as they would if
+// everyone watched the directory.
+//
+// The random token in front of the name makes the create safe to retry.
+// If the answer to a create is lost, the client retries it, and the
+// first attempt may have made a node too. A node with our token that
+// isn't the one we got back is such a leftover; we delete it, or it
+// would hold its place in line — ahead of us — until the session ends.
+//
+// Reads are served by whichever server the client is using, and a
+// follower may not have applied our create yet when we list the
+// directory. Then we wait for the directory to change, as we would for
+// the node before ours.
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"path"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+)
+
+// sequenceDigits is how long the server's sequence suffix is.
+const sequenceDigits = 10
+
+// joinQueue creates this client's place in line under dir: an ephemeral
+// sequential node named <token>-<prefix><number>. It returns the node's
+// name (not its path).
+func (c *Client) joinQueue(ctx context.Context, dir, prefix string, data []byte) (string, error) {
+	if err := c.ensurePath(ctx, dir); err != nil {
+		return "", err
+	}
+	created, err := c.Create(ctx, path.Join(dir, newToken()+"-"+prefix), data, Ephemeral|Sequential)
+	if err != nil {
+		return "", err
+	}
+	return path.Base(created), nil
+}
+
+// waitTurn blocks until name is first in line under dir.
+func (c *Client) waitTurn(ctx context.Context, dir, name string) error {
+	token := name[:strings.Index(name, "-")+1]
+	for {
+		children, err := c.Children(ctx, dir)
+		if err != nil {
+			return err
+		}
+		var line []string
+		for _, child := range children {
+			if child != name && strings.HasPrefix(child, token) {
+				c.Delete(ctx, path.Join(dir, child), AnyVersion) // a leftover
+				continue
+			}
+			line = append(line, child)
+		}
+		sortBySequence(line)
+
+		i := slices.Index(line, name)
+		if i == 0 {
+			return nil
+		}
+
+		// Watch, then check: if what we wait for happened between
+		// Children and now, there'd be no event for it.
+		var (
+			w        *Watcher
+			happened func() (bool, error)
+		)
+		if i < 0 {
+			w, err = c.Watch(ctx, dir, zkpb.WatchType_CHILDREN, false)
+			happened = func() (bool, error) {
+				children, err := c.Children(ctx, dir)
+				return slices.Contains(children, name), err
+			}
+		} else {
+			before := path.Join(dir, line[i-1])
+			w, err = c.Watch(ctx, before, zkpb.WatchType_DATA, false)
+			happened = func() (bool, error) {
+				stat, err := c.Exists(ctx, before)
+				return stat == nil, err
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		done, err := happened()
+		if err == nil && !done {
+			err = c.wait(ctx, w)
+		}
+		w.Cancel()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// leaveQueue deletes our node after waiting failed — even if ctx is
+// what ended the wait. If that fails too, the node goes with the
+// session, so it's only tried for a session timeout.
+func (c *Client) leaveQueue(ctx context.Context, p string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.timeout)
+	defer cancel()
+	c.Delete(ctx, p, AnyVersion)
+}
+
+// wait blocks until w has an event (or ends), ctx is done, or the
+// session expires.
+func (c *Client) wait(ctx context.Context, w *Watcher) error {
+	select {
+	case <-w.Events:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.expired:
+		return ErrSessionExpired
+	}
+}
+
+// sortBySequence sorts sequential node names by their sequence number,
+// whatever comes before it.
+func sortBySequence(names []string) {
+	slices.SortFunc(names, func(a, b string) int {
+		return strings.Compare(sequenceOf(a), sequenceOf(b))
+	})
+}
+
+// sequenceOf is the sequence suffix of a name. Fixed width, so the
+// strings compare like the numbers.
+func sequenceOf(name string) string {
+	if len(name) < sequenceDigits {
+		return name
+	}
+	return name[len(name)-sequenceDigits:]
+}
+
+// newToken returns a random name prefix, unique to one create.
+func newToken() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ignore returns nil if err's code is one of want.
+func ignore(err error, want ...codes.Code) error {
+	if slices.Contains(want, status.Code(err)) {
+		return nil
+	}
+	return err
+}
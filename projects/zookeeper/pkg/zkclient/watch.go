@@ -0,0 +1,116 @@
+package zkclient
+
+// Watches.
+//
+// Watch returns once the server has registered the watch. Every change
+// applied after that arrives on Events, so the safe pattern is "watch,
+// then read":
+//
+//	w, _ := c.Watch(ctx, "/app", zkpb.WatchType_DATA, false)
+//	data, _, _ := c.Get(ctx, "/app")   // can't miss a change after this
+//	<-w.Events                         // the next change
+//
+// Read the other way round, a change landing between the read and the
+// watch is never seen.
+//
+// A watch lives on the server the client was using when it was made.
+// If that server goes away, Events closes and Err says why; watch
+// again, and read again, to pick up what happened in between.
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/syamsularifin/zookeeper/api/proto/zkpb"
+)
+
+// Watcher is a registered watch.
+type Watcher struct {
+	// Events delivers the changes, in order. It closes after a one-shot
+	// watch's event, on Cancel, or when the stream breaks.
+	Events <-chan *zkpb.WatchEvent
+
+	// Zxid is where the watch starts: every change after it is delivered.
+	Zxid int64
+
+	cancel context.CancelFunc
+
+	mu  sync.Mutex
+	err error
+}
+
+// Watch registers a watch on p and returns once it's in place. The
+// watch ends when ctx is done.
+func (c *Client) Watch(ctx context.Context, p string, typ zkpb.WatchType, persistent bool) (*Watcher, error) {
+	req := &zkpb.WatchRequest{Path: p, Type: typ, Persistent: persistent}
+
+	var (
+		stream       zkpb.ZooKeeper_WatchClient
+		registered   *zkpb.WatchEvent
+		cancelStream context.CancelFunc
+	)
+	err := c.do(ctx, func(attemptCtx context.Context, zk zkpb.ZooKeeperClient) error {
+		// The stream outlives this attempt; only waiting for REGISTERED
+		// is bounded by it.
+		streamCtx, cancel := context.WithCancel(ctx)
+		stop := context.AfterFunc(attemptCtx, cancel)
+		defer stop()
+
+		s, err := zk.Watch(streamCtx, req)
+		if err == nil {
+			registered, err = s.Recv()
+		}
+		if err != nil || !stop() {
+			cancel()
+			if err == nil {
+				err = attemptCtx.Err()
+			}
+			return err
+		}
+		stream, cancelStream = s, cancel
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan *zkpb.WatchEvent)
+	w := &Watcher{Events: events, Zxid: registered.Zxid, cancel: cancelStream}
+	go w.run(stream, events)
+	return w, nil
+}
+
+// run passes the stream's events on until it ends.
+func (w *Watcher) run(stream zkpb.ZooKeeper_WatchClient, events chan<- *zkpb.WatchEvent) {
+	defer close(events)
+	defer w.cancel()
+
+	for {
+		ev, err := stream.Recv()
+		if err != nil {
+			if err != io.EOF && stream.Context().Err() == nil {
+				w.mu.Lock()
+				w.err = err
+				w.mu.Unlock()
+			}
+			return
+		}
+		select {
+		case events <- ev:
+		case <-stream.Context().Done():
+			return
+		}
+	}
+}
+
+// Cancel removes the watch. Events closes soon after.
+func (w *Watcher) Cancel() { w.cancel() }
+
+// Err says why Events closed: nil if the watch ended normally (a
+// one-shot fired, or Cancel), the stream's error otherwise.
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}